@@ -3,19 +3,50 @@ package btree
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 const headerSize = 4
 
+// kvOverhead is the space an entry takes in a node besides its key and
+// value: its pointer, its offset and the lengths of both.
+const kvOverhead = 8 + 2 + 4
+
+const PageSize = 4096
+
+// MaxKeySize and MaxValSize are the default limits of a tree. A tree may
+// be given others (see BTree.MaxKey and CheckLimits), trading key room for
+// value room.
 const (
-	PageSize   = 4096
 	MaxKeySize = 1000
 	MaxValSize = 3000
 )
 
-func init() {
-	node1max := headerSize + 8 + 2 + 4 + MaxKeySize + MaxValSize
-	assert(node1max <= PageSize)
+// maxKeyLimit is the largest key limit: an internal node holds at least
+// four keys, so a split never needs more than three nodes. maxEntrySize
+// bounds the key and value sizes of an entry together, so that a leaf
+// holds it.
+const (
+	maxKeyLimit  = (PageSize-headerSize)/4 - kvOverhead
+	maxEntrySize = PageSize - headerSize - kvOverhead
+)
+
+// minKeyLimit is the smallest key limit CheckLimits accepts, room for the
+// prefixes and names the table layer keys its internal data with.
+const minKeyLimit = 64
+
+// CheckLimits verifies that a tree can hold keys of up to maxKey bytes and
+// values of up to maxVal bytes: that every node of them fits a page and
+// splits in at most three.
+func CheckLimits(maxKey, maxVal int) error {
+	switch {
+	case maxKey < minKeyLimit || maxKey > maxKeyLimit:
+		return fmt.Errorf("btree: key limit %d outside [%d, %d]", maxKey, minKeyLimit, maxKeyLimit)
+	case maxVal < 0 || maxKey+maxVal > maxEntrySize:
+		return fmt.Errorf("btree: value limit %d outside [0, %d] for keys of %d bytes",
+			maxVal, maxEntrySize-maxKey, maxKey)
+	}
+	return nil
 }
 
 const (
@@ -105,23 +136,35 @@ func (node BNode) nbytes() uint16 {
 
 // --- lookup ---
 
-// nodeLookupLE returns the last index i where node.getKey(i) <= key.
-func nodeLookupLE(node BNode, key []byte) uint16 {
-	nkeys := node.nkeys()
-	lo, hi := uint16(1), nkeys-1
+// nodeLookup returns the number of keys of node that are <= key.
+func nodeLookup(node BNode, key []byte) uint16 {
+	lo, hi := uint16(0), node.nkeys()
 	for lo < hi {
-		mid := lo + (hi-lo+1)/2
-		cmp := bytes.Compare(node.getKey(mid), key)
-		if cmp <= 0 {
-			lo = mid
+		mid := lo + (hi-lo)/2
+		if bytes.Compare(node.getKey(mid), key) <= 0 {
+			lo = mid + 1
 		} else {
-			hi = mid - 1
+			hi = mid
 		}
 	}
-	if lo < nkeys && bytes.Compare(node.getKey(lo), key) <= 0 {
-		return lo
+	return lo
+}
+
+// nodeLookupLE returns the last index i where node.getKey(i) <= key, or 0
+// if every key is greater: in an internal node, the child that holds key.
+func nodeLookupLE(node BNode, key []byte) uint16 {
+	return max(nodeLookup(node, key), 1) - 1
+}
+
+// leafLookup returns the position of key in leaf and true, or, if key is
+// not there, the position it would be inserted at and false. Either way
+// the position is the number of keys less than key.
+func leafLookup(leaf BNode, key []byte) (uint16, bool) {
+	n := nodeLookup(leaf, key)
+	if n > 0 && bytes.Equal(leaf.getKey(n-1), key) {
+		return n - 1, true
 	}
-	return 0
+	return n, false
 }
 
 // --- mutation helpers ---
@@ -14,6 +14,21 @@ import "bytes"
 type BTree struct {
 	Root  uint64    // page number of the root node (0 = empty tree)
 	Store PageStore // injected by kv when a transaction begins
+	// MaxKey and MaxVal bound the sizes of the keys and values inserted;
+	// 0 means MaxKeySize and MaxValSize. They must pass CheckLimits.
+	MaxKey, MaxVal int
+}
+
+// Limits returns the largest key and value sizes the tree accepts.
+func (tree *BTree) Limits() (maxKey, maxVal int) {
+	maxKey, maxVal = tree.MaxKey, tree.MaxVal
+	if maxKey == 0 {
+		maxKey = MaxKeySize
+	}
+	if maxVal == 0 {
+		maxVal = MaxValSize
+	}
+	return maxKey, maxVal
 }
 
 // --- internal node helpers ---
@@ -65,37 +80,33 @@ type InsertReq struct {
 	// outputs
 	Added   bool   // true if a new key was created
 	Updated bool   // true if the tree was modified (insert or value change)
-	Old     []byte // previous value, set when an existing key was replaced
+	Old     []byte // value of the key before the insert, nil if it did not exist
 }
 
 func treeInsert(tree *BTree, req *InsertReq, node BNode) BNode {
 	new := BNode{Data: make([]byte, 2*PageSize)}
 
-	idx := nodeLookupLE(node, req.Key)
 	switch node.btype() {
 	case BNodeLeaf:
-		if bytes.Equal(req.Key, node.getKey(idx)) {
-			if req.Mode == ModeInsertOnly {
-				return BNode{}
-			}
-			old := node.getVal(idx)
-			if bytes.Equal(req.Val, old) {
+		idx, found := leafLookup(node, req.Key)
+		if found {
+			req.Old = node.getVal(idx)
+			if req.Mode == ModeInsertOnly || bytes.Equal(req.Val, req.Old) {
 				return BNode{}
 			}
 			leafUpdate(new, node, idx, req.Key, req.Val)
 			req.Updated = true
-			req.Old = old
 		} else {
 			if req.Mode == ModeUpdateOnly {
 				return BNode{}
 			}
-			leafInsert(new, node, idx+1, req.Key, req.Val)
+			leafInsert(new, node, idx, req.Key, req.Val)
 			req.Updated = true
 			req.Added = true
 		}
 		return new
 	case BNodeInternal:
-		return nodeInsert(tree, req, new, node, idx)
+		return nodeInsert(tree, req, new, node, nodeLookupLE(node, req.Key))
 	default:
 		panic("bad node!")
 	}
@@ -120,19 +131,26 @@ func (tree *BTree) Insert(key []byte, val []byte) bool {
 	return req.Added
 }
 
-// InsertEx is the full insert path, writing results into req.
+// InsertEx is the full insert path, writing results into req. The sizes of
+// the key and the value must pass CheckSize.
 func (tree *BTree) InsertEx(req *InsertReq) {
 	assert(len(req.Key) != 0)
-	assert(len(req.Key) <= MaxKeySize)
-	assert(len(req.Val) <= MaxValSize)
+	assert(tree.CheckSize(len(req.Key), len(req.Val)) == nil)
+	if tree.isLong(req.Key) {
+		tree.longInsert(req)
+		return
+	}
+	tree.insert(req)
+}
 
+// insert inserts req.Key as stored.
+func (tree *BTree) insert(req *InsertReq) {
 	if tree.Root == 0 {
 		root := BNode{Data: make([]byte, PageSize)}
-		root.setHeader(BNodeLeaf, 2)
-		nodeAppendKV(root, 0, 0, nil, nil) // dummy sentinel key
-		nodeAppendKV(root, 1, 0, req.Key, req.Val)
+		root.setHeader(BNodeLeaf, 1)
+		nodeAppendKV(root, 0, 0, req.Key, req.Val)
 		tree.Root = tree.Store.PageNew(root)
-		req.Added = true
+		req.Added, req.Updated = true, true
 		return
 	}
 
@@ -167,10 +185,10 @@ type DeleteReq struct {
 }
 
 func treeDelete(tree *BTree, req *DeleteReq, node BNode) BNode {
-	idx := nodeLookupLE(node, req.Key)
 	switch node.btype() {
 	case BNodeLeaf:
-		if !bytes.Equal(req.Key, node.getKey(idx)) {
+		idx, found := leafLookup(node, req.Key)
+		if !found {
 			return BNode{}
 		}
 		req.Old = node.getVal(idx)
@@ -178,7 +196,7 @@ func treeDelete(tree *BTree, req *DeleteReq, node BNode) BNode {
 		leafDelete(new, node, idx)
 		return new
 	case BNodeInternal:
-		return nodeDelete(tree, req, node, idx)
+		return nodeDelete(tree, req, node, nodeLookupLE(node, req.Key))
 	default:
 		panic("bad node!")
 	}
@@ -205,8 +223,14 @@ func nodeDelete(tree *BTree, req *DeleteReq, node BNode, idx uint16) BNode {
 		nodeMerge(merged, updated, sibling)
 		tree.Store.PageDel(node.getPtr(idx + 1))
 		nodeReplace2Kid(new, node, idx, tree.Store.PageNew(merged), merged.getKey(0))
-	case mergeDir == 0:
-		assert(updated.nkeys() > 0)
+	case updated.nkeys() == 0:
+		// An empty child merges into any sibling, so this is the only
+		// child, left when merges of its own siblings did not fit (large
+		// keys make full nodes of a few). Node empties too, and its parent
+		// merges it away, or, at the root, the tree is empty.
+		assert(node.nkeys() == 1)
+		new.setHeader(BNodeInternal, 0)
+	default:
 		nodeReplaceKidN(tree, new, node, idx, updated)
 	}
 	return new
@@ -239,7 +263,20 @@ func (tree *BTree) Delete(key []byte) bool {
 // DeleteEx is the full delete path, writing the old value into req.
 func (tree *BTree) DeleteEx(req *DeleteReq) bool {
 	assert(len(req.Key) != 0)
-	assert(len(req.Key) <= MaxKeySize)
+	if tree.isLong(req.Key) {
+		return tree.longDelete(req)
+	}
+	return tree.delete(req)
+}
+
+// DropSentinel deletes the empty key, which trees written by older versions
+// hold as their first key, and reports whether there was one.
+func (tree *BTree) DropSentinel() bool {
+	return tree.Root != 0 && tree.delete(&DeleteReq{Key: []byte{}})
+}
+
+// delete deletes req.Key as stored.
+func (tree *BTree) delete(req *DeleteReq) bool {
 	if tree.Root == 0 {
 		return false
 	}
@@ -250,9 +287,12 @@ func (tree *BTree) DeleteEx(req *DeleteReq) bool {
 	}
 
 	tree.Store.PageDel(tree.Root)
-	if updated.btype() == BNodeInternal && updated.nkeys() == 1 {
+	switch {
+	case updated.nkeys() == 0:
+		tree.Root = 0 // the last key is gone
+	case updated.btype() == BNodeInternal && updated.nkeys() == 1:
 		tree.Root = updated.getPtr(0) // collapse one level
-	} else {
+	default:
 		tree.Root = tree.Store.PageNew(updated)
 	}
 	return true
@@ -261,15 +301,14 @@ func (tree *BTree) DeleteEx(req *DeleteReq) bool {
 // --- get ---
 
 func nodeGetKey(tree *BTree, node BNode, key []byte) ([]byte, bool) {
-	idx := nodeLookupLE(node, key)
 	switch node.btype() {
 	case BNodeLeaf:
-		if bytes.Equal(key, node.getKey(idx)) {
+		if idx, found := leafLookup(node, key); found {
 			return node.getVal(idx), true
 		}
 		return nil, false
 	case BNodeInternal:
-		return nodeGetKey(tree, tree.Store.PageGet(node.getPtr(idx)), key)
+		return nodeGetKey(tree, tree.Store.PageGet(node.getPtr(nodeLookupLE(node, key))), key)
 	default:
 		panic("bad node!")
 	}
@@ -280,5 +319,108 @@ func (tree *BTree) Get(key []byte) ([]byte, bool) {
 	if tree.Root == 0 {
 		return nil, false
 	}
+	if tree.isLong(key) {
+		return tree.longGet(key)
+	}
 	return nodeGetKey(tree, tree.Store.PageGet(tree.Root), key)
 }
+
+// Height returns the number of levels of the tree (1 for a single leaf), or
+// 0 if it is empty. Every leaf is at the same depth, so it follows the
+// leftmost path.
+func (tree *BTree) Height() int {
+	if tree.Root == 0 {
+		return 0
+	}
+	h := 1
+	for node := tree.Store.PageGet(tree.Root); node.btype() == BNodeInternal; h++ {
+		node = tree.Store.PageGet(node.getPtr(0))
+	}
+	return h
+}
+
+// estimateSamples is the number of children of a node whose sizes
+// EstimateKeys averages.
+const estimateSamples = 8
+
+// EstimateKeys estimates the number of keys in [start, end) from the paths
+// to start and to end down from the root. At each level it reads the node on
+// each path and up to estimateSamples of its children in the range, so its
+// cost grows with the height of the tree, not with the size of the range.
+// Where the paths part, the children in between are sampled too. A range
+// within one leaf is counted exactly. A nil end means no upper bound.
+func (tree *BTree) EstimateKeys(start, end []byte) int64 {
+	if tree.Root == 0 || end != nil && bytes.Compare(start, end) >= 0 {
+		return 0
+	}
+	node := tree.Store.PageGet(tree.Root)
+	for node.btype() == BNodeInternal {
+		i, j := nodeLookupLE(node, start), node.nkeys() // j: past the last child
+		if end != nil {
+			j = nodeLookupLE(node, end)
+		}
+		if i == j {
+			node = tree.Store.PageGet(node.getPtr(i))
+			continue
+		}
+		// The paths part here: count from start to the end of child i, the
+		// children in between, and child j up to end.
+		keys, unit := pathKeys(tree, tree.Store.PageGet(node.getPtr(i)), start, true)
+		if end != nil {
+			n, unit2 := pathKeys(tree, tree.Store.PageGet(node.getPtr(j)), end, false)
+			keys, unit = keys+n, (unit+unit2)/2
+		}
+		if j > i+1 {
+			keys += float64(j-i-1) * meanKeys(tree, node, i+1, j) * unit
+		}
+		return int64(keys + 0.5)
+	}
+	return int64(leafRank(node, end) - leafRank(node, start))
+}
+
+// pathKeys estimates the number of keys of the subtree of node at or after
+// key if after is set, or else before key, from the path to key. It also
+// returns the mean number of keys under a child of node on that side. The
+// sizes of the children off the path come from samples of those on the same
+// side, so keys outside the range, which may be of another size, do not
+// skew them.
+func pathKeys(tree *BTree, node BNode, key []byte, after bool) (keys, unit float64) {
+	if node.btype() == BNodeLeaf {
+		n := leafRank(node, key)
+		if after {
+			n = int(node.nkeys()) - n
+		}
+		return float64(n), 1
+	}
+	i := nodeLookupLE(node, key)
+	keys, unit = pathKeys(tree, tree.Store.PageGet(node.getPtr(i)), key, after)
+	if after {
+		unit *= meanKeys(tree, node, i, node.nkeys())
+		return keys + float64(node.nkeys()-i-1)*unit, unit
+	}
+	unit *= meanKeys(tree, node, 0, i+1)
+	return keys + float64(i)*unit, unit
+}
+
+// meanKeys returns the mean number of keys of the children [from, to) of
+// node, reading up to estimateSamples of them, one from the middle of each
+// of as many even strides.
+func meanKeys(tree *BTree, node BNode, from, to uint16) float64 {
+	n := min(int(to-from), estimateSamples)
+	total := 0
+	for k := range n {
+		child := from + uint16((2*k+1)*int(to-from)/(2*n))
+		total += int(tree.Store.PageGet(node.getPtr(child)).nkeys())
+	}
+	return float64(total) / float64(n)
+}
+
+// leafRank returns the number of keys of leaf less than key, or all of them
+// for a nil key.
+func leafRank(leaf BNode, key []byte) int {
+	if key == nil {
+		return int(leaf.nkeys())
+	}
+	i, _ := leafLookup(leaf, key)
+	return int(i)
+}
@@ -8,6 +8,24 @@ type BIter struct {
 	tree *BTree
 	path []BNode  // nodes from root to current leaf
 	pos  []uint16 // index into each node along the path
+
+	// ahead holds the positions, in the parent of the current leaf, of the
+	// leaves requested by read-ahead (see Prefetcher), if ok.
+	ahead struct {
+		lo, hi int
+		ok     bool
+	}
+
+	// group holds the long keys sharing the prefix of the current entry, in
+	// key order, when that entry is one of them, and gpos the position in it
+	// (see enterGroup).
+	group []entry
+	gpos  int
+
+	// before is set when the iterator is before the first key, having
+	// stepped back off it or been sought below it; path and pos then hold
+	// the first key.
+	before bool
 }
 
 // Comparison modes for Seek.
@@ -21,15 +39,29 @@ const (
 // Clone returns a deep copy of the iterator.
 func (iter *BIter) Clone() *BIter {
 	return &BIter{
-		tree: iter.tree,
-		path: append([]BNode(nil), iter.path...),
-		pos:  append([]uint16(nil), iter.pos...),
+		tree:   iter.tree,
+		path:   append([]BNode(nil), iter.path...),
+		pos:    append([]uint16(nil), iter.pos...),
+		ahead:  iter.ahead,
+		group:  iter.group,
+		gpos:   iter.gpos,
+		before: iter.before,
 	}
 }
 
 // Deref returns the key and value at the current position.
 func (iter *BIter) Deref() ([]byte, []byte) {
 	assert(iter.Valid())
+	return iter.current()
+}
+
+// current returns the key and value at the current position, or at the
+// first key when the iterator is before it.
+func (iter *BIter) current() ([]byte, []byte) {
+	if iter.group != nil {
+		e := iter.group[iter.gpos]
+		return e.key, e.val
+	}
 	return iterDeref(iter)
 }
 
@@ -40,20 +72,13 @@ func iterDeref(iter *BIter) ([]byte, []byte) {
 	return node.getKey(pos), node.getVal(pos)
 }
 
-// Valid reports whether the iterator points to a real (non-dummy) key.
+// Valid reports whether the iterator points to a key.
 func (iter *BIter) Valid() bool {
-	return !iterDummy(iter) && iterInRange(iter)
+	return rawValid(iter)
 }
 
-// iterDummy returns true when every position is zero, which means the iterator
-// is sitting on the synthetic sentinel key at the start of the tree.
-func iterDummy(iter *BIter) bool {
-	for _, pos := range iter.pos {
-		if pos != 0 {
-			return false
-		}
-	}
-	return true
+func rawValid(iter *BIter) bool {
+	return !iter.before && len(iter.path) > 0 && iterInRange(iter)
 }
 
 func iterInRange(iter *BIter) bool {
@@ -62,13 +87,18 @@ func iterInRange(iter *BIter) bool {
 	return iter.pos[last] < node.nkeys()
 }
 
-func iterPrev(iter *BIter, level int) {
+// iterPrev moves the position at level one step backward, reloading the
+// levels below it. It reports false when there is no step to take, so the
+// callers leave their levels alone at the ends of the tree.
+func iterPrev(iter *BIter, level int) bool {
 	if iter.pos[level] > 0 {
 		iter.pos[level]--
 	} else if level > 0 {
-		iterPrev(iter, level-1) // move to a sibling node
+		if !iterPrev(iter, level-1) { // move to a sibling node
+			return false
+		}
 	} else {
-		return // already at the dummy key
+		return false // already at the first key
 	}
 
 	if level+1 < len(iter.pos) {
@@ -76,17 +106,21 @@ func iterPrev(iter *BIter, level int) {
 		kid := iter.tree.Store.PageGet(node.getPtr(iter.pos[level]))
 		iter.path[level+1] = kid
 		iter.pos[level+1] = kid.nkeys() - 1
+		iter.readAhead(level, -1)
 	}
+	return true
 }
 
-func iterNext(iter *BIter, level int) {
+func iterNext(iter *BIter, level int) bool {
 	if iter.pos[level]+1 < iter.path[level].nkeys() {
 		iter.pos[level]++
 	} else if level > 0 {
-		iterNext(iter, level-1)
+		if !iterNext(iter, level-1) {
+			return false
+		}
 	} else {
 		iter.pos[len(iter.pos)-1]++ // step past the last key
-		return
+		return false
 	}
 
 	if level+1 < len(iter.pos) {
@@ -94,21 +128,109 @@ func iterNext(iter *BIter, level int) {
 		kid := iter.tree.Store.PageGet(node.getPtr(iter.pos[level]))
 		iter.path[level+1] = kid
 		iter.pos[level+1] = 0
+		iter.readAhead(level, +1)
+	}
+	return true
+}
+
+// readAhead runs after the iterator, moving in direction dir, loaded the
+// child of the node at level. When that child is a leaf, it requests the
+// leaves that follow it in the parent, up to ReadAhead of them, from a
+// store that is a Prefetcher. It only does so once fewer than half of them
+// are left requested, so the requests go out in batches. When the child is
+// the parent of the leaves, the requests start over.
+func (iter *BIter) readAhead(level, dir int) {
+	pf, ok := iter.tree.Store.(Prefetcher)
+	if !ok {
+		return
+	}
+	n := pf.ReadAhead()
+	switch {
+	case n <= 0:
+		return
+	case level+2 == len(iter.path):
+	case level+3 == len(iter.path):
+		iter.ahead.ok = false
+		return
+	default:
+		return
+	}
+
+	parent, pos := iter.path[level], int(iter.pos[level])
+	if !iter.ahead.ok {
+		iter.ahead.lo, iter.ahead.hi, iter.ahead.ok = pos, pos, true
+	}
+	var from, to int // the positions to request, in the direction of dir
+	if dir > 0 {
+		if iter.ahead.hi-pos > n/2 {
+			return
+		}
+		from, to = max(iter.ahead.hi, pos)+1, min(pos+n, int(parent.nkeys())-1)
+		iter.ahead.hi = max(iter.ahead.hi, to)
+	} else {
+		if pos-iter.ahead.lo > n/2 {
+			return
+		}
+		from, to = min(iter.ahead.lo, pos)-1, max(pos-n, 0)
+		iter.ahead.lo = min(iter.ahead.lo, to)
+	}
+	var ptrs []uint64
+	for i := from; dir*(to-i) >= 0; i += dir {
+		ptrs = append(ptrs, parent.getPtr(uint16(i)))
+	}
+	if len(ptrs) > 0 {
+		pf.PagePrefetch(ptrs)
 	}
 }
 
 // Prev moves the iterator one step backward.
 func (iter *BIter) Prev() {
-	iterPrev(iter, len(iter.path)-1)
+	leaf := len(iter.path) - 1
+	if iter.group != nil {
+		if iter.gpos > 0 {
+			iter.gpos--
+			return
+		}
+		for range len(iter.group) - 1 {
+			iterPrev(iter, leaf) // to the first entry of the group
+		}
+		iter.group = nil
+	}
+	if !iterPrev(iter, leaf) {
+		iter.before = true
+		return
+	}
+	iter.enterGroup(false)
 }
 
 // Next moves the iterator one step forward.
 func (iter *BIter) Next() {
-	iterNext(iter, len(iter.path)-1)
+	switch {
+	case iter.before:
+		iter.before = false // back on the first key
+	case iter.group != nil && iter.gpos+1 < len(iter.group):
+		iter.gpos++
+		return
+	default:
+		iter.group = nil
+		iterNext(iter, len(iter.path)-1)
+	}
+	iter.enterGroup(true)
 }
 
 // SeekLE positions the iterator at the largest key <= the given key.
 func (tree *BTree) SeekLE(key []byte) *BIter {
+	if tree.isLong(key) {
+		return tree.seekLELong(key)
+	}
+	iter := tree.seekLE(key)
+	iter.enterGroup(false)
+	return iter
+}
+
+// seekLE positions the iterator at the largest stored key <= key, or before
+// the first key.
+func (tree *BTree) seekLE(key []byte) *BIter {
 	iter := &BIter{tree: tree}
 	for ptr := tree.Root; ptr != 0; {
 		node := tree.Store.PageGet(ptr)
@@ -121,6 +243,46 @@ func (tree *BTree) SeekLE(key []byte) *BIter {
 			ptr = 0
 		}
 	}
+	if len(iter.path) > 0 {
+		if skey, _ := iterDeref(iter); bytes.Compare(skey, key) > 0 {
+			iter.before = true // every key is greater
+		}
+	}
+	return iter
+}
+
+// First positions the iterator at the smallest key, following the leftmost
+// path without comparing keys. The iterator is not valid if the tree holds
+// no key.
+func (tree *BTree) First() *BIter {
+	iter := tree.edge(func(BNode) uint16 { return 0 })
+	iter.enterGroup(true)
+	return iter
+}
+
+// Last positions the iterator at the largest key, following the rightmost
+// path without comparing keys. The iterator is not valid if the tree holds
+// no key.
+func (tree *BTree) Last() *BIter {
+	iter := tree.edge(func(node BNode) uint16 { return node.nkeys() - 1 })
+	iter.enterGroup(false)
+	return iter
+}
+
+// edge follows the path from the root that takes child pick(node) of each
+// node.
+func (tree *BTree) edge(pick func(node BNode) uint16) *BIter {
+	iter := &BIter{tree: tree}
+	for ptr := tree.Root; ptr != 0; {
+		node := tree.Store.PageGet(ptr)
+		idx := pick(node)
+		iter.path = append(iter.path, node)
+		iter.pos = append(iter.pos, idx)
+		ptr = 0
+		if node.btype() == BNodeInternal {
+			ptr = node.getPtr(idx)
+		}
+	}
 	return iter
 }
 
@@ -150,8 +312,8 @@ func (tree *BTree) Seek(key []byte, cmp int) *BIter {
 
 	iter = tree.SeekLE(key)
 	if cmp != CmpLE && iterInRange(iter) {
-		cur, _ := iterDeref(iter)
-		if !CmpOK(cur, cmp, key) {
+		cur, _ := iter.current()
+		if iter.before || !CmpOK(cur, cmp, key) {
 			if cmp > 0 {
 				iter.Next()
 			} else {
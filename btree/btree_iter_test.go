@@ -57,3 +57,179 @@ func TestBTreeIter(t *testing.T) {
 		}
 	}
 }
+
+func TestBTreeIterEnds(t *testing.T) {
+	btt := newBTreeTester()
+	const sz = 20000
+	for i := range sz {
+		btt.add(fmt.Sprintf("key%010d", i), "v")
+	}
+	is.Greater(t, btt.tree.Height(), 2)
+	first, last := fmt.Appendf(nil, "key%010d", 0), fmt.Appendf(nil, "key%010d", sz-1)
+
+	// Stepping off either end of a deep tree stays off it.
+	n := 0
+	for iter := btt.tree.Seek(first, CmpGE); iter.Valid(); iter.Next() {
+		n++
+		is.LessOrEqual(t, n, sz)
+	}
+	is.Equal(t, sz, n)
+	n = 0
+	for iter := btt.tree.Seek(last, CmpLE); iter.Valid(); iter.Prev() {
+		n++
+		is.LessOrEqual(t, n, sz)
+	}
+	is.Equal(t, sz, n)
+
+	is.False(t, btt.tree.Seek(last, CmpGT).Valid())
+	is.False(t, btt.tree.Seek(first, CmpLT).Valid())
+	iter := btt.tree.Seek(last, CmpGE)
+	iter.Next()
+	is.False(t, iter.Valid())
+	iter.Prev()
+	is.True(t, iter.Valid())
+	key, _ := iter.Deref()
+	is.Equal(t, last, key)
+}
+
+func TestBTreeFirstLast(t *testing.T) {
+	btt := newBTreeTester()
+	is.False(t, btt.tree.First().Valid())
+	is.False(t, btt.tree.Last().Valid())
+	btt.add("k", "v")
+	is.True(t, btt.del("k"))
+	is.Zero(t, btt.tree.Root) // the tree is empty again
+	is.False(t, btt.tree.First().Valid())
+	is.False(t, btt.tree.Last().Valid())
+
+	// Stepping back off the first key and forward again lands on it.
+	btt.add("b", "v")
+	iter := btt.tree.First()
+	iter.Prev()
+	is.False(t, iter.Valid())
+	iter.Prev()
+	iter.Next()
+	key, _ := iter.Deref()
+	is.Equal(t, "b", string(key))
+	is.False(t, btt.tree.Seek([]byte("a"), CmpLE).Valid())
+	key, _ = btt.tree.Seek([]byte("a"), CmpGT).Deref()
+	is.Equal(t, "b", string(key))
+	btt.verify(t)
+
+	for _, sz := range []int{1, 5, 20000} {
+		btt := newBTreeTester()
+		for i := range sz {
+			btt.add(fmt.Sprintf("key%010d", fmix32(uint32(i))), "v")
+		}
+		var lo, hi string
+		for k := range btt.ref {
+			if lo == "" || k < lo {
+				lo = k
+			}
+			hi = max(hi, k)
+		}
+		height := btt.tree.Height()
+		cs := &countingStore{PageStore: btt.store}
+		btt.tree.Store = cs
+
+		iter := btt.tree.First()
+		key, _ := iter.Deref()
+		is.Equal(t, lo, string(key))
+		iter.Prev()
+		is.False(t, iter.Valid())
+		iter = btt.tree.Last()
+		key, _ = iter.Deref()
+		is.Equal(t, hi, string(key))
+		iter.Next()
+		is.False(t, iter.Valid())
+		is.LessOrEqual(t, cs.reads, 2*height+1) // one path each
+		if sz > 1 {
+			iter = btt.tree.Last()
+			iter.Prev()
+			is.True(t, iter.Valid())
+		}
+	}
+}
+
+// prefetchStore records the pages requested by read-ahead, and the leaves
+// read without having been requested.
+type prefetchStore struct {
+	PageStore
+	ahead     int
+	requested map[uint64]bool
+	batches   [][]uint64
+	missed    int
+}
+
+func (s *prefetchStore) ReadAhead() int { return s.ahead }
+
+func (s *prefetchStore) PagePrefetch(ptrs []uint64) {
+	for _, ptr := range ptrs {
+		s.requested[ptr] = true
+	}
+	s.batches = append(s.batches, ptrs)
+}
+
+func (s *prefetchStore) PageGet(ptr uint64) BNode {
+	node := s.PageStore.PageGet(ptr)
+	if node.btype() == BNodeLeaf && !s.requested[ptr] {
+		s.missed++
+	}
+	return node
+}
+
+func TestBTreeIterReadAhead(t *testing.T) {
+	btt := newBTreeTester()
+	const sz = 20000
+	for i := range sz {
+		btt.add(fmt.Sprintf("key%010d", i), fmt.Sprintf("vvv%d", i))
+	}
+	leaves, parents := 0, 0
+	var count func(BNode)
+	count = func(node BNode) {
+		if node.btype() == BNodeLeaf {
+			leaves++
+			return
+		}
+		if btt.store.PageGet(node.getPtr(0)).btype() == BNodeLeaf {
+			parents++
+		}
+		for i := range node.nkeys() {
+			count(btt.store.PageGet(node.getPtr(i)))
+		}
+	}
+	count(btt.store.PageGet(btt.tree.Root))
+	is.Greater(t, parents, 1) // the windows stop at the parents
+
+	scan := func(ahead int, forward bool) *prefetchStore {
+		ps := &prefetchStore{PageStore: btt.store, ahead: ahead, requested: map[uint64]bool{}}
+		tree := BTree{Root: btt.tree.Root, Store: ps}
+		n := 0
+		if forward {
+			for iter := tree.Seek(nil, CmpGT); iter.Valid(); iter.Next() {
+				n++
+			}
+		} else {
+			for iter := tree.SeekLE([]byte("l")); iter.Valid(); iter.Prev() {
+				n++
+			}
+		}
+		is.Equal(t, sz, n)
+		return ps
+	}
+	for _, forward := range []bool{true, false} {
+		ps := scan(0, forward)
+		is.Empty(t, ps.batches)
+		is.Equal(t, leaves, ps.missed)
+
+		ps = scan(8, forward)
+		// The first leaf of each parent is read unrequested, and so is the
+		// leaf after the one the seek read.
+		is.Equal(t, parents+1, ps.missed)
+		is.Equal(t, leaves-parents-1, len(ps.requested))
+		for _, b := range ps.batches {
+			is.LessOrEqual(t, len(b), 8)
+		}
+		is.Less(t, len(ps.batches), leaves/3) // batched, not one per leaf
+	}
+}
@@ -61,25 +61,16 @@ func (btt *btreeTester) del(key string) bool {
 
 func (btt *btreeTester) dump() ([]string, []string) {
 	keys, vals := []string{}, []string{}
-	var nodeDump func(uint64)
-	nodeDump = func(ptr uint64) {
-		node := btt.store.PageGet(ptr)
-		nkeys := node.nkeys()
-		if node.btype() == BNodeLeaf {
-			for i := range nkeys {
-				keys = append(keys, string(node.getKey(i)))
-				vals = append(vals, string(node.getVal(i)))
-			}
-		} else {
-			for i := range nkeys {
-				nodeDump(node.getPtr(i))
-			}
+	btt.tree.Walk(func(_ int, node BNode) {
+		if node.Type() != BNodeLeaf {
+			return
 		}
-	}
-	nodeDump(btt.tree.Root)
-	assert(keys[0] == "")
-	assert(vals[0] == "")
-	return keys[1:], vals[1:]
+		for i := range node.NKeys() {
+			keys = append(keys, string(node.Key(i)))
+			vals = append(vals, string(node.Val(i)))
+		}
+	})
+	return keys, vals
 }
 
 type sortIF struct {
@@ -123,7 +114,9 @@ func (btt *btreeTester) verify(t *testing.T) {
 			nodeVerify(kid)
 		}
 	}
-	nodeVerify(btt.store.PageGet(btt.tree.Root))
+	if btt.tree.Root != 0 {
+		nodeVerify(btt.store.PageGet(btt.tree.Root))
+	}
 }
 
 func fmix32(h uint32) uint32 {
@@ -181,8 +174,33 @@ func TestBTreeBasic(t *testing.T) {
 	btt.del("k")
 	btt.verify(t)
 
-	is.Equal(t, 1, len(btt.store.pages))
-	is.Equal(t, uint16(1), btt.store.PageGet(btt.tree.Root).nkeys())
+	is.Empty(t, btt.store.pages)
+	is.Zero(t, btt.tree.Root)
+}
+
+func TestInsertExFlags(t *testing.T) {
+	btt := newBTreeTester()
+
+	// The first key of an empty tree is both added and an update.
+	req := InsertReq{Key: []byte("k"), Val: []byte("v")}
+	btt.tree.InsertEx(&req)
+	is.True(t, req.Added)
+	is.True(t, req.Updated)
+
+	req = InsertReq{Key: []byte("k"), Val: []byte("v")}
+	btt.tree.InsertEx(&req)
+	is.False(t, req.Added)
+	is.False(t, req.Updated)
+
+	req = InsertReq{Key: []byte("k"), Val: []byte("v2")}
+	btt.tree.InsertEx(&req)
+	is.False(t, req.Added)
+	is.True(t, req.Updated)
+
+	req = InsertReq{Key: []byte("k2"), Val: []byte("v")}
+	btt.tree.InsertEx(&req)
+	is.True(t, req.Added)
+	is.True(t, req.Updated)
 }
 
 func TestBTreeRandLength(t *testing.T) {
@@ -217,3 +235,232 @@ func TestBTreeIncLength(t *testing.T) {
 		btt.verify(t)
 	}
 }
+
+// TestNodeFormatGolden pins the byte layout of a leaf node: header (type,
+// nkeys), pointers, offsets, then length-prefixed key/value pairs, all
+// little-endian. Changing it requires a new kv format version.
+func TestNodeFormatGolden(t *testing.T) {
+	node := BNode{Data: make([]byte, PageSize)}
+	node.setHeader(BNodeLeaf, 2)
+	nodeAppendKV(node, 0, 0, []byte("ab"), []byte("xyz"))
+	nodeAppendKV(node, 1, 0, []byte("c"), nil)
+	got := fmt.Sprintf("%x", node.Data[:node.nbytes()])
+	is.Equal(t, "0200"+"0200"+ // type, nkeys
+		"0000000000000000"+"0000000000000000"+ // pointers
+		"0900"+"0e00"+ // offsets
+		"020003006162"+"78797a"+ // "ab" => "xyz"
+		"0100000063", // "c" => ""
+		got)
+}
+
+// countingStore counts PageGet calls.
+type countingStore struct {
+	PageStore
+	reads int
+}
+
+func (s *countingStore) PageGet(ptr uint64) BNode {
+	s.reads++
+	return s.PageStore.PageGet(ptr)
+}
+
+func TestSummary(t *testing.T) {
+	btt := newBTreeTester()
+	s := btt.tree.Summary()
+	is.Equal(t, 0, s.Leaves())
+	_, ok := s.Get(btt.store, []byte("k"))
+	is.False(t, ok)
+
+	for i := 0; i < 20000; i++ {
+		btt.add(fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("vvv%d", i))
+		if i == 0 {
+			s = btt.tree.Summary()
+			is.Equal(t, 1, s.Leaves())
+			v, ok := s.Get(btt.store, []byte(fmt.Sprintf("key%d", fmix32(0))))
+			is.True(t, ok)
+			is.Equal(t, "vvv0", string(v))
+		}
+	}
+	s = btt.tree.Summary()
+	leaves := 0
+	var count func(BNode)
+	count = func(node BNode) {
+		if node.btype() == BNodeLeaf {
+			leaves++
+			return
+		}
+		for i := range node.nkeys() {
+			count(btt.store.PageGet(node.getPtr(i)))
+		}
+	}
+	count(btt.store.PageGet(btt.tree.Root))
+	is.Equal(t, leaves, s.Leaves())
+
+	cs := &countingStore{PageStore: btt.store}
+	for k, v := range btt.ref {
+		got, ok := s.Get(cs, []byte(k))
+		is.True(t, ok)
+		is.Equal(t, v, string(got))
+		_, ok = s.Get(cs, []byte(k+"x"))
+		is.False(t, ok)
+	}
+	is.Equal(t, 2*len(btt.ref), cs.reads) // one page per lookup
+	_, ok = s.Get(cs, []byte(""))
+	is.False(t, ok) // the empty key is not stored
+}
+
+func TestEstimateRange(t *testing.T) {
+	btt := newBTreeTester()
+	is.Equal(t, RangeEstimate{Exact: true}, btt.tree.Summary().EstimateRange(btt.store, []byte("a"), nil, 4))
+
+	// Two "tables" of 5000 keys each, with values of different sizes.
+	for i := range 5000 {
+		btt.add(fmt.Sprintf("a%05d", fmix32(uint32(i))%100000), "v")
+		btt.add(fmt.Sprintf("b%05d", fmix32(uint32(i))%100000), "vvvvvvvvvv")
+	}
+	count := func(start, end string) (int64, int64) {
+		var n, size int64
+		for k, v := range btt.ref {
+			if k >= start && k < end {
+				n++
+				size += int64(len(k) + len(v))
+			}
+		}
+		return n, size
+	}
+	s := btt.tree.Summary()
+
+	for _, r := range [][2]string{{"a", "b"}, {"b", "c"}, {"a1", "a3"}} {
+		keys, size := count(r[0], r[1])
+		est := s.EstimateRange(btt.store, []byte(r[0]), []byte(r[1]), 8)
+		is.Equal(t, est.Leaves <= 8, est.Exact)
+		is.InEpsilon(t, keys, est.Keys, 0.25, "%v", r)
+		is.InEpsilon(t, size, est.Bytes, 0.25, "%v", r)
+
+		// With enough samples every leaf is read and the answer is exact.
+		est = s.EstimateRange(btt.store, []byte(r[0]), []byte(r[1]), s.Leaves())
+		is.True(t, est.Exact)
+		is.Equal(t, keys, est.Keys)
+		is.Equal(t, size, est.Bytes)
+	}
+	est := s.EstimateRange(btt.store, []byte("c"), nil, 8)
+	is.Zero(t, est.Keys)
+	is.LessOrEqual(t, est.Leaves, 1)
+}
+
+func TestEstimateKeys(t *testing.T) {
+	btt := newBTreeTester()
+	is.Zero(t, btt.tree.EstimateKeys([]byte("a"), nil))
+
+	for i := range 20000 {
+		btt.add(fmt.Sprintf("a%06d", fmix32(uint32(i))%1000000), fmt.Sprintf("%0100d", i))
+	}
+	height := btt.tree.Height()
+	is.Greater(t, height, 2)
+	count := func(start, end string) int64 {
+		n := int64(0)
+		for k := range btt.ref {
+			if k >= start && (end == "" || k < end) {
+				n++
+			}
+		}
+		return n
+	}
+	cs := &countingStore{PageStore: btt.store}
+	btt.tree.Store = cs
+	for _, r := range [][2]string{{"a", "b"}, {"a0", "a5"}, {"a2", "a25"}, {"a3", ""}} {
+		var end []byte
+		if r[1] != "" {
+			end = []byte(r[1])
+		}
+		is.InEpsilon(t, count(r[0], r[1]), btt.tree.EstimateKeys([]byte(r[0]), end), 0.15, "%v", r)
+	}
+	is.LessOrEqual(t, cs.reads, 4*3*(1+estimateSamples)*height)
+
+	// A range within one leaf is exact.
+	is.Equal(t, count("a100000", "a100500"), btt.tree.EstimateKeys([]byte("a100000"), []byte("a100500")))
+	is.Zero(t, btt.tree.EstimateKeys([]byte("a5"), []byte("a4")))
+	is.Zero(t, btt.tree.EstimateKeys([]byte("b"), nil))
+}
+
+func TestHeight(t *testing.T) {
+	is.Equal(t, 0, (&BTree{}).Height())
+	btt := newBTreeTester()
+	is.Equal(t, 0, btt.tree.Height())
+
+	var depth func(node BNode) int
+	depth = func(node BNode) int {
+		if node.btype() == BNodeLeaf {
+			return 1
+		}
+		d := depth(btt.store.PageGet(node.getPtr(0)))
+		for i := range node.nkeys() {
+			is.Equal(t, d, depth(btt.store.PageGet(node.getPtr(i))))
+		}
+		return d + 1
+	}
+	for i := 0; i < 20000; i++ {
+		btt.add(fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("vvv%d", i))
+		if i%5000 == 0 {
+			is.Equal(t, depth(btt.store.PageGet(btt.tree.Root)), btt.tree.Height())
+		}
+	}
+	is.Equal(t, depth(btt.store.PageGet(btt.tree.Root)), btt.tree.Height())
+	is.Greater(t, btt.tree.Height(), 2)
+}
+
+func TestInsertOld(t *testing.T) {
+	btt := newBTreeTester()
+	insert := func(key, val string, mode int) InsertReq {
+		req := InsertReq{Key: []byte(key), Val: []byte(val), Mode: mode}
+		btt.tree.InsertEx(&req)
+		return req
+	}
+	req := insert("k", "a", ModeUpsert)
+	is.True(t, req.Added)
+	is.Nil(t, req.Old)
+	req = insert("k", "b", ModeUpsert)
+	is.True(t, req.Updated)
+	is.Equal(t, "a", string(req.Old))
+	// The key's value is reported when the tree is left alone too.
+	req = insert("k", "b", ModeUpsert)
+	is.False(t, req.Updated)
+	is.Equal(t, "b", string(req.Old))
+	req = insert("k", "c", ModeInsertOnly)
+	is.False(t, req.Updated)
+	is.Equal(t, "b", string(req.Old))
+	insert("x", "", ModeUpsert)
+	req = insert("x", "", ModeInsertOnly)
+	is.NotNil(t, req.Old) // an empty value
+	req = insert("y", "a", ModeUpdateOnly)
+	is.False(t, req.Updated)
+	is.Nil(t, req.Old)
+}
+
+func TestLimits(t *testing.T) {
+	is.NoError(t, CheckLimits(MaxKeySize, MaxValSize))
+	is.NoError(t, CheckLimits(minKeyLimit, maxEntrySize-minKeyLimit))
+	is.NoError(t, CheckLimits(maxKeyLimit, maxEntrySize-maxKeyLimit))
+	is.Error(t, CheckLimits(maxKeyLimit+1, 0))
+	is.Error(t, CheckLimits(minKeyLimit-1, 0))
+	is.Error(t, CheckLimits(MaxKeySize, maxEntrySize-MaxKeySize+1))
+	is.Error(t, CheckLimits(200, -1))
+
+	// Small keys leave room for large values, in any mix of sizes.
+	btt := newBTreeTester()
+	btt.tree.MaxKey, btt.tree.MaxVal = 100, maxEntrySize-100
+	maxKey, maxVal := btt.tree.Limits()
+	is.Equal(t, 100, maxKey)
+	is.Greater(t, maxVal, MaxValSize)
+	for i := range 300 {
+		key := make([]byte, 1+fmix32(uint32(2*i))%uint32(maxKey))
+		rand.Read(key)
+		val := make([]byte, fmix32(uint32(2*i+1))%uint32(maxVal+1))
+		if i%2 == 0 {
+			val = make([]byte, maxVal)
+		}
+		btt.add(string(key), string(val))
+	}
+	btt.verify(t)
+	is.Panics(t, func() { btt.tree.Insert(make([]byte, maxKey+maxVal), nil) })
+}
@@ -0,0 +1,311 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// maxCheckProblems bounds CheckReport.Problems; a badly damaged file could
+// otherwise produce one problem per page.
+const maxCheckProblems = 100
+
+// Problem is one violated invariant found by Check.
+type Problem struct {
+	Page uint64 // the page it was found on, or 0 if it is not about a page
+	Msg  string
+}
+
+func (p Problem) String() string {
+	if p.Page == 0 {
+		return p.Msg
+	}
+	return fmt.Sprintf("page %d: %s", p.Page, p.Msg)
+}
+
+// CheckReport is the result of Check.
+type CheckReport struct {
+	Pages         uint64 // pages in the file, the master page included
+	TreePages     int    // pages reachable from the root
+	Height        int    // levels of the tree
+	Keys          int64  // keys in the leaves
+	FreeListNodes int    // pages holding the free list
+	FreePages     int    // pages listed as free
+	Leaked        int    // pages neither in the tree nor on the free list
+
+	Problems     []Problem // the first problems found
+	MoreProblems int       // problems found beyond those in Problems
+}
+
+// OK reports whether no problem was found.
+func (r CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *CheckReport) problem(page uint64, format string, args ...any) {
+	if len(r.Problems) == maxCheckProblems {
+		r.MoreProblems++
+		return
+	}
+	r.Problems = append(r.Problems, Problem{page, fmt.Sprintf(format, args...)})
+}
+
+// Check walks the whole tree at root and the free list at freeHead of a file
+// of npages pages and verifies that
+//
+//   - every node is well formed: a known type, offsets in order and within
+//     the page, and keys and values within the largest limits that pass
+//     CheckLimits;
+//   - keys are in strictly increasing order, each internal node stores the
+//     first key of each child, and all leaves are at the same depth;
+//   - every page in [1, npages) is used exactly once, by the tree, by the
+//     free list or as a free page, and no pointer leaves the file.
+//
+// Check never panics on a damaged page; it records a Problem and skips what
+// the page points to. Page 0 is the master page and is not checked.
+func Check(store PageStore, root, freeHead, npages uint64) CheckReport {
+	c := checker{store: store, npages: npages, used: make([]byte, npages)}
+	c.report.Pages = npages
+	if root != 0 {
+		c.walk(root, nil, nil, 1)
+	}
+	c.report.Height = c.height
+	c.freeList(freeHead)
+	for ptr := uint64(1); ptr < npages; ptr++ {
+		if c.used[ptr] == 0 {
+			c.report.Leaked++
+			c.report.problem(ptr, "leaked: not in the tree nor on the free list")
+		}
+	}
+	return c.report
+}
+
+// Page uses recorded in checker.used.
+const (
+	useTree = 1 + iota
+	useFreeListNode
+	useFree
+)
+
+var useNames = [...]string{"", "tree node", "free-list node", "free page"}
+
+type checker struct {
+	store  PageStore
+	npages uint64
+	used   []byte // per page, how it is used
+	height int    // depth of the first leaf found
+	report CheckReport
+	leaf   func(node BNode)          // if set, called for every well-formed leaf
+	free   func(ptr, version uint64) // if set, called for every free page
+	chain  []uint64                  // the free-list nodes followed, from the head
+}
+
+// claim records a use of ptr and reports whether the page may be read.
+func (c *checker) claim(ptr uint64, use byte, from uint64) bool {
+	if ptr == 0 || ptr >= c.npages {
+		c.report.problem(from, "pointer to page %d outside the file (%d pages)", ptr, c.npages)
+		return false
+	}
+	if prev := c.used[ptr]; prev != 0 {
+		c.report.problem(ptr, "referenced twice: as a %s and as a %s", useNames[prev], useNames[use])
+		return false
+	}
+	c.used[ptr] = use
+	return true
+}
+
+// walk checks the subtree at ptr, whose keys must be in [lo, hi); a nil hi
+// means no upper bound. lo is the key the parent stores for it.
+func (c *checker) walk(ptr uint64, lo, hi []byte, depth int) {
+	if !c.claim(ptr, useTree, 0) {
+		return
+	}
+	c.report.TreePages++
+	node := c.store.PageGet(ptr)
+	if !c.wellFormed(ptr, node) {
+		return
+	}
+
+	btype, nkeys := node.btype(), node.nkeys()
+	if lo != nil && !bytes.Equal(node.getKey(0), lo) {
+		c.report.problem(ptr, "first key %q differs from the key %q its parent stores for it", node.getKey(0), lo)
+	}
+	for i := range nkeys {
+		key := node.getKey(i)
+		if i > 0 && bytes.Compare(node.getKey(i-1), key) >= 0 {
+			c.report.problem(ptr, "key %d %q is not greater than the previous key", i, key)
+		}
+		if bytes.Compare(key, lo) < 0 || hi != nil && bytes.Compare(key, hi) >= 0 {
+			c.report.problem(ptr, "key %d %q outside the range its parent assigns", i, key)
+		}
+	}
+
+	if btype == BNodeLeaf {
+		if c.leaf != nil {
+			c.leaf(node)
+		}
+		c.report.Keys += int64(nkeys)
+		if c.height == 0 {
+			c.height = depth
+		} else if depth != c.height {
+			c.report.problem(ptr, "leaf at depth %d, expected %d", depth, c.height)
+		}
+		return
+	}
+	for i := range nkeys {
+		next := hi
+		if i+1 < nkeys {
+			next = node.getKey(i + 1)
+		}
+		c.walk(node.getPtr(i), node.getKey(i), next, depth+1)
+	}
+}
+
+// wellFormed checks the layout of a tree node before any accessor, which
+// would panic on bad offsets, is used on it.
+func (c *checker) wellFormed(ptr uint64, node BNode) bool {
+	btype, nkeys := node.btype(), int(node.nkeys())
+	if btype != BNodeLeaf && btype != BNodeInternal {
+		c.report.problem(ptr, "bad node type %d", btype)
+		return false
+	}
+	if nkeys == 0 {
+		c.report.problem(ptr, "node without keys")
+		return false
+	}
+	base := headerSize + 10*nkeys
+	if base > PageSize {
+		c.report.problem(ptr, "%d keys do not fit in a page", nkeys)
+		return false
+	}
+	prev := 0
+	for i := 1; i <= nkeys; i++ {
+		offset := int(binary.LittleEndian.Uint16(node.Data[headerSize+8*nkeys+2*(i-1):]))
+		if offset < prev+4 || base+offset > PageSize {
+			c.report.problem(ptr, "bad offset %d of key %d (nbytes > page size or out of order)", offset, i)
+			return false
+		}
+		pos := base + prev
+		klen := int(binary.LittleEndian.Uint16(node.Data[pos:]))
+		vlen := int(binary.LittleEndian.Uint16(node.Data[pos+2:]))
+		if 4+klen+vlen != offset-prev {
+			c.report.problem(ptr, "key %d: lengths %d+%d do not match its offsets", i-1, klen, vlen)
+			return false
+		}
+		if klen > maxKeyLimit || klen+vlen > maxEntrySize {
+			c.report.problem(ptr, "key %d: key or value too large (%d, %d bytes)", i-1, klen, vlen)
+		}
+		if btype == BNodeInternal && vlen != 0 {
+			c.report.problem(ptr, "key %d: internal node with a value", i-1)
+		}
+		prev = offset
+	}
+	return true
+}
+
+// freeList checks the free list, following it as FreeList.loadCache does:
+// from the head towards the tail until the recorded total is covered. The
+// first entries of the tail node were already handed out and are skipped.
+func (c *checker) freeList(head uint64) {
+	if head == 0 {
+		return
+	}
+	if head >= c.npages {
+		c.report.problem(0, "free-list head %d outside the file (%d pages)", head, c.npages)
+		return
+	}
+	total := FreeListTotal(c.store, head)
+	remain := total
+	for ptr, from := head, uint64(0); ptr == head || remain > 0; {
+		if !c.claim(ptr, useFreeListNode, from) {
+			return
+		}
+		c.report.FreeListNodes++
+		node := c.store.PageGet(ptr)
+		if node.btype() != BNodeFreeList {
+			c.report.problem(ptr, "bad free-list node type %d", node.btype())
+			return
+		}
+		if flnSize(node) > FreeListCap {
+			c.report.problem(ptr, "free-list node with %d entries, at most %d fit", flnSize(node), FreeListCap)
+			return
+		}
+		c.chain = append(c.chain, ptr)
+		remain -= flnSize(node)
+		if remain > 0 && flnNext(node) == 0 {
+			c.report.problem(ptr, "free list ends %d entries short of its total %d", remain, total)
+			remain = 0
+		}
+		ptr, from = flnNext(node), ptr
+	}
+
+	skip := -remain // entries of the tail node already handed out
+	for i, nptr := range c.chain {
+		node := c.store.PageGet(nptr)
+		start := 0
+		if i == len(c.chain)-1 {
+			start = skip
+		}
+		for j := start; j < flnSize(node); j++ {
+			ptr, ver := flnItem(node, j)
+			if c.claim(ptr, useFree, nptr) {
+				c.report.FreePages++
+				if c.free != nil {
+					c.free(ptr, ver)
+				}
+			}
+		}
+	}
+}
+
+// Salvage recovers the entries of a damaged tree. Like Check, it walks what
+// is readable of the tree at root and of the free list at freeHead, calling
+// fn with reachable set for every well-formed leaf of the tree. Then it
+// scans the pages of [1, npages) that neither walk reached, which include
+// the leaves below damaged internal nodes, and calls fn for each intact
+// leaf among them: well formed, with keys in strictly increasing order.
+//
+// Those orphan leaves carry no version: one that was freed but is missing
+// from a damaged free list may hold stale entries, so callers should prefer
+// reachable entries. Pass root 0 or freeHead 0 when they are unknown. The
+// returned report is that of the walks.
+func Salvage(store PageStore, root, freeHead, npages uint64, fn func(keys, vals [][]byte, reachable bool)) CheckReport {
+	entries := func(node BNode) ([][]byte, [][]byte) {
+		var keys, vals [][]byte
+		for i := range node.nkeys() {
+			keys = append(keys, node.getKey(i))
+			vals = append(vals, node.getVal(i))
+		}
+		return keys, vals
+	}
+	c := checker{store: store, npages: npages, used: make([]byte, npages)}
+	c.report.Pages = npages
+	c.leaf = func(node BNode) {
+		keys, vals := entries(node)
+		fn(keys, vals, true)
+	}
+	if root != 0 {
+		c.walk(root, nil, nil, 1)
+	}
+	c.report.Height = c.height
+	c.freeList(freeHead)
+
+	for ptr := uint64(1); ptr < npages; ptr++ {
+		if c.used[ptr] != 0 {
+			continue
+		}
+		node := store.PageGet(ptr)
+		if node.btype() != BNodeLeaf || !(&checker{}).wellFormed(ptr, node) {
+			continue
+		}
+		keys, vals := entries(node)
+		sorted := true
+		for i := 1; i < len(keys); i++ {
+			sorted = sorted && bytes.Compare(keys[i-1], keys[i]) < 0
+		}
+		if sorted {
+			fn(keys, vals, false)
+		}
+	}
+	return c.report
+}
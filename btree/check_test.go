@@ -0,0 +1,88 @@
+package btree
+
+import (
+	"encoding/binary"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCheckNode(t *testing.T) {
+	leaf := func() BNode {
+		node := BNode{make([]byte, PageSize)}
+		node.setHeader(BNodeLeaf, 2)
+		nodeAppendKV(node, 0, 0, []byte("a"), []byte("1"))
+		nodeAppendKV(node, 1, 0, []byte("b"), []byte("2"))
+		return node
+	}
+	check := func(node BNode) []Problem {
+		c := checker{}
+		c.wellFormed(7, node)
+		return c.report.Problems
+	}
+
+	is.Empty(t, check(leaf()))
+
+	node := leaf()
+	node.setHeader(9, 2)
+	is.Equal(t, []Problem{{7, "bad node type 9"}}, check(node))
+
+	node = leaf()
+	node.setHeader(BNodeLeaf, 0)
+	is.Equal(t, []Problem{{7, "node without keys"}}, check(node))
+
+	node = leaf()
+	node.setHeader(BNodeLeaf, 1000)
+	is.Equal(t, []Problem{{7, "1000 keys do not fit in a page"}}, check(node))
+
+	node = leaf()
+	node.setOffset(2, 5) // overlaps the first key
+	is.Equal(t, []Problem{{7, "bad offset 5 of key 2 (nbytes > page size or out of order)"}}, check(node))
+
+	node = leaf()
+	binary.LittleEndian.PutUint16(node.Data[node.kvPos(1):], 3) // key length
+	is.Equal(t, []Problem{{7, "key 1: lengths 3+1 do not match its offsets"}}, check(node))
+
+	node = leaf()
+	node.setHeader(BNodeInternal, 2)
+	is.Len(t, check(node), 2) // values in an internal node
+}
+
+func TestCheckReportLimit(t *testing.T) {
+	var r CheckReport
+	for range maxCheckProblems + 5 {
+		r.problem(1, "x")
+	}
+	is.False(t, r.OK())
+	is.Len(t, r.Problems, maxCheckProblems)
+	is.Equal(t, 5, r.MoreProblems)
+	is.Equal(t, "page 1: x", r.Problems[0].String())
+	is.Equal(t, "x", Problem{Msg: "x"}.String())
+}
+
+func TestInspectPage(t *testing.T) {
+	leaf := BNode{make([]byte, PageSize)}
+	leaf.setHeader(BNodeLeaf, 2)
+	nodeAppendKV(leaf, 0, 0, []byte("a"), []byte("1"))
+	nodeAppendKV(leaf, 1, 0, []byte("b"), nil)
+	info := InspectPage(leaf)
+	is.Equal(t, [][]byte{[]byte("a"), []byte("b")}, info.Keys)
+	is.Equal(t, [][]byte{[]byte("1"), {}}, info.Vals)
+	is.Nil(t, info.Ptrs)
+	is.Equal(t, int(leaf.nbytes()), info.Bytes)
+	is.Empty(t, info.Problem)
+
+	fl := BNode{make([]byte, PageSize)}
+	flnSetHeader(fl, 2, 9)
+	flnSetTotal(fl, 5)
+	flnSetItem(fl, 0, 3, 10)
+	flnSetItem(fl, 1, 4, 11)
+	info = InspectPage(fl)
+	is.Equal(t, PageInfo{Type: BNodeFreeList, Free: []uint64{3, 4}, Versions: []uint64{10, 11}, Total: 5, Next: 9}, info)
+	binary.LittleEndian.PutUint16(fl.Data[2:], FreeListCap+1)
+	is.NotEmpty(t, InspectPage(fl).Problem)
+
+	info = InspectPage(BNode{make([]byte, PageSize)})
+	is.Zero(t, info.Type)
+	is.Equal(t, "not a tree or free-list node", info.Problem)
+}
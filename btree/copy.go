@@ -0,0 +1,86 @@
+package btree
+
+// Copy writes a densely packed copy of the tree at root, read from src, to
+// dst and returns the root of the copy. Leaves and internal nodes are filled
+// as far as a page allows, in key order, so the copy has the fewest pages the
+// entries fit in. Pages are allocated with dst.PageNew, children before their
+// parent. An empty tree (root 0) copies to 0.
+func Copy(src PageStore, root uint64, dst PageStore) uint64 {
+	if root == 0 {
+		return 0
+	}
+	b := builder{dst: dst}
+	b.copy(src, root)
+	return b.finish()
+}
+
+// builder packs a sorted stream of entries into nodes, one pending node per
+// level: levels[0] collects leaf entries, levels[i] the children of level i-1.
+type builder struct {
+	dst    PageStore
+	levels []pending
+}
+
+type pending struct {
+	keys, vals [][]byte
+	ptrs       []uint64
+	size       int // bytes the node would use
+}
+
+func (b *builder) copy(src PageStore, ptr uint64) {
+	node := src.PageGet(ptr)
+	for i := range node.nkeys() {
+		if node.btype() == BNodeLeaf {
+			b.add(0, node.getKey(i), node.getVal(i), 0)
+		} else {
+			b.copy(src, node.getPtr(i))
+		}
+	}
+}
+
+// add appends an entry to the pending node of level, writing that node out
+// first if the entry does not fit in it.
+func (b *builder) add(level int, key, val []byte, ptr uint64) {
+	if level == len(b.levels) {
+		b.levels = append(b.levels, pending{size: headerSize})
+	}
+	p := &b.levels[level]
+	size := 8 + 2 + 4 + len(key) + len(val)
+	if len(p.keys) > 0 && p.size+size > PageSize {
+		b.flush(level)
+		p = &b.levels[level] // flush may grow b.levels
+	}
+	p.keys = append(p.keys, key)
+	p.vals = append(p.vals, val)
+	p.ptrs = append(p.ptrs, ptr)
+	p.size += size
+}
+
+// flush writes the pending node of level and adds it to the level above.
+func (b *builder) flush(level int) {
+	p := &b.levels[level]
+	btype := uint16(BNodeInternal)
+	if level == 0 {
+		btype = BNodeLeaf
+	}
+	node := BNode{make([]byte, PageSize)}
+	node.setHeader(btype, uint16(len(p.keys)))
+	for i := range p.keys {
+		nodeAppendKV(node, uint16(i), p.ptrs[i], p.keys[i], p.vals[i])
+	}
+	first := p.keys[0]
+	*p = pending{size: headerSize}
+	b.add(level+1, first, nil, b.dst.PageNew(node))
+}
+
+// finish writes the remaining pending nodes, bottom up, and returns the root:
+// the only child left in the top level.
+func (b *builder) finish() uint64 {
+	for level := 0; ; level++ {
+		p := b.levels[level]
+		if level > 0 && level == len(b.levels)-1 && len(p.keys) == 1 {
+			return p.ptrs[0]
+		}
+		b.flush(level)
+	}
+}
@@ -0,0 +1,60 @@
+package btree
+
+import (
+	"fmt"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	is.Zero(t, Copy(nil, 0, nil))
+
+	btt := newBTreeTester()
+	btt.add("k", "v")
+	dst := &testStore{pages: map[uint64]BNode{}}
+	root := Copy(btt.store, btt.tree.Root, dst)
+	is.Len(t, dst.pages, 1)
+	is.Equal(t, uint16(BNodeLeaf), dst.PageGet(root).btype())
+
+	for i := range 20000 {
+		btt.add(fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("vvv%d", i))
+	}
+	for i := 0; i < 20000; i += 3 {
+		is.True(t, btt.del(fmt.Sprintf("key%d", fmix32(uint32(i)))))
+	}
+	height := btt.tree.Height()
+
+	dst = &testStore{pages: map[uint64]BNode{}}
+	root = Copy(btt.store, btt.tree.Root, dst)
+	is.Less(t, len(dst.pages), len(btt.store.pages))
+	btt.store, btt.tree = dst, BTree{Root: root, Store: dst}
+	btt.verify(t)
+	is.LessOrEqual(t, btt.tree.Height(), height)
+
+	// Every node but the last of its level is full: the next entry did not fit.
+	var leaves []BNode
+	var collect func(BNode)
+	collect = func(node BNode) {
+		if node.btype() == BNodeLeaf {
+			leaves = append(leaves, node)
+			return
+		}
+		for i := range node.nkeys() {
+			collect(dst.PageGet(node.getPtr(i)))
+		}
+	}
+	collect(dst.PageGet(root))
+	for i, leaf := range leaves[:len(leaves)-1] {
+		next := leaves[i+1]
+		size := 8 + 2 + 4 + len(next.getKey(0)) + len(next.getVal(0))
+		is.Greater(t, int(leaf.nbytes())+size, PageSize)
+	}
+
+	// The copy is an ordinary tree.
+	for i := range 1000 {
+		btt.add(fmt.Sprintf("new%d", i), "x")
+		btt.del(fmt.Sprintf("key%d", fmix32(uint32(3*i+1))))
+	}
+	btt.verify(t)
+}
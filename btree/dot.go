@@ -0,0 +1,60 @@
+package btree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// dotKeyLen is how many bytes of a key DumpDOT shows; longer keys are cut
+// and end with "...".
+const dotKeyLen = 16
+
+// DumpDOT writes the tree as a Graphviz graph, to render with dot -Tsvg:
+// one record per node, labelled with its page number and keys, and an edge
+// from each key of an internal node to the child it points to. Keys are
+// shown as stored, quoted and cut to dotKeyLen bytes; values are left out.
+// It reads every page of the tree.
+func (tree *BTree) DumpDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph btree {")
+	fmt.Fprintln(bw, "\tnode [shape=record, fontname=monospace];")
+	tree.walk(func(ptr uint64, _ int, node BNode) {
+		kind := "leaf"
+		if node.btype() == BNodeInternal {
+			kind = "internal"
+		}
+		keys := make([]string, node.nkeys())
+		for i := range node.nkeys() {
+			keys[i] = fmt.Sprintf("<k%d> %s", i, dotKey(node.getKey(i)))
+		}
+		fmt.Fprintf(bw, "\tn%d [label=\"{page %d, %s|{%s}}\"];\n", ptr, ptr, kind, strings.Join(keys, "|"))
+		if node.btype() == BNodeInternal {
+			for i := range node.nkeys() {
+				fmt.Fprintf(bw, "\tn%d:k%d -> n%d;\n", ptr, i, node.getPtr(i))
+			}
+		}
+	})
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotKey formats a key for a record label: quoted as a Go string, cut to
+// dotKeyLen bytes, with the characters records treat specially escaped.
+func dotKey(key []byte) string {
+	cut := ""
+	if len(key) > dotKeyLen {
+		key, cut = key[:dotKeyLen], "..."
+	}
+	q := strconv.Quote(string(key)) + cut
+	var b strings.Builder
+	for _, c := range q {
+		if strings.ContainsRune(`{}|<>"\ `, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package btree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestDumpDOT(t *testing.T) {
+	btt := newBTreeTester()
+	var b strings.Builder
+	is.NoError(t, btt.tree.DumpDOT(&b))
+	is.Equal(t, "digraph btree {\n\tnode [shape=record, fontname=monospace];\n}\n", b.String())
+
+	btt.add("a|b", "v")
+	b.Reset()
+	is.NoError(t, btt.tree.DumpDOT(&b))
+	is.Contains(t, b.String(), fmt.Sprintf(`n%d [label="{page %d, leaf|{<k0> \"a\|b\"}}"];`, btt.tree.Root, btt.tree.Root))
+
+	for i := range 2000 {
+		btt.add(fmt.Sprintf("key%d", fmix32(uint32(i))), strings.Repeat("v", 100))
+	}
+	btt.add(strings.Repeat("k", 40), "v")
+	b.Reset()
+	is.NoError(t, btt.tree.DumpDOT(&b))
+	out := b.String()
+	st := btt.tree.Stats()
+	is.Equal(t, st.Pages(), strings.Count(out, "[label="))
+	is.Equal(t, st.Pages()-1, strings.Count(out, " -> "))
+	is.Equal(t, st.LeafNodes, strings.Count(out, ", leaf|"))
+	is.Contains(t, out, `\"kkkkkkkkkkkkkkkk\"...`)
+}
@@ -1,6 +1,11 @@
 package btree
 
-import "encoding/binary"
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"slices"
+)
 
 // FreeListData is the serialisable, snapshot-able part of the free list.
 // kv copies this into each transaction so changes can be committed atomically.
@@ -22,11 +27,16 @@ type FreeList struct {
 	version   uint64   // version of the current transaction
 	minReader uint64   // oldest reader version (pages freed after this are unsafe to reuse)
 	freed     []uint64 // pages queued for release by the current transaction
+	maxFreed  uint64   // highest page Add put on the list
+	maxReused uint64   // highest version of the pages Pop handed out
 	store     FreeListStore
 }
 
 // NewFreeList wires the store into a FreeList ready for use in a transaction.
+// The node cache is copied, so that concurrent transactions may start from
+// the same data.
 func NewFreeList(data FreeListData, version, minReader uint64, store FreeListStore) *FreeList {
+	data.nodes = slices.Clone(data.nodes)
 	return &FreeList{
 		FreeListData: data,
 		version:      version,
@@ -138,6 +148,7 @@ func flPop1(fl *FreeList) uint64 {
 	}
 	fl.offset++
 	fl.total--
+	fl.maxReused = max(fl.maxReused, ver)
 
 	for len(fl.nodes) > 0 && fl.offset == flnSize(fl.store.PageGet(fl.nodes[0])) {
 		fl.offset = 0
@@ -211,12 +222,121 @@ func (fl *FreeList) Add(freed []uint64) {
 			fl.freed = append(prepend, fl.freed...)
 		}
 
+		fl.maxFreed = slices.Max(fl.freed)
 		flPush(fl, fl.freed, version, reuse)
 	}
 
+	flStoreTotal(fl)
+}
+
+// flStoreTotal records the total in the head node.
+func flStoreTotal(fl *FreeList) {
 	if fl.Head != 0 {
-		flnSetTotal(fl.store.PageGet(fl.Head), uint64(fl.total))
+		// The head may come from the store's read cache, where an in-place
+		// change is not persisted: write the new total through PageUse.
+		node := BNode{bytes.Clone(fl.store.PageGet(fl.Head).Data)}
+		flnSetTotal(node, uint64(fl.total))
+		fl.store.PageUse(fl.Head, node)
+	}
+}
+
+// MaxFreed returns the highest page Add put on the list: freed by the
+// transaction or given up by the list itself. 0 if there was none.
+func (fl *FreeList) MaxFreed() uint64 {
+	return fl.maxFreed
+}
+
+// MaxReused returns the highest version among the pages taken off the list
+// for reuse: readers of older versions may still see one of them. 0 if none
+// was taken.
+func (fl *FreeList) MaxReused() uint64 {
+	return fl.maxReused
+}
+
+// TruncateTail takes off the list the run of free pages that ends a file of
+// npages pages and returns the page count without them, for the caller to
+// shorten the file. It takes nothing unless at least min pages can go. Only
+// pages no reader can see are taken: pending reports that the page below the
+// returned count is free but still visible to one.
+//
+// The list is rewritten through PageUse, in pages taken from the list itself,
+// so no other transaction may be reading it. Entries keep their versions, and
+// the pages of the old nodes are listed as free. Call it instead of Add in a
+// transaction that changes nothing else.
+func (fl *FreeList) TruncateTail(npages, min uint64) (cut uint64, pending bool) {
+	fl.loadCache()
+	if fl.Head == 0 {
+		return npages, false
+	}
+	type entry struct{ ptr, ver uint64 }
+	var entries []entry
+	spare := map[uint64]bool{} // pages no one can see: they may be cut
+	visible := map[uint64]bool{}
+	for i, nptr := range fl.nodes {
+		node := fl.store.PageGet(nptr)
+		start := 0
+		if i == 0 {
+			start = fl.offset
+		}
+		for j := start; j < flnSize(node); j++ {
+			ptr, ver := flnItem(node, j)
+			entries = append(entries, entry{ptr, ver})
+			if versionBefore(fl.minReader, ver) {
+				visible[ptr] = true
+			} else {
+				spare[ptr] = true
+			}
+		}
+		entries = append(entries, entry{nptr, fl.minReader})
+		spare[nptr] = true
+	}
+
+	cut = npages
+	for cut > 1 && spare[cut-1] {
+		cut--
+	}
+	if npages-cut < max(min, 1) {
+		return npages, visible[cut-1]
+	}
+
+	kept := slices.DeleteFunc(slices.Clone(entries), func(e entry) bool { return e.ptr >= cut })
+	if len(kept) == 1 {
+		// A lone page would make a node without entries, which the list
+		// cannot hold: keep the first page cut as its entry.
+		cut++
+		kept = slices.DeleteFunc(entries, func(e entry) bool { return e.ptr >= cut })
+		if npages-cut < max(min, 1) {
+			return npages, false
+		}
+	}
+	entries = kept
+
+	// The new nodes go into the first spare pages left; the oldest entries
+	// go to the tail, where Pop takes them first.
+	slices.SortStableFunc(entries, func(a, b entry) int { return cmp.Compare(a.ver, b.ver) })
+	nnodes := 0
+	for nnodes*FreeListCap < len(entries)-nnodes {
+		nnodes++
 	}
+	var nodes, ptrs, versions []uint64
+	for _, e := range entries {
+		if len(nodes) < nnodes && spare[e.ptr] {
+			nodes = append(nodes, e.ptr)
+		} else {
+			ptrs = append(ptrs, e.ptr)
+			versions = append(versions, e.ver)
+		}
+	}
+	if len(nodes) < nnodes {
+		// Every page left is still visible: there is nowhere to write the
+		// new list yet.
+		return npages, true
+	}
+
+	fl.FreeListData = FreeListData{}
+	flPush(fl, ptrs, versions, nodes)
+	flStoreTotal(fl)
+	return cut, visible[cut-1]
 }
 
 func flPush(fl *FreeList, freed []uint64, version []uint64, reuse []uint64) {
@@ -254,3 +374,74 @@ func flPush(fl *FreeList, freed []uint64, version []uint64, reuse []uint64) {
 		fl.nodes = append(fl.nodes, fl.Head)
 	}
 }
+
+// FreeListTotal returns the number of pages on the free list whose head
+// node is head, as recorded in that node.
+func FreeListTotal(store PageStore, head uint64) int {
+	if head == 0 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint64(store.PageGet(head).Data[4:]))
+}
+
+// FreeListWalk calls fn for every page on the free list at head of a file of
+// npages pages, with the version that freed it.
+func FreeListWalk(store PageStore, head, npages uint64, fn func(ptr, version uint64)) {
+	c := checker{store: store, npages: npages, used: make([]byte, npages), free: fn}
+	c.freeList(head)
+}
+
+// FreeListNodes returns the pages holding the free list at head of a file of
+// npages pages, in page order.
+func FreeListNodes(store PageStore, head, npages uint64) []uint64 {
+	c := checker{store: store, npages: npages, used: make([]byte, npages)}
+	c.freeList(head)
+	var nodes []uint64
+	for ptr, use := range c.used {
+		if use == useFreeListNode {
+			nodes = append(nodes, uint64(ptr))
+		}
+	}
+	return nodes
+}
+
+// FreeListChain returns the nodes of the free list at head of a file of
+// npages pages from the head towards the tail, as far as FreeList follows
+// them: until the total recorded in the head is covered, or a damaged node,
+// which problems describes.
+func FreeListChain(store PageStore, head, npages uint64) (nodes []uint64, problems []Problem) {
+	c := checker{store: store, npages: npages, used: make([]byte, npages)}
+	c.freeList(head)
+	return c.chain, c.report.Problems
+}
+
+// FreeStats describes the free list of a file, as returned by
+// FreeListStats.
+type FreeStats struct {
+	Pages     uint64 // pages in the file, the master page included
+	FreePages int    // pages listed as free
+	Nodes     int    // pages holding the list itself
+	Reusable  int    // free pages no reader can see any more
+	TailPages int    // free pages at the end of the file
+}
+
+// FreeListStats walks the free list at head of a file of npages pages. Free
+// pages released before minReader, the version of the oldest reader, are
+// Reusable by the next commit. TailPages counts the run of free pages that
+// ends the file: truncating the file would give them back to the OS.
+func FreeListStats(store PageStore, head, npages, minReader uint64) FreeStats {
+	c := checker{store: store, npages: npages, used: make([]byte, npages)}
+	st := FreeStats{Pages: npages}
+	c.free = func(_, version uint64) {
+		if !versionBefore(minReader, version) {
+			st.Reusable++
+		}
+	}
+	c.freeList(head)
+	st.FreePages = c.report.FreePages
+	st.Nodes = c.report.FreeListNodes
+	for ptr := npages - 1; ptr > 0 && c.used[ptr] == useFree; ptr-- {
+		st.TailPages++
+	}
+	return st
+}
@@ -0,0 +1,67 @@
+package btree
+
+import "encoding/binary"
+
+// PageInfo is a page as decoded by InspectPage.
+type PageInfo struct {
+	// Type is the type field of the page: BNodeInternal, BNodeLeaf or
+	// BNodeFreeList, or any other value for a page that is none of them,
+	// such as a free page.
+	Type uint16
+
+	// Of a tree node: its keys as stored, the values of a leaf and the
+	// children of an internal node, and the bytes it uses.
+	Keys  [][]byte
+	Vals  [][]byte
+	Ptrs  []uint64
+	Bytes int
+
+	// Of a free-list node: the pages it lists with the versions that freed
+	// them, the total of the list (recorded in the head node only) and the
+	// next node towards the tail.
+	Free     []uint64
+	Versions []uint64
+	Total    uint64
+	Next     uint64
+
+	// Problem says why the page does not decode as its type, or is empty.
+	Problem string
+}
+
+// InspectPage decodes a page of any kind, for debugging. It never panics on
+// a damaged page: what it cannot decode is left out and Problem says why.
+func InspectPage(node BNode) PageInfo {
+	info := PageInfo{Type: node.btype()}
+	switch info.Type {
+	case BNodeInternal, BNodeLeaf:
+		c := checker{}
+		if !c.wellFormed(0, node) {
+			info.Problem = c.report.Problems[0].Msg
+			return info
+		}
+		for i := range node.nkeys() {
+			info.Keys = append(info.Keys, node.getKey(i))
+			if info.Type == BNodeLeaf {
+				info.Vals = append(info.Vals, node.getVal(i))
+			} else {
+				info.Ptrs = append(info.Ptrs, node.getPtr(i))
+			}
+		}
+		info.Bytes = int(node.nbytes())
+	case BNodeFreeList:
+		info.Total = binary.LittleEndian.Uint64(node.Data[4:])
+		info.Next = flnNext(node)
+		if flnSize(node) > FreeListCap {
+			info.Problem = "more entries than fit in a page"
+			return info
+		}
+		for i := range flnSize(node) {
+			ptr, ver := flnItem(node, i)
+			info.Free = append(info.Free, ptr)
+			info.Versions = append(info.Versions, ver)
+		}
+	default:
+		info.Problem = "not a tree or free-list node"
+	}
+	return info
+}
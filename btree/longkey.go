@@ -0,0 +1,248 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Keys longer than the key limit of a tree are stored under a separator:
+// their first maxKey bytes and an 8-byte sequence number, which tells apart
+// the long keys sharing those bytes. The rest of the key overflows into the
+// value of the entry, ahead of the value proper:
+//
+//	key: key[:maxKey] | seq (8 bytes, big-endian)
+//	val: uvarint(len(key)-maxKey) | key[maxKey:] | val
+//
+// Every other stored key is a whole key of at most maxKey bytes, so the two
+// are told apart by length, and a separator sorts against them as its key
+// would. The separators of one prefix sort by sequence number instead: they
+// form a run of entries, the group of the prefix, which lookups scan for
+// the key and iterators sort. Only a tree whose key limit leaves room for
+// the sequence number takes long keys.
+
+const (
+	longSeqSize = 8
+	// longKeyOverhead is the room a long key takes besides its bytes: its
+	// sequence number and the length of its tail, which is under 2^14.
+	longKeyOverhead = longSeqSize + 2
+)
+
+// longKeys reports whether the tree takes keys longer than its key limit.
+func (tree *BTree) longKeys() bool {
+	maxKey, _ := tree.Limits()
+	return maxKey+longSeqSize <= maxKeyLimit
+}
+
+// isLong reports whether skey, a stored key, is the separator of a long key.
+func (tree *BTree) isLong(skey []byte) bool {
+	maxKey, _ := tree.Limits()
+	return len(skey) > maxKey
+}
+
+// CheckSize verifies that the tree takes a key of klen bytes with a value of
+// vlen bytes. A key up to the key limit takes a value up to the value limit.
+// A longer key, if the tree takes long keys, shares the room of both with
+// its value, less longKeyOverhead bytes.
+func (tree *BTree) CheckSize(klen, vlen int) error {
+	maxKey, maxVal := tree.Limits()
+	if klen <= maxKey {
+		if vlen > maxVal {
+			return fmt.Errorf("value too large: %d bytes (max %d)", vlen, maxVal)
+		}
+		return nil
+	}
+	limit := maxKey
+	if tree.longKeys() {
+		limit = maxKey + maxVal - longKeyOverhead
+	}
+	switch {
+	case klen > limit:
+		return fmt.Errorf("key too large: %d bytes (max %d)", klen, limit)
+	case vlen > limit-klen:
+		return fmt.Errorf("value too large: %d bytes (max %d with a key of %d bytes)", vlen, limit-klen, klen)
+	}
+	return nil
+}
+
+// DecodeEntry returns the key and the value of a leaf entry from its stored
+// key and value, which differ for a long key. ok is false if the entry of a
+// long key is malformed. The key of a long key is a new slice; the rest
+// alias skey and sval.
+func (tree *BTree) DecodeEntry(skey, sval []byte) (key, val []byte, ok bool) {
+	if !tree.isLong(skey) {
+		return skey, sval, true
+	}
+	maxKey, _ := tree.Limits()
+	tail, val, ok := longTail(sval)
+	if !ok || len(skey) != maxKey+longSeqSize {
+		return nil, nil, false
+	}
+	return append(skey[:maxKey:maxKey], tail...), val, true
+}
+
+// longTail splits the stored value of a long key into the tail of the key
+// and the value.
+func longTail(sval []byte) (tail, val []byte, ok bool) {
+	n, k := binary.Uvarint(sval)
+	if k <= 0 || n == 0 || n > uint64(len(sval)-k) {
+		return nil, nil, false
+	}
+	return sval[k : k+int(n)], sval[k+int(n):], true
+}
+
+// longFind scans the group of the prefix of key, a long key, for key. It
+// returns the stored entry of key if there is one, and otherwise the
+// sequence number a new key of the group takes.
+func (tree *BTree) longFind(key []byte) (skey, sval []byte, next uint64) {
+	if tree.Root == 0 {
+		return nil, nil, 0
+	}
+	maxKey, _ := tree.Limits()
+	prefix := key[:maxKey]
+	iter := tree.seekLE(prefix)
+	leaf := len(iter.path) - 1
+	for ok := iter.before || iterNext(iter, leaf); ok; ok = iterNext(iter, leaf) {
+		sk, sv := iterDeref(iter)
+		if !tree.isLong(sk) || !bytes.HasPrefix(sk, prefix) {
+			break
+		}
+		next = max(next, binary.BigEndian.Uint64(sk[maxKey:])+1)
+		if tail, _, ok := longTail(sv); ok && bytes.Equal(tail, key[maxKey:]) {
+			return sk, sv, next
+		}
+	}
+	return nil, nil, next
+}
+
+// longInsert is InsertEx for a long key.
+func (tree *BTree) longInsert(req *InsertReq) {
+	maxKey, _ := tree.Limits()
+	skey, sval, next := tree.longFind(req.Key)
+	raw := InsertReq{Mode: req.Mode}
+	if skey != nil {
+		_, req.Old, _ = tree.DecodeEntry(skey, sval)
+		if req.Mode == ModeInsertOnly || bytes.Equal(req.Val, req.Old) {
+			return
+		}
+		raw.Key = skey
+	} else {
+		if req.Mode == ModeUpdateOnly {
+			return
+		}
+		raw.Key = binary.BigEndian.AppendUint64(req.Key[:maxKey:maxKey], next)
+	}
+	tail := req.Key[maxKey:]
+	raw.Val = binary.AppendUvarint(make([]byte, 0, 2+len(tail)+len(req.Val)), uint64(len(tail)))
+	raw.Val = append(append(raw.Val, tail...), req.Val...)
+	tree.insert(&raw)
+	req.Added, req.Updated = raw.Added, raw.Updated
+}
+
+// longDelete is DeleteEx for a long key.
+func (tree *BTree) longDelete(req *DeleteReq) bool {
+	skey, sval, _ := tree.longFind(req.Key)
+	if skey == nil {
+		return false
+	}
+	_, req.Old, _ = tree.DecodeEntry(skey, sval)
+	return tree.delete(&DeleteReq{Key: skey})
+}
+
+// longGet is Get for a long key.
+func (tree *BTree) longGet(key []byte) ([]byte, bool) {
+	skey, sval, _ := tree.longFind(key)
+	if skey == nil {
+		return nil, false
+	}
+	_, val, _ := tree.DecodeEntry(skey, sval)
+	return val, true
+}
+
+// --- iteration ---
+
+// entry is a decoded leaf entry.
+type entry struct {
+	key, val []byte
+}
+
+// enterGroup makes the iterator step through the group of the current entry
+// in key order when that entry is a long key, starting from the smallest
+// key of the group if first, else from the largest. The cursor in the tree
+// moves to the last entry of the group.
+func (iter *BIter) enterGroup(first bool) {
+	iter.group = nil
+	if !rawValid(iter) {
+		return
+	}
+	tree := iter.tree
+	skey, _ := iterDeref(iter)
+	if !tree.isLong(skey) {
+		return
+	}
+	maxKey, _ := tree.Limits()
+	prefix := bytes.Clone(skey[:maxKey])
+	member := func() bool {
+		if !rawValid(iter) {
+			return false
+		}
+		sk, _ := iterDeref(iter)
+		return tree.isLong(sk) && bytes.HasPrefix(sk, prefix)
+	}
+
+	// Back to the first entry of the group, then along the group to its
+	// last entry.
+	leaf := len(iter.path) - 1
+	for iterPrev(iter, leaf) {
+		if !member() {
+			iterNext(iter, leaf)
+			break
+		}
+	}
+	var group []entry
+	for {
+		key, val, ok := tree.DecodeEntry(iterDeref(iter))
+		assert(ok)
+		group = append(group, entry{key, val})
+		if !iterNext(iter, leaf) || !member() {
+			iterPrev(iter, leaf)
+			break
+		}
+	}
+	slices.SortFunc(group, func(a, b entry) int { return bytes.Compare(a.key, b.key) })
+	iter.group, iter.gpos = group, 0
+	if !first {
+		iter.gpos = len(group) - 1
+	}
+}
+
+// seekLELong is SeekLE for a long key: the group of its prefix follows the
+// entry at or before the prefix, or starts the tree, and holds the answer
+// unless all its keys are greater.
+func (tree *BTree) seekLELong(key []byte) *BIter {
+	maxKey, _ := tree.Limits()
+	before := tree.seekLE(key[:maxKey])
+	if tree.Root == 0 {
+		return before
+	}
+	iter := before.Clone()
+	iter.before = false
+	if before.before || iterNext(iter, len(iter.path)-1) {
+		iter.enterGroup(true)
+		if iter.group != nil && bytes.HasPrefix(iter.group[0].key, key[:maxKey]) {
+			n, found := slices.BinarySearchFunc(iter.group, key, func(e entry, key []byte) int {
+				return bytes.Compare(e.key, key)
+			})
+			if found {
+				n++
+			}
+			if n > 0 {
+				iter.gpos = n - 1
+				return iter
+			}
+		}
+	}
+	before.enterGroup(false)
+	return before
+}
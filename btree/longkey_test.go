@@ -0,0 +1,166 @@
+package btree
+
+import (
+	"bytes"
+	"maps"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestLongKeys(t *testing.T) {
+	btt := newBTreeTester()
+	tree := &btt.tree
+	rng := rand.New(rand.NewSource(1))
+	word := func(n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = "xyz"[rng.Intn(3)]
+		}
+		return string(b)
+	}
+
+	// Many long keys share a few prefixes, some of them prefixes of each
+	// other, among keys up to the limit.
+	prefixes := []string{strings.Repeat("a", MaxKeySize), strings.Repeat("a", MaxKeySize-1) + "b", strings.Repeat("c", MaxKeySize)}
+	ref := map[string]string{}
+	for i := range 600 {
+		var key string
+		switch i % 4 {
+		case 0:
+			key = prefixes[0][:1+rng.Intn(MaxKeySize)]
+		case 3:
+			key = prefixes[rng.Intn(3)] + word(1+rng.Intn(3))
+		default:
+			key = prefixes[rng.Intn(3)] + word(1+rng.Intn(2000))
+		}
+		val := word(rng.Intn(MaxKeySize + MaxValSize - longKeyOverhead - len(key) + 1))
+		if len(key) <= MaxKeySize {
+			val = val[:min(len(val), MaxValSize)]
+		}
+		tree.Insert([]byte(key), []byte(val))
+		ref[key] = val
+	}
+	check := func() {
+		keys := slices.Sorted(maps.Keys(ref))
+		for k, v := range ref {
+			val, ok := tree.Get([]byte(k))
+			is.True(t, ok)
+			is.Equal(t, v, string(val))
+		}
+		var got []string
+		for iter := tree.First(); iter.Valid(); iter.Next() {
+			k, v := iter.Deref()
+			is.Equal(t, ref[string(k)], string(v))
+			got = append(got, string(k))
+		}
+		is.Equal(t, keys, got)
+		got = got[:0]
+		for iter := tree.Last(); iter.Valid(); iter.Prev() {
+			k, _ := iter.Deref()
+			got = append(got, string(k))
+		}
+		slices.Reverse(got)
+		is.Equal(t, keys, got)
+
+		// Seeks land where a search of the sorted keys does.
+		for i := range 300 {
+			probe := keys[rng.Intn(len(keys))]
+			switch i % 3 {
+			case 1:
+				probe = probe[:len(probe)-1] + "y"
+			case 2:
+				probe = probe[:rng.Intn(len(probe))+1]
+			}
+			ge, found := slices.BinarySearch(keys, probe)
+			gt, le := ge, ge-1
+			if found {
+				gt, le = ge+1, ge
+			}
+			for cmp, want := range map[int]int{CmpGE: ge, CmpGT: gt, CmpLE: le, CmpLT: ge - 1} {
+				iter := tree.Seek([]byte(probe), cmp)
+				if want < 0 || want >= len(keys) {
+					is.False(t, iter.Valid())
+					continue
+				}
+				is.True(t, iter.Valid())
+				k, _ := iter.Deref()
+				is.Equal(t, keys[want], string(k), "cmp %d", cmp)
+			}
+		}
+		_, ok := tree.Get([]byte(prefixes[0] + "w"))
+		is.False(t, ok)
+		_, ok = tree.Summary().Get(btt.store, []byte(keys[len(keys)-1]))
+		is.True(t, ok)
+	}
+	check()
+
+	// Updates and deletes find the key among those of its prefix.
+	var long []string
+	for k := range ref {
+		if len(k) > MaxKeySize {
+			long = append(long, k)
+		}
+	}
+	slices.Sort(long)
+	for i, k := range long {
+		switch i % 3 {
+		case 0:
+			req := &InsertReq{Key: []byte(k), Val: []byte("new")}
+			tree.InsertEx(req)
+			is.True(t, req.Updated)
+			is.False(t, req.Added)
+			is.Equal(t, ref[k], string(req.Old))
+			ref[k] = "new"
+		case 1:
+			req := &DeleteReq{Key: []byte(k)}
+			is.True(t, tree.DeleteEx(req))
+			is.Equal(t, ref[k], string(req.Old))
+			delete(ref, k)
+		}
+	}
+	is.False(t, tree.Delete([]byte(prefixes[0]+"w")))
+	req := &InsertReq{Key: []byte(prefixes[0] + "w"), Mode: ModeUpdateOnly}
+	tree.InsertEx(req)
+	is.False(t, req.Updated)
+	check()
+
+	// The entries as stored decode to the keys.
+	skeys, svals := btt.dump()
+	decoded := map[string]string{}
+	for i := range skeys {
+		key, val, ok := tree.DecodeEntry([]byte(skeys[i]), []byte(svals[i]))
+		is.True(t, ok)
+		decoded[string(key)] = string(val)
+	}
+	is.Equal(t, ref, decoded)
+}
+
+func TestLongKeySizes(t *testing.T) {
+	tree := &BTree{}
+	limit := MaxKeySize + MaxValSize - longKeyOverhead
+	is.NoError(t, tree.CheckSize(MaxKeySize, MaxValSize))
+	is.NoError(t, tree.CheckSize(limit, 0))
+	is.NoError(t, tree.CheckSize(2000, limit-2000))
+	is.ErrorContains(t, tree.CheckSize(limit+1, 0), "key too large")
+	is.ErrorContains(t, tree.CheckSize(2000, limit-1999), "value too large")
+	is.ErrorContains(t, tree.CheckSize(10, MaxValSize+1), "value too large")
+
+	// A key limit without room for the sequence number takes no long keys.
+	tree = &BTree{MaxKey: maxKeyLimit, MaxVal: maxEntrySize - maxKeyLimit}
+	is.NoError(t, tree.CheckSize(maxKeyLimit, 0))
+	is.ErrorContains(t, tree.CheckSize(maxKeyLimit+1, 0), "key too large")
+
+	// A malformed entry of a long key does not decode.
+	tree = &BTree{}
+	skey := bytes.Repeat([]byte("k"), MaxKeySize+longSeqSize)
+	_, _, ok := tree.DecodeEntry(skey, []byte{5, 'a'})
+	is.False(t, ok)
+	key, val, ok := tree.DecodeEntry(skey, []byte{1, 'a', 'v'})
+	is.True(t, ok)
+	is.Equal(t, string(skey[:MaxKeySize])+"a", string(key))
+	is.Equal(t, "v", string(val))
+}
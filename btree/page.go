@@ -14,6 +14,17 @@ type PageStore interface {
 	PageDel(ptr uint64)
 }
 
+// Prefetcher is an optional interface of a PageStore that can start reading
+// pages before they are needed. Iterators use it to read ahead the leaves
+// they are about to step into.
+type Prefetcher interface {
+	// ReadAhead returns how many leaves an iterator should have requested
+	// ahead of the one it is on; 0 turns read-ahead off.
+	ReadAhead() int
+	// PagePrefetch starts reading the pages ptrs, without waiting for them.
+	PagePrefetch(ptrs []uint64)
+}
+
 // FreeListStore is the interface FreeList requires from its storage backend.
 // It extends PageStore with PageUse, which rewrites an existing page in-place
 // (used when the free list recycles its own nodes).
@@ -0,0 +1,17 @@
+package btree
+
+// TreePages calls fn for every page of the tree at root, parents before
+// their children. Only internal nodes are read.
+func TreePages(store PageStore, root uint64, fn func(ptr uint64)) {
+	if root == 0 {
+		return
+	}
+	fn(root)
+	node := store.PageGet(root)
+	if node.btype() != BNodeInternal {
+		return
+	}
+	for i := range node.nkeys() {
+		TreePages(store, node.getPtr(i), fn)
+	}
+}
@@ -0,0 +1,29 @@
+package btree
+
+import (
+	"fmt"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestTreePages(t *testing.T) {
+	TreePages(nil, 0, func(uint64) { t.Fatal("empty tree has pages") })
+
+	btt := newBTreeTester()
+	for i := range 5000 {
+		btt.add(fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("vvv%d", i))
+	}
+	var pages []uint64
+	seen := map[uint64]bool{}
+	TreePages(btt.store, btt.tree.Root, func(ptr uint64) {
+		is.False(t, seen[ptr], ptr)
+		seen[ptr] = true
+		pages = append(pages, ptr)
+	})
+	is.Equal(t, btt.tree.Root, pages[0])
+	is.Len(t, pages, len(btt.store.pages))
+	for ptr := range btt.store.pages {
+		is.True(t, seen[ptr], ptr)
+	}
+}
@@ -0,0 +1,66 @@
+package btree
+
+// TreeStats describes the shape of a tree, as returned by BTree.Stats.
+type TreeStats struct {
+	Height        int   // levels of the tree
+	InternalNodes int   // pages holding internal nodes
+	LeafNodes     int   // pages holding leaves
+	Keys          int64 // entries in the leaves
+
+	// Levels describes each level of the tree, from the root down.
+	Levels []LevelStats
+
+	UsedBytes    int64 // bytes of the nodes in use: headers, pointers, offsets and entries
+	PayloadBytes int64 // bytes of the keys and values of the leaves
+}
+
+// LevelStats describes one level of a tree.
+type LevelStats struct {
+	Nodes int
+	Keys  int64   // entries in the nodes of the level
+	Fill  float64 // mean fraction of a page its nodes use
+}
+
+// Pages returns the number of pages of the tree.
+func (s TreeStats) Pages() int {
+	return s.InternalNodes + s.LeafNodes
+}
+
+// Utilization returns the fraction of the bytes of the tree's pages that
+// its nodes use. A low value points to pages left part empty by splits and
+// deletes.
+func (s TreeStats) Utilization() float64 {
+	if s.Pages() == 0 {
+		return 0
+	}
+	return float64(s.UsedBytes) / float64(s.Pages()*PageSize)
+}
+
+// Stats walks the whole tree, reading every page, and reports its shape.
+func (tree *BTree) Stats() TreeStats {
+	var st TreeStats
+	tree.walk(func(_ uint64, depth int, node BNode) {
+		if depth == len(st.Levels) {
+			st.Levels = append(st.Levels, LevelStats{})
+		}
+		level := &st.Levels[depth]
+		level.Nodes++
+		level.Keys += int64(node.nkeys())
+		level.Fill += float64(node.nbytes()) / PageSize
+		st.UsedBytes += int64(node.nbytes())
+		if node.btype() == BNodeInternal {
+			st.InternalNodes++
+			return
+		}
+		st.LeafNodes++
+		st.Keys += int64(node.nkeys())
+		for i := range node.nkeys() {
+			st.PayloadBytes += int64(len(node.getKey(i)) + len(node.getVal(i)))
+		}
+	})
+	for i := range st.Levels {
+		st.Levels[i].Fill /= float64(st.Levels[i].Nodes)
+	}
+	st.Height = len(st.Levels)
+	return st
+}
@@ -0,0 +1,52 @@
+package btree
+
+import (
+	"fmt"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestTreeStats(t *testing.T) {
+	btt := newBTreeTester()
+	st := btt.tree.Stats()
+	is.Zero(t, st.Height)
+	is.Zero(t, st.Pages())
+	is.Zero(t, st.Utilization())
+
+	payload := int64(0)
+	for i := range 5000 {
+		key, val := fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("vvv%d", i)
+		btt.add(key, val)
+		payload += int64(len(key) + len(val))
+	}
+	st = btt.tree.Stats()
+	is.Equal(t, btt.tree.Height(), st.Height)
+	is.Len(t, st.Levels, st.Height)
+	is.Equal(t, len(btt.store.pages), st.Pages())
+	is.Equal(t, int64(5000), st.Keys)
+	is.Equal(t, payload, st.PayloadBytes)
+
+	// Each level holds an entry per node of the level below.
+	is.Equal(t, 1, st.Levels[0].Nodes)
+	for i, level := range st.Levels {
+		is.Greater(t, level.Fill, 0.0)
+		is.LessOrEqual(t, level.Fill, 1.0)
+		if i+1 < len(st.Levels) {
+			is.Equal(t, int64(st.Levels[i+1].Nodes), level.Keys)
+		}
+	}
+	leaves := st.Levels[st.Height-1]
+	is.Equal(t, st.LeafNodes, leaves.Nodes)
+	is.Equal(t, st.Keys, leaves.Keys)
+	is.Greater(t, st.UsedBytes, st.PayloadBytes)
+	is.InDelta(t, 0.75, st.Utilization(), 0.25)
+
+	// Deleting most keys leaves the pages emptier.
+	for i := range 5000 {
+		if i%10 != 0 {
+			btt.del(fmt.Sprintf("key%d", fmix32(uint32(i))))
+		}
+	}
+	is.Less(t, btt.tree.Stats().Utilization(), st.Utilization())
+}
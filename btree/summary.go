@@ -0,0 +1,126 @@
+package btree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Summary is a sparse in-memory index of a tree: the first key and page
+// number of every leaf, in key order. With it a point lookup reads exactly
+// one page (the leaf) instead of one per level. A Summary describes the tree
+// it was built from and must be rebuilt after the tree changes.
+type Summary struct {
+	keys [][]byte
+	ptrs []uint64
+	tree BTree // the tree it describes, without its store
+}
+
+// Summary builds the summary of tree. Only internal nodes are read: the
+// key an internal node stores for a child is the child's first key.
+func (tree *BTree) Summary() *Summary {
+	s := &Summary{tree: BTree{Root: tree.Root, MaxKey: tree.MaxKey, MaxVal: tree.MaxVal}}
+	if tree.Root == 0 {
+		return s
+	}
+	root := tree.Store.PageGet(tree.Root)
+	if root.btype() == BNodeLeaf {
+		s.add(root.getKey(0), tree.Root)
+		return s
+	}
+
+	// All leaves are at the same depth: measure it down the left edge.
+	height := 1
+	for node := root; node.btype() == BNodeInternal; height++ {
+		node = tree.Store.PageGet(node.getPtr(0))
+	}
+
+	var walk func(node BNode, level int)
+	walk = func(node BNode, level int) {
+		for i := range node.nkeys() {
+			if level == height-1 {
+				s.add(node.getKey(i), node.getPtr(i))
+			} else {
+				walk(tree.Store.PageGet(node.getPtr(i)), level+1)
+			}
+		}
+	}
+	walk(root, 1)
+	return s
+}
+
+func (s *Summary) add(key []byte, ptr uint64) {
+	s.keys = append(s.keys, bytes.Clone(key))
+	s.ptrs = append(s.ptrs, ptr)
+}
+
+// Leaves returns the number of leaves covered.
+func (s *Summary) Leaves() int {
+	return len(s.ptrs)
+}
+
+// Get returns the value for key, reading only the leaf that can hold it
+// from store. A long key, whose group may span leaves, is looked up in the
+// tree.
+func (s *Summary) Get(store PageStore, key []byte) ([]byte, bool) {
+	if s.tree.isLong(key) {
+		tree := s.tree
+		tree.Store = store
+		return tree.Get(key)
+	}
+	i := sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], key) > 0 }) - 1
+	if i < 0 {
+		return nil, false
+	}
+	leaf := store.PageGet(s.ptrs[i])
+	if idx, found := leafLookup(leaf, key); found {
+		return leaf.getVal(idx), true
+	}
+	return nil, false
+}
+
+// RangeEstimate is the approximate content of a key range, as returned by
+// Summary.EstimateRange.
+type RangeEstimate struct {
+	Keys   int64 // keys in the range
+	Bytes  int64 // total key and value bytes of those keys
+	Leaves int   // leaves that may hold keys of the range
+	Exact  bool  // every such leaf was read, so Keys and Bytes are exact
+}
+
+// EstimateRange estimates the keys in [start, end) without reading the whole
+// range: it counts the leaves that may hold them from the summary, reads up
+// to samples of those leaves spread evenly across them, and scales what it
+// finds by the number of leaves. A nil end means no upper bound.
+func (s *Summary) EstimateRange(store PageStore, start, end []byte, samples int) RangeEstimate {
+	// Leaf i holds the keys in [s.keys[i], s.keys[i+1]).
+	lo := max(sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], start) > 0 })-1, 0)
+	hi := len(s.keys)
+	if end != nil {
+		hi = sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], end) >= 0 })
+	}
+	est := RangeEstimate{Leaves: max(hi-lo, 0), Exact: true}
+	if est.Leaves == 0 {
+		return est
+	}
+	samples = max(samples, 1)
+	if est.Leaves > samples {
+		est.Exact = false
+	}
+
+	var keys, nbytes, read int64
+	for i := range min(samples, est.Leaves) {
+		leaf := store.PageGet(s.ptrs[lo+i*est.Leaves/min(samples, est.Leaves)])
+		for j := range leaf.nkeys() {
+			key := leaf.getKey(j)
+			if bytes.Compare(key, start) < 0 || end != nil && bytes.Compare(key, end) >= 0 {
+				continue
+			}
+			keys++
+			nbytes += int64(len(key) + len(leaf.getVal(j)))
+		}
+		read++
+	}
+	est.Keys = keys * int64(est.Leaves) / read
+	est.Bytes = nbytes * int64(est.Leaves) / read
+	return est
+}
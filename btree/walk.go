@@ -0,0 +1,93 @@
+package btree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- read-only node accessors, for Walk ---
+
+// Type returns BNodeInternal or BNodeLeaf.
+func (node BNode) Type() uint16 {
+	return node.btype()
+}
+
+// NKeys returns the number of keys of the node.
+func (node BNode) NKeys() int {
+	return int(node.nkeys())
+}
+
+// Key returns key i of the node, as stored: see BTree.DecodeEntry for the
+// keys of a leaf. The slice aliases the page.
+func (node BNode) Key(i int) []byte {
+	return node.getKey(uint16(i))
+}
+
+// Val returns the value of entry i of a leaf, as stored. The slice aliases
+// the page.
+func (node BNode) Val(i int) []byte {
+	return node.getVal(uint16(i))
+}
+
+// Ptr returns the page of child i of an internal node.
+func (node BNode) Ptr(i int) uint64 {
+	return node.getPtr(uint16(i))
+}
+
+// Walk calls fn for every node of the tree, depth first, parents before
+// their children and children in key order. level is the depth of the
+// node: 0 for the root. It reads every page of the tree.
+func (tree *BTree) Walk(fn func(level int, node BNode)) {
+	tree.walk(func(_ uint64, level int, node BNode) { fn(level, node) })
+}
+
+func (tree *BTree) walk(fn func(ptr uint64, level int, node BNode)) {
+	var visit func(ptr uint64, level int)
+	visit = func(ptr uint64, level int) {
+		node := tree.Store.PageGet(ptr)
+		fn(ptr, level, node)
+		if node.btype() == BNodeInternal {
+			for i := range node.nkeys() {
+				visit(node.getPtr(i), level+1)
+			}
+		}
+	}
+	if tree.Root != 0 {
+		visit(tree.Root, 0)
+	}
+}
+
+// debugLen is how many bytes of a key or value DebugString shows.
+const debugLen = 32
+
+// DebugString returns the tree as indented text, a line per node and one
+// per leaf entry, with keys and values as stored, quoted and cut to
+// debugLen bytes. It is meant for small trees in tests and debugging
+// sessions.
+func (tree *BTree) DebugString() string {
+	if tree.Root == 0 {
+		return "empty\n"
+	}
+	var b strings.Builder
+	tree.walk(func(ptr uint64, level int, node BNode) {
+		indent := strings.Repeat("  ", level)
+		if node.btype() == BNodeInternal {
+			fmt.Fprintf(&b, "%spage %d: internal, %d keys\n", indent, ptr, node.nkeys())
+			return
+		}
+		fmt.Fprintf(&b, "%spage %d: leaf, %d keys\n", indent, ptr, node.nkeys())
+		for i := range node.nkeys() {
+			fmt.Fprintf(&b, "%s  %s = %s\n", indent, debugQuote(node.getKey(i)), debugQuote(node.getVal(i)))
+		}
+	})
+	return b.String()
+}
+
+// debugQuote quotes data as a Go string, cut to debugLen bytes.
+func debugQuote(data []byte) string {
+	if len(data) > debugLen {
+		return strconv.Quote(string(data[:debugLen])) + "..."
+	}
+	return strconv.Quote(string(data))
+}
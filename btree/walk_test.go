@@ -0,0 +1,40 @@
+package btree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestWalk(t *testing.T) {
+	btt := newBTreeTester()
+	btt.tree.Walk(func(int, BNode) { t.Fatal("empty tree has nodes") })
+	is.Equal(t, "empty\n", btt.tree.DebugString())
+
+	btt.add("a", "1")
+	btt.add("b\n", strings.Repeat("v", 40))
+	is.Equal(t, fmt.Sprintf("page %d: leaf, 2 keys\n", btt.tree.Root)+
+		"  \"a\" = \"1\"\n"+
+		"  \"b\\n\" = \""+strings.Repeat("v", debugLen)+"\"...\n",
+		btt.tree.DebugString())
+
+	for i := range 3000 {
+		btt.add(fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("vvv%d", i))
+	}
+	height := btt.tree.Height()
+	var levels []int
+	btt.tree.Walk(func(level int, node BNode) {
+		levels = append(levels, level)
+		is.Equal(t, node.Type() == BNodeLeaf, level == height-1)
+		if node.Type() == BNodeInternal {
+			for i := range node.NKeys() {
+				is.NotZero(t, node.Ptr(i))
+			}
+		}
+	})
+	is.Equal(t, 0, levels[0])
+	is.Len(t, levels, btt.tree.Stats().Pages())
+	is.Equal(t, len(levels), strings.Count(btt.tree.DebugString(), "page "))
+}
@@ -2,30 +2,135 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/kv"
 	"github.com/MHS-20/ElkDB/network"
 	"github.com/MHS-20/ElkDB/queries"
 	table "github.com/MHS-20/ElkDB/tables"
+	"github.com/MHS-20/ElkDB/tlsconfig"
 )
 
 func main() {
 	// Flags
 	remote := flag.String("remote", "", "connect to a running server, e.g. localhost:5433")
 	dbPath := flag.String("db", "elkdb.db", "path to the local ElkDB data file (local mode only)")
+	user := flag.String("user", "", "log in to the server as this user; the password is read from $ELKDB_PASSWORD or prompted for")
+	useTLS := flag.Bool("tls", false, "connect to the server over TLS (implied by the other -tls-* flags)")
+	tlsCA := flag.String("tls-ca", "", "verify the server against the CAs in this PEM file instead of the system roots")
+	tlsCert := flag.String("tls-cert", "", "client certificate to present, for servers started with -tls-client-ca")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: elkdb [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: elkdb [flags]\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] dump [file]\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] load [file]\n")
+		fmt.Fprintf(os.Stderr, "       elkdb convert <in> <out>\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] stats\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] check\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] compact\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] snapshot <out>\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] backup [-since version] [file]\n")
+		fmt.Fprintf(os.Stderr, "       elkdb restore [file] <out>\n")
+		fmt.Fprintf(os.Stderr, "       elkdb apply [file] <db>\n")
+		fmt.Fprintf(os.Stderr, "       elkdb recover [-version v | -time t] <db> <archive>\n")
+		fmt.Fprintf(os.Stderr, "       elkdb salvage <in> <out>\n")
+		fmt.Fprintf(os.Stderr, "       elkdb inspect <file> [page N]\n")
+		fmt.Fprintf(os.Stderr, "       elkdb [-db path] users [list | add [-admin] <name> | passwd <name> | grant <name> | revoke <name> | del <name>]\n\n")
 		fmt.Fprintf(os.Stderr, "  Local mode (default): opens the data file directly.\n")
-		fmt.Fprintf(os.Stderr, "  Remote mode (-remote): connects to an elkdb-server over TCP.\n\n")
+		fmt.Fprintf(os.Stderr, "  Remote mode (-remote): connects to an elkdb-server over TCP,\n")
+		fmt.Fprintf(os.Stderr, "  or over TLS with -tls or any other -tls-* flag.\n")
+		fmt.Fprintf(os.Stderr, "  dump / load: write or read a portable dump of all tables\n")
+		fmt.Fprintf(os.Stderr, "  (stdout / stdin when no file is given).\n")
+		fmt.Fprintf(os.Stderr, "  convert: copy all tables into a new file in this build's format\n")
+		fmt.Fprintf(os.Stderr, "  and verify row counts and checksums. No other process may have\n")
+		fmt.Fprintf(os.Stderr, "  <in> open.\n")
+		fmt.Fprintf(os.Stderr, "  stats: print the file size, free pages, tree height, approximate\n")
+		fmt.Fprintf(os.Stderr, "  table sizes and cache hit rates.\n")
+		fmt.Fprintf(os.Stderr, "  check: verify the B-tree, the free list and the secondary indexes;\n")
+		fmt.Fprintf(os.Stderr, "    exits with status 1 on damage.\n")
+		fmt.Fprintf(os.Stderr, "  compact: rewrite the file without its free pages.\n")
+		fmt.Fprintf(os.Stderr, "  snapshot: copy the last commit into a new data file.\n")
+		fmt.Fprintf(os.Stderr, "  backup / restore: write a page-level backup, or restore one into a\n")
+		fmt.Fprintf(os.Stderr, "  new file (stdout / stdin when no file is given); with -since, only\n")
+		fmt.Fprintf(os.Stderr, "  the pages changed after that version, for apply to lay over a\n")
+		fmt.Fprintf(os.Stderr, "  restored file.\n")
+		fmt.Fprintf(os.Stderr, "  recover: replay the WAL segments archived with DB.WALArchive onto a\n")
+		fmt.Fprintf(os.Stderr, "  restored file, up to a version or an RFC 3339 time.\n")
+		fmt.Fprintf(os.Stderr, "  salvage: copy the rows that can still be read from a damaged file\n")
+		fmt.Fprintf(os.Stderr, "  into a new one.\n")
+		fmt.Fprintf(os.Stderr, "  inspect: print the master page and the free-list chain of a data\n")
+		fmt.Fprintf(os.Stderr, "  file as it is on disk, or the decoded header and entries of a page.\n")
+		fmt.Fprintf(os.Stderr, "  users: manage the users the servers authenticate against; passwords\n")
+		fmt.Fprintf(os.Stderr, "  are read from $ELKDB_PASSWORD or prompted for.\n\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
+	switch flag.Arg(0) {
+	case "dump":
+		runDump(*dbPath, flag.Arg(1))
+		return
+	case "load":
+		runLoad(*dbPath, flag.Arg(1))
+		return
+	case "convert":
+		runConvert(flag.Args()[1:])
+		return
+	case "stats":
+		runStats(*dbPath)
+		return
+	case "compact":
+		runCompact(*dbPath)
+		return
+	case "snapshot":
+		runSnapshot(*dbPath, flag.Arg(1))
+		return
+	case "check":
+		runCheck(*dbPath)
+		return
+	case "backup":
+		runBackup(*dbPath, flag.Args()[1:])
+		return
+	case "restore", "apply":
+		runRestore(flag.Arg(0), flag.Args()[1:])
+		return
+	case "recover":
+		runRecover(flag.Args()[1:])
+		return
+	case "salvage":
+		runSalvage(flag.Args()[1:])
+		return
+	case "inspect":
+		runInspect(flag.Args()[1:])
+		return
+	case "users":
+		runUsers(*dbPath, flag.Args()[1:])
+		return
+	case "":
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	if *remote != "" {
-		runRemote(*remote)
+		var tlsCfg *tls.Config
+		if *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+			cfg, err := tlsconfig.Client(*tlsCA, *tlsCert, *tlsKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			tlsCfg = cfg
+		}
+		runRemote(*remote, tlsCfg, *user)
 	} else {
 		runLocal(*dbPath)
 	}
@@ -50,12 +155,578 @@ func runLocal(path string) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// Dump and load
+// ---------------------------------------------------------------------------
+
+func openDB(path string) *table.DB {
+	db := &table.DB{Path: path}
+	if err := db.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	return db
+}
+
+func runDump(path, out string) {
+	db := openDB(path)
+	defer db.Close()
+
+	w := os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dump: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	err := db.Dump(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runLoad(path, in string) {
+	db := openDB(path)
+	defer db.Close()
+
+	r := os.Stdin
+	if in != "" {
+		f, err := os.Open(in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "load: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := db.Load(bufio.NewReader(r)); err != nil {
+		fmt.Fprintf(os.Stderr, "load: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb convert <in> <out>\n\n")
+		fmt.Fprintf(os.Stderr, "<in> is locked like any open, so stop the servers using it first.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+	if _, err := os.Stat(in); err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(out); err == nil {
+		fmt.Fprintf(os.Stderr, "convert: %s already exists\n", out)
+		os.Exit(1)
+	}
+
+	// The source is only read: open it ReadOnly, so that nothing can be
+	// committed to it, the format migrations of Open included. It is still
+	// locked exclusively, and a WAL left by a crash is replayed into it.
+	src := &table.DB{Path: in, ReadOnly: true}
+	if err := src.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", in, err)
+		os.Exit(1)
+	}
+	defer src.Close()
+	dst := openDB(out)
+	defer dst.Close()
+	sums, err := table.Convert(dst, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		dst.Close()
+		src.Close()
+		os.Exit(1)
+	}
+	for _, sum := range sums {
+		fmt.Fprintf(os.Stderr, "%s: %d rows, checksum %016x\n", sum.Table, sum.Rows, sum.Sum)
+	}
+	fmt.Fprintf(os.Stderr, "converted %s to %s (format version %d, %d-byte pages)\n", in, out, kv.FormatVersion(), btree.PageSize)
+}
+
+// ---------------------------------------------------------------------------
+// Users
+// ---------------------------------------------------------------------------
+
+func runUsers(path string, args []string) {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	admin := fs.Bool("admin", false, "with add: make the user an admin")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb [-db path] users [list | add [-admin] <name> | passwd <name> | grant <name> | revoke <name> | del <name>]\n\n")
+		fs.PrintDefaults()
+	}
+	verb := "list"
+	if len(args) > 0 {
+		verb, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if (verb == "list") != (fs.NArg() == 0) || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	db := openDB(path)
+	defer db.Close()
+	if verb == "list" {
+		r := table.DBReader{}
+		db.BeginRead(&r)
+		users, err := r.Users()
+		db.EndRead(&r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "users: %v\n", err)
+			os.Exit(1)
+		}
+		for _, u := range users {
+			if u.Admin {
+				fmt.Printf("%s\tadmin\n", u.Name)
+			} else {
+				fmt.Println(u.Name)
+			}
+		}
+		return
+	}
+
+	var op func(tx *table.DBTX) error
+	switch verb {
+	case "add":
+		pw := password()
+		op = func(tx *table.DBTX) error { return tx.UserNew(name, pw, *admin) }
+	case "passwd":
+		pw := password()
+		op = func(tx *table.DBTX) error { return tx.UserSetPassword(name, pw) }
+	case "grant", "revoke":
+		op = func(tx *table.DBTX) error { return tx.UserSetAdmin(name, verb == "grant") }
+	case "del":
+		op = func(tx *table.DBTX) error { return tx.UserDelete(name) }
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	tx := table.DBTX{}
+	db.Begin(&tx)
+	err := op(&tx)
+	if err != nil {
+		db.Abort(&tx)
+	} else {
+		err = db.Commit(&tx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "users: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Stats
+// ---------------------------------------------------------------------------
+
+func runStats(path string) {
+	db := openDB(path)
+	defer db.Close()
+	st, err := db.Stats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stats: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "version\t%d\n", st.KV.Version)
+	fmt.Fprintf(w, "file size\t%d bytes\n", st.KV.FileSize)
+	fmt.Fprintf(w, "pages\t%d (%d free)\n", st.KV.Pages, st.KV.FreePages)
+	free := db.FreeStats()
+	fmt.Fprintf(w, "free list\t%d nodes, %d pages reusable, %d at the end of the file\n", free.Nodes, free.Reusable, free.TailPages)
+	fmt.Fprintf(w, "tree height\t%d\n", st.KV.TreeHeight)
+	tree := db.TreeStats()
+	fmt.Fprintf(w, "tree nodes\t%d internal, %d leaves, %.1f%% of their bytes used\n",
+		tree.InternalNodes, tree.LeafNodes, 100*tree.Utilization())
+	fill := make([]string, len(tree.Levels))
+	for i, level := range tree.Levels {
+		fill[i] = fmt.Sprintf("%.1f%%", 100*level.Fill)
+	}
+	fmt.Fprintf(w, "fill by level\t%s\n", strings.Join(fill, " "))
+	fmt.Fprintf(w, "table def cache\t%.1f%% hits\n", 100*st.TableDefs.HitRate())
+	fmt.Fprintf(w, "auth cache\t%.1f%% hits\n", 100*st.Auth.HitRate())
+	fmt.Fprintf(w, "\nTABLE\tROWS\tBYTES\n")
+	for _, t := range st.Tables {
+		approx := "~"
+		if t.Exact {
+			approx = ""
+		}
+		fmt.Fprintf(w, "%s\t%s%d\t%s%d\n", t.Name, approx, t.Rows, approx, t.Bytes)
+	}
+	w.Flush()
+}
+
+func runCheck(path string) {
+	db := openDB(path)
+	defer db.Close()
+	rep := db.Check()
+
+	fmt.Printf("%d pages: %d in the tree (height %d, %d keys), %d free-list nodes, %d free, %d leaked\n",
+		rep.Pages, rep.TreePages, rep.Height, rep.Keys, rep.FreeListNodes, rep.FreePages, rep.Leaked)
+	for _, p := range rep.Problems {
+		fmt.Println(p)
+	}
+	if rep.MoreProblems > 0 {
+		fmt.Printf("... and %d more problems\n", rep.MoreProblems)
+	}
+	if !rep.OK() {
+		db.Close()
+		os.Exit(1)
+	}
+
+	// The tables are only read once the tree is known to be sound.
+	irep, err := db.CheckIndexes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check: %v\n", err)
+		db.Close()
+		os.Exit(1)
+	}
+	fmt.Printf("%d tables, %d indexes: %d rows, %d entries\n", irep.Tables, irep.Indexes, irep.Rows, irep.Entries)
+	for _, p := range irep.Problems {
+		fmt.Println(p)
+	}
+	if irep.MoreProblems > 0 {
+		fmt.Printf("... and %d more problems\n", irep.MoreProblems)
+	}
+	if !irep.OK() {
+		db.Close()
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
+
+func runCompact(path string) {
+	db := openDB(path)
+	defer db.Close()
+	rep, err := db.Compact()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compact: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d pages -> %d pages\n", rep.PagesBefore, rep.PagesAfter)
+}
+
+func runSnapshot(path, out string) {
+	if out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	db := openDB(path)
+	defer db.Close()
+	if err := db.SnapshotTo(out); err != nil {
+		fmt.Fprintf(os.Stderr, "snapshot: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runBackup(path string, args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	since := fs.Int64("since", -1, "write only the pages changed after this version, the one an earlier backup printed")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb [-db path] backup [-since version] [file]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	db := openDB(path)
+	defer db.Close()
+
+	w := os.Stdout
+	if out := fs.Arg(0); out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	var rep kv.BackupReport
+	var err error
+	if *since >= 0 {
+		rep, err = db.BackupSince(uint64(*since), bw)
+	} else {
+		rep, err = db.Backup(bw)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Sync()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "version %d: %d of %d pages\n", rep.Version, rep.Written, rep.Pages)
+}
+
+func runRestore(cmd string, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		if cmd == "apply" {
+			fmt.Fprintf(os.Stderr, "Usage: elkdb apply [file] <db>\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "Usage: elkdb restore [file] <out>\n")
+		}
+		os.Exit(2)
+	}
+	r := os.Stdin
+	if len(args) == 2 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+	restore := kv.RestoreFrom
+	if cmd == "apply" {
+		restore = kv.ApplyBackup
+	}
+	if err := restore(bufio.NewReader(r), args[len(args)-1]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runRecover(args []string) {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	version := fs.Uint64("version", 0, "stop at this version")
+	at := fs.String("time", "", "replay only the commits made at or before this RFC 3339 time")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb recover [-version v | -time t] <db> <archive>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	upto := kv.RecoverTarget{Version: *version}
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339Nano, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "recover: -time: %v\n", err)
+			os.Exit(2)
+		}
+		upto.Time = t
+	}
+	rep, err := kv.Recover(fs.Arg(0), fs.Arg(1), upto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("version %d: %d commits from %d segments", rep.Version, rep.Commits, rep.Segments)
+	if !rep.Time.IsZero() {
+		fmt.Printf(", the last at %s", rep.Time.Format(time.RFC3339Nano))
+	}
+	fmt.Println()
+}
+
+func runSalvage(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb salvage <in> <out>\n")
+		os.Exit(2)
+	}
+	in, out := args[0], args[1]
+	if _, err := os.Stat(out); err == nil {
+		fmt.Fprintf(os.Stderr, "salvage: %s already exists\n", out)
+		os.Exit(1)
+	}
+
+	dst := &kv.KV{Path: out}
+	if err := dst.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", out, err)
+		os.Exit(1)
+	}
+	rep, err := kv.Salvage(in, dst)
+	dst.Close()
+	for _, p := range rep.Check.Problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	if rep.Check.MoreProblems > 0 {
+		fmt.Fprintf(os.Stderr, "... and %d more problems\n", rep.Check.MoreProblems)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	master := "intact"
+	if !rep.Master {
+		master = "damaged"
+	}
+	fmt.Fprintf(os.Stderr, "%d pages scanned (master page %s, %d pages from the WAL)\n", rep.Pages, master, rep.WALPages)
+	fmt.Fprintf(os.Stderr, "%d keys reachable from the root, %d from orphan leaves (%d conflicting)\n", rep.Reachable, rep.Orphans, rep.Conflicts)
+	fmt.Fprintf(os.Stderr, "salvaged %d keys from %s to %s\n", rep.Keys, in, out)
+}
+
+func runInspect(args []string) {
+	if len(args) != 1 && (len(args) != 3 || args[1] != "page") {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb inspect <file> [page N]\n")
+		os.Exit(2)
+	}
+	in, err := kv.Inspect(args[0])
+	if err == nil {
+		err = inspect(in, args[1:])
+		in.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// inspect prints the page given by args ("page N"), or without args the
+// master page and the free-list chain.
+func inspect(in *kv.Inspector, args []string) error {
+	if len(args) == 2 {
+		ptr, err := strconv.ParseUint(args[1], 10, 64)
+		switch {
+		case err != nil:
+			return err
+		case ptr == 0:
+			return printMaster(in)
+		}
+		return printPage(in, ptr)
+	}
+	if err := printMaster(in); err != nil {
+		return err
+	}
+	nodes, problems, err := in.FreeList()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nfree list: %d nodes from the head\n", len(nodes))
+	for _, ptr := range nodes {
+		page, err := in.Page(ptr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  page %d: %d entries, next %d\n", ptr, len(page.Free), page.Next)
+	}
+	for _, p := range problems {
+		fmt.Printf("  %v\n", p)
+	}
+	return nil
+}
+
+// printMaster prints the master page of the file of in.
+func printMaster(in *kv.Inspector) error {
+	m, err := in.Master()
+	if err != nil {
+		return err
+	}
+	sig := "ElkDB"
+	if !m.Signature {
+		sig = "missing"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "file\t%d pages\n", in.Pages)
+	fmt.Fprintf(w, "signature\t%s\n", sig)
+	fmt.Fprintf(w, "format version\t%d (this build: %d)\n", m.FormatVersion, kv.FormatVersion())
+	fmt.Fprintf(w, "root\tpage %d\n", m.Root)
+	fmt.Fprintf(w, "pages in use\t%d\n", m.Pages)
+	fmt.Fprintf(w, "free-list head\tpage %d\n", m.FreeHead)
+	fmt.Fprintf(w, "version\t%d\n", m.Version)
+	fmt.Fprintf(w, "size limits\tkeys %d bytes, values %d bytes\n", m.MaxKey, m.MaxVal)
+	return w.Flush()
+}
+
+// printPage prints the decoded header and entries of page ptr of in.
+func printPage(in *kv.Inspector, ptr uint64) error {
+	page, err := in.Page(ptr)
+	if err != nil {
+		return err
+	}
+	switch page.Type {
+	case btree.BNodeInternal, btree.BNodeLeaf:
+		kind := "leaf"
+		if page.Type == btree.BNodeInternal {
+			kind = "internal node"
+		}
+		fmt.Printf("page %d: %s, %d keys, %d bytes used\n", ptr, kind, len(page.Keys), page.Bytes)
+		for i, key := range page.Keys {
+			if page.Type == btree.BNodeInternal {
+				fmt.Printf("  %d\t%s -> page %d\n", i, quoteCut(key), page.Ptrs[i])
+			} else {
+				fmt.Printf("  %d\t%s = %s\n", i, quoteCut(key), quoteCut(page.Vals[i]))
+			}
+		}
+	case btree.BNodeFreeList:
+		fmt.Printf("page %d: free-list node, %d entries, total %d, next %d\n", ptr, len(page.Free), page.Total, page.Next)
+		for i, free := range page.Free {
+			fmt.Printf("  %d\tpage %d, freed at version %d\n", i, free, page.Versions[i])
+		}
+	default:
+		fmt.Printf("page %d: type %d\n", ptr, page.Type)
+	}
+	if page.Problem != "" {
+		fmt.Printf("  %s\n", page.Problem)
+	}
+	return nil
+}
+
+// quoteCut quotes data as a Go string, cut to 64 bytes.
+func quoteCut(data []byte) string {
+	if len(data) > 64 {
+		return strconv.Quote(string(data[:64])) + "..."
+	}
+	return strconv.Quote(string(data))
+}
+
+// password returns $ELKDB_PASSWORD, or a line read from stdin after a
+// prompt. The input is echoed: prefer the variable on a shared terminal.
+func password() string {
+	if pw := os.Getenv("ELKDB_PASSWORD"); pw != "" {
+		return pw
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	// Read byte by byte so that nothing after the line is consumed from a
+	// stdin the REPL reads next.
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 0 || err != nil || b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return strings.TrimSuffix(string(line), "\r")
+}
+
 // ---------------------------------------------------------------------------
 // Remote mode — REPL over an ElkWire connection
 // ---------------------------------------------------------------------------
 
-func runRemote(addr string) {
-	conn, err := network.Dial(addr)
+func runRemote(addr string, tlsCfg *tls.Config, user string) {
+	dial := network.Dial
+	if tlsCfg != nil {
+		dial = func(addr string) (*network.Conn, error) { return network.DialTLS(addr, tlsCfg) }
+	}
+	conn, err := dial(addr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to connect to %s: %v\n", addr, err)
 		os.Exit(1)
@@ -66,6 +737,12 @@ func runRemote(addr string) {
 		fmt.Fprintf(os.Stderr, "server unreachable: %v\n", err)
 		os.Exit(1)
 	}
+	if user != "" {
+		if err := conn.Auth(user, password()); err != nil {
+			fmt.Fprintf(os.Stderr, "login failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Fprintf(os.Stderr, "ElkDB (remote) — %s\nType EXIT to quit.\n", addr)
 
@@ -140,6 +817,10 @@ func printResult(w *os.File, r network.Result) {
 				switch v.Type {
 				case table.TypeInt64:
 					parts[i] = fmt.Sprintf("%d", v.I64)
+				case table.TypeFloat64:
+					parts[i] = strconv.FormatFloat(v.F64, 'g', -1, 64)
+				case table.TypeUUID:
+					parts[i] = table.UUID(v.Str).String()
 				case table.TypeBytes:
 					parts[i] = string(v.Str)
 				default:
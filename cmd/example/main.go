@@ -107,6 +107,10 @@ func rowStr(row table.Record) string {
 		switch v.Type {
 		case table.TypeInt64:
 			s += fmt.Sprintf("%s=%d", col, v.I64)
+		case table.TypeFloat64:
+			s += fmt.Sprintf("%s=%g", col, v.F64)
+		case table.TypeUUID:
+			s += fmt.Sprintf("%s=%s", col, table.UUID(v.Str))
 		case table.TypeBytes:
 			s += fmt.Sprintf("%s=%s", col, v.Str)
 		}
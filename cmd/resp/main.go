@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/MHS-20/ElkDB/server/resp"
+	table "github.com/MHS-20/ElkDB/tables"
+	"github.com/MHS-20/ElkDB/tlsconfig"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "TCP address to listen on")
+	dbPath := flag.String("db", "elk.db", "path to the ElkDB data file")
+	tlsCert := flag.String("tls-cert", "", "serve TLS with this PEM certificate (needs -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
+	auth := flag.Bool("auth", true, "require clients to authenticate as a user of the database")
+	tlsClientCA := flag.String("tls-client-ca", "", "require client certificates signed by a CA in this PEM file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb-resp [flags]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	db := &table.DB{Path: *dbPath, Logger: slog.Default()}
+	if err := db.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "elkdb-resp: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if *auth {
+		password, err := db.BootstrapAdmin("admin", os.Getenv("ELKDB_ADMIN_PASSWORD"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-resp: create admin user: %v\n", err)
+			db.Close()
+			os.Exit(1)
+		}
+		if password != "" && os.Getenv("ELKDB_ADMIN_PASSWORD") == "" {
+			fmt.Fprintf(os.Stderr, "elkdb-resp: created user admin with password %s\n", password)
+		}
+	}
+
+	srv := &resp.Server{Addr: *addr, DB: db, RequireAuth: *auth}
+	if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+		cfg, err := tlsconfig.Server(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-resp: %v\n", err)
+			db.Close()
+			os.Exit(1)
+		}
+		srv.TLSConfig = cfg
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		if err := srv.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-resp: shutdown: %v\n", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "elkdb-resp: %v\n", err)
+		db.Close()
+		os.Exit(1)
+	}
+	<-done // commands in progress have finished; the DB can be closed
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/raftdb"
+	resthttp "github.com/MHS-20/ElkDB/server/http"
+	table "github.com/MHS-20/ElkDB/tables"
+	"github.com/MHS-20/ElkDB/tlsconfig"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "HTTP address to listen on")
+	dbPath := flag.String("db", "elk.db", "path to the ElkDB data file")
+	grace := flag.Duration("grace", 10*time.Second, "how long shutdown waits for requests in flight")
+	tlsCert := flag.String("tls-cert", "", "serve TLS with this PEM certificate (needs -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
+	auth := flag.Bool("auth", true, "require clients to authenticate as a user of the database")
+	tlsClientCA := flag.String("tls-client-ca", "", "require client certificates signed by a CA in this PEM file")
+	raftID := flag.Uint64("raft-id", 0, "serve the database as this node of a Raft cluster (needs -raft-peers and -raft-cert, -raft-key, -raft-ca)")
+	raftPeers := flag.String("raft-peers", "", "the nodes of the cluster, this one included, as id=host:port,...")
+	raftCert := flag.String("raft-cert", "", "PEM certificate this node presents to the other nodes")
+	raftKey := flag.String("raft-key", "", "PEM private key of -raft-cert")
+	raftCA := flag.String("raft-ca", "", "PEM file of the CA that signs the certificates of the nodes")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: elkdb-rest [flags]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var db *table.DB
+	if *raftID != 0 {
+		node, err := openNode(*raftID, *raftPeers, *dbPath, *raftCert, *raftKey, *raftCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-rest: %v\n", err)
+			os.Exit(1)
+		}
+		defer node.Close()
+		db = node.DB()
+	} else {
+		db = &table.DB{Path: *dbPath, Logger: slog.Default()}
+		if err := db.Open(); err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-rest: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+	}
+
+	if *auth {
+		password, err := bootstrapAdmin(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-rest: create admin user: %v\n", err)
+			db.Close()
+			os.Exit(1)
+		}
+		if password != "" && os.Getenv("ELKDB_ADMIN_PASSWORD") == "" {
+			fmt.Fprintf(os.Stderr, "elkdb-rest: created user admin with password %s\n", password)
+		}
+	}
+
+	srv := &resthttp.Server{Addr: *addr, DB: db, RequireAuth: *auth}
+	if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+		cfg, err := tlsconfig.Server(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-rest: %v\n", err)
+			db.Close()
+			os.Exit(1)
+		}
+		srv.TLSConfig = cfg
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), *grace)
+		defer cancel()
+		if err := srv.Shutdown(shutdown); err != nil {
+			fmt.Fprintf(os.Stderr, "elkdb-rest: shutdown: %v\n", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "elkdb-rest: %v\n", err)
+		db.Close()
+		os.Exit(1)
+	}
+	<-done // requests in flight have finished; the DB can be closed
+}
+
+// openNode starts this server's node of a Raft cluster and waits until the
+// cluster has a leader.
+func openNode(id uint64, peers, path, certFile, keyFile, caFile string) (*raftdb.Node, error) {
+	p, err := raftdb.ParsePeers(peers)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := tlsconfig.Peer(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	node := &raftdb.Node{ID: id, Peers: p, Path: path, TLSConfig: cfg}
+	if err := node.Open(); err != nil {
+		return nil, err
+	}
+	if err := node.WaitLeader(context.Background()); err != nil {
+		node.Close()
+		return nil, err
+	}
+	return node, nil
+}
+
+// bootstrapAdmin creates the admin user if there are no users, retrying
+// the conflicts of cluster nodes that start at the same time.
+func bootstrapAdmin(db *table.DB) (string, error) {
+	for {
+		password, err := db.BootstrapAdmin("admin", os.Getenv("ELKDB_ADMIN_PASSWORD"))
+		if !errors.Is(err, kv.ErrConflict) && !errors.Is(err, raftdb.ErrTimeout) {
+			return password, err
+		}
+	}
+}
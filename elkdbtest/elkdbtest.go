@@ -0,0 +1,430 @@
+// Package elkdbtest is a conformance harness for transactional key-value
+// stores. It drives a Store with random multi-key transactions and checks
+// every observation against an in-memory reference model, the same approach
+// the btree and kv packages use in their own tests.
+//
+// NewKVStore adapts a *kv.KV so any ElkDB configuration can be checked; an
+// alternative backend only has to implement Store. A typical test is
+//
+//	func TestConformance(t *testing.T) {
+//		db := &kv.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+//		if err := db.Open(); err != nil {
+//			t.Fatal(err)
+//		}
+//		defer db.Close()
+//		elkdbtest.Run(t, elkdbtest.NewKVStore(db), elkdbtest.Config{})
+//	}
+package elkdbtest
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// Store under test
+// ---------------------------------------------------------------------------
+
+// Store is the engine under test.
+type Store interface {
+	Begin() Txn      // a read-write transaction
+	BeginRead() View // a read-only snapshot
+}
+
+// Reader is the read surface shared by Txn and View.
+type Reader interface {
+	// Get returns the value of key, or (nil, false) if absent.
+	Get(key []byte) ([]byte, bool)
+	// Scan calls fn for each key >= from in ascending order until fn
+	// returns false. key and val are only valid during the call.
+	Scan(from []byte, fn func(key, val []byte) bool)
+}
+
+// View is a read-only snapshot; End releases it.
+type View interface {
+	Reader
+	End()
+}
+
+// Txn is a read-write transaction. Its reads see its own writes. Commit
+// either applies every write atomically or returns an error and applies
+// none; the harness treats any error (such as an OCC conflict) that way.
+type Txn interface {
+	Reader
+	Set(key, val []byte)
+	Del(key []byte)
+	Commit() error
+	Abort()
+}
+
+// ---------------------------------------------------------------------------
+// Configuration
+// ---------------------------------------------------------------------------
+
+// Prefix is prepended to every key the harness writes. Only keys under it
+// are inspected, so the store may hold other data.
+const Prefix = "elkdbtest/"
+
+// Config sizes a run. Zero fields take the defaults shown.
+type Config struct {
+	Seed    int64
+	Keys    int // distinct keys (64)
+	Batches int // transactions per writer (200)
+	Writers int // concurrent writers in CheckSnapshots (4)
+	Readers int // concurrent readers in CheckSnapshots (2)
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Keys <= 0 {
+		cfg.Keys = 64
+	}
+	if cfg.Batches <= 0 {
+		cfg.Batches = 200
+	}
+	if cfg.Writers <= 0 {
+		cfg.Writers = 4
+	}
+	if cfg.Readers <= 0 {
+		cfg.Readers = 2
+	}
+	return cfg
+}
+
+// Run runs every check in the package against s.
+func Run(t testing.TB, s Store, cfg Config) {
+	t.Helper()
+	CheckBatches(t, s, cfg)
+	CheckSnapshots(t, s, cfg)
+}
+
+// ---------------------------------------------------------------------------
+// Reference model
+// ---------------------------------------------------------------------------
+
+// state is the content of the key space: key -> value.
+type state map[string]string
+
+// op is one write of a batch; del ops ignore val.
+type op struct {
+	key, val string
+	del      bool
+}
+
+func (st state) apply(batch []op) {
+	for _, o := range batch {
+		if o.del {
+			delete(st, o.key)
+		} else {
+			st[o.key] = o.val
+		}
+	}
+}
+
+// randBatch builds up to 8 random writes over cfg.Keys keys.
+func randBatch(rng *rand.Rand, cfg Config) []op {
+	batch := make([]op, 1+rng.Intn(8))
+	for i := range batch {
+		batch[i].key = fmt.Sprintf("%sk%04d", Prefix, rng.Intn(cfg.Keys))
+		if rng.Intn(4) == 0 {
+			batch[i].del = true
+		} else {
+			batch[i].val = strconv.FormatUint(rng.Uint64(), 36)
+		}
+	}
+	return batch
+}
+
+// readState returns the harness keys visible to r.
+func readState(r Reader) state {
+	st := state{}
+	prefix := []byte(Prefix)
+	r.Scan(prefix, func(key, val []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		st[string(key)] = string(val)
+		return true
+	})
+	return st
+}
+
+// diff describes how got differs from want, or returns "" if they match.
+func diff(got, want state) string {
+	keys := slices.Sorted(maps.Keys(got))
+	for k := range want {
+		if _, ok := got[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		g, gok := got[k]
+		w, wok := want[k]
+		switch {
+		case !wok:
+			return fmt.Sprintf("unexpected key %q = %q", k, g)
+		case !gok:
+			return fmt.Sprintf("missing key %q (want %q)", k, w)
+		case g != w:
+			return fmt.Sprintf("key %q = %q, want %q", k, g, w)
+		}
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------
+// Checks
+// ---------------------------------------------------------------------------
+
+// CheckBatches runs cfg.Batches random transactions one at a time, aborting
+// about one in five, and checks after each that a transaction reads its own
+// writes, that committed batches are applied whole, and that aborted ones
+// leave no trace, by comparing full scans and point reads with the model.
+func CheckBatches(t testing.TB, s Store, cfg Config) {
+	t.Helper()
+	cfg = cfg.withDefaults()
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	reset(s)
+	model := state{}
+	for i := 0; i < cfg.Batches; i++ {
+		batch := randBatch(rng, cfg)
+		pending := maps.Clone(model)
+		tx := s.Begin()
+		for _, o := range batch {
+			if o.del {
+				tx.Del([]byte(o.key))
+			} else {
+				tx.Set([]byte(o.key), []byte(o.val))
+			}
+			pending.apply([]op{o})
+			val, ok := tx.Get([]byte(o.key))
+			if ok == o.del || string(val) != o.val {
+				tx.Abort()
+				t.Fatalf("batch %d: read of own write %q = (%q, %v)", i, o.key, val, ok)
+			}
+		}
+		if d := diff(readState(tx), pending); d != "" {
+			tx.Abort()
+			t.Fatalf("batch %d: in-transaction scan: %s", i, d)
+		}
+
+		if rng.Intn(5) == 0 {
+			tx.Abort()
+		} else {
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("batch %d: commit without concurrency: %v", i, err)
+			}
+			model = pending
+		}
+
+		v := s.BeginRead()
+		got := readState(v)
+		for k, want := range model {
+			if val, ok := v.Get([]byte(k)); !ok || string(val) != want {
+				v.End()
+				t.Fatalf("batch %d: Get(%q) = (%q, %v), want %q", i, k, val, ok, want)
+			}
+		}
+		v.End()
+		if d := diff(got, model); d != "" {
+			t.Fatalf("batch %d: after commit/abort: %s", i, d)
+		}
+	}
+}
+
+// seqKey is a counter every CheckSnapshots writer increments, which both
+// forces concurrent writers to conflict and numbers the commits.
+const seqKey = Prefix + "@seq"
+
+// maxAttempts bounds the commit retries of one CheckSnapshots transaction.
+const maxAttempts = 1000
+
+type observation struct {
+	seq uint64
+	st  state
+}
+
+// CheckSnapshots runs cfg.Writers concurrent writers, each committing
+// cfg.Batches random transactions (retrying failed commits), against
+// cfg.Readers concurrent readers. Every transaction also increments a
+// sequence key, so the commits form a total order. The check then verifies
+// that
+//
+//   - the committed sequence numbers are exactly 1..N (no lost or
+//     duplicated commits);
+//   - replaying the committed batches in that order reproduces the final
+//     content (batches are atomic and linearizable);
+//   - every snapshot a reader took equals the replayed state at its
+//     sequence number, reads the same when scanned twice, and no reader
+//     ever sees the sequence go backwards (snapshot isolation).
+func CheckSnapshots(t testing.TB, s Store, cfg Config) {
+	t.Helper()
+	cfg = cfg.withDefaults()
+	reset(s)
+
+	var mu sync.Mutex
+	log := map[uint64][]op{}
+	var errs []string
+	fail := func(format string, args ...any) {
+		mu.Lock()
+		errs = append(errs, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+
+	var writers sync.WaitGroup
+	for w := 0; w < cfg.Writers; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			rng := rand.New(rand.NewSource(cfg.Seed + int64(w) + 1))
+			for i := 0; i < cfg.Batches; i++ {
+				batch := randBatch(rng, cfg)
+				for attempt := 0; ; attempt++ {
+					if attempt == maxAttempts {
+						fail("writer %d: batch %d not committed after %d attempts", w, i, attempt)
+						return
+					}
+					tx := s.Begin()
+					seq, err := readSeq(tx)
+					if err != nil {
+						tx.Abort()
+						fail("writer %d: %v", w, err)
+						return
+					}
+					for _, o := range batch {
+						if o.del {
+							tx.Del([]byte(o.key))
+						} else {
+							tx.Set([]byte(o.key), []byte(o.val))
+						}
+					}
+					tx.Set([]byte(seqKey), []byte(strconv.FormatUint(seq+1, 10)))
+					if tx.Commit() != nil {
+						continue // not applied; try again on a fresh snapshot
+					}
+					mu.Lock()
+					if _, dup := log[seq+1]; dup {
+						errs = append(errs, fmt.Sprintf("sequence %d committed twice", seq+1))
+					}
+					log[seq+1] = batch
+					mu.Unlock()
+					break
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	obs := make([][]observation, cfg.Readers)
+	for r := 0; r < cfg.Readers; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			last := uint64(0)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				v := s.BeginRead()
+				st := readState(v)
+				again := readState(v)
+				v.End()
+				if d := diff(again, st); d != "" {
+					fail("reader %d: snapshot changed between scans: %s", r, d)
+					return
+				}
+				seq, err := parseSeq(st[seqKey])
+				if err != nil {
+					fail("reader %d: %v", r, err)
+					return
+				}
+				if seq < last {
+					fail("reader %d: sequence went back from %d to %d", r, last, seq)
+					return
+				}
+				last = seq
+				obs[r] = append(obs[r], observation{seq, st})
+			}
+		}(r)
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	if len(errs) > 0 {
+		t.Fatalf("%d failures, first: %s", len(errs), errs[0])
+	}
+
+	// Replay the log and compare each observation with its state.
+	var all []observation
+	for _, o := range obs {
+		all = append(all, o...)
+	}
+	slices.SortStableFunc(all, func(a, b observation) int { return cmp.Compare(a.seq, b.seq) })
+	n := uint64(cfg.Writers * cfg.Batches)
+	model := state{}
+	next := 0
+	for seq := uint64(0); seq <= n; seq++ {
+		if seq > 0 {
+			batch, ok := log[seq]
+			if !ok {
+				t.Fatalf("sequence %d was never committed (%d commits)", seq, len(log))
+			}
+			model.apply(batch)
+			model[seqKey] = strconv.FormatUint(seq, 10)
+		}
+		for ; next < len(all) && all[next].seq == seq; next++ {
+			if d := diff(all[next].st, model); d != "" {
+				t.Fatalf("snapshot at sequence %d: %s", seq, d)
+			}
+		}
+	}
+	if next < len(all) {
+		t.Fatalf("snapshot at sequence %d beyond the last commit %d", all[next].seq, n)
+	}
+
+	v := s.BeginRead()
+	defer v.End()
+	if d := diff(readState(v), model); d != "" {
+		t.Fatalf("final state: %s", d)
+	}
+}
+
+func readSeq(r Reader) (uint64, error) {
+	val, _ := r.Get([]byte(seqKey))
+	return parseSeq(string(val))
+}
+
+func parseSeq(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad sequence value %q", s)
+	}
+	return seq, nil
+}
+
+// reset deletes every harness key so that each check starts from empty.
+func reset(s Store) {
+	for {
+		tx := s.Begin()
+		for k := range readState(tx) {
+			tx.Del([]byte(k))
+		}
+		if tx.Commit() == nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package elkdbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/kv"
+)
+
+func TestModel(t *testing.T) {
+	Run(t, &Model{}, Config{Seed: 1})
+}
+
+func TestKV(t *testing.T) {
+	db := &kv.KV{Path: filepath.Join(t.TempDir(), "test.db"), NoSync: true}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	Run(t, NewKVStore(db), Config{Seed: 1})
+
+	// Data outside Prefix is left alone and ignored.
+	tx := NewKVStore(db).Begin()
+	tx.Set([]byte("other"), []byte("x"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	CheckBatches(t, NewKVStore(db), Config{Seed: 2, Batches: 50})
+	v := NewKVStore(db).BeginRead()
+	defer v.End()
+	if val, ok := v.Get([]byte("other")); !ok || string(val) != "x" {
+		t.Fatalf("other = (%q, %v)", val, ok)
+	}
+}
+
+// brokenStore drops every other write of a transaction, which the checks
+// must notice.
+type brokenStore struct{ Model }
+
+type brokenTxn struct {
+	Txn
+	n int
+}
+
+func (s *brokenStore) Begin() Txn { return &brokenTxn{Txn: s.Model.Begin()} }
+
+func (t *brokenTxn) Set(key, val []byte) {
+	if t.n++; t.n%2 == 1 {
+		t.Txn.Set(key, val)
+	}
+}
+
+func TestBrokenStoreFails(t *testing.T) {
+	ft := &fakeT{TB: t}
+	func() {
+		defer func() { recover() }()
+		CheckBatches(ft, &brokenStore{}, Config{Seed: 1})
+	}()
+	if !ft.failed {
+		t.Fatal("CheckBatches accepted a store that drops writes")
+	}
+}
+
+// fakeT records Fatalf instead of failing the real test.
+type fakeT struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Fatalf(format string, args ...any) {
+	f.failed = true
+	panic("fatal")
+}
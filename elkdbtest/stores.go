@@ -0,0 +1,164 @@
+package elkdbtest
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/kv"
+)
+
+// ---------------------------------------------------------------------------
+// kv.KV adapter
+// ---------------------------------------------------------------------------
+
+// NewKVStore returns a Store backed by db, which must be open.
+func NewKVStore(db *kv.KV) Store {
+	return kvStore{db}
+}
+
+type kvStore struct {
+	db *kv.KV
+}
+
+func (s kvStore) Begin() Txn {
+	tx := &kv.KVTX{}
+	s.db.Begin(tx)
+	return &kvTxn{s.db, tx}
+}
+
+func (s kvStore) BeginRead() View {
+	tx := &kv.KVReader{}
+	s.db.BeginRead(tx)
+	return &kvView{s.db, tx}
+}
+
+func kvScan(r kv.Reader, from []byte, fn func(key, val []byte) bool) {
+	for iter := r.Seek(from, btree.CmpGE); iter.Valid(); iter.Next() {
+		if !fn(iter.Deref()) {
+			return
+		}
+	}
+}
+
+type kvView struct {
+	db *kv.KV
+	tx *kv.KVReader
+}
+
+func (v *kvView) Get(key []byte) ([]byte, bool)                   { return v.tx.Get(key) }
+func (v *kvView) Scan(from []byte, fn func(key, val []byte) bool) { kvScan(v.tx, from, fn) }
+func (v *kvView) End()                                            { v.db.EndRead(v.tx) }
+
+type kvTxn struct {
+	db *kv.KV
+	tx *kv.KVTX
+}
+
+func (t *kvTxn) Get(key []byte) ([]byte, bool)                   { return t.tx.Get(key) }
+func (t *kvTxn) Scan(from []byte, fn func(key, val []byte) bool) { kvScan(t.tx, from, fn) }
+func (t *kvTxn) Set(key, val []byte)                             { t.tx.Update(&btree.InsertReq{Key: key, Val: val}) }
+func (t *kvTxn) Del(key []byte)                                  { t.tx.Del(&btree.DeleteReq{Key: key}) }
+func (t *kvTxn) Commit() error                                   { return t.db.Commit(t.tx) }
+func (t *kvTxn) Abort()                                          { t.db.Abort(t.tx) }
+
+// ---------------------------------------------------------------------------
+// Reference store
+// ---------------------------------------------------------------------------
+
+// ErrConflict is returned by Model commits that lost an OCC race.
+var ErrConflict = errors.New("serialisation conflict: retry transaction")
+
+// Model is an in-memory Store with the semantics the checks expect:
+// snapshot reads and optimistic commits that fail if any other transaction
+// committed since Begin. It is the reference an alternative backend can be
+// compared with, and a way to test checks built on this package.
+type Model struct {
+	mu      sync.Mutex
+	data    state
+	version uint64
+}
+
+func (m *Model) snapshot() (state, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := maps.Clone(m.data)
+	if st == nil {
+		st = state{}
+	}
+	return st, m.version
+}
+
+func (m *Model) Begin() Txn {
+	st, version := m.snapshot()
+	return &modelTxn{m: m, view: modelView{st}, version: version}
+}
+
+func (m *Model) BeginRead() View {
+	st, _ := m.snapshot()
+	return modelView{st}
+}
+
+type modelView struct {
+	st state
+}
+
+func (v modelView) Get(key []byte) ([]byte, bool) {
+	val, ok := v.st[string(key)]
+	if !ok {
+		return nil, false
+	}
+	return []byte(val), true
+}
+
+func (v modelView) Scan(from []byte, fn func(key, val []byte) bool) {
+	for _, k := range slices.Sorted(maps.Keys(v.st)) {
+		if strings.Compare(k, string(from)) < 0 {
+			continue
+		}
+		if !fn([]byte(k), []byte(v.st[k])) {
+			return
+		}
+	}
+}
+
+func (v modelView) End() {}
+
+type modelTxn struct {
+	m       *Model
+	view    modelView
+	version uint64
+	writes  bool
+}
+
+func (t *modelTxn) Get(key []byte) ([]byte, bool)                   { return t.view.Get(key) }
+func (t *modelTxn) Scan(from []byte, fn func(key, val []byte) bool) { t.view.Scan(from, fn) }
+
+func (t *modelTxn) Set(key, val []byte) {
+	t.view.st[string(key)] = string(val)
+	t.writes = true
+}
+
+func (t *modelTxn) Del(key []byte) {
+	delete(t.view.st, string(key))
+	t.writes = true
+}
+
+func (t *modelTxn) Commit() error {
+	if !t.writes {
+		return nil
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	if t.version != t.m.version {
+		return ErrConflict
+	}
+	t.m.data = t.view.st
+	t.m.version++
+	return nil
+}
+
+func (t *modelTxn) Abort() {}
@@ -0,0 +1,116 @@
+// Package events is the engine's notification bus. The storage layers
+// publish lifecycle events (commits, checkpoints, schema changes, detected
+// corruption...) and embedders subscribe to them instead of polling.
+//
+// Delivery is asynchronous through a bounded queue per subscription:
+// publishing never blocks the engine, and events that do not fit in a full
+// queue are dropped and counted.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind identifies what happened.
+type Kind string
+
+const (
+	Commit        Kind = "commit"         // a write transaction committed; Version is the new version
+	Checkpoint    Kind = "checkpoint"     // the WAL was applied to the data file and truncated
+	Recovery      Kind = "recovery"       // committed transactions were replayed from the WAL on open
+	Compaction    Kind = "compaction"     // the data file was rewritten by Compact; Version is the new version
+	SchemaChanged Kind = "schema changed" // Table was created or its definition changed
+	Corruption    Kind = "corruption"     // damaged on-disk data was detected; Err says what
+	IOError       Kind = "I/O error"      // an I/O step failed; Err is the error
+)
+
+// Event is one notification. Fields that do not apply to Kind are zero.
+type Event struct {
+	Kind    Kind
+	Time    time.Time
+	Version uint64 // KV version after a Commit or Compaction
+	Table   string // SchemaChanged
+	Err     error  // Checkpoint, Recovery, Corruption and IOError failures
+}
+
+// DefaultQueue is the queue length used when Subscribe is given 0.
+const DefaultQueue = 64
+
+// Bus fans events out to subscribers. The zero value is ready to use.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives the events of the kinds it subscribed to on C, in
+// publication order, until Close.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	kinds   map[Kind]bool // nil: every kind
+	dropped atomic.Uint64
+	bus     *Bus
+}
+
+// Subscribe returns a subscription with a queue of queue events (0 means
+// DefaultQueue) for the given kinds, or for every kind if none are given.
+func (b *Bus) Subscribe(queue int, kinds ...Kind) *Subscription {
+	if queue <= 0 {
+		queue = DefaultQueue
+	}
+	c := make(chan Event, queue)
+	sub := &Subscription{C: c, c: c, bus: b}
+	if len(kinds) > 0 {
+		sub.kinds = map[Kind]bool{}
+		for _, k := range kinds {
+			sub.kinds[k] = true
+		}
+	}
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = map[*Subscription]struct{}{}
+	}
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish delivers ev to every matching subscription whose queue has room,
+// setting ev.Time if it is zero. It never blocks.
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if sub.kinds != nil && !sub.kinds[ev.Kind] {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns how many events did not fit in the queue.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes C; events already queued can still be
+// received. Calling Close more than once is harmless.
+func (s *Subscription) Close() {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
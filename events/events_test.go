@@ -0,0 +1,43 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	var b Bus
+	all := b.Subscribe(0)
+	commits := b.Subscribe(2, Commit)
+
+	b.Publish(Event{Kind: Commit, Version: 1})
+	b.Publish(Event{Kind: SchemaChanged, Table: "t"})
+	b.Publish(Event{Kind: Commit, Version: 2})
+	b.Publish(Event{Kind: Commit, Version: 3}) // commits' queue is full
+
+	ev := <-commits.C
+	is.Equal(t, uint64(1), ev.Version)
+	is.False(t, ev.Time.IsZero())
+	is.Equal(t, uint64(2), (<-commits.C).Version)
+	is.Equal(t, uint64(1), commits.Dropped())
+
+	is.Equal(t, Commit, (<-all.C).Kind)
+	is.Equal(t, "t", (<-all.C).Table)
+	is.Equal(t, uint64(0), all.Dropped())
+
+	// Close keeps queued events and then ends the channel.
+	all.Close()
+	all.Close()
+	n := 0
+	for range all.C {
+		n++
+	}
+	is.Equal(t, 2, n)
+
+	b.Publish(Event{Kind: Corruption, Err: errors.New("bad page")})
+	commits.Close()
+	_, ok := <-commits.C
+	is.False(t, ok)
+}
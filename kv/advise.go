@@ -0,0 +1,143 @@
+package kv
+
+import (
+	"slices"
+	"syscall"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// Access is an access-pattern hint for the mapping of the data file, handed
+// to the kernel with madvise(2) to tune its read-ahead.
+type Access int
+
+const (
+	AccessNormal     Access = iota // MADV_NORMAL: the kernel's default read-ahead
+	AccessRandom                   // MADV_RANDOM: no read-ahead, for point lookups
+	AccessSequential               // MADV_SEQUENTIAL: aggressive read-ahead, for scans
+)
+
+func (a Access) madvise() int {
+	switch a {
+	case AccessRandom:
+		return syscall.MADV_RANDOM
+	case AccessSequential:
+		return syscall.MADV_SEQUENTIAL
+	}
+	return syscall.MADV_NORMAL
+}
+
+// Advise changes the access hint of the mapping to a, for instance to
+// AccessSequential around a large scan, and makes it the one new mappings
+// get. It starts as KV.Access.
+func (kv *KV) Advise(a Access) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.access = a
+	return adviseChunks(kv.mmap.chunks, a)
+}
+
+// adviseSequential hints chunks, which a copy of the whole tree is about to
+// read, as sequential. The returned function restores the hint of the KV.
+func (kv *KV) adviseSequential(chunks [][]byte) func() {
+	_ = adviseChunks(chunks, AccessSequential)
+	return func() {
+		kv.mu.Lock()
+		defer kv.mu.Unlock()
+		_ = adviseChunks(chunks, kv.access)
+	}
+}
+
+func adviseChunks(chunks [][]byte, a Access) error {
+	for _, chunk := range chunks {
+		if err := madvise(chunk, a.madvise()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAhead returns KV.ReadAhead, which makes a KVReader (and a KVTX) a
+// btree.Prefetcher.
+func (tx *KVReader) ReadAhead() int {
+	return tx.ahead
+}
+
+// PagePrefetch advises the mapped pages ptrs with MADV_WILLNEED, which
+// starts reading them into the page cache without waiting. A page past the
+// mapping of the snapshot, such as a page a write transaction has not
+// committed yet, is left alone, and so are errors: the hint is only a hint.
+func (tx *KVReader) PagePrefetch(ptrs []uint64) {
+	ptrs = slices.Clone(ptrs)
+	slices.Sort(ptrs)
+	tx.mmapMu.RLock()
+	defer tx.mmapMu.RUnlock()
+	n := uint64(0)
+	_ = pageRuns(ptrs, func(first, count uint64) error {
+		lo, hi := int(first*btree.PageSize), int((first+count)*btree.PageSize)
+		return mappedRange(tx.mmap.chunks, lo, hi, func(mem []byte) error {
+			n += uint64(len(mem) / btree.PageSize)
+			return madvise(mem, syscall.MADV_WILLNEED)
+		})
+	})
+	if tx.stats != nil {
+		tx.stats.pagesPrefetched.Add(n)
+	}
+}
+
+// dropPages releases the mapped memory of the free pages ptrs, which are
+// sorted, with MADV_DONTNEED; see KV.DropFreed. The file keeps their
+// contents, which a later access faults back in.
+func (kv *KV) dropPages(ptrs []uint64) {
+	kv.mmapMu.RLock()
+	defer kv.mmapMu.RUnlock()
+	n := uint64(0)
+	err := pageRuns(ptrs, func(first, count uint64) error {
+		lo, hi := int(first*btree.PageSize), int((first+count)*btree.PageSize)
+		return mappedRange(kv.mmap.chunks, lo, hi, func(mem []byte) error {
+			if err := madvise(mem, syscall.MADV_DONTNEED); err != nil {
+				return err
+			}
+			n += uint64(len(mem) / btree.PageSize)
+			return nil
+		})
+	})
+	kv.stats.pagesDropped.Add(n)
+	if err != nil {
+		kv.log().Warn("kv: dropping free pages failed", "path", kv.Path, "err", err)
+	}
+}
+
+// adviseHuge asks the kernel, with madvise(MADV_HUGEPAGE), to back the
+// mapping with transparent huge pages once the file has reached
+// KV.HugePages bytes, and every part mapped after that. Like lockMemory it
+// runs whenever the file or its mapping grows. If the kernel refuses, it
+// logs a warning and stops trying.
+func (kv *KV) adviseHuge() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.HugePages <= 0 || kv.mmap.hugeOff || int64(kv.mmap.file) < kv.HugePages || kv.mmap.huge >= kv.mmap.total {
+		return
+	}
+	err := mappedRange(kv.mmap.chunks, kv.mmap.huge, kv.mmap.total, func(mem []byte) error {
+		if err := adviseHugePages(mem); err != nil {
+			return err
+		}
+		kv.mmap.huge += len(mem)
+		return nil
+	})
+	if err != nil {
+		kv.mmap.hugeOff = true
+		kv.log().Warn("kv: huge pages not supported, going on without", "path", kv.Path, "err", err)
+		return
+	}
+	kv.log().Debug("kv: mapping advised for huge pages", "path", kv.Path, "bytes", kv.mmap.total)
+}
+
+// HugePagesAdvised reports whether the mapping has been advised for huge
+// pages; see KV.HugePages.
+func (kv *KV) HugePagesAdvised() bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.mmap.huge > 0
+}
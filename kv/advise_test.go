@@ -0,0 +1,159 @@
+//go:build linux
+
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+// resident returns the resident memory of the process in pages, from
+// /proc/self/statm.
+func resident(t *testing.T) int {
+	data, err := os.ReadFile("/proc/self/statm")
+	is.NoError(t, err)
+	var size, rss int
+	_, err = fmt.Sscan(string(data), &size, &rss)
+	is.NoError(t, err)
+	return rss
+}
+
+func TestAdvise(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "advise.db"), NoSync: true, Access: AccessRandom, DropFreed: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	val := bytes.Repeat([]byte{'v'}, 1000)
+	write := func(prefix string, n int) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range n {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i), Val: val})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+
+	// The deleted keys sit in the middle of the file, so their pages are
+	// not truncated but leave the resident set.
+	write("a", 3000)
+	write("z", 100)
+	before, dropped := resident(t), db.Metrics().PagesDropped
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 3000 {
+		tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "a%05d", i)})
+	}
+	is.NoError(t, db.Commit(&tx))
+	write("z", 1) // the next commit drops them
+	dropped = db.Metrics().PagesDropped - dropped
+	is.Greater(t, dropped, uint64(500))
+	is.Less(t, resident(t), before-500)
+
+	// They are faulted back in from the file when reused.
+	write("b", 3000)
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(3000+100), check.Keys)
+
+	is.NoError(t, db.Advise(AccessSequential))
+	_, err := db.Compact()
+	is.NoError(t, err)
+	is.Equal(t, AccessSequential, db.access)
+}
+
+// vmFlags returns the VmFlags that /proc/self/smaps shows for the mapping
+// that starts at the address of mem.
+func vmFlags(t *testing.T, mem []byte) []string {
+	data, err := os.ReadFile("/proc/self/smaps")
+	is.NoError(t, err)
+	start := fmt.Sprintf("%x-", uintptr(unsafe.Pointer(&mem[0])))
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, start) {
+			found = true
+		}
+		if found && strings.HasPrefix(line, "VmFlags:") {
+			return strings.Fields(line)[1:]
+		}
+	}
+	t.Fatalf("no mapping at %s", start)
+	return nil
+}
+
+func TestHugePages(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "huge.db"), NoSync: true, HugePages: 1 << 20}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	val := bytes.Repeat([]byte{'v'}, 1000)
+	write := func(prefix string, n int) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range n {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i), Val: val})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+
+	// A small file is left alone; the advice comes once it is large.
+	write("a", 10)
+	is.False(t, db.HugePagesAdvised())
+	is.NotContains(t, vmFlags(t, db.mmap.chunks[0]), "hg")
+	write("b", 3000)
+	if db.mmap.hugeOff {
+		t.Skip("MADV_HUGEPAGE not supported")
+	}
+	is.True(t, db.HugePagesAdvised())
+	is.Contains(t, vmFlags(t, db.mmap.chunks[0]), "hg")
+
+	// Compact maps the new file, which is advised again.
+	_, err := db.Compact()
+	is.NoError(t, err)
+	is.True(t, db.HugePagesAdvised())
+	is.Contains(t, vmFlags(t, db.mmap.chunks[0]), "hg")
+}
+
+func TestReadAhead(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "ahead.db"), NoSync: true, ReadAhead: 16}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	val := bytes.Repeat([]byte{'v'}, 1000)
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 3000 {
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%05d", i), Val: val})
+	}
+	// A write transaction reads ahead too, past its own new pages.
+	n := 0
+	for iter := tx.Seek([]byte("k"), btree.CmpGE); iter.Valid(); iter.Next() {
+		n++
+	}
+	is.Equal(t, 3000, n)
+	is.NoError(t, db.Commit(&tx))
+
+	// A scan of the 3 keys per leaf requests nearly every leaf.
+	before := db.Metrics().PagesPrefetched
+	r := KVReader{}
+	db.BeginRead(&r)
+	n = 0
+	for iter := r.Seek([]byte("k"), btree.CmpGE); iter.Valid(); iter.Next() {
+		n++
+	}
+	db.EndRead(&r)
+	is.Equal(t, 3000, n)
+	is.Greater(t, db.Metrics().PagesPrefetched-before, uint64(900))
+
+	db.ReadAhead = 0
+	before = db.Metrics().PagesPrefetched
+	db.BeginRead(&r)
+	for iter := r.Seek([]byte("k"), btree.CmpGE); iter.Valid(); iter.Next() {
+	}
+	db.EndRead(&r)
+	is.Equal(t, before, db.Metrics().PagesPrefetched)
+}
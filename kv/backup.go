@@ -0,0 +1,343 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// Backup stream layout. Integers are little-endian; every checksum is the
+// CRC-32 (IEEE) of the bytes before it in the same header or record.
+//
+//	header | sig | stream version | page size | format | pad | pages | root | free head | version | crc |
+//	       |  8B |       4B       |     4B    |   4B   |  4B |   8B  |  8B  |     8B    |    8B   |  4B |
+//	base   | type | base version | crc |
+//	       |  1B  |      8B      |  4B |
+//	page   | type | page number | data     | crc |
+//	       |  1B  |      8B     | PageSize |  4B |
+//	end    | type | page records | crc |
+//	       |  1B  |      8B      |  4B |
+//
+// Page records come in increasing page order. In a full backup the pages
+// the stream leaves out are free and restore as holes. An incremental
+// backup (BackupSince) starts with a base record and leaves out the pages
+// that have not changed since the base version as well.
+const (
+	backupSig     = "ElkBAK\000"
+	backupVersion = uint32(1)
+	backupHeader  = 60
+)
+
+const (
+	backupPage byte = 1
+	backupEnd  byte = 2
+	backupBase byte = 3
+)
+
+// ErrBadBackup is returned (wrapped) by RestoreFrom and ApplyBackup for a
+// stream that is not a complete, intact backup of the kind they take.
+var ErrBadBackup = errors.New("bad backup")
+
+// BackupReport describes a backup written by Backup or BackupSince.
+type BackupReport struct {
+	Version uint64 // committed version the backup holds; the base of the next BackupSince
+	Pages   uint64 // size of the restored file in pages, the master page included
+	Written int    // page records in the stream
+}
+
+// Backup writes a consistent copy of the store to w, to be restored with
+// RestoreFrom. The copy holds the pages of the tree and of the free list as
+// of the last commit; free pages are left out. Transactions go on while the
+// tree pages are written, paced by Maintenance: only the free-list nodes,
+// which commits rewrite in place, are copied with commits blocked.
+func (kv *KV) Backup(w io.Writer) (BackupReport, error) {
+	return kv.backup(w, 0, false)
+}
+
+// BackupSince writes an incremental backup to w: like Backup, but only with
+// the pages written after version gen, the Version of an earlier backup.
+// ApplyBackup lays it over a file restored from that backup. The generation
+// of each page is kept in memory, so the first incremental backup after
+// Open holds every page, as does one after Compact.
+func (kv *KV) BackupSince(gen uint64, w io.Writer) (BackupReport, error) {
+	return kv.backup(w, gen, true)
+}
+
+func (kv *KV) backup(w io.Writer, since uint64, incremental bool) (BackupReport, error) {
+	kv.commitMu.Lock()
+	if kv.failed != nil {
+		kv.commitMu.Unlock()
+		return BackupReport{}, fmt.Errorf("%w: %v", ErrNeedsReopen, kv.failed)
+	}
+	if since > kv.version {
+		kv.commitMu.Unlock()
+		return BackupReport{}, fmt.Errorf("backup: base version %d is ahead of the store (%d)", since, kv.version)
+	}
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	rep := BackupReport{Version: r.version, Pages: kv.page.flushed}
+	free := kv.free.Head
+	pages := map[uint64][]byte{}
+	for _, ptr := range btree.FreeListNodes(&r, free, rep.Pages) {
+		pages[ptr] = bytes.Clone(r.PageGet(ptr).Data)
+	}
+	var gens []uint64
+	if incremental {
+		gens = slices.Clone(kv.page.gens)
+	}
+	kv.commitMu.Unlock()
+
+	btree.TreePages(&r, r.tree.Root, func(ptr uint64) { pages[ptr] = nil })
+	ptrs := slices.Sorted(maps.Keys(pages))
+	if incremental {
+		ptrs = slices.DeleteFunc(ptrs, func(ptr uint64) bool { return gens[ptr] <= since })
+	}
+
+	hdr := make([]byte, backupHeader)
+	copy(hdr, backupSig)
+	binary.LittleEndian.PutUint32(hdr[8:], backupVersion)
+	binary.LittleEndian.PutUint32(hdr[12:], btree.PageSize)
+	binary.LittleEndian.PutUint32(hdr[16:], formatVersion)
+	binary.LittleEndian.PutUint16(hdr[20:], uint16(kv.limits.key))
+	binary.LittleEndian.PutUint16(hdr[22:], uint16(kv.limits.val))
+	binary.LittleEndian.PutUint64(hdr[24:], rep.Pages)
+	binary.LittleEndian.PutUint64(hdr[32:], r.tree.Root)
+	binary.LittleEndian.PutUint64(hdr[40:], free)
+	binary.LittleEndian.PutUint64(hdr[48:], rep.Version)
+	binary.LittleEndian.PutUint32(hdr[56:], crc32.ChecksumIEEE(hdr[:56]))
+	if incremental {
+		hdr = append(hdr, backupRecord(backupBase, since)...)
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return rep, fmt.Errorf("backup: %w", err)
+	}
+
+	rec := make([]byte, 1+8+btree.PageSize+4)
+	rec[0] = backupPage
+	for _, ptr := range ptrs {
+		data := pages[ptr]
+		if data == nil {
+			data = r.PageGet(ptr).Data
+		}
+		kv.Maintenance.Wait(1, btree.PageSize)
+		binary.LittleEndian.PutUint64(rec[1:], ptr)
+		copy(rec[9:], data[:btree.PageSize])
+		binary.LittleEndian.PutUint32(rec[9+btree.PageSize:], crc32.ChecksumIEEE(rec[:9+btree.PageSize]))
+		if _, err := w.Write(rec); err != nil {
+			return rep, fmt.Errorf("backup: %w", err)
+		}
+		rep.Written++
+	}
+
+	if _, err := w.Write(backupRecord(backupEnd, uint64(rep.Written))); err != nil {
+		return rep, fmt.Errorf("backup: %w", err)
+	}
+	return rep, nil
+}
+
+// backupRecord encodes a base or end record.
+func backupRecord(typ byte, val uint64) []byte {
+	rec := make([]byte, 1+8+4)
+	rec[0] = typ
+	binary.LittleEndian.PutUint64(rec[1:], val)
+	binary.LittleEndian.PutUint32(rec[9:], crc32.ChecksumIEEE(rec[:9]))
+	return rec
+}
+
+// stampPages records gen as the generation of the pages tx writes. It runs
+// under commitMu.
+func (kv *KV) stampPages(tx *KVTX, gen uint64) {
+	for ptr, page := range tx.page.updates {
+		if page == nil {
+			continue
+		}
+		if n := int(ptr) + 1; n > len(kv.page.gens) {
+			kv.page.gens = append(kv.page.gens, make([]uint64, n-len(kv.page.gens))...)
+		}
+		kv.page.gens[ptr] = gen
+	}
+}
+
+// stampAll records gen as the generation of every page of the file.
+func (kv *KV) stampAll(gen uint64) {
+	kv.page.gens = make([]uint64, kv.page.flushed)
+	for i := range kv.page.gens {
+		kv.page.gens[i] = gen
+	}
+}
+
+// RestoreFrom reads a backup written by KV.Backup from r and writes it to a
+// new database file at path. Neither path nor its WAL may exist. The stream
+// is checked as it is read: signature, header and record checksums, page
+// numbers and the page count of the end record. The restored tree and free
+// list must then pass btree.Check. Only then is the file renamed into
+// place, so a damaged or cut-short stream leaves nothing behind.
+func RestoreFrom(r io.Reader, path string) error {
+	for _, p := range []string{path, path + ".wal"} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("restore: %s: %w", p, os.ErrExist)
+		}
+	}
+	if err := installFile(path, path+".restore", nil, func(fp *os.File) error { return restore(r, fp, nil) }); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	return nil
+}
+
+// ApplyBackup reads an incremental backup written by KV.BackupSince from r
+// and lays it over the closed database file at path, which must hold the
+// base version of the backup: it was restored from the backup whose Version
+// BackupSince was given, or brought up to it by earlier increments. The
+// stream is checked like in RestoreFrom and applied to a copy of the file,
+// which replaces it only if the result is intact.
+func ApplyBackup(r io.Reader, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("apply backup: %w", err)
+	}
+	defer src.Close()
+	if fi, err := os.Stat(path + ".wal"); err == nil && fi.Size() > 16 {
+		return fmt.Errorf("apply backup: %s has commits in its WAL: open and close it first", path)
+	}
+	master := make([]byte, masterSize)
+	if _, err := src.ReadAt(master, 0); err != nil || !bytes.HasPrefix(master, []byte(dbSig)) {
+		return fmt.Errorf("apply backup: %s: bad master page", path)
+	}
+	base := binary.LittleEndian.Uint64(master[40:])
+	err = installFile(path, path+".restore", src, func(fp *os.File) error { return restore(r, fp, &base) })
+	if err != nil {
+		return fmt.Errorf("apply backup: %w", err)
+	}
+	return nil
+}
+
+// restore writes the backup in r to fp. base is nil for a full backup, or
+// the version fp holds for an incremental one.
+func restore(r io.Reader, fp *os.File, base *uint64) error {
+	hdr := make([]byte, backupHeader)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return backupReadErr(err)
+	}
+	if string(hdr[:len(backupSig)]) != backupSig {
+		return fmt.Errorf("%w: bad signature", ErrBadBackup)
+	}
+	if binary.LittleEndian.Uint32(hdr[56:]) != crc32.ChecksumIEEE(hdr[:56]) {
+		return fmt.Errorf("%w: header checksum mismatch", ErrBadBackup)
+	}
+	if v := binary.LittleEndian.Uint32(hdr[8:]); v > backupVersion {
+		return fmt.Errorf("%w: unsupported stream version %d (max %d)", ErrBadBackup, v, backupVersion)
+	}
+	if size := binary.LittleEndian.Uint32(hdr[12:]); size != btree.PageSize {
+		return fmt.Errorf("%w: page size %d, this build uses %d", ErrBadBackup, size, btree.PageSize)
+	}
+	if v := binary.LittleEndian.Uint32(hdr[16:]); v > formatVersion {
+		return fmt.Errorf("%w: unsupported format version %d (max %d)", ErrBadBackup, v, formatVersion)
+	}
+	npages := binary.LittleEndian.Uint64(hdr[24:])
+	root := binary.LittleEndian.Uint64(hdr[32:])
+	free := binary.LittleEndian.Uint64(hdr[40:])
+	version := binary.LittleEndian.Uint64(hdr[48:])
+	// Streams of format revision 1 carry no limits, and take the defaults.
+	limits := btree.BTree{
+		MaxKey: int(binary.LittleEndian.Uint16(hdr[20:])),
+		MaxVal: int(binary.LittleEndian.Uint16(hdr[22:])),
+	}
+	maxKey, maxVal := limits.Limits()
+	if npages < 1 || root >= npages || free >= npages || btree.CheckLimits(maxKey, maxVal) != nil {
+		return fmt.Errorf("%w: bad header", ErrBadBackup)
+	}
+
+	rec := make([]byte, 1+8+btree.PageSize+4)
+	record := func() (byte, uint64, error) { // reads a base or end record
+		small := rec[:1+8+4]
+		if _, err := io.ReadFull(r, small[1:]); err != nil {
+			return 0, 0, backupReadErr(err)
+		}
+		if binary.LittleEndian.Uint32(small[9:]) != crc32.ChecksumIEEE(small[:9]) {
+			return 0, 0, fmt.Errorf("%w: record checksum mismatch", ErrBadBackup)
+		}
+		return small[0], binary.LittleEndian.Uint64(small[1:]), nil
+	}
+	if _, err := io.ReadFull(r, rec[:1]); err != nil {
+		return backupReadErr(err)
+	}
+	switch {
+	case rec[0] == backupBase && base == nil:
+		return fmt.Errorf("%w: an incremental backup, to be applied with ApplyBackup", ErrBadBackup)
+	case rec[0] == backupBase:
+		_, since, err := record()
+		if err != nil {
+			return err
+		}
+		if since != *base {
+			return fmt.Errorf("%w: taken since version %d, the file holds version %d", ErrBadBackup, since, *base)
+		}
+		if _, err := io.ReadFull(r, rec[:1]); err != nil {
+			return backupReadErr(err)
+		}
+	case base != nil:
+		return fmt.Errorf("%w: a full backup, to be restored with RestoreFrom", ErrBadBackup)
+	}
+	if err := fp.Truncate(int64(npages) * btree.PageSize); err != nil {
+		return err
+	}
+
+	prev, n := uint64(0), uint64(0)
+	for {
+		switch rec[0] {
+		case backupPage:
+			if _, err := io.ReadFull(r, rec[1:]); err != nil {
+				return backupReadErr(err)
+			}
+			ptr := binary.LittleEndian.Uint64(rec[1:])
+			if binary.LittleEndian.Uint32(rec[9+btree.PageSize:]) != crc32.ChecksumIEEE(rec[:9+btree.PageSize]) {
+				return fmt.Errorf("%w: page %d checksum mismatch", ErrBadBackup, ptr)
+			}
+			if ptr <= prev || ptr >= npages {
+				return fmt.Errorf("%w: page %d out of order or outside the file (%d pages)", ErrBadBackup, ptr, npages)
+			}
+			if _, err := fp.WriteAt(rec[9:9+btree.PageSize], int64(ptr)*btree.PageSize); err != nil {
+				return err
+			}
+			prev = ptr
+			n++
+		case backupEnd:
+			_, count, err := record()
+			if err != nil {
+				return err
+			}
+			if count != n {
+				return fmt.Errorf("%w: %d pages, the end record says %d", ErrBadBackup, n, count)
+			}
+			report := btree.Check(&salvageStore{fp: fp}, root, free, npages)
+			if !report.OK() {
+				return fmt.Errorf("%w: %v", ErrBadBackup, report.Problems[0])
+			}
+			_, err = fp.WriteAt(masterData(root, npages, free, version, maxKey, maxVal), 0)
+			return err
+		default:
+			return fmt.Errorf("%w: unknown record type %d", ErrBadBackup, rec[0])
+		}
+		if _, err := io.ReadFull(r, rec[:1]); err != nil {
+			return backupReadErr(err)
+		}
+	}
+}
+
+// backupReadErr reports a read error, a stream that ends early as a bad
+// backup.
+func backupReadErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: stream ends early", ErrBadBackup)
+	}
+	return err
+}
@@ -0,0 +1,262 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/throttle"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	db := &KV{Path: filepath.Join(dir, "src.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	// An empty store restores to an empty file.
+	var buf bytes.Buffer
+	rep, err := db.Backup(&buf)
+	is.NoError(t, err)
+	is.Equal(t, BackupReport{Version: 0, Pages: 1}, rep)
+	is.NoError(t, RestoreFrom(&buf, filepath.Join(dir, "empty.db")))
+	empty := &KV{Path: filepath.Join(dir, "empty.db"), NoSync: true}
+	is.NoError(t, empty.Open())
+	is.Zero(t, empty.Check().Keys)
+	empty.Close()
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "k%05d", i) }
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 3000 {
+		tx.Update(&btree.InsertReq{Key: key(i), Val: bytes.Repeat([]byte{byte(i)}, 200)})
+	}
+	is.NoError(t, db.Commit(&tx))
+	tx = KVTX{}
+	db.Begin(&tx)
+	for i := 0; i < 3000; i += 2 {
+		tx.Del(&btree.DeleteReq{Key: key(i)})
+	}
+	is.NoError(t, db.Commit(&tx))
+	st := db.FreeStats()
+	is.Greater(t, st.FreePages, 0)
+
+	buf.Reset()
+	rep, err = db.Backup(&buf)
+	is.NoError(t, err)
+	is.Equal(t, st.Pages, rep.Pages)
+	is.Equal(t, int(st.Pages)-1-st.FreePages, rep.Written)
+	stream := buf.Bytes()
+
+	// Commits after the backup are not in it.
+	tx = KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("later"), Val: []byte("x")})
+	is.NoError(t, db.Commit(&tx))
+
+	path := filepath.Join(dir, "restored.db")
+	is.NoError(t, RestoreFrom(bytes.NewReader(stream), path))
+	_, err = os.Stat(path + ".restore")
+	is.ErrorIs(t, err, os.ErrNotExist)
+	restored := &KV{Path: path, NoSync: true}
+	is.NoError(t, restored.Open())
+	defer restored.Close()
+	check := restored.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(1500), check.Keys)
+	is.Equal(t, st.FreePages, check.FreePages)
+	is.Equal(t, rep.Version, restored.Stats().Version)
+	r := KVReader{}
+	restored.BeginRead(&r)
+	for i := range 3000 {
+		val, ok := r.Get(key(i))
+		is.Equal(t, i%2 == 1, ok, i)
+		if ok {
+			is.True(t, bytes.Equal(bytes.Repeat([]byte{byte(i)}, 200), val), i)
+		}
+	}
+	_, ok := r.Get([]byte("later"))
+	is.False(t, ok)
+	restored.EndRead(&r)
+
+	// The restored file takes writes.
+	tx = KVTX{}
+	restored.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("new"), Val: []byte("v")})
+	is.NoError(t, restored.Commit(&tx))
+
+	// Damaged or cut-short streams leave no file behind.
+	is.ErrorIs(t, RestoreFrom(bytes.NewReader(stream), path), os.ErrExist)
+	bad := filepath.Join(dir, "bad.db")
+	flip := bytes.Clone(stream)
+	flip[backupHeader+100] ^= 1
+	for name, data := range map[string][]byte{
+		"signature": append([]byte("NotElk!\000"), stream[8:]...),
+		"checksum":  flip,
+		"truncated": stream[:len(stream)-20],
+		"no end":    stream[:len(stream)-13],
+		"empty":     nil,
+	} {
+		err := RestoreFrom(bytes.NewReader(data), bad)
+		is.ErrorIs(t, err, ErrBadBackup, name)
+		_, err = os.Stat(bad)
+		is.ErrorIs(t, err, os.ErrNotExist, name)
+		_, err = os.Stat(bad + ".restore")
+		is.ErrorIs(t, err, os.ErrNotExist, name)
+	}
+}
+
+func TestBackupConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := &KV{Path: filepath.Join(dir, "src.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 2000 {
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%05d", i), Val: make([]byte, 100)})
+	}
+	is.NoError(t, db.Commit(&tx))
+
+	// Writers replace keys, freeing and reusing pages and rewriting the free
+	// list, while a slow backup runs.
+	db.Maintenance = throttle.New(0, 2000)
+	var stop atomic.Bool
+	done := make(chan int)
+	go func() {
+		n := 0
+		for !stop.Load() {
+			tx := KVTX{}
+			db.Begin(&tx)
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%05d", n%2000), Val: fmt.Appendf(nil, "v%d", n)})
+			if db.Commit(&tx) == nil {
+				n++
+			}
+		}
+		done <- n
+	}()
+	var buf bytes.Buffer
+	rep, err := db.Backup(&buf)
+	stop.Store(true)
+	is.Greater(t, <-done, 0)
+	is.NoError(t, err)
+
+	path := filepath.Join(dir, "restored.db")
+	is.NoError(t, RestoreFrom(&buf, path))
+	restored := &KV{Path: path, NoSync: true}
+	is.NoError(t, restored.Open())
+	defer restored.Close()
+	check := restored.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(2000), check.Keys)
+	is.Equal(t, rep.Version, restored.Stats().Version)
+}
+
+func TestBackupSince(t *testing.T) {
+	dir := t.TempDir()
+	db := &KV{Path: filepath.Join(dir, "src.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer func() { db.Close() }()
+	key := func(i int) []byte { return fmt.Appendf(nil, "k%05d", i) }
+	apply := func(f func(tx *KVTX)) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		f(&tx)
+		is.NoError(t, db.Commit(&tx))
+	}
+	apply(func(tx *KVTX) {
+		for i := range 3000 {
+			tx.Update(&btree.InsertReq{Key: key(i), Val: make([]byte, 200)})
+		}
+	})
+	path := filepath.Join(dir, "restored.db")
+	same := func() {
+		restored := &KV{Path: path, NoSync: true}
+		is.NoError(t, restored.Open())
+		defer restored.Close()
+		check := restored.Check()
+		is.True(t, check.OK(), "%v", check.Problems)
+		is.Equal(t, db.Stats().Version, restored.Stats().Version)
+		a, b := KVReader{}, KVReader{}
+		db.BeginRead(&a)
+		restored.BeginRead(&b)
+		for i := range 4000 {
+			va, oka := a.Get(key(i))
+			vb, okb := b.Get(key(i))
+			is.True(t, oka == okb && bytes.Equal(va, vb), i)
+		}
+		db.EndRead(&a)
+		restored.EndRead(&b)
+	}
+
+	var buf bytes.Buffer
+	full, err := db.Backup(&buf)
+	is.NoError(t, err)
+	is.NoError(t, RestoreFrom(&buf, path))
+
+	// A few changes make a small increment.
+	apply(func(tx *KVTX) {
+		for i := 0; i < 3000; i += 300 {
+			tx.Update(&btree.InsertReq{Key: key(i), Val: []byte("changed")})
+			tx.Del(&btree.DeleteReq{Key: key(i + 1)})
+		}
+		tx.Update(&btree.InsertReq{Key: key(3500), Val: []byte("new")})
+	})
+	buf.Reset()
+	inc, err := db.BackupSince(full.Version, &buf)
+	is.NoError(t, err)
+	is.Equal(t, full.Version+1, inc.Version)
+	is.Less(t, inc.Written, full.Written/4)
+	stream := bytes.Clone(buf.Bytes())
+	is.NoError(t, ApplyBackup(&buf, path))
+	same()
+
+	// The same increment no longer fits the file; a full backup is not an
+	// increment, nor an increment a full backup.
+	is.ErrorIs(t, ApplyBackup(bytes.NewReader(stream), path), ErrBadBackup)
+	is.ErrorIs(t, RestoreFrom(bytes.NewReader(stream), filepath.Join(dir, "other.db")), ErrBadBackup)
+	buf.Reset()
+	_, err = db.Backup(&buf)
+	is.NoError(t, err)
+	is.ErrorIs(t, ApplyBackup(&buf, path), ErrBadBackup)
+	_, err = os.Stat(path + ".restore")
+	is.ErrorIs(t, err, os.ErrNotExist)
+	same()
+
+	// Deleting the upper keys cuts pages off the end of the file, which
+	// rewrites the free list without a new version.
+	apply(func(tx *KVTX) {
+		for i := 1500; i < 3000; i++ {
+			tx.Del(&btree.DeleteReq{Key: key(i)})
+		}
+	})
+	is.NotZero(t, db.Metrics().PagesTruncated)
+	buf.Reset()
+	inc, err = db.BackupSince(inc.Version, &buf)
+	is.NoError(t, err)
+	is.NoError(t, ApplyBackup(&buf, path))
+	same()
+	fi, err := os.Stat(path)
+	is.NoError(t, err)
+	is.Equal(t, int64(inc.Pages)*btree.PageSize, fi.Size())
+
+	// Generations are not kept across Open: the next increment is complete.
+	db.Close()
+	db = &KV{Path: filepath.Join(dir, "src.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	buf.Reset()
+	again, err := db.BackupSince(inc.Version, &buf)
+	is.NoError(t, err)
+	st := db.FreeStats()
+	is.Equal(t, int(st.Pages)-1-st.FreePages, again.Written)
+	is.NoError(t, ApplyBackup(&buf, path))
+	same()
+
+	_, err = db.BackupSince(again.Version+1, &buf)
+	is.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package kv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+	"github.com/MHS-20/ElkDB/throttle"
+)
+
+// compactAttempts bounds the copies Compact makes while writers commit; the
+// last one is made with commits blocked.
+const compactAttempts = 3
+
+// CompactReport describes what Compact did.
+type CompactReport struct {
+	PagesBefore uint64 // pages in use before, the master page included
+	PagesAfter  uint64 // and after
+	Attempts    int    // copies made: a commit during a copy forces another
+}
+
+// Compact rewrites the store into a new file, like SQLite's VACUUM: the tree
+// is copied into densely packed pages (see btree.Copy), the free list starts
+// empty and the file is exactly as long as the pages in use. Deleting data
+// otherwise never shrinks the file.
+//
+// The copy is made from a snapshot into Path+".compact" while transactions
+// go on, paced by Maintenance. If a commit lands in the meantime the copy is
+// thrown away and made again; after compactAttempts tries, the last copy is
+// made with commits blocked. The WAL is then checkpointed and the new file
+// renamed over the old one. Readers that began before keep reading the old
+// file, whose space is returned to the OS once the KV is closed. Write
+// transactions that began before fail to commit with a conflict.
+func (kv *KV) Compact() (CompactReport, error) {
+	if kv.ReadOnly {
+		return CompactReport{}, fmt.Errorf("compact: %w", ErrReadOnly)
+	}
+	var rep CompactReport
+	for {
+		rep.Attempts++
+		last := rep.Attempts == compactAttempts
+		lim := kv.Maintenance
+		if last {
+			kv.commitMu.Lock()
+			lim = nil // do not keep writers waiting longer than needed
+		}
+		r := KVReader{}
+		kv.BeginRead(&r)
+		restore := kv.adviseSequential(r.mmap.chunks)
+		root, npages, err := kv.compactCopy(&r, lim)
+		restore()
+		kv.EndRead(&r)
+		if !last {
+			kv.commitMu.Lock()
+		}
+		if err == nil && kv.version == r.version {
+			rep.PagesBefore = kv.page.flushed
+			err = kv.compactSwap(root, npages)
+			rep.PagesAfter = kv.page.flushed
+		}
+		kv.commitMu.Unlock()
+		if err != nil || rep.PagesAfter != 0 {
+			os.Remove(kv.Path + ".compact")
+			return rep, err
+		}
+	}
+}
+
+// compactCopy writes the tree of r, with a master page for the version after
+// it, to Path+".compact" and returns the new root and page count.
+func (kv *KV) compactCopy(r *KVReader, lim *throttle.Limiter) (uint64, uint64, error) {
+	fp, err := os.OpenFile(kv.Path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, 0, fmt.Errorf("compact: %w", err)
+	}
+	defer fp.Close()
+
+	root, npages, err := copyTree(fp, r, r.version+1, lim)
+	if err == nil && !kv.NoSync {
+		err = fp.Sync()
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("compact: %w", err)
+	}
+	return root, npages, nil
+}
+
+// copyTree writes a packed copy of the tree of r (see btree.Copy) to fp, an
+// empty file, with a master page for version and an empty free list. It
+// returns the root and page count of the copy.
+func copyTree(fp *os.File, r *KVReader, version uint64, lim *throttle.Limiter) (uint64, uint64, error) {
+	store := &compactStore{w: bufio.NewWriterSize(fp, 1<<20), next: 1, lim: lim}
+	store.write(make([]byte, btree.PageSize)) // the master page, written last
+	root := btree.Copy(r, r.tree.Root, store)
+	if store.err == nil {
+		store.err = store.w.Flush()
+	}
+	if store.err == nil {
+		maxKey, maxVal := r.tree.Limits()
+		_, store.err = fp.WriteAt(masterData(root, store.next, 0, version, maxKey, maxVal), 0)
+	}
+	if store.err != nil {
+		return 0, 0, store.err
+	}
+	return root, store.next, nil
+}
+
+// SnapshotTo writes the committed state of the store to a new database file
+// at path, for test fixtures or a copy to run analytics on. Neither path nor
+// its WAL may exist. Like Compact, it copies the tree of a snapshot into
+// densely packed pages with an empty free list, paced by Maintenance;
+// writers are not blocked, and their later commits are not in the copy. The
+// new file holds the version of the snapshot. It is written as
+// path+".snapshot" and renamed into place once complete.
+func (kv *KV) SnapshotTo(path string) error {
+	for _, p := range []string{path, path + ".wal"} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("snapshot: %s: %w", p, os.ErrExist)
+		}
+	}
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	defer kv.adviseSequential(r.mmap.chunks)()
+	err := installFile(path, path+".snapshot", nil, func(fp *os.File) error {
+		_, _, err := copyTree(fp, &r, r.version, kv.Maintenance)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+// compactSwap replaces the data file with the copy. It runs under commitMu.
+func (kv *KV) compactSwap(root, npages uint64) error {
+	if kv.failed != nil {
+		return fmt.Errorf("%w: %v", ErrNeedsReopen, kv.failed)
+	}
+	// The WAL holds pages of the old file: it must be empty before the
+	// rename, or recovery would lay them over the new one.
+	if hasData, err := kv.wal.HasData(); err != nil || hasData {
+		if err == nil {
+			err = kv.wal.Checkpoint(kv)
+			kv.events.Publish(events.Event{Kind: events.Checkpoint, Version: kv.version, Err: err})
+		}
+		if err != nil {
+			return fmt.Errorf("compact: %w", err)
+		}
+	}
+	// The copy is locked before it takes the place of the locked file, so
+	// that no other process can open it in between.
+	fp, err := os.OpenFile(kv.Path+".compact", os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	if err := lockFile(fp); err != nil {
+		fp.Close()
+		return fmt.Errorf("compact: %w", err)
+	}
+	if err := os.Rename(kv.Path+".compact", kv.Path); err != nil {
+		fp.Close()
+		return fmt.Errorf("compact: %w", err)
+	}
+
+	// From here on the file on disk is the new one, so failing to map it
+	// leaves the KV unusable until Reopen.
+	if !kv.NoSync {
+		if err := syncDir(filepath.Dir(kv.Path)); err != nil {
+			fp.Close()
+			kv.failed = err
+			return fmt.Errorf("compact: %w", err)
+		}
+	}
+	size, chunk, reserved, err := kv.mmapInit(fp)
+	if err != nil {
+		fp.Close()
+		kv.failed = err
+		return fmt.Errorf("compact: %w", err)
+	}
+	before := kv.page.flushed
+	kv.fp.Close()
+	kv.fp = fp
+	kv.page.flushed = npages
+	clear(kv.punch.pending)
+	kv.pageAllocMu.Lock()
+	kv.pageAlloc = npages
+	kv.pageAllocMu.Unlock()
+
+	kv.mu.Lock()
+	kv.free = btree.FreeListData{}
+	kv.unlockMemory(0)
+	kv.mmap.retired = append(kv.mmap.retired, kv.regions()...)
+	kv.mmap.file, kv.mmap.total, kv.mmap.chunks = size, len(chunk), [][]byte{chunk}
+	kv.mmap.reserved = reserved
+	kv.mmap.huge = 0
+	_ = adviseChunks(kv.mmap.chunks, kv.access)
+	kv.tree.root = root
+	kv.version++
+	version := kv.version
+	kv.mu.Unlock()
+	kv.lockMemory()
+	kv.adviseHuge()
+	kv.stampAll(version)
+	summary := kv.buildSummary(root)
+	kv.mu.Lock()
+	kv.summary = summary
+	kv.mu.Unlock()
+	kv.keepVersion()
+
+	kv.closeFeeds(ErrFeedReset)
+	kv.events.Publish(events.Event{Kind: events.Compaction, Version: version})
+	kv.log().Info("kv: compacted", "path", kv.Path, "version", version, "from", before, "to", npages)
+	return nil
+}
+
+// compactStore appends the pages of btree.Copy to a file in order. Write
+// errors are kept in err; the copy carries on and its result is discarded.
+type compactStore struct {
+	w    *bufio.Writer
+	next uint64 // number of the next page
+	lim  *throttle.Limiter
+	err  error
+}
+
+func (s *compactStore) write(data []byte) {
+	s.lim.Wait(1, btree.PageSize)
+	if s.err == nil {
+		_, s.err = s.w.Write(data)
+	}
+}
+
+func (s *compactStore) PageNew(node btree.BNode) uint64 {
+	s.write(node.Data[:btree.PageSize])
+	s.next++
+	return s.next - 1
+}
+
+func (s *compactStore) PageGet(uint64) btree.BNode { panic("write-only store") }
+func (s *compactStore) PageDel(uint64)             { panic("write-only store") }
+
+// installFile creates the file tmp, copies src into it and calls fn on it,
+// each if it is not nil. If that succeeds, tmp is synced and renamed to
+// path; otherwise it is removed.
+func installFile(path, tmp string, src *os.File, fn func(fp *os.File) error) error {
+	fp, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if src != nil {
+		_, err = io.Copy(fp, src)
+	}
+	if err == nil && fn != nil {
+		err = fn(fp)
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(path))
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
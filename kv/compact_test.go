@@ -0,0 +1,190 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+	"github.com/MHS-20/ElkDB/throttle"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	defer func() { db.Close() }()
+	sub := db.Events().Subscribe(0, events.Compaction)
+	defer sub.Close()
+
+	rep, err := db.Compact() // an empty store
+	is.NoError(t, err)
+	is.Equal(t, uint64(1), rep.PagesAfter)
+	is.Equal(t, uint64(1), (<-sub.C).Version)
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "k%05d", i) }
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 5000 {
+		tx.Update(&btree.InsertReq{Key: key(i), Val: make([]byte, 100)})
+	}
+	is.NoError(t, db.Commit(&tx))
+	tx = KVTX{}
+	db.Begin(&tx)
+	for i := range 5000 {
+		if i%10 != 0 {
+			tx.Del(&btree.DeleteReq{Key: key(i)})
+		}
+	}
+	is.NoError(t, db.Commit(&tx))
+
+	// A snapshot from before keeps reading the old file; a write
+	// transaction from before fails to commit.
+	old := KVReader{}
+	db.BeginRead(&old)
+	stale := KVTX{}
+	db.Begin(&stale)
+	stale.Update(&btree.InsertReq{Key: []byte("stale"), Val: []byte("x")})
+
+	before := db.Stats()
+	rep, err = db.Compact()
+	is.NoError(t, err)
+	is.Equal(t, 1, rep.Attempts)
+	is.Equal(t, before.Pages, rep.PagesBefore)
+	is.Less(t, rep.PagesAfter, before.Pages/4)
+	is.Equal(t, before.Version+1, (<-sub.C).Version)
+
+	after := db.Stats()
+	is.Equal(t, rep.PagesAfter, after.Pages)
+	is.Zero(t, after.FreePages)
+	is.Equal(t, before.Version+1, after.Version)
+	fi, err := os.Stat(path)
+	is.NoError(t, err)
+	is.Equal(t, int64(rep.PagesAfter)*btree.PageSize, fi.Size())
+	_, err = os.Stat(path + ".compact")
+	is.ErrorIs(t, err, os.ErrNotExist)
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(500), check.Keys)
+
+	_, ok := old.Get(key(500))
+	is.True(t, ok)
+	db.EndRead(&old)
+	is.Error(t, db.Commit(&stale))
+
+	// The compacted store takes writes and survives a reopen.
+	tx = KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("new"), Val: []byte("v")})
+	is.NoError(t, db.Commit(&tx))
+	db.Close()
+	db = &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	r := KVReader{}
+	db.BeginRead(&r)
+	for i := range 5000 {
+		_, ok := r.Get(key(i))
+		is.Equal(t, i%10 == 0, ok, i)
+	}
+	val, ok := r.Get([]byte("new"))
+	is.True(t, ok)
+	is.Equal(t, []byte("v"), val)
+	db.EndRead(&r)
+	is.Equal(t, before.Version+2, db.Stats().Version)
+}
+
+func TestCompactConcurrentWrites(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "compact.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 2000 {
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%05d", i), Val: make([]byte, 100)})
+	}
+	is.NoError(t, db.Commit(&tx))
+
+	// Slow copies, each overtaken by commits, until the last one blocks them.
+	db.Maintenance = throttle.New(0, 2000)
+	var stop atomic.Bool
+	done := make(chan int)
+	go func() {
+		n := 0
+		for !stop.Load() {
+			tx := KVTX{}
+			db.Begin(&tx)
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "w%05d", n), Val: []byte("x")})
+			if db.Commit(&tx) == nil {
+				n++
+			}
+		}
+		done <- n
+	}()
+	rep, err := db.Compact()
+	stop.Store(true)
+	n := <-done
+	is.NoError(t, err)
+	is.Equal(t, compactAttempts, rep.Attempts)
+
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(2000+n), check.Keys)
+}
+
+func TestSnapshotTo(t *testing.T) {
+	dir := t.TempDir()
+	db := &KV{Path: filepath.Join(dir, "src.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 2000 {
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%05d", i), Val: make([]byte, 100)})
+	}
+	is.NoError(t, db.Commit(&tx))
+
+	// Writers are not blocked by a slow copy, and their commits stay out
+	// of it.
+	db.Maintenance = throttle.New(0, 2000)
+	var stop atomic.Bool
+	done := make(chan int)
+	go func() {
+		n := 0
+		for !stop.Load() {
+			tx := KVTX{}
+			db.Begin(&tx)
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "w%05d", n), Val: []byte("x")})
+			if db.Commit(&tx) == nil {
+				n++
+			}
+		}
+		done <- n
+	}()
+	path := filepath.Join(dir, "clone.db")
+	err := db.SnapshotTo(path)
+	stop.Store(true)
+	is.Greater(t, <-done, 0)
+	is.NoError(t, err)
+	_, err = os.Stat(path + ".snapshot")
+	is.ErrorIs(t, err, os.ErrNotExist)
+
+	clone := &KV{Path: path, NoSync: true}
+	is.NoError(t, clone.Open())
+	defer clone.Close()
+	check := clone.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Zero(t, check.FreePages)
+	version := clone.Stats().Version
+	is.Less(t, version, db.Stats().Version)
+	is.Equal(t, int64(2000+int(version)-1), check.Keys) // one key per later commit
+	tx = KVTX{}
+	clone.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("clone"), Val: []byte("v")})
+	is.NoError(t, clone.Commit(&tx))
+
+	is.ErrorIs(t, db.SnapshotTo(path), os.ErrExist)
+}
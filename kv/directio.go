@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// directAlign is the alignment O_DIRECT asks of the file offset, length and
+// memory address of a write. The logical block size of most devices divides
+// it.
+const directAlign = 4096
+
+// alignedBuf returns a zeroed buffer of n bytes whose address is a multiple
+// of directAlign.
+func alignedBuf(n int) []byte {
+	buf := make([]byte, n+directAlign)
+	off := int(uintptr(unsafe.Pointer(&buf[0])) & (directAlign - 1))
+	if off != 0 {
+		off = directAlign - off
+	}
+	return buf[off : off+n : off+n]
+}
+
+// openDirect makes the WAL append its records through a second descriptor
+// opened with O_DIRECT, which bypasses the page cache. Reads, truncation and
+// the header still go through fp. It fails if the filesystem refuses
+// O_DIRECT, and the WAL stays buffered.
+func (wal *WAL) openDirect() error {
+	direct, err := openDirect(wal.path)
+	if err != nil {
+		return err
+	}
+	end, err := wal.fp.Seek(0, io.SeekEnd)
+	if err == nil {
+		err = wal.loadTail(end)
+	}
+	if err != nil {
+		direct.Close()
+		return err
+	}
+	wal.direct = direct
+	return nil
+}
+
+// loadTail reads the partial block before end, the logical end of the WAL,
+// into wal.tail for the next direct write to rewrite.
+func (wal *WAL) loadTail(end int64) error {
+	start := end &^ (directAlign - 1)
+	wal.tail = wal.tail[:0]
+	if n := int(end - start); n > 0 {
+		wal.tail = make([]byte, n)
+		if _, err := wal.fp.ReadAt(wal.tail, start); err != nil {
+			return fmt.Errorf("read WAL tail: %w", err)
+		}
+	}
+	wal.end = end
+	return nil
+}
+
+// writeDirect appends rec at the logical end of the WAL. Direct writes cover
+// whole blocks, so it writes the last partial block again with rec after it,
+// padded with zeros up to the next block; the padding ends the records for
+// walRecords and is overwritten by the next record.
+func (wal *WAL) writeDirect(rec []byte) error {
+	buf, start := wal.directBuf(rec)
+	if _, err := wal.direct.WriteAt(buf, start); err != nil {
+		return err
+	}
+	wal.directDone(buf, rec)
+	return nil
+}
+
+// directBuf returns the aligned blocks that append rec to the WAL, and the
+// offset they are written at.
+func (wal *WAL) directBuf(rec []byte) ([]byte, int64) {
+	n := len(wal.tail) + len(rec)
+	buf := alignedBuf((n + directAlign - 1) &^ (directAlign - 1))
+	copy(buf, wal.tail)
+	copy(buf[len(wal.tail):], rec)
+	return buf, wal.end - int64(len(wal.tail))
+}
+
+// directDone moves the logical end past rec once buf, from directBuf, has
+// been written.
+func (wal *WAL) directDone(buf, rec []byte) {
+	n := len(wal.tail) + len(rec)
+	wal.end += int64(len(rec))
+	wal.tail = append(wal.tail[:0], buf[n&^(directAlign-1):n]...)
+}
@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestDirectWAL(t *testing.T) {
+	dbPath := tempDB(t)
+	db := &KV{Path: dbPath, NoSync: true, DirectWAL: true}
+	is.NoError(t, db.Open())
+	if db.wal.direct == nil {
+		db.Close()
+		t.Skip("filesystem does not support O_DIRECT")
+	}
+
+	for i := range 50 {
+		is.NoError(t, kvPut(db, fmt.Sprintf("k%02d", i), fmt.Sprintf("v%d", i)))
+	}
+	// Records end off block boundaries, and the file is padded to them.
+	fi, err := os.Stat(dbPath + ".wal")
+	is.NoError(t, err)
+	is.Zero(t, fi.Size()%directAlign)
+	is.NotZero(t, db.wal.end%directAlign)
+
+	// A torn record is cut off, and the next one rewrites its block.
+	failWrites(db.wal, 1, syscall.EIO)
+	is.ErrorIs(t, kvPut(db, "torn", "x"), syscall.EIO)
+	is.NoError(t, kvPut(db, "last", "y"))
+
+	// Simulate a crash: the commits are only in the WAL.
+	is.NoError(t, db.wal.Close())
+	for _, chunk := range db.regions() {
+		_ = syscall.Munmap(chunk)
+	}
+	_ = db.fp.Close()
+
+	db = &KV{Path: dbPath, NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	for i := range 50 {
+		v, ok := kvGet(db, fmt.Sprintf("k%02d", i))
+		is.True(t, ok)
+		is.Equal(t, fmt.Sprintf("v%d", i), v)
+	}
+	_, ok := kvGet(db, "torn")
+	is.False(t, ok)
+	v, ok := kvGet(db, "last")
+	is.True(t, ok)
+	is.Equal(t, "y", v)
+}
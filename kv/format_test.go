@@ -0,0 +1,118 @@
+package kv
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+// goldenMaster pins the master page produced by writeGoldenDB. It must be
+// byte-for-byte identical on every architecture; if a change alters it on
+// purpose, bump formatVersion.
+const goldenMaster = "456c6b44420000000000000004000000" + // signature, format version
+	"0200000000000000" + // root
+	"0600000000000000" + // used pages
+	"0500000000000000" + // free-list head
+	"c800000000000000" + // version
+	"e803b80b" // key and value size limits
+
+func writeGoldenDB(t *testing.T, path string) []byte {
+	t.Helper()
+	os.Remove(path)
+	os.Remove(path + ".wal")
+	t.Cleanup(func() { os.Remove(path); os.Remove(path + ".wal") })
+
+	db := KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	for i := 0; i < 200; i++ {
+		tx := KVTX{}
+		db.Begin(&tx)
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "key%04d", i), Val: fmt.Appendf(nil, "val%d", i*i)})
+		if i%3 == 0 && i > 0 {
+			tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "key%04d", i-1)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	db.Close()
+
+	data, err := os.ReadFile(path)
+	is.NoError(t, err)
+	return data
+}
+
+func TestFormatGolden(t *testing.T) {
+	is.Equal(t, uint32(4), FormatVersion())
+	data := writeGoldenDB(t, "golden.db")
+	is.Equal(t, goldenMaster, hex.EncodeToString(data[:masterSize]))
+}
+
+func TestFormatVersionRejected(t *testing.T) {
+	data := writeGoldenDB(t, "golden.db")
+
+	// Files from before the version field existed (0) still open.
+	binary.LittleEndian.PutUint32(data[12:], 0)
+	is.NoError(t, os.WriteFile("golden.db", data, 0o644))
+	db := KV{Path: "golden.db", NoSync: true}
+	is.NoError(t, db.Open())
+	db.Close()
+
+	binary.LittleEndian.PutUint32(data[12:], FormatVersion()+1)
+	is.NoError(t, os.WriteFile("golden.db", data, 0o644))
+	db = KV{Path: "golden.db", NoSync: true}
+	is.ErrorContains(t, db.Open(), "unsupported format version")
+
+	// Same for the WAL header.
+	os.Remove("golden.db")
+	header := make([]byte, 16)
+	copy(header, walSig)
+	binary.LittleEndian.PutUint32(header[8:], walVersion+1)
+	is.NoError(t, os.WriteFile("golden.db.wal", header, 0o644))
+	_, err := OpenWAL("golden.db.wal")
+	is.ErrorContains(t, err, "unsupported WAL version")
+}
+
+func TestFormatSentinelDropped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db := KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	is.NoError(t, kvPut(&db, "a", "1"))
+	db.Close()
+
+	// Make it a file of revision 3, whose only leaf starts with the empty
+	// key: header, pointers, offsets, then "" => "" and "a" => "1".
+	data, err := os.ReadFile(path)
+	is.NoError(t, err)
+	binary.LittleEndian.PutUint32(data[12:], 3)
+	leaf, err := hex.DecodeString("0200" + "0200" + "0000000000000000" + "0000000000000000" +
+		"0400" + "0a00" + "00000000" + "010001006131")
+	is.NoError(t, err)
+	root := binary.LittleEndian.Uint64(data[16:])
+	copy(data[root*btree.PageSize:(root+1)*btree.PageSize], make([]byte, btree.PageSize))
+	copy(data[root*btree.PageSize:], leaf)
+	is.NoError(t, os.WriteFile(path, data, 0o644))
+
+	// A follower keeps the key; a writable store drops it when opened.
+	db = KV{Path: path, NoSync: true, ReadOnly: true}
+	is.NoError(t, db.Open())
+	is.Equal(t, int64(2), db.Check().Keys)
+	db.Close()
+	db = KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(1), check.Keys)
+	val, ok := kvGet(&db, "a")
+	is.True(t, ok)
+	is.Equal(t, "1", val)
+	db.Close()
+
+	data, err = os.ReadFile(path)
+	is.NoError(t, err)
+	is.Equal(t, FormatVersion(), binary.LittleEndian.Uint32(data[12:]))
+}
@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// MasterPage is the master page of a data file, as decoded by Inspect.
+type MasterPage struct {
+	Signature     bool   // it starts with the ElkDB signature
+	FormatVersion uint32 // 0 for files from before the field existed
+	Root          uint64 // page of the tree root, 0 for an empty tree
+	Pages         uint64 // pages in use, free ones and the master page included
+	FreeHead      uint64 // head node of the free list, 0 for none
+	Version       uint64 // version of the last commit that wrote it
+	MaxKey        int    // size limits: the defaults for files of revision 1
+	MaxVal        int
+}
+
+// Inspector reads the pages of a data file as they are on disk, for
+// debugging files that are damaged or hold something unexpected. Unlike
+// Open it neither locks the file nor replays its WAL, so commits still in
+// the WAL are not seen.
+type Inspector struct {
+	Pages uint64 // whole pages in the file
+	store *salvageStore
+}
+
+// Inspect opens the data file at path for reading with an Inspector.
+func Inspect(path string) (*Inspector, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return &Inspector{Pages: uint64(fi.Size()) / btree.PageSize, store: &salvageStore{fp: fp}}, nil
+}
+
+// Close closes the file.
+func (in *Inspector) Close() error {
+	return in.store.fp.Close()
+}
+
+// Master decodes the master page, whatever it holds.
+func (in *Inspector) Master() (MasterPage, error) {
+	data := in.store.PageGet(0).Data
+	if err := in.err(); err != nil {
+		return MasterPage{}, err
+	}
+	m := MasterPage{
+		Signature:     bytes.Equal(data[:len(dbSig)], []byte(dbSig)),
+		FormatVersion: binary.LittleEndian.Uint32(data[12:]),
+		Root:          binary.LittleEndian.Uint64(data[16:]),
+		Pages:         binary.LittleEndian.Uint64(data[24:]),
+		FreeHead:      binary.LittleEndian.Uint64(data[32:]),
+		Version:       binary.LittleEndian.Uint64(data[40:]),
+	}
+	m.MaxKey, m.MaxVal = masterLimits(data)
+	return m, nil
+}
+
+// Page decodes page ptr (see btree.InspectPage). Page 0 is the master page;
+// see Master.
+func (in *Inspector) Page(ptr uint64) (btree.PageInfo, error) {
+	if ptr >= in.Pages {
+		return btree.PageInfo{}, fmt.Errorf("page %d outside the file (%d pages)", ptr, in.Pages)
+	}
+	node := in.store.PageGet(ptr)
+	if err := in.err(); err != nil {
+		return btree.PageInfo{}, err
+	}
+	return btree.InspectPage(node), nil
+}
+
+// FreeList returns the nodes of the free list from its head (see
+// btree.FreeListChain), with the problems that stopped it early.
+func (in *Inspector) FreeList() ([]uint64, []btree.Problem, error) {
+	m, err := in.Master()
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes, problems := btree.FreeListChain(in.store, m.FreeHead, in.Pages)
+	return nodes, problems, in.err()
+}
+
+// err returns the first read error of the file.
+func (in *Inspector) err() error {
+	if in.store.err != nil {
+		return fmt.Errorf("inspect: %w", in.store.err)
+	}
+	return nil
+}
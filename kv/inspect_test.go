@@ -0,0 +1,65 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inspect.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	for i := range 300 {
+		is.NoError(t, kvPut(db, fmt.Sprintf("key%04d", i), "val"))
+	}
+	st := db.Stats()
+	db.Close()
+
+	in, err := Inspect(path)
+	is.NoError(t, err)
+	defer in.Close()
+	m, err := in.Master()
+	is.NoError(t, err)
+	is.True(t, m.Signature)
+	is.Equal(t, FormatVersion(), m.FormatVersion)
+	is.Equal(t, st.Pages, m.Pages)
+	is.Equal(t, st.Version, m.Version)
+	is.Equal(t, btree.MaxKeySize, m.MaxKey)
+
+	// The root leads to the leaves, which hold the keys in order.
+	root, err := in.Page(m.Root)
+	is.NoError(t, err)
+	is.Equal(t, uint16(btree.BNodeInternal), root.Type)
+	is.Empty(t, root.Problem)
+	is.Equal(t, "key0000", string(root.Keys[0]))
+	leaf, err := in.Page(root.Ptrs[0])
+	is.NoError(t, err)
+	is.Equal(t, uint16(btree.BNodeLeaf), leaf.Type)
+	is.Equal(t, "val", string(leaf.Vals[0]))
+
+	nodes, problems, err := in.FreeList()
+	is.NoError(t, err)
+	is.Empty(t, problems)
+	is.Equal(t, m.FreeHead, nodes[0])
+	head, err := in.Page(nodes[0])
+	is.NoError(t, err)
+	is.Equal(t, uint16(btree.BNodeFreeList), head.Type)
+	is.Equal(t, uint64(st.FreePages), head.Total)
+	_, err = in.Page(in.Pages)
+	is.ErrorContains(t, err, "outside the file")
+
+	// A damaged page is described, not decoded.
+	data, err := os.ReadFile(path)
+	is.NoError(t, err)
+	copy(data[m.Root*btree.PageSize+2:], []byte{0xff, 0xff}) // nkeys
+	is.NoError(t, os.WriteFile(path, data, 0o644))
+	root, err = in.Page(m.Root)
+	is.NoError(t, err)
+	is.Nil(t, root.Keys)
+	is.NotEmpty(t, root.Problem)
+}
@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+)
+
+// ---------------------------------------------------------------------------
+// I/O error classification and retry
+// ---------------------------------------------------------------------------
+
+// ErrNeedsReopen is returned (wrapped) by Commit once a failed write could
+// not be rolled back, leaving the WAL in an unknown state. KV.Reopen
+// recovers from the WAL and clears it.
+var ErrNeedsReopen = errors.New("KV needs reopen")
+
+// IOEvent reports one failed I/O operation to KV.OnIOError.
+type IOEvent struct {
+	Op        string // "extend file", "reserve pages", "WAL append" or "master store"
+	Err       error
+	Attempt   int  // 1 for the first try
+	Transient bool // IsTransient(Err)
+	Retrying  bool // the operation will be tried again
+}
+
+// transientErrnos are the errors that may go away on their own: an
+// interrupted call, a full disk or quota that gets cleaned up, or a network
+// filesystem that timed out or lost its handle.
+var transientErrnos = []syscall.Errno{
+	syscall.EINTR, syscall.EAGAIN, syscall.ENOSPC, syscall.EDQUOT,
+	syscall.ETIMEDOUT, syscall.ESTALE,
+}
+
+// IsTransient reports whether err is worth retrying. Anything else, EIO
+// included, is treated as permanent.
+func IsTransient(err error) bool {
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryIO runs fn, retrying it up to kv.IORetries times while it fails with
+// a transient error. fn must be safe to repeat: it either had no effect or
+// undid its effect before returning the error. The pause between attempts
+// starts at kv.IORetryDelay and doubles each time.
+func (kv *KV) retryIO(op string, fn func() error) error {
+	delay := kv.IORetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		transient := IsTransient(err)
+		retrying := transient && attempt <= kv.IORetries && kv.failed == nil
+		if kv.OnIOError != nil {
+			kv.OnIOError(IOEvent{op, err, attempt, transient, retrying})
+		}
+		kv.events.Publish(events.Event{Kind: events.IOError, Err: fmt.Errorf("%s: %w", op, err)})
+		kv.log().Warn("kv: I/O error", "op", op, "attempt", attempt, "retrying", retrying, "err", err)
+		if !retrying {
+			return err
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// Reopen closes the database files without checkpointing and opens them
+// again, recovering every committed transaction from the WAL. It is the way
+// out of ErrNeedsReopen. No transaction may be open.
+func (kv *KV) Reopen() error {
+	// Wait for the WAL write of a commit that timed out.
+	kv.commitMu.Lock()
+	kv.commitMu.Unlock()
+	kv.dropVersions()
+	kv.mu.Lock()
+	assert(len(kv.readers) == 0)
+	kv.mu.Unlock()
+
+	kv.closeFiles()
+	kv.fp, kv.wal = nil, nil
+	kv.tree.root = 0
+	kv.free = btree.FreeListData{}
+	clear(kv.punch.pending)
+	kv.mmap.file, kv.mmap.total, kv.mmap.chunks, kv.mmap.retired = 0, 0, nil, nil
+	kv.mmap.reserved = nil
+	kv.mmap.locked, kv.mmap.huge = 0, 0
+	kv.page.flushed = 0
+	kv.version = 0
+	kv.summary = nil
+	kv.failed = nil
+	return kv.Open()
+}
@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func kvPut(db *KV, key, val string) error {
+	tx := KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte(key), Val: []byte(val)})
+	return db.Commit(&tx)
+}
+
+func kvGet(db *KV, key string) (string, bool) {
+	tx := KVReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	val, ok := tx.Get([]byte(key))
+	return string(val), ok
+}
+
+// failWrites makes the next n WAL writes fail with err after a torn write.
+func failWrites(wal *WAL, n int, err error) {
+	wal.fault = func(op string) (int, error) {
+		if op != "write" || n == 0 {
+			return 0, nil
+		}
+		n--
+		return 5, err
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	is.True(t, IsTransient(syscall.ENOSPC))
+	is.True(t, IsTransient(fmt.Errorf("WAL commit: %w", syscall.EINTR)))
+	is.True(t, IsTransient(&os.PathError{Op: "write", Path: "x", Err: syscall.ESTALE}))
+	is.False(t, IsTransient(syscall.EIO))
+	is.False(t, IsTransient(errors.New("other")))
+}
+
+func TestCommitRetriesTransientIO(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	var events []IOEvent
+	db := &KV{Path: dbPath, NoSync: true, IORetries: 3}
+	db.OnIOError = func(ev IOEvent) { events = append(events, ev) }
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	failWrites(db.wal, 2, syscall.ENOSPC)
+	is.NoError(t, kvPut(db, "k1", "v1"))
+	is.Len(t, events, 2)
+	is.Equal(t, "WAL append", events[0].Op)
+	is.True(t, events[0].Transient && events[0].Retrying)
+	is.Equal(t, 2, events[1].Attempt)
+
+	// Retries exhausted: the error surfaces but the WAL stays clean.
+	events = nil
+	failWrites(db.wal, 10, syscall.ENOSPC)
+	err := kvPut(db, "k2", "v2")
+	is.ErrorIs(t, err, syscall.ENOSPC)
+	is.Len(t, events, 4)
+	is.False(t, events[3].Retrying)
+
+	db.wal.fault = nil
+	is.NoError(t, kvPut(db, "k3", "v3"))
+
+	// Crash and recover from the WAL alone.
+	is.NoError(t, db.Reopen())
+	v, ok := kvGet(db, "k1")
+	is.True(t, ok)
+	is.Equal(t, "v1", v)
+	_, ok = kvGet(db, "k2")
+	is.False(t, ok)
+	v, ok = kvGet(db, "k3")
+	is.True(t, ok)
+	is.Equal(t, "v3", v)
+}
+
+func TestCommitTornWriteRolledBack(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	db := &KV{Path: dbPath, NoSync: true, IORetries: 3}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	is.NoError(t, kvPut(db, "a", "1"))
+	// EIO is permanent: no retry, and the torn record is cut off so the
+	// next commit is still recoverable.
+	failWrites(db.wal, 1, syscall.EIO)
+	is.ErrorIs(t, kvPut(db, "b", "2"), syscall.EIO)
+	is.NoError(t, kvPut(db, "c", "3"))
+
+	is.NoError(t, db.Reopen())
+	_, ok := kvGet(db, "b")
+	is.False(t, ok)
+	v, ok := kvGet(db, "c")
+	is.True(t, ok)
+	is.Equal(t, "3", v)
+}
+
+func TestCommitNeedsReopen(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	db := &KV{Path: dbPath, NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.NoError(t, kvPut(db, "a", "1"))
+
+	// A read-only descriptor on a removed WAL: the write fails, and so do
+	// the truncate and the reopen of the rollback.
+	ro, err := os.Open(dbPath + ".wal")
+	is.NoError(t, err)
+	_, err = ro.Seek(0, io.SeekEnd)
+	is.NoError(t, err)
+	is.NoError(t, os.Remove(dbPath+".wal"))
+	db.wal.fp.Close()
+	db.wal.fp = ro
+	is.Error(t, kvPut(db, "b", "2"))
+	is.ErrorIs(t, kvPut(db, "c", "3"), ErrNeedsReopen)
+
+	is.NoError(t, db.Reopen())
+	v, ok := kvGet(db, "a")
+	is.True(t, ok)
+	is.Equal(t, "1", v)
+	is.NoError(t, kvPut(db, "c", "3"))
+}
+
+func TestWALAppendAfterReopen(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	db := &KV{Path: dbPath, NoSync: true}
+	is.NoError(t, db.Open())
+	is.NoError(t, kvPut(db, "a", "1"))
+	db.Close()
+
+	// The checkpointed WAL holds only its header; new records must be
+	// appended after it rather than overwrite it.
+	db = &KV{Path: dbPath, NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.NoError(t, kvPut(db, "b", "2"))
+	is.NoError(t, db.Reopen())
+	v, ok := kvGet(db, "b")
+	is.True(t, ok)
+	is.Equal(t, "2", v)
+}
@@ -10,38 +10,196 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+	"github.com/MHS-20/ElkDB/throttle"
 )
 
 const dbSig = "ElkDB"
 
+// formatVersion is the on-disk format revision stored in the master page.
+// Bump it whenever the layout of the master page, B-tree nodes, free-list
+// pages or WAL records changes; the golden vectors in format_test.go must
+// change with it. Revision 2 added the size limits to the master page,
+// revision 3 the leaf entries of long keys (see btree.BTree.CheckSize), and
+// revision 4 dropped the empty key that trees used to start with.
+const formatVersion = uint32(4)
+
+// FormatVersion returns the on-disk format revision this build reads and
+// writes. Every multi-byte field in the file is stored with an explicit byte
+// order (little-endian for page structures, big-endian inside table keys),
+// never in native order, so a file written on amd64 can be opened on arm64
+// and vice versa. Open rejects files with a newer revision.
+func FormatVersion() uint32 { return formatVersion }
+
 // KV is the top-level database handle.
-// Open it with KV.Open, then create transactions with Begin / BeginRead.
+// Open it with KV.Open, then create transactions with Begin / BeginRead. A
+// KV is safe for concurrent use by multiple goroutines; a transaction is not.
 type KV struct {
 	Path   string
 	NoSync bool // skip fsync (useful in tests; dangerous in production)
 
+	// IORetries bounds how many times a commit retries an I/O step that
+	// failed with a transient error (see IsTransient); 0 disables retries.
+	// IORetryDelay is the pause before the first retry, doubled after each.
+	IORetries    int
+	IORetryDelay time.Duration
+	// OnIOError, if set, is called for every failed I/O step of a commit,
+	// whether or not it is retried. It runs with the commit lock held.
+	OnIOError func(IOEvent)
+	// WriteStall, if set, bounds how long Commit waits for the commits
+	// ahead of it, and for Compact, Backup or Stats, which take the same
+	// lock, before it fails with ErrTimeout. CommitTimeout, if set, bounds
+	// the WAL write and fsync of a commit, which may hang on a network
+	// filesystem: Commit then fails with ErrTimeout without knowing whether
+	// the commit will be durable, and the store refuses commits with
+	// ErrNeedsReopen once the write ends; Reopen recovers what the WAL holds.
+	WriteStall    time.Duration
+	CommitTimeout time.Duration
+	// IndexSummary keeps an in-memory sparse index of the B-tree leaves
+	// (btree.Summary) so that point reads in read transactions touch one
+	// page even with a cold cache. It is built on Open and rebuilt by every
+	// commit from the internal nodes, which makes writes slower: it suits
+	// read-mostly data.
+	IndexSummary bool
+	// Logger receives the lifecycle messages of the store: open and close,
+	// file growth, free-page recycling, recovery, corruption and I/O errors.
+	// Nil discards them.
+	Logger *slog.Logger
+	// Maintenance, if set, paces the page copy of Compact.
+	Maintenance *throttle.Limiter
+	// PunchHoles deallocates the disk blocks of freed pages with
+	// fallocate(FALLOC_FL_PUNCH_HOLE) once no reader can see them, so that
+	// deleting data releases disk space on filesystems with sparse files.
+	// Pages are reserved again before reuse, which costs commits a call.
+	PunchHoles bool
+	// WALArchive, if set, is a directory that every checkpoint first copies
+	// the WAL into, as a segment named after its first transaction, for
+	// Recover to replay onto a backup. Segments are never removed by the
+	// store.
+	WALArchive string
+	// ReadOnly makes the store a follower: Commit and Compact fail with
+	// ErrReadOnly, and changes come only from a leader's Feed through Apply.
+	ReadOnly bool
+	// Propose, if set, puts the store in logged mode: Commit hands the
+	// changes of a write transaction to Propose, as an entry of a replicated
+	// log, and returns its error; the store changes only as the log applies
+	// its entries through ApplyLogged. See package raftdb.
+	Propose func(entry []byte) error
+	// KeepVersions keeps the last KeepVersions committed versions readable
+	// through BeginReadAt, holding back the reuse of the pages they reach
+	// as an open reader would. 0 keeps only the current one.
+	KeepVersions int
+	// Access is the access-pattern hint the mapping of the data file starts
+	// with; see Advise.
+	Access Access
+	// ReadAhead is the number of leaf pages an iterator asks the kernel,
+	// with madvise(MADV_WILLNEED), to start reading ahead of the leaf it is
+	// on, so that a scan of a cold file overlaps its reads instead of
+	// faulting the leaves in one at a time. It helps on spinning disks and
+	// network filesystems, where the kernel's own read-ahead does not follow
+	// the scattered leaves. The hints go out in batches, from the parent of
+	// the leaf. 0 sends none.
+	ReadAhead int
+	// DropFreed releases the mapped memory of freed pages with
+	// madvise(MADV_DONTNEED) once no reader can see them, so that large
+	// deletions shrink the resident memory of the process.
+	DropFreed bool
+	// LockMemory locks up to that many bytes of the file, from its start,
+	// into memory with mlock(2), so that reads never fault on the disk
+	// there. The lock follows the file as it grows. If RLIMIT_MEMLOCK is
+	// too low, a warning is logged and the store runs with what it got.
+	LockMemory int64
+	// HugePages, once the file has reached that many bytes, advises its
+	// mapping with madvise(MADV_HUGEPAGE) so that the kernel may back it
+	// with transparent huge pages, which cuts TLB misses on scans of a large
+	// database. Whether it does depends on the kernel and the filesystem.
+	// 0 never advises.
+	HugePages int64
+	// MmapInitial is the size in bytes of the first mapping of the data
+	// file, rounded up to whole pages and doubled until the file fits; 0
+	// means 64 MiB. MmapGrowth is the size of each chunk mapped after it as
+	// the file grows; 0 doubles the mapping every time. MmapMax, if set,
+	// caps the address space the mapping takes: a commit that needs more
+	// fails with ErrMapFull, and so does Open for a larger file.
+	MmapInitial int
+	MmapGrowth  int
+	MmapMax     int64
+	// DirectWAL appends WAL records with O_DIRECT, through block-aligned
+	// buffers, so that the log, which is only read back by recovery, does not
+	// take up the page cache next to the mapped data file. Where the
+	// filesystem refuses O_DIRECT, a warning is logged and the WAL stays
+	// buffered.
+	DirectWAL bool
+	// IOUring gathers the WAL records of a commit and submits their write
+	// and the fsync after it to the kernel as one io_uring chain, which a
+	// single system call waits for, instead of one write per record and a
+	// blocking fsync. Where io_uring is unavailable, a warning is logged and
+	// the WAL is written as usual.
+	IOUring bool
+	// MaxKeySize and MaxValSize bound the sizes of keys and values in a file
+	// Open creates; 0 means btree.MaxKeySize and btree.MaxValSize. They
+	// must pass btree.CheckLimits: keys may grow a little past the default,
+	// and smaller keys leave room for larger values. The limits are stored in
+	// the master page, and a file keeps those it was created with, whatever
+	// these say when it is opened again; Limits reports them.
+	MaxKeySize int
+	MaxValSize int
+
 	fp   *os.File
 	wal  *WAL
 	tree struct {
 		root uint64
+		// sentinel is set when the file is of a format revision before 4,
+		// whose trees start with an empty key; see dropSentinel.
+		sentinel bool
 	}
 	free btree.FreeListData
+	// limits are the key and value size limits of the file.
+	limits struct {
+		key, val int
+	}
 	mmap struct {
 		file   int      // file size in bytes (can exceed database size)
 		total  int      // total mapped bytes (can exceed file size)
 		chunks [][]byte // one or more mmap regions
+		// reserved is the address space reserved for the first chunk to
+		// grow into (see reserve.go), which it lies at the start of; nil if
+		// the kernel refused it.
+		reserved []byte
+		// retired holds the regions of files replaced by Compact, which
+		// older snapshots may still read; they are unmapped by Close.
+		retired [][]byte
+		// locked is how many bytes from the start of the file are locked
+		// into memory (see LockMemory), written under mu; lockOff is set
+		// once the kernel refused to lock more.
+		locked  int
+		lockOff bool
+		// huge is how many bytes from the start of the mapping are advised
+		// for huge pages (see HugePages), written under mu; hugeOff is set
+		// once the kernel refused.
+		huge    int
+		hugeOff bool
 	}
 	page struct {
 		flushed uint64 // database size in pages
+		// gens holds the generation of every page: the version whose
+		// commit last wrote it, or a later one. Guarded by commitMu; see
+		// BackupSince.
+		gens []uint64
 	}
 
 	mu      sync.Mutex
 	version uint64
+	logged  logPos // position in the replicated log, in logged mode
 
 	// commitMu serialises the commit phase across concurrent writers.
 	// Only held during Commit (not the full transaction lifetime).
@@ -54,27 +212,101 @@ type KV struct {
 	// Lock.  This prevents readers from seeing partially-written pages.
 	mmapMu sync.RWMutex
 
-	readers readerList // min-heap tracking the oldest active reader version
+	readers readerList  // min-heap tracking the oldest active reader version
+	writers int         // open write transactions, which are on readers too; guarded by mu
+	kept    []*KVReader // pins of the last KeepVersions versions, oldest first; guarded by mu
+
+	// txGate is held shared by every open write transaction, which may read
+	// any page or the free list; truncateTail needs it exclusively.
+	txGate sync.RWMutex
+	// tailFree is set when a commit freed a page near the end of the file,
+	// until truncateTail has dealt with it. Guarded by commitMu.
+	tailFree bool
+	// punch holds the freed pages waiting for punchHoles, with the version
+	// that freed them; off is set once the filesystem refused to punch.
+	// Guarded by commitMu.
+	punch struct {
+		pending map[uint64]uint64
+		off     bool
+	}
+
+	// failed is set when a failed commit could not be rolled back from the
+	// WAL; further commits are refused until Reopen.
+	failed error
+
+	// feeds are the open Feeds, which every change is handed to.
+	feeds struct {
+		mu   sync.Mutex
+		subs map[*Feed]struct{}
+	}
+
+	// watches are the open Watches, by channel; n counts them.
+	watches struct {
+		mu   sync.Mutex
+		subs map[<-chan Event]*watch
+		n    atomic.Int32
+	}
+
+	events  events.Bus
+	summary *btree.Summary // current tree's summary if IndexSummary; guarded by mu
+	access  Access         // current hint of the mapping; guarded by mu
+	stats   kvStats
+}
+
+// Events returns the bus the KV publishes its lifecycle events on. It may be
+// subscribed to before Open.
+func (kv *KV) Events() *events.Bus {
+	return &kv.events
 }
 
-// Open opens or creates the database file at db.Path.
+// log returns kv.Logger, or a logger that discards everything.
+func (kv *KV) log() *slog.Logger {
+	if kv.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return kv.Logger
+}
+
+// corrupt reports a damaged data file or WAL found by Open.
+func (kv *KV) corrupt(err error) {
+	kv.log().Error("kv: corruption detected", "path", kv.Path, "err", err)
+	kv.events.Publish(events.Event{Kind: events.Corruption, Err: err})
+}
+
+// Open opens or creates the database file at db.Path. It fails with
+// ErrLocked if another process, or another KV, has it open.
 func (kv *KV) Open() error {
 	fp, err := os.OpenFile(kv.Path, os.O_RDWR|os.O_CREATE, 0o644)
 	if err != nil {
 		return fmt.Errorf("OpenFile: %w", err)
 	}
+	if err := lockFile(fp); err != nil {
+		fp.Close()
+		return fmt.Errorf("KV.Open: %w", err)
+	}
 	kv.fp = fp
 
-	sz, chunk, err := mmapInit(kv.fp)
+	sz, chunk, reserved, err := kv.mmapInit(kv.fp)
 	if err != nil {
+		if errors.Is(err, errBadFileSize) {
+			kv.corrupt(err)
+		}
 		kv.Close()
 		return fmt.Errorf("KV.Open: %w", err)
 	}
 	kv.mmap.file = sz
 	kv.mmap.total = len(chunk)
 	kv.mmap.chunks = [][]byte{chunk}
+	kv.mmap.reserved = reserved
+	kv.access = kv.Access
+	if err := adviseChunks(kv.mmap.chunks, kv.access); err != nil {
+		kv.log().Warn("kv: madvise failed", "path", kv.Path, "err", err)
+	}
+	kv.lockMemory()
+	kv.adviseHuge()
 
 	if err := masterLoad(kv); err != nil {
+		kv.corrupt(err)
 		kv.Close()
 		return fmt.Errorf("KV.Open: %w", err)
 	}
@@ -82,10 +314,23 @@ func (kv *KV) Open() error {
 	walPath := kv.Path + ".wal"
 	wal, err := OpenWAL(walPath)
 	if err != nil {
+		if errors.Is(err, errBadWAL) {
+			kv.corrupt(err)
+		}
 		kv.Close()
 		return fmt.Errorf("KV.Open: %w", err)
 	}
 	kv.wal = wal
+	if kv.DirectWAL {
+		if err := wal.openDirect(); err != nil {
+			kv.log().Warn("kv: direct WAL writes not supported, using buffered writes", "path", kv.Path, "err", err)
+		}
+	}
+	if kv.IOUring {
+		if err := wal.openRing(); err != nil {
+			kv.log().Warn("kv: io_uring not available, using plain WAL writes", "path", kv.Path, "err", err)
+		}
+	}
 
 	hasData, err := wal.HasData()
 	if err != nil {
@@ -93,25 +338,91 @@ func (kv *KV) Open() error {
 		return fmt.Errorf("KV.Open: %w", err)
 	}
 	if hasData {
-		if err := wal.Recover(kv); err != nil {
+		err := wal.Recover(kv)
+		kv.events.Publish(events.Event{Kind: events.Recovery, Version: kv.version, Err: err})
+		if err != nil {
+			kv.log().Error("kv: WAL recovery failed", "path", kv.Path, "err", err)
 			kv.Close()
 			return fmt.Errorf("KV.Open: %w", err)
 		}
+		kv.log().Warn("kv: recovered commits from the WAL", "path", kv.Path, "version", kv.version)
+		if kv.PunchHoles {
+			kv.punchFreeList()
+		}
 	}
 
 	kv.pageAlloc = kv.page.flushed
+	kv.stampAll(kv.version + 1) // unknown: maybe written by the last session
+	kv.summary = kv.buildSummary(kv.tree.root)
+	kv.loadLogState()
+	if kv.tree.sentinel && !kv.ReadOnly {
+		if err := kv.dropSentinel(); err != nil {
+			kv.Close()
+			return fmt.Errorf("KV.Open: %w", err)
+		}
+	}
+	kv.log().Info("kv: opened", "path", kv.Path, "version", kv.version, "pages", kv.page.flushed)
+	return nil
+}
+
+// dropSentinel deletes the empty key that trees of format revisions before 4
+// start with, in a commit that changes neither the contents nor the log
+// position. A ReadOnly store keeps it until the commit of its leader that
+// drops it arrives.
+func (kv *KV) dropSentinel() error {
+	pos := kv.logged
+	tx := KVTX{logPos: &pos}
+	kv.Begin(&tx)
+	if !tx.tree.DropSentinel() {
+		kv.Abort(&tx)
+		return nil
+	}
+	if err := kv.Commit(&tx); err != nil {
+		return fmt.Errorf("drop the sentinel key: %w", err)
+	}
 	return nil
 }
 
+// buildSummary returns the summary of the committed tree at root, or nil
+// when IndexSummary is off.
+func (kv *KV) buildSummary(root uint64) *btree.Summary {
+	if !kv.IndexSummary {
+		return nil
+	}
+	r := &KVReader{mmapMu: &kv.mmapMu}
+	r.mmap.chunks = kv.mmap.chunks
+	tree := btree.BTree{Root: root, Store: r, MaxKey: kv.limits.key, MaxVal: kv.limits.val}
+	return tree.Summary()
+}
+
 // Close unmaps all pages and closes the file.
 func (kv *KV) Close() {
+	// Wait for the WAL write of a commit that timed out.
+	kv.commitMu.Lock()
+	kv.commitMu.Unlock()
+	kv.dropVersions()
+	kv.closeFeeds(os.ErrClosed)
+	kv.closeWatches()
 	if kv.wal != nil {
 		if hasData, _ := kv.wal.HasData(); hasData {
-			_ = kv.wal.Checkpoint(kv)
+			err := kv.wal.Checkpoint(kv)
+			kv.events.Publish(events.Event{Kind: events.Checkpoint, Version: kv.version, Err: err})
+			if err != nil {
+				kv.log().Error("kv: checkpoint failed", "path", kv.Path, "err", err)
+			} else if kv.PunchHoles {
+				kv.punchFreeList()
+			}
 		}
+	}
+	kv.closeFiles()
+	kv.log().Info("kv: closed", "path", kv.Path, "version", kv.version)
+}
+
+func (kv *KV) closeFiles() {
+	if kv.wal != nil {
 		_ = kv.wal.Close()
 	}
-	for _, chunk := range kv.mmap.chunks {
+	for _, chunk := range slices.Concat(kv.regions(), kv.mmap.retired) {
 		err := syscall.Munmap(chunk)
 		assert(err == nil)
 	}
@@ -120,29 +431,69 @@ func (kv *KV) Close() {
 
 // --- mmap helpers ---
 
-func mmapInit(fp *os.File) (int, []byte, error) {
+var errBadFileSize = errors.New("file size is not a multiple of page size")
+
+// ErrMapFull is returned by a commit that would grow the mapping of the data
+// file past KV.MmapMax, and by Open for a file larger than that.
+var ErrMapFull = errors.New("kv: mapping size limit reached")
+
+// defaultMmapInitial is the size of the first mapping of the data file when
+// KV.MmapInitial is 0.
+const defaultMmapInitial = 64 << 20
+
+// mmapInit maps fp, from its start, with room for it to grow: MmapInitial
+// bytes, doubled until the file fits, within MmapMax. It returns the file
+// size, the mapping, and the address space reserved for it to grow into.
+func (kv *KV) mmapInit(fp *os.File) (int, []byte, []byte, error) {
 	fi, err := fp.Stat()
 	if err != nil {
-		return 0, nil, fmt.Errorf("stat: %w", err)
+		return 0, nil, nil, fmt.Errorf("stat: %w", err)
 	}
 	if fi.Size()%btree.PageSize != 0 {
-		return 0, nil, errors.New("file size is not a multiple of page size")
+		return 0, nil, nil, errBadFileSize
 	}
 
-	mmapSize := 64 << 20
+	mmapSize := defaultMmapInitial
+	if kv.MmapInitial > 0 {
+		mmapSize = pageRound(kv.MmapInitial)
+	}
 	assert(mmapSize%btree.PageSize == 0)
 	for mmapSize < int(fi.Size()) {
 		mmapSize *= 2
 	}
+	if kv.MmapMax > 0 {
+		if fi.Size() > kv.MmapMax {
+			return 0, nil, nil, fmt.Errorf("file of %d bytes: %w", fi.Size(), ErrMapFull)
+		}
+		mmapSize = max(min(mmapSize, kv.mmapMax()), int(fi.Size()), btree.PageSize)
+	}
 
+	if reserved := reserveAddress(kv.mmapReserve(mmapSize)); reserved != nil {
+		chunk, err := mapFixed(fp, reserved, 0, mmapSize)
+		if err != nil {
+			_ = syscall.Munmap(reserved)
+			return 0, nil, nil, err
+		}
+		return int(fi.Size()), chunk, reserved, nil
+	}
 	chunk, err := syscall.Mmap(
 		int(fp.Fd()), 0, mmapSize,
 		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
 	)
 	if err != nil {
-		return 0, nil, fmt.Errorf("mmap: %w", err)
+		return 0, nil, nil, fmt.Errorf("mmap: %w", err)
 	}
-	return int(fi.Size()), chunk, nil
+	return int(fi.Size()), chunk, nil, nil
+}
+
+// mmapMax returns MmapMax in whole pages.
+func (kv *KV) mmapMax() int {
+	return int(kv.MmapMax) / btree.PageSize * btree.PageSize
+}
+
+// pageRound rounds n up to whole pages.
+func pageRound(n int) int {
+	return (n + btree.PageSize - 1) / btree.PageSize * btree.PageSize
 }
 
 func extendFile(kv *KV, npages int) error {
@@ -155,34 +506,140 @@ func extendFile(kv *KV, npages int) error {
 		filePages += inc
 	}
 	fileSize := filePages * btree.PageSize
-	if err := syscall.Fallocate(int(kv.fp.Fd()), 0, 0, int64(fileSize)); err != nil {
-		return fmt.Errorf("fallocate: %w", err)
+	if err := allocFile(kv.fp, int64(kv.mmap.file), int64(fileSize)); err != nil {
+		return err
 	}
+	kv.log().Debug("kv: file extended", "path", kv.Path, "from", kv.mmap.file, "to", fileSize)
 	kv.mmap.file = fileSize
 	return nil
 }
 
+// allocFile grows fp from size from to size to with its blocks allocated, so
+// that writing to them through the mapping cannot fail for lack of space,
+// which would be a SIGBUS rather than an error. Where fallocate(2) is
+// missing, as on macOS and some filesystems, it writes zeros instead.
+func allocFile(fp *os.File, from, to int64) error {
+	err := fallocate(int(fp.Fd()), 0, 0, to)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		err = zeroFill(fp, from, to)
+		if err != nil {
+			return fmt.Errorf("zero fill: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fallocate: %w", err)
+	}
+	return nil
+}
+
+// zeroFill writes zeros to fp from offset from to offset to.
+func zeroFill(fp *os.File, from, to int64) error {
+	if to <= from {
+		return nil
+	}
+	zeros := make([]byte, min(to-from, 1<<20))
+	for off := from; off < to; {
+		n, err := fp.WriteAt(zeros[:min(int64(len(zeros)), to-off)], off)
+		if err != nil {
+			return err
+		}
+		off += int64(n)
+	}
+	return nil
+}
+
+// shrinkFile truncates the file to npages pages and unmaps the regions that
+// lie wholly beyond it. No one may read those pages any more.
+func shrinkFile(kv *KV, npages int) error {
+	size := npages * btree.PageSize
+	if kv.mmap.file <= size {
+		return nil
+	}
+	if err := kv.fp.Truncate(int64(size)); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	kv.log().Debug("kv: file truncated", "path", kv.Path, "from", kv.mmap.file, "to", size)
+	kv.mmap.file = size
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.unlockMemory(size)
+	for n := len(kv.mmap.chunks); n > 1; n-- {
+		last := kv.mmap.chunks[n-1]
+		if kv.mmap.total-len(last) < size {
+			break
+		}
+		if err := syscall.Munmap(last); err != nil {
+			return fmt.Errorf("munmap: %w", err)
+		}
+		kv.mmap.total -= len(last)
+		kv.mmap.chunks = kv.mmap.chunks[: n-1 : n-1]
+		kv.mmap.huge = min(kv.mmap.huge, kv.mmap.total)
+	}
+	return nil
+}
+
+// extendMmap grows the mapping if it does not reach npages: by as much as
+// the mapping so far, or MmapGrowth, and never past MmapMax. The first chunk
+// grows in place while its reserved address space lasts, and new chunks are
+// mapped after it from then on. It fails with ErrMapFull if npages lie
+// beyond MmapMax.
 func extendMmap(kv *KV, npages int) error {
-	if kv.mmap.total >= npages*btree.PageSize {
+	need := npages * btree.PageSize
+	if kv.mmap.total >= need {
+		kv.lockMemory() // the file may have grown
+		kv.adviseHuge()
+		return nil
+	}
+	if kv.MmapMax > 0 && need > kv.mmapMax() {
+		return fmt.Errorf("mmap %d bytes: %w", need, ErrMapFull)
+	}
+	size := kv.mmap.total
+	if kv.MmapGrowth > 0 {
+		size = pageRound(kv.MmapGrowth)
+	}
+	size = max(size, need-kv.mmap.total)
+	if kv.MmapMax > 0 {
+		size = min(size, kv.mmapMax()-kv.mmap.total)
+	}
+	if chunk, mem := kv.growReserved(size, need-kv.mmap.total); chunk != nil {
+		kv.mmap.total += len(mem)
+		kv.log().Debug("kv: mapping grown in place", "path", kv.Path, "bytes", kv.mmap.total)
+		kv.mu.Lock()
+		kv.mmap.chunks = [][]byte{chunk} // snapshots keep the old list
+		_ = adviseChunks([][]byte{mem}, kv.access)
+		kv.mu.Unlock()
+		kv.lockMemory()
+		kv.adviseHuge()
 		return nil
 	}
 	chunk, err := syscall.Mmap(
-		int(kv.fp.Fd()), int64(kv.mmap.total), kv.mmap.total,
+		int(kv.fp.Fd()), int64(kv.mmap.total), size,
 		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
 	)
 	if err != nil {
 		return fmt.Errorf("mmap: %w", err)
 	}
 	kv.mmap.total += len(chunk)
+	kv.log().Debug("kv: mapping extended", "path", kv.Path, "bytes", kv.mmap.total)
 	kv.mu.Lock()
 	kv.mmap.chunks = append(kv.mmap.chunks, chunk)
+	_ = adviseChunks([][]byte{chunk}, kv.access)
 	kv.mu.Unlock()
+	kv.lockMemory()
+	kv.adviseHuge()
 	return nil
 }
 
 // --- master page ---
 func masterLoad(kv *KV) error {
 	if kv.mmap.file == 0 {
+		maxKey, maxVal := (&btree.BTree{MaxKey: kv.MaxKeySize, MaxVal: kv.MaxValSize}).Limits()
+		if err := btree.CheckLimits(maxKey, maxVal); err != nil {
+			return err
+		}
+		kv.limits.key, kv.limits.val = maxKey, maxVal
 		kv.page.flushed = 1
 		return nil
 	}
@@ -196,6 +653,12 @@ func masterLoad(kv *KV) error {
 	if !bytes.Equal([]byte(dbSig), data[:len(dbSig)]) {
 		return errors.New("bad signature")
 	}
+	// Files written before the format revision was recorded hold 0 here;
+	// their layout is revision 1.
+	v := binary.LittleEndian.Uint32(data[12:])
+	if v > formatVersion {
+		return fmt.Errorf("unsupported format version %d (max %d)", v, formatVersion)
+	}
 	bad := 1 > used || used > uint64(kv.mmap.file/btree.PageSize)
 	bad = bad || root >= used
 	bad = bad || free >= used
@@ -203,7 +666,13 @@ func masterLoad(kv *KV) error {
 		return errors.New("bad master page")
 	}
 
+	kv.limits.key, kv.limits.val = masterLimits(data)
+	if err := btree.CheckLimits(kv.limits.key, kv.limits.val); err != nil {
+		return fmt.Errorf("bad master page: %w", err)
+	}
+
 	kv.tree.root = root
+	kv.tree.sentinel = v < 4
 	kv.free.Head = free
 	kv.page.flushed = used
 	kv.pageAlloc = used
@@ -212,19 +681,58 @@ func masterLoad(kv *KV) error {
 }
 
 func masterStore(kv *KV) error {
-	var data [48]byte
-	copy(data[:16], []byte(dbSig))
-	binary.LittleEndian.PutUint64(data[16:], kv.tree.root)
-	binary.LittleEndian.PutUint64(data[24:], kv.page.flushed)
-	binary.LittleEndian.PutUint64(data[32:], kv.free.Head)
-	binary.LittleEndian.PutUint64(data[40:], kv.version)
-	_, err := kv.fp.WriteAt(data[:], 0)
+	data := masterData(kv.tree.root, kv.page.flushed, kv.free.Head, kv.version, kv.limits.key, kv.limits.val)
+	_, err := kv.fp.WriteAt(data, 0)
 	if err != nil {
 		return fmt.Errorf("write master page: %w", err)
 	}
 	return nil
 }
 
+// masterData encodes the master page.
+func masterData(root, used, free, version uint64, maxKey, maxVal int) []byte {
+	data := make([]byte, masterSize)
+	copy(data[:12], []byte(dbSig))
+	binary.LittleEndian.PutUint32(data[12:], formatVersion)
+	binary.LittleEndian.PutUint64(data[16:], root)
+	binary.LittleEndian.PutUint64(data[24:], used)
+	binary.LittleEndian.PutUint64(data[32:], free)
+	binary.LittleEndian.PutUint64(data[40:], version)
+	binary.LittleEndian.PutUint16(data[48:], uint16(maxKey))
+	binary.LittleEndian.PutUint16(data[50:], uint16(maxVal))
+	return data
+}
+
+// masterSize is the size of the encoded master page.
+const masterSize = 52
+
+// masterLimits returns the key and value size limits of a master page.
+// Files of format revision 1 have none stored, and take the defaults.
+func masterLimits(data []byte) (maxKey, maxVal int) {
+	tree := btree.BTree{
+		MaxKey: int(binary.LittleEndian.Uint16(data[48:])),
+		MaxVal: int(binary.LittleEndian.Uint16(data[50:])),
+	}
+	if binary.LittleEndian.Uint32(data[12:]) < 2 {
+		tree.MaxKey, tree.MaxVal = 0, 0
+	}
+	return tree.Limits()
+}
+
+// Limits returns the key and value size limits of the store, fixed when its
+// file was created; see MaxKeySize.
+func (kv *KV) Limits() (maxKey, maxVal int) {
+	return kv.limits.key, kv.limits.val
+}
+
+// CheckSize verifies that the store takes a key of klen bytes with a value
+// of vlen bytes. Keys longer than the key limit are taken too, sharing the
+// room of the value; see btree.BTree.CheckSize.
+func (kv *KV) CheckSize(klen, vlen int) error {
+	tree := btree.BTree{MaxKey: kv.limits.key, MaxVal: kv.limits.val}
+	return tree.CheckSize(klen, vlen)
+}
+
 // --- reader heap ---
 
 // readerList is a min-heap of active read transactions ordered by version.
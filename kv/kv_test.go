@@ -1,14 +1,22 @@
 package kv
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
 	is "github.com/stretchr/testify/require"
 )
 
@@ -61,6 +69,10 @@ func (kvt *kvTester) del(key string) bool {
 }
 
 func (kvt *kvTester) verify(t *testing.T) {
+	rep := kvt.db.Check()
+	is.True(t, rep.OK(), "%v", rep.Problems)
+	is.Equal(t, int64(len(kvt.ref)), rep.Keys)
+
 	tx := KVReader{}
 	kvt.db.BeginRead(&tx)
 	defer kvt.db.EndRead(&tx)
@@ -363,3 +375,367 @@ func TestConcurrentWriterStress(t *testing.T) {
 		}
 	}
 }
+
+func TestKVEvents(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	db := &KV{Path: dbPath, NoSync: true}
+	sub := db.Events().Subscribe(16)
+	defer sub.Close()
+	is.NoError(t, db.Open())
+
+	tx := KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k"), Val: []byte("v")})
+	is.NoError(t, db.Commit(&tx))
+	ev := <-sub.C
+	is.Equal(t, events.Commit, ev.Kind)
+	is.Equal(t, uint64(1), ev.Version)
+
+	failWrites(db.wal, 1, syscall.EIO)
+	tx = KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k2"), Val: []byte("v")})
+	is.Error(t, db.Commit(&tx))
+	ev = <-sub.C
+	is.Equal(t, events.IOError, ev.Kind)
+	is.ErrorIs(t, ev.Err, syscall.EIO)
+
+	db.Close()
+	ev = <-sub.C
+	is.Equal(t, events.Checkpoint, ev.Kind)
+	is.NoError(t, ev.Err)
+
+	// A crash leaves the WAL to be replayed on the next open.
+	db = &KV{Path: dbPath, NoSync: true}
+	sub2 := db.Events().Subscribe(16)
+	defer sub2.Close()
+	is.NoError(t, db.Open())
+	tx = KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k3"), Val: []byte("v")})
+	is.NoError(t, db.Commit(&tx))
+	is.NoError(t, db.Reopen())
+	is.Equal(t, events.Commit, (<-sub2.C).Kind)
+	ev = <-sub2.C
+	is.Equal(t, events.Recovery, ev.Kind)
+	is.NoError(t, ev.Err)
+	db.Close()
+
+	// Damaged files are reported before Open fails.
+	is.NoError(t, os.WriteFile(dbPath, []byte("garbage"), 0o644))
+	db = &KV{Path: dbPath}
+	sub3 := db.Events().Subscribe(1, events.Corruption)
+	defer sub3.Close()
+	is.Error(t, db.Open())
+	is.Equal(t, events.Corruption, (<-sub3.C).Kind)
+}
+
+func TestKVLogger(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db := &KV{Path: dbPath, NoSync: true, Logger: logger}
+	is.NoError(t, db.Open())
+	for i := range 5 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		tx.Update(&btree.InsertReq{Key: []byte("k"), Val: []byte{byte(i)}})
+		is.NoError(t, db.Commit(&tx))
+	}
+	is.NoError(t, db.Reopen())
+	db.Close()
+
+	out := buf.String()
+	is.Contains(t, out, "level=INFO msg=\"kv: opened\"")
+	is.Contains(t, out, "level=DEBUG msg=\"kv: file extended\"")
+	is.Contains(t, out, "level=DEBUG msg=\"kv: recycled free pages\"")
+	is.Contains(t, out, "level=WARN msg=\"kv: recovered commits from the WAL\"")
+	is.Contains(t, out, "level=INFO msg=\"kv: closed\"")
+
+	buf.Reset()
+	is.NoError(t, os.WriteFile(dbPath, []byte("garbage"), 0o644))
+	db = &KV{Path: dbPath, Logger: logger}
+	is.Error(t, db.Open())
+	is.Contains(t, buf.String(), "level=ERROR msg=\"kv: corruption detected\"")
+
+	// Without a Logger nothing is written anywhere.
+	db = &KV{Path: dbPath}
+	is.Error(t, db.Open())
+}
+
+func TestKVIndexSummary(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	db := &KV{Path: dbPath, NoSync: true, IndexSummary: true}
+	is.NoError(t, db.Open())
+	put := func(key, val string) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		tx.Update(&btree.InsertReq{Key: []byte(key), Val: []byte(val)})
+		is.NoError(t, db.Commit(&tx))
+	}
+	get := func(r *KVReader, key string) string {
+		v, ok := r.Get([]byte(key))
+		if !ok {
+			return "<missing>"
+		}
+		return string(v)
+	}
+	for i := 0; i < 2000; i++ {
+		put(fmt.Sprintf("key%d", fmix32(uint32(i))), fmt.Sprintf("v%d", i))
+	}
+	db.Close()
+
+	db = &KV{Path: dbPath, NoSync: true, IndexSummary: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	old := KVReader{}
+	db.BeginRead(&old)
+	is.NotNil(t, old.summary)
+	is.Greater(t, old.summary.Leaves(), 1)
+	for i := 0; i < 2000; i++ {
+		is.Equal(t, fmt.Sprintf("v%d", i), get(&old, fmt.Sprintf("key%d", fmix32(uint32(i)))))
+	}
+	is.Equal(t, "<missing>", get(&old, "nope"))
+
+	// Commits rebuild the summary; older snapshots keep theirs.
+	key0 := fmt.Sprintf("key%d", fmix32(0))
+	put(key0, "new")
+	put("nope", "added")
+	cur := KVReader{}
+	db.BeginRead(&cur)
+	is.Equal(t, "new", get(&cur, key0))
+	is.Equal(t, "added", get(&cur, "nope"))
+	is.Equal(t, "v0", get(&old, key0))
+	is.Equal(t, "<missing>", get(&old, "nope"))
+	db.EndRead(&cur)
+	db.EndRead(&old)
+}
+
+func TestOpenLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	other := &KV{Path: path, NoSync: true}
+	is.ErrorIs(t, other.Open(), ErrLocked)
+
+	// The compacted file takes the lock over.
+	tx := KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k"), Val: []byte("v")})
+	is.NoError(t, db.Commit(&tx))
+	_, err := db.Compact()
+	is.NoError(t, err)
+	is.ErrorIs(t, other.Open(), ErrLocked)
+
+	db.Close()
+	is.NoError(t, other.Open())
+	other.Close()
+}
+
+func TestMmapLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mmap.db")
+	db := &KV{Path: path, NoSync: true, MmapInitial: 100_000, MmapGrowth: 64 << 10, MmapMax: 1 << 20}
+	is.NoError(t, db.Open())
+	is.Len(t, db.mmap.chunks, 1)
+	is.Equal(t, 25*btree.PageSize, len(db.mmap.chunks[0])) // rounded up to pages
+	put := func(prefix string, n int) error {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range n {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i), Val: bytes.Repeat([]byte{'v'}, 1000)})
+		}
+		return db.Commit(&tx)
+	}
+
+	// The mapping grows by at least a step at a time up to the cap, then
+	// commits fail.
+	total := db.mmap.total
+	for i := range 10 {
+		is.NoError(t, put(fmt.Sprintf("a%d", i), 20))
+		if db.mmap.total != total {
+			is.GreaterOrEqual(t, db.mmap.total-total, 64<<10)
+			total = db.mmap.total
+		}
+	}
+	is.Greater(t, total, 25*btree.PageSize)
+	is.ErrorIs(t, put("b", 2000), ErrMapFull)
+	is.LessOrEqual(t, db.mmap.total, 1<<20)
+
+	// The store goes on: smaller commits fit, and the data is intact.
+	is.NoError(t, put("c", 2))
+	tx := KVReader{}
+	db.BeginRead(&tx)
+	_, ok := tx.Get([]byte("a900019"))
+	is.True(t, ok)
+	_, ok = tx.Get([]byte("b00000"))
+	is.False(t, ok)
+	db.EndRead(&tx)
+	db.Close()
+
+	// A file larger than the cap is refused.
+	db = &KV{Path: path, NoSync: true, MmapMax: 64 << 10}
+	is.ErrorIs(t, db.Open(), ErrMapFull)
+	db = &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	db.Close()
+}
+
+func TestMmapGrowInPlace(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "grow.db"), NoSync: true, MmapInitial: 64 << 10}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	put := func(prefix string) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range 20 {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i), Val: bytes.Repeat([]byte{'v'}, 1000)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	put("a")
+	if db.mmap.reserved == nil {
+		t.Skip("address space reservation refused")
+	}
+
+	// The mapping grows in place as one region, under an open snapshot.
+	r := KVReader{}
+	db.BeginRead(&r)
+	total := db.mmap.total
+	for i := range 30 {
+		put(fmt.Sprintf("b%d", i))
+	}
+	is.Greater(t, db.mmap.total, 4*total)
+	is.Equal(t, 1, db.Metrics().Mappings)
+	_, ok := r.Get([]byte("a00019"))
+	is.True(t, ok)
+	_, ok = r.Get([]byte("b000000"))
+	is.False(t, ok)
+	db.EndRead(&r)
+
+	// Compact maps the new file with a reservation of its own.
+	_, err := db.Compact()
+	is.NoError(t, err)
+	put("c")
+	is.Equal(t, 1, db.Metrics().Mappings)
+	_, ok = kvGet(db, "b2900019")
+	is.True(t, ok)
+}
+
+func TestZeroFill(t *testing.T) {
+	fp, err := os.Create(filepath.Join(t.TempDir(), "fill.db"))
+	is.NoError(t, err)
+	defer fp.Close()
+	head := bytes.Repeat([]byte{'x'}, btree.PageSize)
+	_, err = fp.Write(head)
+	is.NoError(t, err)
+
+	// The fallback for filesystems without fallocate keeps the data and
+	// allocates zeros after it.
+	is.NoError(t, zeroFill(fp, btree.PageSize, 3<<20))
+	data, err := os.ReadFile(fp.Name())
+	is.NoError(t, err)
+	is.Len(t, data, 3<<20)
+	is.Equal(t, head, data[:btree.PageSize])
+	is.Zero(t, bytes.Count(data[btree.PageSize:], []byte{'x'}))
+	is.NoError(t, zeroFill(fp, 3<<20, 3<<20))
+}
+
+func TestKVSizeLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "limits.db")
+	bad := &KV{Path: path, NoSync: true, MaxKeySize: 10}
+	is.Error(t, bad.Open())
+	os.Remove(path)
+	os.Remove(path + ".wal")
+
+	maxVal := btree.MaxValSize + btree.MaxKeySize - 100
+	db := &KV{Path: path, NoSync: true, MaxKeySize: 100, MaxValSize: maxVal}
+	is.NoError(t, db.Open())
+	is.NoError(t, db.SetMulti([]Pair{{[]byte("k"), make([]byte, maxVal)}}))
+	is.ErrorContains(t, db.SetMulti([]Pair{{make([]byte, 100+maxVal-9), nil}}), "key too large")
+	is.ErrorContains(t, db.SetMulti([]Pair{{[]byte("k"), make([]byte, maxVal+1)}}), "value too large")
+
+	// The limits are those of the file, whatever the options on reopen.
+	db.Close()
+	db = &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	k, v := db.Limits()
+	is.Equal(t, []int{100, maxVal}, []int{k, v})
+
+	// Copies of the file keep them.
+	var buf bytes.Buffer
+	_, err := db.Backup(&buf)
+	is.NoError(t, err)
+	is.NoError(t, db.SnapshotTo(filepath.Join(dir, "snap.db")))
+	db.Close()
+	is.NoError(t, RestoreFrom(&buf, filepath.Join(dir, "restored.db")))
+	for _, name := range []string{"snap.db", "restored.db"} {
+		db := &KV{Path: filepath.Join(dir, name), NoSync: true}
+		is.NoError(t, db.Open())
+		k, v := db.Limits()
+		is.Equal(t, []int{100, maxVal}, []int{k, v}, name)
+		val, ok := kvGet(db, "k")
+		is.True(t, ok)
+		is.Len(t, val, maxVal)
+		db.Close()
+	}
+}
+
+func TestKVLongKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "long.db")
+	db := &KV{Path: path, NoSync: true, IndexSummary: true}
+	is.NoError(t, db.Open())
+
+	// URLs past the key limit that share their first bytes, and one at it.
+	base := "https://example.com/" + strings.Repeat("p/", btree.MaxKeySize/2)
+	want := map[string]string{base[:btree.MaxKeySize]: "edge"}
+	var pairs []Pair
+	for i := range 50 {
+		key := fmt.Sprintf("%s%d", base, i*7919)
+		want[key] = fmt.Sprint(i)
+		pairs = append(pairs, Pair{[]byte(key), []byte(fmt.Sprint(i))})
+	}
+	pairs = append(pairs, Pair{[]byte(base[:btree.MaxKeySize]), []byte("edge")})
+	is.NoError(t, db.SetMulti(pairs))
+	is.NoError(t, db.SetMulti([]Pair{{[]byte(base + "0"), []byte("zero")}}))
+	want[base+"0"] = "zero"
+
+	scan := func() []string {
+		r := KVReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		var keys []string
+		for it := r.Seek([]byte(base[:btree.MaxKeySize]), btree.CmpGE); it.Valid(); it.Next() {
+			k, v := it.Deref()
+			is.Equal(t, want[string(k)], string(v))
+			keys = append(keys, string(k))
+		}
+		return keys
+	}
+	is.Equal(t, slices.Sorted(maps.Keys(want)), scan())
+
+	_, err := db.Compact()
+	is.NoError(t, err)
+	db.Close()
+	db = &KV{Path: path, NoSync: true, IndexSummary: true}
+	is.NoError(t, db.Open())
+	for k, v := range want {
+		val, ok := kvGet(db, k)
+		is.True(t, ok)
+		is.Equal(t, v, val)
+	}
+	is.True(t, db.Check().OK())
+	db.Close()
+
+	_, got := salvageInto(t, path)
+	is.Equal(t, want, got)
+}
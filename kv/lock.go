@@ -0,0 +1,27 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrLocked is returned by Open when another process has the database open.
+var ErrLocked = errors.New("database is locked by another process")
+
+// lockFile takes an exclusive flock on the data file, for as long as fp is
+// open, so that a second process opening the same database fails instead
+// of overwriting pages behind the first one's back. Followers (ReadOnly)
+// write the file too, so they take it as well. Locks taken through other
+// open files conflict even within one process.
+func lockFile(fp *os.File) error {
+	err := syscall.Flock(int(fp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return fmt.Errorf("%w: %s", ErrLocked, fp.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("flock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package kv
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/metrics"
+)
+
+// Metrics is a snapshot of the activity counters and state of a KV. The
+// counters start at zero when the KV is opened.
+type Metrics struct {
+	Gets    uint64 // Get calls, in read and write transactions
+	Sets    uint64 // Update calls
+	Deletes uint64 // Del calls
+
+	Commits   uint64 // write transactions that changed the tree
+	Conflicts uint64 // commits rejected with a serialisation conflict
+	Timeouts  uint64 // commits failed with ErrTimeout
+	// Flush is the time a commit spends writing and syncing the WAL.
+	Flush metrics.HistogramSnapshot
+
+	PagesAllocated uint64 // pages handed out to committed transactions
+	PagesFreed     uint64 // pages released by committed transactions
+	PagesTruncated uint64 // free pages cut off the end of the file
+	PagesPunched   uint64 // free pages whose disk blocks were deallocated
+	PagesDropped   uint64 // free pages whose mapped memory was released
+	// PagesPrefetched counts the leaves iterators asked the kernel to read
+	// ahead; see KV.ReadAhead.
+	PagesPrefetched uint64
+
+	Version    uint64 // committed version
+	Pages      uint64 // database size in pages
+	TreeHeight int    // levels of the B-tree
+	Readers    int    // open read transactions
+	Mappings   int    // regions the data file is mapped in
+}
+
+// kvStats holds the counters behind Metrics.
+type kvStats struct {
+	gets, sets, deletes    atomic.Uint64
+	commits, conflicts     atomic.Uint64
+	timeouts               atomic.Uint64
+	pagesAlloc, pagesFreed atomic.Uint64
+	pagesTruncated         atomic.Uint64
+	pagesPunched           atomic.Uint64
+	pagesDropped           atomic.Uint64
+	pagesPrefetched        atomic.Uint64
+	flush                  metrics.Histogram
+}
+
+// Metrics returns a snapshot of the counters and current state.
+func (kv *KV) Metrics() Metrics {
+	m := Metrics{
+		Gets:            kv.stats.gets.Load(),
+		Sets:            kv.stats.sets.Load(),
+		Deletes:         kv.stats.deletes.Load(),
+		Commits:         kv.stats.commits.Load(),
+		Conflicts:       kv.stats.conflicts.Load(),
+		Timeouts:        kv.stats.timeouts.Load(),
+		Flush:           kv.stats.flush.Snapshot(),
+		PagesAllocated:  kv.stats.pagesAlloc.Load(),
+		PagesFreed:      kv.stats.pagesFreed.Load(),
+		PagesTruncated:  kv.stats.pagesTruncated.Load(),
+		PagesPunched:    kv.stats.pagesPunched.Load(),
+		PagesDropped:    kv.stats.pagesDropped.Load(),
+		PagesPrefetched: kv.stats.pagesPrefetched.Load(),
+	}
+	kv.mu.Lock()
+	m.Readers = len(kv.readers) - len(kv.kept) - kv.writers
+	m.Mappings = len(kv.mmap.chunks)
+	kv.mu.Unlock()
+	r := KVReader{}
+	kv.BeginRead(&r)
+	m.Version = r.version
+	m.TreeHeight = r.tree.Height()
+	kv.EndRead(&r)
+	kv.commitMu.Lock()
+	m.Pages = kv.page.flushed
+	kv.commitMu.Unlock()
+	return m
+}
+
+// WriteMetrics writes a fresh snapshot of the metrics, which makes a KV a
+// metrics.Source.
+func (kv *KV) WriteMetrics(w *metrics.Writer) {
+	kv.Metrics().Write(w)
+}
+
+// Write writes m in the Prometheus text format.
+func (m Metrics) Write(w *metrics.Writer) {
+	w.Counter("elkdb_kv_gets_total", "Key lookups.", m.Gets)
+	w.Counter("elkdb_kv_sets_total", "Key inserts and updates.", m.Sets)
+	w.Counter("elkdb_kv_deletes_total", "Key deletes.", m.Deletes)
+	w.Counter("elkdb_kv_commits_total", "Write transactions committed.", m.Commits)
+	w.Counter("elkdb_kv_conflicts_total", "Commits rejected by a serialisation conflict.", m.Conflicts)
+	w.Counter("elkdb_kv_timeouts_total", "Commits failed by a timeout.", m.Timeouts)
+	w.Histogram("elkdb_kv_flush_seconds", "Time a commit spends writing and syncing the WAL.", m.Flush)
+	w.Counter("elkdb_kv_pages_allocated_total", "Pages allocated by committed transactions.", m.PagesAllocated)
+	w.Counter("elkdb_kv_pages_freed_total", "Pages freed by committed transactions.", m.PagesFreed)
+	w.Counter("elkdb_kv_pages_truncated_total", "Free pages cut off the end of the file.", m.PagesTruncated)
+	w.Counter("elkdb_kv_pages_punched_total", "Free pages whose disk blocks were deallocated.", m.PagesPunched)
+	w.Counter("elkdb_kv_pages_dropped_total", "Free pages whose mapped memory was released.", m.PagesDropped)
+	w.Counter("elkdb_kv_pages_prefetched_total", "Leaf pages iterators asked the kernel to read ahead.", m.PagesPrefetched)
+	w.Gauge("elkdb_kv_version", "Committed version.", float64(m.Version))
+	w.Gauge("elkdb_kv_pages", "Database size in pages.", float64(m.Pages))
+	w.Gauge("elkdb_kv_page_size_bytes", "Size of a page.", btree.PageSize)
+	w.Gauge("elkdb_kv_tree_height", "Levels of the B-tree.", float64(m.TreeHeight))
+	w.Gauge("elkdb_kv_readers", "Open read transactions.", float64(m.Readers))
+	w.Gauge("elkdb_kv_mappings", "Regions the data file is mapped in.", float64(m.Mappings))
+}
+
+// observeFlush records the duration of a WAL flush that started at start.
+func (s *kvStats) observeFlush(start time.Time) {
+	s.flush.Observe(time.Since(start))
+}
@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/metrics"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "metrics.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	m := db.Metrics()
+	is.Zero(t, m.Commits)
+	is.Zero(t, m.TreeHeight)
+
+	tx := KVTX{}
+	db.Begin(&tx)
+	for _, k := range []string{"a", "b", "c"} {
+		tx.Update(&btree.InsertReq{Key: []byte(k), Val: []byte("v")})
+	}
+	tx.Del(&btree.DeleteReq{Key: []byte("b")})
+	tx.Get([]byte("a"))
+	is.NoError(t, db.Commit(&tx))
+
+	// Two writers from the same version: the second one conflicts.
+	tx1, tx2 := KVTX{}, KVTX{}
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	tx1.Update(&btree.InsertReq{Key: []byte("d"), Val: []byte("v")})
+	tx2.Update(&btree.InsertReq{Key: []byte("d"), Val: []byte("w")})
+	is.NoError(t, db.Commit(&tx1))
+	is.ErrorContains(t, db.Commit(&tx2), "serialisation conflict")
+
+	r := KVReader{}
+	db.BeginRead(&r)
+	r.Get([]byte("d"))
+	m = db.Metrics()
+	db.EndRead(&r)
+
+	is.Equal(t, uint64(2), m.Gets)
+	is.Equal(t, uint64(5), m.Sets)
+	is.Equal(t, uint64(1), m.Deletes)
+	is.Equal(t, uint64(2), m.Commits)
+	is.Equal(t, uint64(1), m.Conflicts)
+	is.Equal(t, uint64(2), m.Flush.Count)
+	is.Positive(t, m.PagesAllocated)
+	is.Positive(t, m.PagesFreed) // the second commit replaces the first root
+	is.Equal(t, 1, m.TreeHeight)
+	is.Equal(t, 1, m.Readers)
+	is.Positive(t, m.Pages)
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	db.WriteMetrics(w)
+	is.NoError(t, w.Flush())
+	out := buf.String()
+	is.Contains(t, out, "elkdb_kv_sets_total 5\n")
+	is.Contains(t, out, "elkdb_kv_conflicts_total 1\n")
+	is.Contains(t, out, "elkdb_kv_flush_seconds_count 2\n")
+	is.Contains(t, out, "elkdb_kv_tree_height 1\n")
+	is.True(t, strings.HasPrefix(out, "# HELP elkdb_kv_gets_total "))
+}
@@ -0,0 +1,67 @@
+package kv
+
+import (
+	"syscall"
+)
+
+// lockMemory locks the mapped pages of the file into memory with mlock(2),
+// from the start of the file up to KV.LockMemory bytes, so that reads of
+// them never wait for the disk. It runs whenever the file or its mapping
+// grows. If the kernel refuses, most likely because RLIMIT_MEMLOCK is too
+// low, it logs a warning and stops trying; what it locked stays locked.
+func (kv *KV) lockMemory() {
+	limit := int(min(kv.LockMemory, int64(kv.mmap.file), int64(kv.mmap.total)))
+	if kv.mmap.lockOff || kv.mmap.locked >= limit {
+		return
+	}
+	err := mappedRange(kv.mmap.chunks, kv.mmap.locked, limit, func(mem []byte) error {
+		if err := mlock(mem); err != nil {
+			return err
+		}
+		kv.mu.Lock()
+		kv.mmap.locked += len(mem)
+		kv.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		kv.mmap.lockOff = true
+		var rl syscall.Rlimit
+		_ = syscall.Getrlimit(rlimitMemlock, &rl)
+		kv.log().Warn("kv: cannot lock the mapping into memory, going on without",
+			"path", kv.Path, "locked", kv.mmap.locked, "want", limit, "rlimit_memlock", rl.Cur, "err", err)
+	}
+}
+
+// unlockMemory unlocks the locked pages from offset size on, which the file
+// no longer holds. It runs under mu.
+func (kv *KV) unlockMemory(size int) {
+	if kv.mmap.locked <= size {
+		return
+	}
+	_ = mappedRange(kv.mmap.chunks, size, kv.mmap.locked, munlock)
+	kv.mmap.locked = size
+}
+
+// mappedRange calls fn with the mapped memory of the bytes [lo, hi) of the
+// file, one piece per chunk, and stops at its first error.
+func mappedRange(chunks [][]byte, lo, hi int, fn func(mem []byte) error) error {
+	start := 0
+	for _, chunk := range chunks {
+		end := start + len(chunk)
+		if a, b := max(lo, start), min(hi, end); a < b {
+			if err := fn(chunk[a-start : b-start]); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// LockedBytes returns how much of the file KV.LockMemory has locked into
+// memory.
+func (kv *KV) LockedBytes() int64 {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return int64(kv.mmap.locked)
+}
@@ -0,0 +1,40 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestLockMemory(t *testing.T) {
+	const limit = 1 << 20
+	db := &KV{Path: filepath.Join(t.TempDir(), "mlock.db"), NoSync: true, LockMemory: limit}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	write := func(prefix string, n int) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range n {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i), Val: bytes.Repeat([]byte{'v'}, 1000)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+
+	// The lock follows the file as it grows, up to the limit.
+	write("a", 10)
+	if db.mmap.lockOff {
+		t.Skip("RLIMIT_MEMLOCK too low")
+	}
+	is.Equal(t, int64(db.mmap.file), db.LockedBytes())
+	write("b", 3000)
+	is.Equal(t, int64(limit), db.LockedBytes())
+
+	// Compact moves it to the new file.
+	_, err := db.Compact()
+	is.NoError(t, err)
+	is.Equal(t, int64(min(limit, db.mmap.file)), db.LockedBytes())
+}
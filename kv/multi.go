@@ -0,0 +1,46 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// Pair is a key and the value SetMulti sets it to.
+type Pair struct {
+	Key, Val []byte
+}
+
+// SetMulti sets every key of pairs to its value in one write transaction,
+// made durable by one commit: a reader sees all of the new values or none
+// of them. When a key appears more than once, its last value wins. The
+// pairs are checked before anything is written, and a pair with an empty
+// or oversized key or an oversized value fails the whole call. The inserts
+// are applied in key order, which touches each leaf once per run of keys
+// in it. A serialisation conflict with a concurrent writer is retried.
+func (kv *KV) SetMulti(pairs []Pair) error {
+	for i, p := range pairs {
+		if len(p.Key) == 0 {
+			return fmt.Errorf("set pair %d: empty key", i)
+		}
+		if err := kv.CheckSize(len(p.Key), len(p.Val)); err != nil {
+			return fmt.Errorf("set pair %d: %w", i, err)
+		}
+	}
+	sorted := slices.Clone(pairs)
+	slices.SortStableFunc(sorted, func(a, b Pair) int { return bytes.Compare(a.Key, b.Key) })
+
+	return Retry(func() error {
+		tx := KVTX{}
+		kv.Begin(&tx)
+		for i, p := range sorted {
+			if i+1 < len(sorted) && bytes.Equal(p.Key, sorted[i+1].Key) {
+				continue // a later value replaces it
+			}
+			tx.Update(&btree.InsertReq{Key: p.Key, Val: p.Val})
+		}
+		return kv.Commit(&tx)
+	})
+}
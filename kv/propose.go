@@ -0,0 +1,294 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// Logged mode.
+//
+// With KV.Propose set, Commit does not write a transaction: it encodes the
+// changes the transaction made (the keys it set and deleted) as an entry and
+// hands it to Propose, for a replicated log such as package raftdb keeps.
+// Every node then writes the entries the log commits, in log order, through
+// ApplyLogged, so that the stores of all nodes go through the same states.
+//
+// Entry layout. Integers are little-endian.
+//
+//	header | base | count |
+//	       |  8B  |   4B  |
+//	op     | type | key len | key | val len | val |  (count times)
+//	       |  1B  |    4B   |     |    4B   |     |
+//
+// An entry carries the log index the transaction read its snapshot at
+// (base). ApplyLogged refuses it with ErrConflict when an entry applied
+// after base wrote anything, a test that depends on the log alone and thus
+// comes out the same on every node.
+const entryHeader = 8 + 4
+
+const (
+	opSet byte = 1
+	opDel byte = 2
+)
+
+// ErrConflict is returned by Commit when another transaction committed
+// after this one began, and by ApplyLogged for an entry whose snapshot a
+// later entry made stale. The transaction should be retried.
+var ErrConflict = errors.New("serialisation conflict: retry transaction")
+
+// MaxRetries bounds the attempts of Retry at a transaction that keeps losing
+// serialisation conflicts.
+const MaxRetries = 20
+
+// Retry calls attempt, which runs and commits one transaction, again while
+// it fails with ErrConflict, up to MaxRetries calls in all, and returns the
+// error of the last call. It is the retry rule of KV.SetMulti and of
+// tables.DB.Update.
+func Retry(attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if errors.Is(err, ErrConflict) && i < MaxRetries {
+			continue
+		}
+		return err
+	}
+}
+
+// logKey holds the logPos of the store in logged mode. Its prefix of four
+// zero bytes sorts it before the keys of the tables layer, whose prefixes
+// start at 1.
+var logKey = []byte("\x00\x00\x00\x00log")
+
+// logPos is the position of the store in the replicated log: the index of
+// the last entry applied, and of the last one that changed the store.
+type logPos struct {
+	applied, lastWrite uint64
+}
+
+// Op is one change of a transaction in logged mode: Key set to Val, or
+// deleted if Del.
+type Op struct {
+	Key, Val []byte
+	Del      bool
+}
+
+// proposing reports whether the transaction is recorded and proposed,
+// rather than written, by Commit.
+func (tx *KVTX) proposing() bool {
+	return tx.kv.Propose != nil && tx.logPos == nil
+}
+
+// propose hands the changes of tx to KV.Propose; a transaction that changed
+// nothing is not proposed.
+func (kv *KV) propose(tx *KVTX) error {
+	if kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+	return kv.Propose(encodeEntry(tx.base, tx.ops))
+}
+
+func encodeEntry(base uint64, ops []Op) []byte {
+	n := entryHeader
+	for _, op := range ops {
+		n += 1 + 4 + len(op.Key) + 4 + len(op.Val)
+	}
+	buf := make([]byte, 0, n)
+	buf = binary.LittleEndian.AppendUint64(buf, base)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(ops)))
+	for _, op := range ops {
+		if op.Del {
+			buf = append(buf, opDel)
+		} else {
+			buf = append(buf, opSet)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(op.Key)))
+		buf = append(buf, op.Key...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(op.Val)))
+		buf = append(buf, op.Val...)
+	}
+	return buf
+}
+
+// decodeEntry returns the base and the changes of an entry made by Commit
+// in logged mode.
+func decodeEntry(entry []byte) (base uint64, ops []Op, err error) {
+	bad := errors.New("bad log entry")
+	if len(entry) < entryHeader {
+		return 0, nil, bad
+	}
+	base = binary.LittleEndian.Uint64(entry)
+	count := binary.LittleEndian.Uint32(entry[8:])
+	rest := entry[entryHeader:]
+	field := func() ([]byte, bool) {
+		if len(rest) < 4 || uint64(len(rest)-4) < uint64(binary.LittleEndian.Uint32(rest)) {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(rest)
+		b := rest[4 : 4+n]
+		rest = rest[4+n:]
+		return b, true
+	}
+	for range count {
+		if len(rest) == 0 || (rest[0] != opSet && rest[0] != opDel) {
+			return 0, nil, bad
+		}
+		op := Op{Del: rest[0] == opDel}
+		rest = rest[1:]
+		var ok1, ok2 bool
+		op.Key, ok1 = field()
+		op.Val, ok2 = field()
+		if !ok1 || !ok2 {
+			return 0, nil, bad
+		}
+		ops = append(ops, op)
+	}
+	if len(rest) != 0 {
+		return 0, nil, bad
+	}
+	return base, ops, nil
+}
+
+// ApplyLogged applies the entry at index of the replicated log, as encoded
+// by Commit in logged mode. Entries must be applied in log order, each
+// once. An entry whose snapshot was made stale by an entry applied after
+// it is refused with ErrConflict and changes nothing; the refusal is the
+// same on every node.
+func (kv *KV) ApplyLogged(index uint64, entry []byte) error {
+	base, ops, err := decodeEntry(entry)
+	kv.mu.Lock()
+	stale := kv.logged.lastWrite > base
+	if err != nil || stale {
+		kv.logged.applied = index
+	}
+	kv.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("entry %d: %w", index, err)
+	}
+	if stale {
+		kv.stats.conflicts.Add(1)
+		return ErrConflict
+	}
+	return kv.writeLogged(logPos{index, index}, func(tx *KVTX) {
+		for _, op := range ops {
+			if op.Del {
+				tx.Del(&btree.DeleteReq{Key: op.Key})
+			} else {
+				tx.Update(&btree.InsertReq{Key: op.Key, Val: op.Val})
+			}
+		}
+	})
+}
+
+// SkipLogged records that the entry at index of the replicated log holds
+// nothing for the store, such as a change of the log's membership.
+func (kv *KV) SkipLogged(index uint64) {
+	kv.mu.Lock()
+	kv.logged.applied = max(kv.logged.applied, index)
+	kv.mu.Unlock()
+}
+
+// InstallLogged replaces the contents of the store with those of src, in
+// one transaction, and moves it to index applied of the replicated log.
+// src is a snapshot of a node's store taken at applied or later, and
+// lastWrite the last entry up to applied that changed it: applying the
+// entries after applied then brings the store to the state of that node,
+// even over the changes src already holds.
+func (kv *KV) InstallLogged(src *KV, applied, lastWrite uint64) error {
+	from := KVReader{}
+	src.BeginRead(&from)
+	defer src.EndRead(&from)
+	return kv.writeLogged(logPos{applied, lastWrite}, func(tx *KVTX) {
+		// A commit between Begin and this snapshot fails the transaction
+		// with a conflict, and writeLogged starts over.
+		cur := KVReader{}
+		kv.BeginRead(&cur)
+		defer kv.EndRead(&cur)
+		a, b := cur.Seek(nil, btree.CmpGT), from.Seek(nil, btree.CmpGT)
+		for a.Valid() || b.Valid() {
+			cmp := 0
+			switch {
+			case !b.Valid():
+				cmp = -1
+			case !a.Valid():
+				cmp = 1
+			default:
+				ka, _ := a.Deref()
+				kb, _ := b.Deref()
+				cmp = bytes.Compare(ka, kb)
+			}
+			switch {
+			case cmp < 0:
+				key, _ := a.Deref()
+				tx.Del(&btree.DeleteReq{Key: key})
+				a.Next()
+			case cmp > 0:
+				key, val := b.Deref()
+				tx.Update(&btree.InsertReq{Key: key, Val: val})
+				b.Next()
+			default:
+				_, va := a.Deref()
+				key, vb := b.Deref()
+				if !bytes.Equal(va, vb) {
+					tx.Update(&btree.InsertReq{Key: key, Val: vb})
+				}
+				a.Next()
+				b.Next()
+			}
+		}
+	})
+}
+
+// LogState returns the index of the last entry of the replicated log the
+// store applied, and of the last one that changed it. Both are 0 for a
+// store that never ran in logged mode, or that is not open in it.
+func (kv *KV) LogState() (applied, lastWrite uint64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.logged.applied, kv.logged.lastWrite
+}
+
+// writeLogged writes the transaction made by fn, which leaves the store at
+// pos. A conflict with a concurrent Compact is retried.
+func (kv *KV) writeLogged(pos logPos, fn func(tx *KVTX)) error {
+	val := binary.LittleEndian.AppendUint64(nil, pos.applied)
+	val = binary.LittleEndian.AppendUint64(val, pos.lastWrite)
+	for {
+		tx := KVTX{logPos: &pos}
+		kv.Begin(&tx)
+		fn(&tx)
+		// Not a change of the store's contents: neither counted nor watched.
+		tx.tree.InsertEx(&btree.InsertReq{Key: logKey, Val: val})
+		if err := kv.Commit(&tx); !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+}
+
+// loadLogState reads the log position stored by the last session, in
+// logged mode.
+func (kv *KV) loadLogState() {
+	if kv.Propose == nil {
+		return
+	}
+	r := KVReader{}
+	kv.BeginRead(&r)
+	val, ok := r.Get(logKey)
+	kv.EndRead(&r)
+	if ok && len(val) == 16 {
+		kv.logged = logPos{binary.LittleEndian.Uint64(val), binary.LittleEndian.Uint64(val[8:])}
+	}
+}
+
+// recordOp adds a change to the ops of a proposing transaction.
+func (tx *KVTX) recordOp(op Op) {
+	op.Key, op.Val = slices.Clone(op.Key), slices.Clone(op.Val)
+	tx.ops = append(tx.ops, op)
+}
@@ -0,0 +1,167 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestLoggedMode(t *testing.T) {
+	dir := t.TempDir()
+	var log [][]byte
+	propose := func(entry []byte) error {
+		log = append(log, entry)
+		return nil
+	}
+	open := func(name string) *KV {
+		store := &KV{Path: filepath.Join(dir, name), NoSync: true, Propose: propose}
+		is.NoError(t, store.Open())
+		return store
+	}
+	a, b := open("a.db"), open("b.db")
+	defer func() { a.Close() }()
+	defer b.Close()
+	// sync applies the entries of the log neither store has applied yet.
+	sync := func() []error {
+		t.Helper()
+		var errs []error
+		applied, _ := a.LogState()
+		for i := applied; i < uint64(len(log)); i++ {
+			ea := a.ApplyLogged(i+1, log[i])
+			eb := b.ApplyLogged(i+1, log[i])
+			is.Equal(t, ea, eb)
+			errs = append(errs, ea)
+		}
+		return errs
+	}
+	get := func(store *KV, key string) string {
+		r := KVReader{}
+		store.BeginRead(&r)
+		defer store.EndRead(&r)
+		val, _ := r.Get([]byte(key))
+		return string(val)
+	}
+	keys := func(store *KV) (out []string) {
+		r := KVReader{}
+		store.BeginRead(&r)
+		defer store.EndRead(&r)
+		for iter := r.Seek(nil, btree.CmpGT); iter.Valid(); iter.Next() {
+			key, val := iter.Deref()
+			if !bytes.Equal(key, logKey) {
+				out = append(out, string(key)+"="+string(val))
+			}
+		}
+		return out
+	}
+
+	// Commit proposes the changes; the stores change as the log is applied.
+	pages := a.pageAlloc
+	tx := KVTX{}
+	a.Begin(&tx)
+	for i := range 500 {
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%04d", i), Val: bytes.Repeat([]byte{'v'}, 100)})
+	}
+	is.NoError(t, a.Commit(&tx))
+	is.Len(t, log, 1)
+	is.Equal(t, pages, a.pageAlloc)
+	is.Equal(t, "", get(a, "k0000"))
+	is.Equal(t, []error{nil}, sync())
+	is.Equal(t, 100, len(get(b, "k0000")))
+
+	// Changes are effects: a delete of a missing key and a no-op update
+	// are not logged, and a transaction without changes is not proposed.
+	tx = KVTX{}
+	b.Begin(&tx)
+	tx.Del(&btree.DeleteReq{Key: []byte("missing")})
+	tx.Update(&btree.InsertReq{Key: []byte("k0001"), Val: bytes.Repeat([]byte{'v'}, 100)})
+	is.NoError(t, b.Commit(&tx))
+	is.Len(t, log, 1)
+
+	// Of two transactions on the same snapshot, the one logged second
+	// conflicts on every store.
+	t1, t2 := KVTX{}, KVTX{}
+	a.Begin(&t1)
+	b.Begin(&t2)
+	t1.Update(&btree.InsertReq{Key: []byte("k0000"), Val: []byte("t1")})
+	t2.Del(&btree.DeleteReq{Key: []byte("k0000")})
+	t2.Update(&btree.InsertReq{Key: []byte("t2"), Val: []byte("t2")})
+	is.NoError(t, a.Commit(&t1))
+	is.NoError(t, b.Commit(&t2))
+	is.Equal(t, []error{nil, ErrConflict}, sync())
+	is.Equal(t, "t1", get(a, "k0000"))
+	is.Equal(t, keys(a), keys(b))
+	applied, lastWrite := b.LogState()
+	is.Equal(t, [2]uint64{3, 2}, [2]uint64{applied, lastWrite})
+
+	// A transaction that began after the conflict sees the last write.
+	tx = KVTX{}
+	b.Begin(&tx)
+	tx.Del(&btree.DeleteReq{Key: []byte("k0499")})
+	is.NoError(t, b.Commit(&tx))
+	is.Equal(t, []error{nil}, sync())
+	is.Equal(t, keys(a), keys(b))
+
+	// The position survives a reopen.
+	a.Close()
+	a = open("a.db")
+	applied, lastWrite = a.LogState()
+	is.Equal(t, [2]uint64{4, 4}, [2]uint64{applied, lastWrite})
+
+	// A store installs a snapshot over different contents, then catches up
+	// with the entries after it, even over the changes the snapshot holds.
+	c := open("c.db")
+	defer c.Close()
+	tx = KVTX{logPos: &logPos{}}
+	c.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k0000"), Val: []byte("other")})
+	tx.Update(&btree.InsertReq{Key: []byte("z"), Val: []byte("z")})
+	is.NoError(t, c.Commit(&tx))
+	snapApplied, snapLastWrite := a.LogState()
+	tx = KVTX{}
+	a.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k0000"), Val: []byte("later")})
+	is.NoError(t, a.Commit(&tx))
+	is.Equal(t, []error{nil}, sync())
+	is.NoError(t, c.InstallLogged(a, snapApplied, snapLastWrite))
+	applied, lastWrite = c.LogState()
+	is.Equal(t, [2]uint64{snapApplied, snapLastWrite}, [2]uint64{applied, lastWrite})
+	for i := snapApplied; i < uint64(len(log)); i++ {
+		is.NoError(t, c.ApplyLogged(i+1, log[i]))
+	}
+	is.Equal(t, keys(a), keys(c))
+	is.True(t, c.Check().OK())
+
+	is.ErrorContains(t, a.ApplyLogged(uint64(len(log)+1), []byte("junk")), "bad log entry")
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	is.NoError(t, Retry(func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("commit: %w", ErrConflict)
+		}
+		return nil
+	}))
+	is.Equal(t, 3, calls)
+
+	// Other errors are returned at once.
+	calls = 0
+	is.EqualError(t, Retry(func() error {
+		calls++
+		return fmt.Errorf("no")
+	}), "no")
+	is.Equal(t, 1, calls)
+
+	// A transaction that always conflicts is given up on.
+	calls = 0
+	is.ErrorIs(t, Retry(func() error {
+		calls++
+		return ErrConflict
+	}), ErrConflict)
+	is.Equal(t, MaxRetries, calls)
+}
@@ -0,0 +1,162 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"syscall"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// Flags of fallocate(2) missing from package syscall.
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// punchQueue records the pages tx freed at version for punchHoles, and
+// forgets the queued pages it wrote again. It runs under commitMu.
+func (kv *KV) punchQueue(tx *KVTX, freed []uint64, version uint64) {
+	if kv.punch.pending == nil {
+		kv.punch.pending = map[uint64]uint64{}
+	}
+	for ptr, page := range tx.page.updates {
+		if page != nil {
+			delete(kv.punch.pending, ptr)
+		}
+	}
+	for _, ptr := range freed {
+		kv.punch.pending[ptr] = version
+	}
+}
+
+// punchHoles deallocates the disk blocks of the queued free pages that no
+// reader can see any more, or with DropFreed releases their mapped memory,
+// or both. Like truncateTail it runs under commitMu after a
+// commit, and only while no write transaction is open: those may read any
+// page. The pages it cannot punch yet wait for a later commit.
+func (kv *KV) punchHoles() {
+	if (kv.punch.off && !kv.DropFreed) || !kv.txGate.TryLock() {
+		return
+	}
+	defer kv.txGate.Unlock()
+	minReader := kv.version
+	kv.mu.Lock()
+	if len(kv.readers) > 0 {
+		minReader = kv.readers[0].version
+	}
+	kv.mu.Unlock()
+
+	var ptrs []uint64
+	for ptr, version := range kv.punch.pending {
+		switch {
+		case ptr >= kv.page.flushed: // truncated
+			delete(kv.punch.pending, ptr)
+		case version <= minReader:
+			ptrs = append(ptrs, ptr)
+			delete(kv.punch.pending, ptr)
+		}
+	}
+	slices.Sort(ptrs)
+	if kv.DropFreed {
+		kv.dropPages(ptrs)
+	}
+	if kv.PunchHoles {
+		kv.punchPages(ptrs)
+	}
+}
+
+// punchFreeList punches every page on the free list. Replaying the WAL
+// writes back pages that were punched after they were freed, so Open and
+// Close call it after recovery and checkpoint. No transaction may be open.
+func (kv *KV) punchFreeList() {
+	r := &KVReader{mmapMu: &kv.mmapMu}
+	r.mmap.chunks = kv.mmap.chunks
+	var ptrs []uint64
+	btree.FreeListWalk(r, kv.free.Head, kv.page.flushed, func(ptr, _ uint64) {
+		ptrs = append(ptrs, ptr)
+	})
+	slices.Sort(ptrs)
+	clear(kv.punch.pending)
+	kv.punchPages(ptrs)
+}
+
+// punchPages punches holes over ptrs, which are sorted. A filesystem without
+// support turns punching off for the rest of the session; other failures are
+// logged and the pages keep their blocks.
+func (kv *KV) punchPages(ptrs []uint64) {
+	if kv.punch.off || len(ptrs) == 0 {
+		return
+	}
+	fd := int(kv.fp.Fd())
+	n := uint64(0)
+	err := pageRuns(ptrs, func(first, count uint64) error {
+		err := fallocate(fd, fallocPunchHole|fallocKeepSize,
+			int64(first*btree.PageSize), int64(count*btree.PageSize))
+		if err == nil {
+			n += count
+		}
+		return err
+	})
+	kv.stats.pagesPunched.Add(n)
+	switch {
+	case errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS):
+		kv.punch.off = true
+		kv.log().Warn("kv: hole punching not supported, turned off", "path", kv.Path, "err", err)
+	case err != nil:
+		kv.log().Warn("kv: hole punching failed", "path", kv.Path, "err", err)
+	default:
+		kv.log().Debug("kv: punched free pages", "path", kv.Path, "pages", n)
+	}
+}
+
+// reservePages allocates disk blocks for ptrs, which are sorted, in case
+// they were punched. Writing into a hole through the mapping would otherwise
+// need a block when the page is touched, and on a full disk that is a
+// SIGBUS rather than an error.
+func reservePages(kv *KV, ptrs []uint64) error {
+	fd := int(kv.fp.Fd())
+	return pageRuns(ptrs, func(first, count uint64) error {
+		err := fallocate(fd, fallocKeepSize, int64(first*btree.PageSize), int64(count*btree.PageSize))
+		if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+			return nil // nor can there be holes
+		}
+		if err != nil {
+			return fmt.Errorf("fallocate: %w", err)
+		}
+		return nil
+	})
+}
+
+// reserveUpdates reserves the pages inside the file that tx writes, if
+// PunchHoles may have left holes there.
+func (kv *KV) reserveUpdates(tx *KVTX) error {
+	if !kv.PunchHoles {
+		return nil
+	}
+	var ptrs []uint64
+	for ptr, page := range tx.page.updates {
+		if page != nil && ptr < kv.page.flushed {
+			ptrs = append(ptrs, ptr)
+		}
+	}
+	slices.Sort(ptrs)
+	return kv.retryIO("reserve pages", func() error { return reservePages(kv, ptrs) })
+}
+
+// pageRuns calls fn with the first page and the length of every run of
+// consecutive pages in ptrs, which are sorted, and stops at its first error.
+func pageRuns(ptrs []uint64, fn func(first, count uint64) error) error {
+	for len(ptrs) > 0 {
+		n := 1
+		for n < len(ptrs) && ptrs[n] == ptrs[0]+uint64(n) {
+			n++
+		}
+		if err := fn(ptrs[0], uint64(n)); err != nil {
+			return err
+		}
+		ptrs = ptrs[n:]
+	}
+	return nil
+}
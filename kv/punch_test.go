@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestPunchHoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "punch.db")
+	db := &KV{Path: path, NoSync: true, PunchHoles: true}
+	is.NoError(t, db.Open())
+	defer func() { db.Close() }()
+	blocks := func() int64 {
+		var st syscall.Stat_t
+		is.NoError(t, syscall.Stat(path, &st))
+		return st.Blocks
+	}
+	val := func(c byte) []byte { return bytes.Repeat([]byte{c}, 1000) }
+	write := func(prefix string, n int, c byte) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range n {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i), Val: val(c)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	del := func(prefix string, n int) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range n {
+			tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "%s%05d", prefix, i)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+
+	// The deleted keys sit in the middle of the file, so the pages are not
+	// truncated but punched.
+	write("a", 3000, 'a')
+	write("z", 100, 'z')
+	if db.punch.off {
+		t.Skip("filesystem does not punch holes")
+	}
+	before, punched := blocks(), db.Metrics().PagesPunched
+
+	// A snapshot keeps the freed pages intact until it ends.
+	r := KVReader{}
+	db.BeginRead(&r)
+	del("a", 3000)
+	is.Equal(t, punched, db.Metrics().PagesPunched)
+	got, ok := r.Get([]byte("a01234"))
+	is.True(t, ok && bytes.Equal(val('a'), got))
+	db.EndRead(&r)
+
+	write("z", 1, 'y') // the next commit punches them
+	if db.punch.off {
+		t.Skip("filesystem does not punch holes")
+	}
+	punched = db.Metrics().PagesPunched - punched
+	free := db.FreeStats().FreePages // a few list nodes given up are left
+	is.Greater(t, free, 100)
+	is.InDelta(t, free, punched, 8)
+	is.Empty(t, db.punch.pending)
+	is.LessOrEqual(t, blocks(), before-int64(punched*btree.PageSize/512)+64)
+	fi, err := os.Stat(path)
+	is.NoError(t, err)
+	is.Equal(t, int64(db.mmap.file), fi.Size())
+
+	// Punched pages are reused like any other.
+	write("b", 3000, 'b')
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(3000+100), check.Keys)
+
+	// Closing replays the WAL into the file, then punches the free list again.
+	del("b", 3000)
+	db.Close()
+	closed := blocks()
+	db = &KV{Path: path, NoSync: true, PunchHoles: true}
+	is.NoError(t, db.Open())
+	is.Equal(t, closed, blocks())
+	r = KVReader{}
+	db.BeginRead(&r)
+	for i := 1; i < 100; i++ { // z00000 was overwritten
+		got, ok := r.Get(fmt.Appendf(nil, "z%05d", i))
+		is.True(t, ok && bytes.Equal(val('z'), got), i)
+	}
+	_, ok = r.Get([]byte("b00000"))
+	is.False(t, ok)
+	db.EndRead(&r)
+	st := db.FreeStats()
+	is.Greater(t, st.FreePages, 100)
+	is.Less(t, closed, int64(db.mmap.file-st.FreePages*btree.PageSize)/512+64)
+}
@@ -0,0 +1,215 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// RecoverTarget bounds the commits Recover replays. The zero value replays
+// every archived commit.
+type RecoverTarget struct {
+	Version uint64    // stop at this version; 0 for no bound
+	Time    time.Time // replay only the commits made at or before it; zero for no bound
+}
+
+// RecoverReport describes a point-in-time recovery.
+type RecoverReport struct {
+	Version  uint64    // version of the recovered file
+	Time     time.Time // when the last replayed commit was made; zero if none was
+	Commits  int       // commits replayed
+	Segments int       // archived segments that commits were replayed from
+}
+
+// Recover brings the closed database file at base forward by replaying the
+// WAL segments archived in walDir (see KV.WALArchive), stopping at upto.
+// base holds the starting point and must keep the page layout of the store:
+// a file restored with RestoreFrom and ApplyBackup, a copy of the closed data
+// file, or one recovered earlier, but not a SnapshotTo copy. Commits up to
+// its version are skipped and the following ones replayed in order; a
+// version missing from the archive, which a lost segment or a Compact
+// leaves, fails the recovery unless upto stops before it. The replay works
+// on a copy of the file that must pass btree.Check before it replaces base.
+func Recover(base, walDir string, upto RecoverTarget) (RecoverReport, error) {
+	src, err := os.Open(base)
+	if err != nil {
+		return RecoverReport{}, fmt.Errorf("recover: %w", err)
+	}
+	defer src.Close()
+	if fi, err := os.Stat(base + ".wal"); err == nil && fi.Size() > 16 {
+		return RecoverReport{}, fmt.Errorf("recover: %s has commits in its WAL: open and close it first", base)
+	}
+	master := make([]byte, masterSize)
+	if _, err := src.ReadAt(master, 0); err != nil || !bytes.HasPrefix(master, []byte(dbSig)) {
+		return RecoverReport{}, fmt.Errorf("recover: %s: bad master page", base)
+	}
+	segs, err := filepath.Glob(filepath.Join(walDir, "*.wal"))
+	if err != nil {
+		return RecoverReport{}, fmt.Errorf("recover: %w", err)
+	}
+
+	r := &replay{
+		upto:   upto,
+		root:   binary.LittleEndian.Uint64(master[16:]),
+		npages: binary.LittleEndian.Uint64(master[24:]),
+		free:   binary.LittleEndian.Uint64(master[32:]),
+	}
+	r.maxKey, r.maxVal = masterLimits(master)
+	r.rep.Version = binary.LittleEndian.Uint64(master[40:])
+	if upto.Version != 0 && upto.Version < r.rep.Version {
+		return RecoverReport{}, fmt.Errorf("recover: %s holds version %d, past %d", base, r.rep.Version, upto.Version)
+	}
+	err = installFile(base, base+".recover", src, func(fp *os.File) error {
+		r.fp = fp
+		for _, seg := range segs { // in the order of their first transaction
+			if r.done {
+				break
+			}
+			if err := r.segment(seg); err != nil {
+				return err
+			}
+		}
+		if upto.Version > r.rep.Version {
+			return fmt.Errorf("the archive ends at version %d", r.rep.Version)
+		}
+		return r.finish()
+	})
+	if err != nil {
+		return RecoverReport{}, fmt.Errorf("recover: %w", err)
+	}
+	return r.rep, nil
+}
+
+// replay lays archived commits over a database file.
+type replay struct {
+	fp   *os.File
+	upto RecoverTarget
+	rep  RecoverReport // Version is the version fp holds
+
+	root, free, npages uint64
+	maxKey, maxVal     int  // of the base file
+	done               bool // upto was reached
+	err                error
+}
+
+// segment replays the commits of one archived WAL segment.
+func (r *replay) segment(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) < 16 || string(data[:len(walSig)]) != walSig {
+		return fmt.Errorf("%s: %w", path, errBadWAL)
+	}
+	if v := binary.LittleEndian.Uint32(data[8:]); v > walVersion {
+		return fmt.Errorf("%s: unsupported WAL version %d (max %d)", path, v, walVersion)
+	}
+	commits := r.rep.Commits
+	walCommits(data, r.commit)
+	if r.err != nil {
+		return fmt.Errorf("%s: %w", path, r.err)
+	}
+	if r.rep.Commits > commits {
+		r.rep.Segments++
+	}
+	return nil
+}
+
+// commit replays one transaction, and reports whether to go on.
+func (r *replay) commit(txID uint64, state commitState, pages []walEntry) bool {
+	if state.Version == 0 {
+		r.err = fmt.Errorf("transaction %d was logged without its version", txID)
+		return false
+	}
+	truncation := state.Version == txID // a tail truncation keeps the version
+	next := r.rep.Version + 1
+	if truncation {
+		next = r.rep.Version
+	}
+	if state.Version < next {
+		return true // already in the file
+	}
+	if r.upto.Version != 0 && state.Version > r.upto.Version ||
+		!r.upto.Time.IsZero() && state.Time > r.upto.Time.UnixNano() {
+		r.done = true
+		return false
+	}
+	if state.Version > next {
+		r.err = fmt.Errorf("the archive skips from version %d to %d", r.rep.Version, state.Version)
+		return false
+	}
+	for _, e := range pages {
+		if e.pageNum >= state.PageFlushed {
+			continue // cut off the file by a later truncation
+		}
+		if _, err := r.fp.WriteAt(e.data, int64(e.pageNum)*btree.PageSize); err != nil {
+			r.err = err
+			return false
+		}
+	}
+	r.root, r.free, r.npages = state.Root, state.FreeHead, state.PageFlushed
+	r.rep.Version = state.Version
+	if !truncation {
+		r.rep.Time = time.Unix(0, state.Time)
+		r.rep.Commits++
+	}
+	return true
+}
+
+// finish sizes the file, checks it and writes its master page.
+func (r *replay) finish() error {
+	if err := r.fp.Truncate(int64(r.npages) * btree.PageSize); err != nil {
+		return err
+	}
+	report := btree.Check(&salvageStore{fp: r.fp}, r.root, r.free, r.npages)
+	if !report.OK() {
+		return fmt.Errorf("replayed file is damaged: %v", report.Problems[0])
+	}
+	_, err := r.fp.WriteAt(masterData(r.root, r.npages, r.free, r.rep.Version, r.maxKey, r.maxVal), 0)
+	return err
+}
+
+// walCommits calls fn with every committed transaction in the WAL file
+// contents in data, in commit order, until fn returns false.
+func walCommits(data []byte, fn func(txID uint64, state commitState, pages []walEntry) bool) {
+	txPages := map[uint64][]walEntry{}
+	stop := false
+	walRecords(data, func(recType byte, payload []byte) {
+		if stop {
+			return
+		}
+		txID := binary.LittleEndian.Uint64(payload)
+		switch recType {
+		case walPageData:
+			txPages[txID] = append(txPages[txID], parsePage(payload))
+		case walCommitTX:
+			_, state := parseCommit(payload)
+			stop = !fn(txID, state, txPages[txID])
+			delete(txPages, txID)
+		}
+	})
+}
+
+// archive copies the WAL into dir as a segment named after the ID of its
+// first transaction, so that segments sort in commit order.
+func (wal *WAL) archive(dir string) error {
+	src, err := os.Open(wal.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	head := make([]byte, 16+9+8)
+	if _, err := src.ReadAt(head, 0); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%020d.wal", binary.LittleEndian.Uint64(head[25:])))
+	return installFile(name, name+".tmp", src, nil)
+}
@@ -0,0 +1,144 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	path := filepath.Join(dir, "src.db")
+	db := &KV{Path: path, NoSync: true, WALArchive: archive}
+	is.NoError(t, db.Open())
+	reopen := func() {
+		db.Close()
+		db = &KV{Path: path, NoSync: true, WALArchive: archive}
+		is.NoError(t, db.Open())
+	}
+	key := func(i int) []byte { return fmt.Appendf(nil, "k%05d", i) }
+	commit := func(f func(tx *KVTX)) uint64 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		f(&tx)
+		is.NoError(t, db.Commit(&tx))
+		return db.Stats().Version
+	}
+	insert := func(i int) func(tx *KVTX) {
+		return func(tx *KVTX) { tx.Update(&btree.InsertReq{Key: key(i), Val: []byte("v")}) }
+	}
+
+	commit(func(tx *KVTX) {
+		for i := range 3000 {
+			tx.Update(&btree.InsertReq{Key: key(i), Val: make([]byte, 200)})
+		}
+	})
+	var buf bytes.Buffer
+	full, err := db.Backup(&buf)
+	is.NoError(t, err)
+	stream := buf.Bytes()
+
+	// The commits after the backup span two segments, and one of them is
+	// followed by a tail truncation.
+	v1 := commit(insert(5000))
+	reopen()
+	v2 := commit(func(tx *KVTX) {
+		for i := 1500; i < 3000; i++ {
+			tx.Del(&btree.DeleteReq{Key: key(i)})
+		}
+	})
+	is.NotZero(t, db.Metrics().PagesTruncated)
+	mid := time.Now()
+	time.Sleep(time.Millisecond)
+	v3 := commit(insert(6000))
+	db.Close()
+	segs, err := filepath.Glob(filepath.Join(archive, "*.wal"))
+	is.NoError(t, err)
+	is.Len(t, segs, 2)
+
+	n := 0
+	restore := func() string {
+		n++
+		p := filepath.Join(dir, fmt.Sprintf("base%d.db", n))
+		is.NoError(t, RestoreFrom(bytes.NewReader(stream), p))
+		return p
+	}
+	recovered := func(p string, version uint64, keys ...int) {
+		t.Helper()
+		db := &KV{Path: p, NoSync: true}
+		is.NoError(t, db.Open())
+		defer db.Close()
+		check := db.Check()
+		is.True(t, check.OK(), "%v", check.Problems)
+		is.Equal(t, version, db.Stats().Version)
+		is.Equal(t, int64(len(keys)), check.Keys)
+		r := KVReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		for _, i := range keys {
+			_, ok := r.Get(key(i))
+			is.True(t, ok, i)
+		}
+	}
+	upper := func(extra ...int) []int {
+		keys := append([]int{}, extra...)
+		for i := range 1500 {
+			keys = append(keys, i)
+		}
+		return keys
+	}
+
+	// All of it, in one go or in steps.
+	base := restore()
+	rep, err := Recover(base, archive, RecoverTarget{})
+	is.NoError(t, err)
+	is.Equal(t, RecoverReport{Version: v3, Time: rep.Time, Commits: int(v3 - full.Version), Segments: 2}, rep)
+	is.True(t, rep.Time.After(mid))
+	recovered(base, v3, upper(5000, 6000)...)
+	_, err = os.Stat(base + ".recover")
+	is.ErrorIs(t, err, os.ErrNotExist)
+
+	base = restore()
+	rep, err = Recover(base, archive, RecoverTarget{Version: v1})
+	is.NoError(t, err)
+	is.Equal(t, 1, rep.Segments)
+	keys := upper(5000)
+	for i := 1500; i < 3000; i++ {
+		keys = append(keys, i)
+	}
+	recovered(base, v1, keys...)
+	rep, err = Recover(base, archive, RecoverTarget{Time: mid})
+	is.NoError(t, err)
+	is.Equal(t, v2, rep.Version)
+	is.False(t, rep.Time.After(mid))
+	recovered(base, v2, upper(5000)...)
+
+	// Targets the archive cannot reach leave the file as it was.
+	_, err = Recover(base, archive, RecoverTarget{Version: v3 + 1})
+	is.ErrorContains(t, err, "ends at version")
+	_, err = Recover(base, archive, RecoverTarget{Version: v1})
+	is.ErrorContains(t, err, "past")
+	recovered(base, v2, upper(5000)...)
+
+	// A compaction lays the pages out anew: the archive cannot be replayed
+	// across it.
+	db = &KV{Path: path, NoSync: true, WALArchive: archive}
+	is.NoError(t, db.Open())
+	_, err = db.Compact()
+	is.NoError(t, err)
+	commit(insert(7000))
+	db.Close()
+	base = restore()
+	_, err = Recover(base, archive, RecoverTarget{})
+	is.ErrorContains(t, err, "skips")
+	rep, err = Recover(base, archive, RecoverTarget{Version: v3})
+	is.NoError(t, err)
+	recovered(base, v3, upper(5000, 6000)...)
+}
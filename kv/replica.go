@@ -0,0 +1,301 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+)
+
+// Replication record layout. Integers are little-endian; the checksum is the
+// CRC-32 (IEEE) of every byte before it.
+//
+//	header | type | version | prev free head | prev pages | root | free head | pages | horizon | count |
+//	       |  1B  |    8B   |       8B       |     8B     |  8B  |     8B    |   8B  |    8B   |   4B  |
+//	page   | page number | data     |  (count times, in increasing page order)
+//	       |      8B     | PageSize |
+//	crc    |  4B  |
+//
+// A record holds the pages a commit or a tail truncation wrote and the state
+// it left, with the free-list head and page count it started from, which a
+// follower must hold to apply it. Readers of versions before horizon may
+// still see one of the pages it reuses.
+const recordHeader = 1 + 7*8 + 4
+
+const (
+	recordCommit   byte = 1
+	recordTruncate byte = 2
+)
+
+var (
+	// ErrReadOnly is returned by Commit and Compact on a ReadOnly store.
+	ErrReadOnly = errors.New("read-only store")
+	// ErrFeedLagging closes a Feed whose queue was full when a change came.
+	ErrFeedLagging = errors.New("replication feed fell behind")
+	// ErrFeedReset closes every Feed when Compact replaces the data file,
+	// whose pages followers can no longer follow.
+	ErrFeedReset = errors.New("data file replaced by Compact")
+	// ErrDiverged is returned (wrapped) by Apply for a record that does not
+	// follow the state of the store: a record was missed, or the store does
+	// not descend from the leader.
+	ErrDiverged = errors.New("replica diverged from its leader")
+)
+
+// Record is one change of a store, as delivered by a Feed and taken by
+// Apply on a follower.
+type Record struct {
+	Version uint64 // version the store holds after the change
+	Commit  bool   // a commit, rather than a tail truncation, which keeps the version
+	Data    []byte // encoded record
+}
+
+// Feed receives the changes of a store on C, in order, for replication.
+type Feed struct {
+	C       <-chan Record
+	Version uint64 // version of the store when the feed was opened; C holds every change after it
+
+	c   chan Record
+	err error // why C was closed; guarded by kv.feeds.mu
+	kv  *KV
+}
+
+// Feed opens a feed of the changes made from now on, with a queue of queue
+// records (0 means events.DefaultQueue). Changes are never dropped: a feed
+// whose queue is full when a change comes is closed with ErrFeedLagging, so
+// that its follower can start over from a backup. Compact closes every feed
+// with ErrFeedReset, and Close with os.ErrClosed.
+func (kv *KV) Feed(queue int) *Feed {
+	if queue <= 0 {
+		queue = events.DefaultQueue
+	}
+	c := make(chan Record, queue)
+	f := &Feed{C: c, c: c, kv: kv}
+	kv.commitMu.Lock()
+	defer kv.commitMu.Unlock()
+	f.Version = kv.version
+	kv.feeds.mu.Lock()
+	defer kv.feeds.mu.Unlock()
+	if kv.feeds.subs == nil {
+		kv.feeds.subs = map[*Feed]struct{}{}
+	}
+	kv.feeds.subs[f] = struct{}{}
+	return f
+}
+
+// Err returns why C was closed: nil while it is open or after Close.
+func (f *Feed) Err() error {
+	f.kv.feeds.mu.Lock()
+	defer f.kv.feeds.mu.Unlock()
+	return f.err
+}
+
+// Close closes C; records already queued can still be received. Calling
+// Close more than once is harmless.
+func (f *Feed) Close() {
+	f.kv.feeds.mu.Lock()
+	defer f.kv.feeds.mu.Unlock()
+	f.kv.closeFeed(f, nil)
+}
+
+// closeFeed closes f with err. It runs with feeds.mu held.
+func (kv *KV) closeFeed(f *Feed, err error) {
+	if _, ok := kv.feeds.subs[f]; ok {
+		delete(kv.feeds.subs, f)
+		f.err = err
+		close(f.c)
+	}
+}
+
+// closeFeeds closes every feed with err.
+func (kv *KV) closeFeeds(err error) {
+	kv.feeds.mu.Lock()
+	defer kv.feeds.mu.Unlock()
+	for f := range kv.feeds.subs {
+		kv.closeFeed(f, err)
+	}
+}
+
+// publishRecord hands the change tx made to the feeds. It runs under
+// commitMu, after the new state has been published.
+func (kv *KV) publishRecord(typ byte, tx *KVTX, prevFree, prevPages, horizon uint64) {
+	kv.feeds.mu.Lock()
+	defer kv.feeds.mu.Unlock()
+	if len(kv.feeds.subs) == 0 {
+		return
+	}
+	hdr := recordHeader
+	data := make([]byte, hdr, hdr+len(tx.page.updates)*(8+btree.PageSize)+4)
+	data[0] = typ
+	for i, v := range []uint64{kv.version, prevFree, prevPages, kv.tree.root, kv.free.Head, kv.page.flushed, horizon} {
+		binary.LittleEndian.PutUint64(data[1+8*i:], v)
+	}
+	count := 0
+	for _, ptr := range slices.Sorted(maps.Keys(tx.page.updates)) {
+		if page := tx.page.updates[ptr]; page != nil {
+			data = binary.LittleEndian.AppendUint64(data, ptr)
+			data = append(data, make([]byte, btree.PageSize)...)
+			copy(data[len(data)-btree.PageSize:], page)
+			count++
+		}
+	}
+	binary.LittleEndian.PutUint32(data[hdr-4:], uint32(count))
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	kv.sendRecord(Record{Version: kv.version, Commit: typ == recordCommit, Data: data})
+}
+
+// sendRecord queues rec on every feed. It runs with feeds.mu held.
+func (kv *KV) sendRecord(rec Record) {
+	for f := range kv.feeds.subs {
+		select {
+		case f.c <- rec:
+		default:
+			kv.closeFeed(f, ErrFeedLagging)
+		}
+	}
+}
+
+// record is a decoded replication record.
+type record struct {
+	typ                 byte
+	version             uint64
+	prevFree, prevPages uint64
+	root, free, npages  uint64
+	horizon             uint64
+	updates             map[uint64][]byte
+}
+
+func decodeRecord(data []byte) (record, error) {
+	if len(data) < recordHeader+4 ||
+		binary.LittleEndian.Uint32(data[len(data)-4:]) != crc32.ChecksumIEEE(data[:len(data)-4]) {
+		return record{}, errors.New("bad replication record")
+	}
+	var rec record
+	rec.typ = data[0]
+	fields := []*uint64{&rec.version, &rec.prevFree, &rec.prevPages, &rec.root, &rec.free, &rec.npages, &rec.horizon}
+	for i, p := range fields {
+		*p = binary.LittleEndian.Uint64(data[1+8*i:])
+	}
+	count := int(binary.LittleEndian.Uint32(data[recordHeader-4:]))
+	body := data[recordHeader : len(data)-4]
+	if rec.typ != recordCommit && rec.typ != recordTruncate || len(body) != count*(8+btree.PageSize) {
+		return record{}, errors.New("bad replication record")
+	}
+	rec.updates = make(map[uint64][]byte, count)
+	for ; len(body) > 0; body = body[8+btree.PageSize:] {
+		ptr := binary.LittleEndian.Uint64(body)
+		if ptr == 0 || ptr >= rec.npages {
+			return record{}, fmt.Errorf("bad replication record: page %d outside the file (%d pages)", ptr, rec.npages)
+		}
+		rec.updates[ptr] = body[8 : 8+btree.PageSize]
+	}
+	return rec, nil
+}
+
+// Apply lays a record of the leader's Feed over this ReadOnly store, making
+// it durable through the WAL like a commit. Records must be applied in the
+// order of the feed; those the store already holds are skipped, and one
+// that does not follow its state fails with ErrDiverged. Before it
+// overwrites a page that an older snapshot may still read, Apply waits for
+// the read transactions that could see it to end, and it waits for every
+// write transaction, which may read any page. Applied records are passed on
+// to the store's own feeds, so that followers can be chained.
+func (kv *KV) Apply(data []byte) error {
+	rec, err := decodeRecord(data)
+	if err != nil {
+		return err
+	}
+	kv.commitMu.Lock()
+	defer kv.commitMu.Unlock()
+	if !kv.ReadOnly {
+		return errors.New("apply: the store is not ReadOnly")
+	}
+	if kv.failed != nil {
+		return fmt.Errorf("%w: %v", ErrNeedsReopen, kv.failed)
+	}
+	commit := rec.typ == recordCommit
+	switch {
+	case commit && rec.version <= kv.version, !commit && rec.version < kv.version:
+		return nil // already applied
+	case !commit && rec.version == kv.version && kv.free.Head == rec.free && kv.page.flushed == rec.npages:
+		return nil
+	case commit && rec.version != kv.version+1, !commit && rec.version != kv.version:
+		return fmt.Errorf("%w: version %d does not follow %d", ErrDiverged, rec.version, kv.version)
+	case kv.free.Head != rec.prevFree || kv.page.flushed != rec.prevPages:
+		return fmt.Errorf("%w: version %d does not start from this free list", ErrDiverged, rec.version)
+	}
+
+	kv.awaitReaders(rec.horizon)
+	kv.txGate.Lock()
+	defer kv.txGate.Unlock()
+	npages := max(rec.npages, kv.page.flushed)
+	if err := kv.retryIO("extend file", func() error { return extendFile(kv, int(npages)) }); err != nil {
+		return err
+	}
+	if err := extendMmap(kv, int(npages)); err != nil {
+		return err
+	}
+	tx := KVTX{}
+	tx.page.updates = rec.updates
+	if err := kv.reserveUpdates(&tx); err != nil {
+		return err
+	}
+	kv.mmapMu.Lock()
+	for ptr, page := range rec.updates {
+		copy(pageGetMapped(kv.mmap.chunks, ptr).Data, page)
+	}
+	kv.mmapMu.Unlock()
+	if err := kv.walWrite(&tx, commitState{
+		Root:        rec.root,
+		FreeHead:    rec.free,
+		PageFlushed: rec.npages,
+		Version:     rec.version,
+	}); err != nil {
+		return err
+	}
+
+	// The file keeps the pages a truncation cut off: older snapshots may
+	// still be reading them.
+	summary := kv.buildSummary(rec.root)
+	kv.page.flushed = rec.npages
+	kv.pageAllocMu.Lock()
+	kv.pageAlloc = rec.npages
+	kv.pageAllocMu.Unlock()
+	kv.mu.Lock()
+	kv.free = btree.FreeListData{Head: rec.free}
+	kv.tree.root = rec.root
+	kv.summary = summary
+	kv.version = rec.version
+	kv.mu.Unlock()
+	if commit {
+		kv.stampPages(&tx, rec.version)
+		kv.events.Publish(events.Event{Kind: events.Commit, Version: rec.version})
+	} else {
+		kv.stampPages(&tx, rec.version+1)
+	}
+	if err := kv.retryIO("master store", func() error { return masterStore(kv) }); err != nil {
+		return fmt.Errorf("apply master store: %w", err)
+	}
+	kv.feeds.mu.Lock()
+	kv.sendRecord(Record{Version: rec.version, Commit: commit, Data: data})
+	kv.feeds.mu.Unlock()
+	return nil
+}
+
+// awaitReaders waits until no read transaction is older than version.
+func (kv *KV) awaitReaders(version uint64) {
+	for {
+		kv.mu.Lock()
+		done := len(kv.readers) == 0 || kv.readers[0].version >= version
+		kv.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,176 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestFeedApply(t *testing.T) {
+	dir := t.TempDir()
+	leader := &KV{Path: filepath.Join(dir, "leader.db"), NoSync: true}
+	is.NoError(t, leader.Open())
+	defer leader.Close()
+	key := func(prefix string, i int) []byte { return fmt.Appendf(nil, "%s%05d", prefix, i) }
+	write := func(f func(tx *KVTX)) {
+		tx := KVTX{}
+		leader.Begin(&tx)
+		f(&tx)
+		is.NoError(t, leader.Commit(&tx))
+	}
+	insert := func(prefix string, n int, val []byte) {
+		write(func(tx *KVTX) {
+			for i := range n {
+				tx.Update(&btree.InsertReq{Key: key(prefix, i), Val: val})
+			}
+		})
+	}
+	insert("a", 2000, make([]byte, 200))
+	insert("z", 100, []byte("z")) // keeps the freed pages away from the end
+
+	// The follower starts from a backup taken after the feed was opened.
+	feed := leader.Feed(1000)
+	defer feed.Close()
+	insert("b", 10, []byte("b")) // in the backup and in the feed
+	var buf bytes.Buffer
+	_, err := leader.Backup(&buf)
+	is.NoError(t, err)
+	path := filepath.Join(dir, "follower.db")
+	is.NoError(t, RestoreFrom(&buf, path))
+	follower := &KV{Path: path, NoSync: true, ReadOnly: true}
+	is.NoError(t, follower.Open())
+	defer func() { follower.Close() }()
+	chained := follower.Feed(1000)
+	defer chained.Close()
+	var applied []Record
+	catchUp := func() {
+		t.Helper()
+		for len(feed.C) > 0 {
+			rec := <-feed.C
+			is.NoError(t, follower.Apply(rec.Data))
+			applied = append(applied, rec)
+		}
+	}
+	same := func() {
+		t.Helper()
+		catchUp()
+		check := follower.Check()
+		is.True(t, check.OK(), "%v", check.Problems)
+		is.Equal(t, leader.Stats().Version, follower.Stats().Version)
+		is.Equal(t, leader.FreeStats(), follower.FreeStats())
+		is.Equal(t, leader.Check().Keys, check.Keys)
+		a, b := KVReader{}, KVReader{}
+		leader.BeginRead(&a)
+		follower.BeginRead(&b)
+		defer leader.EndRead(&a)
+		defer follower.EndRead(&b)
+		for _, prefix := range []string{"a", "b", "c", "z"} {
+			for i := range 2000 {
+				va, oka := a.Get(key(prefix, i))
+				vb, okb := b.Get(key(prefix, i))
+				is.True(t, oka == okb && bytes.Equal(va, vb), "%s%05d", prefix, i)
+			}
+		}
+	}
+	same()
+
+	// Followers take no writes of their own.
+	tx := KVTX{}
+	follower.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("x"), Val: []byte("x")})
+	is.ErrorIs(t, follower.Commit(&tx), ErrReadOnly)
+	_, err = follower.Compact()
+	is.ErrorIs(t, err, ErrReadOnly)
+
+	// Deleting frees pages in the middle of the file, and the next commit
+	// reuses them: the follower waits for its older snapshot to end first.
+	r := KVReader{}
+	follower.BeginRead(&r)
+	write(func(tx *KVTX) {
+		for i := range 2000 {
+			tx.Del(&btree.DeleteReq{Key: key("a", i)})
+		}
+	})
+	insert("c", 2000, bytes.Repeat([]byte{'c'}, 200))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for len(feed.C) > 0 {
+			rec := <-feed.C
+			is.NoError(t, follower.Apply(rec.Data))
+			applied = append(applied, rec)
+		}
+	}()
+	select {
+	case <-done:
+		t.Fatal("Apply overwrote pages an open snapshot can see")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i := range 2000 {
+		val, ok := r.Get(key("a", i))
+		is.True(t, ok && len(val) == 200, i)
+	}
+	follower.EndRead(&r)
+	<-done
+	same()
+
+	// Deleting the upper keys cuts pages off the end of the file.
+	write(func(tx *KVTX) {
+		for i := range 100 {
+			tx.Del(&btree.DeleteReq{Key: key("z", i)})
+		}
+		for i := range 2000 {
+			tx.Del(&btree.DeleteReq{Key: key("c", i)})
+		}
+	})
+	is.NotZero(t, leader.Metrics().PagesTruncated)
+	same()
+	is.True(t, slices.ContainsFunc(applied, func(rec Record) bool { return !rec.Commit }))
+
+	// Records already applied are skipped; one out of step is refused.
+	for _, rec := range applied {
+		is.NoError(t, follower.Apply(rec.Data))
+	}
+	insert("d", 1, []byte("d"))
+	insert("e", 1, []byte("e"))
+	<-feed.C
+	rec := <-feed.C
+	is.ErrorIs(t, follower.Apply(rec.Data), ErrDiverged)
+	bad := bytes.Clone(rec.Data)
+	bad[recordHeader+100] ^= 1
+	is.ErrorContains(t, follower.Apply(bad), "bad replication record")
+
+	// The follower passes on what it applies; the first record was in the
+	// backup.
+	is.Len(t, chained.C, len(applied)-1)
+	for _, want := range applied[1:] {
+		is.Equal(t, want, <-chained.C)
+	}
+
+	// A full queue or a compaction ends a feed; so does Close.
+	small := leader.Feed(1)
+	insert("f", 1, nil)
+	insert("g", 1, nil)
+	<-small.C
+	_, ok := <-small.C
+	is.False(t, ok)
+	is.ErrorIs(t, small.Err(), ErrFeedLagging)
+	_, err = leader.Compact()
+	is.NoError(t, err)
+	for range feed.C {
+	}
+	is.ErrorIs(t, feed.Err(), ErrFeedReset)
+	follower.Close()
+	for range chained.C {
+	}
+	is.ErrorIs(t, chained.Err(), os.ErrClosed)
+	follower = &KV{Path: path, NoSync: true, ReadOnly: true}
+	is.NoError(t, follower.Open())
+}
@@ -0,0 +1,49 @@
+package kv
+
+import (
+	"slices"
+)
+
+// defaultMmapReserve is the address space reserved for the mapping of the
+// data file to grow into when KV.MmapMax is 0.
+const defaultMmapReserve = 64 << 30
+
+// mmapReserve returns how much address space to reserve for a mapping whose
+// first chunk is size bytes.
+func (kv *KV) mmapReserve(size int) int {
+	if kv.MmapMax > 0 {
+		return max(size, kv.mmapMax())
+	}
+	return max(size, defaultMmapReserve)
+}
+
+// growReserved grows the first chunk, the only one, by size bytes into the
+// reserved address space after it, if there is room for at least need of
+// them. It returns the grown chunk and the bytes added, or nil.
+func (kv *KV) growReserved(size, need int) ([]byte, []byte) {
+	r := kv.mmap.reserved
+	if r == nil || len(kv.mmap.chunks) != 1 {
+		return nil, nil
+	}
+	room := len(r) - kv.mmap.total
+	if room < need {
+		return nil, nil
+	}
+	mem, err := mapFixed(kv.fp, r[kv.mmap.total:], int64(kv.mmap.total), min(size, room))
+	if err != nil {
+		kv.log().Warn("kv: growing the mapping in place failed", "path", kv.Path, "err", err)
+		return nil, nil
+	}
+	end := kv.mmap.total + len(mem)
+	return r[:end:end], mem
+}
+
+// regions returns the regions that unmap the mapping of the data file: the
+// reservation in place of the first chunk, which lies at its start.
+func (kv *KV) regions() [][]byte {
+	regions := slices.Clone(kv.mmap.chunks)
+	if kv.mmap.reserved != nil {
+		regions[0] = kv.mmap.reserved
+	}
+	return regions
+}
@@ -6,11 +6,14 @@ import "github.com/MHS-20/ElkDB/btree"
 // Both *KVReader and *KVTX satisfy this interface.
 // The table package depends only on this interface, never on the concrete types.
 type Reader interface {
-	// Get returns the value stored under key, or (nil, false) if absent.
+	// Get returns a copy of the value stored under key, or (nil, false) if
+	// absent.
 	Get(key []byte) ([]byte, bool)
 	// Seek positions a B-tree iterator at the key nearest to key satisfying cmp.
 	// cmp must be one of btree.CmpGE, CmpGT, CmpLT, CmpLE.
 	Seek(key []byte, cmp int) *btree.BIter
+	// EstimateKeys estimates the number of keys in [start, end).
+	EstimateKeys(start, end []byte) int64
 }
 
 // Writer is the read-write surface of a KV transaction.
@@ -0,0 +1,182 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// salvageBatch is the number of keys Salvage writes per transaction.
+const salvageBatch = 1000
+
+// SalvageReport describes what Salvage recovered.
+type SalvageReport struct {
+	Pages     uint64 // pages scanned
+	Master    bool   // the master page was intact
+	WALPages  int    // committed page images taken from the WAL
+	Reachable int    // keys found in leaves reachable from the root
+	Orphans   int    // keys found only in leaves no intact node points to
+	Conflicts int    // orphan keys found with different values; the first was kept
+	Keys      int    // keys written to the new database
+
+	// Check holds the problems met while walking the tree and free list.
+	Check btree.CheckReport
+}
+
+// Salvage copies whatever can be read from the damaged database at path
+// into dst, an open and empty KV, for when the master page or internal
+// nodes are damaged and Open or Check fail.
+//
+// Committed transactions still in the WAL are applied in memory first. The
+// tree is then followed from the root given by the WAL or the master page
+// as far as it is intact, and every page that it and the free list do not
+// account for is scanned for intact leaves (see btree.Salvage). Keys from
+// reachable leaves win over those from orphan leaves. An orphan leaf may
+// be stale when the free list is damaged too, so deleted keys can come
+// back. The source files are only read.
+func Salvage(path string, dst *KV) (SalvageReport, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return SalvageReport{}, err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return SalvageReport{}, err
+	}
+	store := &salvageStore{fp: fp}
+	rep := SalvageReport{Pages: uint64(fi.Size()) / btree.PageSize}
+
+	// The master page is trusted only if it passes the checks of Open.
+	// Without it, the limits that decode the entries of long keys are the
+	// defaults.
+	var root, free uint64
+	var tree btree.BTree
+	master := make([]byte, masterSize)
+	if _, err := fp.ReadAt(master, 0); err == nil && bytes.HasPrefix(master, []byte(dbSig)) {
+		r := binary.LittleEndian.Uint64(master[16:])
+		used := binary.LittleEndian.Uint64(master[24:])
+		f := binary.LittleEndian.Uint64(master[32:])
+		maxKey, maxVal := masterLimits(master)
+		if 1 <= used && used <= rep.Pages && r < used && f < used && btree.CheckLimits(maxKey, maxVal) == nil {
+			rep.Master = true
+			root, free = r, f
+			tree.MaxKey, tree.MaxVal = maxKey, maxVal
+		}
+	}
+	state, err := store.loadWAL(path + ".wal")
+	if err != nil {
+		return SalvageReport{}, err
+	}
+	if state != nil {
+		root, free = state.Root, state.FreeHead
+		rep.Pages = max(rep.Pages, state.PageFlushed)
+	}
+	rep.WALPages = len(store.wal)
+
+	found := map[string][]byte{}
+	orphan := map[string]bool{}
+	rep.Check = btree.Salvage(store, root, free, rep.Pages, func(keys, vals [][]byte, reachable bool) {
+		for i, skey := range keys {
+			key, val, ok := tree.DecodeEntry(skey, vals[i])
+			if len(key) == 0 || !ok {
+				continue // the empty key of an older format, or a damaged entry
+			}
+			k := string(key)
+			old, seen := found[k]
+			switch {
+			case !seen:
+				found[k] = val
+				orphan[k] = !reachable
+			case orphan[k] && reachable:
+				found[k] = val
+				orphan[k] = false
+			case orphan[k] && !reachable && !bytes.Equal(old, val):
+				rep.Conflicts++
+			}
+		}
+	})
+	if store.err != nil {
+		return SalvageReport{}, fmt.Errorf("salvage: %w", store.err)
+	}
+
+	keys := slices.Sorted(maps.Keys(found))
+	for _, k := range keys {
+		if orphan[k] {
+			rep.Orphans++
+		} else {
+			rep.Reachable++
+		}
+	}
+	for len(keys) > 0 {
+		n := min(len(keys), salvageBatch)
+		tx := KVTX{}
+		dst.Begin(&tx)
+		for _, k := range keys[:n] {
+			tx.Update(&btree.InsertReq{Key: []byte(k), Val: found[k]})
+		}
+		if err := dst.Commit(&tx); err != nil {
+			return rep, fmt.Errorf("salvage: %w", err)
+		}
+		rep.Keys += n
+		keys = keys[n:]
+	}
+	return rep, nil
+}
+
+// salvageStore reads the pages of a database file that may not open, with
+// the committed page images of its WAL laid over them. Read errors are kept
+// in err and the page reads as zeros, which no check accepts.
+type salvageStore struct {
+	fp  *os.File
+	wal map[uint64][]byte
+	err error
+}
+
+func (s *salvageStore) PageGet(ptr uint64) btree.BNode {
+	if data, ok := s.wal[ptr]; ok {
+		return btree.BNode{Data: data}
+	}
+	data := make([]byte, btree.PageSize)
+	if _, err := s.fp.ReadAt(data, int64(ptr)*btree.PageSize); err != nil && !errors.Is(err, io.EOF) && s.err == nil {
+		s.err = err
+	}
+	return btree.BNode{Data: data}
+}
+
+func (s *salvageStore) PageNew(btree.BNode) uint64 { panic("read-only store") }
+func (s *salvageStore) PageDel(uint64)             { panic("read-only store") }
+
+// loadWAL reads the committed transactions of the WAL at path, if there is
+// one with a valid header, and returns the state of the last of them.
+func (s *salvageStore) loadWAL(path string) (*commitState, error) {
+	fp, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	header := make([]byte, 16)
+	if _, err := fp.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, []byte(walSig)) {
+		return nil, nil // empty or damaged: nothing to take from it
+	}
+	wal := &WAL{fp: fp, path: path}
+	entries, state, err := wal.readCommitted()
+	if err != nil {
+		return nil, err
+	}
+	s.wal = map[uint64][]byte{}
+	for _, e := range entries {
+		s.wal[e.pageNum] = e.data
+	}
+	return state, nil
+}
@@ -0,0 +1,122 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+// salvageInto salvages the database at path into a new file and returns
+// the report and the recovered keys.
+func salvageInto(t *testing.T, path string) (SalvageReport, map[string]string) {
+	dst := &KV{Path: filepath.Join(t.TempDir(), "out.db"), NoSync: true}
+	is.NoError(t, dst.Open())
+	defer dst.Close()
+	rep, err := Salvage(path, dst)
+	is.NoError(t, err)
+	is.True(t, dst.Check().OK())
+
+	got := map[string]string{}
+	r := KVReader{}
+	dst.BeginRead(&r)
+	defer dst.EndRead(&r)
+	for it := r.Seek([]byte{1}, btree.CmpGE); it.Valid(); it.Next() {
+		k, v := it.Deref()
+		got[string(k)] = string(v)
+	}
+	return rep, got
+}
+
+func TestSalvage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "damaged.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	want := map[string]string{}
+	for i := range 3000 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		k := fmt.Sprintf("k%05d", i)
+		tx.Update(&btree.InsertReq{Key: []byte(k), Val: []byte(fmt.Sprint(i))})
+		want[k] = fmt.Sprint(i)
+		if i%4 == 3 {
+			k := fmt.Sprintf("k%05d", i-2)
+			tx.Del(&btree.DeleteReq{Key: []byte(k)})
+			delete(want, k)
+		}
+		if i%5 == 4 {
+			k := fmt.Sprintf("k%05d", i-4)
+			tx.Update(&btree.InsertReq{Key: []byte(k), Val: []byte("updated")})
+			want[k] = "updated"
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	db.Close()
+	data, err := os.ReadFile(path)
+	is.NoError(t, err)
+
+	// An intact file is copied as is.
+	rep, got := salvageInto(t, path)
+	is.True(t, rep.Master)
+	is.Equal(t, want, got)
+	is.Equal(t, len(want), rep.Reachable)
+	is.Zero(t, rep.Orphans)
+
+	// The root is lost: its leaves are found by scanning, and the free list
+	// keeps the stale copies of them out.
+	r := binary.LittleEndian.Uint64(data[16:])
+	bad := append([]byte(nil), data...)
+	clear(bad[r*btree.PageSize:][:btree.PageSize])
+	is.NoError(t, os.WriteFile(path, bad, 0o644))
+	rep, got = salvageInto(t, path)
+	is.False(t, rep.Check.OK())
+	is.Equal(t, want, got)
+	is.Equal(t, len(want), rep.Orphans)
+	is.Zero(t, rep.Conflicts)
+	is.Equal(t, len(want), rep.Keys)
+}
+
+func TestSalvageNoMaster(t *testing.T) {
+	// Without the master page nothing tells current and stale leaves
+	// apart, but with inserts only the stale leaves hold nothing new.
+	path := filepath.Join(t.TempDir(), "damaged.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	want := map[string]string{}
+	for i := range 1000 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		k := fmt.Sprintf("k%05d", i*7919%1000)
+		tx.Update(&btree.InsertReq{Key: []byte(k), Val: []byte(k)})
+		want[k] = k
+		is.NoError(t, db.Commit(&tx))
+	}
+
+	// Crash: the last commits are still in the WAL. Copy the files while
+	// the database is open.
+	for _, suffix := range []string{"", ".wal"} {
+		data, err := os.ReadFile(path + suffix)
+		is.NoError(t, err)
+		if suffix == "" {
+			clear(data[:btree.PageSize])
+		}
+		is.NoError(t, os.WriteFile(path+".crash"+suffix, data, 0o644))
+	}
+	db.Close()
+
+	rep, got := salvageInto(t, path+".crash")
+	is.False(t, rep.Master)
+	is.Positive(t, rep.WALPages)
+	is.Equal(t, want, got)
+
+	os.Remove(path + ".crash.wal")
+	rep, got = salvageInto(t, path+".crash")
+	is.Zero(t, rep.WALPages)
+	is.Equal(t, want, got)
+	is.Equal(t, len(want), rep.Orphans)
+	is.Zero(t, rep.Conflicts)
+}
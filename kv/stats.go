@@ -0,0 +1,96 @@
+package kv
+
+import (
+	"io"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// Stats describes the size and shape of the store at the latest commit.
+type Stats struct {
+	Version    uint64
+	FileSize   int64  // bytes, including space preallocated for growth
+	Pages      uint64 // pages in use, free ones included
+	FreePages  int    // pages on the free list
+	TreeHeight int    // levels of the B-tree
+}
+
+// Stats returns the current Stats. It waits for a commit in progress.
+func (kv *KV) Stats() Stats {
+	kv.commitMu.Lock()
+	defer kv.commitMu.Unlock()
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	return Stats{
+		Version:    r.version,
+		FileSize:   int64(kv.mmap.file),
+		Pages:      kv.page.flushed,
+		FreePages:  btree.FreeListTotal(&r, kv.free.Head),
+		TreeHeight: r.tree.Height(),
+	}
+}
+
+// EstimateRange estimates the number and size of the keys in [start, end) of
+// the snapshot by sampling up to samples leaves (see
+// btree.Summary.EstimateRange). A nil end means no upper bound. Without
+// KV.IndexSummary the leaf summary is built from the internal nodes on the
+// first call and kept for the rest of the transaction.
+func (tx *KVReader) EstimateRange(start, end []byte, samples int) btree.RangeEstimate {
+	if tx.summary == nil {
+		tx.summary = tx.tree.Summary()
+	}
+	return tx.summary.EstimateRange(tx, start, end, samples)
+}
+
+// EstimateKeys estimates the number of keys in [start, end) of the snapshot
+// from the paths to start and end, reading at most two pages per level (see
+// btree.BTree.EstimateKeys). A nil end means no upper bound.
+func (tx *KVReader) EstimateKeys(start, end []byte) int64 {
+	return tx.tree.EstimateKeys(start, end)
+}
+
+// Check verifies the integrity of the committed tree and free list (see
+// btree.Check), like SQLite's PRAGMA integrity_check. Commits wait until it
+// is done, because they rewrite free-list nodes in place.
+func (kv *KV) Check() btree.CheckReport {
+	kv.commitMu.Lock()
+	defer kv.commitMu.Unlock()
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	return btree.Check(&r, r.tree.Root, kv.free.Head, kv.page.flushed)
+}
+
+// TreeStats reports the shape of the committed tree: node counts, fill per
+// level and byte utilization (see btree.BTree.Stats). It reads every page of
+// the tree, from a snapshot: commits go on meanwhile.
+func (kv *KV) TreeStats() btree.TreeStats {
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	return r.tree.Stats()
+}
+
+// DumpDOT writes the committed tree as a Graphviz graph (see
+// btree.BTree.DumpDOT), from a snapshot.
+func (kv *KV) DumpDOT(w io.Writer) error {
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	return r.tree.DumpDOT(w)
+}
+
+// FreeStats reports how much of the file is free, to help decide when to
+// compact it. Commits wait until it is done.
+func (kv *KV) FreeStats() btree.FreeStats {
+	kv.commitMu.Lock()
+	defer kv.commitMu.Unlock()
+	r := KVReader{}
+	kv.BeginRead(&r)
+	defer kv.EndRead(&r)
+	kv.mu.Lock()
+	minReader := kv.readers[0].version
+	kv.mu.Unlock()
+	return btree.FreeListStats(&r, kv.free.Head, kv.page.flushed, minReader)
+}
@@ -0,0 +1,229 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "stats.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	st := db.Stats()
+	is.Zero(t, st.TreeHeight)
+	is.Zero(t, st.FreePages)
+
+	for i := range 2000 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%04d", i), Val: make([]byte, 100)})
+		if i%2 == 1 {
+			tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "k%04d", i-1)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	st = db.Stats()
+	is.Equal(t, uint64(2000), st.Version)
+	is.Equal(t, 2, st.TreeHeight)
+	is.Positive(t, st.FreePages)
+	is.Less(t, st.FreePages, int(st.Pages))
+	is.GreaterOrEqual(t, st.FileSize, int64(st.Pages)*btree.PageSize)
+
+	r := KVReader{}
+	db.BeginRead(&r)
+	defer db.EndRead(&r)
+	tree := db.TreeStats()
+	is.Equal(t, st.TreeHeight, tree.Height)
+	is.Equal(t, int64(1000), tree.Keys)
+	is.Equal(t, r.tree.Height(), len(tree.Levels))
+	is.Less(t, tree.Pages(), int(st.Pages)-st.FreePages)
+	var dot strings.Builder
+	is.NoError(t, db.DumpDOT(&dot))
+	is.Equal(t, tree.Pages(), strings.Count(dot.String(), "[label="))
+	est := r.EstimateRange([]byte("k"), []byte("l"), 1000)
+	is.True(t, est.Exact)
+	is.Equal(t, int64(1000), est.Keys)
+	is.Equal(t, int64(1000*(5+100)), est.Bytes)
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "check.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	is.True(t, db.Check().OK())
+
+	// A reader from the start keeps every freed page on the free list.
+	old := KVReader{}
+	db.BeginRead(&old)
+	for i := range 500 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%04d", i), Val: make([]byte, 50)})
+		if i%3 == 2 {
+			tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "k%04d", i-1)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	rep := db.Check()
+	is.True(t, rep.OK(), "%v", rep.Problems)
+	is.Equal(t, int64(500-500/3), rep.Keys)
+	is.Equal(t, 2, rep.Height)
+	is.Positive(t, rep.FreeListNodes)
+	is.Equal(t, rep.Pages, uint64(1+rep.TreePages+rep.FreeListNodes+rep.FreePages))
+	db.EndRead(&old)
+
+	// Now the free pages are recycled.
+	for i := range 50 {
+		tx := KVTX{}
+		db.Begin(&tx)
+		tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "k%04d", 3*i)})
+		is.NoError(t, db.Commit(&tx))
+	}
+	rep = db.Check()
+	is.True(t, rep.OK(), "%v", rep.Problems)
+	is.Equal(t, rep.Pages, uint64(1+rep.TreePages+rep.FreeListNodes+rep.FreePages))
+	db.Close()
+
+	data, err := os.ReadFile(path)
+	is.NoError(t, err)
+	root := binary.LittleEndian.Uint64(data[16:])
+	used := binary.LittleEndian.Uint64(data[24:])
+	page := func(ptr uint64) []byte { return data[ptr*btree.PageSize:][:btree.PageSize] }
+	check := func(data []byte) btree.CheckReport {
+		is.NoError(t, os.WriteFile(path, data, 0o644))
+		db := &KV{Path: path, NoSync: true}
+		is.NoError(t, db.Open())
+		defer db.Close()
+		return db.Check()
+	}
+
+	// The root points to its first child twice: the second child leaks.
+	bad := bytes.Clone(data)
+	rootNode := page(root)
+	copy(bad[root*btree.PageSize+4+8:], rootNode[4:12])
+	rep = check(bad)
+	is.False(t, rep.OK())
+	is.Contains(t, fmt.Sprint(rep.Problems), "referenced twice: as a tree node and as a tree node")
+	is.Positive(t, rep.Leaked)
+
+	// A page beyond the tree and free list is a leak.
+	bad = append(bytes.Clone(data), make([]byte, btree.PageSize)...)
+	binary.LittleEndian.PutUint64(bad[24:], used+1)
+	rep = check(bad)
+	is.Equal(t, 1, rep.Leaked)
+	is.Equal(t, []btree.Problem{{Page: used, Msg: "leaked: not in the tree nor on the free list"}}, rep.Problems)
+
+	// A leaf whose offsets run past the end of the page.
+	bad = bytes.Clone(data)
+	leaf := binary.LittleEndian.Uint64(rootNode[4:])
+	nkeys := int(binary.LittleEndian.Uint16(page(leaf)[2:]))
+	binary.LittleEndian.PutUint16(bad[int(leaf)*btree.PageSize+4+8*nkeys+2*(nkeys-1):], 0xffff)
+	rep = check(bad)
+	is.Len(t, rep.Problems, 1)
+	is.Contains(t, rep.Problems[0].String(), fmt.Sprintf("page %d: bad offset 65535", leaf))
+
+	is.True(t, check(data).OK())
+}
+
+func TestFreeStats(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "free.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.Equal(t, btree.FreeStats{Pages: db.Stats().Pages}, db.FreeStats())
+
+	insert := func(val byte) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range 1000 {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%04d", i), Val: bytes.Repeat([]byte{val}, 100)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	insert('a')
+
+	// Pages freed while a reader is open are not reusable until it ends.
+	r := KVReader{}
+	db.BeginRead(&r)
+	insert('b')
+	st := db.FreeStats()
+	is.Positive(t, st.FreePages)
+	is.Positive(t, st.Nodes)
+	is.Zero(t, st.Reusable)
+	is.Equal(t, db.Stats().FreePages, st.FreePages)
+	db.EndRead(&r)
+	st = db.FreeStats()
+	is.Equal(t, st.FreePages, st.Reusable)
+
+	// Deleting everything frees the whole tree, the last pages included;
+	// once no snapshot can see them, no run long enough to cut off the file
+	// is left.
+	db.BeginRead(&r)
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 1000 {
+		tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "k%04d", i)})
+	}
+	is.NoError(t, db.Commit(&tx))
+	st = db.FreeStats()
+	is.Positive(t, st.TailPages)
+	is.LessOrEqual(t, st.TailPages, st.FreePages)
+	is.Equal(t, db.Stats().Pages, st.Pages)
+	db.EndRead(&r)
+	insert('c')
+	is.Less(t, db.FreeStats().TailPages, truncateMin)
+}
+
+func TestFreeListTotalPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "total.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	for _, val := range []byte("ab") {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range 1000 {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%04d", i), Val: bytes.Repeat([]byte{val}, 100)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	// Empty the tree under a reader, so the freed pages stay in the list
+	// rather than being cut off the end of the file.
+	r := KVReader{}
+	db.BeginRead(&r)
+	tx := KVTX{}
+	db.Begin(&tx)
+	for i := range 1000 {
+		tx.Del(&btree.DeleteReq{Key: fmt.Appendf(nil, "k%04d", i)})
+	}
+	is.NoError(t, db.Commit(&tx))
+	db.EndRead(&r)
+	db.Close()
+
+	// A commit to the empty tree takes a page from the list and frees none,
+	// so only the total in the head node changes. The head was read from
+	// the file, not written by the transaction: the new total must still
+	// reach it.
+	db = &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	tx = KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("k"), Val: []byte("v")})
+	is.NoError(t, db.Commit(&tx))
+	free := db.FreeStats().FreePages
+	db.Close()
+
+	db = &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, free, check.FreePages)
+	is.Equal(t, free, db.FreeStats().FreePages)
+}
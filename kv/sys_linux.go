@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The system calls of the pager that differ between Linux and the other
+// Unix systems; see sys_other.go.
+
+// rlimitMemlock is RLIMIT_MEMLOCK, missing from package syscall.
+const rlimitMemlock = 8
+
+func fallocate(fd int, mode uint32, off, size int64) error {
+	return syscall.Fallocate(fd, mode, off, size)
+}
+
+func madvise(mem []byte, advice int) error {
+	return syscall.Madvise(mem, advice)
+}
+
+func adviseHugePages(mem []byte) error {
+	return syscall.Madvise(mem, syscall.MADV_HUGEPAGE)
+}
+
+func mlock(mem []byte) error {
+	return syscall.Mlock(mem)
+}
+
+func munlock(mem []byte) error {
+	return syscall.Munlock(mem)
+}
+
+// openDirect opens path for writing with O_DIRECT, which bypasses the page
+// cache.
+func openDirect(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_DIRECT, 0o644)
+}
+
+// reserveAddress reserves size bytes of address space, with no access and no
+// memory behind it, for the mapping of the data file to grow into as one
+// region. The kernel hands out addresses from the top down, so the space
+// right after a mapping is usually taken and it could not grow in place
+// otherwise. It returns nil if the kernel refuses.
+func reserveAddress(size int) []byte {
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_NONE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|syscall.MAP_NORESERVE)
+	if err != nil {
+		return nil
+	}
+	return mem
+}
+
+// mapFixed maps size bytes of fp, from offset off, over the start of free,
+// a reserved range, and returns them.
+func mapFixed(fp *os.File, free []byte, off int64, size int) ([]byte, error) {
+	assert(size <= len(free))
+	_, _, errno := syscall.Syscall6(syscall.SYS_MMAP, uintptr(unsafe.Pointer(&free[0])), uintptr(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_FIXED, fp.Fd(), uintptr(off))
+	if errno != 0 {
+		return nil, fmt.Errorf("mmap: %w", errno)
+	}
+	return free[:size:size], nil
+}
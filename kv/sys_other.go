@@ -0,0 +1,62 @@
+//go:build unix && !linux
+
+package kv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The system calls of the pager on Unix systems other than Linux: macOS and
+// the BSDs. What they lack is reported as unsupported, and the store goes on
+// without it: files grow by writing zeros, and hole punching, huge pages,
+// O_DIRECT, io_uring and the address-space reservation are off.
+
+// rlimitMemlock is RLIMIT_MEMLOCK, missing from package syscall.
+const rlimitMemlock = 6
+
+func fallocate(fd int, mode uint32, off, size int64) error {
+	return syscall.EOPNOTSUPP
+}
+
+func madvise(mem []byte, advice int) error {
+	return memSyscall(syscall.SYS_MADVISE, mem, uintptr(advice))
+}
+
+func adviseHugePages(mem []byte) error {
+	return errors.ErrUnsupported
+}
+
+func mlock(mem []byte) error {
+	return memSyscall(syscall.SYS_MLOCK, mem, 0)
+}
+
+func munlock(mem []byte) error {
+	return memSyscall(syscall.SYS_MUNLOCK, mem, 0)
+}
+
+// memSyscall makes the system call trap on the address range of mem.
+func memSyscall(trap uintptr, mem []byte, arg uintptr) error {
+	if len(mem) == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(&mem[0])), uintptr(len(mem)), arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func openDirect(path string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func reserveAddress(size int) []byte {
+	return nil
+}
+
+func mapFixed(fp *os.File, free []byte, off int64, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,70 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Commit timeouts
+// ---------------------------------------------------------------------------
+
+// ErrTimeout is returned (wrapped) by Commit when it waited longer than
+// KV.WriteStall for the commit lock, or longer than KV.CommitTimeout for
+// the WAL. In the first case nothing was written and the transaction may
+// be retried. In the second its outcome is unknown: see CommitTimeout.
+var ErrTimeout = errors.New("kv: operation timed out")
+
+// lockCommit takes commitMu, giving up with ErrTimeout after KV.WriteStall.
+// A sync.Mutex cannot be waited on with a deadline, so it polls, backing
+// off up to a millisecond between attempts.
+func (kv *KV) lockCommit() error {
+	if kv.WriteStall <= 0 {
+		kv.commitMu.Lock()
+		return nil
+	}
+	deadline := time.Now().Add(kv.WriteStall)
+	for wait := 10 * time.Microsecond; !kv.commitMu.TryLock(); wait = min(2*wait, time.Millisecond) {
+		left := time.Until(deadline)
+		if left <= 0 {
+			kv.stats.timeouts.Add(1)
+			return fmt.Errorf("%w: commit lock busy for %v", ErrTimeout, kv.WriteStall)
+		}
+		time.Sleep(min(wait, left))
+	}
+	return nil
+}
+
+// walWriteTimeout is walWrite bounded by KV.CommitTimeout. A write that
+// outlives it cannot be cancelled: it goes on in the background, keeping
+// commitMu, which *handoff is then set to tell the caller not to unlock.
+// When it ends, whether or not it succeeded, the WAL may hold a commit the
+// store never published, so it sets kv.failed and unlocks: later commits
+// fail with ErrNeedsReopen, and Reopen recovers whatever the WAL holds.
+func (kv *KV) walWriteTimeout(tx *KVTX, state commitState, handoff *bool) error {
+	if kv.CommitTimeout <= 0 {
+		return kv.walWrite(tx, state)
+	}
+	done := make(chan error, 1)
+	go func() { done <- kv.walWrite(tx, state) }()
+	timer := time.NewTimer(kv.CommitTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+	*handoff = true
+	kv.stats.timeouts.Add(1)
+	kv.log().Error("kv: WAL write timed out", "path", kv.Path, "version", state.Version, "timeout", kv.CommitTimeout)
+	go func() {
+		err := <-done
+		if err == nil {
+			err = errors.New("abandoned commit reached the WAL")
+		}
+		kv.failed = fmt.Errorf("WAL write timed out: %w", err)
+		kv.commitMu.Unlock()
+	}()
+	return fmt.Errorf("%w: WAL write took over %v; outcome unknown", ErrTimeout, kv.CommitTimeout)
+}
@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCommitTimeouts(t *testing.T) {
+	dbPath := tempDB(t)
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + ".wal")
+
+	db := &KV{Path: dbPath, NoSync: true, WriteStall: 20 * time.Millisecond}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.NoError(t, kvPut(db, "a", "1"))
+
+	// A commit waits for the lock for WriteStall, then gives up unwritten.
+	db.commitMu.Lock()
+	start := time.Now()
+	err := kvPut(db, "b", "2")
+	is.ErrorIs(t, err, ErrTimeout)
+	is.Less(t, time.Since(start), time.Second)
+	db.commitMu.Unlock()
+	_, ok := kvGet(db, "b")
+	is.False(t, ok)
+	is.NoError(t, kvPut(db, "b", "2"))
+
+	// A WAL write that hangs past CommitTimeout fails the commit, and the
+	// write goes on holding the commit lock.
+	db.CommitTimeout = 20 * time.Millisecond
+	stuck := make(chan struct{})
+	db.wal.fault = func(op string) (int, error) {
+		<-stuck
+		return 0, nil
+	}
+	err = kvPut(db, "c", "3")
+	is.ErrorIs(t, err, ErrTimeout)
+	is.ErrorIs(t, kvPut(db, "d", "4"), ErrTimeout)
+
+	// Once it ends the store needs a reopen, which recovers the commit.
+	db.WriteStall = 0
+	close(stuck)
+	is.ErrorIs(t, kvPut(db, "d", "4"), ErrNeedsReopen)
+	is.Equal(t, uint64(3), db.Metrics().Timeouts)
+	db.wal.fault = nil
+	is.NoError(t, db.Reopen())
+	val, ok := kvGet(db, "c")
+	is.True(t, ok)
+	is.Equal(t, "3", val)
+	is.NoError(t, kvPut(db, "d", "4"))
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
 )
 
 // KVReader is a snapshot read transaction.
@@ -17,28 +19,45 @@ type KVReader struct {
 	mmap    struct {
 		chunks [][]byte // snapshot of db.mmap.chunks at the moment Begin was called
 	}
-	mmapMu *sync.RWMutex // shared reference to KV.mmapMu
-	index  int           // position in the KV.readers heap
-	done   bool          // true after EndRead
+	mmapMu  *sync.RWMutex  // shared reference to KV.mmapMu
+	index   int            // position in the KV.readers heap
+	done    bool           // true after EndRead
+	kv      *KV            // for Pinned.Release; set by BeginRead
+	pins    int            // unreleased views of GetPinned; guarded by KV.mu
+	ended   bool           // EndRead was called with views pinned; guarded by KV.mu
+	summary *btree.Summary // leaf index of tree, if KV.IndexSummary
+	stats   *kvStats       // nil for internal readers
+	ahead   int            // KV.ReadAhead
 }
 
 // BeginRead opens a new read transaction, taking a snapshot of the current
 // tree root and mmap chunk list.
 func (kv *KV) BeginRead(tx *KVReader) {
 	kv.mu.Lock()
+	assert(tx.pins == 0) // the last snapshot of tx is still pinned
+	tx.kv = kv
+	tx.ended = false
 	tx.mmap.chunks = kv.mmap.chunks
 	tx.tree.Root = kv.tree.root
 	tx.tree.Store = tx // KVReader implements btree.PageStore (read-only subset)
+	tx.tree.MaxKey, tx.tree.MaxVal = kv.limits.key, kv.limits.val
 	tx.version = kv.version
 	tx.mmapMu = &kv.mmapMu
+	tx.summary = kv.summary
+	tx.stats = &kv.stats
+	tx.ahead = kv.ReadAhead
 	heap.Push(&kv.readers, tx)
 	kv.mu.Unlock()
 }
 
-// EndRead closes a read transaction and removes it from the reader heap.
+// EndRead closes a read transaction and removes it from the reader heap,
+// or, if views of GetPinned are still pinned, once the last is released.
 func (kv *KV) EndRead(tx *KVReader) {
 	kv.mu.Lock()
-	heap.Remove(&kv.readers, tx.index)
+	tx.ended = true
+	if tx.pins == 0 {
+		heap.Remove(&kv.readers, tx.index)
+	}
 	kv.mu.Unlock()
 }
 
@@ -80,7 +99,64 @@ func (tx *KVReader) PageDel(_ uint64)             { panic("read-only transaction
 // --- kv.Reader interface ---
 
 // Get returns the value for key in this snapshot, or (nil, false) if absent.
+// The value is a copy the caller owns; GetPinned avoids the copy.
 func (tx *KVReader) Get(key []byte) ([]byte, bool) {
+	val, ok := tx.get(key)
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(val), true
+}
+
+// Pinned is a zero-copy view of a value, as GetPinned returns it. Val must
+// not be modified, and must not be used after Release.
+type Pinned struct {
+	Val []byte
+	tx  *KVReader // nil if there is nothing to release
+}
+
+// GetPinned is Get without the copy: the value it returns aliases the
+// mapped file. The pages of the snapshot, and so the view, stay valid until
+// Release, even after EndRead; until then they are not reused, as if the
+// read transaction were still open, and the KV must not be closed. Release
+// every view promptly, as a long-pinned snapshot holds back the reuse of
+// the pages later commits free. A KVReader whose views are pinned must not
+// begin another read.
+func (tx *KVReader) GetPinned(key []byte) (Pinned, bool) {
+	val, ok := tx.get(key)
+	if !ok {
+		return Pinned{}, false
+	}
+	tx.kv.mu.Lock()
+	assert(!tx.ended)
+	tx.pins++
+	tx.kv.mu.Unlock()
+	return Pinned{val, tx}, true
+}
+
+// Release unpins the view; Val is then nil. Releasing a view twice, or the
+// zero Pinned, does nothing.
+func (p *Pinned) Release() {
+	tx := p.tx
+	p.Val, p.tx = nil, nil
+	if tx == nil {
+		return
+	}
+	tx.kv.mu.Lock()
+	tx.pins--
+	if tx.pins == 0 && tx.ended {
+		heap.Remove(&tx.kv.readers, tx.index)
+	}
+	tx.kv.mu.Unlock()
+}
+
+func (tx *KVReader) get(key []byte) ([]byte, bool) {
+	if tx.stats != nil {
+		tx.stats.gets.Add(1)
+	}
+	if tx.summary != nil {
+		return tx.summary.Get(tx, key)
+	}
 	return tx.tree.Get(key)
 }
 
@@ -100,11 +176,22 @@ type KVTX struct {
 	readSet  map[uint64]struct{} // pages read from committed state (for OCC conflict detection)
 	page     struct {
 		nappend int               // number of pages appended by this tx
+		nalloc  int               // number of pages allocated, reused or appended
 		updates map[uint64][]byte // nil value = page is freed; non-nil = new content
 	}
 	// pageCache holds copies of mmap pages read during this transaction.
 	// Separate from updates to avoid treating cached reads as writes at commit time.
 	pageCache map[uint64][]byte
+
+	// In logged mode (see KV.Propose): the log index the snapshot was taken
+	// at and the changes to propose, or, for a transaction of the log
+	// itself, the log position it leaves the store at.
+	base   uint64
+	ops    []Op
+	logPos *logPos
+	// watched is set when the changes are recorded in ops for the watches
+	// as well; see KV.Watch.
+	watched bool
 }
 
 // --- btree.PageStore implementation for KVTX (read + write path) ---
@@ -136,6 +223,7 @@ func (tx *KVTX) PageGet(ptr uint64) btree.BNode {
 func (tx *KVTX) PageNew(node btree.BNode) uint64 {
 	assert(len(node.Data) <= btree.PageSize)
 	if ptr := tx.free.Pop(); ptr != 0 {
+		tx.page.nalloc++
 		tx.page.updates[ptr] = node.Data
 		return ptr
 	}
@@ -150,18 +238,39 @@ func (tx *KVTX) PageDel(ptr uint64) {
 // PageAppend allocates a brand-new page beyond the current file end.
 // Used by both PageNew (overflow) and the FreeList (via btree.FreeListStore).
 // Page numbers are handed out under pageAllocMu so that concurrent writers
-// each get unique page numbers.
+// each get unique page numbers. A proposing transaction, whose pages are
+// never written, numbers them past the allocated ones without taking them.
 func (tx *KVTX) PageAppend(node btree.BNode) uint64 {
 	assert(len(node.Data) <= btree.PageSize)
 	tx.kv.pageAllocMu.Lock()
 	ptr := tx.kv.pageAlloc
-	tx.kv.pageAlloc++
+	if tx.proposing() {
+		ptr += uint64(tx.page.nappend)
+	} else {
+		tx.kv.pageAlloc++
+	}
 	tx.kv.pageAllocMu.Unlock()
 	tx.page.nappend++
+	tx.page.nalloc++
 	tx.page.updates[ptr] = node.Data
 	return ptr
 }
 
+// rewindAlloc hands out the page numbers past the end of the file again
+// after a commit failed to take them, as one that ran into MmapMax, so that
+// smaller commits can still go through. An open write transaction may hold
+// some of them, so it does nothing while there is one. It runs under
+// commitMu.
+func (kv *KV) rewindAlloc() {
+	kv.pageAllocMu.Lock()
+	defer kv.pageAllocMu.Unlock()
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.writers == 0 {
+		kv.pageAlloc = kv.page.flushed
+	}
+}
+
 // PageUse rewrites an existing page in-place (used by FreeList to recycle its
 // own nodes without going through the free list again).
 func (tx *KVTX) PageUse(ptr uint64, node btree.BNode) {
@@ -172,17 +281,34 @@ func (tx *KVTX) PageUse(ptr uint64, node btree.BNode) {
 // PageGet is already provided above.
 // PageAppend and PageUse are provided above.
 
+// GetPinned is KVReader.GetPinned for a write transaction. Its pages are
+// private copies, which no commit changes, so the view pins nothing, but it
+// is to be released all the same.
+func (tx *KVTX) GetPinned(key []byte) (Pinned, bool) {
+	val, ok := tx.get(key)
+	return Pinned{Val: val}, ok
+}
+
 // --- kv.Writer interface ---
 
 // Update inserts or updates a key. Returns true if a new key was created.
 func (tx *KVTX) Update(req *btree.InsertReq) bool {
+	tx.kv.stats.sets.Add(1)
 	tx.tree.InsertEx(req)
+	if req.Updated && (tx.watched || tx.proposing()) {
+		tx.recordOp(Op{Key: req.Key, Val: req.Val})
+	}
 	return req.Added
 }
 
 // Del deletes a key. Returns true if the key existed.
 func (tx *KVTX) Del(req *btree.DeleteReq) bool {
-	return tx.tree.DeleteEx(req)
+	tx.kv.stats.deletes.Add(1)
+	deleted := tx.tree.DeleteEx(req)
+	if deleted && (tx.watched || tx.proposing()) {
+		tx.recordOp(Op{Key: req.Key, Del: true})
+	}
+	return deleted
 }
 
 // --- transaction lifecycle ---
@@ -192,29 +318,46 @@ func (tx *KVTX) Del(req *btree.DeleteReq) bool {
 // instances may exist simultaneously. The commit phase is serialised via
 // commitMu and uses OCC conflict detection.
 func (kv *KV) Begin(tx *KVTX) {
+	kv.txGate.RLock()
+	kv.begin(tx)
+}
+
+func (kv *KV) begin(tx *KVTX) {
 	tx.kv = kv
 	tx.page.updates = map[uint64][]byte{}
 	tx.pageCache = map[uint64][]byte{}
 	tx.readSet = map[uint64]struct{}{}
-	tx.mmap.chunks = kv.mmap.chunks
+	tx.stats = &kv.stats
+	tx.watched = kv.watched()
+	tx.mmapMu = &kv.mmapMu
+	tx.ahead = kv.ReadAhead
 
+	// The root, the free list and the log index are published together by
+	// Commit.
+	kv.mu.Lock()
+	tx.mmap.chunks = kv.mmap.chunks
 	tx.version = kv.version
+	free := kv.free
+	tx.base = kv.logged.applied
 
 	// Wire the B-tree to this transaction's page store.
 	tx.tree.Root = kv.tree.root
 	tx.tree.Store = tx
+	tx.tree.MaxKey, tx.tree.MaxVal = kv.limits.key, kv.limits.val
 
 	// Determine the oldest active reader so the free list knows which pages
-	// are safe to reuse.
+	// are safe to reuse, then count as one: other commits must not reuse
+	// the pages of this snapshot while it is read.
 	minReader := kv.version
-	kv.mu.Lock()
 	if len(kv.readers) > 0 {
 		minReader = kv.readers[0].version
 	}
+	heap.Push(&kv.readers, &tx.KVReader)
+	kv.writers++
 	kv.mu.Unlock()
 
 	// Wire the free list.
-	tx.free = btree.NewFreeList(kv.free, kv.version, minReader, tx)
+	tx.free = btree.NewFreeList(free, tx.version, minReader, tx)
 
 	assert(tx.page.nappend == 0 && len(tx.page.updates) == 0)
 }
@@ -222,13 +365,30 @@ func (kv *KV) Begin(tx *KVTX) {
 // Commit persists the transaction using OCC.
 // Under commitMu it performs conflict detection, then writes pages to the
 // mmap, appends commit records to the WAL, fsyncs the WAL, and publishes
-// the new in-memory state.
+// the new in-memory state. In logged mode it proposes the changes of the
+// transaction instead; see KV.Propose.
 func (kv *KV) Commit(tx *KVTX) error {
 	assert(!tx.done)
 	tx.done = true
+	kv.release(tx)
+	kv.txGate.RUnlock()
+	if tx.proposing() {
+		return kv.propose(tx)
+	}
 
-	kv.commitMu.Lock()
-	defer kv.commitMu.Unlock()
+	if err := kv.lockCommit(); err != nil {
+		return err
+	}
+	handoff := false // a timed-out WAL write keeps commitMu
+	defer func() {
+		if !handoff {
+			kv.commitMu.Unlock()
+		}
+	}()
+
+	if kv.failed != nil {
+		return fmt.Errorf("%w: %v", ErrNeedsReopen, kv.failed)
+	}
 
 	// --- OCC conflict detection ---
 	// If another writer committed after this tx began (version advanced),
@@ -236,7 +396,8 @@ func (kv *KV) Commit(tx *KVTX) error {
 	// computed does not incorporate the other tx's changes. We must abort
 	// to prevent lost updates.
 	if tx.version != kv.version {
-		return fmt.Errorf("serialisation conflict: retry transaction")
+		kv.stats.conflicts.Add(1)
+		return ErrConflict
 	}
 
 	// Fast path: nothing changed.
@@ -246,6 +407,9 @@ func (kv *KV) Commit(tx *KVTX) error {
 	if kv.tree.root == tx.tree.Root {
 		return nil
 	}
+	if kv.ReadOnly {
+		return ErrReadOnly
+	}
 
 	// 1. Collect freed pages and update the freelist.
 	freed := make([]uint64, 0, len(tx.page.updates))
@@ -266,10 +430,14 @@ func (kv *KV) Commit(tx *KVTX) error {
 	}
 	db := tx.kv
 	npages := int(newFlushed)
-	if err := extendFile(db, npages); err != nil {
+	if err := kv.retryIO("extend file", func() error { return extendFile(db, npages) }); err != nil {
 		return err
 	}
 	if err := extendMmap(db, npages); err != nil {
+		kv.rewindAlloc()
+		return err
+	}
+	if err := kv.reserveUpdates(tx); err != nil {
 		return err
 	}
 	tx.mmap.chunks = db.mmap.chunks
@@ -283,7 +451,159 @@ func (kv *KV) Commit(tx *KVTX) error {
 	}
 	kv.mmapMu.Unlock()
 
-	// 3. Write the transaction to the WAL for crash recovery.
+	// 3. Write the transaction to the WAL for crash recovery, and
+	// 4. fsync it so the commit is durable (main DB fsync deferred to
+	// checkpoint). A failed attempt is cut off the WAL before it is retried
+	// or reported, so later commits never follow a torn record.
+	flushStart := time.Now()
+	if err := kv.walWriteTimeout(tx, commitState{
+		Root:        tx.tree.Root,
+		FreeHead:    tx.free.FreeListData.Head,
+		PageFlushed: newFlushed,
+		Version:     kv.version + 1,
+	}, &handoff); err != nil {
+		return err
+	}
+	kv.stats.observeFlush(flushStart)
+
+	// 5. Publish the new in-memory state so subsequent reads see it.
+	summary := kv.buildSummary(tx.tree.Root)
+	prevFree, prevPages := kv.free.Head, kv.page.flushed
+	kv.page.flushed = newFlushed
+	kv.mu.Lock()
+	kv.free = tx.free.FreeListData
+	kv.tree.root = tx.tree.Root
+	kv.summary = summary
+	kv.version++
+	if tx.logPos != nil {
+		kv.logged = *tx.logPos
+	}
+	version := kv.version
+	kv.mu.Unlock()
+	kv.keepVersion()
+	kv.events.Publish(events.Event{Kind: events.Commit, Version: version})
+	kv.notifyWatches(tx.ops, version)
+	kv.stats.commits.Add(1)
+	kv.stats.pagesAlloc.Add(uint64(tx.page.nalloc))
+	kv.stats.pagesFreed.Add(uint64(len(freed)))
+	if reused := tx.page.nalloc - tx.page.nappend; reused > 0 {
+		kv.log().Debug("kv: recycled free pages", "version", version, "pages", reused)
+	}
+	kv.stampPages(tx, version)
+	if kv.PunchHoles || kv.DropFreed {
+		kv.punchQueue(tx, freed, version)
+	}
+	kv.publishRecord(recordCommit, tx, prevFree, prevPages, tx.free.MaxReused())
+
+	// 6. Write the master page (no fsync) so other sessions can open the DB
+	// without needing WAL recovery.
+	if err := kv.retryIO("master store", func() error { return masterStore(kv) }); err != nil {
+		return fmt.Errorf("commit master store: %w", err)
+	}
+
+	if tx.free.MaxFreed()+truncateMin >= newFlushed {
+		kv.tailFree = true
+	}
+	if kv.tailFree {
+		kv.truncateTail()
+	}
+	if len(kv.punch.pending) > 0 {
+		kv.punchHoles()
+	}
+	return nil
+}
+
+// truncateMin is the fewest free pages truncateTail cuts off: shorter runs
+// are left for reuse rather than shrinking the file only to grow it again.
+const truncateMin = 64
+
+// truncateTail gives the free pages that end the file back to the OS: it
+// takes them off the free list (see btree.FreeList.TruncateTail) in a
+// transaction of its own, then shortens the file and the mapping. It runs
+// under commitMu after a commit, and only while no write transaction is open,
+// since those may read any page and the free list. Failures are logged; the
+// pages then stay on the free list.
+func (kv *KV) truncateTail() {
+	if !kv.txGate.TryLock() {
+		return // retried after a later commit
+	}
+	defer kv.txGate.Unlock()
+	tx := KVTX{}
+	kv.begin(&tx)
+	tx.done = true
+	kv.release(&tx)
+	cut, pending := tx.free.TruncateTail(kv.page.flushed, truncateMin)
+	kv.tailFree = pending
+	if cut == kv.page.flushed {
+		return
+	}
+
+	// The free list is rewritten in place, so the WAL goes first: a failed
+	// append leaves the mapped pages as they were.
+	err := kv.reserveUpdates(&tx)
+	if err == nil {
+		err = kv.walWrite(&tx, commitState{
+			Root:        tx.tree.Root,
+			FreeHead:    tx.free.FreeListData.Head,
+			PageFlushed: cut,
+			Version:     kv.version,
+		})
+	}
+	if err != nil {
+		kv.log().Warn("kv: tail truncation failed", "path", kv.Path, "err", err)
+		return
+	}
+	kv.mmapMu.Lock()
+	for ptr, page := range tx.page.updates {
+		copy(pageGetMapped(kv.mmap.chunks, ptr).Data, page)
+	}
+	kv.mmapMu.Unlock()
+	kv.stampPages(&tx, kv.version+1) // the version stays: count them as the next
+	if kv.PunchHoles || kv.DropFreed {
+		kv.punchQueue(&tx, nil, 0) // the new list nodes
+	}
+	before, prevFree := kv.page.flushed, kv.free.Head
+	kv.page.flushed = cut
+	kv.mu.Lock()
+	kv.free = tx.free.FreeListData
+	kv.mu.Unlock()
+	kv.pageAllocMu.Lock()
+	kv.pageAlloc = cut
+	kv.pageAllocMu.Unlock()
+	// The truncation reuses free pages of any version up to the current one.
+	kv.publishRecord(recordTruncate, &tx, prevFree, before, kv.version)
+	if err := kv.retryIO("master store", func() error { return masterStore(kv) }); err != nil {
+		kv.log().Warn("kv: tail truncation failed", "path", kv.Path, "err", err)
+		return
+	}
+	kv.stats.pagesTruncated.Add(before - cut)
+	if err := shrinkFile(kv, int(cut)); err != nil {
+		kv.log().Warn("kv: tail truncation failed", "path", kv.Path, "err", err)
+	}
+}
+
+// walWrite appends the records of tx, which leads to state, to the WAL,
+// retrying transient errors. A failed attempt is cut off the WAL before it
+// is retried or reported.
+func (kv *KV) walWrite(tx *KVTX, state commitState) error {
+	start, err := kv.wal.offset()
+	if err != nil {
+		return fmt.Errorf("WAL offset: %w", err)
+	}
+	return kv.retryIO("WAL append", func() error {
+		err := kv.walAppend(tx, state)
+		if err != nil {
+			if rerr := kv.wal.rollback(start); rerr != nil {
+				kv.failed = fmt.Errorf("WAL rollback: %w", rerr)
+			}
+		}
+		return err
+	})
+}
+
+// walAppend writes and syncs the WAL records of tx. With a ring, the
+// records are gathered and written together with the fsync.
+func (kv *KV) walAppend(tx *KVTX, state commitState) error {
 	if err := kv.wal.BeginTX(kv.version); err != nil {
 		return fmt.Errorf("WAL begin: %w", err)
 	}
@@ -295,34 +615,19 @@ func (kv *KV) Commit(tx *KVTX) error {
 			return fmt.Errorf("WAL page data: %w", err)
 		}
 	}
-	if err := kv.wal.CommitTX(kv.version, commitState{
-		Root:        tx.tree.Root,
-		FreeHead:    tx.free.FreeListData.Head,
-		PageFlushed: newFlushed,
-	}); err != nil {
+	state.Time = time.Now().UnixNano()
+	if err := kv.wal.CommitTX(kv.version, state); err != nil {
 		return fmt.Errorf("WAL commit: %w", err)
 	}
-
-	// 4. fsync the WAL so the commit is durable (main DB fsync deferred to checkpoint).
-	if !kv.NoSync {
+	if kv.wal.ring != nil {
+		if err := kv.wal.flush(!kv.NoSync); err != nil {
+			return fmt.Errorf("WAL flush: %w", err)
+		}
+	} else if !kv.NoSync {
 		if err := kv.wal.Sync(); err != nil {
 			return fmt.Errorf("WAL fsync: %w", err)
 		}
 	}
-
-	// 5. Publish the new in-memory state so subsequent reads see it.
-	kv.page.flushed = newFlushed
-	kv.free = tx.free.FreeListData
-	kv.mu.Lock()
-	kv.tree.root = tx.tree.Root
-	kv.version++
-	kv.mu.Unlock()
-
-	// 6. Write the master page (no fsync) so other sessions can open the DB
-	// without needing WAL recovery.
-	if err := masterStore(kv); err != nil {
-		return fmt.Errorf("commit master store: %w", err)
-	}
 	return nil
 }
 
@@ -330,4 +635,15 @@ func (kv *KV) Commit(tx *KVTX) error {
 func (kv *KV) Abort(tx *KVTX) {
 	assert(!tx.done)
 	tx.done = true
+	kv.release(tx)
+	kv.txGate.RUnlock()
+}
+
+// release takes a finished write transaction off the readers heap. Pages
+// read by Commit stay safe: only a commit can reuse them.
+func (kv *KV) release(tx *KVTX) {
+	kv.mu.Lock()
+	heap.Remove(&kv.readers, tx.index)
+	kv.writers--
+	kv.mu.Unlock()
 }
@@ -1,6 +1,10 @@
 package kv
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/MHS-20/ElkDB/btree"
@@ -91,3 +95,172 @@ func TestKVRW(t *testing.T) {
 
 	kvt.dispose()
 }
+
+func TestTruncateTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tail.db")
+	db := &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	key := func(i int) []byte { return fmt.Appendf(nil, "k%05d", i) }
+	update := func(fn func(tx *KVTX)) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		fn(&tx)
+		is.NoError(t, db.Commit(&tx))
+	}
+	fileSize := func() int64 {
+		fi, err := os.Stat(path)
+		is.NoError(t, err)
+		return fi.Size()
+	}
+	update(func(tx *KVTX) {
+		for i := range 2000 {
+			tx.Update(&btree.InsertReq{Key: key(i), Val: make([]byte, 100)})
+		}
+	})
+	full := db.Stats().Pages
+
+	// An open snapshot still sees the pages, and an open write transaction
+	// may read them: neither lets the file shrink.
+	r := KVReader{}
+	db.BeginRead(&r)
+	update(func(tx *KVTX) {
+		for i := 1000; i < 2000; i++ {
+			tx.Del(&btree.DeleteReq{Key: key(i)})
+		}
+	})
+	is.Equal(t, full, db.Stats().Pages)
+	is.Positive(t, db.FreeStats().TailPages)
+	db.EndRead(&r)
+	open := KVTX{}
+	db.Begin(&open)
+	update(func(tx *KVTX) { tx.Update(&btree.InsertReq{Key: []byte("a"), Val: []byte("1")}) })
+	is.Equal(t, full, db.Stats().Pages)
+	db.Abort(&open)
+
+	update(func(tx *KVTX) { tx.Update(&btree.InsertReq{Key: []byte("b"), Val: []byte("2")}) })
+	st := db.Stats()
+	is.Less(t, st.Pages, full*3/4)
+	is.Zero(t, db.FreeStats().TailPages)
+	is.Equal(t, int64(st.Pages)*btree.PageSize, fileSize())
+	is.Equal(t, full-st.Pages, db.Metrics().PagesTruncated)
+	check := db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(1000+2), check.Keys)
+
+	// Crash without a checkpoint: the WAL still holds images of the pages
+	// cut off, which recovery must skip.
+	is.NoError(t, db.wal.Close())
+	for _, chunk := range db.regions() {
+		_ = syscall.Munmap(chunk)
+	}
+	_ = db.fp.Close()
+	db = &KV{Path: path, NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.Equal(t, st.Pages, db.Stats().Pages)
+	check = db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	is.Equal(t, int64(1000+2), check.Keys)
+	update(func(tx *KVTX) {
+		for i := range 3000 {
+			tx.Update(&btree.InsertReq{Key: key(i), Val: make([]byte, 50)})
+		}
+	})
+	is.True(t, db.Check().OK())
+}
+
+func TestSetMulti(t *testing.T) {
+	kvt := newKVTester()
+	defer kvt.dispose()
+
+	commits := kvt.db.Metrics().Commits
+	is.NoError(t, kvt.db.SetMulti([]Pair{
+		{[]byte("b"), []byte("1")},
+		{[]byte("a"), []byte("1")},
+		{[]byte("b"), []byte("2")}, // the last value wins
+	}))
+	is.Equal(t, commits+1, kvt.db.Metrics().Commits)
+	kvt.ref["a"], kvt.ref["b"] = "1", "2"
+	kvt.verify(t)
+
+	// A bad pair fails the call before anything is written.
+	err := kvt.db.SetMulti([]Pair{{[]byte("c"), nil}, {nil, []byte("x")}})
+	is.ErrorContains(t, err, "pair 1")
+	err = kvt.db.SetMulti([]Pair{{[]byte("c"), nil}, {[]byte("d"), make([]byte, btree.MaxValSize+1)}})
+	is.Error(t, err)
+	kvt.verify(t)
+
+	// Readers see every key of a call at the same value.
+	keys := []string{"x1", "x2", "x3", "x4"}
+	set := func(v int) error {
+		var pairs []Pair
+		for _, k := range keys {
+			pairs = append(pairs, Pair{[]byte(k), fmt.Appendf(nil, "%d", v)})
+		}
+		return kvt.db.SetMulti(pairs)
+	}
+	is.NoError(t, set(0))
+	done := make(chan error)
+	go func() {
+		for i := range 200 {
+			if err := set(i + 1); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for range 200 {
+		r := KVReader{}
+		kvt.db.BeginRead(&r)
+		first, _ := r.Get([]byte(keys[0]))
+		for _, k := range keys[1:] {
+			v, ok := r.Get([]byte(k))
+			is.True(t, ok)
+			is.Equal(t, string(first), string(v))
+		}
+		kvt.db.EndRead(&r)
+	}
+	is.NoError(t, <-done)
+}
+
+func TestGetPinned(t *testing.T) {
+	kvt := newKVTester()
+	defer kvt.dispose()
+	kvt.add("k1", "v1")
+
+	r := KVReader{}
+	kvt.db.BeginRead(&r)
+	// Get returns a copy the caller may change.
+	val, ok := r.Get([]byte("k1"))
+	is.True(t, ok)
+	val[0] = 'x'
+	val, _ = r.Get([]byte("k1"))
+	is.Equal(t, "v1", string(val))
+
+	p, ok := r.GetPinned([]byte("k1"))
+	is.True(t, ok)
+	_, ok = r.GetPinned([]byte("nope"))
+	is.False(t, ok)
+	kvt.db.EndRead(&r)
+	// The view keeps the snapshot registered, so commits that free its
+	// pages do not reuse them.
+	is.Equal(t, 1, kvt.db.readers.Len())
+	for i := range 50 {
+		kvt.add("k1", fmt.Sprintf("value %d", i))
+	}
+	is.Equal(t, "v1", string(p.Val))
+	p.Release()
+	p.Release()
+	is.Nil(t, p.Val)
+	is.Equal(t, 0, kvt.db.readers.Len())
+
+	// A write transaction views its private pages.
+	tx := KVTX{}
+	kvt.db.Begin(&tx)
+	p, ok = tx.GetPinned([]byte("k1"))
+	is.True(t, ok)
+	is.Equal(t, "value 49", string(p.Val))
+	p.Release()
+	kvt.db.Abort(&tx)
+}
@@ -0,0 +1,265 @@
+//go:build linux
+
+package kv
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The parts of the io_uring(7) interface the WAL uses, which package syscall
+// lacks. The system call numbers are the same on every architecture.
+const (
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringOpFsync = 3
+	uringOpWrite = 23
+
+	uringSQELink        = 1 << 2 // IOSQE_IO_LINK
+	uringEnterGetEvents = 1 << 0 // IORING_ENTER_GETEVENTS
+	uringSQESize        = 64
+	uringCQESize        = 16
+	uringEntries        = 8
+)
+
+// uringParams is struct io_uring_params.
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	cqOff struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+}
+
+// uringSQE is struct io_uring_sqe, with the fields the WAL sets.
+type uringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	opFlags  uint32
+	userData uint64
+	_        [24]byte
+}
+
+// uringCQE is struct io_uring_cqe.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringOp is one operation of a submission: a write of buf at off, or an
+// fsync if buf is nil.
+type uringOp struct {
+	fd  int
+	buf []byte
+	off int64
+}
+
+// uring is an io_uring instance. It is used by one goroutine at a time.
+type uring struct {
+	fd             int
+	sqRing, cqRing []byte
+	sqes           []byte
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []byte
+	// broken is set when a submission could not be waited for; inflight
+	// then keeps its buffers alive, and the ring must not be used again.
+	broken   bool
+	inflight []uringOp
+}
+
+// newURing sets up an io_uring instance with room for uringEntries
+// operations per submission.
+func newURing() (*uring, error) {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &uring{fd: int(fd)}
+	fail := func(err error) (*uring, error) {
+		r.close()
+		return nil, err
+	}
+	var err error
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	if r.sqRing, err = mmapRing(r.fd, uringOffSQRing, sqSize); err != nil {
+		return fail(err)
+	}
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uringCQESize)
+	if r.cqRing, err = mmapRing(r.fd, uringOffCQRing, cqSize); err != nil {
+		return fail(err)
+	}
+	if r.sqes, err = mmapRing(r.fd, uringOffSQEs, int(p.sqEntries)*uringSQESize); err != nil {
+		return fail(err)
+	}
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = r.cqRing[p.cqOff.cqes:]
+	return r, nil
+}
+
+func mmapRing(fd int, off int64, size int) ([]byte, error) {
+	b, err := syscall.Mmap(fd, off, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return nil, fmt.Errorf("mmap io_uring: %w", err)
+	}
+	return b, nil
+}
+
+func (r *uring) close() {
+	for _, b := range [][]byte{r.sqes, r.cqRing, r.sqRing} {
+		if b != nil {
+			_ = syscall.Munmap(b)
+		}
+	}
+	_ = syscall.Close(r.fd)
+}
+
+// run submits ops as one chain, each linked to the next so that a failure
+// cancels the rest, and waits for all of them with a single system call
+// when it can. It returns the first error; a short write is
+// io.ErrShortWrite.
+func (r *uring) run(ops []uringOp) error {
+	assert(len(ops) <= uringEntries)
+	tail := atomic.LoadUint32(r.sqTail)
+	for i, op := range ops {
+		idx := (tail + uint32(i)) & r.sqMask
+		sqe := (*uringSQE)(unsafe.Pointer(&r.sqes[idx*uringSQESize]))
+		*sqe = uringSQE{fd: int32(op.fd), userData: uint64(i)}
+		if op.buf == nil {
+			sqe.opcode = uringOpFsync
+		} else {
+			sqe.opcode = uringOpWrite
+			sqe.addr = uint64(uintptr(unsafe.Pointer(&op.buf[0])))
+			sqe.len = uint32(len(op.buf))
+			sqe.off = uint64(op.off)
+		}
+		if i < len(ops)-1 {
+			sqe.flags = uringSQELink
+		}
+		r.sqArray[idx] = idx
+	}
+	atomic.StoreUint32(r.sqTail, tail+uint32(len(ops)))
+
+	toSubmit, done := len(ops), 0
+	var first error
+	for done < len(ops) {
+		n, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(toSubmit),
+			uintptr(len(ops)-done), uringEnterGetEvents, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			// Nothing can wait for the operations left in flight.
+			r.broken = true
+			r.inflight = ops
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		toSubmit -= int(n)
+		head := atomic.LoadUint32(r.cqHead)
+		for ; head != atomic.LoadUint32(r.cqTail); head++ {
+			cqe := (*uringCQE)(unsafe.Pointer(&r.cqes[(head&r.cqMask)*uringCQESize]))
+			op := ops[cqe.userData]
+			switch {
+			case cqe.res < 0 && first == nil:
+				first = syscall.Errno(-cqe.res)
+			case cqe.res >= 0 && op.buf != nil && int(cqe.res) < len(op.buf) && first == nil:
+				first = io.ErrShortWrite
+			}
+			done++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+	runtime.KeepAlive(ops)
+	return first
+}
+
+// openRing makes the WAL gather the records of a commit and submit them,
+// with the fsync after them, through an io_uring instance in flush.
+func (wal *WAL) openRing() error {
+	r, err := newURing()
+	if err != nil {
+		return err
+	}
+	wal.ring = r
+	return nil
+}
+
+// flush writes the records gathered since the last flush and, if sync is
+// set, syncs the WAL, all in one submission to the ring. A ring that broke
+// is dropped, and later records are written directly.
+func (wal *WAL) flush(sync bool) error {
+	if sync && wal.fault != nil {
+		if _, err := wal.fault("fsync"); err != nil {
+			return err
+		}
+	}
+	rec := wal.pending
+	var ops []uringOp
+	var buf []byte
+	var off int64
+	if len(rec) > 0 {
+		if wal.direct != nil {
+			buf, off = wal.directBuf(rec)
+			ops = append(ops, uringOp{fd: int(wal.direct.Fd()), buf: buf, off: off})
+		} else {
+			var err error
+			if off, err = wal.fp.Seek(0, io.SeekCurrent); err != nil {
+				return err
+			}
+			ops = append(ops, uringOp{fd: int(wal.fp.Fd()), buf: rec, off: off})
+		}
+	}
+	if sync {
+		ops = append(ops, uringOp{fd: int(wal.fp.Fd())})
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := wal.ring.run(ops); err != nil {
+		if wal.ring.broken {
+			wal.ring = nil
+		}
+		return err
+	}
+	switch {
+	case len(rec) == 0:
+	case wal.direct != nil:
+		wal.directDone(buf, rec)
+	default:
+		if _, err := wal.fp.Seek(off+int64(len(rec)), io.SeekStart); err != nil {
+			return err
+		}
+	}
+	wal.pending = wal.pending[:0]
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix && !linux
+
+package kv
+
+import "errors"
+
+// io_uring is Linux only; elsewhere KV.IOUring falls back to plain writes.
+
+type uring struct{}
+
+func (r *uring) close() {}
+
+func (wal *WAL) openRing() error {
+	return errors.ErrUnsupported
+}
+
+func (wal *WAL) flush(sync bool) error {
+	panic("no io_uring")
+}
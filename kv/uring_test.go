@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestIOUringWAL(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		t.Run(fmt.Sprintf("direct=%v", direct), func(t *testing.T) {
+			dbPath := tempDB(t)
+			db := &KV{Path: dbPath, IOUring: true, DirectWAL: direct}
+			is.NoError(t, db.Open())
+			if db.wal.ring == nil {
+				db.Close()
+				t.Skip("io_uring not available")
+			}
+
+			// A large transaction writes many pages in one submission.
+			tx := KVTX{}
+			db.Begin(&tx)
+			for i := range 500 {
+				tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%03d", i), Val: fmt.Appendf(nil, "%0200d", i)})
+			}
+			is.NoError(t, db.Commit(&tx))
+			for i := range 20 {
+				is.NoError(t, kvPut(db, fmt.Sprintf("s%02d", i), fmt.Sprintf("v%d", i)))
+			}
+
+			// Failed writes and fsyncs are cut off the WAL like plain ones.
+			failWrites(db.wal, 1, syscall.EIO)
+			is.ErrorIs(t, kvPut(db, "torn", "x"), syscall.EIO)
+			db.wal.fault = func(op string) (int, error) {
+				if op == "fsync" {
+					return 0, syscall.EIO
+				}
+				return 0, nil
+			}
+			is.ErrorIs(t, kvPut(db, "unsynced", "x"), syscall.EIO)
+			db.wal.fault = nil
+			is.NoError(t, kvPut(db, "last", "y"))
+			is.NotNil(t, db.wal.ring)
+
+			// Simulate a crash: the commits are only in the WAL.
+			is.NoError(t, db.wal.Close())
+			for _, chunk := range db.regions() {
+				_ = syscall.Munmap(chunk)
+			}
+			_ = db.fp.Close()
+
+			db = &KV{Path: dbPath, NoSync: true}
+			is.NoError(t, db.Open())
+			defer db.Close()
+			for i := range 500 {
+				v, ok := kvGet(db, fmt.Sprintf("k%03d", i))
+				is.True(t, ok)
+				is.Equal(t, fmt.Sprintf("%0200d", i), v)
+			}
+			for i := range 20 {
+				v, ok := kvGet(db, fmt.Sprintf("s%02d", i))
+				is.True(t, ok)
+				is.Equal(t, fmt.Sprintf("v%d", i), v)
+			}
+			for _, key := range []string{"torn", "unsynced"} {
+				_, ok := kvGet(db, key)
+				is.False(t, ok)
+			}
+			v, ok := kvGet(db, "last")
+			is.True(t, ok)
+			is.Equal(t, "y", v)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"container/heap"
+	"errors"
+	"slices"
+)
+
+// ErrVersionGone is returned by BeginReadAt for a version that is no longer
+// kept; see KV.KeepVersions.
+var ErrVersionGone = errors.New("kv: version no longer kept")
+
+// The free list only hands out a page once no snapshot can reach it: every
+// freed page is tagged with the version that freed it, and a transaction
+// reuses only the pages freed at or before the oldest version still read
+// (the top of the readers heap). Long-running reads therefore never see
+// their pages overwritten, however many commits land meanwhile; they only
+// delay the reuse of the pages freed since they began.
+//
+// KeepVersions extends this to versions no reader has opened yet: the last
+// KeepVersions commits each pin their root with an internal reader, so that
+// BeginReadAt can open any of them later. The pins count as readers, so the
+// pages they reach are not recycled, truncated or punched until they are
+// released by newer commits.
+
+// Version returns the version the read transaction sees.
+func (tx *KVReader) Version() uint64 {
+	return tx.version
+}
+
+// BeginReadAt opens a read transaction on version, which is either the
+// current version or one of the last KeepVersions committed. It fails with
+// ErrVersionGone for any other.
+func (kv *KV) BeginReadAt(tx *KVReader, version uint64) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	src := kv.currentPin()
+	if version != src.version {
+		i := slices.IndexFunc(kv.kept, func(pin *KVReader) bool { return pin.version == version })
+		if i < 0 {
+			return ErrVersionGone
+		}
+		src = kv.kept[i]
+	}
+	tx.mmap.chunks = src.mmap.chunks
+	tx.tree.Root = src.tree.Root
+	tx.tree.Store = tx
+	tx.tree.MaxKey, tx.tree.MaxVal = kv.limits.key, kv.limits.val
+	tx.version = src.version
+	tx.mmapMu = &kv.mmapMu
+	tx.summary = src.summary
+	tx.stats = &kv.stats
+	tx.ahead = kv.ReadAhead
+	heap.Push(&kv.readers, tx)
+	return nil
+}
+
+// currentPin returns an internal reader of the current version, not yet on
+// the readers heap. It runs under mu.
+func (kv *KV) currentPin() *KVReader {
+	pin := &KVReader{version: kv.version, mmapMu: &kv.mmapMu, summary: kv.summary}
+	pin.mmap.chunks = kv.mmap.chunks
+	pin.tree.Root = kv.tree.root
+	pin.tree.Store = pin
+	pin.tree.MaxKey, pin.tree.MaxVal = kv.limits.key, kv.limits.val
+	return pin
+}
+
+// Versions returns the oldest version still read or kept, whose pages and
+// those of every later version are protected from reuse, and the current
+// version.
+func (kv *KV) Versions() (oldest, current uint64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	oldest = kv.version
+	if len(kv.readers) > 0 {
+		oldest = kv.readers[0].version
+	}
+	return oldest, kv.version
+}
+
+// keepVersion pins the current version and releases the pins beyond
+// KeepVersions. It runs under commitMu after the version is published.
+func (kv *KV) keepVersion() {
+	if kv.KeepVersions <= 0 && len(kv.kept) == 0 {
+		return
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.KeepVersions > 0 {
+		pin := kv.currentPin()
+		heap.Push(&kv.readers, pin)
+		kv.kept = append(kv.kept, pin)
+	}
+	for len(kv.kept) > max(kv.KeepVersions, 0) {
+		heap.Remove(&kv.readers, kv.kept[0].index)
+		kv.kept = kv.kept[1:]
+	}
+}
+
+// dropVersions releases every pin.
+func (kv *KV) dropVersions() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for _, pin := range kv.kept {
+		heap.Remove(&kv.readers, pin.index)
+	}
+	kv.kept = nil
+}
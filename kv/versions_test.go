@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestKeepVersions(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "versions.db"), NoSync: true, KeepVersions: 3}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	// write rewrites every key with round, freeing the pages of the last
+	// version.
+	write := func(round int) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		for i := range 200 {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "k%03d", i), Val: fmt.Appendf(nil, "%d-%0100d", round, i)})
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	check := func(r *KVReader, round int) {
+		t.Helper()
+		for i := range 200 {
+			val, ok := r.Get(fmt.Appendf(nil, "k%03d", i))
+			is.True(t, ok)
+			is.Equal(t, fmt.Appendf(nil, "%d-%0100d", round, i), val)
+		}
+	}
+
+	for round := 1; round <= 5; round++ {
+		write(round)
+	}
+	oldest, current := db.Versions()
+	is.Equal(t, uint64(5), current)
+	is.Equal(t, uint64(3), oldest)
+	is.Zero(t, db.Metrics().Readers)
+
+	// The kept versions stay readable while later commits free their
+	// pages; older ones are gone.
+	r := KVReader{}
+	is.NoError(t, db.BeginReadAt(&r, 3))
+	is.Equal(t, uint64(3), r.Version())
+	for round := 6; round <= 20; round++ {
+		write(round)
+	}
+	check(&r, 3)
+	is.ErrorIs(t, db.BeginReadAt(&KVReader{}, 2), ErrVersionGone)
+	is.ErrorIs(t, db.BeginReadAt(&KVReader{}, 17), ErrVersionGone)
+	for v := uint64(18); v <= 20; v++ {
+		r := KVReader{}
+		is.NoError(t, db.BeginReadAt(&r, v))
+		check(&r, int(v))
+		db.EndRead(&r)
+	}
+
+	// The open reader holds back the reuse of every page freed since; its
+	// end lets them be recycled.
+	oldest, _ = db.Versions()
+	is.Equal(t, uint64(3), oldest)
+	db.EndRead(&r)
+	oldest, _ = db.Versions()
+	is.Equal(t, uint64(18), oldest)
+	pages := db.Metrics().Pages
+	for round := 21; round <= 30; round++ {
+		write(round)
+	}
+	is.LessOrEqual(t, db.Metrics().Pages, pages)
+
+	// Turning KeepVersions off releases the pins at the next commit.
+	db.KeepVersions = 0
+	write(31)
+	oldest, current = db.Versions()
+	is.Equal(t, current, oldest)
+	is.ErrorIs(t, db.BeginReadAt(&KVReader{}, 30), ErrVersionGone)
+}
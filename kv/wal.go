@@ -2,9 +2,13 @@ package kv
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"maps"
 	"os"
+	"slices"
 
 	"github.com/MHS-20/ElkDB/btree"
 )
@@ -20,9 +24,28 @@ const (
 	walCommitTX byte = 3
 )
 
+var errBadWAL = errors.New("bad WAL signature")
+
 type WAL struct {
 	fp   *os.File
 	path string
+
+	// direct, if set, is a descriptor of the file opened with O_DIRECT that
+	// records are appended through (see KV.DirectWAL). end is then the
+	// logical end of the records, and tail the bytes of its partial block.
+	direct *os.File
+	end    int64
+	tail   []byte
+
+	// ring, if set, is the io_uring instance that flush submits the
+	// records gathered in pending through (see KV.IOUring).
+	ring    *uring
+	pending []byte
+
+	// fault, if set, is consulted before each record write and fsync (a
+	// test hook). A non-nil error fails the operation after the first n
+	// bytes of the record have been written, simulating a torn write.
+	fault func(op string) (n int, err error)
 }
 
 func OpenWAL(path string) (*WAL, error) {
@@ -36,6 +59,21 @@ func OpenWAL(path string) (*WAL, error) {
 		return nil, err
 	}
 	wal := &WAL{fp: fp, path: path}
+	if fi.Size() >= 16 {
+		header := make([]byte, 16)
+		if _, err := fp.ReadAt(header, 0); err != nil {
+			fp.Close()
+			return nil, err
+		}
+		if string(header[:len(walSig)]) != walSig {
+			fp.Close()
+			return nil, errBadWAL
+		}
+		if v := binary.LittleEndian.Uint32(header[8:]); v > walVersion {
+			fp.Close()
+			return nil, fmt.Errorf("unsupported WAL version %d (max %d)", v, walVersion)
+		}
+	}
 	if fi.Size() == 0 {
 		header := make([]byte, 16)
 		copy(header, walSig)
@@ -45,17 +83,78 @@ func OpenWAL(path string) (*WAL, error) {
 			return nil, err
 		}
 	}
+	// Records are appended: never start writing over the header.
+	if _, err := fp.Seek(0, io.SeekEnd); err != nil {
+		fp.Close()
+		return nil, err
+	}
 	return wal, nil
 }
 
 func (wal *WAL) Close() error {
+	if wal.ring != nil {
+		wal.ring.close()
+	}
+	if wal.direct != nil {
+		_ = wal.direct.Close()
+	}
 	return wal.fp.Close()
 }
 
 func (wal *WAL) Sync() error {
+	if wal.fault != nil {
+		if _, err := wal.fault("fsync"); err != nil {
+			return err
+		}
+	}
 	return wal.fp.Sync()
 }
 
+// offset returns the position the next record will be written at.
+func (wal *WAL) offset() (int64, error) {
+	if wal.direct != nil {
+		return wal.end, nil
+	}
+	return wal.fp.Seek(0, io.SeekCurrent)
+}
+
+// rollback discards everything written after off, such as the records of a
+// transaction whose commit failed half-way. If the file descriptor itself
+// has gone bad it is reopened once before giving up.
+func (wal *WAL) rollback(off int64) error {
+	err := wal.truncate(off)
+	if err == nil {
+		return nil
+	}
+	fp, oerr := os.OpenFile(wal.path, os.O_RDWR, 0o644)
+	if oerr != nil {
+		return err
+	}
+	_ = wal.fp.Close()
+	wal.fp = fp
+	if wal.direct != nil {
+		direct, oerr := openDirect(wal.path)
+		if oerr != nil {
+			return err
+		}
+		_ = wal.direct.Close()
+		wal.direct = direct
+	}
+	return wal.truncate(off)
+}
+
+func (wal *WAL) truncate(off int64) error {
+	wal.pending = wal.pending[:0]
+	if err := wal.fp.Truncate(off); err != nil {
+		return err
+	}
+	if wal.direct != nil {
+		return wal.loadTail(off)
+	}
+	_, err := wal.fp.Seek(off, io.SeekStart)
+	return err
+}
+
 func (wal *WAL) HasData() (bool, error) {
 	fi, err := wal.fp.Stat()
 	if err != nil {
@@ -82,17 +181,39 @@ type commitState struct {
 	Root        uint64
 	FreeHead    uint64
 	PageFlushed uint64
+	// Version is the version the store holds after the transaction: one
+	// more than its ID for a commit, equal to it for a tail truncation.
+	// Time is when it was written, in Unix nanoseconds. Both are 0 in
+	// records written before they were added to the end of the payload.
+	Version uint64
+	Time    int64
 }
 
 func (wal *WAL) CommitTX(txID uint64, state commitState) error {
-	payload := make([]byte, 8+8+8+8)
+	payload := make([]byte, 8+8+8+8+8+8)
 	binary.LittleEndian.PutUint64(payload, txID)
 	binary.LittleEndian.PutUint64(payload[8:], state.Root)
 	binary.LittleEndian.PutUint64(payload[16:], state.FreeHead)
 	binary.LittleEndian.PutUint64(payload[24:], state.PageFlushed)
+	binary.LittleEndian.PutUint64(payload[32:], state.Version)
+	binary.LittleEndian.PutUint64(payload[40:], uint64(state.Time))
 	return wal.writeRecord(walCommitTX, payload)
 }
 
+// parseCommit decodes the payload of a commit record.
+func parseCommit(payload []byte) (uint64, commitState) {
+	state := commitState{
+		Root:        binary.LittleEndian.Uint64(payload[8:]),
+		FreeHead:    binary.LittleEndian.Uint64(payload[16:]),
+		PageFlushed: binary.LittleEndian.Uint64(payload[24:]),
+	}
+	if len(payload) >= 48 {
+		state.Version = binary.LittleEndian.Uint64(payload[32:])
+		state.Time = int64(binary.LittleEndian.Uint64(payload[40:]))
+	}
+	return binary.LittleEndian.Uint64(payload), state
+}
+
 func (wal *WAL) writeRecord(recType byte, payload []byte) error {
 	buf := make([]byte, 1+4+4+len(payload))
 	buf[0] = recType
@@ -100,7 +221,25 @@ func (wal *WAL) writeRecord(recType byte, payload []byte) error {
 	binary.LittleEndian.PutUint32(buf[1:], crc)
 	binary.LittleEndian.PutUint32(buf[5:], uint32(len(payload)))
 	copy(buf[9:], payload)
-	_, err := wal.fp.Write(buf)
+	if wal.fault != nil {
+		if n, err := wal.fault("write"); err != nil {
+			_ = wal.write(buf[:min(n, len(buf))])
+			return err
+		}
+	}
+	return wal.write(buf)
+}
+
+// write appends rec to the WAL.
+func (wal *WAL) write(rec []byte) error {
+	if wal.ring != nil {
+		wal.pending = append(wal.pending, rec...)
+		return nil
+	}
+	if wal.direct != nil {
+		return wal.writeDirect(rec)
+	}
+	_, err := wal.fp.Write(rec)
 	return err
 }
 
@@ -109,6 +248,39 @@ type walEntry struct {
 	data    []byte
 }
 
+// parsePage decodes the payload of a page record.
+func parsePage(payload []byte) walEntry {
+	pg := make([]byte, btree.PageSize)
+	copy(pg, payload[16:])
+	return walEntry{binary.LittleEndian.Uint64(payload[8:]), pg}
+}
+
+// walRecords calls fn with the records of the WAL file contents in data, in
+// order, up to the first torn or damaged one or the zero padding of a
+// direct write.
+func walRecords(data []byte, fn func(recType byte, payload []byte)) {
+	pos := int64(16)
+	for pos+9 <= int64(len(data)) {
+		recType := data[pos]
+		if recType == 0 {
+			break
+		}
+		crc := binary.LittleEndian.Uint32(data[pos+1:])
+		payloadLen := binary.LittleEndian.Uint32(data[pos+5:])
+
+		if pos+9+int64(payloadLen) > int64(len(data)) {
+			break
+		}
+
+		payload := data[pos+9 : pos+9+int64(payloadLen)]
+		if crc != crc32.ChecksumIEEE(payload) {
+			break
+		}
+		fn(recType, payload)
+		pos += 9 + int64(payloadLen)
+	}
+}
+
 func (wal *WAL) readCommitted() ([]walEntry, *commitState, error) {
 	fi, err := wal.fp.Stat()
 	if err != nil {
@@ -126,22 +298,7 @@ func (wal *WAL) readCommitted() ([]walEntry, *commitState, error) {
 	committed := map[uint64]bool{}
 	var lastState *commitState
 	txPages := map[uint64][]walEntry{}
-
-	pos := int64(16)
-	for pos+9 <= int64(len(data)) {
-		recType := data[pos]
-		crc := binary.LittleEndian.Uint32(data[pos+1:])
-		payloadLen := binary.LittleEndian.Uint32(data[pos+5:])
-
-		if pos+9+int64(payloadLen) > int64(len(data)) {
-			break
-		}
-
-		payload := data[pos+9 : pos+9+int64(payloadLen)]
-		if crc != crc32.ChecksumIEEE(payload) {
-			break
-		}
-
+	walRecords(data, func(recType byte, payload []byte) {
 		switch recType {
 		case walBeginTX:
 			txID := binary.LittleEndian.Uint64(payload)
@@ -151,28 +308,21 @@ func (wal *WAL) readCommitted() ([]walEntry, *commitState, error) {
 
 		case walPageData:
 			txID := binary.LittleEndian.Uint64(payload)
-			pageNum := binary.LittleEndian.Uint64(payload[8:])
-			pg := make([]byte, btree.PageSize)
-			copy(pg, payload[16:])
-			txPages[txID] = append(txPages[txID], walEntry{pageNum, pg})
+			txPages[txID] = append(txPages[txID], parsePage(payload))
 
 		case walCommitTX:
-			txID := binary.LittleEndian.Uint64(payload)
+			txID, state := parseCommit(payload)
 			committed[txID] = true
-			lastState = &commitState{
-				Root:        binary.LittleEndian.Uint64(payload[8:]),
-				FreeHead:    binary.LittleEndian.Uint64(payload[16:]),
-				PageFlushed: binary.LittleEndian.Uint64(payload[24:]),
-			}
+			lastState = &state
 		}
+	})
 
-		pos += 9 + int64(payloadLen)
-	}
-
+	// Transactions must be applied in commit order so that the last image
+	// of a page written by several of them wins.
 	var entries []walEntry
-	for txID, txEntries := range txPages {
+	for _, txID := range slices.Sorted(maps.Keys(txPages)) {
 		if committed[txID] {
-			entries = append(entries, txEntries...)
+			entries = append(entries, txPages[txID]...)
 		}
 	}
 
@@ -213,6 +363,11 @@ func (wal *WAL) Checkpoint(kv *KV) error {
 }
 
 func (wal *WAL) checkpointApply(kv *KV, entries []walEntry, state *commitState) error {
+	if kv.WALArchive != "" {
+		if err := wal.archive(kv.WALArchive); err != nil {
+			return fmt.Errorf("checkpoint archive: %w", err)
+		}
+	}
 	npages := int(state.PageFlushed)
 	if err := extendFile(kv, npages); err != nil {
 		return fmt.Errorf("checkpoint extend file: %w", err)
@@ -221,16 +376,37 @@ func (wal *WAL) checkpointApply(kv *KV, entries []walEntry, state *commitState)
 		return fmt.Errorf("checkpoint extend mmap: %w", err)
 	}
 
+	if kv.PunchHoles {
+		var ptrs []uint64
+		for _, e := range entries {
+			if e.pageNum < state.PageFlushed {
+				ptrs = append(ptrs, e.pageNum)
+			}
+		}
+		slices.Sort(ptrs)
+		if err := reservePages(kv, ptrs); err != nil {
+			return fmt.Errorf("checkpoint reserve pages: %w", err)
+		}
+	}
+
 	kv.mmapMu.Lock()
 	for _, e := range entries {
+		if e.pageNum >= state.PageFlushed {
+			continue // cut off the file by a later truncation
+		}
 		copy(pageGetMapped(kv.mmap.chunks, e.pageNum).Data, e.data)
 	}
 	kv.mmapMu.Unlock()
 
+	kv.mu.Lock() // Compact checkpoints while transactions run
 	kv.tree.root = state.Root
+	kv.version = max(kv.version, state.Version)
 	kv.free.Head = state.FreeHead
+	kv.mu.Unlock()
 	kv.page.flushed = state.PageFlushed
+	kv.pageAllocMu.Lock()
 	kv.pageAlloc = state.PageFlushed
+	kv.pageAllocMu.Unlock()
 
 	if err := masterStore(kv); err != nil {
 		return fmt.Errorf("checkpoint master store: %w", err)
@@ -257,5 +433,8 @@ func (wal *WAL) reset() error {
 	if _, err := wal.fp.Write(header); err != nil {
 		return err
 	}
+	if wal.direct != nil {
+		return wal.loadTail(int64(len(header)))
+	}
 	return nil
 }
@@ -168,6 +168,28 @@ func TestWALPageDedup(t *testing.T) {
 	is.Equal(t, pg2, entries[0].data)
 }
 
+func TestWALPageDedupCommitOrder(t *testing.T) {
+	wal := newTestWAL(t)
+	defer wal.Close()
+
+	// Many transactions rewrite the same page: the last one must win no
+	// matter how the transactions are grouped internally.
+	var last []byte
+	for tx := uint64(1); tx <= 32; tx++ {
+		pg := make([]byte, btree.PageSize)
+		pg[0] = byte(tx)
+		is.NoError(t, wal.BeginTX(tx))
+		is.NoError(t, wal.PageData(tx, 42, pg))
+		is.NoError(t, wal.CommitTX(tx, commitState{Root: tx, PageFlushed: 50}))
+		last = pg
+	}
+
+	entries, _, err := wal.readCommitted()
+	is.NoError(t, err)
+	is.Len(t, entries, 1)
+	is.Equal(t, last, entries[0].data)
+}
+
 func TestWALReset(t *testing.T) {
 	wal := newTestWAL(t)
 	defer wal.Close()
@@ -213,7 +235,7 @@ func TestWALRecovery(t *testing.T) {
 	// Simulate crash: close without checkpoint (skip the KV.Close that
 	// would normally checkpoint). Instead just close the WAL and file.
 	is.NoError(t, db1.wal.Close())
-	for _, chunk := range db1.mmap.chunks {
+	for _, chunk := range db1.regions() {
 		_ = syscall.Munmap(chunk)
 	}
 	_ = db1.fp.Close()
@@ -0,0 +1,99 @@
+package kv
+
+import (
+	"bytes"
+)
+
+// WatchQueue is the number of events a watch holds for its receiver.
+const WatchQueue = 1024
+
+// Event is a change reported by Watch: Key set to Val, or deleted if Del,
+// by the commit that made Version. Key and Val are shared by every watch
+// and must not be modified.
+type Event struct {
+	Op
+	Version uint64
+}
+
+// watch is an open Watch, sending the changes of keys with its prefix on c.
+type watch struct {
+	prefix []byte
+	c      chan Event
+}
+
+// Watch returns a channel that receives an Event for every key with the
+// given prefix (nil for every key) set or deleted by a commit, after the
+// commit, in commit order. Changes of transactions begun before Watch
+// returned may not be reported, nor those that arrive through Apply.
+//
+// Events are never dropped: a watch whose queue of WatchQueue events is
+// full when a commit comes is closed, and its receiver, having missed
+// changes, should reload what it derived from the store and watch again.
+// Unwatch and Close close the channel too.
+func (kv *KV) Watch(prefix []byte) <-chan Event {
+	w := &watch{prefix: bytes.Clone(prefix), c: make(chan Event, WatchQueue)}
+	kv.watches.mu.Lock()
+	defer kv.watches.mu.Unlock()
+	if kv.watches.subs == nil {
+		kv.watches.subs = map[<-chan Event]*watch{}
+	}
+	kv.watches.subs[w.c] = w
+	kv.watches.n.Add(1)
+	return w.c
+}
+
+// Unwatch closes a channel returned by Watch; events already queued can
+// still be received. Calling it more than once is harmless.
+func (kv *KV) Unwatch(c <-chan Event) {
+	kv.watches.mu.Lock()
+	defer kv.watches.mu.Unlock()
+	kv.closeWatch(c)
+}
+
+// closeWatch closes the watch of c. It runs with watches.mu held.
+func (kv *KV) closeWatch(c <-chan Event) {
+	if w, ok := kv.watches.subs[c]; ok {
+		delete(kv.watches.subs, c)
+		kv.watches.n.Add(-1)
+		close(w.c)
+	}
+}
+
+// closeWatches closes every watch.
+func (kv *KV) closeWatches() {
+	kv.watches.mu.Lock()
+	defer kv.watches.mu.Unlock()
+	for c := range kv.watches.subs {
+		kv.closeWatch(c)
+	}
+}
+
+// watched reports whether the changes of a transaction beginning now are to
+// be recorded for the watches.
+func (kv *KV) watched() bool {
+	return kv.watches.n.Load() > 0
+}
+
+// notifyWatches hands the changes of the commit that made version to the
+// watches. It runs under commitMu, so that events follow commit order.
+func (kv *KV) notifyWatches(ops []Op, version uint64) {
+	if len(ops) == 0 {
+		return
+	}
+	kv.watches.mu.Lock()
+	defer kv.watches.mu.Unlock()
+	for c, w := range kv.watches.subs {
+		for _, op := range ops {
+			if !bytes.HasPrefix(op.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.c <- Event{op, version}:
+				continue
+			default:
+			}
+			kv.closeWatch(c)
+			break
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	db := &KV{Path: filepath.Join(t.TempDir(), "watch.db"), NoSync: true}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	write := func(f func(tx *KVTX)) {
+		tx := KVTX{}
+		db.Begin(&tx)
+		f(&tx)
+		is.NoError(t, db.Commit(&tx))
+	}
+
+	users, all := db.Watch([]byte("user:")), db.Watch(nil)
+	write(func(tx *KVTX) {
+		tx.Update(&btree.InsertReq{Key: []byte("user:1"), Val: []byte("a")})
+		tx.Update(&btree.InsertReq{Key: []byte("item:1"), Val: []byte("b")})
+		tx.Update(&btree.InsertReq{Key: []byte("user:1"), Val: []byte("a")}) // unchanged
+	})
+	write(func(tx *KVTX) {
+		tx.Del(&btree.DeleteReq{Key: []byte("user:1")})
+		tx.Del(&btree.DeleteReq{Key: []byte("user:2")}) // absent
+	})
+	is.Equal(t, Event{Op{Key: []byte("user:1"), Val: []byte("a")}, 1}, <-users)
+	is.Equal(t, Event{Op{Key: []byte("user:1"), Del: true}, 2}, <-users)
+	is.Len(t, users, 0)
+	is.Equal(t, []byte("user:1"), (<-all).Key)
+	is.Equal(t, []byte("item:1"), (<-all).Key)
+	is.True(t, (<-all).Del)
+
+	// An aborted transaction reports nothing.
+	tx := KVTX{}
+	db.Begin(&tx)
+	tx.Update(&btree.InsertReq{Key: []byte("user:3"), Val: []byte("c")})
+	db.Abort(&tx)
+	db.Unwatch(all)
+	_, ok := <-all
+	is.False(t, ok)
+
+	// A watch that falls behind is closed rather than losing events.
+	write(func(tx *KVTX) {
+		for i := range WatchQueue + 1 {
+			tx.Update(&btree.InsertReq{Key: fmt.Appendf(nil, "user:%05d", i), Val: []byte("v")})
+		}
+	})
+	n := 0
+	for range users {
+		n++
+	}
+	is.Equal(t, WatchQueue, n)
+	is.False(t, db.watched())
+}
@@ -0,0 +1,160 @@
+// Package metrics holds the instruments the storage layers count with and
+// writes them in the Prometheus text exposition format, without depending on
+// a Prometheus client library.
+//
+// The layers keep plain atomic counters and Histograms and take snapshots
+// of them (kv.KV.Metrics, tables.DB.Metrics). A Source writes its snapshot
+// to a Writer; Handler serves a Source as a /metrics endpoint:
+//
+//	http.Handle("/metrics", metrics.Handler(db))
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Histogram
+// ---------------------------------------------------------------------------
+
+// Buckets are the upper bounds, in seconds, of the buckets of a Histogram:
+// from 100µs (an unsynced commit) to 10s (a stalled disk).
+var Buckets = [...]float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01,
+	0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Histogram counts durations into Buckets. The zero value is ready
+// to use and safe for concurrent use.
+type Histogram struct {
+	counts [len(Buckets) + 1]atomic.Uint64 // per bucket, the last one is +Inf
+	sum    atomic.Int64                    // nanoseconds
+}
+
+// Observe records one duration.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(Buckets) && d.Seconds() > Buckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// HistogramSnapshot is the state of a Histogram at one point in time.
+type HistogramSnapshot struct {
+	Buckets []float64 // upper bounds in seconds, as in Buckets
+	Counts  []uint64  // observations per bucket, plus one for +Inf
+	Count   uint64    // total observations
+	Sum     time.Duration
+}
+
+// Snapshot returns the current state of h.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{Buckets: Buckets[:], Counts: make([]uint64, len(h.counts))}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	s.Sum = time.Duration(h.sum.Load())
+	return s
+}
+
+// Quantile estimates the q-quantile (0 < q <= 1) of the observations as the
+// upper bound of the bucket it falls in; +Inf if it is beyond the last one.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(s.Count)))
+	var n uint64
+	for i, c := range s.Counts {
+		n += c
+		if n >= rank && i < len(s.Buckets) {
+			return s.Buckets[i]
+		}
+	}
+	return math.Inf(1)
+}
+
+// ---------------------------------------------------------------------------
+// Text exposition format
+// ---------------------------------------------------------------------------
+
+// Source is implemented by the components that expose metrics.
+type Source interface {
+	WriteMetrics(w *Writer)
+}
+
+// Writer writes metrics in the Prometheus text format (version 0.0.4).
+// Errors are sticky and reported by Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{bufio.NewWriter(w)}
+}
+
+func (w *Writer) header(name, help, typ string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Counter writes a monotonically increasing value; name should end in
+// "_total".
+func (w *Writer) Counter(name, help string, v uint64) {
+	w.header(name, help, "counter")
+	fmt.Fprintf(w.w, "%s %d\n", name, v)
+}
+
+// Gauge writes a value that can go up and down.
+func (w *Writer) Gauge(name, help string, v float64) {
+	w.header(name, help, "gauge")
+	fmt.Fprintf(w.w, "%s %s\n", name, formatFloat(v))
+}
+
+// Histogram writes s with cumulative buckets, in seconds.
+func (w *Writer) Histogram(name, help string, s HistogramSnapshot) {
+	w.header(name, help, "histogram")
+	var n uint64
+	for i, c := range s.Counts {
+		n += c
+		le := "+Inf"
+		if i < len(s.Buckets) {
+			le = formatFloat(s.Buckets[i])
+		}
+		fmt.Fprintf(w.w, "%s_bucket{le=%q} %d\n", name, le, n)
+	}
+	fmt.Fprintf(w.w, "%s_sum %s\n", name, formatFloat(s.Sum.Seconds()))
+	fmt.Fprintf(w.w, "%s_count %d\n", name, s.Count)
+}
+
+// Flush writes out buffered data and returns the first error met.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ContentType is the media type of the text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics of src, taking a fresh snapshot per request.
+func Handler(src Source) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", ContentType)
+		w := NewWriter(rw)
+		src.WriteMetrics(w)
+		w.Flush()
+	})
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	is.Zero(t, h.Snapshot().Quantile(0.5))
+	h.Observe(50 * time.Microsecond) // first bucket
+	h.Observe(time.Millisecond)      // on a bound: le="0.001"
+	h.Observe(3 * time.Millisecond)
+	h.Observe(time.Minute) // +Inf
+
+	s := h.Snapshot()
+	is.Equal(t, uint64(4), s.Count)
+	is.Equal(t, time.Minute+4050*time.Microsecond, s.Sum)
+	is.Equal(t, len(Buckets)+1, len(s.Counts))
+	is.Equal(t, uint64(1), s.Counts[0])
+	is.Equal(t, uint64(1), s.Counts[3])
+	is.Equal(t, uint64(1), s.Counts[len(Buckets)])
+	is.Equal(t, 0.0001, s.Quantile(0.25))
+	is.Equal(t, 0.001, s.Quantile(0.5))
+	is.Equal(t, 0.005, s.Quantile(0.75))
+	is.True(t, math.IsInf(s.Quantile(1), 1))
+}
+
+type source struct{}
+
+func (source) WriteMetrics(w *Writer) {
+	w.Counter("x_total", "Some count.", 3)
+	w.Gauge("y", "Some level.", 0.5)
+	var h Histogram
+	h.Observe(2 * time.Second)
+	w.Histogram("z_seconds", "Some latency.", h.Snapshot())
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	source{}.WriteMetrics(w)
+	is.NoError(t, w.Flush())
+	out := buf.String()
+	is.Contains(t, out, "# HELP x_total Some count.\n# TYPE x_total counter\nx_total 3\n")
+	is.Contains(t, out, "# TYPE y gauge\ny 0.5\n")
+	is.Contains(t, out, "# TYPE z_seconds histogram\n")
+	is.Contains(t, out, `z_seconds_bucket{le="1"} 0`+"\n")
+	is.Contains(t, out, `z_seconds_bucket{le="2.5"} 1`+"\n")
+	is.Contains(t, out, `z_seconds_bucket{le="+Inf"} 1`+"\n")
+	is.Contains(t, out, "z_seconds_sum 2\nz_seconds_count 1\n")
+
+	rec := httptest.NewRecorder()
+	Handler(source{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	is.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	is.Equal(t, out, rec.Body.String())
+}
@@ -2,6 +2,8 @@ package network
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -59,6 +61,21 @@ func Dial(addr string) (*Conn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("elkdb dial %s: %w", addr, err)
 	}
+	return newConn(nc), nil
+}
+
+// DialTLS opens a TLS connection to an ElkDB server at addr, verifying it
+// with config (see the tlsconfig package). Unless config sets ServerName,
+// the host part of addr is used.
+func DialTLS(addr string, config *tls.Config) (*Conn, error) {
+	nc, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("elkdb dial %s: %w", addr, err)
+	}
+	return newConn(nc), nil
+}
+
+func newConn(nc net.Conn) *Conn {
 	c := &Conn{
 		conn:       nc,
 		r:          bufio.NewReader(nc),
@@ -67,7 +84,7 @@ func Dial(addr string) (*Conn, error) {
 		readerDone: make(chan struct{}),
 	}
 	go c.readerLoop()
-	return c, nil
+	return c
 }
 
 // Close shuts down the connection and waits for the background reader to
@@ -110,6 +127,54 @@ func (c *Conn) Exec(sql string) (Result, error) {
 	return Result{}, fmt.Errorf("max retries exceeded for OCC conflict")
 }
 
+// ExecContext is Exec with cancellation. If ctx is done before the server
+// answers, a MsgCancel frame is sent so the server stops executing the
+// statement, and ctx.Err() is returned. OCC conflicts are retried as in Exec.
+func (c *Conn) ExecContext(ctx context.Context, sql string) (Result, error) {
+	const maxRetries = 20
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		reqID, ch := c.execAsync(sql)
+		var r ResultWithError
+		select {
+		case r = <-ch:
+		case <-ctx.Done():
+			c.cancel(reqID)
+			return Result{}, ctx.Err()
+		}
+		if r.Err != nil {
+			if attempt < maxRetries-1 && strings.Contains(r.Err.Error(), "serialisation conflict") {
+				continue
+			}
+			return Result{}, r.Err
+		}
+		return r.Result, nil
+	}
+	return Result{}, fmt.Errorf("max retries exceeded for OCC conflict")
+}
+
+// cancel forgets the pending request and asks the server to abort it.
+func (c *Conn) cancel(target uint32) {
+	c.pdMu.Lock()
+	if c.pending != nil {
+		delete(c.pending, target)
+	}
+	c.pdMu.Unlock()
+
+	reqID := atomic.AddUint32(&c.nextID, 1)
+	c.wmu.Lock()
+	_ = SendCancel(c.conn, reqID, target)
+	c.wmu.Unlock()
+}
+
+// Auth authenticates the connection as user name. A server started with
+// RequireAuth serves nothing else until it succeeds.
+func (c *Conn) Auth(name, password string) error {
+	_, ch := c.request("send auth", func(reqID uint32) error {
+		return SendAuth(c.conn, reqID, name, password)
+	})
+	return (<-ch).Err
+}
+
 // Ping checks that the server is reachable.
 func (c *Conn) Ping() error {
 	ch := c.PingAsync()
@@ -125,31 +190,30 @@ func (c *Conn) Ping() error {
 // receive the result (or error) when the server responds. The channel is
 // buffered (cap 1) so a single receive is sufficient.
 func (c *Conn) ExecAsync(sql string) <-chan ResultWithError {
-	ch := make(chan ResultWithError, 1)
-	reqID := atomic.AddUint32(&c.nextID, 1)
-	readOnly := isSelect(sql)
-
-	c.pdMu.Lock()
-	c.pending[reqID] = ch
-	c.pdMu.Unlock()
-
-	c.wmu.Lock()
-	err := SendQuery(c.conn, reqID, sql, readOnly)
-	c.wmu.Unlock()
-
-	if err != nil {
-		c.pdMu.Lock()
-		delete(c.pending, reqID)
-		c.pdMu.Unlock()
-		ch <- ResultWithError{Err: fmt.Errorf("send query: %w", err)}
-	}
-
+	_, ch := c.execAsync(sql)
 	return ch
 }
 
+func (c *Conn) execAsync(sql string) (uint32, <-chan ResultWithError) {
+	readOnly := isSelect(sql)
+	return c.request("send query", func(reqID uint32) error {
+		return SendQuery(c.conn, reqID, sql, readOnly)
+	})
+}
+
 // PingAsync sends a ping to the server and returns a channel that will receive
 // the result (nil error on success) when the server responds.
 func (c *Conn) PingAsync() <-chan ResultWithError {
+	_, ch := c.request("send ping", func(reqID uint32) error {
+		return SendPing(c.conn, reqID)
+	})
+	return ch
+}
+
+// request registers a pending response channel under a fresh reqID and
+// writes the request frame with send. Send failures are delivered on the
+// channel, wrapped with what.
+func (c *Conn) request(what string, send func(reqID uint32) error) (uint32, <-chan ResultWithError) {
 	ch := make(chan ResultWithError, 1)
 	reqID := atomic.AddUint32(&c.nextID, 1)
 
@@ -158,17 +222,55 @@ func (c *Conn) PingAsync() <-chan ResultWithError {
 	c.pdMu.Unlock()
 
 	c.wmu.Lock()
-	err := SendPing(c.conn, reqID)
+	err := send(reqID)
 	c.wmu.Unlock()
 
 	if err != nil {
 		c.pdMu.Lock()
 		delete(c.pending, reqID)
 		c.pdMu.Unlock()
-		ch <- ResultWithError{Err: fmt.Errorf("send ping: %w", err)}
+		ch <- ResultWithError{Err: fmt.Errorf("%s: %w", what, err)}
 	}
 
-	return ch
+	return reqID, ch
+}
+
+// ---------------------------------------------------------------------------
+// Paged results
+// ---------------------------------------------------------------------------
+
+// ExecPaged sends a SQL string asking for at most pageSize rows in the
+// response. If the result has more rows, Result.Cursor is non-zero and the
+// remaining rows are read with Fetch. Cursors left idle expire on the server;
+// call CloseCursor to release one early.
+func (c *Conn) ExecPaged(sql string, pageSize int) (Result, error) {
+	if pageSize <= 0 {
+		return Result{}, fmt.Errorf("page size must be positive")
+	}
+	readOnly := isSelect(sql)
+	_, ch := c.request("send query", func(reqID uint32) error {
+		return SendQueryPaged(c.conn, reqID, sql, readOnly, uint32(pageSize))
+	})
+	r := <-ch
+	return r.Result, r.Err
+}
+
+// Fetch returns the next page of cursor. pageSize 0 keeps the page size the
+// cursor was opened with.
+func (c *Conn) Fetch(cursor uint64, pageSize int) (Result, error) {
+	_, ch := c.request("send fetch", func(reqID uint32) error {
+		return SendFetch(c.conn, reqID, cursor, uint32(max(pageSize, 0)))
+	})
+	r := <-ch
+	return r.Result, r.Err
+}
+
+// CloseCursor releases a cursor on the server before it is drained.
+func (c *Conn) CloseCursor(cursor uint64) error {
+	reqID := atomic.AddUint32(&c.nextID, 1)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return SendCloseCursor(c.conn, reqID, cursor)
 }
 
 // ---------------------------------------------------------------------------
@@ -248,6 +350,9 @@ func (c *Conn) readerLoop() {
 			case MsgResult:
 				res, err := decodeResult(rr.payload)
 				ch <- ResultWithError{Result: res, Err: err}
+			case MsgPage:
+				res, err := decodePage(rr.payload)
+				ch <- ResultWithError{Result: res, Err: err}
 			case MsgError:
 				msg, err := parseErrorPayload(rr.payload)
 				if err != nil {
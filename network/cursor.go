@@ -0,0 +1,162 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Server-side cursors
+// ---------------------------------------------------------------------------
+
+// DefaultCursorTTL is how long an idle cursor survives when Server.CursorTTL
+// is zero.
+const DefaultCursorTTL = time.Minute
+
+// DefaultMaxCursors and DefaultMaxCursorBytes bound the open cursors of a
+// server when Server.MaxCursors and Server.MaxCursorBytes are zero.
+const (
+	DefaultMaxCursors     = 1024
+	DefaultMaxCursorBytes = 16 << 20
+)
+
+// errTooManyCursors is returned when opening a cursor would exceed the
+// server's cursor limits.
+var errTooManyCursors = errors.New("too many open cursors")
+
+// cursor is the position of a paged SELECT between two pages: no rows are
+// kept, each page runs the statement again from the token of the last row
+// sent (see queries.SelectPage).
+type cursor struct {
+	conn     uint64 // the connection that opened it
+	sql      string
+	token    string // position after the last row sent
+	returned int    // rows sent so far
+	pageSize int
+	expires  time.Time
+}
+
+// size is what the cursor counts against Server.MaxCursorBytes.
+func (cur *cursor) size() int {
+	return len(cur.sql) + len(cur.token)
+}
+
+// cursorStore is the server-wide table of open cursors. Cursor IDs are random
+// 64-bit tokens, and a cursor serves only the connection that opened it.
+// Cursors are dropped once drained, explicitly closed, idle past their TTL,
+// or when their connection closes.
+type cursorStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	max      int // open cursors at most
+	maxBytes int // bytes of cursor state at most
+	bytes    int
+	cursors  map[uint64]*cursor
+}
+
+func newCursorStore(ttl time.Duration, max, maxBytes int) *cursorStore {
+	if ttl <= 0 {
+		ttl = DefaultCursorTTL
+	}
+	if max <= 0 {
+		max = DefaultMaxCursors
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxCursorBytes
+	}
+	return &cursorStore{ttl: ttl, max: max, maxBytes: maxBytes, cursors: map[uint64]*cursor{}}
+}
+
+// open registers cur and returns its ID, or errTooManyCursors.
+func (cs *cursorStore) open(cur cursor) (uint64, error) {
+	now := time.Now()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.reapLocked(now)
+	if len(cs.cursors) >= cs.max || cs.bytes+cur.size() > cs.maxBytes {
+		return 0, errTooManyCursors
+	}
+
+	id := newCursorID()
+	for cs.cursors[id] != nil {
+		id = newCursorID()
+	}
+	cur.expires = now.Add(cs.ttl)
+	cs.cursors[id] = &cur
+	cs.bytes += cur.size()
+	return id, nil
+}
+
+// get returns a copy of cursor id, if connection conn opened it.
+func (cs *cursorStore) get(conn, id uint64) (cursor, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.reapLocked(time.Now())
+	cur, ok := cs.cursors[id]
+	if !ok || cur.conn != conn {
+		return cursor{}, false
+	}
+	return *cur, true
+}
+
+// advance records that n more rows of cursor id were sent, the last of them
+// at token.
+func (cs *cursorStore) advance(id uint64, token string, n int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cur, ok := cs.cursors[id]
+	if !ok {
+		return
+	}
+	cs.bytes -= cur.size()
+	cur.token = token
+	cur.returned += n
+	cur.expires = time.Now().Add(cs.ttl)
+	cs.bytes += cur.size()
+}
+
+// close drops cursor id of connection conn. Unknown IDs are ignored.
+func (cs *cursorStore) close(conn, id uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cur, ok := cs.cursors[id]; ok && cur.conn == conn {
+		cs.dropLocked(id)
+	}
+}
+
+// closeConn drops the cursors of connection conn.
+func (cs *cursorStore) closeConn(conn uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for id, cur := range cs.cursors {
+		if cur.conn == conn {
+			cs.dropLocked(id)
+		}
+	}
+}
+
+func (cs *cursorStore) reapLocked(now time.Time) {
+	for id, cur := range cs.cursors {
+		if now.After(cur.expires) {
+			cs.dropLocked(id)
+		}
+	}
+}
+
+func (cs *cursorStore) dropLocked(id uint64) {
+	cs.bytes -= cs.cursors[id].size()
+	delete(cs.cursors, id)
+}
+
+func newCursorID() uint64 {
+	var buf [8]byte
+	_, _ = rand.Read(buf[:])
+	id := binary.BigEndian.Uint64(buf[:])
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
@@ -1,7 +1,15 @@
 package network_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"math/big"
 	"net"
 	"os"
 	"path/filepath"
@@ -310,6 +318,20 @@ func TestProtoRoundtrip(t *testing.T) {
 						{Type: table.TypeBytes, Str: []byte("bob")},
 					},
 				},
+				{
+					Cols: []string{"id", "score"},
+					Vals: []table.Value{
+						{Type: table.TypeInt64, I64: 2},
+						{Type: table.TypeFloat64, F64: -2.75},
+					},
+				},
+				{
+					Cols: []string{"id", "uuid"},
+					Vals: []table.Value{
+						{Type: table.TypeInt64, I64: 3},
+						{Type: table.TypeUUID, Str: []byte("0123456789abcdef")},
+					},
+				},
 			},
 		}
 
@@ -355,7 +377,11 @@ func TestProtoRoundtrip(t *testing.T) {
 					if got.I64 != want.I64 {
 						t.Errorf("row %d col %d int64: got %d, want %d", i, j, got.I64, want.I64)
 					}
-				case table.TypeBytes:
+				case table.TypeFloat64:
+					if got.F64 != want.F64 {
+						t.Errorf("row %d col %d float64: got %g, want %g", i, j, got.F64, want.F64)
+					}
+				case table.TypeBytes, table.TypeUUID:
 					if string(got.Str) != string(want.Str) {
 						t.Errorf("row %d col %d bytes: got %q, want %q", i, j, got.Str, want.Str)
 					}
@@ -427,6 +453,68 @@ func TestProtoRoundtrip(t *testing.T) {
 			t.Errorf("readOnly: got false, want true")
 		}
 	})
+
+	t.Run("page_encode_decode", func(t *testing.T) {
+		var buf safeBuffer
+		rec := table.Record{}
+		rec.AddInt64("id", 7)
+		if err := network.SendPage(&buf, 3, network.Result{Rows: []table.Record{rec}, Cursor: 42}); err != nil {
+			t.Fatalf("SendPage: %v", err)
+		}
+		frame, err := network.ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		if frame.MsgType != network.MsgPage {
+			t.Fatalf("MsgType: got 0x%02x, want 0x%02x", frame.MsgType, network.MsgPage)
+		}
+		res, err := network.ReadPage(&buf, frame.PayloadLen)
+		if err != nil {
+			t.Fatalf("ReadPage: %v", err)
+		}
+		if res.Cursor != 42 || len(res.Rows) != 1 || res.Rows[0].Get("id").I64 != 7 {
+			t.Errorf("page roundtrip mismatch: %+v", res)
+		}
+	})
+
+	t.Run("paged_query_encode_decode", func(t *testing.T) {
+		var buf safeBuffer
+		if err := network.SendQueryPaged(&buf, 4, "SELECT * FROM t", true, 50); err != nil {
+			t.Fatalf("SendQueryPaged: %v", err)
+		}
+		frame, err := network.ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		q, err := network.ReadQueryRequest(&buf, frame.PayloadLen)
+		if err != nil {
+			t.Fatalf("ReadQueryRequest: %v", err)
+		}
+		if q.SQL != "SELECT * FROM t" || !q.ReadOnly || q.PageSize != 50 {
+			t.Errorf("query roundtrip mismatch: %+v", q)
+		}
+	})
+
+	t.Run("cancel_encode_decode", func(t *testing.T) {
+		var buf safeBuffer
+		if err := network.SendCancel(&buf, 8, 5); err != nil {
+			t.Fatalf("SendCancel: %v", err)
+		}
+		frame, err := network.ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		if frame.MsgType != network.MsgCancel {
+			t.Fatalf("MsgType: got 0x%02x, want 0x%02x", frame.MsgType, network.MsgCancel)
+		}
+		target, err := network.ReadCancel(&buf, frame.PayloadLen)
+		if err != nil {
+			t.Fatalf("ReadCancel: %v", err)
+		}
+		if target != 5 {
+			t.Errorf("target: got %d, want 5", target)
+		}
+	})
 }
 
 func TestConcurrentClients(t *testing.T) {
@@ -740,6 +828,233 @@ func TestMultiplex_ConcurrentInserts(t *testing.T) {
 	requireRowCount(t, res, 5)
 }
 
+// ---------------------------------------------------------------------------
+// Cancellation tests
+// ---------------------------------------------------------------------------
+
+func TestExecContext_CancelInFlight(t *testing.T) {
+	conn, cleanup := startServer(t)
+	defer cleanup()
+
+	mustExec(t, conn, `CREATE TABLE big (id INT, v INT, PRIMARY KEY (id));`)
+	for i := 0; i < 300; i++ {
+		mustExec(t, conn, fmt.Sprintf(`INSERT INTO big (id, v) VALUES (%d, %d);`, i, i))
+	}
+
+	// A self-join large enough that it is still running when the deadline hits.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err := conn.ExecContext(ctx, `SELECT * FROM big a JOIN big b ON a.v >= b.v;`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ExecContext: got %v, want deadline exceeded", err)
+	}
+
+	// The connection stays usable after a cancel.
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("Ping after cancel: %v", err)
+	}
+	res := mustExec(t, conn, `SELECT * FROM big WHERE id == 7;`)
+	requireRowCount(t, res, 1)
+}
+
+func TestCancelFrame_AbortsQuery(t *testing.T) {
+	conn, cleanup := startServer(t)
+	defer cleanup()
+	mustExec(t, conn, `CREATE TABLE big2 (id INT, v INT, PRIMARY KEY (id));`)
+	for i := 0; i < 300; i++ {
+		mustExec(t, conn, fmt.Sprintf(`INSERT INTO big2 (id, v) VALUES (%d, %d);`, i, i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.ExecContext(ctx, `SELECT * FROM big2 a JOIN big2 b ON a.v >= b.v JOIN big2 c ON c.id == a.id;`)
+		done <- err
+	}()
+	time.Sleep(2 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecContext did not return after cancel")
+	}
+	res := mustExec(t, conn, `SELECT * FROM big2 WHERE id == 1;`)
+	requireRowCount(t, res, 1)
+}
+
+// ---------------------------------------------------------------------------
+// Pagination tests
+// ---------------------------------------------------------------------------
+
+func TestPaged_FetchAll(t *testing.T) {
+	conn, cleanup := startServer(t)
+	defer cleanup()
+
+	mustExec(t, conn, `CREATE TABLE pg (id INT, PRIMARY KEY (id));`)
+	for i := 0; i < 25; i++ {
+		mustExec(t, conn, fmt.Sprintf(`INSERT INTO pg (id) VALUES (%d);`, i))
+	}
+
+	res, err := conn.ExecPaged(`SELECT * FROM pg;`, 10)
+	if err != nil {
+		t.Fatalf("ExecPaged: %v", err)
+	}
+	requireRowCount(t, res, 10)
+	if res.Cursor == 0 {
+		t.Fatal("expected a cursor for the remaining rows")
+	}
+
+	var ids []int64
+	for _, row := range res.Rows {
+		ids = append(ids, row.Get("id").I64)
+	}
+	// The cursor holds a position, not rows: later pages see rows
+	// committed in between.
+	mustExec(t, conn, `INSERT INTO pg (id) VALUES (25);`)
+	cursor := res.Cursor
+	for cursor != 0 {
+		page, err := conn.Fetch(cursor, 0)
+		if err != nil {
+			t.Fatalf("Fetch: %v", err)
+		}
+		if len(page.Rows) > 10 {
+			t.Fatalf("page too large: %d rows", len(page.Rows))
+		}
+		for _, row := range page.Rows {
+			ids = append(ids, row.Get("id").I64)
+		}
+		cursor = page.Cursor
+	}
+	if len(ids) != 26 {
+		t.Fatalf("got %d rows, want 26", len(ids))
+	}
+	for i, id := range ids {
+		if id != int64(i) {
+			t.Fatalf("row %d: got id %d", i, id)
+		}
+	}
+
+	// A drained cursor is gone.
+	if _, err := conn.Fetch(res.Cursor, 0); err == nil {
+		t.Error("Fetch on drained cursor: expected error")
+	}
+
+	// Small results come back in a single page without a cursor.
+	res, err = conn.ExecPaged(`SELECT * FROM pg WHERE id < 3;`, 10)
+	if err != nil {
+		t.Fatalf("ExecPaged: %v", err)
+	}
+	requireRowCount(t, res, 3)
+	if res.Cursor != 0 {
+		t.Errorf("unexpected cursor %d", res.Cursor)
+	}
+
+	// A join has no position to resume from: it comes back whole.
+	res, err = conn.ExecPaged(`SELECT * FROM pg JOIN pg AS b ON pg.id == b.id;`, 10)
+	if err != nil {
+		t.Fatalf("ExecPaged join: %v", err)
+	}
+	requireRowCount(t, res, 26)
+	if res.Cursor != 0 {
+		t.Errorf("unexpected cursor %d", res.Cursor)
+	}
+}
+
+func TestPaged_Limits(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cursors.db")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("find free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	srv := &network.Server{Addr: addr, DBPath: dbPath, MaxCursors: 2}
+	go func() { _ = srv.ListenAndServe() }()
+
+	dial := func() *network.Conn {
+		t.Helper()
+		deadline := time.Now().Add(500 * time.Millisecond)
+		for {
+			conn, err := network.Dial(addr)
+			if err == nil {
+				return conn
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("dial server: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	c1 := dial()
+	defer c1.Close()
+	c2 := dial()
+	defer c2.Close()
+
+	mustExec(t, c1, `CREATE TABLE pg3 (id INT, PRIMARY KEY (id));`)
+	for i := 0; i < 5; i++ {
+		mustExec(t, c1, fmt.Sprintf(`INSERT INTO pg3 (id) VALUES (%d);`, i))
+	}
+	var cursors []uint64
+	for range 2 {
+		res, err := c1.ExecPaged(`SELECT * FROM pg3;`, 2)
+		if err != nil {
+			t.Fatalf("ExecPaged: %v", err)
+		}
+		cursors = append(cursors, res.Cursor)
+	}
+	if _, err := c2.ExecPaged(`SELECT * FROM pg3;`, 2); err == nil || err.Error() != "too many open cursors" {
+		t.Fatalf("ExecPaged past MaxCursors: got %v", err)
+	}
+	// A cursor serves only the connection that opened it.
+	if _, err := c2.Fetch(cursors[0], 0); err == nil {
+		t.Error("Fetch of another connection's cursor: expected error")
+	}
+	c2.CloseCursor(cursors[0])
+	if _, err := c1.Fetch(cursors[0], 0); err != nil {
+		t.Errorf("Fetch after another connection's CloseCursor: %v", err)
+	}
+
+	// Closing the connection closes its cursors.
+	c1.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		res, err := c2.ExecPaged(`SELECT * FROM pg3;`, 2)
+		if err == nil {
+			if res.Cursor == 0 {
+				t.Fatal("expected a cursor")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ExecPaged after the owner disconnected: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPaged_CloseCursor(t *testing.T) {
+	conn, cleanup := startServer(t)
+	defer cleanup()
+
+	mustExec(t, conn, `CREATE TABLE pg2 (id INT, PRIMARY KEY (id));`)
+	for i := 0; i < 5; i++ {
+		mustExec(t, conn, fmt.Sprintf(`INSERT INTO pg2 (id) VALUES (%d);`, i))
+	}
+	res, err := conn.ExecPaged(`SELECT * FROM pg2;`, 2)
+	if err != nil {
+		t.Fatalf("ExecPaged: %v", err)
+	}
+	if err := conn.CloseCursor(res.Cursor); err != nil {
+		t.Fatalf("CloseCursor: %v", err)
+	}
+	if _, err := conn.Fetch(res.Cursor, 0); err == nil {
+		t.Error("Fetch on closed cursor: expected error")
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Async API tests
 // ---------------------------------------------------------------------------
@@ -840,6 +1155,153 @@ func TestAsync_ErrorPropagation(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// Authentication
+// ---------------------------------------------------------------------------
+
+func TestAuth(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db := &table.DB{Path: dbPath}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BootstrapAdmin("admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("find free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	srv := &network.Server{Addr: addr, DBPath: dbPath, RequireAuth: true}
+	go func() { _ = srv.ListenAndServe() }()
+
+	var conn *network.Conn
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		conn, err = network.Dial(addr)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial server: %v", err)
+	}
+	defer conn.Close()
+
+	// Pings are answered, anything else needs a user.
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if _, err := conn.Exec("CREATE TABLE t (id INT, PRIMARY KEY (id));"); err == nil || err.Error() != "authentication required" {
+		t.Fatalf("Exec before Auth: got %v", err)
+	}
+	if err := conn.Auth("admin", "wrong"); err == nil {
+		t.Fatal("Auth with a wrong password succeeded")
+	}
+	if err := conn.Auth("admin", "secret"); err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	mustExec(t, conn, "CREATE TABLE t (id INT, PRIMARY KEY (id));")
+}
+
+// ---------------------------------------------------------------------------
+// TLS
+// ---------------------------------------------------------------------------
+
+// selfSigned returns a certificate for 127.0.0.1 that is its own CA, valid
+// for both server and client authentication, and a pool trusting it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	cert, pool := selfSigned(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("find free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := &network.Server{
+		Addr:   addr,
+		DBPath: filepath.Join(t.TempDir(), "test.db"),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}
+	go func() { _ = srv.ListenAndServe() }()
+
+	client := &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	var conn *network.Conn
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		conn, err = network.DialTLS(addr, client)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial server: %v", err)
+	}
+	defer conn.Close()
+	mustExec(t, conn, "CREATE TABLE t (id INT, name TEXT, PRIMARY KEY (id));")
+	requireAffected(t, mustExec(t, conn, "INSERT INTO t (id, name) VALUES (1, 'a');"), 1)
+
+	// Without a client certificate the handshake fails, which a TLS 1.3
+	// client only learns on its first round trip.
+	anon, err := network.DialTLS(addr, &tls.Config{RootCAs: pool})
+	if err == nil {
+		err = anon.Ping()
+		anon.Close()
+	}
+	if err == nil {
+		t.Fatal("connected without a client certificate")
+	}
+	// A plain TCP client gets no answer it can read.
+	plain, err := network.Dial(addr)
+	if err != nil {
+		t.Fatalf("dial server: %v", err)
+	}
+	defer plain.Close()
+	if err := plain.Ping(); err == nil {
+		t.Fatal("plain TCP ping succeeded on a TLS server")
+	}
+}
+
 // ---------------------------------------------------------------------------
 // safeBuffer — an in-memory io.ReadWriter safe for use in codec unit tests
 // ---------------------------------------------------------------------------
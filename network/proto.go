@@ -15,6 +15,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 
 	table "github.com/MHS-20/ElkDB/tables"
 )
@@ -25,13 +26,18 @@ import (
 
 const (
 	// Client → Server
-	MsgQuery byte = 0x01
-	MsgPing  byte = 0x02
+	MsgQuery       byte = 0x01
+	MsgPing        byte = 0x02
+	MsgCancel      byte = 0x03
+	MsgFetch       byte = 0x04
+	MsgCloseCursor byte = 0x05
+	MsgAuth        byte = 0x06
 
 	// Server → Client
 	MsgResult byte = 0x81
 	MsgError  byte = 0x82
 	MsgPong   byte = 0x83
+	MsgPage   byte = 0x84
 )
 
 // QueryFlag bits sent in the first byte of a MsgQuery payload.
@@ -39,6 +45,10 @@ const (
 	// FlagReadOnly is a hint that the query is a SELECT; the server may open
 	// a read-only transaction for it.
 	FlagReadOnly byte = 0x01
+	// FlagPaged asks the server to answer with a MsgPage of at most the
+	// page size that follows the SQL string, keeping the remaining rows in a
+	// server-side cursor.
+	FlagPaged byte = 0x02
 )
 
 // ---------------------------------------------------------------------------
@@ -81,14 +91,44 @@ func readHeader(r io.Reader) (header, error) {
 
 // SendQuery writes a MsgQuery frame to w.
 func SendQuery(w io.Writer, reqID uint32, sql string, readOnly bool) error {
+	return sendQuery(w, reqID, QueryRequest{SQL: sql, ReadOnly: readOnly})
+}
+
+// SendQueryPaged writes a MsgQuery frame with FlagPaged set. The server
+// answers with a MsgPage holding at most pageSize rows.
+func SendQueryPaged(w io.Writer, reqID uint32, sql string, readOnly bool, pageSize uint32) error {
+	return sendQuery(w, reqID, QueryRequest{SQL: sql, ReadOnly: readOnly, PageSize: pageSize})
+}
+
+// QueryRequest is the decoded payload of a MsgQuery frame.
+//
+// Query payload layout:
+//
+//	uint8    flags
+//	uint32   sql_len
+//	[]byte   sql
+//	if flags & FlagPaged:  uint32 page_size
+type QueryRequest struct {
+	SQL      string
+	ReadOnly bool
+	PageSize uint32 // 0 = unpaged
+}
+
+func sendQuery(w io.Writer, reqID uint32, q QueryRequest) error {
 	var flags byte
-	if readOnly {
+	if q.ReadOnly {
 		flags |= FlagReadOnly
 	}
-	payload := make([]byte, 1+4+len(sql))
+	if q.PageSize > 0 {
+		flags |= FlagPaged
+	}
+	payload := make([]byte, 5, 1+4+len(q.SQL)+4)
 	payload[0] = flags
-	binary.BigEndian.PutUint32(payload[1:5], uint32(len(sql)))
-	copy(payload[5:], sql)
+	binary.BigEndian.PutUint32(payload[1:5], uint32(len(q.SQL)))
+	payload = append(payload, q.SQL...)
+	if q.PageSize > 0 {
+		payload = appendUint32(payload, q.PageSize)
+	}
 
 	if err := writeHeader(w, header{MsgQuery, reqID, uint32(len(payload))}); err != nil {
 		return err
@@ -100,19 +140,40 @@ func SendQuery(w io.Writer, reqID uint32, sql string, readOnly bool) error {
 // ReadQuery reads the payload of a MsgQuery frame (after the header has
 // already been consumed). Returns (sql, readOnly, error).
 func ReadQuery(r io.Reader, payloadLen uint32) (string, bool, error) {
+	q, err := ReadQueryRequest(r, payloadLen)
+	return q.SQL, q.ReadOnly, err
+}
+
+// ReadQueryRequest reads the payload of a MsgQuery frame (after the header),
+// including the page size of paged queries.
+func ReadQueryRequest(r io.Reader, payloadLen uint32) (QueryRequest, error) {
 	payload := make([]byte, payloadLen)
 	if _, err := io.ReadFull(r, payload); err != nil {
-		return "", false, err
+		return QueryRequest{}, err
 	}
 	if len(payload) < 5 {
-		return "", false, fmt.Errorf("query payload too short")
+		return QueryRequest{}, fmt.Errorf("query payload too short")
 	}
 	flags := payload[0]
 	sqlLen := binary.BigEndian.Uint32(payload[1:5])
 	if uint32(len(payload)) < 5+sqlLen {
-		return "", false, fmt.Errorf("query payload truncated")
+		return QueryRequest{}, fmt.Errorf("query payload truncated")
 	}
-	return string(payload[5 : 5+sqlLen]), flags&FlagReadOnly != 0, nil
+	q := QueryRequest{
+		SQL:      string(payload[5 : 5+sqlLen]),
+		ReadOnly: flags&FlagReadOnly != 0,
+	}
+	if flags&FlagPaged != 0 {
+		rest := payload[5+sqlLen:]
+		if len(rest) < 4 {
+			return QueryRequest{}, fmt.Errorf("query page size truncated")
+		}
+		q.PageSize = binary.BigEndian.Uint32(rest[0:4])
+		if q.PageSize == 0 {
+			return QueryRequest{}, fmt.Errorf("query page size must be positive")
+		}
+	}
+	return q, nil
 }
 
 // ---------------------------------------------------------------------------
@@ -125,6 +186,9 @@ func ReadQuery(r io.Reader, payloadLen uint32) (string, bool, error) {
 type Result struct {
 	Affected int
 	Rows     []table.Record
+	// Cursor is set on paged results when more rows remain on the server;
+	// pass it to Conn.Fetch to read the next page. 0 means no more rows.
+	Cursor uint64
 }
 
 // SendResult writes a MsgResult frame to w.
@@ -138,8 +202,10 @@ type Result struct {
 //	  for each col:
 //	    uint8    name_len
 //	    []byte   name
-//	    uint8    type  (1=int64, 2=bytes)
+//	    uint8    type  (1=int64, 2=bytes, 3=float64, 4=uuid)
 //	    if int64:  int64  (8 bytes, big-endian)
+//	    if float64: IEEE 754 bits (8 bytes, big-endian)
+//	    if uuid:   16 bytes
 //	    if bytes:  uint32 len + []byte data
 func SendResult(w io.Writer, reqID uint32, res Result) error {
 	payload := encodeResult(res)
@@ -168,6 +234,12 @@ func encodeResult(res Result) []byte {
 			case table.TypeInt64:
 				buf = append(buf, 0x01)
 				buf = appendInt64(buf, val.I64)
+			case table.TypeFloat64:
+				buf = append(buf, 0x03)
+				buf = appendInt64(buf, int64(math.Float64bits(val.F64)))
+			case table.TypeUUID:
+				buf = append(buf, 0x04)
+				buf = append(buf, val.Str...)
 			case table.TypeBytes:
 				buf = append(buf, 0x02)
 				buf = appendUint32(buf, uint32(len(val.Str)))
@@ -253,6 +325,21 @@ func decodeResult(payload []byte) (Result, error) {
 				copy(b, payload[:dataLen])
 				row.Vals[j] = table.Value{Type: table.TypeBytes, Str: b}
 				payload = payload[dataLen:]
+			case 0x03: // float64
+				if len(payload) < 8 {
+					return Result{}, fmt.Errorf("truncated float64")
+				}
+				row.Vals[j] = table.Value{
+					Type: table.TypeFloat64,
+					F64:  math.Float64frombits(binary.BigEndian.Uint64(payload[:8])),
+				}
+				payload = payload[8:]
+			case 0x04: // uuid
+				if len(payload) < 16 {
+					return Result{}, fmt.Errorf("truncated uuid")
+				}
+				row.Vals[j] = table.Value{Type: table.TypeUUID, Str: append([]byte(nil), payload[:16]...)}
+				payload = payload[16:]
 			default:
 				return Result{}, fmt.Errorf("unknown value type: 0x%02x", typ)
 			}
@@ -262,6 +349,169 @@ func decodeResult(payload []byte) (Result, error) {
 	return Result{Affected: int(affected), Rows: rows}, nil
 }
 
+// ---------------------------------------------------------------------------
+// SendPage / ReadPage
+// ---------------------------------------------------------------------------
+
+// SendPage writes a MsgPage frame to w: one page of a paged result.
+//
+// Page payload layout:
+//
+//	uint64   cursor_id  (0 = last page)
+//	...      Result payload (see SendResult)
+func SendPage(w io.Writer, reqID uint32, res Result) error {
+	payload := make([]byte, 8, 64)
+	binary.BigEndian.PutUint64(payload, res.Cursor)
+	payload = append(payload, encodeResult(res)...)
+	if err := writeHeader(w, header{MsgPage, reqID, uint32(len(payload))}); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadPage reads the payload of a MsgPage frame (after the header).
+func ReadPage(r io.Reader, payloadLen uint32) (Result, error) {
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Result{}, err
+	}
+	return decodePage(payload)
+}
+
+func decodePage(payload []byte) (Result, error) {
+	if len(payload) < 8 {
+		return Result{}, fmt.Errorf("page payload too short")
+	}
+	res, err := decodeResult(payload[8:])
+	res.Cursor = binary.BigEndian.Uint64(payload[0:8])
+	return res, err
+}
+
+// ---------------------------------------------------------------------------
+// SendFetch / ReadFetch, SendCloseCursor / ReadCloseCursor
+// ---------------------------------------------------------------------------
+
+// SendFetch writes a MsgFetch frame asking for the next page of a cursor.
+// pageSize 0 keeps the page size the cursor was opened with. The server
+// answers with a MsgPage, or a MsgError if the cursor is unknown or expired.
+//
+// Fetch payload layout:
+//
+//	uint64   cursor_id
+//	uint32   page_size
+func SendFetch(w io.Writer, reqID uint32, cursor uint64, pageSize uint32) error {
+	var payload [12]byte
+	binary.BigEndian.PutUint64(payload[0:8], cursor)
+	binary.BigEndian.PutUint32(payload[8:12], pageSize)
+	if err := writeHeader(w, header{MsgFetch, reqID, uint32(len(payload))}); err != nil {
+		return err
+	}
+	_, err := w.Write(payload[:])
+	return err
+}
+
+// ReadFetch reads the payload of a MsgFetch frame (after the header).
+func ReadFetch(r io.Reader, payloadLen uint32) (uint64, uint32, error) {
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, err
+	}
+	if len(payload) < 12 {
+		return 0, 0, fmt.Errorf("fetch payload too short")
+	}
+	return binary.BigEndian.Uint64(payload[0:8]), binary.BigEndian.Uint32(payload[8:12]), nil
+}
+
+// SendCloseCursor writes a MsgCloseCursor frame releasing a cursor before it
+// is drained. The server does not answer it.
+//
+// CloseCursor payload layout:
+//
+//	uint64   cursor_id
+func SendCloseCursor(w io.Writer, reqID uint32, cursor uint64) error {
+	var payload [8]byte
+	binary.BigEndian.PutUint64(payload[:], cursor)
+	if err := writeHeader(w, header{MsgCloseCursor, reqID, uint32(len(payload))}); err != nil {
+		return err
+	}
+	_, err := w.Write(payload[:])
+	return err
+}
+
+// ReadCloseCursor reads the payload of a MsgCloseCursor frame.
+func ReadCloseCursor(r io.Reader, payloadLen uint32) (uint64, error) {
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, err
+	}
+	if len(payload) < 8 {
+		return 0, fmt.Errorf("close cursor payload too short")
+	}
+	return binary.BigEndian.Uint64(payload[0:8]), nil
+}
+
+// ---------------------------------------------------------------------------
+// SendAuth / ReadAuth
+// ---------------------------------------------------------------------------
+
+// maxAuthPayload bounds a MsgAuth payload, which the server reads before the
+// client is trusted.
+const maxAuthPayload = 4096
+
+// SendAuth writes a MsgAuth frame carrying a user's credentials. The server
+// answers with an empty MsgResult, or a MsgError if they are rejected.
+//
+// Auth payload layout:
+//
+//	uint16   name_len
+//	[]byte   name
+//	uint16   password_len
+//	[]byte   password
+func SendAuth(w io.Writer, reqID uint32, name, password string) error {
+	if len(name)+len(password)+4 > maxAuthPayload {
+		return fmt.Errorf("credentials too long")
+	}
+	payload := appendUint16(nil, uint16(len(name)))
+	payload = append(payload, name...)
+	payload = appendUint16(payload, uint16(len(password)))
+	payload = append(payload, password...)
+	if err := writeHeader(w, header{MsgAuth, reqID, uint32(len(payload))}); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadAuth reads the payload of a MsgAuth frame (after the header).
+func ReadAuth(r io.Reader, payloadLen uint32) (name, password string, err error) {
+	if payloadLen > maxAuthPayload {
+		return "", "", fmt.Errorf("auth payload too long")
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", "", err
+	}
+	field := func() (string, bool) {
+		if len(payload) < 2 {
+			return "", false
+		}
+		n := int(binary.BigEndian.Uint16(payload))
+		if len(payload) < 2+n {
+			return "", false
+		}
+		v := string(payload[2 : 2+n])
+		payload = payload[2+n:]
+		return v, true
+	}
+	name, ok1 := field()
+	password, ok2 := field()
+	if !ok1 || !ok2 {
+		return "", "", fmt.Errorf("auth payload truncated")
+	}
+	return name, password, nil
+}
+
 // ---------------------------------------------------------------------------
 // SendError / ReadError
 // ---------------------------------------------------------------------------
@@ -299,6 +549,40 @@ func parseErrorPayload(payload []byte) (string, error) {
 	return string(payload[4 : 4+msgLen]), nil
 }
 
+// ---------------------------------------------------------------------------
+// SendCancel / ReadCancel
+// ---------------------------------------------------------------------------
+
+// SendCancel writes a MsgCancel frame asking the server to abort the
+// in-flight request target. The frame carries its own reqID but the server
+// never answers it directly; the cancelled request receives a MsgError.
+//
+// Cancel payload layout:
+//
+//	uint32   target_req_id
+func SendCancel(w io.Writer, reqID uint32, target uint32) error {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], target)
+	if err := writeHeader(w, header{MsgCancel, reqID, uint32(len(payload))}); err != nil {
+		return err
+	}
+	_, err := w.Write(payload[:])
+	return err
+}
+
+// ReadCancel reads the payload of a MsgCancel frame (after the header) and
+// returns the target request ID.
+func ReadCancel(r io.Reader, payloadLen uint32) (uint32, error) {
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, err
+	}
+	if len(payload) < 4 {
+		return 0, fmt.Errorf("cancel payload too short")
+	}
+	return binary.BigEndian.Uint32(payload[0:4]), nil
+}
+
 // ---------------------------------------------------------------------------
 // SendPing / SendPong (empty payload)
 // ---------------------------------------------------------------------------
@@ -2,12 +2,17 @@ package network
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/MHS-20/ElkDB/queries"
 	table "github.com/MHS-20/ElkDB/tables"
@@ -19,46 +24,158 @@ import (
 
 // Server listens for incoming ElkWire connections and dispatches each one to
 // its own goroutine. Each connection gets its own queries.Session so that
-// transactions are isolated between clients.
+// transactions are isolated between clients; the sessions share one open
+// database.
 type Server struct {
 	// Addr is the TCP address to listen on, e.g. ":5433".
 	Addr string
 	// DBPath is the path to the ElkDB data file.
 	DBPath string
+	// DB, if set, is served instead of opening DBPath, e.g. the database of
+	// a raftdb.Node. ListenAndServe leaves it open.
+	DB *table.DB
+	// CursorTTL bounds how long a paged result may sit idle on the server
+	// before its cursor is discarded (0 = DefaultCursorTTL).
+	CursorTTL time.Duration
+	// MaxCursors and MaxCursorBytes bound the cursors open at once, and the
+	// bytes of SQL and positions they hold (0 = DefaultMaxCursors,
+	// DefaultMaxCursorBytes). A paged query that would need one more fails.
+	MaxCursors     int
+	MaxCursorBytes int
+	// TLSConfig, if set, makes the server accept TLS connections only; see
+	// the tlsconfig package.
+	TLSConfig *tls.Config
+	// RequireAuth makes clients authenticate with a MsgAuth frame (see
+	// Conn.Auth) against the users of the database before anything but a
+	// ping is served.
+	RequireAuth bool
+
+	cursorsOnce sync.Once
+	cursors     *cursorStore
+	conns       atomic.Uint64 // last connection ID, for cursor ownership
+	db          *table.DB     // DB, or the one ListenAndServe opened
+}
+
+// cursorStore returns the server-wide cursor table, creating it on first use.
+func (s *Server) cursorStore() *cursorStore {
+	s.cursorsOnce.Do(func() { s.cursors = newCursorStore(s.CursorTTL, s.MaxCursors, s.MaxCursorBytes) })
+	return s.cursors
 }
 
-// ListenAndServe starts listening and blocks until l.Close() is called or a
-// fatal listen error occurs.
+// ListenAndServe opens the database, unless DB is set, starts listening and
+// blocks until l.Close() is called or a fatal listen error occurs. On return
+// the open connections are closed, and the database it opened once they are
+// done.
 func (s *Server) ListenAndServe() error {
+	s.db = s.DB
+	if s.db == nil {
+		s.db = &table.DB{Path: s.DBPath}
+		if err := s.db.Open(); err != nil {
+			return fmt.Errorf("open %s: %w", s.DBPath, err)
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	conns := map[net.Conn]struct{}{}
+	defer func() {
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+		if s.DB == nil {
+			s.db.Close()
+		}
+	}()
 	ln, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return fmt.Errorf("listen %s: %w", s.Addr, err)
 	}
-	log.Printf("elkdb-server: listening on %s (db: %s)", s.Addr, s.DBPath)
+	mode := "plain TCP"
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+		mode = "TLS"
+	}
+	log.Printf("elkdb-server: listening on %s (db: %s, %s)", s.Addr, s.DBPath, mode)
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			return fmt.Errorf("accept: %w", err)
 		}
-		go s.handleConn(conn)
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleConn(conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
+
+// inflight tracks the cancel functions of the queries currently executing on
+// one connection, keyed by request ID. A client may reuse the ID of a query
+// that is still running; the newer query then takes the entry, and each
+// dispatch holds a token so that the older one, finishing, leaves it alone.
+type inflight struct {
+	mu      sync.Mutex
+	next    uint64
+	cancels map[uint32]inflightQuery
+}
+
+type inflightQuery struct {
+	token  uint64
+	cancel context.CancelFunc
+}
+
+// add registers the cancel function of a query and returns the token to
+// pass to done.
+func (f *inflight) add(reqID uint32, cancel context.CancelFunc) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	f.cancels[reqID] = inflightQuery{token: f.next, cancel: cancel}
+	return f.next
+}
+
+// done releases the entry of a finished query, if it is still its own.
+func (f *inflight) done(reqID uint32, token uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if q, ok := f.cancels[reqID]; ok && q.token == token {
+		delete(f.cancels, reqID)
+	}
+}
+
+// cancel aborts the query running under reqID, if any.
+func (f *inflight) cancel(reqID uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if q, ok := f.cancels[reqID]; ok {
+		q.cancel()
+		delete(f.cancels, reqID)
 	}
 }
 
 // handleConn runs the per-connection read loop. It opens a dedicated Session
-// for this connection and closes it when the connection drops.
-// Queries are dispatched to goroutines for concurrent execution.
+// on the shared database for this connection and closes it when the
+// connection drops.
+// Queries are dispatched to goroutines for concurrent execution. Each query
+// runs under a context derived from the connection, so a MsgCancel frame or
+// the client disconnecting aborts work that is still running.
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
 	remote := conn.RemoteAddr().String()
 	log.Printf("elkdb-server: new connection from %s", remote)
 
-	session, err := queries.NewSession(s.DBPath)
-	if err != nil {
-		log.Printf("elkdb-server: [%s] failed to open session: %v", remote, err)
-		_ = SendError(conn, 0, fmt.Sprintf("server could not open db: %v", err))
-		return
-	}
+	session := queries.NewSessionDB(s.db)
+	connID := s.conns.Add(1)
 	defer func() {
+		s.cursorStore().closeConn(connID)
 		session.Close()
 		log.Printf("elkdb-server: connection closed %s", remote)
 	}()
@@ -66,6 +183,11 @@ func (s *Server) handleConn(conn net.Conn) {
 	r := bufio.NewReader(conn)
 	var wmu sync.Mutex // serialises writes to the wire
 
+	connCtx, cancelConn := context.WithCancel(context.Background())
+	defer cancelConn()
+	running := &inflight{cancels: map[uint32]inflightQuery{}}
+	authed := !s.RequireAuth
+
 	for {
 		frame, err := ReadFrame(r)
 		if err != nil {
@@ -75,9 +197,38 @@ func (s *Server) handleConn(conn net.Conn) {
 			return
 		}
 
+		if !authed && frame.MsgType != MsgAuth && frame.MsgType != MsgPing {
+			if err := DiscardPayload(r, frame.PayloadLen); err != nil {
+				return
+			}
+			wmu.Lock()
+			_ = SendError(conn, frame.ReqID, "authentication required")
+			wmu.Unlock()
+			continue
+		}
+
 		switch frame.MsgType {
+		case MsgAuth:
+			name, password, err := ReadAuth(r, frame.PayloadLen)
+			if err != nil {
+				log.Printf("elkdb-server: [%s] malformed auth: %v", remote, err)
+				return
+			}
+			// Checked inline, so that frames pipelined after it see the
+			// outcome.
+			_, err = session.DB.Authenticate(name, password)
+			wmu.Lock()
+			if err != nil {
+				log.Printf("elkdb-server: [%s] authentication failed for %q", remote, name)
+				_ = SendError(conn, frame.ReqID, err.Error())
+			} else {
+				authed = true
+				_ = SendResult(conn, frame.ReqID, Result{})
+			}
+			wmu.Unlock()
+
 		case MsgQuery:
-			sql, _, err := ReadQuery(r, frame.PayloadLen)
+			q, err := ReadQueryRequest(r, frame.PayloadLen)
 			if err != nil {
 				log.Printf("elkdb-server: [%s] malformed query: %v", remote, err)
 				wmu.Lock()
@@ -85,7 +236,46 @@ func (s *Server) handleConn(conn net.Conn) {
 				wmu.Unlock()
 				return
 			}
-			go s.execAndRespond(conn, &wmu, session, frame.ReqID, sql)
+			ctx, cancel := context.WithCancel(connCtx)
+			token := running.add(frame.ReqID, cancel)
+			go func(reqID uint32) {
+				defer cancel()
+				defer running.done(reqID, token)
+				s.execAndRespond(ctx, conn, &wmu, session, connID, reqID, q)
+			}(frame.ReqID)
+
+		case MsgFetch:
+			id, pageSize, err := ReadFetch(r, frame.PayloadLen)
+			if err != nil {
+				log.Printf("elkdb-server: [%s] malformed fetch: %v", remote, err)
+				return
+			}
+			// Served inline: a page is bounded, and fetches of one cursor
+			// must run in order.
+			res, err := s.fetch(connCtx, connID, id, int(pageSize))
+			wmu.Lock()
+			if err == nil {
+				_ = SendPage(conn, frame.ReqID, res)
+			} else {
+				_ = SendError(conn, frame.ReqID, err.Error())
+			}
+			wmu.Unlock()
+
+		case MsgCloseCursor:
+			id, err := ReadCloseCursor(r, frame.PayloadLen)
+			if err != nil {
+				log.Printf("elkdb-server: [%s] malformed close cursor: %v", remote, err)
+				return
+			}
+			s.cursorStore().close(connID, id)
+
+		case MsgCancel:
+			target, err := ReadCancel(r, frame.PayloadLen)
+			if err != nil {
+				log.Printf("elkdb-server: [%s] malformed cancel: %v", remote, err)
+				return
+			}
+			running.cancel(target)
 
 		case MsgPing:
 			go func(reqID uint32) {
@@ -104,8 +294,28 @@ func (s *Server) handleConn(conn net.Conn) {
 }
 
 // execAndRespond runs one SQL string and writes a MsgResult or MsgError back.
-// Called from a goroutine; wmu synchronises writes to the wire.
-func (s *Server) execAndRespond(w io.Writer, wmu *sync.Mutex, session *queries.Session, reqID uint32, sql string) {
+// Called from a goroutine; wmu synchronises writes to the wire. If ctx is
+// cancelled while the statement runs, the transaction is aborted and the
+// client receives a MsgError. Paged queries are answered with a MsgPage; see
+// pagedSelect for how the rest of the rows are read.
+func (s *Server) execAndRespond(ctx context.Context, w io.Writer, wmu *sync.Mutex, session *queries.Session, connID uint64, reqID uint32, q QueryRequest) {
+	sql := q.SQL
+	if q.PageSize > 0 {
+		res, err := s.pagedSelect(ctx, connID, sql, int(q.PageSize))
+		if !errors.Is(err, queries.ErrNotPageable) {
+			wmu.Lock()
+			if err == nil {
+				err = SendPage(w, reqID, res)
+			} else {
+				err = SendError(w, reqID, err.Error())
+			}
+			wmu.Unlock()
+			if err != nil {
+				log.Printf("elkdb-server: result write error: %v", err)
+			}
+			return
+		}
+	}
 	// Parse the statement. We do this outside the transaction so we can
 	// reject a bad parse without consuming a commit slot.
 	stmt, err := queries.ParseStatement(sql)
@@ -121,9 +331,9 @@ func (s *Server) execAndRespond(w io.Writer, wmu *sync.Mutex, session *queries.S
 
 	var result queries.Result
 	if stmt.Kind == queries.StmtSelect {
-		result, err = queries.ReaderExecString(&tx, sql)
+		result, err = queries.ReaderExecContext(ctx, &tx, sql)
 	} else {
-		result, err = queries.WriterExecString(&tx, sql)
+		result, err = queries.WriterExecContext(ctx, &tx, sql)
 	}
 
 	if err != nil {
@@ -147,11 +357,69 @@ func (s *Server) execAndRespond(w io.Writer, wmu *sync.Mutex, session *queries.S
 		merged.Rows = append(merged.Rows, convertRecord(row))
 	}
 
-	wmu.Lock()
-	if err := SendResult(w, reqID, merged); err != nil {
+	if q.PageSize > 0 {
+		// Not a single-table SELECT: there is no position to resume from,
+		// so the whole result goes in one page.
+		wmu.Lock()
+		err = SendPage(w, reqID, merged)
+		wmu.Unlock()
+	} else {
+		wmu.Lock()
+		err = SendResult(w, reqID, merged)
+		wmu.Unlock()
+	}
+	if err != nil {
 		log.Printf("elkdb-server: result write error: %v", err)
 	}
-	wmu.Unlock()
+}
+
+// pagedSelect answers the first page of a paged single-table SELECT. If more
+// rows remain, it opens a cursor owned by connection connID that holds the
+// statement and the position after the page, not the rows: each MsgFetch
+// reads the next page from a new snapshot (see queries.SelectPage). Other
+// statements return queries.ErrNotPageable.
+func (s *Server) pagedSelect(ctx context.Context, connID uint64, sql string, pageSize int) (Result, error) {
+	r := table.DBReader{}
+	s.db.BeginRead(&r)
+	rows, next, err := queries.SelectPage(ctx, &r, sql, pageSize, 0, "")
+	s.db.EndRead(&r)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Rows: rows}
+	if next != "" {
+		res.Cursor, err = s.cursorStore().open(cursor{
+			conn: connID, sql: sql, token: next, returned: len(rows), pageSize: pageSize,
+		})
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// fetch reads the next page of cursor id for connection connID. pageSize 0
+// keeps the size the cursor was opened with. The returned cursor is 0, and
+// the cursor dropped, after the last page or an error.
+func (s *Server) fetch(ctx context.Context, connID, id uint64, pageSize int) (Result, error) {
+	cs := s.cursorStore()
+	cur, ok := cs.get(connID, id)
+	if !ok {
+		return Result{}, fmt.Errorf("unknown or expired cursor")
+	}
+	if pageSize <= 0 {
+		pageSize = cur.pageSize
+	}
+	r := table.DBReader{}
+	s.db.BeginRead(&r)
+	rows, next, err := queries.SelectPage(ctx, &r, cur.sql, pageSize, cur.returned, cur.token)
+	s.db.EndRead(&r)
+	if err != nil || next == "" {
+		cs.close(connID, id)
+		return Result{Rows: rows}, err
+	}
+	cs.advance(id, next, len(rows))
+	return Result{Rows: rows, Cursor: id}, nil
 }
 
 // convertRecord converts a queries.Result row (table.Record) to the network
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInflight_ReusedReqID(t *testing.T) {
+	running := &inflight{cancels: map[uint32]inflightQuery{}}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	// The client reuses request ID 7 while the first query still runs.
+	token1 := running.add(7, cancel1)
+	token2 := running.add(7, cancel2)
+
+	// The first query finishing must not touch the second.
+	running.done(7, token1)
+	if ctx2.Err() != nil {
+		t.Fatal("second query canceled when the first finished")
+	}
+	if _, ok := running.cancels[7]; !ok {
+		t.Fatal("entry of the second query dropped when the first finished")
+	}
+
+	// A cancel frame reaches the query now running under the ID.
+	running.cancel(7)
+	if ctx2.Err() == nil {
+		t.Fatal("cancel did not reach the second query")
+	}
+	if ctx1.Err() != nil {
+		t.Fatal("cancel reached the finished first query")
+	}
+	running.done(7, token2)
+	if len(running.cancels) != 0 {
+		t.Fatalf("%d entries left", len(running.cancels))
+	}
+}
@@ -0,0 +1,1033 @@
+// ElkDB gRPC service: the table API of the REST server (server/http) with
+// protobuf types, for services written in languages without an ElkWire
+// client. server/grpc serves it; the generated Go client and types are in
+// this directory, package elkdbv1. After changing this file, regenerate them
+// with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//          proto/elkdb/v1/elkdb.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: proto/elkdb/v1/elkdb.proto
+
+package elkdbv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Cmp mirrors btree.CmpGE / CmpGT / CmpLT / CmpLE.
+type Cmp int32
+
+const (
+	Cmp_CMP_UNSPECIFIED Cmp = 0 // as a start: GE; as a stop: no bound
+	Cmp_CMP_GE          Cmp = 1
+	Cmp_CMP_GT          Cmp = 2
+	Cmp_CMP_LT          Cmp = 3
+	Cmp_CMP_LE          Cmp = 4
+)
+
+// Enum value maps for Cmp.
+var (
+	Cmp_name = map[int32]string{
+		0: "CMP_UNSPECIFIED",
+		1: "CMP_GE",
+		2: "CMP_GT",
+		3: "CMP_LT",
+		4: "CMP_LE",
+	}
+	Cmp_value = map[string]int32{
+		"CMP_UNSPECIFIED": 0,
+		"CMP_GE":          1,
+		"CMP_GT":          2,
+		"CMP_LT":          3,
+		"CMP_LE":          4,
+	}
+)
+
+func (x Cmp) Enum() *Cmp {
+	p := new(Cmp)
+	*p = x
+	return p
+}
+
+func (x Cmp) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Cmp) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_elkdb_v1_elkdb_proto_enumTypes[0].Descriptor()
+}
+
+func (Cmp) Type() protoreflect.EnumType {
+	return &file_proto_elkdb_v1_elkdb_proto_enumTypes[0]
+}
+
+func (x Cmp) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Cmp.Descriptor instead.
+func (Cmp) EnumDescriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{0}
+}
+
+type ColumnType int32
+
+const (
+	ColumnType_COLUMN_TYPE_UNSPECIFIED ColumnType = 0
+	ColumnType_COLUMN_TYPE_BYTES       ColumnType = 1
+	ColumnType_COLUMN_TYPE_INT64       ColumnType = 2
+	ColumnType_COLUMN_TYPE_FLOAT64     ColumnType = 3
+	ColumnType_COLUMN_TYPE_UUID        ColumnType = 4
+)
+
+// Enum value maps for ColumnType.
+var (
+	ColumnType_name = map[int32]string{
+		0: "COLUMN_TYPE_UNSPECIFIED",
+		1: "COLUMN_TYPE_BYTES",
+		2: "COLUMN_TYPE_INT64",
+		3: "COLUMN_TYPE_FLOAT64",
+		4: "COLUMN_TYPE_UUID",
+	}
+	ColumnType_value = map[string]int32{
+		"COLUMN_TYPE_UNSPECIFIED": 0,
+		"COLUMN_TYPE_BYTES":       1,
+		"COLUMN_TYPE_INT64":       2,
+		"COLUMN_TYPE_FLOAT64":     3,
+		"COLUMN_TYPE_UUID":        4,
+	}
+)
+
+func (x ColumnType) Enum() *ColumnType {
+	p := new(ColumnType)
+	*p = x
+	return p
+}
+
+func (x ColumnType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ColumnType) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_elkdb_v1_elkdb_proto_enumTypes[1].Descriptor()
+}
+
+func (ColumnType) Type() protoreflect.EnumType {
+	return &file_proto_elkdb_v1_elkdb_proto_enumTypes[1]
+}
+
+func (x ColumnType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ColumnType.Descriptor instead.
+func (ColumnType) EnumDescriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{1}
+}
+
+// Value is one column value; its case must match the column type.
+type Value struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*Value_Int64
+	//	*Value_Bytes
+	//	*Value_Float64
+	Kind          isValue_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Value) Reset() {
+	*x = Value{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Value) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Value) ProtoMessage() {}
+
+func (x *Value) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Value.ProtoReflect.Descriptor instead.
+func (*Value) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Value) GetKind() isValue_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *Value) GetInt64() int64 {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Int64); ok {
+			return x.Int64
+		}
+	}
+	return 0
+}
+
+func (x *Value) GetBytes() []byte {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Bytes); ok {
+			return x.Bytes
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetFloat64() float64 {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Float64); ok {
+			return x.Float64
+		}
+	}
+	return 0
+}
+
+type isValue_Kind interface {
+	isValue_Kind()
+}
+
+type Value_Int64 struct {
+	Int64 int64 `protobuf:"varint,1,opt,name=int64,proto3,oneof"` // TypeInt64
+}
+
+type Value_Bytes struct {
+	Bytes []byte `protobuf:"bytes,2,opt,name=bytes,proto3,oneof"` // TypeBytes, and TypeUUID as its 16 bytes
+}
+
+type Value_Float64 struct {
+	Float64 float64 `protobuf:"fixed64,3,opt,name=float64,proto3,oneof"` // TypeFloat64
+}
+
+func (*Value_Int64) isValue_Kind() {}
+
+func (*Value_Bytes) isValue_Kind() {}
+
+func (*Value_Float64) isValue_Kind() {}
+
+// Column is a named value. Key columns are listed in table or index order.
+type Column struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value         *Value                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Column) Reset() {
+	*x = Column{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Column) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Column) ProtoMessage() {}
+
+func (x *Column) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Column.ProtoReflect.Descriptor instead.
+func (*Column) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Column) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Column) GetValue() *Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// Row is a record in column order.
+type Row struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Columns       []*Column              `protobuf:"bytes,1,rep,name=columns,proto3" json:"columns,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Row) Reset() {
+	*x = Row{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Row) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Row) ProtoMessage() {}
+
+func (x *Row) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Row.ProtoReflect.Descriptor instead.
+func (*Row) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Row) GetColumns() []*Column {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+type GetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Table         string                 `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Key           []*Column              `protobuf:"bytes,2,rep,name=key,proto3" json:"key,omitempty"` // every primary-key column
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRequest) Reset() {
+	*x = GetRequest{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRequest) ProtoMessage() {}
+
+func (x *GetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRequest.ProtoReflect.Descriptor instead.
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *GetRequest) GetKey() []*Column {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type PutRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Table         string                 `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Row           *Row                   `protobuf:"bytes,2,opt,name=row,proto3" json:"row,omitempty"` // every column
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PutRequest) Reset() {
+	*x = PutRequest{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PutRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PutRequest) ProtoMessage() {}
+
+func (x *PutRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PutRequest.ProtoReflect.Descriptor instead.
+func (*PutRequest) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PutRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *PutRequest) GetRow() *Row {
+	if x != nil {
+		return x.Row
+	}
+	return nil
+}
+
+type PutResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Created       bool                   `protobuf:"varint,1,opt,name=created,proto3" json:"created,omitempty"` // false if an existing row was replaced
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PutResponse) Reset() {
+	*x = PutResponse{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PutResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PutResponse) ProtoMessage() {}
+
+func (x *PutResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PutResponse.ProtoReflect.Descriptor instead.
+func (*PutResponse) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PutResponse) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+type DeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Table         string                 `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Key           []*Column              `protobuf:"bytes,2,rep,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetKey() []*Column {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{7}
+}
+
+// ScanRequest mirrors tables.Scanner: the key columns, in order, must be a
+// prefix of the primary key or of a secondary index.
+type ScanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Table         string                 `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Cmp1          Cmp                    `protobuf:"varint,2,opt,name=cmp1,proto3,enum=elkdb.v1.Cmp" json:"cmp1,omitempty"`
+	Key1          []*Column              `protobuf:"bytes,3,rep,name=key1,proto3" json:"key1,omitempty"`
+	Cmp2          Cmp                    `protobuf:"varint,4,opt,name=cmp2,proto3,enum=elkdb.v1.Cmp" json:"cmp2,omitempty"`
+	Key2          []*Column              `protobuf:"bytes,5,rep,name=key2,proto3" json:"key2,omitempty"`
+	Limit         uint32                 `protobuf:"varint,6,opt,name=limit,proto3" json:"limit,omitempty"` // 0 = no limit
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanRequest) Reset() {
+	*x = ScanRequest{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanRequest) ProtoMessage() {}
+
+func (x *ScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanRequest.ProtoReflect.Descriptor instead.
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ScanRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetCmp1() Cmp {
+	if x != nil {
+		return x.Cmp1
+	}
+	return Cmp_CMP_UNSPECIFIED
+}
+
+func (x *ScanRequest) GetKey1() []*Column {
+	if x != nil {
+		return x.Key1
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetCmp2() Cmp {
+	if x != nil {
+		return x.Cmp2
+	}
+	return Cmp_CMP_UNSPECIFIED
+}
+
+func (x *ScanRequest) GetKey2() []*Column {
+	if x != nil {
+		return x.Key2
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type TableDef struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Cols          []string               `protobuf:"bytes,2,rep,name=cols,proto3" json:"cols,omitempty"`
+	Types         []ColumnType           `protobuf:"varint,3,rep,packed,name=types,proto3,enum=elkdb.v1.ColumnType" json:"types,omitempty"`
+	Pkeys         uint32                 `protobuf:"varint,4,opt,name=pkeys,proto3" json:"pkeys,omitempty"` // the first pkeys columns form the primary key
+	Indexes       []*Index               `protobuf:"bytes,5,rep,name=indexes,proto3" json:"indexes,omitempty"`
+	Quota         int64                  `protobuf:"varint,6,opt,name=quota,proto3" json:"quota,omitempty"` // max bytes of row data; 0 = unlimited
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TableDef) Reset() {
+	*x = TableDef{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TableDef) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TableDef) ProtoMessage() {}
+
+func (x *TableDef) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TableDef.ProtoReflect.Descriptor instead.
+func (*TableDef) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TableDef) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TableDef) GetCols() []string {
+	if x != nil {
+		return x.Cols
+	}
+	return nil
+}
+
+func (x *TableDef) GetTypes() []ColumnType {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+func (x *TableDef) GetPkeys() uint32 {
+	if x != nil {
+		return x.Pkeys
+	}
+	return 0
+}
+
+func (x *TableDef) GetIndexes() []*Index {
+	if x != nil {
+		return x.Indexes
+	}
+	return nil
+}
+
+func (x *TableDef) GetQuota() int64 {
+	if x != nil {
+		return x.Quota
+	}
+	return 0
+}
+
+type Index struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cols          []string               `protobuf:"bytes,1,rep,name=cols,proto3" json:"cols,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Index) Reset() {
+	*x = Index{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Index) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Index) ProtoMessage() {}
+
+func (x *Index) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Index.ProtoReflect.Descriptor instead.
+func (*Index) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Index) GetCols() []string {
+	if x != nil {
+		return x.Cols
+	}
+	return nil
+}
+
+type CreateTableRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Table         *TableDef              `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateTableRequest) Reset() {
+	*x = CreateTableRequest{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTableRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTableRequest) ProtoMessage() {}
+
+func (x *CreateTableRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTableRequest.ProtoReflect.Descriptor instead.
+func (*CreateTableRequest) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *CreateTableRequest) GetTable() *TableDef {
+	if x != nil {
+		return x.Table
+	}
+	return nil
+}
+
+type CreateTableResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateTableResponse) Reset() {
+	*x = CreateTableResponse{}
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTableResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTableResponse) ProtoMessage() {}
+
+func (x *CreateTableResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_elkdb_v1_elkdb_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTableResponse.ProtoReflect.Descriptor instead.
+func (*CreateTableResponse) Descriptor() ([]byte, []int) {
+	return file_proto_elkdb_v1_elkdb_proto_rawDescGZIP(), []int{12}
+}
+
+var File_proto_elkdb_v1_elkdb_proto protoreflect.FileDescriptor
+
+const file_proto_elkdb_v1_elkdb_proto_rawDesc = "" +
+	"\n" +
+	"\x1aproto/elkdb/v1/elkdb.proto\x12\belkdb.v1\"[\n" +
+	"\x05Value\x12\x16\n" +
+	"\x05int64\x18\x01 \x01(\x03H\x00R\x05int64\x12\x16\n" +
+	"\x05bytes\x18\x02 \x01(\fH\x00R\x05bytes\x12\x1a\n" +
+	"\afloat64\x18\x03 \x01(\x01H\x00R\afloat64B\x06\n" +
+	"\x04kind\"C\n" +
+	"\x06Column\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12%\n" +
+	"\x05value\x18\x02 \x01(\v2\x0f.elkdb.v1.ValueR\x05value\"1\n" +
+	"\x03Row\x12*\n" +
+	"\acolumns\x18\x01 \x03(\v2\x10.elkdb.v1.ColumnR\acolumns\"F\n" +
+	"\n" +
+	"GetRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\"\n" +
+	"\x03key\x18\x02 \x03(\v2\x10.elkdb.v1.ColumnR\x03key\"C\n" +
+	"\n" +
+	"PutRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\x1f\n" +
+	"\x03row\x18\x02 \x01(\v2\r.elkdb.v1.RowR\x03row\"'\n" +
+	"\vPutResponse\x12\x18\n" +
+	"\acreated\x18\x01 \x01(\bR\acreated\"I\n" +
+	"\rDeleteRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\"\n" +
+	"\x03key\x18\x02 \x03(\v2\x10.elkdb.v1.ColumnR\x03key\"\x10\n" +
+	"\x0eDeleteResponse\"\xcb\x01\n" +
+	"\vScanRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12!\n" +
+	"\x04cmp1\x18\x02 \x01(\x0e2\r.elkdb.v1.CmpR\x04cmp1\x12$\n" +
+	"\x04key1\x18\x03 \x03(\v2\x10.elkdb.v1.ColumnR\x04key1\x12!\n" +
+	"\x04cmp2\x18\x04 \x01(\x0e2\r.elkdb.v1.CmpR\x04cmp2\x12$\n" +
+	"\x04key2\x18\x05 \x03(\v2\x10.elkdb.v1.ColumnR\x04key2\x12\x14\n" +
+	"\x05limit\x18\x06 \x01(\rR\x05limit\"\xb5\x01\n" +
+	"\bTableDef\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04cols\x18\x02 \x03(\tR\x04cols\x12*\n" +
+	"\x05types\x18\x03 \x03(\x0e2\x14.elkdb.v1.ColumnTypeR\x05types\x12\x14\n" +
+	"\x05pkeys\x18\x04 \x01(\rR\x05pkeys\x12)\n" +
+	"\aindexes\x18\x05 \x03(\v2\x0f.elkdb.v1.IndexR\aindexes\x12\x14\n" +
+	"\x05quota\x18\x06 \x01(\x03R\x05quota\"\x1b\n" +
+	"\x05Index\x12\x12\n" +
+	"\x04cols\x18\x01 \x03(\tR\x04cols\">\n" +
+	"\x12CreateTableRequest\x12(\n" +
+	"\x05table\x18\x01 \x01(\v2\x12.elkdb.v1.TableDefR\x05table\"\x15\n" +
+	"\x13CreateTableResponse*J\n" +
+	"\x03Cmp\x12\x13\n" +
+	"\x0fCMP_UNSPECIFIED\x10\x00\x12\n" +
+	"\n" +
+	"\x06CMP_GE\x10\x01\x12\n" +
+	"\n" +
+	"\x06CMP_GT\x10\x02\x12\n" +
+	"\n" +
+	"\x06CMP_LT\x10\x03\x12\n" +
+	"\n" +
+	"\x06CMP_LE\x10\x04*\x86\x01\n" +
+	"\n" +
+	"ColumnType\x12\x1b\n" +
+	"\x17COLUMN_TYPE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11COLUMN_TYPE_BYTES\x10\x01\x12\x15\n" +
+	"\x11COLUMN_TYPE_INT64\x10\x02\x12\x17\n" +
+	"\x13COLUMN_TYPE_FLOAT64\x10\x03\x12\x14\n" +
+	"\x10COLUMN_TYPE_UUID\x10\x042\xa0\x02\n" +
+	"\x05ElkDB\x12*\n" +
+	"\x03Get\x12\x14.elkdb.v1.GetRequest\x1a\r.elkdb.v1.Row\x122\n" +
+	"\x03Put\x12\x14.elkdb.v1.PutRequest\x1a\x15.elkdb.v1.PutResponse\x12;\n" +
+	"\x06Delete\x12\x17.elkdb.v1.DeleteRequest\x1a\x18.elkdb.v1.DeleteResponse\x12.\n" +
+	"\x04Scan\x12\x15.elkdb.v1.ScanRequest\x1a\r.elkdb.v1.Row0\x01\x12J\n" +
+	"\vCreateTable\x12\x1c.elkdb.v1.CreateTableRequest\x1a\x1d.elkdb.v1.CreateTableResponseB0Z.github.com/MHS-20/ElkDB/proto/elkdb/v1;elkdbv1b\x06proto3"
+
+var (
+	file_proto_elkdb_v1_elkdb_proto_rawDescOnce sync.Once
+	file_proto_elkdb_v1_elkdb_proto_rawDescData []byte
+)
+
+func file_proto_elkdb_v1_elkdb_proto_rawDescGZIP() []byte {
+	file_proto_elkdb_v1_elkdb_proto_rawDescOnce.Do(func() {
+		file_proto_elkdb_v1_elkdb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_elkdb_v1_elkdb_proto_rawDesc), len(file_proto_elkdb_v1_elkdb_proto_rawDesc)))
+	})
+	return file_proto_elkdb_v1_elkdb_proto_rawDescData
+}
+
+var file_proto_elkdb_v1_elkdb_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_proto_elkdb_v1_elkdb_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_proto_elkdb_v1_elkdb_proto_goTypes = []any{
+	(Cmp)(0),                    // 0: elkdb.v1.Cmp
+	(ColumnType)(0),             // 1: elkdb.v1.ColumnType
+	(*Value)(nil),               // 2: elkdb.v1.Value
+	(*Column)(nil),              // 3: elkdb.v1.Column
+	(*Row)(nil),                 // 4: elkdb.v1.Row
+	(*GetRequest)(nil),          // 5: elkdb.v1.GetRequest
+	(*PutRequest)(nil),          // 6: elkdb.v1.PutRequest
+	(*PutResponse)(nil),         // 7: elkdb.v1.PutResponse
+	(*DeleteRequest)(nil),       // 8: elkdb.v1.DeleteRequest
+	(*DeleteResponse)(nil),      // 9: elkdb.v1.DeleteResponse
+	(*ScanRequest)(nil),         // 10: elkdb.v1.ScanRequest
+	(*TableDef)(nil),            // 11: elkdb.v1.TableDef
+	(*Index)(nil),               // 12: elkdb.v1.Index
+	(*CreateTableRequest)(nil),  // 13: elkdb.v1.CreateTableRequest
+	(*CreateTableResponse)(nil), // 14: elkdb.v1.CreateTableResponse
+}
+var file_proto_elkdb_v1_elkdb_proto_depIdxs = []int32{
+	2,  // 0: elkdb.v1.Column.value:type_name -> elkdb.v1.Value
+	3,  // 1: elkdb.v1.Row.columns:type_name -> elkdb.v1.Column
+	3,  // 2: elkdb.v1.GetRequest.key:type_name -> elkdb.v1.Column
+	4,  // 3: elkdb.v1.PutRequest.row:type_name -> elkdb.v1.Row
+	3,  // 4: elkdb.v1.DeleteRequest.key:type_name -> elkdb.v1.Column
+	0,  // 5: elkdb.v1.ScanRequest.cmp1:type_name -> elkdb.v1.Cmp
+	3,  // 6: elkdb.v1.ScanRequest.key1:type_name -> elkdb.v1.Column
+	0,  // 7: elkdb.v1.ScanRequest.cmp2:type_name -> elkdb.v1.Cmp
+	3,  // 8: elkdb.v1.ScanRequest.key2:type_name -> elkdb.v1.Column
+	1,  // 9: elkdb.v1.TableDef.types:type_name -> elkdb.v1.ColumnType
+	12, // 10: elkdb.v1.TableDef.indexes:type_name -> elkdb.v1.Index
+	11, // 11: elkdb.v1.CreateTableRequest.table:type_name -> elkdb.v1.TableDef
+	5,  // 12: elkdb.v1.ElkDB.Get:input_type -> elkdb.v1.GetRequest
+	6,  // 13: elkdb.v1.ElkDB.Put:input_type -> elkdb.v1.PutRequest
+	8,  // 14: elkdb.v1.ElkDB.Delete:input_type -> elkdb.v1.DeleteRequest
+	10, // 15: elkdb.v1.ElkDB.Scan:input_type -> elkdb.v1.ScanRequest
+	13, // 16: elkdb.v1.ElkDB.CreateTable:input_type -> elkdb.v1.CreateTableRequest
+	4,  // 17: elkdb.v1.ElkDB.Get:output_type -> elkdb.v1.Row
+	7,  // 18: elkdb.v1.ElkDB.Put:output_type -> elkdb.v1.PutResponse
+	9,  // 19: elkdb.v1.ElkDB.Delete:output_type -> elkdb.v1.DeleteResponse
+	4,  // 20: elkdb.v1.ElkDB.Scan:output_type -> elkdb.v1.Row
+	14, // 21: elkdb.v1.ElkDB.CreateTable:output_type -> elkdb.v1.CreateTableResponse
+	17, // [17:22] is the sub-list for method output_type
+	12, // [12:17] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
+}
+
+func init() { file_proto_elkdb_v1_elkdb_proto_init() }
+func file_proto_elkdb_v1_elkdb_proto_init() {
+	if File_proto_elkdb_v1_elkdb_proto != nil {
+		return
+	}
+	file_proto_elkdb_v1_elkdb_proto_msgTypes[0].OneofWrappers = []any{
+		(*Value_Int64)(nil),
+		(*Value_Bytes)(nil),
+		(*Value_Float64)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_elkdb_v1_elkdb_proto_rawDesc), len(file_proto_elkdb_v1_elkdb_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_elkdb_v1_elkdb_proto_goTypes,
+		DependencyIndexes: file_proto_elkdb_v1_elkdb_proto_depIdxs,
+		EnumInfos:         file_proto_elkdb_v1_elkdb_proto_enumTypes,
+		MessageInfos:      file_proto_elkdb_v1_elkdb_proto_msgTypes,
+	}.Build()
+	File_proto_elkdb_v1_elkdb_proto = out.File
+	file_proto_elkdb_v1_elkdb_proto_goTypes = nil
+	file_proto_elkdb_v1_elkdb_proto_depIdxs = nil
+}
@@ -0,0 +1,297 @@
+// ElkDB gRPC service: the table API of the REST server (server/http) with
+// protobuf types, for services written in languages without an ElkWire
+// client. server/grpc serves it; the generated Go client and types are in
+// this directory, package elkdbv1. After changing this file, regenerate them
+// with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//          proto/elkdb/v1/elkdb.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: proto/elkdb/v1/elkdb.proto
+
+package elkdbv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ElkDB_Get_FullMethodName         = "/elkdb.v1.ElkDB/Get"
+	ElkDB_Put_FullMethodName         = "/elkdb.v1.ElkDB/Put"
+	ElkDB_Delete_FullMethodName      = "/elkdb.v1.ElkDB/Delete"
+	ElkDB_Scan_FullMethodName        = "/elkdb.v1.ElkDB/Scan"
+	ElkDB_CreateTable_FullMethodName = "/elkdb.v1.ElkDB/CreateTable"
+)
+
+// ElkDBClient is the client API for ElkDB service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ElkDBClient interface {
+	// Get fetches one row by primary key; NOT_FOUND if absent.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Row, error)
+	// Put inserts or replaces a row.
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Delete removes a row by primary key; NOT_FOUND if absent.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Scan streams the rows of a range, read from one snapshot.
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Row], error)
+	// CreateTable creates a table; ALREADY_EXISTS if the name is taken.
+	CreateTable(ctx context.Context, in *CreateTableRequest, opts ...grpc.CallOption) (*CreateTableResponse, error)
+}
+
+type elkDBClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewElkDBClient(cc grpc.ClientConnInterface) ElkDBClient {
+	return &elkDBClient{cc}
+}
+
+func (c *elkDBClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Row, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Row)
+	err := c.cc.Invoke(ctx, ElkDB_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *elkDBClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, ElkDB_Put_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *elkDBClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, ElkDB_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *elkDBClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Row], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ElkDB_ServiceDesc.Streams[0], ElkDB_Scan_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ScanRequest, Row]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ElkDB_ScanClient = grpc.ServerStreamingClient[Row]
+
+func (c *elkDBClient) CreateTable(ctx context.Context, in *CreateTableRequest, opts ...grpc.CallOption) (*CreateTableResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateTableResponse)
+	err := c.cc.Invoke(ctx, ElkDB_CreateTable_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ElkDBServer is the server API for ElkDB service.
+// All implementations must embed UnimplementedElkDBServer
+// for forward compatibility.
+type ElkDBServer interface {
+	// Get fetches one row by primary key; NOT_FOUND if absent.
+	Get(context.Context, *GetRequest) (*Row, error)
+	// Put inserts or replaces a row.
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Delete removes a row by primary key; NOT_FOUND if absent.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Scan streams the rows of a range, read from one snapshot.
+	Scan(*ScanRequest, grpc.ServerStreamingServer[Row]) error
+	// CreateTable creates a table; ALREADY_EXISTS if the name is taken.
+	CreateTable(context.Context, *CreateTableRequest) (*CreateTableResponse, error)
+	mustEmbedUnimplementedElkDBServer()
+}
+
+// UnimplementedElkDBServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedElkDBServer struct{}
+
+func (UnimplementedElkDBServer) Get(context.Context, *GetRequest) (*Row, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedElkDBServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedElkDBServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedElkDBServer) Scan(*ScanRequest, grpc.ServerStreamingServer[Row]) error {
+	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedElkDBServer) CreateTable(context.Context, *CreateTableRequest) (*CreateTableResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTable not implemented")
+}
+func (UnimplementedElkDBServer) mustEmbedUnimplementedElkDBServer() {}
+func (UnimplementedElkDBServer) testEmbeddedByValue()               {}
+
+// UnsafeElkDBServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ElkDBServer will
+// result in compilation errors.
+type UnsafeElkDBServer interface {
+	mustEmbedUnimplementedElkDBServer()
+}
+
+func RegisterElkDBServer(s grpc.ServiceRegistrar, srv ElkDBServer) {
+	// If the following call pancis, it indicates UnimplementedElkDBServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ElkDB_ServiceDesc, srv)
+}
+
+func _ElkDB_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ElkDBServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ElkDB_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ElkDBServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ElkDB_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ElkDBServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ElkDB_Put_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ElkDBServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ElkDB_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ElkDBServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ElkDB_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ElkDBServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ElkDB_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ElkDBServer).Scan(m, &grpc.GenericServerStream[ScanRequest, Row]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ElkDB_ScanServer = grpc.ServerStreamingServer[Row]
+
+func _ElkDB_CreateTable_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTableRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ElkDBServer).CreateTable(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ElkDB_CreateTable_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ElkDBServer).CreateTable(ctx, req.(*CreateTableRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ElkDB_ServiceDesc is the grpc.ServiceDesc for ElkDB service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ElkDB_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "elkdb.v1.ElkDB",
+	HandlerType: (*ElkDBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _ElkDB_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _ElkDB_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _ElkDB_Delete_Handler,
+		},
+		{
+			MethodName: "CreateTable",
+			Handler:    _ElkDB_CreateTable_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _ElkDB_Scan_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/elkdb/v1/elkdb.proto",
+}
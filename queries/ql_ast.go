@@ -70,11 +70,13 @@ type Expr struct {
 	// ExprStr: string value (unquoted)
 	Str []byte
 
-	// ExprCol: column name
-	Col string
+	// ExprCol: column name, and the collation its values compare by (see
+	// table.TableDef.Collate); filled in at execution time
+	Col     string
+	Collate string
 
 	// ExprBinop: operator and operands
-	Op    string // "+", "-", "*", "/", "==", "!=", "<", "<=", ">", ">="
+	Op    string // "+", "-", "*", "/", "==", "!=", "<", "<=", ">", ">=", "AND", "OR"
 	Left  *Expr
 	Right *Expr
 }
@@ -92,23 +94,43 @@ const (
 	StmtUpdate
 	StmtDelete
 	StmtCreateTable
+	StmtAnalyze
+	StmtAlterTable
+)
+
+// AlterKind is the change an ALTER TABLE statement makes.
+type AlterKind uint8
+
+const (
+	AlterDropColumn AlterKind = iota + 1
+	AlterColumnType
+	AlterRenameColumn
 )
 
 // ColDef describes one column inside a CREATE TABLE statement.
 type ColDef struct {
-	Name string
-	Type uint32 // table.TypeBytes or table.TypeInt64
+	Name    string
+	Type    uint32 // table.TypeBytes or table.TypeInt64
+	Collate string // COLLATE clause, if any
+	Desc    bool   // DESC in the primary key or an index
 }
 
 // IndexDef describes one index inside a CREATE TABLE statement.
 type IndexDef struct {
-	Cols []string
+	Cols    []string
+	Include []string // INCLUDE clause: columns stored in the entries
+	Where   *Expr    // WHERE clause of a partial index, if any
+	Unique  bool     // UNIQUE INDEX
 }
 
 // Statement is the parsed form of a single SQL-like query.
 type Statement struct {
 	Kind StmtKind
 
+	// Explain is set by an EXPLAIN prefix: describe the access path instead
+	// of executing the statement.
+	Explain bool
+
 	// Table references (all statements). The first entry is the primary
 	// table; subsequent entries come from JOIN clauses.
 	Tables []TableRef
@@ -121,6 +143,15 @@ type Statement struct {
 	// key restriction for UPDATE).
 	Where *Expr
 
+	// SELECT: LIMIT n. Execution stops as soon as Limit rows have been
+	// produced.
+	HasLimit bool
+	Limit    int
+
+	// SELECT: OFFSET n. The first Offset rows that would be produced are
+	// left out, before LIMIT counts.
+	Offset int
+
 	// SELECT: comparison operators that bound the range scan when Where is
 	// a simple primary-key comparison.
 	Cmp1 int // btree.CmpGE / CmpGT / CmpLT / CmpLE
@@ -136,6 +167,13 @@ type Statement struct {
 	ColDefs []ColDef
 	PKeys   int // number of leading columns that form the primary key
 	Indexes []IndexDef
+
+	// ALTER TABLE: the change, the column it applies to, and its new type
+	// (AlterColumnType) or name (AlterRenameColumn).
+	Alter     AlterKind
+	AlterCol  string
+	AlterType uint32
+	AlterName string
 }
 
 // Table returns the first table name (convenience for single-table
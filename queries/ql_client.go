@@ -6,6 +6,9 @@
 package queries
 
 import (
+	"context"
+	"errors"
+
 	table "github.com/MHS-20/ElkDB/tables"
 )
 
@@ -16,11 +19,18 @@ import (
 // The tx parameter is table.Writer — any *table.DBTX satisfies it, as do
 // test doubles.
 func WriterExecString(tx table.Writer, query string) (Result, error) {
+	return WriterExecContext(context.Background(), tx, query)
+}
+
+// WriterExecContext is WriterExecString with cancellation: the executor
+// checks ctx between rows and returns ctx.Err() once it is done. The caller
+// is responsible for aborting tx in that case.
+func WriterExecContext(ctx context.Context, tx table.Writer, query string) (Result, error) {
 	stmt, err := ParseStatement(query)
 	if err != nil {
 		return Result{}, err
 	}
-	return qlExec(tx, tx, stmt)
+	return qlExec(ctx, tx, tx, stmt)
 }
 
 // ReaderExecString parses and executes a single SQL-like statement against a
@@ -30,6 +40,12 @@ func WriterExecString(tx table.Writer, query string) (Result, error) {
 // The tx parameter is table.Reader — both *table.DBReader and *table.DBTX
 // satisfy it, as do test doubles.
 func ReaderExecString(tx table.Reader, query string) (Result, error) {
+	return ReaderExecContext(context.Background(), tx, query)
+}
+
+// ReaderExecContext is ReaderExecString with cancellation (see
+// WriterExecContext).
+func ReaderExecContext(ctx context.Context, tx table.Reader, query string) (Result, error) {
 	stmt, err := ParseStatement(query)
 	if err != nil {
 		return Result{}, err
@@ -37,5 +53,29 @@ func ReaderExecString(tx table.Reader, query string) (Result, error) {
 	// A Reader cannot satisfy Writer; pass nil for the write half.
 	// qlExec only calls write paths for write statements, so passing nil is
 	// safe here as long as the query is a SELECT.
-	return qlExec(nil, tx, stmt)
+	return qlExec(ctx, nil, tx, stmt)
+}
+
+// ErrNotPageable is returned by SelectPage for a statement it cannot resume
+// between pages: anything but a single-table SELECT.
+var ErrNotPageable = errors.New("statement cannot be paged")
+
+// SelectPage runs a single-table SELECT and returns up to limit of its rows,
+// starting after token, the position the previous page returned (empty for
+// the first page). returned is the number of rows the earlier pages
+// returned: LIMIT counts them, and OFFSET applies to the first page only.
+// next is empty after the last page.
+//
+// Nothing is kept between pages but the token, so each page may read its own
+// snapshot: see table.Scanner.Page for what a page sees of the writes
+// committed since the previous one.
+func SelectPage(ctx context.Context, tx table.Reader, query string, limit, returned int, token string) (rows []table.Record, next string, err error) {
+	stmt, err := ParseStatement(query)
+	if err != nil {
+		return nil, "", err
+	}
+	if stmt.Kind != StmtSelect || stmt.Explain || len(stmt.Tables) > 1 {
+		return nil, "", ErrNotPageable
+	}
+	return qlSelectPage(ctx, tx, stmt, limit, returned, token)
 }
@@ -1,6 +1,7 @@
 package queries
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/MHS-20/ElkDB/btree"
@@ -27,22 +28,47 @@ type Result struct {
 // w is required for write statements; r is used for read-only statements.
 // For write transactions, pass the same *DBTX for both w and r (DBTX
 // satisfies both interfaces).
-func qlExec(w table.Writer, r table.Reader, stmt Statement) (Result, error) {
+// ctx is checked once per scanned row so long-running statements can be
+// cancelled mid-execution.
+func qlExec(ctx context.Context, w table.Writer, r table.Reader, stmt Statement) (Result, error) {
+	if stmt.Explain {
+		return qlExplain(r, stmt)
+	}
 	switch stmt.Kind {
 	case StmtSelect:
-		return qlSelect(r, stmt)
+		return qlSelect(ctx, r, stmt)
 	case StmtInsert:
 		return qlInsert(w, stmt)
 	case StmtUpdate:
-		return qlUpdate(w, stmt)
+		return qlUpdate(ctx, w, stmt)
 	case StmtDelete:
-		return qlDelete(w, stmt)
+		return qlDelete(ctx, w, stmt)
 	case StmtCreateTable:
 		return qlCreateTable(w, stmt)
+	case StmtAnalyze:
+		return Result{}, w.Analyze(stmt.Table())
+	case StmtAlterTable:
+		return qlAlterTable(w, stmt)
 	}
 	return Result{}, fmt.Errorf("unknown statement kind")
 }
 
+// ---------------------------------------------------------------------------
+// ALTER TABLE
+// ---------------------------------------------------------------------------
+
+func qlAlterTable(tx table.Writer, stmt Statement) (Result, error) {
+	switch stmt.Alter {
+	case AlterDropColumn:
+		return Result{}, tx.ColumnDrop(stmt.Table(), stmt.AlterCol)
+	case AlterColumnType:
+		return Result{}, tx.ColumnType(stmt.Table(), stmt.AlterCol, stmt.AlterType)
+	case AlterRenameColumn:
+		return Result{}, tx.ColumnRename(stmt.Table(), stmt.AlterCol, stmt.AlterName)
+	}
+	return Result{}, fmt.Errorf("unknown ALTER TABLE change")
+}
+
 // ---------------------------------------------------------------------------
 // CREATE TABLE
 // ---------------------------------------------------------------------------
@@ -56,8 +82,41 @@ func qlCreateTable(tx table.Writer, stmt Statement) (Result, error) {
 		tdef.Cols = append(tdef.Cols, cd.Name)
 		tdef.Types = append(tdef.Types, cd.Type)
 	}
-	for _, idx := range stmt.Indexes {
+	for i, idx := range stmt.Indexes {
 		tdef.Indexes = append(tdef.Indexes, idx.Cols)
+		if idx.Include != nil {
+			// Indexes before this one include nothing.
+			tdef.Include = append(tdef.Include, make([][]string, i-len(tdef.Include))...)
+			tdef.Include = append(tdef.Include, idx.Include)
+		}
+		if idx.Unique {
+			tdef.Unique = append(tdef.Unique, make([]bool, i-len(tdef.Unique))...)
+			tdef.Unique = append(tdef.Unique, true)
+		}
+	}
+	for i, idx := range stmt.Indexes {
+		if idx.Where != nil {
+			conds, err := indexConds(tdef, idx.Where)
+			if err != nil {
+				return Result{}, err
+			}
+			tdef.Where = append(tdef.Where, make([][]table.Cond, i-len(tdef.Where))...)
+			tdef.Where = append(tdef.Where, conds)
+		}
+	}
+	for _, cd := range stmt.ColDefs {
+		if cd.Collate != "" {
+			if tdef.Collate == nil {
+				tdef.Collate = map[string]string{}
+			}
+			tdef.Collate[cd.Name] = cd.Collate
+		}
+		if cd.Desc {
+			if tdef.Desc == nil {
+				tdef.Desc = map[string]bool{}
+			}
+			tdef.Desc[cd.Name] = true
+		}
 	}
 	if err := tx.TableNew(tdef); err != nil {
 		return Result{}, err
@@ -65,6 +124,25 @@ func qlCreateTable(tx table.Writer, stmt Statement) (Result, error) {
 	return Result{}, nil
 }
 
+// indexConds converts the WHERE clause of a partial index, an AND of
+// comparisons of a column with a literal, to table conditions.
+func indexConds(tdef *table.TableDef, expr *Expr) ([]table.Cond, error) {
+	if expr.Kind == ExprBinop && expr.Op == "AND" {
+		left, err := indexConds(tdef, expr.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := indexConds(tdef, expr.Right)
+		return append(left, right...), err
+	}
+	if expr.Kind == ExprBinop && expr.Left.Kind == ExprCol {
+		if v, ok := literalValue(tdef, expr.Left.Col, expr.Right); ok {
+			return []table.Cond{{Col: expr.Left.Col, Cmp: expr.Op, Val: v}}, nil
+		}
+	}
+	return nil, fmt.Errorf("index WHERE must be an AND of comparisons of a column with a literal")
+}
+
 // ---------------------------------------------------------------------------
 // INSERT / UPSERT
 // ---------------------------------------------------------------------------
@@ -119,7 +197,7 @@ func qlExpandStar(_ table.Reader, tdef *table.TableDef, cols []string) []string
 // ---------------------------------------------------------------------------
 
 // qlSelectJoin implements nested-loop INNER/LEFT JOIN.
-func qlSelectJoin(tx table.Reader, stmt Statement) (Result, error) {
+func qlSelectJoin(ctx context.Context, tx table.Reader, stmt Statement) (Result, error) {
 	// Collect table definitions.
 	tdefs := make([]*table.TableDef, len(stmt.Tables))
 	for i, ref := range stmt.Tables {
@@ -135,94 +213,140 @@ func qlSelectJoin(tx table.Reader, stmt Statement) (Result, error) {
 		return Result{}, err
 	}
 
-	// Resolve ON and WHERE expressions: replace bare column names with
-	// qualified names where unambiguous.
-	onExprs := make([]*Expr, len(stmt.Tables))
-	for i, ref := range stmt.Tables {
-		if ref.OnExpr != nil {
-			resolved := resolveExprCols(*ref.OnExpr, tdefs, stmt.Tables)
-			onExprs[i] = &resolved
-		}
-	}
-	var where *Expr
-	if stmt.Where != nil {
-		resolved := resolveExprCols(*stmt.Where, tdefs, stmt.Tables)
-		where = &resolved
-	}
+	// Plan the join: the order of the tables, how the leftmost one is
+	// scanned, and which right-hand tables can be probed by key.
+	on, where := joinExprs(stmt, tdefs)
+	jp := planJoin(tx, tdefs, stmt.Tables, on, where)
+	refs, tdefs, onExprs, lookups := jp.refs, jp.tdefs, jp.on, jp.lookups
 
-	// Nested-loop join: scan leftmost table, for each row scan all right tables.
+	// Nested-loop join: scan leftmost table, for each row scan or probe all
+	// right tables.
 	var rows []table.Record
 
 	// Build scanner for leftmost table.
-	leftSc := &table.Scanner{Cmp1: btree.CmpGE}
+	leftSc := jp.left.sc
 	if err := tx.Scan(tdefs[0].Name, leftSc); err != nil {
 		return Result{}, err
 	}
 
-	for leftSc.Valid() {
+	for leftSc.Valid() && !limitReached(stmt, len(rows)) {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
 		var leftRec table.Record
 		leftSc.Deref(&leftRec)
 		leftSc.Next()
+		if jp.leftWhere != nil {
+			// An error is left for WHERE to report on the joined rows.
+			v, err := evalExpr(*jp.leftWhere, recordToMap(leftRec))
+			if err == nil && (v.Type != table.TypeInt64 || v.I64 == 0) {
+				continue
+			}
+		}
 
-		leftAlias := tableAlias(stmt.Tables[0], 0)
+		leftAlias := tableAlias(refs[0], 0)
 		leftQualified := qualifyRecord(leftRec, leftAlias)
 
 		leftRows := []table.Record{leftQualified}
 		compatible := true
 
-		for rightIdx := 1; rightIdx < len(stmt.Tables) && compatible; rightIdx++ {
-			rightSc := &table.Scanner{Cmp1: btree.CmpGE}
-			if err := tx.Scan(tdefs[rightIdx].Name, rightSc); err != nil {
-				return Result{}, err
-			}
-
-			rightAlias := tableAlias(stmt.Tables[rightIdx], rightIdx)
+		for rightIdx := 1; rightIdx < len(refs) && compatible; rightIdx++ {
+			rightAlias := tableAlias(refs[rightIdx], rightIdx)
 			var nextLeft []table.Record
 
-			for rightSc.Valid() {
-				var rightRec table.Record
-				rightSc.Deref(&rightRec)
-				rightSc.Next()
-				rightQualified := qualifyRecord(rightRec, rightAlias)
+			// match combines a left row with a right row and keeps the
+			// result if it satisfies ON (and WHERE, at the last table).
+			match := func(lr, rightQualified table.Record) error {
+				combined := combineRows(lr, rightQualified)
+
+				// Evaluate ON clause (if any).
+				if onExprs[rightIdx] != nil {
+					rowMap := joinRecordToMap(combined, refs[:rightIdx+1])
+					v, err := evalExpr(*onExprs[rightIdx], rowMap)
+					if err != nil {
+						return err
+					}
+					if v.Type != table.TypeInt64 || v.I64 == 0 {
+						return nil
+					}
+				}
+
+				// Evaluate WHERE on final combined row.
+				if rightIdx == len(refs)-1 && where != nil {
+					rowMap := joinRecordToMap(combined, refs)
+					v, err := evalExpr(*where, rowMap)
+					if err != nil {
+						return err
+					}
+					if v.Type != table.TypeInt64 || v.I64 == 0 {
+						return nil
+					}
+				}
 
-				for _, lr := range leftRows {
-					combined := combineRows(lr, rightQualified)
+				nextLeft = append(nextLeft, combined)
+				return nil
+			}
+			// full reports whether the rows matched so far already satisfy
+			// LIMIT, so the last table's scan can stop early.
+			last := rightIdx == len(refs)-1
+			full := func() bool {
+				return last && limitReached(stmt, len(rows)+len(nextLeft))
+			}
 
-					// Evaluate ON clause (if any).
-					if onExprs[rightIdx] != nil {
-						rowMap := joinRecordToMap(combined, stmt.Tables[:rightIdx+1])
-						v, err := evalExpr(*onExprs[rightIdx], rowMap)
-						if err != nil {
+			if lookup := lookups[rightIdx]; lookup != nil {
+				// Index-lookup join: probe the right table once per left row.
+				for _, lr := range leftRows {
+					if full() {
+						break
+					}
+					rightSc, err := lookup.probe(tdefs[rightIdx], joinRecordToMap(lr, refs[:rightIdx]))
+					if err != nil {
+						return Result{}, err
+					}
+					if err := tx.Scan(tdefs[rightIdx].Name, rightSc); err != nil {
+						return Result{}, err
+					}
+					for rightSc.Valid() && !full() {
+						if err := ctx.Err(); err != nil {
 							return Result{}, err
 						}
-						if v.Type != table.TypeInt64 || v.I64 == 0 {
-							continue
+						var rightRec table.Record
+						rightSc.Deref(&rightRec)
+						rightSc.Next()
+						if err := match(lr, qualifyRecord(rightRec, rightAlias)); err != nil {
+							return Result{}, err
 						}
 					}
-
-					// Evaluate WHERE on final combined row.
-					if rightIdx == len(stmt.Tables)-1 && where != nil {
-						rowMap := joinRecordToMap(combined, stmt.Tables)
-						v, err := evalExpr(*where, rowMap)
-						if err != nil {
+				}
+			} else {
+				// Nested-loop join: one full scan of the right table.
+				rightSc := &table.Scanner{Cmp1: btree.CmpGE}
+				if err := tx.Scan(tdefs[rightIdx].Name, rightSc); err != nil {
+					return Result{}, err
+				}
+				for rightSc.Valid() && !full() {
+					if err := ctx.Err(); err != nil {
+						return Result{}, err
+					}
+					var rightRec table.Record
+					rightSc.Deref(&rightRec)
+					rightSc.Next()
+					rightQualified := qualifyRecord(rightRec, rightAlias)
+					for _, lr := range leftRows {
+						if err := match(lr, rightQualified); err != nil {
 							return Result{}, err
 						}
-						if v.Type != table.TypeInt64 || v.I64 == 0 {
-							continue
-						}
 					}
-
-					nextLeft = append(nextLeft, combined)
 				}
 			}
 
 			// LEFT JOIN: if no right rows matched, emit left rows with NULLs.
-			if len(nextLeft) == 0 && stmt.Tables[rightIdx].JoinType == JoinLeft {
+			if len(nextLeft) == 0 && refs[rightIdx].JoinType == JoinLeft {
 				for _, lr := range leftRows {
-					nulls := makeNullRecord(tdefs[rightIdx], stmt.Tables[rightIdx])
+					nulls := makeNullRecord(tdefs[rightIdx], refs[rightIdx])
 					combined := combineRows(lr, nulls)
-					if rightIdx == len(stmt.Tables)-1 && where != nil {
-						rowMap := joinRecordToMap(combined, stmt.Tables)
+					if rightIdx == len(refs)-1 && where != nil {
+						rowMap := joinRecordToMap(combined, refs)
 						v, err := evalExpr(*where, rowMap)
 						if err != nil {
 							return Result{}, err
@@ -243,13 +367,34 @@ func qlSelectJoin(tx table.Reader, stmt Statement) (Result, error) {
 
 		// Project and append results.
 		for _, lr := range leftRows {
-			rowMap := joinRecordToMap(lr, stmt.Tables)
+			if limitReached(stmt, len(rows)) {
+				break
+			}
+			rowMap := joinRecordToMap(lr, refs)
 			projected := projectJoinRecord(rowMap, outputCols)
 			rows = append(rows, projected)
 		}
 	}
 
-	return Result{Rows: rows}, nil
+	return Result{Rows: applyOffset(stmt, rows)}, nil
+}
+
+// joinExprs resolves the column references of the ON conditions and the
+// WHERE clause of a join, qualifying bare column names where unambiguous.
+// on[i] is nil when refs[i] has no ON condition.
+func joinExprs(stmt Statement, tdefs []*table.TableDef) (on []*Expr, where *Expr) {
+	on = make([]*Expr, len(stmt.Tables))
+	for i, ref := range stmt.Tables {
+		if ref.OnExpr != nil {
+			resolved := resolveExprCols(*ref.OnExpr, tdefs, stmt.Tables)
+			on[i] = &resolved
+		}
+	}
+	if stmt.Where != nil {
+		resolved := resolveExprCols(*stmt.Where, tdefs, stmt.Tables)
+		where = &resolved
+	}
+	return on, where
 }
 
 // qualifyRecord prefixes each column in a record with "alias.".
@@ -374,7 +519,7 @@ func resolveExprCols(expr Expr, tdefs []*table.TableDef, refs []TableRef) Expr {
 	switch expr.Kind {
 	case ExprCol:
 		if containsDot(expr.Col) {
-			return expr
+			return collateCol(expr, tdefs, refs)
 		}
 		// Bare column name — try to qualify.
 		matches := findColInAll(tdefs, expr.Col)
@@ -382,7 +527,7 @@ func resolveExprCols(expr Expr, tdefs []*table.TableDef, refs []TableRef) Expr {
 			expr.Col = matches[0] + "." + expr.Col
 		}
 		// If 0 or >1, leave bare — error will be reported at eval time.
-		return expr
+		return collateCol(expr, tdefs, refs)
 	case ExprBinop:
 		left := resolveExprCols(*expr.Left, tdefs, refs)
 		right := resolveExprCols(*expr.Right, tdefs, refs)
@@ -392,6 +537,31 @@ func resolveExprCols(expr Expr, tdefs []*table.TableDef, refs []TableRef) Expr {
 	}
 }
 
+// collateCol sets the collation of the qualified column expr.
+func collateCol(expr Expr, tdefs []*table.TableDef, refs []TableRef) Expr {
+	name, col := splitQualified(expr.Col)
+	if i := findTableIndex(refs, name); i >= 0 {
+		expr.Collate = tdefs[i].Collate[col]
+	}
+	return expr
+}
+
+// collateExpr returns expr with the collations of the columns of tdef it
+// refers to set, so that its comparisons follow them.
+func collateExpr(tdef *table.TableDef, expr *Expr) *Expr {
+	if expr == nil || len(tdef.Collate) == 0 {
+		return expr
+	}
+	out := *expr
+	switch expr.Kind {
+	case ExprCol:
+		out.Collate = tdef.Collate[expr.Col]
+	case ExprBinop:
+		out.Left, out.Right = collateExpr(tdef, expr.Left), collateExpr(tdef, expr.Right)
+	}
+	return &out
+}
+
 // findTableIndex finds the index of a table reference by name or alias.
 func findTableIndex(refs []TableRef, name string) int {
 	for i, ref := range refs {
@@ -443,15 +613,16 @@ func joinStrings(ss []string) string {
 	return s
 }
 
-func qlSelect(tx table.Reader, stmt Statement) (Result, error) {
+func qlSelect(ctx context.Context, tx table.Reader, stmt Statement) (Result, error) {
 	if len(stmt.Tables) > 1 {
-		return qlSelectJoin(tx, stmt)
+		return qlSelectJoin(ctx, tx, stmt)
 	}
 
 	tdef := tx.TableDef(stmt.Table())
 	if tdef == nil {
 		return Result{}, fmt.Errorf("table not found: %s", stmt.Table())
 	}
+	stmt.Where = collateExpr(tdef, stmt.Where)
 
 	outputCols := qlExpandStar(tx, tdef, stmt.Cols)
 
@@ -467,9 +638,15 @@ func qlSelect(tx table.Reader, stmt Statement) (Result, error) {
 	if err != nil {
 		return Result{}, err
 	}
+	if stmt.Where == nil {
+		stmt.Offset = 0 // skipped by the scan
+	}
 
 	var rows []table.Record
-	for sc.Valid() {
+	for sc.Valid() && !limitReached(stmt, len(rows)) {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
 		var full table.Record
 		sc.Deref(&full)
 		sc.Next()
@@ -491,69 +668,85 @@ func qlSelect(tx table.Reader, stmt Statement) (Result, error) {
 		rows = append(rows, projectRecord(full, outputCols))
 	}
 
-	return Result{Rows: rows}, nil
+	return Result{Rows: applyOffset(stmt, rows)}, nil
 }
 
-// qlScan builds and initialises a Scanner for the statement's WHERE clause.
-// If Where describes a simple primary-key comparison we use it as a range
-// bound; otherwise we do a full scan and let qlSelect filter in memory.
-func qlScan(tx table.Reader, tdef *table.TableDef, stmt Statement) (*table.Scanner, error) {
-	sc := &table.Scanner{}
+// qlSelectPage is qlSelect for one page of a single-table SELECT (see
+// SelectPage). WHERE and OFFSET go into the scanner's Filter, so that the
+// scan can resume from the page token alone.
+func qlSelectPage(ctx context.Context, tx table.Reader, stmt Statement, limit, returned int, token string) ([]table.Record, string, error) {
+	tdef := tx.TableDef(stmt.Table())
+	if tdef == nil {
+		return nil, "", fmt.Errorf("table not found: %s", stmt.Table())
+	}
+	stmt.Where = collateExpr(tdef, stmt.Where)
+	outputCols := qlExpandStar(tx, tdef, stmt.Cols)
+	for _, c := range outputCols {
+		if table.ColIndex(tdef, c) < 0 {
+			return nil, "", fmt.Errorf("unknown column: %s", c)
+		}
+	}
+	if stmt.HasLimit {
+		limit = min(limit, stmt.Limit-returned)
+		if limit <= 0 {
+			return nil, "", nil
+		}
+	}
 
+	sc := planScan(tx, tdef, stmt.Where, selectCols(tdef, stmt)).sc
+	skip := 0
+	if token == "" {
+		skip = stmt.Offset
+	}
+	// Once WHERE fails, every row passes: the page stops after at most
+	// limit rows, and tells from matched whether the failing row was one of
+	// them or the one after them.
+	var ferr error
+	matched := 0
 	if stmt.Where != nil {
-		// Try to extract a primary-key range from the WHERE expression.
-		cmp, key, ok := extractPKRange(tdef, stmt.Where)
-		if ok {
-			sc.Cmp1 = cmp
-			sc.Key1 = key
-			sc.Cmp2 = 0 // prefix scan: bounded by the next prefix
-		} else {
-			// Full scan; WHERE is evaluated post-scan.
-			sc.Cmp1 = btree.CmpGE
+		sc.Filter = func(rec table.Record) bool {
+			if ferr != nil {
+				return true
+			}
+			if ferr = ctx.Err(); ferr != nil {
+				return true
+			}
+			v, err := evalExpr(*stmt.Where, recordToMap(rec))
+			if err != nil {
+				ferr = err
+				return true
+			}
+			if v.Type != table.TypeInt64 || v.I64 == 0 {
+				return false
+			}
+			if skip > 0 {
+				skip--
+				return false
+			}
+			matched++
+			return true
 		}
 	} else {
-		sc.Cmp1 = btree.CmpGE
+		sc.Offset = skip
 	}
-
 	if err := tx.Scan(stmt.Table(), sc); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return sc, nil
-}
-
-// extractPKRange tries to decompose a WHERE expr of the form
-//
-//	pkCol cmp literal
-//
-// into a scanner bound.  Returns (cmp, key, true) on success.
-func extractPKRange(tdef *table.TableDef, expr *Expr) (int, table.Record, bool) {
-	if expr == nil || expr.Kind != ExprBinop {
-		return 0, table.Record{}, false
-	}
-	// We only handle "col op literal" for now.
-	if expr.Left == nil || expr.Right == nil {
-		return 0, table.Record{}, false
-	}
-	if expr.Left.Kind != ExprCol {
-		return 0, table.Record{}, false
+	page, next, err := sc.Page(limit, token)
+	if err != nil {
+		return nil, "", err
 	}
-	col := expr.Left.Col
-	// Must be the first primary-key column.
-	if tdef.PKeys == 0 || tdef.Cols[0] != col {
-		return 0, table.Record{}, false
+	if ferr != nil && matched < limit {
+		return nil, "", ferr
 	}
-	cmp, err := cmpFromStr(expr.Op)
-	if err != nil {
-		return 0, table.Record{}, false
+	if stmt.HasLimit && returned+len(page) >= stmt.Limit {
+		next = ""
 	}
-	val, ok := literalValue(tdef, col, expr.Right)
-	if !ok {
-		return 0, table.Record{}, false
+	rows := make([]table.Record, 0, len(page))
+	for _, rec := range page {
+		rows = append(rows, projectRecord(rec, outputCols))
 	}
-	rec := table.Record{}
-	rec.Cols = []string{col}
-	rec.Vals = []table.Value{val}
-	return cmp, rec, true
+	return rows, next, nil
 }
 
 // literalValue converts a literal Expr to a table.Value typed according to tdef.
@@ -578,31 +771,39 @@ func literalValue(tdef *table.TableDef, col string, expr *Expr) (table.Value, bo
 	return table.Value{}, false
 }
 
+// limitReached reports whether n rows, including those OFFSET leaves out,
+// satisfy the statement's LIMIT.
+func limitReached(stmt Statement, n int) bool {
+	return stmt.HasLimit && n >= stmt.Offset+stmt.Limit
+}
+
+// applyOffset drops the rows the statement's OFFSET leaves out.
+func applyOffset(stmt Statement, rows []table.Record) []table.Record {
+	return rows[min(stmt.Offset, len(rows)):]
+}
+
 // ---------------------------------------------------------------------------
 // UPDATE
 // ---------------------------------------------------------------------------
 
-func qlUpdate(tx table.Writer, stmt Statement) (Result, error) {
+func qlUpdate(ctx context.Context, tx table.Writer, stmt Statement) (Result, error) {
 	tdef := tx.TableDef(stmt.Table())
 	if tdef == nil {
 		return Result{}, fmt.Errorf("table not found: %s", stmt.Table())
 	}
+	stmt.Where = collateExpr(tdef, stmt.Where)
 
 	// Scan for matching rows (same logic as SELECT).
-	sc := &table.Scanner{Cmp1: btree.CmpGE}
-	if stmt.Where != nil {
-		cmp, key, ok := extractPKRange(tdef, stmt.Where)
-		if ok {
-			sc.Cmp1 = cmp
-			sc.Key1 = key
-		}
-	}
+	sc := planScan(tx, tdef, stmt.Where, nil).sc
 	if err := tx.Scan(stmt.Table(), sc); err != nil {
 		return Result{}, err
 	}
 
 	affected := 0
 	for sc.Valid() {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
 		var full table.Record
 		sc.Deref(&full)
 		sc.Next()
@@ -640,20 +841,14 @@ func qlUpdate(tx table.Writer, stmt Statement) (Result, error) {
 // DELETE
 // ---------------------------------------------------------------------------
 
-func qlDelete(tx table.Writer, stmt Statement) (Result, error) {
+func qlDelete(ctx context.Context, tx table.Writer, stmt Statement) (Result, error) {
 	tdef := tx.TableDef(stmt.Table())
 	if tdef == nil {
 		return Result{}, fmt.Errorf("table not found: %s", stmt.Table())
 	}
+	stmt.Where = collateExpr(tdef, stmt.Where)
 
-	sc := &table.Scanner{Cmp1: btree.CmpGE}
-	if stmt.Where != nil {
-		cmp, key, ok := extractPKRange(tdef, stmt.Where)
-		if ok {
-			sc.Cmp1 = cmp
-			sc.Key1 = key
-		}
-	}
+	sc := planScan(tx, tdef, stmt.Where, nil).sc
 	if err := tx.Scan(stmt.Table(), sc); err != nil {
 		return Result{}, err
 	}
@@ -661,6 +856,9 @@ func qlDelete(tx table.Writer, stmt Statement) (Result, error) {
 	// Collect primary keys first to avoid mutating while iterating.
 	var toDelete []table.Record
 	for sc.Valid() {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
 		var full table.Record
 		sc.Deref(&full)
 		sc.Next()
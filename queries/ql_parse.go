@@ -1,6 +1,7 @@
 package queries
 
 import (
+	"cmp"
 	"fmt"
 	"strconv"
 	"strings"
@@ -165,27 +166,82 @@ func (p *parser) sym(s string) bool {
 }
 
 // ---------------------------------------------------------------------------
-// Expression parser (recursive descent, two precedence levels)
+// Expression parser (recursive descent)
 // ---------------------------------------------------------------------------
 
-// parseExpr parses a comparison or arithmetic expression.
+// parseExpr parses a boolean, comparison or arithmetic expression.
+// Precedence from loosest to tightest: OR, AND, comparison, + -, * /.
 func (p *parser) parseExpr() (Expr, error) {
-	return p.parseCmp()
+	return p.parseOr()
 }
 
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return Expr{}, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return Expr{}, err
+		}
+		l := left
+		left = Expr{Kind: ExprBinop, Op: "OR", Left: &l, Right: &right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseCmp()
+	if err != nil {
+		return Expr{}, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseCmp()
+		if err != nil {
+			return Expr{}, err
+		}
+		l := left
+		left = Expr{Kind: ExprBinop, Op: "AND", Left: &l, Right: &right}
+	}
+	return left, nil
+}
+
+// parseCmp parses a single comparison. A bare "=" is accepted as a synonym
+// for "==", and "x BETWEEN a AND b" is desugared to "x >= a AND x <= b".
 func (p *parser) parseCmp() (Expr, error) {
 	left, err := p.parseAddSub()
 	if err != nil {
 		return Expr{}, err
 	}
 	t := p.peek()
-	if t.Kind == TokenCmp {
+	if t.Kind == TokenCmp || (t.Kind == TokenSym && t.Text == "=") {
 		p.consume()
 		right, err := p.parseAddSub()
 		if err != nil {
 			return Expr{}, err
 		}
-		return Expr{Kind: ExprBinop, Op: t.Text, Left: &left, Right: &right}, nil
+		op := t.Text
+		if op == "=" {
+			op = "=="
+		}
+		return Expr{Kind: ExprBinop, Op: op, Left: &left, Right: &right}, nil
+	}
+	if p.keyword("BETWEEN") {
+		lo, err := p.parseAddSub()
+		if err != nil {
+			return Expr{}, err
+		}
+		if !p.keyword("AND") {
+			return Expr{}, fmt.Errorf("expected AND in BETWEEN")
+		}
+		hi, err := p.parseAddSub()
+		if err != nil {
+			return Expr{}, err
+		}
+		ge := Expr{Kind: ExprBinop, Op: ">=", Left: &left, Right: &lo}
+		le := Expr{Kind: ExprBinop, Op: "<=", Left: &left, Right: &hi}
+		return Expr{Kind: ExprBinop, Op: "AND", Left: &ge, Right: &le}, nil
 	}
 	return left, nil
 }
@@ -205,7 +261,8 @@ func (p *parser) parseAddSub() (Expr, error) {
 		if err != nil {
 			return Expr{}, err
 		}
-		left = Expr{Kind: ExprBinop, Op: t.Text, Left: &left, Right: &right}
+		l := left
+		left = Expr{Kind: ExprBinop, Op: t.Text, Left: &l, Right: &right}
 	}
 	return left, nil
 }
@@ -225,7 +282,8 @@ func (p *parser) parseMulDiv() (Expr, error) {
 		if err != nil {
 			return Expr{}, err
 		}
-		left = Expr{Kind: ExprBinop, Op: t.Text, Left: &left, Right: &right}
+		l := left
+		left = Expr{Kind: ExprBinop, Op: t.Text, Left: &l, Right: &right}
 	}
 	return left, nil
 }
@@ -294,12 +352,42 @@ func evalExpr(expr Expr, row map[string]table.Value) (table.Value, error) {
 		if err != nil {
 			return table.Value{}, err
 		}
+		if coll := exprCollation(expr); coll != nil && l.Type == table.TypeBytes && r.Type == table.TypeBytes {
+			l.Str, r.Str = coll(nil, l.Str), coll(nil, r.Str)
+		}
 		return evalBinop(expr.Op, l, r)
 	}
 	return table.Value{}, fmt.Errorf("unknown expr kind")
 }
 
+// exprCollation returns the collation a comparison compares its operands
+// by: that of a collated column on either side, the left one first.
+func exprCollation(expr Expr) table.Collation {
+	switch expr.Op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil
+	}
+	for _, side := range []*Expr{expr.Left, expr.Right} {
+		if side.Kind == ExprCol && side.Collate != "" {
+			coll, _ := table.LookupCollation(side.Collate)
+			return coll
+		}
+	}
+	return nil
+}
+
 func evalBinop(op string, l, r table.Value) (table.Value, error) {
+	// Logical connectives — operands are truth values (non-zero int64).
+	if op == "AND" || op == "OR" {
+		lt := l.Type == table.TypeInt64 && l.I64 != 0
+		rt := r.Type == table.TypeInt64 && r.I64 != 0
+		var result int64
+		if (op == "AND" && lt && rt) || (op == "OR" && (lt || rt)) {
+			result = 1
+		}
+		return table.Value{Type: table.TypeInt64, I64: result}, nil
+	}
 	// Arithmetic (int64 only)
 	if l.Type == table.TypeInt64 && r.Type == table.TypeInt64 {
 		switch op {
@@ -366,6 +454,10 @@ func compareValues(l, r table.Value) (int, error) {
 			return 1, nil
 		}
 		return 0, nil
+	case table.TypeFloat64:
+		return cmp.Compare(l.F64, r.F64), nil
+	case table.TypeUUID:
+		return strings.Compare(string(l.Str), string(r.Str)), nil
 	case table.TypeBytes:
 		c := strings.Compare(string(l.Str), string(r.Str))
 		return c, nil
@@ -402,7 +494,19 @@ func ParseStatement(input string) (Statement, error) {
 	if err != nil {
 		return Statement{}, err
 	}
+	return p.parseStatement(kw)
+}
+
+func (p *parser) parseStatement(kw string) (Statement, error) {
 	switch strings.ToUpper(kw) {
+	case "EXPLAIN":
+		kw, err := p.expectIdent()
+		if err != nil {
+			return Statement{}, err
+		}
+		stmt, err := p.parseStatement(kw)
+		stmt.Explain = true
+		return stmt, err
 	case "SELECT":
 		return p.parseSelect()
 	case "INSERT":
@@ -415,11 +519,15 @@ func ParseStatement(input string) (Statement, error) {
 		return p.parseDelete()
 	case "CREATE":
 		return p.parseCreateTable()
+	case "ANALYZE":
+		return p.parseAnalyze()
+	case "ALTER":
+		return p.parseAlterTable()
 	}
 	return Statement{}, fmt.Errorf("unknown statement keyword: %s", kw)
 }
 
-// SELECT col, ... FROM table [[AS] alias] [JOIN ...] [WHERE expr]
+// SELECT col, ... FROM table [[AS] alias] [JOIN ...] [WHERE expr] [LIMIT n]
 func (p *parser) parseSelect() (Statement, error) {
 	stmt := Statement{Kind: StmtSelect}
 
@@ -508,6 +616,26 @@ func (p *parser) parseSelect() (Statement, error) {
 		stmt.Where = &expr
 	}
 
+	// Optional LIMIT
+	if p.keyword("LIMIT") {
+		t := p.consume()
+		n, err := strconv.Atoi(t.Text)
+		if t.Kind != TokenInt || err != nil || n < 0 {
+			return stmt, fmt.Errorf("bad LIMIT: %s", t.Text)
+		}
+		stmt.HasLimit, stmt.Limit = true, n
+	}
+
+	// Optional OFFSET
+	if p.keyword("OFFSET") {
+		t := p.consume()
+		n, err := strconv.Atoi(t.Text)
+		if t.Kind != TokenInt || err != nil || n < 0 {
+			return stmt, fmt.Errorf("bad OFFSET: %s", t.Text)
+		}
+		stmt.Offset = n
+	}
+
 	return stmt, nil
 }
 
@@ -524,7 +652,7 @@ func (p *parser) parseTableRef(joinType JoinType) (TableRef, error) {
 		up := upper(t.Text)
 		if up != "JOIN" && up != "INNER" && up != "LEFT" && up != "CROSS" &&
 			up != "ON" && up != "WHERE" && up != "AS" && up != "ORDER" &&
-			up != "GROUP" && up != "LIMIT" && up != "HAVING" {
+			up != "GROUP" && up != "LIMIT" && up != "OFFSET" && up != "HAVING" {
 			ref.Alias = t.Text
 			p.consume()
 		} else if up == "AS" {
@@ -673,7 +801,67 @@ func (p *parser) parseDelete() (Statement, error) {
 	return stmt, nil
 }
 
-// CREATE TABLE name (col type, ..., PRIMARY KEY (col, ...) [, INDEX (col, ...)] ...)
+// ANALYZE table
+func (p *parser) parseAnalyze() (Statement, error) {
+	stmt := Statement{Kind: StmtAnalyze}
+	tbl, err := p.expectIdent()
+	if err != nil {
+		return stmt, err
+	}
+	stmt.Tables = append(stmt.Tables, TableRef{Name: tbl})
+	return stmt, nil
+}
+
+// ALTER TABLE table DROP [COLUMN] col
+// ALTER TABLE table ALTER [COLUMN] col [SET DATA] TYPE type
+// ALTER TABLE table RENAME [COLUMN] col TO name
+func (p *parser) parseAlterTable() (Statement, error) {
+	stmt := Statement{Kind: StmtAlterTable}
+	if !p.keyword("TABLE") {
+		return stmt, fmt.Errorf("expected TABLE")
+	}
+	tbl, err := p.expectIdent()
+	if err != nil {
+		return stmt, err
+	}
+	stmt.Tables = append(stmt.Tables, TableRef{Name: tbl})
+
+	switch {
+	case p.keyword("DROP"):
+		stmt.Alter = AlterDropColumn
+	case p.keyword("ALTER"):
+		stmt.Alter = AlterColumnType
+	case p.keyword("RENAME"):
+		stmt.Alter = AlterRenameColumn
+	default:
+		return stmt, fmt.Errorf("expected DROP, ALTER or RENAME")
+	}
+	p.keyword("COLUMN")
+	if stmt.AlterCol, err = p.expectIdent(); err != nil {
+		return stmt, err
+	}
+	switch stmt.Alter {
+	case AlterColumnType:
+		if p.keyword("SET") && !p.keyword("DATA") {
+			return stmt, fmt.Errorf("expected DATA")
+		}
+		if !p.keyword("TYPE") {
+			return stmt, fmt.Errorf("expected TYPE")
+		}
+		stmt.AlterType, err = parseTypeKeyword(p)
+	case AlterRenameColumn:
+		if !p.keyword("TO") {
+			return stmt, fmt.Errorf("expected TO")
+		}
+		stmt.AlterName, err = p.expectIdent()
+	}
+	return stmt, err
+}
+
+// CREATE TABLE name (col type [COLLATE name], ..., PRIMARY KEY (col [ASC|DESC], ...) [, [UNIQUE] INDEX (col [ASC|DESC], ...) [INCLUDE (col, ...)] [WHERE expr]] ...)
+//
+// The sort direction belongs to the column, so a column must not be DESC in
+// one key and ASC in another.
 func (p *parser) parseCreateTable() (Statement, error) {
 	stmt := Statement{Kind: StmtCreateTable}
 
@@ -690,6 +878,7 @@ func (p *parser) parseCreateTable() (Statement, error) {
 		return stmt, err
 	}
 
+	dirs := map[string]bool{} // column -> DESC, for columns given a direction
 	for {
 		t := p.peek()
 		if t.Kind == TokenIdent && strings.EqualFold(t.Text, "PRIMARY") {
@@ -701,7 +890,7 @@ func (p *parser) parseCreateTable() (Statement, error) {
 			if _, err := p.expect(TokenSym, "("); err != nil {
 				return stmt, err
 			}
-			pkCols, err := p.parseColList()
+			pkCols, err := p.parseKeyList(dirs)
 			if err != nil {
 				return stmt, err
 			}
@@ -711,17 +900,36 @@ func (p *parser) parseCreateTable() (Statement, error) {
 			if err != nil {
 				return stmt, err
 			}
-		} else if t.Kind == TokenIdent && strings.EqualFold(t.Text, "INDEX") {
-			// INDEX (col, ...)
-			p.consume()
+		} else if t.Kind == TokenIdent && (strings.EqualFold(t.Text, "INDEX") || strings.EqualFold(t.Text, "UNIQUE")) {
+			// [UNIQUE] INDEX (col, ...) [INCLUDE (col, ...)] [WHERE expr]
+			unique := p.keyword("UNIQUE")
+			if !p.keyword("INDEX") {
+				return stmt, fmt.Errorf("expected INDEX after UNIQUE")
+			}
 			if _, err := p.expect(TokenSym, "("); err != nil {
 				return stmt, err
 			}
-			cols, err := p.parseColList()
+			cols, err := p.parseKeyList(dirs)
 			if err != nil {
 				return stmt, err
 			}
-			stmt.Indexes = append(stmt.Indexes, IndexDef{Cols: cols})
+			idx := IndexDef{Cols: cols, Unique: unique}
+			if p.keyword("INCLUDE") {
+				if _, err := p.expect(TokenSym, "("); err != nil {
+					return stmt, err
+				}
+				if idx.Include, err = p.parseColList(); err != nil {
+					return stmt, err
+				}
+			}
+			if p.keyword("WHERE") {
+				expr, err := p.parseExpr()
+				if err != nil {
+					return stmt, err
+				}
+				idx.Where = &expr
+			}
+			stmt.Indexes = append(stmt.Indexes, idx)
 		} else if t.Kind == TokenIdent {
 			// col type
 			col, err := p.expectIdent()
@@ -732,7 +940,16 @@ func (p *parser) parseCreateTable() (Statement, error) {
 			if err != nil {
 				return stmt, err
 			}
-			stmt.ColDefs = append(stmt.ColDefs, ColDef{Name: col, Type: typ})
+			cd := ColDef{Name: col, Type: typ}
+			if p.keyword("COLLATE") {
+				if cd.Collate, err = p.expectIdent(); err != nil {
+					return stmt, err
+				}
+				if strings.EqualFold(cd.Collate, table.CollateBinary) || strings.EqualFold(cd.Collate, table.CollateNoCase) {
+					cd.Collate = strings.ToLower(cd.Collate)
+				}
+			}
+			stmt.ColDefs = append(stmt.ColDefs, cd)
 		} else {
 			return stmt, fmt.Errorf("unexpected token in CREATE TABLE: %q", t.Text)
 		}
@@ -753,6 +970,9 @@ func (p *parser) parseCreateTable() (Statement, error) {
 	if stmt.PKeys == 0 {
 		return stmt, fmt.Errorf("CREATE TABLE %s: missing PRIMARY KEY", stmt.Table())
 	}
+	for i := range stmt.ColDefs {
+		stmt.ColDefs[i].Desc = dirs[stmt.ColDefs[i].Name]
+	}
 
 	return stmt, nil
 }
@@ -776,6 +996,39 @@ func (p *parser) parseColList() ([]string, error) {
 	return cols, nil
 }
 
+// parseKeyList is parseColList for the columns of a key, each optionally
+// followed by ASC or DESC, which it records in dirs.
+func (p *parser) parseKeyList(dirs map[string]bool) ([]string, error) {
+	var cols []string
+	for {
+		col, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		desc, given := false, true
+		switch {
+		case p.keyword("DESC"):
+			desc = true
+		case p.keyword("ASC"):
+		default:
+			given = false
+		}
+		if old, ok := dirs[col]; given && ok && old != desc {
+			return nil, fmt.Errorf("column %s is both ASC and DESC", col)
+		} else if given {
+			dirs[col] = desc
+		}
+		cols = append(cols, col)
+		if !p.sym(",") {
+			break
+		}
+	}
+	if _, err := p.expect(TokenSym, ")"); err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
 // reorderColDefs reorders defs so that the columns named in pkCols come first,
 // preserving their relative order within both groups.
 func reorderColDefs(defs []ColDef, pkCols []string) ([]ColDef, error) {
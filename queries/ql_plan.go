@@ -0,0 +1,731 @@
+package queries
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/MHS-20/ElkDB/btree"
+	table "github.com/MHS-20/ElkDB/tables"
+)
+
+// ---------------------------------------------------------------------------
+// Access-path planning
+// ---------------------------------------------------------------------------
+
+// Access paths chosen by planScan, as reported by EXPLAIN.
+const (
+	PathFullScan  = "full scan"
+	PathPKRange   = "primary key range"
+	PathIndexScan = "index scan"
+	PathIndexOnly = "index-only scan" // a SELECT that never reads the rows
+)
+
+// scanPlan is the access path chosen for one table of a statement.
+type scanPlan struct {
+	path  string
+	index []string       // secondary index columns (PathIndexScan only)
+	sc    *table.Scanner // uninitialised; pass to Reader.Scan
+
+	col    string  // the column bounded by lo and hi
+	lo, hi *bound  // in the order of the values of col
+	cost   float64 // relative to a full scan; 0 if unknown
+}
+
+// Costs of the access paths relative to a full scan of the table, per row
+// visited. An index scan fetches each row by its primary key, a descent of
+// the tree rather than the next entry of a leaf.
+const (
+	rangeCost     = 1.0
+	indexScanCost = 4.0
+)
+
+// planScan picks the access path for a single-table WHERE clause. Every
+// literal bound on the first column of the primary key or of a secondary
+// index is a candidate. A partial index is a candidate only if where
+// implies its predicate, and an index being built is none. cols, if not
+// nil, are the columns the statement reads, which may let an index scan
+// read the index alone (see coverScan).
+//
+// Once the table has been analysed (see table.DB.Analyze), each candidate
+// is costed from the histogram of its column: the fraction of the rows its
+// range holds, times indexScanCost for an index scan. The cheapest path
+// wins, a full scan (cost 1) included, so an index whose range holds most
+// of the table is not used. Without statistics a closed range (both
+// bounds, which includes equality) beats a half-open one, and the primary
+// key wins ties since it avoids the extra row fetch an index scan does;
+// with no usable bound the plan is a full scan. The returned scanner
+// covers at least every row that can satisfy where, so the caller still
+// evaluates where on each row.
+func planScan(tx table.Reader, tdef *table.TableDef, where *Expr, cols []string) scanPlan {
+	full := scanPlan{path: PathFullScan, sc: &table.Scanner{Cmp1: btree.CmpGE}, cost: 1}
+	if where == nil {
+		return full
+	}
+
+	var cands []scanPlan
+	try := func(path string, index []string, conds []table.Cond, col string) {
+		lo, hi, ok := extractRange(tdef, col, where)
+		if !ok {
+			return
+		}
+		klo, khi := lo, hi
+		if tdef.Desc[col] {
+			// The key runs from high values to low ones.
+			klo, khi = flipBound(hi), flipBound(lo)
+		}
+		plan := scanPlan{path: path, index: index, sc: rangeScanner(klo, khi), col: col, lo: lo, hi: hi}
+		// Rows outside a partial index satisfy no WHERE that implies its
+		// predicate, so filtering by the predicate loses nothing.
+		plan.sc.Where = conds
+		if cols != nil {
+			coverScan(tdef, &plan, cols)
+		}
+		cands = append(cands, plan)
+	}
+	try(PathPKRange, nil, nil, tdef.Cols[0])
+	for i, index := range tdef.Indexes {
+		conds := indexWhere(tdef, i)
+		if indexReady(tdef, i) && implies(tdef, where, conds) {
+			try(PathIndexScan, index, conds, index[0])
+		}
+	}
+
+	if costs, ok := scanCosts(tx, tdef, cands); ok {
+		best := full
+		for i, plan := range cands {
+			if costs[i] < best.cost {
+				best, best.cost = plan, costs[i]
+			}
+		}
+		return best
+	}
+	best, bestScore := full, 0
+	for _, plan := range cands {
+		score := 1
+		if plan.lo != nil && plan.hi != nil {
+			score = 2
+		}
+		if score > bestScore {
+			best, bestScore = plan, score
+		}
+	}
+	return best
+}
+
+// scanCosts returns the cost of each plan relative to a full scan, or false
+// if the column of one of them has no statistics.
+func scanCosts(tx table.Reader, tdef *table.TableDef, plans []scanPlan) ([]float64, bool) {
+	costs := make([]float64, len(plans))
+	for i, plan := range plans {
+		st, ok, err := tx.ColumnStats(tdef.Name, plan.col)
+		if !ok || err != nil {
+			return nil, false
+		}
+		f := rangeSelectivity(tdef, &st, plan.col, plan.lo, plan.hi)
+		if plan.path == PathIndexScan {
+			costs[i] = f * indexScanCost
+		} else {
+			costs[i] = f * rangeCost
+		}
+	}
+	return costs, true
+}
+
+// cmpOps maps the comparisons of a bound to the operators of a table.Cond.
+var cmpOps = map[int]string{btree.CmpGE: ">=", btree.CmpGT: ">", btree.CmpLE: "<=", btree.CmpLT: "<"}
+
+// rangeSelectivity estimates from st the fraction of the rows of tdef whose
+// col lies between lo and hi, either of which may be nil.
+func rangeSelectivity(tdef *table.TableDef, st *table.ColumnStats, col string, lo, hi *bound) float64 {
+	// P(lo <= x <= hi) = P(x >= lo) + P(x <= hi) - 1
+	f := 1.0
+	for _, b := range []*bound{lo, hi} {
+		if b != nil {
+			f += st.Selectivity(tdef, table.Cond{Col: col, Cmp: cmpOps[b.cmp], Val: b.key.Vals[0]}) - 1
+		}
+	}
+	return max(f, 0)
+}
+
+// indexWhere returns the predicate of index i, which is empty unless it is
+// a partial index.
+func indexWhere(tdef *table.TableDef, i int) []table.Cond {
+	if i < len(tdef.Where) {
+		return tdef.Where[i]
+	}
+	return nil
+}
+
+// indexReady reports whether index i can serve scans: it is not being
+// built (see table.DB.IndexAdd).
+func indexReady(tdef *table.TableDef, i int) bool {
+	return i >= len(tdef.Building) || !tdef.Building[i]
+}
+
+// implies reports whether every row that satisfies where satisfies conds:
+// each condition is one of the AND-ed terms of where, or follows from an
+// equality term on its column.
+func implies(tdef *table.TableDef, where *Expr, conds []table.Cond) bool {
+	terms := conjuncts(where)
+	for _, c := range conds {
+		found := false
+		for _, term := range terms {
+			if term.Kind != ExprBinop || term.Left.Kind != ExprCol || term.Left.Col != c.Col {
+				continue
+			}
+			v, ok := literalValue(tdef, c.Col, term.Right)
+			if !ok {
+				continue
+			}
+			same := term.Op == c.Cmp && table.Cond{Col: c.Col, Cmp: "==", Val: c.Val}.Match(tdef, v)
+			found = found || same || term.Op == "==" && c.Match(tdef, v)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// conjuncts returns the AND-ed terms of expr, which may be nil.
+func conjuncts(expr *Expr) []*Expr {
+	if expr == nil {
+		return nil
+	}
+	if expr.Kind == ExprBinop && expr.Op == "AND" {
+		return append(conjuncts(expr.Left), conjuncts(expr.Right)...)
+	}
+	return []*Expr{expr}
+}
+
+// andExpr returns the AND of terms, or nil for none.
+func andExpr(terms []*Expr) *Expr {
+	var out *Expr
+	for _, term := range terms {
+		if out == nil {
+			out = term
+		} else {
+			out = &Expr{Kind: ExprBinop, Op: "AND", Left: out, Right: term}
+		}
+	}
+	return out
+}
+
+// whereSelectivity estimates the fraction of the rows of tdef that satisfy
+// where, a condition on its bare columns, assuming its terms independent.
+// Only comparisons of a column with a literal are estimated, from the
+// statistics of the column; any other term is taken to match every row.
+func whereSelectivity(tx table.Reader, tdef *table.TableDef, where *Expr) float64 {
+	f := 1.0
+	for _, term := range conjuncts(where) {
+		if term.Kind != ExprBinop || term.Left.Kind != ExprCol || !slices.Contains([]string{"==", "!=", "<", "<=", ">", ">="}, term.Op) {
+			continue
+		}
+		v, ok := literalValue(tdef, term.Left.Col, term.Right)
+		if !ok {
+			continue
+		}
+		if st, ok, err := tx.ColumnStats(tdef.Name, term.Left.Col); ok && err == nil {
+			f *= st.Selectivity(tdef, table.Cond{Col: term.Left.Col, Cmp: term.Op, Val: v})
+		}
+	}
+	return f
+}
+
+// rangeScanner builds a Scanner from the bounds found by extractRange.
+func rangeScanner(lo, hi *bound) *table.Scanner {
+	sc := &table.Scanner{}
+	switch {
+	case lo != nil && hi != nil:
+		sc.Cmp1, sc.Key1 = lo.cmp, lo.key
+		sc.Cmp2, sc.Key2 = hi.cmp, hi.key
+	case lo != nil:
+		sc.Cmp1, sc.Key1 = lo.cmp, lo.key
+	default:
+		sc.Cmp1, sc.Key1 = hi.cmp, hi.key
+	}
+	// A zero Cmp2 makes a prefix scan: bounded by the next table prefix.
+	return sc
+}
+
+// flipBound turns a bound on the values of a descending column into the
+// same bound on its keys.
+func flipBound(b *bound) *bound {
+	if b == nil {
+		return nil
+	}
+	flipped := map[int]int{
+		btree.CmpGE: btree.CmpLE, btree.CmpGT: btree.CmpLT,
+		btree.CmpLE: btree.CmpGE, btree.CmpLT: btree.CmpGT,
+	}
+	return &bound{flipped[b.cmp], b.key}
+}
+
+// coverScan turns an index scan into an index-only scan if the index
+// entries hold every column in cols, its key columns or its included ones.
+// The scanner then returns rows of just cols, read from the entries.
+func coverScan(tdef *table.TableDef, plan *scanPlan, cols []string) {
+	if plan.path != PathIndexScan {
+		return
+	}
+	i := slices.IndexFunc(tdef.Indexes, func(index []string) bool { return slices.Equal(index, plan.index) })
+	var include []string
+	if i < len(tdef.Include) {
+		include = tdef.Include[i]
+	}
+	for _, c := range cols {
+		if !slices.Contains(plan.index, c) && !slices.Contains(include, c) {
+			return
+		}
+	}
+	plan.path = PathIndexOnly
+	plan.sc.Cols = cols
+}
+
+// selectCols returns the columns a single-table SELECT reads: its output
+// columns and those its WHERE clause refers to.
+func selectCols(tdef *table.TableDef, stmt Statement) []string {
+	cols := slices.Clone(qlExpandStar(nil, tdef, stmt.Cols))
+	var walk func(expr *Expr)
+	walk = func(expr *Expr) {
+		switch {
+		case expr == nil:
+		case expr.Kind == ExprCol:
+			if !slices.Contains(cols, expr.Col) {
+				cols = append(cols, expr.Col)
+			}
+		case expr.Kind == ExprBinop:
+			walk(expr.Left)
+			walk(expr.Right)
+		}
+	}
+	walk(stmt.Where)
+	return cols
+}
+
+// qlScan plans and initialises a Scanner for the statement's WHERE clause.
+// A SELECT reads only the columns it needs, from the index alone when it
+// can. Without WHERE, every row of the scan is produced, so the scan itself
+// skips the rows of OFFSET and stops at LIMIT.
+func qlScan(tx table.Reader, tdef *table.TableDef, stmt Statement) (*table.Scanner, error) {
+	sc := planScan(tx, tdef, stmt.Where, selectCols(tdef, stmt)).sc
+	if stmt.Where == nil {
+		sc.Offset = stmt.Offset
+		if stmt.HasLimit {
+			sc.Limit = stmt.Limit
+		}
+	}
+	if err := tx.Scan(stmt.Table(), sc); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// bound is one side of a key range.
+type bound struct {
+	cmp int
+	key table.Record
+}
+
+// extractRange tries to decompose a WHERE expr into bounds on col. It
+// understands
+//
+//	col cmp literal
+//	col == literal
+//	col BETWEEN a AND b   (parsed as col >= a AND col <= b)
+//
+// and AND-ed combinations of those. Returns the lower and upper bound (either
+// may be nil) and true if at least one bound was found.
+func extractRange(tdef *table.TableDef, col string, expr *Expr) (*bound, *bound, bool) {
+	if expr == nil || expr.Kind != ExprBinop || expr.Left == nil || expr.Right == nil {
+		return nil, nil, false
+	}
+	if expr.Op == "AND" {
+		lo1, hi1, ok1 := extractRange(tdef, col, expr.Left)
+		lo2, hi2, ok2 := extractRange(tdef, col, expr.Right)
+		if !ok1 && !ok2 {
+			return nil, nil, false
+		}
+		// Any bound from either side is valid; prefer the left one.
+		if lo1 == nil {
+			lo1 = lo2
+		}
+		if hi1 == nil {
+			hi1 = hi2
+		}
+		return lo1, hi1, true
+	}
+	// We only handle "col op literal" below.
+	if expr.Left.Kind != ExprCol || expr.Left.Col != col {
+		return nil, nil, false
+	}
+	val, ok := literalValue(tdef, col, expr.Right)
+	if !ok {
+		return nil, nil, false
+	}
+	rec := table.Record{}
+	rec.Cols = []string{col}
+	rec.Vals = []table.Value{val}
+	if expr.Op == "==" {
+		return &bound{btree.CmpGE, rec}, &bound{btree.CmpLE, rec}, true
+	}
+	cmp, err := cmpFromStr(expr.Op)
+	if err != nil {
+		return nil, nil, false
+	}
+	if cmp == btree.CmpGE || cmp == btree.CmpGT {
+		return &bound{cmp, rec}, nil, true
+	}
+	return nil, &bound{cmp, rec}, true
+}
+
+// ---------------------------------------------------------------------------
+// EXPLAIN
+// ---------------------------------------------------------------------------
+
+// qlExplain describes how stmt would be executed without running it. The
+// result has one row per table with the columns table, access, index and
+// rows. rows is the number of entries the access path visits before the
+// WHERE filter is applied; for an index lookup, which is probed once per
+// left row, it is the size of the table, the bound for a single probe.
+func qlExplain(tx table.Reader, stmt Statement) (Result, error) {
+	switch stmt.Kind {
+	case StmtSelect, StmtUpdate, StmtDelete:
+	default:
+		return Result{}, fmt.Errorf("EXPLAIN supports SELECT, UPDATE and DELETE only")
+	}
+
+	tdefs := make([]*table.TableDef, len(stmt.Tables))
+	for i, ref := range stmt.Tables {
+		tdefs[i] = tx.TableDef(ref.Name)
+		if tdefs[i] == nil {
+			return Result{}, fmt.Errorf("table not found: %s", ref.Name)
+		}
+	}
+
+	refs, plans := stmt.Tables, []scanPlan{}
+	if len(stmt.Tables) == 1 {
+		// Only a single-table statement narrows its scan with WHERE.
+		var cols []string
+		if stmt.Kind == StmtSelect {
+			cols = selectCols(tdefs[0], stmt)
+		}
+		plans = append(plans, planScan(tx, tdefs[0], collateExpr(tdefs[0], stmt.Where), cols))
+	} else {
+		on, where := joinExprs(stmt, tdefs)
+		jp := planJoin(tx, tdefs, stmt.Tables, on, where)
+		refs, plans = jp.refs, append(plans, jp.left)
+		for i, l := range jp.lookups[1:] {
+			plan := scanPlan{path: PathFullScan, sc: &table.Scanner{Cmp1: btree.CmpGE}}
+			if l != nil {
+				plan.path, plan.index = PathIndexLookup, l.index
+				if l.index == nil {
+					plan.index = jp.tdefs[i+1].Cols[:jp.tdefs[i+1].PKeys]
+				}
+			}
+			plans = append(plans, plan)
+		}
+	}
+
+	var out []table.Record
+	for i, ref := range refs {
+		plan := plans[i]
+		if err := tx.Scan(ref.Name, plan.sc); err != nil {
+			return Result{}, err
+		}
+		n := int64(0)
+		for ; plan.sc.Valid(); plan.sc.Next() {
+			n++
+		}
+
+		rec := table.Record{}
+		rec.AddStr("table", []byte(tableAlias(ref, i)))
+		rec.AddStr("access", []byte(plan.path))
+		rec.AddStr("index", []byte(strings.Join(plan.index, ",")))
+		rec.AddInt64("rows", n)
+		out = append(out, rec)
+	}
+	return Result{Rows: out}, nil
+}
+
+// ---------------------------------------------------------------------------
+// Join planning
+// ---------------------------------------------------------------------------
+
+// PathIndexLookup is the access path of a join's right-hand table when it
+// is probed by key once per left row.
+const PathIndexLookup = "index lookup"
+
+// joinPlan is how a join runs: the order in which its tables are joined,
+// the condition checked as each one is added, and how each one is read.
+type joinPlan struct {
+	refs    []TableRef // the tables, in join order
+	tdefs   []*table.TableDef
+	on      []*Expr       // resolved; on[i] is checked when refs[i] is joined
+	lookups []*joinLookup // nil for a table scanned in full per left row
+	left    scanPlan      // the scan of refs[0]
+
+	// leftWhere holds the terms of WHERE that involve refs[0] alone, with
+	// bare column names. The rows of refs[0] that fail it are dropped
+	// before the join.
+	leftWhere *Expr
+}
+
+// planJoin plans the join of the tables refs, defined by tdefs, with the
+// resolved ON conditions on (on[0] is unused) and WHERE clause where. The
+// leftmost table is read with the plan planScan picks for the terms of
+// WHERE that only involve it. Each following table is probed by key when
+// its ON clause allows (see planJoinLookup), and scanned in full for each
+// left row otherwise.
+//
+// A chain of INNER JOINs whose tables have all been analysed may be joined
+// in another order, the cheapest one found by joinOrder. Each term of the
+// ON conditions then moves to the first table by which every table it
+// involves has been joined. The rows come out in another order too.
+func planJoin(tx table.Reader, tdefs []*table.TableDef, refs []TableRef, on []*Expr, where *Expr) joinPlan {
+	jp := joinPlan{refs: refs, tdefs: tdefs, on: on}
+	if order := joinOrder(tx, tdefs, refs, on, where); order != nil {
+		jp.refs, jp.tdefs, jp.on = nil, nil, make([]*Expr, len(order))
+		for _, i := range order {
+			jp.refs, jp.tdefs = append(jp.refs, refs[i]), append(jp.tdefs, tdefs[i])
+		}
+		terms := make([][]*Expr, len(order))
+		for _, e := range on {
+			for _, term := range conjuncts(e) {
+				k := termPosition(jp.refs, term)
+				terms[k] = append(terms[k], term)
+			}
+		}
+		for k := range terms {
+			jp.on[k] = andExpr(terms[k])
+		}
+	}
+	jp.lookups = make([]*joinLookup, len(jp.refs))
+	for i := 1; i < len(jp.refs); i++ {
+		jp.lookups[i] = planJoinLookup(jp.tdefs[i], jp.refs, i, jp.on[i])
+	}
+	jp.leftWhere = localWhere(where, tableAlias(jp.refs[0], 0))
+	jp.left = planScan(tx, jp.tdefs[0], jp.leftWhere, nil)
+	return jp
+}
+
+// termPosition returns the position in refs of the first table by which
+// all the tables that term involves have been joined, and at least 1. A
+// term with an unqualified column goes last.
+func termPosition(refs []TableRef, term *Expr) int {
+	k := 1
+	var walk func(expr *Expr)
+	walk = func(expr *Expr) {
+		switch expr.Kind {
+		case ExprCol:
+			a, _ := splitQualified(expr.Col)
+			i := findTableIndex(refs, a)
+			if !containsDot(expr.Col) || i < 0 {
+				i = len(refs) - 1
+			}
+			k = max(k, i)
+		case ExprBinop:
+			walk(expr.Left)
+			walk(expr.Right)
+		}
+	}
+	walk(term)
+	return k
+}
+
+// localWhere returns the terms of the resolved WHERE clause where that
+// only involve the table alias, with its columns unqualified, or nil.
+func localWhere(where *Expr, alias string) *Expr {
+	var unqualify func(expr Expr) Expr
+	unqualify = func(expr Expr) Expr {
+		switch expr.Kind {
+		case ExprCol:
+			_, expr.Col = splitQualified(expr.Col)
+		case ExprBinop:
+			left, right := unqualify(*expr.Left), unqualify(*expr.Right)
+			expr.Left, expr.Right = &left, &right
+		}
+		return expr
+	}
+	var terms []*Expr
+	for _, term := range conjuncts(where) {
+		if hasCol(term) && exprRefsOnly(term, map[string]bool{alias: true}) {
+			local := unqualify(*term)
+			terms = append(terms, &local)
+		}
+	}
+	return andExpr(terms)
+}
+
+// hasCol reports whether expr refers to a column.
+func hasCol(expr *Expr) bool {
+	switch expr.Kind {
+	case ExprCol:
+		return true
+	case ExprBinop:
+		return hasCol(expr.Left) || hasCol(expr.Right)
+	}
+	return false
+}
+
+// joinOrder returns the order, as positions in refs, in which to join the
+// tables of an INNER JOIN chain, or nil to keep the written one. It needs
+// the statistics of every table (see table.DB.Analyze) and their row
+// counts. The cost of an order is the rows read: those of the leftmost
+// table's scan, then for each row entering a join, one probe plus the
+// matching rows for an index lookup, or the whole table for a scan. A
+// probe of col matches rows/distinct(col) rows; the rows of the leftmost
+// table that reach the join are estimated from its terms of WHERE
+// (whereSelectivity). Each table in turn starts an order that is extended
+// greedily by the cheapest next join, and the cheapest order wins, the
+// written one on ties.
+func joinOrder(tx table.Reader, tdefs []*table.TableDef, refs []TableRef, on []*Expr, where *Expr) []int {
+	for _, ref := range refs[1:] {
+		if ref.JoinType != JoinInner {
+			return nil
+		}
+	}
+	n := len(refs)
+	rows := make([]float64, n)
+	for i, tdef := range tdefs {
+		count, err := tx.Count(tdef.Name)
+		if _, ok, _ := tx.ColumnStats(tdef.Name, tdef.Cols[0]); !ok || err != nil {
+			return nil
+		}
+		rows[i] = float64(count)
+	}
+	var terms []*Expr
+	for _, e := range on {
+		terms = append(terms, conjuncts(e)...)
+	}
+
+	// step estimates the rows read per left row to join refs[j] after the
+	// tables order, and the rows it matches.
+	step := func(order []int, j int) (read, matched float64) {
+		placed := make([]TableRef, 0, len(order)+1)
+		for _, i := range order {
+			placed = append(placed, refs[i])
+		}
+		placed = append(placed, refs[j])
+		l := planJoinLookup(tdefs[j], placed, len(order), andExpr(terms))
+		if l == nil {
+			return rows[j], rows[j]
+		}
+		matched = 1
+		if st, ok, err := tx.ColumnStats(tdefs[j].Name, l.col); ok && err == nil && st.Distinct > 0 {
+			matched = rows[j] / float64(st.Distinct)
+		}
+		return 1 + matched, matched
+	}
+
+	var best []int
+	bestCost := math.Inf(1)
+	for first := range n {
+		local := localWhere(where, tableAlias(refs[first], first))
+		order := []int{first}
+		cost := rows[first] * planScan(tx, tdefs[first], local, nil).cost
+		card := rows[first] * whereSelectivity(tx, tdefs[first], local)
+		for len(order) < n {
+			next, nextCost, nextCard := -1, 0.0, 0.0
+			for j := range n {
+				if slices.Contains(order, j) {
+					continue
+				}
+				read, matched := step(order, j)
+				if next < 0 || card*read < nextCost || card*read == nextCost && card*matched < nextCard {
+					next, nextCost, nextCard = j, card*read, card*matched
+				}
+			}
+			order = append(order, next)
+			cost, card = cost+nextCost, nextCard
+		}
+		if cost < bestCost {
+			best, bestCost = order, cost
+		}
+	}
+	if slices.IsSorted(best) {
+		return nil
+	}
+	return best
+}
+
+// joinLookup describes how to probe the right-hand table of a join: ON
+// contains "right.col == expr", col leads the primary key or a secondary
+// index, and expr only references tables to the left.
+type joinLookup struct {
+	col   string   // bare column of the right table
+	index []string // secondary index columns; nil for the primary key
+	key   Expr     // evaluated against the left rows
+}
+
+// planJoinLookup looks for an equality in the (resolved) ON clause of
+// refs[idx] that can drive an index lookup. Returns nil if there is none.
+func planJoinLookup(tdef *table.TableDef, refs []TableRef, idx int, on *Expr) *joinLookup {
+	if on == nil || on.Kind != ExprBinop {
+		return nil
+	}
+	if on.Op == "AND" {
+		if l := planJoinLookup(tdef, refs, idx, on.Left); l != nil {
+			return l
+		}
+		return planJoinLookup(tdef, refs, idx, on.Right)
+	}
+	if on.Op != "==" {
+		return nil
+	}
+
+	alias := tableAlias(refs[idx], idx)
+	left := map[string]bool{}
+	for i := 0; i < idx; i++ {
+		left[tableAlias(refs[i], i)] = true
+	}
+	for _, pair := range [2][2]*Expr{{on.Left, on.Right}, {on.Right, on.Left}} {
+		col, other := pair[0], pair[1]
+		if col.Kind != ExprCol || !exprRefsOnly(other, left) {
+			continue
+		}
+		a, c := splitQualified(col.Col)
+		if a != alias {
+			continue
+		}
+		if tdef.Cols[0] == c {
+			return &joinLookup{col: c, key: *other}
+		}
+		for i, index := range tdef.Indexes {
+			// A partial index lacks rows the probe may need.
+			if index[0] == c && len(indexWhere(tdef, i)) == 0 && indexReady(tdef, i) {
+				return &joinLookup{col: c, index: index, key: *other}
+			}
+		}
+	}
+	return nil
+}
+
+// exprRefsOnly reports whether every column in expr is qualified with one
+// of aliases.
+func exprRefsOnly(expr *Expr, aliases map[string]bool) bool {
+	switch expr.Kind {
+	case ExprCol:
+		a, _ := splitQualified(expr.Col)
+		return containsDot(expr.Col) && aliases[a]
+	case ExprBinop:
+		return exprRefsOnly(expr.Left, aliases) && exprRefsOnly(expr.Right, aliases)
+	}
+	return true
+}
+
+// probe returns an uninitialised Scanner over the right-table rows whose
+// lookup column equals the key evaluated against row.
+func (l *joinLookup) probe(tdef *table.TableDef, row map[string]table.Value) (*table.Scanner, error) {
+	v, err := evalExpr(l.key, row)
+	if err != nil {
+		return nil, err
+	}
+	if v.Type != tdef.Types[table.ColIndex(tdef, l.col)] {
+		return nil, fmt.Errorf("type mismatch in comparison")
+	}
+	key := table.Record{Cols: []string{l.col}, Vals: []table.Value{v}}
+	return &table.Scanner{Cmp1: btree.CmpGE, Key1: key, Cmp2: btree.CmpLE, Key2: key}, nil
+}
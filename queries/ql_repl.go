@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	table "github.com/MHS-20/ElkDB/tables"
@@ -52,6 +53,10 @@ func printResult(out io.Writer, r Result) {
 				switch v.Type {
 				case table.TypeInt64:
 					parts[i] = fmt.Sprintf("%d", v.I64)
+				case table.TypeFloat64:
+					parts[i] = strconv.FormatFloat(v.F64, 'g', -1, 64)
+				case table.TypeUUID:
+					parts[i] = table.UUID(v.Str).String()
 				case table.TypeBytes:
 					parts[i] = string(v.Str)
 				default:
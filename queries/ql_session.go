@@ -31,20 +31,30 @@ func (s *StmtSplitter) Pop() (string, bool) {
 }
 
 type Session struct {
-	DB       table.DB
+	DB       *table.DB
 	splitter StmtSplitter
+	owned    bool // DB was opened by NewSession and is closed with the session
 }
 
 func NewSession(path string) (*Session, error) {
-	s := &Session{}
-	s.DB.Path = path
+	s := &Session{DB: &table.DB{Path: path}, owned: true}
 	if err := s.DB.Open(); err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (s *Session) Close() { s.DB.Close() }
+// NewSessionDB returns a session on db, an open database that may be shared
+// with other sessions. Closing the session leaves db open.
+func NewSessionDB(db *table.DB) *Session {
+	return &Session{DB: db}
+}
+
+func (s *Session) Close() {
+	if s.owned {
+		s.DB.Close()
+	}
+}
 
 // ExecChunk feeds a chunk of text, executes any complete statements,
 // and returns their results (or the first error encountered).
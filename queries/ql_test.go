@@ -14,6 +14,7 @@ package queries
 //   Result
 
 import (
+	"context"
 	"os"
 	"strings"
 	"testing"
@@ -476,6 +477,56 @@ func TestJoin_LeftJoin(t *testing.T) {
 	}
 }
 
+func TestJoin_IndexLookup(t *testing.T) {
+	s := newSession(t, "join_lookup.db")
+
+	s.SendChunk(t, "CREATE TABLE users (id INT, name TEXT, PRIMARY KEY (id));")
+	s.SendChunk(t, "CREATE TABLE orders (id INT, user_id INT, total INT, PRIMARY KEY (id), INDEX (user_id));")
+	for i := 1; i <= 20; i++ {
+		s.SendChunk(t, "INSERT INTO users (id, name) VALUES ("+itoa(i)+", 'u"+itoa(i)+"');")
+	}
+	for i := 1; i <= 30; i++ {
+		// Users 1..10 get three orders each; the rest get none.
+		s.SendChunk(t, "INSERT INTO orders (id, user_id, total) VALUES ("+itoa(100+i)+", "+itoa((i-1)%10+1)+", "+itoa(i)+");")
+	}
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	query := func(q string) []table.Record {
+		t.Helper()
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		return res.Rows
+	}
+
+	// Probe users by primary key.
+	rows := query("SELECT o.id, u.name FROM orders o JOIN users u ON o.user_id = u.id WHERE o.total > 25;")
+	is.Len(t, rows, 5)
+	for _, r := range rows {
+		is.Equal(t, "u"+itoa(int((r.Get("o.id").I64-101)%10+1)), string(r.Get("u.name").Str))
+	}
+	plan := query("EXPLAIN SELECT * FROM orders o JOIN users u ON o.user_id = u.id;")
+	is.Equal(t, PathFullScan, string(plan[0].Get("access").Str))
+	is.Equal(t, PathIndexLookup, string(plan[1].Get("access").Str))
+	is.Equal(t, "id", string(plan[1].Get("index").Str))
+
+	// Probe orders through the secondary index, with an extra ON condition.
+	rows = query("SELECT users.id, orders.total FROM users JOIN orders ON users.id == orders.user_id AND orders.total > 10;")
+	is.Len(t, rows, 20)
+	plan = query("EXPLAIN SELECT * FROM users JOIN orders ON users.id == orders.user_id;")
+	is.Equal(t, PathIndexLookup, string(plan[1].Get("access").Str))
+	is.Equal(t, "user_id,id", string(plan[1].Get("index").Str))
+
+	// LEFT JOIN through a lookup keeps unmatched users.
+	rows = query("SELECT users.id, orders.id FROM users LEFT JOIN orders ON orders.user_id == users.id;")
+	is.Len(t, rows, 40)
+
+	// Non-equality ON conditions fall back to a full scan.
+	plan = query("EXPLAIN SELECT * FROM users JOIN orders ON users.id < orders.user_id;")
+	is.Equal(t, PathFullScan, string(plan[1].Get("access").Str))
+}
+
 func TestJoin_ErrorAmbiguousColumn(t *testing.T) {
 	s := newSession(t, "join6.db")
 
@@ -613,6 +664,332 @@ func TestSession_LargeBatch(t *testing.T) {
 	is.Len(t, res.Rows, total)
 }
 
+func TestExecContext_Cancelled(t *testing.T) {
+	s := newSession(t, "sess_cancel.db")
+	s.SendChunk(t, "CREATE TABLE t (id INT, v INT, PRIMARY KEY (id));")
+	for i := 0; i < 10; i++ {
+		s.SendChunk(t, "INSERT INTO t (id, v) VALUES ("+itoa(i)+", 0);")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	_, err := ReaderExecContext(ctx, &tx, "SELECT * FROM t;")
+	is.ErrorIs(t, err, context.Canceled)
+	_, err = WriterExecContext(ctx, &tx, "UPDATE t SET v = 1;")
+	is.ErrorIs(t, err, context.Canceled)
+	s.DB.Abort(&tx)
+
+	tx = table.DBTX{}
+	s.DB.Begin(&tx)
+	res, err := ReaderExecContext(context.Background(), &tx, "SELECT * FROM t WHERE v == 0;")
+	s.DB.Abort(&tx)
+	is.NoError(t, err)
+	is.Len(t, res.Rows, 10)
+}
+
+func TestWhere_EqualsAndBetween(t *testing.T) {
+	s := newSession(t, "sess_between.db")
+	s.SendChunk(t, "CREATE TABLE t (id int64, v int64, PRIMARY KEY (id));")
+	for i := 0; i < 20; i++ {
+		s.SendChunk(t, "INSERT INTO t (id, v) VALUES ("+itoa(i)+", "+itoa(i%3)+");")
+	}
+
+	query := func(q string) []table.Record {
+		t.Helper()
+		tx := table.DBTX{}
+		s.DB.Begin(&tx)
+		defer s.DB.Abort(&tx)
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		return res.Rows
+	}
+
+	rows := query("SELECT * FROM t WHERE id = 7;")
+	is.Len(t, rows, 1)
+	is.Equal(t, int64(7), rows[0].Get("id").I64)
+
+	rows = query("SELECT * FROM t WHERE id BETWEEN 5 AND 9;")
+	is.Len(t, rows, 5)
+	is.Equal(t, int64(5), rows[0].Get("id").I64)
+	is.Equal(t, int64(9), rows[4].Get("id").I64)
+
+	is.Len(t, query("SELECT * FROM t WHERE id BETWEEN 9 AND 5;"), 0)
+	is.Len(t, query("SELECT * FROM t WHERE id > 2 AND id < 6 AND v = 0;"), 1)
+	is.Len(t, query("SELECT * FROM t WHERE id = 1 OR id = 18;"), 2)
+
+	s.SendChunk(t, "UPDATE t SET v = 100 WHERE id BETWEEN 10 AND 11;")
+	is.Len(t, query("SELECT * FROM t WHERE v = 100;"), 2)
+
+	s.SendChunk(t, "UPDATE t SET v = v + 1 + 1 WHERE id = 0;")
+	is.Equal(t, int64(2), query("SELECT * FROM t WHERE id = 0;")[0].Get("v").I64)
+
+	results := s.SendChunk(t, "DELETE FROM t WHERE id = 3;")
+	is.Equal(t, 1, results[0].Affected)
+	is.Len(t, query("SELECT * FROM t;"), 19)
+
+	_, err := ParseStatement("SELECT * FROM t WHERE id BETWEEN 1 5;")
+	is.Error(t, err)
+}
+
+func TestExplain(t *testing.T) {
+	s := newSession(t, "sess_explain.db")
+	s.SendChunk(t, "CREATE TABLE emp (id int64, dept string, v int64, PRIMARY KEY (id), INDEX (dept));")
+	for i := 0; i < 10; i++ {
+		dept := "'a'"
+		if i%2 == 1 {
+			dept = "'b'"
+		}
+		s.SendChunk(t, "INSERT INTO emp (id, dept, v) VALUES ("+itoa(i)+", "+dept+", "+itoa(i)+");")
+	}
+
+	explain := func(q string) table.Record {
+		t.Helper()
+		tx := table.DBTX{}
+		s.DB.Begin(&tx)
+		defer s.DB.Abort(&tx)
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		is.Len(t, res.Rows, 1)
+		return res.Rows[0]
+	}
+
+	rec := explain("EXPLAIN SELECT * FROM emp WHERE id = 3;")
+	is.Equal(t, PathPKRange, string(rec.Get("access").Str))
+	is.Equal(t, int64(1), rec.Get("rows").I64)
+
+	rec = explain("EXPLAIN SELECT * FROM emp WHERE dept = 'b' AND v > 2;")
+	is.Equal(t, PathIndexScan, string(rec.Get("access").Str))
+	is.Equal(t, "dept,id", string(rec.Get("index").Str))
+	is.Equal(t, int64(5), rec.Get("rows").I64)
+
+	// A closed index range beats a half-open primary-key range.
+	rec = explain("EXPLAIN DELETE FROM emp WHERE id > 2 AND dept = 'a';")
+	is.Equal(t, PathIndexScan, string(rec.Get("access").Str))
+
+	rec = explain("EXPLAIN UPDATE emp SET v = 0 WHERE v > 2;")
+	is.Equal(t, PathFullScan, string(rec.Get("access").Str))
+	is.Equal(t, int64(10), rec.Get("rows").I64)
+
+	// The index path returns the same rows as a full scan would.
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	res, err := ReaderExecString(&tx, "SELECT id FROM emp WHERE dept = 'b' AND v > 2;")
+	is.NoError(t, err)
+	is.Len(t, res.Rows, 4)
+
+	_, err = ReaderExecString(&tx, "EXPLAIN INSERT INTO emp (id, dept, v) VALUES (1, 'a', 1);")
+	is.Error(t, err)
+}
+
+func TestCoveringIndex(t *testing.T) {
+	s := newSession(t, "sess_covering.db")
+	s.SendChunk(t, "CREATE TABLE emp (id int64, dept string, name string, v int64, PRIMARY KEY (id), INDEX (v), INDEX (dept) INCLUDE (name));")
+	for i := 0; i < 10; i++ {
+		dept := "'a'"
+		if i%2 == 1 {
+			dept = "'b'"
+		}
+		s.SendChunk(t, "INSERT INTO emp (id, dept, name, v) VALUES ("+itoa(i)+", "+dept+", 'n"+itoa(i)+"', "+itoa(i)+");")
+	}
+	s.SendChunk(t, "UPDATE emp SET name = 'x' WHERE id = 3;")
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	is.Equal(t, [][]string{nil, {"name"}}, tx.TableDef("emp").Include)
+	query := func(q string) []table.Record {
+		t.Helper()
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		return res.Rows
+	}
+
+	plan := query("EXPLAIN SELECT name, id FROM emp WHERE dept = 'b' AND id < 5;")
+	is.Equal(t, PathIndexOnly, string(plan[0].Get("access").Str))
+	is.Equal(t, "dept,id", string(plan[0].Get("index").Str))
+	rows := query("SELECT name, id FROM emp WHERE dept = 'b' AND id < 5;")
+	is.Equal(t, []table.Record{
+		*(&table.Record{}).AddStr("name", []byte("n1")).AddInt64("id", 1),
+		*(&table.Record{}).AddStr("name", []byte("x")).AddInt64("id", 3),
+	}, rows)
+
+	// A column outside the index needs the rows.
+	plan = query("EXPLAIN SELECT name FROM emp WHERE dept = 'b' AND v > 4;")
+	is.Equal(t, PathIndexScan, string(plan[0].Get("access").Str))
+	is.Len(t, query("SELECT name FROM emp WHERE dept = 'b' AND v > 4;"), 3)
+	plan = query("EXPLAIN DELETE FROM emp WHERE dept = 'b';")
+	is.Equal(t, PathIndexScan, string(plan[0].Get("access").Str))
+}
+
+func TestPartialIndex(t *testing.T) {
+	s := newSession(t, "sess_partial.db")
+	s.SendChunk(t, "CREATE TABLE docs (id int64, owner string, deleted int64, PRIMARY KEY (id), INDEX (owner) WHERE deleted = 0);")
+	for i := 0; i < 10; i++ {
+		s.SendChunk(t, "INSERT INTO docs (id, owner, deleted) VALUES ("+itoa(i)+", 'o"+itoa(i%2)+"', "+itoa(i/5)+");")
+	}
+	s.SendChunk(t, "UPDATE docs SET deleted = 1 WHERE id = 1;")
+	err := s.SendChunkErr(t, "CREATE TABLE bad (id int64, v int64, w int64, PRIMARY KEY (id), INDEX (v) WHERE w > v);")
+	is.ErrorContains(t, err, "index WHERE")
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	is.Equal(t, [][]table.Cond{{{Col: "deleted", Cmp: "==", Val: table.Value{Type: table.TypeInt64}}}}, tx.TableDef("docs").Where)
+	query := func(q string) []table.Record {
+		t.Helper()
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		return res.Rows
+	}
+	ids := func(rows []table.Record) (ids []int64) {
+		for _, rec := range rows {
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids
+	}
+
+	plan := query("EXPLAIN SELECT id FROM docs WHERE owner = 'o1' AND deleted = 0;")
+	is.Equal(t, PathIndexScan, string(plan[0].Get("access").Str))
+	is.Equal(t, int64(1), plan[0].Get("rows").I64)
+	is.Equal(t, []int64{3}, ids(query("SELECT id FROM docs WHERE owner = 'o1' AND deleted = 0;")))
+
+	// Without the predicate in WHERE, the index would miss rows.
+	plan = query("EXPLAIN SELECT id FROM docs WHERE owner = 'o1';")
+	is.Equal(t, PathFullScan, string(plan[0].Get("access").Str))
+	is.Equal(t, []int64{1, 3, 5, 7, 9}, ids(query("SELECT id FROM docs WHERE owner = 'o1';")))
+	plan = query("EXPLAIN SELECT id FROM docs WHERE owner = 'o1' AND deleted <= 0;")
+	is.Equal(t, PathFullScan, string(plan[0].Get("access").Str))
+
+}
+
+func TestSelectLimit(t *testing.T) {
+	s := newSession(t, "sess_limit.db")
+	s.SendChunk(t, "CREATE TABLE t (id int64, v int64, PRIMARY KEY (id));")
+	s.SendChunk(t, "CREATE TABLE u (id int64, tid int64, PRIMARY KEY (id));")
+	for i := 0; i < 10; i++ {
+		s.SendChunk(t, "INSERT INTO t (id, v) VALUES ("+itoa(i)+", "+itoa(i)+");")
+		s.SendChunk(t, "INSERT INTO u (id, tid) VALUES ("+itoa(i)+", "+itoa(i)+");")
+	}
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	query := func(q string) []table.Record {
+		t.Helper()
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		return res.Rows
+	}
+
+	rows := query("SELECT id FROM t WHERE id >= 3 LIMIT 2;")
+	is.Len(t, rows, 2)
+	is.Equal(t, int64(3), rows[0].Get("id").I64)
+	is.Len(t, query("SELECT * FROM t LIMIT 0;"), 0)
+	is.Len(t, query("SELECT * FROM t LIMIT 100;"), 10)
+
+	// The WHERE clause divides by zero at id 5, so these only succeed if
+	// the scan stops once LIMIT is satisfied.
+	is.Len(t, query("SELECT * FROM t WHERE v / (id - 5) <= 0 LIMIT 2;"), 2)
+	is.Len(t, query("SELECT * FROM t JOIN u ON t.id == u.tid WHERE t.v / (u.id - 5) <= 0 LIMIT 3;"), 3)
+	is.Len(t, query("SELECT * FROM t JOIN u ON t.v / (u.id - 5) <= 0 LIMIT 4;"), 4)
+	_, err := ReaderExecString(&tx, "SELECT * FROM t WHERE v / (id - 5) <= 0 LIMIT 6;")
+	is.ErrorContains(t, err, "division by zero")
+
+	_, err = ParseStatement("SELECT * FROM t LIMIT -1;")
+	is.Error(t, err)
+	_, err = ParseStatement("SELECT * FROM t LIMIT x;")
+	is.Error(t, err)
+
+	// OFFSET skips rows before LIMIT counts them, in the scan itself
+	// without WHERE.
+	ids := func(q string) []int64 {
+		t.Helper()
+		var out []int64
+		for _, r := range query(q) {
+			out = append(out, r.Get("id").I64)
+		}
+		return out
+	}
+	is.Equal(t, []int64{7, 8}, ids("SELECT id FROM t LIMIT 2 OFFSET 7;"))
+	is.Equal(t, []int64{8, 9}, ids("SELECT id FROM t OFFSET 8;"))
+	is.Empty(t, ids("SELECT id FROM t LIMIT 2 OFFSET 10;"))
+	is.Equal(t, []int64{5, 6}, ids("SELECT id FROM t WHERE id >= 3 LIMIT 2 OFFSET 2;"))
+	is.Equal(t, []int64{6}, ids("SELECT id FROM t WHERE id != 5 LIMIT 1 OFFSET 5;"))
+	is.Len(t, query("SELECT * FROM t JOIN u ON t.id == u.tid LIMIT 3 OFFSET 2;"), 3)
+	_, err = ParseStatement("SELECT * FROM t LIMIT 1 OFFSET -1;")
+	is.Error(t, err)
+}
+
+func TestSelectPage(t *testing.T) {
+	s := newSession(t, "sess_page.db")
+	s.SendChunk(t, "CREATE TABLE t (id int64, v int64, PRIMARY KEY (id));")
+	for i := 0; i < 10; i++ {
+		s.SendChunk(t, "INSERT INTO t (id, v) VALUES ("+itoa(i)+", "+itoa(i)+");")
+	}
+	ctx := context.Background()
+	// all reads the result page by page, each page on its own snapshot.
+	all := func(q string, size int) [][]int64 {
+		t.Helper()
+		var pages [][]int64
+		token, returned := "", 0
+		for {
+			r := table.DBReader{}
+			s.DB.BeginRead(&r)
+			rows, next, err := SelectPage(ctx, &r, q, size, returned, token)
+			s.DB.EndRead(&r)
+			is.NoError(t, err)
+			var ids []int64
+			for _, row := range rows {
+				ids = append(ids, row.Get("id").I64)
+			}
+			pages = append(pages, ids)
+			returned += len(rows)
+			if token = next; token == "" {
+				return pages
+			}
+		}
+	}
+	is.Equal(t, [][]int64{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}}, all("SELECT id FROM t;", 4))
+	is.Equal(t, [][]int64{{2, 3, 4}, {5, 6}}, all("SELECT id FROM t LIMIT 5 OFFSET 2;", 3))
+	is.Equal(t, [][]int64{{5, 6}, {7, 9}}, all("SELECT id FROM t WHERE id != 8 AND v >= 3 OFFSET 2;", 2))
+	is.Equal(t, [][]int64{{1, 2}, {3}}, all("SELECT id FROM t WHERE id >= 1 LIMIT 3;", 2))
+	// WHERE fails at id 5: the page that ends before it succeeds.
+	is.Equal(t, [][]int64{{0, 1}, {2, 3}}, all("SELECT id FROM t WHERE v / (id - 5) <= 0 LIMIT 4;", 2))
+
+	// A page resumes after the last row of the previous one, so it sees
+	// the rows committed in between.
+	r := table.DBReader{}
+	s.DB.BeginRead(&r)
+	rows, next, err := SelectPage(ctx, &r, "SELECT id, v FROM t;", 5, 0, "")
+	s.DB.EndRead(&r)
+	is.NoError(t, err)
+	is.Len(t, rows, 5)
+	is.Equal(t, []string{"id", "v"}, rows[0].Cols)
+	s.SendChunk(t, "INSERT INTO t (id, v) VALUES (10, 10);")
+	s.SendChunk(t, "DELETE FROM t WHERE id == 6;")
+	s.DB.BeginRead(&r)
+	rows, next, err = SelectPage(ctx, &r, "SELECT id, v FROM t;", 10, 5, next)
+	s.DB.EndRead(&r)
+	is.NoError(t, err)
+	is.Empty(t, next)
+	is.Len(t, rows, 5) // 5, 7, 8, 9, 10
+
+	s.DB.BeginRead(&r)
+	defer s.DB.EndRead(&r)
+	_, _, err = SelectPage(ctx, &r, "SELECT id FROM t WHERE v / (id - 5) <= 0;", 10, 0, "")
+	is.ErrorContains(t, err, "division by zero")
+	_, _, err = SelectPage(ctx, &r, "SELECT * FROM t JOIN t AS u ON t.id == u.id;", 10, 0, "")
+	is.ErrorIs(t, err, ErrNotPageable)
+	_, _, err = SelectPage(ctx, &r, "DELETE FROM t;", 10, 0, "")
+	is.ErrorIs(t, err, ErrNotPageable)
+	_, _, err = SelectPage(ctx, &r, "SELECT id FROM t;", 10, 0, "!")
+	is.ErrorContains(t, err, "bad page token")
+}
+
 func itoa(n int) string {
 	if n == 0 {
 		return "0"
@@ -634,3 +1011,223 @@ func itoa(n int) string {
 	}
 	return string(buf[pos:])
 }
+
+func TestCollate(t *testing.T) {
+	s := newSession(t, "sess_collate.db")
+	s.SendChunk(t, "CREATE TABLE users (name TEXT COLLATE NOCASE, id INT, city TEXT, PRIMARY KEY (name, id));")
+	s.SendChunk(t, "CREATE TABLE visits (id INT, who TEXT, PRIMARY KEY (id));")
+	for i, name := range []string{"bob", "Alice", "carol", "ALICE", "Bob"} {
+		s.SendChunk(t, "INSERT INTO users (name, id, city) VALUES ('"+name+"', "+itoa(i)+", 'c"+itoa(i)+"');")
+		s.SendChunk(t, "INSERT INTO visits (id, who) VALUES ("+itoa(i)+", '"+strings.ToLower(name)+"');")
+	}
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	names := func(q string) []string {
+		t.Helper()
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		var out []string
+		for _, r := range res.Rows {
+			out = append(out, string(r.Get("name").Str))
+		}
+		return out
+	}
+
+	is.Equal(t, "nocase", tx.TableDef("users").Collate["name"])
+	is.Equal(t, []string{"ALICE", "Alice", "Bob", "bob", "carol"}, names("SELECT name FROM users;"))
+	is.Equal(t, []string{"ALICE", "Alice"}, names("SELECT name FROM users WHERE name = 'alice';"))
+	is.Equal(t, []string{"Bob", "bob", "carol"}, names("SELECT name FROM users WHERE name > 'ALICE';"))
+	is.Equal(t, []string{"ALICE", "Alice", "Bob", "bob"}, names("SELECT name FROM users WHERE name BETWEEN 'a' AND 'BOB';"))
+	is.Equal(t, []string{"Bob"}, names("SELECT name FROM users WHERE name = 'BOB' AND city = 'c4';"))
+
+	res, err := ReaderExecString(&tx, "SELECT users.id, visits.id FROM visits JOIN users ON users.name = visits.who WHERE visits.who = 'alice';")
+	is.NoError(t, err)
+	is.Len(t, res.Rows, 4)
+
+	_, err = WriterExecString(&tx, "CREATE TABLE bad (id INT COLLATE NOCASE, PRIMARY KEY (id));")
+	is.Error(t, err)
+	_, err = WriterExecString(&tx, "CREATE TABLE bad (s TEXT COLLATE klingon, PRIMARY KEY (s));")
+	is.Error(t, err)
+}
+
+func TestDescKeys(t *testing.T) {
+	s := newSession(t, "sess_desc.db")
+	s.SendChunk(t, "CREATE TABLE log (ts INT, id INT, tag TEXT, note TEXT, PRIMARY KEY (ts DESC, id ASC), INDEX (tag DESC));")
+	for i := 0; i < 10; i++ {
+		s.SendChunk(t, "INSERT INTO log (ts, id, tag, note) VALUES ("+itoa(i)+", "+itoa(i)+", 't"+itoa(i%4)+"', '');")
+	}
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	ids := func(q string) []int64 {
+		t.Helper()
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		var out []int64
+		for _, r := range res.Rows {
+			out = append(out, r.Get("id").I64)
+		}
+		return out
+	}
+
+	is.Equal(t, map[string]bool{"ts": true, "tag": true}, tx.TableDef("log").Desc)
+	is.Equal(t, []int64{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, ids("SELECT id FROM log;"))
+	// A lone bound on the high end of the key scans back from it, as it
+	// does on an ascending column.
+	is.Equal(t, []int64{7, 8, 9}, ids("SELECT id FROM log WHERE ts > 6;"))
+	is.Equal(t, []int64{4, 3, 2}, ids("SELECT id FROM log WHERE ts BETWEEN 2 AND 4;"))
+	is.Equal(t, []int64{1, 0}, ids("SELECT id FROM log WHERE ts <= 1;"))
+	is.Equal(t, []int64{9, 5, 1, 8, 4, 0}, ids("SELECT id FROM log WHERE tag <= 't1';"))
+
+	_, err := ParseStatement("CREATE TABLE bad (a INT, b INT, PRIMARY KEY (a DESC), INDEX (b, a ASC));")
+	is.ErrorContains(t, err, "both ASC and DESC")
+}
+
+func TestAnalyze(t *testing.T) {
+	s := newSession(t, "sess_analyze.db")
+	s.SendChunk(t, "CREATE TABLE t (id INT, tag TEXT, PRIMARY KEY (id));")
+	for i := 0; i < 20; i++ {
+		s.SendChunk(t, "INSERT INTO t (id, tag) VALUES ("+itoa(i)+", 't"+itoa(i%5)+"');")
+	}
+	s.SendChunk(t, "ANALYZE t;")
+	is.Error(t, s.SendChunkErr(t, "ANALYZE nope;"))
+
+	tx := table.DBReader{}
+	s.DB.BeginRead(&tx)
+	defer s.DB.EndRead(&tx)
+	st, ok, err := tx.ColumnStats("t", "tag")
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, int64(20), st.Rows)
+	is.Equal(t, int64(5), st.Distinct)
+}
+
+func TestAlterTableDropColumn(t *testing.T) {
+	s := newSession(t, "sess_alter.db")
+	s.SendChunk(t, "CREATE TABLE t (id INT, tag TEXT, v INT, note TEXT, PRIMARY KEY (id), INDEX (tag));")
+	for i := 0; i < 5; i++ {
+		s.SendChunk(t, "INSERT INTO t (id, tag, v, note) VALUES ("+itoa(i)+", 't"+itoa(i%2)+"', 0, 'n');")
+	}
+	s.SendChunk(t, "ALTER TABLE t DROP COLUMN note;")
+	is.Error(t, s.SendChunkErr(t, "ALTER TABLE t DROP tag;"))
+	is.Error(t, s.SendChunkErr(t, "ALTER TABLE t DROP COLUMN note;"))
+
+	res := s.SendChunk(t, "SELECT * FROM t WHERE tag == 't1';")
+	is.Len(t, res[0].Rows, 2)
+	is.Equal(t, []string{"id", "tag", "v"}, res[0].Rows[0].Cols)
+	is.Error(t, s.SendChunkErr(t, "INSERT INTO t (id, tag, v, note) VALUES (9, 't0', 0, 'n');"))
+	// The index key (tag, id) must leave a column out.
+	is.Error(t, s.SendChunkErr(t, "ALTER TABLE t DROP COLUMN v;"))
+}
+
+func TestAlterTableColumnType(t *testing.T) {
+	s := newSession(t, "sess_alter_type.db")
+	s.SendChunk(t, "CREATE TABLE t (id INT, n TEXT, PRIMARY KEY (id));")
+	s.SendChunk(t, "INSERT INTO t (id, n) VALUES (1, '10'); INSERT INTO t (id, n) VALUES (2, '9');")
+	s.SendChunk(t, "ALTER TABLE t ALTER COLUMN n TYPE INT;")
+	res := s.SendChunk(t, "SELECT id FROM t WHERE n > 9;")
+	is.Len(t, res[0].Rows, 1)
+	is.Equal(t, int64(1), res[0].Rows[0].Get("id").I64)
+
+	s.SendChunk(t, "ALTER TABLE t ALTER n SET DATA TYPE TEXT;")
+	s.SendChunk(t, "INSERT INTO t (id, n) VALUES (3, 'x');")
+	is.Error(t, s.SendChunkErr(t, "ALTER TABLE t ALTER COLUMN n TYPE INT;"))
+	res = s.SendChunk(t, "SELECT n FROM t WHERE id == 2;")
+	is.Equal(t, "9", string(res[0].Rows[0].Get("n").Str))
+}
+
+func TestAlterTableRenameColumn(t *testing.T) {
+	s := newSession(t, "sess_alter_rename.db")
+	s.SendChunk(t, "CREATE TABLE t (id INT, tag TEXT, v INT, PRIMARY KEY (id), INDEX (tag));")
+	s.SendChunk(t, "INSERT INTO t (id, tag, v) VALUES (1, 'a', 5);")
+	s.SendChunk(t, "ALTER TABLE t RENAME COLUMN tag TO label;")
+	is.Error(t, s.SendChunkErr(t, "ALTER TABLE t RENAME v TO id;"))
+
+	res := s.SendChunk(t, "SELECT id, v FROM t WHERE label == 'a';")
+	is.Len(t, res[0].Rows, 1)
+	is.Equal(t, int64(5), res[0].Rows[0].Get("v").I64)
+	is.Error(t, s.SendChunkErr(t, "SELECT tag FROM t;"))
+}
+
+func TestPlannerStatistics(t *testing.T) {
+	s := newSession(t, "sess_planstats.db")
+	s.SendChunk(t, "CREATE TABLE emp (id INT, dept TEXT, v INT, PRIMARY KEY (id), INDEX (dept));")
+	s.SendChunk(t, "CREATE TABLE users (id INT, name TEXT, PRIMARY KEY (id));")
+	s.SendChunk(t, "CREATE TABLE orders (id INT, user_id INT, total INT, PRIMARY KEY (id), INDEX (user_id));")
+	for i := 0; i < 200; i++ {
+		dept := "a" // most employees
+		if i%20 == 0 {
+			dept = "b"
+		}
+		s.SendChunk(t, "INSERT INTO emp (id, dept, v) VALUES ("+itoa(i)+", '"+dept+"', 0);")
+		s.SendChunk(t, "INSERT INTO orders (id, user_id, total) VALUES ("+itoa(i)+", "+itoa(i%10)+", 0);")
+	}
+	for i := 0; i < 10; i++ {
+		s.SendChunk(t, "INSERT INTO users (id, name) VALUES ("+itoa(i)+", 'u"+itoa(i)+"');")
+	}
+
+	explain := func(q string) []table.Record {
+		t.Helper()
+		tx := table.DBReader{}
+		s.DB.BeginRead(&tx)
+		defer s.DB.EndRead(&tx)
+		res, err := ReaderExecString(&tx, q)
+		is.NoError(t, err)
+		return res.Rows
+	}
+	access := func(q string) []string {
+		t.Helper()
+		var out []string
+		for _, r := range explain(q) {
+			out = append(out, string(r.Get("table").Str)+": "+string(r.Get("access").Str))
+		}
+		return out
+	}
+	const join = "SELECT users.name, orders.id FROM users JOIN orders ON users.id == orders.user_id WHERE orders.id = 7;"
+
+	// Without statistics, any bound is worth an index scan, and joins run
+	// in the written order.
+	is.Equal(t, []string{"emp: index scan"}, access("EXPLAIN SELECT * FROM emp WHERE dept = 'a';"))
+	is.Equal(t, []string{"users: full scan", "orders: index lookup"}, access("EXPLAIN "+join))
+	before := explain(join)
+	is.Len(t, before, 1)
+
+	s.SendChunk(t, "ANALYZE emp; ANALYZE users; ANALYZE orders;")
+
+	// An index scan of most of the table costs more than a full scan.
+	is.Equal(t, []string{"emp: full scan"}, access("EXPLAIN SELECT * FROM emp WHERE dept = 'a';"))
+	is.Equal(t, []string{"emp: index scan"}, access("EXPLAIN SELECT * FROM emp WHERE dept = 'b';"))
+	is.Equal(t, []string{"emp: index-only scan"}, access("EXPLAIN SELECT id FROM emp WHERE dept = 'b';"))
+	is.Equal(t, []string{"emp: primary key range"}, access("EXPLAIN SELECT * FROM emp WHERE id > 190 AND dept = 'b';"))
+	rows := explain("SELECT id FROM emp WHERE dept = 'a' AND id < 5;")
+	is.Len(t, rows, 4)
+
+	// The join starts from the one order the WHERE clause selects.
+	is.Equal(t, []string{"orders: primary key range", "users: index lookup"}, access("EXPLAIN "+join))
+	is.Equal(t, before, explain(join))
+	rows = explain("SELECT users.name, orders.id FROM users JOIN orders ON users.id == orders.user_id WHERE orders.id < 20;")
+	is.Len(t, rows, 20)
+	for _, r := range rows {
+		is.Equal(t, "u"+itoa(int(r.Get("orders.id").I64%10)), string(r.Get("users.name").Str))
+	}
+	// A LEFT JOIN keeps its order.
+	is.Equal(t, []string{"users: full scan", "orders: index lookup"},
+		access("EXPLAIN SELECT * FROM users LEFT JOIN orders ON users.id == orders.user_id WHERE orders.id = 7;"))
+}
+
+func TestUniqueIndex(t *testing.T) {
+	s := newSession(t, "sess_unique.db")
+	s.SendChunk(t, "CREATE TABLE users (id int64, email string, name string, PRIMARY KEY (id), INDEX (name), UNIQUE INDEX (email));")
+	s.SendChunk(t, "INSERT INTO users (id, email, name) VALUES (1, 'a@x', 'a');")
+	s.SendChunk(t, "INSERT INTO users (id, email, name) VALUES (2, 'b@x', 'a');")
+	err := s.SendChunkErr(t, "INSERT INTO users (id, email, name) VALUES (3, 'a@x', 'c');")
+	is.ErrorIs(t, err, table.ErrUnique)
+
+	tx := table.DBTX{}
+	s.DB.Begin(&tx)
+	defer s.DB.Abort(&tx)
+	is.Equal(t, []bool{false, true}, tx.TableDef("users").Unique)
+}
@@ -0,0 +1,513 @@
+// Package raftdb replicates a tables.DB across a cluster of nodes with the
+// Raft consensus algorithm (go.etcd.io/raft). Every node holds a full copy
+// of the database. A commit on any node is proposed to the Raft log, and
+// returns once the log has committed it and the node has applied it; every
+// node applies the entries of the log in the same order, so their
+// databases go through the same states. When the leader fails, the others
+// elect a new one and go on taking writes as long as a majority of the
+// nodes is up.
+//
+// Transactions keep their snapshot isolation: an entry carries the log
+// index its transaction read at, and one made stale by an entry applied
+// since is refused on every node, which the commit reports as a
+// serialisation conflict (see kv.ErrConflict) for the caller to retry.
+// Reads are served by the local copy, which may lag the leader by the
+// entries it has not applied yet.
+//
+// The membership of the cluster is fixed when it is first started: every
+// node is given the same Peers. The nodes of a new cluster must start from
+// the same database file, typically an empty one.
+//
+// The nodes talk over mutual TLS: an entry a node accepts is written to its
+// database, so each one only talks to nodes presenting a certificate signed
+// by the cluster's CA (see tlsconfig.Peer).
+package raftdb
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/tables"
+)
+
+// Defaults of the Node settings left at 0.
+const (
+	DefaultTick           = 100 * time.Millisecond
+	DefaultSnapshotEvery  = 10000
+	DefaultProposeTimeout = 5 * time.Second
+)
+
+// Raft timing, in ticks.
+const (
+	electionTicks  = 10
+	heartbeatTicks = 1
+)
+
+var (
+	// ErrTimeout is returned by a commit whose entry was not applied within
+	// ProposeTimeout, for want of a leader or of a majority; it may still
+	// be applied later.
+	ErrTimeout = errors.New("raftdb: proposal timed out")
+	// ErrStopped is returned by a commit when the node stops.
+	ErrStopped = errors.New("raftdb: node stopped")
+)
+
+// Node is one member of a replicated database. Open starts it; DB then
+// returns its database, whose commits go through the Raft log.
+type Node struct {
+	// ID identifies the node in the cluster; it must not be 0.
+	ID uint64
+	// Peers maps the ID of every node of the cluster, this one included, to
+	// the TCP address it listens on for the others.
+	Peers map[uint64]string
+	// Path is the database file. The Raft log is kept next to it, in
+	// Path+".raft".
+	Path string
+	// Tick is the Raft clock: heartbeats go out every tick, and an election
+	// starts after 10 to 20 ticks without a leader (0 = DefaultTick).
+	Tick time.Duration
+	// SnapshotEvery is the number of applied entries after which the log is
+	// cut down to a snapshot (0 = DefaultSnapshotEvery).
+	SnapshotEvery uint64
+	// ProposeTimeout bounds how long a commit waits for its entry to be
+	// applied (0 = DefaultProposeTimeout).
+	ProposeTimeout time.Duration
+	// TLSConfig secures the connections between the nodes, both those the
+	// node accepts and those it makes. It must require client certificates;
+	// tlsconfig.Peer builds one.
+	TLSConfig *tls.Config
+
+	db        *tables.DB
+	raft      raft.Node
+	log       *logFile
+	ln        net.Listener
+	peers     map[uint64]*peer
+	ctx       context.Context
+	cancel    context.CancelFunc
+	confState *raftpb.ConfState
+	// Owned by the run loop.
+	applied   uint64 // last entry applied
+	skipTo    uint64 // entries up to this one were applied by an earlier session
+	snapIndex uint64 // index of the last snapshot
+
+	snapMu sync.Mutex     // guards the snapshot file
+	wg     sync.WaitGroup // the listener's goroutine
+
+	mu     sync.Mutex
+	waits  map[uint64]chan error // proposals waiting to be applied, by ID
+	seq    uint64                // of the last proposal; its ID also holds the node ID
+	conns  map[net.Conn]struct{}
+	err    error
+	closed bool
+	done   chan struct{}
+}
+
+// Open opens the database and the Raft log, starting a new cluster if the
+// log does not exist yet, and joins the other nodes.
+func (n *Node) Open() error {
+	if n.ID == 0 || n.Peers[n.ID] == "" {
+		return fmt.Errorf("raftdb: node %d is not one of its Peers", n.ID)
+	}
+	if n.TLSConfig == nil || n.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		return errors.New("raftdb: the nodes need a TLSConfig that requires client certificates")
+	}
+	ln, err := tls.Listen("tcp", n.Peers[n.ID], n.TLSConfig)
+	if err != nil {
+		return fmt.Errorf("raftdb: listen: %w", err)
+	}
+	n.ln = ln
+	if err := n.open(); err != nil {
+		ln.Close()
+		return fmt.Errorf("raftdb: %w", err)
+	}
+	return nil
+}
+
+func (n *Node) open() error {
+	lf, existed, err := openLog(n.Path + ".raft")
+	if err != nil {
+		return err
+	}
+	n.db = &tables.DB{Path: n.Path, Propose: n.propose}
+	if err := n.db.Open(); err != nil {
+		lf.close()
+		return err
+	}
+	fail := func(err error) error {
+		n.db.Close()
+		lf.close()
+		return err
+	}
+	n.log = lf
+	snap, err := lf.storage.Snapshot()
+	if err != nil {
+		return fail(err)
+	}
+	n.snapIndex = snap.GetMetadata().GetIndex()
+	_, cs, err := lf.storage.InitialState()
+	if err != nil {
+		return fail(err)
+	}
+	n.confState = cs
+	if err := n.installPending(snap); err != nil {
+		return fail(err)
+	}
+	n.skipTo, _ = n.db.LogState()
+	n.applied = n.snapIndex
+
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.waits = map[uint64]chan error{}
+	n.seq = uint64(time.Now().UnixNano())
+	n.conns = map[net.Conn]struct{}{}
+	n.err, n.closed = nil, false
+	n.done = make(chan struct{})
+	c := &raft.Config{
+		ID:              n.ID,
+		ElectionTick:    electionTicks,
+		HeartbeatTick:   heartbeatTicks,
+		Storage:         lf.storage,
+		MaxSizePerMsg:   1 << 20,
+		MaxInflightMsgs: 256,
+		CheckQuorum:     true,
+		PreVote:         true,
+		Logger:          &raft.DefaultLogger{Logger: log.New(io.Discard, "", 0)},
+	}
+	if existed {
+		n.raft = raft.RestartNode(c)
+	} else {
+		var peers []raft.Peer
+		for id := range n.Peers {
+			peers = append(peers, raft.Peer{ID: id})
+		}
+		sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+		n.raft = raft.StartNode(c, peers)
+	}
+	n.peers = map[uint64]*peer{}
+	for id, addr := range n.Peers {
+		if id != n.ID {
+			n.peers[id] = newPeer(n, id, addr)
+		}
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.serve(n.ln)
+	}()
+	go n.run()
+	return nil
+}
+
+// ParsePeers parses the nodes of a cluster written as
+// "1=host:port,2=host:port,...", the form of Peers command lines take.
+func ParsePeers(s string) (map[uint64]string, error) {
+	peers := map[uint64]string{}
+	for _, p := range strings.Split(s, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(p), "=")
+		n, err := strconv.ParseUint(id, 10, 64)
+		if !ok || err != nil || n == 0 || addr == "" {
+			return nil, fmt.Errorf("raftdb: bad peer %q: want id=host:port", p)
+		}
+		if peers[n] != "" {
+			return nil, fmt.Errorf("raftdb: node %d is listed twice", n)
+		}
+		peers[n] = addr
+	}
+	return peers, nil
+}
+
+// DB returns the database of the node. Its commits are proposed to the
+// Raft log; see the package documentation.
+func (n *Node) DB() *tables.DB {
+	return n.db
+}
+
+// Leader returns the ID of the current leader, or 0 if there is none.
+func (n *Node) Leader() uint64 {
+	return n.raft.Status().Lead
+}
+
+// IsLeader reports whether the node is the leader.
+func (n *Node) IsLeader() bool {
+	return n.Leader() == n.ID
+}
+
+// WaitLeader waits until the cluster has a leader.
+func (n *Node) WaitLeader(ctx context.Context) error {
+	tick := time.NewTicker(n.tick())
+	defer tick.Stop()
+	for n.Leader() == 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.done:
+			return ErrStopped
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+// Err returns why the node stopped on its own, or nil while it runs. A
+// node stops when it cannot persist its log or apply an entry.
+func (n *Node) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// Close stops the node and closes its database.
+func (n *Node) Close() {
+	n.mu.Lock()
+	n.closed = true
+	for conn := range n.conns {
+		conn.Close()
+	}
+	n.mu.Unlock()
+	n.ln.Close()
+	n.cancel()
+	<-n.done
+	n.wg.Wait()
+	for _, p := range n.peers {
+		p.close()
+	}
+	n.db.Close()
+	n.log.close()
+}
+
+func (n *Node) tick() time.Duration {
+	if n.Tick <= 0 {
+		return DefaultTick
+	}
+	return n.Tick
+}
+
+// propose is the tables.DB Propose hook: it proposes entry to the log and
+// waits until the node has applied it.
+func (n *Node) propose(entry []byte) error {
+	timeout := n.ProposeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProposeTimeout
+	}
+	c := make(chan error, 1)
+	n.mu.Lock()
+	n.seq++
+	id := n.ID<<48 | n.seq&(1<<48-1)
+	n.waits[id] = c
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.waits, id)
+		n.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(n.ctx, timeout)
+	defer cancel()
+	data := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(entry)), id)
+	if err := n.raft.Propose(ctx, append(data, entry...)); err != nil {
+		return n.proposeErr(ctx, err)
+	}
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return n.proposeErr(ctx, ctx.Err())
+	}
+}
+
+func (n *Node) proposeErr(ctx context.Context, err error) error {
+	switch {
+	case n.ctx.Err() != nil:
+		return ErrStopped
+	case ctx.Err() != nil:
+		return ErrTimeout
+	default:
+		return fmt.Errorf("raftdb: %w", err)
+	}
+}
+
+// run drives the Raft node until Close or a failure.
+func (n *Node) run() {
+	defer close(n.done)
+	defer n.raft.Stop()
+	ticker := time.NewTicker(n.tick())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.raft.Tick()
+		case rd := <-n.raft.Ready():
+			if err := n.ready(rd); err != nil {
+				log.Printf("elkdb-raft: node %d stopped: %v", n.ID, err)
+				n.mu.Lock()
+				n.err = err
+				n.mu.Unlock()
+				n.cancel()
+				return
+			}
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// ready handles a raft.Ready: the state is persisted before the messages
+// go out, then the committed entries are applied.
+func (n *Node) ready(rd raft.Ready) error {
+	if err := n.log.save(rd.HardState, rd.Entries, rd.Snapshot, rd.MustSync); err != nil {
+		return fmt.Errorf("save raft log: %w", err)
+	}
+	if !raft.IsEmptySnap(rd.Snapshot) {
+		if err := n.install(rd.Snapshot); err != nil {
+			return err
+		}
+	}
+	for _, m := range rd.Messages {
+		if p := n.peers[m.GetTo()]; p != nil {
+			p.send(m)
+		}
+	}
+	for _, entry := range rd.CommittedEntries {
+		if err := n.apply(entry); err != nil {
+			return err
+		}
+	}
+	if err := n.maybeSnapshot(); err != nil {
+		return err
+	}
+	n.raft.Advance()
+	return nil
+}
+
+// apply applies a committed entry to the database, and hands the result to
+// the proposal waiting for it, if it is this node's.
+func (n *Node) apply(entry *raftpb.Entry) error {
+	index := entry.GetIndex()
+	if index <= n.applied {
+		return nil
+	}
+	n.applied = index
+	switch entry.GetType() {
+	case raftpb.EntryType_EntryConfChange:
+		cc := &raftpb.ConfChange{}
+		if err := proto.Unmarshal(entry.GetData(), cc); err != nil {
+			return err
+		}
+		n.confState = n.raft.ApplyConfChange(cc)
+	case raftpb.EntryType_EntryConfChangeV2:
+		cc := &raftpb.ConfChangeV2{}
+		if err := proto.Unmarshal(entry.GetData(), cc); err != nil {
+			return err
+		}
+		n.confState = n.raft.ApplyConfChange(cc)
+	case raftpb.EntryType_EntryNormal:
+		data := entry.GetData()
+		if len(data) < 8 || index <= n.skipTo {
+			break // the empty entry of a new leader, or applied already
+		}
+		err := n.db.ApplyLogged(index, data[8:])
+		if err != nil && !errors.Is(err, kv.ErrConflict) {
+			return fmt.Errorf("apply entry %d: %w", index, err)
+		}
+		n.mu.Lock()
+		c := n.waits[binary.BigEndian.Uint64(data)]
+		n.mu.Unlock()
+		if c != nil {
+			c <- err
+		}
+		return nil
+	}
+	if index > n.skipTo {
+		n.db.SkipLogged(index)
+	}
+	return nil
+}
+
+// maybeSnapshot cuts the log down to a snapshot every SnapshotEvery
+// entries, keeping the last quarter of them for the nodes that lag a
+// little. The snapshot holds the last entry that changed the database; the
+// database itself is sent along with it when a node needs it.
+func (n *Node) maybeSnapshot() error {
+	every := n.SnapshotEvery
+	if every == 0 {
+		every = DefaultSnapshotEvery
+	}
+	if n.applied-n.snapIndex < every {
+		return nil
+	}
+	applied, lastWrite := n.db.LogState()
+	if applied != n.applied {
+		return nil // still skipping the entries of an earlier session
+	}
+	if err := n.log.compact(n.applied, n.applied-every/4, n.confState, binary.BigEndian.AppendUint64(nil, lastWrite)); err != nil {
+		return fmt.Errorf("snapshot raft log: %w", err)
+	}
+	n.snapIndex = n.applied
+	return nil
+}
+
+func (n *Node) snapPath() string {
+	return n.Path + ".snap"
+}
+
+// install installs a snapshot sent by the leader, whose database the
+// transport restored to the snapshot file.
+func (n *Node) install(snap *raftpb.Snapshot) error {
+	n.snapMu.Lock()
+	defer n.snapMu.Unlock()
+	if err := n.installFile(snap); err != nil {
+		return fmt.Errorf("install snapshot %d: %w", snap.GetMetadata().GetIndex(), err)
+	}
+	n.confState = snap.GetMetadata().GetConfState()
+	n.applied = snap.GetMetadata().GetIndex()
+	n.snapIndex = n.applied
+	n.skipTo = n.applied
+	return nil
+}
+
+// installPending finishes installing the snapshot of the log on open, if
+// the last session stopped between saving and installing it.
+func (n *Node) installPending(snap *raftpb.Snapshot) error {
+	n.snapMu.Lock()
+	defer n.snapMu.Unlock()
+	if _, err := os.Stat(n.snapPath()); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if applied, _ := n.db.LogState(); applied >= snap.GetMetadata().GetIndex() {
+		return removeSnap(n.snapPath())
+	}
+	return n.installFile(snap)
+}
+
+func (n *Node) installFile(snap *raftpb.Snapshot) error {
+	data := snap.GetData()
+	if len(data) != 8 {
+		return errors.New("bad snapshot data")
+	}
+	src := &kv.KV{Path: n.snapPath(), ReadOnly: true}
+	if err := src.Open(); err != nil {
+		return err
+	}
+	err := n.db.InstallLogged(src, snap.GetMetadata().GetIndex(), binary.BigEndian.Uint64(data))
+	src.Close()
+	if err != nil {
+		return err
+	}
+	return removeSnap(n.snapPath())
+}
@@ -0,0 +1,269 @@
+package raftdb
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/internal/testcert"
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/tables"
+	"github.com/MHS-20/ElkDB/tlsconfig"
+	is "github.com/stretchr/testify/require"
+)
+
+// clusterTLS returns the TLS config of the nodes of a test cluster.
+func clusterTLS(t *testing.T) *tls.Config {
+	cert, key := testcert.Write(t, "cluster")
+	cfg, err := tlsconfig.Peer(cert, key, cert)
+	is.NoError(t, err)
+	return cfg
+}
+
+// freePeers returns n free local addresses, by node ID.
+func freePeers(t *testing.T, n int) map[uint64]string {
+	peers := map[uint64]string{}
+	for id := uint64(1); id <= uint64(n); id++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		is.NoError(t, err)
+		peers[id] = ln.Addr().String()
+		ln.Close()
+	}
+	return peers
+}
+
+func TestCluster(t *testing.T) {
+	dir := t.TempDir()
+	peers := freePeers(t, 3)
+	cfg := clusterTLS(t)
+	nodes := map[uint64]*Node{}
+	start := func(id uint64) {
+		t.Helper()
+		n := &Node{
+			ID:            id,
+			Peers:         peers,
+			Path:          filepath.Join(dir, "node"+string(rune('0'+id))+".db"),
+			Tick:          10 * time.Millisecond,
+			SnapshotEvery: 20,
+			TLSConfig:     cfg,
+		}
+		is.NoError(t, n.Open())
+		nodes[id] = n
+	}
+	defer func() {
+		for _, n := range nodes {
+			n.Close()
+		}
+	}()
+	// write commits f on n, retrying conflicts and the timeouts of a
+	// cluster without a leader; f must be idempotent.
+	write := func(n *Node, f func(tx *tables.DBTX) error) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			tx := tables.DBTX{}
+			n.DB().Begin(&tx)
+			err := f(&tx)
+			if err == nil {
+				err = n.DB().Commit(&tx)
+			} else {
+				n.DB().Abort(&tx)
+			}
+			retry := errors.Is(err, kv.ErrConflict) || errors.Is(err, ErrTimeout)
+			if err == nil || !retry || time.Now().After(deadline) {
+				is.NoError(t, err)
+				return
+			}
+		}
+	}
+	row := func(id int64) tables.Record {
+		return *(&tables.Record{}).AddInt64("id", id).AddInt64("k", id%5).AddStr("v", []byte("value"))
+	}
+	upsert := func(n *Node, from, to int64) {
+		t.Helper()
+		write(n, func(tx *tables.DBTX) error {
+			for id := from; id < to; id++ {
+				if _, err := tx.Upsert("t", row(id)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	count := func(n *Node) int64 {
+		r := tables.DBReader{}
+		n.DB().BeginRead(&r)
+		defer n.DB().EndRead(&r)
+		if r.TableDef("t") == nil {
+			return -1
+		}
+		sc := tables.FullScan()
+		is.NoError(t, r.Scan("t", &sc))
+		c := int64(0)
+		for ; sc.Valid(); sc.Next() {
+			c++
+		}
+		return c
+	}
+	converge := func(want int64) {
+		t.Helper()
+		is.Eventually(t, func() bool {
+			for _, n := range nodes {
+				if count(n) != want {
+					return false
+				}
+			}
+			return true
+		}, 10*time.Second, 10*time.Millisecond)
+		for _, n := range nodes {
+			is.True(t, n.DB().Check().OK())
+		}
+	}
+	leader := func() *Node {
+		t.Helper()
+		var l *Node
+		is.Eventually(t, func() bool {
+			for _, n := range nodes {
+				if n.IsLeader() {
+					l = n
+					return true
+				}
+			}
+			return false
+		}, 10*time.Second, 10*time.Millisecond)
+		return l
+	}
+
+	for id := range peers {
+		start(id)
+	}
+	old := leader()
+
+	// Writes go through the log from any node.
+	write(nodes[2], func(tx *tables.DBTX) error {
+		if tx.TableDef("t") != nil {
+			return nil
+		}
+		return tx.TableNew(&tables.TableDef{
+			Name:    "t",
+			Cols:    []string{"id", "k", "v"},
+			Types:   []uint32{tables.TypeInt64, tables.TypeInt64, tables.TypeBytes},
+			PKeys:   1,
+			Indexes: [][]string{{"k"}},
+		})
+	})
+	converge(0) // the other nodes may lag behind the table
+	for id, n := range nodes {
+		upsert(n, int64(id)*100, int64(id)*100+50)
+	}
+	converge(150)
+
+	// Of two transactions on the same snapshot, the one logged second
+	// conflicts.
+	var t1, t2 tables.DBTX
+	nodes[1].DB().Begin(&t1)
+	nodes[3].DB().Begin(&t2)
+	_, err := t1.Delete("t", *(&tables.Record{}).AddInt64("id", 100))
+	is.NoError(t, err)
+	_, err = t2.Upsert("t", *(&tables.Record{}).AddInt64("id", 100).AddInt64("k", 0).AddStr("v", []byte("t2")))
+	is.NoError(t, err)
+	is.NoError(t, nodes[1].DB().Commit(&t1))
+	is.ErrorIs(t, nodes[3].DB().Commit(&t2), kv.ErrConflict)
+	converge(149)
+
+	// The others elect a new leader when it fails, and take writes.
+	old.Close()
+	delete(nodes, old.ID)
+	l := leader()
+	is.NotEqual(t, old.ID, l.ID)
+	for _, n := range nodes {
+		upsert(n, 1000+int64(n.ID)*100, 1000+int64(n.ID)*100+10)
+	}
+	for i := range int64(30) { // enough entries for a snapshot to cut the log
+		upsert(l, 2000+i, 2001+i)
+	}
+	converge(149 + 20 + 30)
+
+	// The old leader comes back as a follower, and catches up from a
+	// snapshot of the others.
+	start(old.ID)
+	converge(149 + 20 + 30)
+	snap, err := nodes[old.ID].log.storage.Snapshot()
+	is.NoError(t, err)
+	is.NotZero(t, snap.GetMetadata().GetIndex())
+	upsert(nodes[old.ID], 3000, 3010)
+	converge(149 + 20 + 30 + 10)
+
+	// A restarted cluster replays its log.
+	for id, n := range nodes {
+		n.Close()
+		delete(nodes, id)
+	}
+	for id := range peers {
+		start(id)
+	}
+	converge(149 + 20 + 30 + 10)
+	upsert(nodes[1], 4000, 4001)
+	converge(149 + 20 + 30 + 10 + 1)
+	for _, n := range nodes {
+		is.NoError(t, n.Err())
+	}
+}
+
+func TestClusterTLS(t *testing.T) {
+	dir := t.TempDir()
+	peers := freePeers(t, 3)
+	node := func(id uint64, cfg *tls.Config) *Node {
+		return &Node{
+			ID:        id,
+			Peers:     peers,
+			Path:      filepath.Join(dir, "node"+string(rune('0'+id))+".db"),
+			Tick:      10 * time.Millisecond,
+			TLSConfig: cfg,
+		}
+	}
+	// Without mutual TLS, a node does not start.
+	is.ErrorContains(t, node(1, nil).Open(), "TLSConfig")
+	cert, key := testcert.Write(t, "node")
+	server, err := tlsconfig.Server(cert, key, "")
+	is.NoError(t, err)
+	is.ErrorContains(t, node(1, server).Open(), "client certificates")
+
+	// A node whose certificate the cluster's CA did not sign takes no part.
+	cfg := clusterTLS(t)
+	var nodes []*Node
+	for id := uint64(1); id <= 2; id++ {
+		n := node(id, cfg)
+		is.NoError(t, n.Open())
+		defer n.Close()
+		nodes = append(nodes, n)
+	}
+	otherCert, otherKey := testcert.Write(t, "intruder")
+	intruderTLS, err := tlsconfig.Peer(otherCert, otherKey, cert)
+	is.NoError(t, err)
+	intruder := node(3, intruderTLS)
+	is.NoError(t, intruder.Open())
+	defer intruder.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	is.NoError(t, nodes[0].WaitLeader(ctx))
+	is.Eventually(t, func() bool { return nodes[1].Leader() != 0 }, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	is.Zero(t, intruder.Leader())
+	is.NotEqual(t, uint64(3), nodes[0].Leader())
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("1=a:7001, 2=b:7001,3=c:7001")
+	is.NoError(t, err)
+	is.Equal(t, map[uint64]string{1: "a:7001", 2: "b:7001", 3: "c:7001"}, peers)
+	for _, bad := range []string{"", "a:7001", "0=a:7001", "x=a:7001", "1=", "1=a:1,1=b:1"} {
+		_, err := ParsePeers(bad)
+		is.Error(t, err, bad)
+	}
+}
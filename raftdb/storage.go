@@ -0,0 +1,246 @@
+package raftdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Raft log file layout. Integers are little-endian; the checksum is the
+// CRC-32 (IEEE) of the payload.
+//
+//	header | sig | version | padding |
+//	       |  8B |    4B   |    4B   |
+//	record | type | crc | payload len | payload |
+//	       |  1B  |  4B |      4B     |         |
+//
+// A payload is the protobuf encoding of a raftpb.Snapshot, raftpb.Entry or
+// raftpb.HardState. The records are replayed in order on open, up to the
+// first torn or damaged one, which is cut off. Taking a snapshot rewrites
+// the file with the snapshot, the hard state and the entries after it.
+const (
+	logSig     = "ElkRAFT\000"
+	logVersion = uint32(1)
+	logHeader  = 16
+)
+
+const (
+	logSnapshot  byte = 1
+	logEntry     byte = 2
+	logHardState byte = 3
+)
+
+// logFile keeps the Raft state of a node on disk, and in a
+// raft.MemoryStorage for the raft.Node to read.
+type logFile struct {
+	fp      *os.File
+	path    string
+	storage *raft.MemoryStorage
+}
+
+// openLog opens the log file at path, creating it if needed, and loads it
+// into a new storage. existed tells whether the node had state already.
+func openLog(path string) (lf *logFile, existed bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		lf, err := createLog(path, func(*os.File) error { return nil })
+		return lf, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) < logHeader || string(data[:len(logSig)]) != logSig {
+		return nil, false, fmt.Errorf("%s: not a raft log", path)
+	}
+	if v := binary.LittleEndian.Uint32(data[8:]); v > logVersion {
+		return nil, false, fmt.Errorf("%s: unsupported raft log version %d (max %d)", path, v, logVersion)
+	}
+
+	storage := raft.NewMemoryStorage()
+	end := int64(logHeader)
+	for end+9 <= int64(len(data)) {
+		typ := data[end]
+		crc := binary.LittleEndian.Uint32(data[end+1:])
+		n := int64(binary.LittleEndian.Uint32(data[end+5:]))
+		if end+9+n > int64(len(data)) || crc32.ChecksumIEEE(data[end+9:end+9+n]) != crc {
+			break
+		}
+		if err := loadRecord(storage, typ, data[end+9:end+9+n]); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		end += 9 + n
+	}
+
+	fp, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	// A torn tail is cut off, so that new records follow the last good one.
+	if end < int64(len(data)) {
+		err = fp.Truncate(end)
+	}
+	if err == nil {
+		_, err = fp.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		fp.Close()
+		return nil, false, err
+	}
+	return &logFile{fp: fp, path: path, storage: storage}, true, nil
+}
+
+func loadRecord(storage *raft.MemoryStorage, typ byte, payload []byte) error {
+	switch typ {
+	case logSnapshot:
+		snap := &raftpb.Snapshot{}
+		if err := proto.Unmarshal(payload, snap); err != nil {
+			return err
+		}
+		return storage.ApplySnapshot(snap)
+	case logEntry:
+		entry := &raftpb.Entry{}
+		if err := proto.Unmarshal(payload, entry); err != nil {
+			return err
+		}
+		return storage.Append([]*raftpb.Entry{entry})
+	case logHardState:
+		hs := &raftpb.HardState{}
+		if err := proto.Unmarshal(payload, hs); err != nil {
+			return err
+		}
+		return storage.SetHardState(hs)
+	default:
+		return fmt.Errorf("unknown raft log record %d", typ)
+	}
+}
+
+// createLog writes a new log file at path, with the records written by
+// fill, through a temporary file that replaces path once synced.
+func createLog(path string, fill func(fp *os.File) error) (*logFile, error) {
+	tmp := path + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, logHeader)
+	copy(header, logSig)
+	binary.LittleEndian.PutUint32(header[8:], logVersion)
+	_, err = fp.Write(header)
+	if err == nil {
+		err = fill(fp)
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		fp.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &logFile{fp: fp, path: path, storage: raft.NewMemoryStorage()}, nil
+}
+
+func writeRecord(w io.Writer, typ byte, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 9, 9+len(payload))
+	buf[0] = typ
+	binary.LittleEndian.PutUint32(buf[1:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buf[5:], uint32(len(payload)))
+	_, err = w.Write(append(buf, payload...))
+	return err
+}
+
+// save persists the state of a raft.Ready, then hands it to the storage.
+func (lf *logFile) save(hs *raftpb.HardState, entries []*raftpb.Entry, snap *raftpb.Snapshot, sync bool) error {
+	if !raft.IsEmptySnap(snap) {
+		if err := writeRecord(lf.fp, logSnapshot, snap); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if err := writeRecord(lf.fp, logEntry, entry); err != nil {
+			return err
+		}
+	}
+	if !raft.IsEmptyHardState(hs) {
+		if err := writeRecord(lf.fp, logHardState, hs); err != nil {
+			return err
+		}
+	}
+	if sync {
+		if err := lf.fp.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if !raft.IsEmptySnap(snap) {
+		if err := lf.storage.ApplySnapshot(snap); err != nil {
+			return err
+		}
+	}
+	if !raft.IsEmptyHardState(hs) {
+		if err := lf.storage.SetHardState(hs); err != nil {
+			return err
+		}
+	}
+	return lf.storage.Append(entries)
+}
+
+// compact takes a snapshot at index, holding data, drops the entries up to
+// keep from the storage and rewrites the file with the entries after the
+// snapshot: those kept before it are only served until the node restarts.
+func (lf *logFile) compact(index, keep uint64, cs *raftpb.ConfState, data []byte) error {
+	snap, err := lf.storage.CreateSnapshot(index, cs, data)
+	if err != nil {
+		return err
+	}
+	if err := lf.storage.Compact(keep); err != nil && !errors.Is(err, raft.ErrCompacted) {
+		return err
+	}
+	hs, _, err := lf.storage.InitialState()
+	if err != nil {
+		return err
+	}
+	first := index + 1
+	last, _ := lf.storage.LastIndex()
+	var entries []*raftpb.Entry
+	if last >= first {
+		if entries, err = lf.storage.Entries(first, last+1, ^uint64(0)); err != nil {
+			return err
+		}
+	}
+	nlf, err := createLog(lf.path, func(fp *os.File) error {
+		if err := writeRecord(fp, logSnapshot, snap); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := writeRecord(fp, logEntry, entry); err != nil {
+				return err
+			}
+		}
+		return writeRecord(fp, logHardState, hs)
+	})
+	if err != nil {
+		return err
+	}
+	lf.fp.Close()
+	lf.fp = nlf.fp
+	return nil
+}
+
+func (lf *logFile) close() error {
+	return lf.fp.Close()
+}
@@ -0,0 +1,242 @@
+package raftdb
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/MHS-20/ElkDB/kv"
+)
+
+// Transport.
+//
+// Nodes talk over TLS, with Node.TLSConfig on both ends, one connection
+// for each direction between two nodes. A connection carries messages:
+//
+//	┌──────────┬──────────────────┐
+//	│ Len      │ Message          │
+//	│ 4 bytes  │ Len bytes        │
+//	└──────────┴──────────────────┘
+//
+// each a raftpb.Message in its protobuf encoding, Len big-endian. A message
+// of type MsgSnap is followed by a backup stream of the sender's database
+// (see kv.KV.Backup), taken when it is sent: the snapshot itself holds only
+// the log position.
+
+const (
+	// sendQueue bounds the messages waiting for a peer; more are dropped,
+	// which Raft copes with.
+	sendQueue = 4096
+	// maxMessage bounds the encoded size of a message.
+	maxMessage = 1 << 30
+	// dialTimeout bounds a connection attempt to a peer.
+	dialTimeout = time.Second
+)
+
+// peer sends the messages of a node to another one.
+type peer struct {
+	n    *Node
+	id   uint64
+	addr string
+	c    chan *raftpb.Message
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newPeer(n *Node, id uint64, addr string) *peer {
+	p := &peer{n: n, id: id, addr: addr, c: make(chan *raftpb.Message, sendQueue), stop: make(chan struct{}), done: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+// send queues m, or drops it if the queue is full.
+func (p *peer) send(m *raftpb.Message) {
+	select {
+	case p.c <- m:
+	default:
+		p.n.raft.ReportUnreachable(p.id)
+		if m.GetType() == raftpb.MessageType_MsgSnap {
+			p.n.raft.ReportSnapshot(p.id, raft.SnapshotFailure)
+		}
+	}
+}
+
+func (p *peer) close() {
+	close(p.stop)
+	<-p.done
+}
+
+// run writes the queued messages, connecting to the peer as needed. After
+// a failure the messages are dropped until a connection attempt succeeds.
+func (p *peer) run() {
+	defer close(p.done)
+	var conn net.Conn
+	var w *bufio.Writer
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var m *raftpb.Message
+		select {
+		case <-p.stop:
+			return
+		case m = <-p.c:
+		}
+		if conn == nil {
+			dialer := &net.Dialer{Timeout: dialTimeout}
+			c, err := tls.DialWithDialer(dialer, "tcp", p.addr, p.n.TLSConfig)
+			if err != nil {
+				p.fail(m, err)
+				continue
+			}
+			conn, w = c, bufio.NewWriter(c)
+		}
+		err := p.write(w, m)
+		// Write the messages queued meanwhile before flushing.
+		for err == nil && len(p.c) > 0 {
+			m = <-p.c
+			err = p.write(w, m)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			conn.Close()
+			conn = nil
+			p.fail(m, err)
+		}
+	}
+}
+
+func (p *peer) write(w *bufio.Writer, m *raftpb.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if m.GetType() != raftpb.MessageType_MsgSnap {
+		return nil
+	}
+	if _, err := p.n.db.Backup(w); err != nil {
+		return fmt.Errorf("snapshot backup: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	p.n.raft.ReportSnapshot(p.id, raft.SnapshotFinish)
+	return nil
+}
+
+// fail reports a message that could not be sent.
+func (p *peer) fail(m *raftpb.Message, err error) {
+	p.n.raft.ReportUnreachable(p.id)
+	if m.GetType() == raftpb.MessageType_MsgSnap {
+		log.Printf("elkdb-raft: node %d: sending a snapshot to %d: %v", p.n.ID, p.id, err)
+		p.n.raft.ReportSnapshot(p.id, raft.SnapshotFailure)
+	}
+}
+
+// serve takes the connections of the other nodes until ln is closed.
+func (n *Node) serve(ln net.Listener) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		if n.closed {
+			n.mu.Unlock()
+			conn.Close()
+			return
+		}
+		n.conns[conn] = struct{}{}
+		n.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.receive(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("elkdb-raft: node %d: [%s] %v", n.ID, conn.RemoteAddr(), err)
+			}
+			n.mu.Lock()
+			delete(n.conns, conn)
+			n.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// receive steps the messages read from conn into the node.
+func (n *Node) receive(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		if size > maxMessage {
+			return fmt.Errorf("message of %d bytes is too large", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		m := &raftpb.Message{}
+		if err := proto.Unmarshal(data, m); err != nil {
+			return err
+		}
+		if m.GetType() == raftpb.MessageType_MsgSnap {
+			if err := n.receiveSnapshot(r, m); err != nil {
+				return fmt.Errorf("snapshot: %w", err)
+			}
+			continue
+		}
+		if err := n.raft.Step(n.ctx, m); err != nil {
+			return err
+		}
+	}
+}
+
+// receiveSnapshot restores the backup following a MsgSnap to the snapshot
+// file, which the node installs if Raft takes the snapshot.
+func (n *Node) receiveSnapshot(r io.Reader, m *raftpb.Message) error {
+	n.snapMu.Lock()
+	defer n.snapMu.Unlock()
+	if err := removeSnap(n.snapPath()); err != nil {
+		return err
+	}
+	if err := kv.RestoreFrom(r, n.snapPath()); err != nil {
+		return err
+	}
+	return n.raft.Step(n.ctx, m)
+}
+
+// removeSnap removes a snapshot file and its WAL.
+func removeSnap(path string) error {
+	for _, p := range []string{path, path + ".wal"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
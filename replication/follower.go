@@ -0,0 +1,253 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/tables"
+)
+
+// DefaultRetryDelay is the pause between reconnection attempts of a Follower
+// when RetryDelay is 0.
+const DefaultRetryDelay = time.Second
+
+// Follower keeps a local, read-only copy of a Leader's database. Open
+// brings the file at Path up to date with a backup, incremental when the
+// file already holds an earlier version of the leader's database, then
+// opens it; from then on the changes of the leader are applied as they
+// come, and a lost connection is retried from where it stopped.
+//
+// Following stops, with Err set, when the leader no longer holds the
+// changes the follower needs (ErrBehind), refuses its credentials
+// (ErrDenied), or the file does not descend from the leader's database
+// (kv.ErrDiverged). The database stays open for
+// reads; Close and Open the Follower again to resync it.
+type Follower struct {
+	// Path is the local database file.
+	Path string
+	// Leader is the TCP address of the Leader.
+	Leader string
+	// RetryDelay is the pause between reconnection attempts
+	// (0 = DefaultRetryDelay).
+	RetryDelay time.Duration
+	// TLSConfig, if set, connects to the leader over TLS; see the tlsconfig
+	// package. Its certificate authenticates the follower to a leader that
+	// requires one.
+	TLSConfig *tls.Config
+	// User and Password authenticate the follower to a leader with
+	// RequireAuth set.
+	User     string
+	Password string
+
+	db      *tables.DB
+	version uint64 // version of db; owned by the apply loop
+
+	mu     sync.Mutex
+	conn   net.Conn
+	err    error
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Open syncs the local file with the leader, opens it and starts following.
+func (f *Follower) Open() error {
+	conn, r, err := f.sync(modeSync)
+	if errors.Is(err, kv.ErrBadBackup) {
+		// The increment does not fit the file: start over.
+		conn, r, err = f.sync(modeFull)
+	}
+	if err != nil {
+		return fmt.Errorf("replication: %w", err)
+	}
+	f.db = &tables.DB{Path: f.Path, ReadOnly: true}
+	if err := f.db.Open(); err != nil {
+		conn.Close()
+		return fmt.Errorf("replication: %w", err)
+	}
+	f.conn, f.err, f.closed = conn, nil, false
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+	go f.run(r)
+	return nil
+}
+
+// DB returns the follower's database, open for reads.
+func (f *Follower) DB() *tables.DB {
+	return f.db
+}
+
+// Err returns why the follower stopped following, or nil while it follows.
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close stops following and closes the database.
+func (f *Follower) Close() {
+	f.mu.Lock()
+	f.closed = true
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	close(f.stop)
+	<-f.done
+	f.db.Close()
+}
+
+// sync brings the closed local file up to date with a backup from the
+// leader, asking for it with mode, and returns the connection to stream
+// the following changes from.
+func (f *Follower) sync(mode byte) (net.Conn, *bufio.Reader, error) {
+	version, err := fileVersion(f.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, r, err := f.dial(mode, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	typ, payload, err := readFrame(r)
+	if err == nil && typ == frameError {
+		err = frameErr(payload)
+	} else if err == nil && (typ != frameBackup || len(payload) != 1) {
+		err = fmt.Errorf("unexpected frame %d", typ)
+	}
+	if err == nil && payload[0] == 1 {
+		for _, p := range []string{f.Path, f.Path + ".wal"} {
+			if err = os.Remove(p); errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = kv.RestoreFrom(r, f.Path)
+		}
+	} else if err == nil {
+		err = kv.ApplyBackup(r, f.Path)
+	}
+	if err == nil {
+		f.version, err = fileVersion(f.Path)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, r, nil
+}
+
+// dial connects to the leader and says hello.
+func (f *Follower) dial(mode byte, version uint64) (net.Conn, *bufio.Reader, error) {
+	var conn net.Conn
+	var err error
+	if f.TLSConfig != nil {
+		conn, err = tls.Dial("tcp", f.Leader, f.TLSConfig)
+	} else {
+		conn, err = net.Dial("tcp", f.Leader)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeHello(conn, hello{mode, version, f.User, f.Password}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, bufio.NewReader(conn), nil
+}
+
+// run applies the changes of the leader until Close, reconnecting when the
+// connection is lost.
+func (f *Follower) run(r *bufio.Reader) {
+	defer close(f.done)
+	delay := f.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for {
+		err := f.apply(r)
+		f.mu.Lock()
+		f.conn.Close()
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return
+		}
+		if errors.Is(err, ErrBehind) || errors.Is(err, ErrDenied) || errors.Is(err, kv.ErrDiverged) {
+			log.Printf("elkdb-replication: stopped following %s: %v", f.Leader, err)
+			f.mu.Lock()
+			f.err = err
+			f.mu.Unlock()
+			return
+		}
+		log.Printf("elkdb-replication: lost %s at version %d: %v", f.Leader, f.version, err)
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-time.After(delay):
+			}
+			var conn net.Conn
+			conn, r, err = f.dial(modeLive, f.version)
+			if err == nil {
+				f.mu.Lock()
+				f.conn = conn
+				if f.closed {
+					conn.Close()
+				}
+				f.mu.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// apply applies the records read from r until an error.
+func (f *Follower) apply(r *bufio.Reader) error {
+	for {
+		typ, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case frameRecord:
+			rec, err := parseRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := f.db.Apply(rec.Data); err != nil {
+				return err
+			}
+			f.version = max(f.version, rec.Version)
+		case frameError:
+			return frameErr(payload)
+		default:
+			return fmt.Errorf("unexpected frame %d", typ)
+		}
+	}
+}
+
+// fileVersion returns the version of the closed database file at path, 0
+// if there is none, after recovering the commits in its WAL.
+func fileVersion(path string) (uint64, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	store := kv.KV{Path: path}
+	if err := store.Open(); err != nil {
+		return 0, err
+	}
+	defer store.Close()
+	return store.Stats().Version, nil
+}
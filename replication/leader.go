@@ -0,0 +1,334 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/tables"
+)
+
+// DefaultBacklog is the backlog a Leader keeps when Backlog is 0.
+const DefaultBacklog = 64 << 20
+
+// feedQueue is the queue of the feed a Leader reads; the records are moved
+// to the backlog as soon as they come.
+const feedQueue = 1024
+
+// Leader serves the changes of a database to followers. Each follower is
+// sent a backup of the database, then every change committed after it; one
+// that reconnects is sent the changes it missed, as long as the backlog
+// still holds them.
+//
+// A follower receives every row of the database, so a Leader only serves
+// followers it can identify: it refuses to start unless RequireAuth is set
+// or TLSConfig requires client certificates.
+type Leader struct {
+	// DB is the open database to replicate.
+	DB *tables.DB
+	// Addr is the TCP address to listen on, e.g. ":5434".
+	Addr string
+	// Backlog bounds the bytes of changes kept for followers that reconnect
+	// (0 = DefaultBacklog). A follower away for longer than the backlog
+	// lasts stops with ErrBehind, as do all followers after the leader
+	// compacts its file.
+	Backlog int
+	// TLSConfig, if set, makes the leader accept TLS connections only; see
+	// the tlsconfig package. With a client CA, only followers presenting a
+	// certificate it signed are served.
+	TLSConfig *tls.Config
+	// RequireAuth makes followers authenticate as an admin user of DB
+	// (Follower.User and Follower.Password).
+	RequireAuth bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	recs   []kv.Record // the backlog; recs[i] has sequence number next-len(recs)+i
+	next   uint64      // sequence number of the next record
+	base   uint64      // the backlog holds every change after this version
+	size   int         // bytes in recs
+	feed   *kv.Feed
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// ListenAndServe listens on Addr and serves followers until Close.
+func (l *Leader) ListenAndServe() error {
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", l.Addr, err)
+	}
+	return l.Serve(ln)
+}
+
+// Serve serves the followers that connect to ln until Close, which closes
+// ln. It always returns a non-nil error.
+func (l *Leader) Serve(ln net.Listener) error {
+	certAuth := l.TLSConfig != nil && l.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	if !l.RequireAuth && !certAuth {
+		ln.Close()
+		return errors.New("replication: leader needs RequireAuth or TLS client certificates")
+	}
+	if l.TLSConfig != nil {
+		ln = tls.NewListener(ln, l.TLSConfig)
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	if l.feed != nil {
+		l.mu.Unlock()
+		return errors.New("replication: leader already serving")
+	}
+	l.cond = sync.NewCond(&l.mu)
+	l.ln = ln
+	l.conns = map[net.Conn]struct{}{}
+	l.feed = l.DB.Feed(feedQueue)
+	l.base = l.feed.Version
+	go l.pump(l.feed)
+	l.mu.Unlock()
+
+	log.Printf("elkdb-replication: leader listening on %s", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("accept: %w", err)
+		}
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+		go l.handleConn(conn)
+	}
+}
+
+// Close stops serving and disconnects the followers.
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	if l.feed != nil {
+		l.feed.Close()
+		l.cond.Broadcast()
+		return l.ln.Close()
+	}
+	return nil
+}
+
+// pump moves the records of the feed to the backlog. A feed closed by a
+// compaction, or because the pump fell behind, is opened again; the changes
+// in between are lost to followers, which the gap in sequence numbers tells
+// them.
+func (l *Leader) pump(feed *kv.Feed) {
+	for {
+		for rec := range feed.C {
+			l.push(rec)
+		}
+		err := feed.Err()
+		l.mu.Lock()
+		if l.closed || err == nil || errors.Is(err, os.ErrClosed) {
+			l.mu.Unlock()
+			return
+		}
+		log.Printf("elkdb-replication: %v; followers must resync", err)
+		feed = l.DB.Feed(feedQueue)
+		l.feed = feed
+		l.recs, l.size = nil, 0
+		l.base = feed.Version
+		l.next++
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}
+}
+
+func (l *Leader) push(rec kv.Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recs = append(l.recs, rec)
+	l.size += len(rec.Data)
+	l.next++
+	backlog := l.Backlog
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	for len(l.recs) > 1 && l.size > backlog {
+		old := l.recs[0]
+		l.recs[0] = kv.Record{}
+		l.recs = l.recs[1:]
+		l.size -= len(old.Data)
+		l.base = old.Version
+		if !old.Commit {
+			l.base++ // a follower at old.Version may not have truncated yet
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// handleConn serves one follower.
+func (l *Leader) handleConn(conn net.Conn) {
+	remote := conn.RemoteAddr().String()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+	w := bufio.NewWriter(conn)
+	h, err := readHello(conn)
+	if err != nil {
+		log.Printf("elkdb-replication: [%s] bad hello: %v", remote, err)
+		return
+	}
+	if err := l.authenticate(h); err != nil {
+		log.Printf("elkdb-replication: [%s] %v", remote, err)
+		if writeErrorFrame(w, codeDenied, err.Error()) == nil {
+			_ = w.Flush()
+		}
+		return
+	}
+	// The follower says nothing more; its end of the connection closing
+	// wakes the sender up.
+	gone := false
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		l.mu.Lock()
+		gone = true
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}()
+
+	var cursor uint64
+	if h.mode == modeLive {
+		cursor, err = l.resume(h.version)
+	} else {
+		cursor, err = l.bootstrap(w, h.mode == modeSync, h.version)
+	}
+	if err == nil {
+		log.Printf("elkdb-replication: [%s] following from version %d", remote, h.version)
+		err = l.stream(w, cursor, &gone)
+	}
+	code := codeFailed
+	if errors.Is(err, ErrBehind) {
+		code = codeBehind
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("elkdb-replication: [%s] %v", remote, err)
+		if writeErrorFrame(w, code, err.Error()) == nil {
+			_ = w.Flush()
+		}
+	}
+}
+
+// authenticate checks the credentials of a follower's hello, if the leader
+// requires them.
+func (l *Leader) authenticate(h hello) error {
+	if !l.RequireAuth {
+		return nil
+	}
+	user, err := l.DB.Authenticate(h.user, h.password)
+	if err != nil {
+		return fmt.Errorf("follower %q: %w", h.user, err)
+	}
+	if !user.Admin {
+		return fmt.Errorf("follower %q: replication needs an admin user", h.user)
+	}
+	return nil
+}
+
+// resume returns the sequence number of the first record a follower at
+// version needs.
+func (l *Leader) resume(version uint64) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if version < l.base {
+		return 0, fmt.Errorf("%w: version %d, the backlog starts after %d", ErrBehind, version, l.base)
+	}
+	first := l.next - uint64(len(l.recs))
+	i := slices.IndexFunc(l.recs, func(rec kv.Record) bool { return rec.Version >= version })
+	if i < 0 {
+		i = len(l.recs)
+	}
+	return first + uint64(i), nil
+}
+
+// bootstrap sends a follower at version a backup, incremental if sync is
+// set and the follower holds a version the leader went through, and
+// returns the sequence number of the first record it must stream after it.
+func (l *Leader) bootstrap(w *bufio.Writer, sync bool, version uint64) (uint64, error) {
+	// Records from here on hold every change made after the backup; those
+	// it already holds are skipped by the follower.
+	l.mu.Lock()
+	cursor := l.next
+	l.mu.Unlock()
+
+	full := !sync || version == 0
+	if err := writeBackupFrame(w, full); err != nil {
+		return 0, err
+	}
+	var err error
+	if full {
+		_, err = l.DB.Backup(w)
+	} else {
+		_, err = l.DB.BackupSince(version, w)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cursor, w.Flush()
+}
+
+// stream sends a follower the records from cursor on, as they come, until
+// it goes away or the leader is closed.
+func (l *Leader) stream(w *bufio.Writer, cursor uint64, gone *bool) error {
+	for {
+		l.mu.Lock()
+		if cursor == l.next && !l.closed && !*gone {
+			l.mu.Unlock()
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			l.mu.Lock()
+			for cursor == l.next && !l.closed && !*gone {
+				l.cond.Wait()
+			}
+		}
+		if l.closed || *gone {
+			l.mu.Unlock()
+			return net.ErrClosed
+		}
+		first := l.next - uint64(len(l.recs))
+		if cursor < first {
+			l.mu.Unlock()
+			return fmt.Errorf("%w: the changes after the follower's version were dropped", ErrBehind)
+		}
+		batch := slices.Clone(l.recs[cursor-first:])
+		cursor = l.next
+		l.mu.Unlock()
+		for _, rec := range batch {
+			if err := writeRecordFrame(w, rec); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package replication streams the changes of a leader database to read-only
+// followers over TCP. A Leader serves the changes of a tables.DB; a Follower
+// keeps a local copy of it up to date, which it opens with DB.ReadOnly for
+// queries. Replication is asynchronous: the leader commits without waiting
+// for its followers, which lag it by the time a change takes to reach them.
+//
+// # Protocol
+//
+// The follower opens the connection with a hello:
+//
+//	┌──────────┬─────────┬──────┬─────────┬─────────┬──────┬─────────┬──────────┐
+//	│ Sig      │ Version │ Mode │ Version │ UserLen │ User │ PassLen │ Password │
+//	│ 7 bytes  │ 1 byte  │ 1 B  │ 8 bytes │ 2 bytes │      │ 2 bytes │          │
+//	└──────────┴─────────┴──────┴─────────┴─────────┴──────┴─────────┴──────────┘
+//
+// naming the protocol version, what it asks for, the version of the
+// database it holds and the user it authenticates as (empty when the leader
+// does not ask for one). The leader answers with frames:
+//
+//	┌──────────┬────────────┬──────────────────┐
+//	│ Type     │ PayloadLen │ Payload          │
+//	│ 1 byte   │ 4 bytes    │ PayloadLen bytes │
+//	└──────────┴────────────┴──────────────────┘
+//
+// A backup frame says whether the backup is a full one, and is followed by
+// the backup stream itself (see kv.KV.Backup and kv.KV.BackupSince). A
+// record frame carries a kv.Record, an error frame a code and a message.
+// All multi-byte integers are big-endian.
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/MHS-20/ElkDB/kv"
+)
+
+const (
+	helloSig     = "ElkREP\000"
+	protoVersion = 2
+	helloSize    = 7 + 1 + 1 + 8
+)
+
+// Hello modes.
+const (
+	modeLive byte = 1 // stream the records after the follower's version
+	modeSync byte = 2 // send a backup, incremental if the leader can, then stream
+	modeFull byte = 3 // send a full backup, then stream
+)
+
+// Frame types.
+const (
+	frameBackup byte = 1
+	frameRecord byte = 2
+	frameError  byte = 3
+)
+
+// Error frame codes.
+const (
+	codeBehind byte = 1 // the backlog no longer holds the follower's version
+	codeFailed byte = 2
+	codeDenied byte = 3 // the follower failed to authenticate
+)
+
+// maxFrame bounds the payload of a frame, well above the largest record.
+const maxFrame = 1 << 30
+
+// ErrBehind is returned (wrapped) when the leader no longer holds the
+// changes a follower needs to catch up: it was away for longer than the
+// backlog lasts, or the leader compacted its file.
+var ErrBehind = errors.New("follower fell behind the leader's backlog")
+
+// ErrDenied is returned (wrapped) when the leader refuses the follower's
+// credentials.
+var ErrDenied = errors.New("leader refused the follower's credentials")
+
+// hello is what a follower opens the connection with.
+type hello struct {
+	mode     byte
+	version  uint64
+	user     string
+	password string
+}
+
+func writeHello(w io.Writer, h hello) error {
+	if len(h.user) > math.MaxUint16 || len(h.password) > math.MaxUint16 {
+		return errors.New("user name or password too long")
+	}
+	buf := make([]byte, helloSize, helloSize+4+len(h.user)+len(h.password))
+	copy(buf, helloSig)
+	buf[7] = protoVersion
+	buf[8] = h.mode
+	binary.BigEndian.PutUint64(buf[9:], h.version)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(h.user)))
+	buf = append(buf, h.user...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(h.password)))
+	buf = append(buf, h.password...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readHello(r io.Reader) (hello, error) {
+	buf := make([]byte, helloSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return hello{}, err
+	}
+	if string(buf[:7]) != helloSig {
+		return hello{}, errors.New("not a replication hello")
+	}
+	if buf[7] != protoVersion {
+		return hello{}, fmt.Errorf("unsupported protocol version %d", buf[7])
+	}
+	h := hello{mode: buf[8], version: binary.BigEndian.Uint64(buf[9:])}
+	var err error
+	if h.user, err = readString(r); err != nil {
+		return hello{}, err
+	}
+	if h.password, err = readString(r); err != nil {
+		return hello{}, err
+	}
+	return h, nil
+}
+
+// readString reads a string prefixed with its 2-byte length.
+func readString(r io.Reader) (string, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func writeFrame(w io.Writer, typ byte, payload ...[]byte) error {
+	n := 0
+	for _, p := range payload {
+		n += len(p)
+	}
+	var hdr [5]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint32(hdr[1:], uint32(n))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	for _, p := range payload {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxFrame {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+func writeBackupFrame(w io.Writer, full bool) error {
+	if full {
+		return writeFrame(w, frameBackup, []byte{1})
+	}
+	return writeFrame(w, frameBackup, []byte{0})
+}
+
+func writeRecordFrame(w io.Writer, rec kv.Record) error {
+	hdr := binary.BigEndian.AppendUint64(nil, rec.Version)
+	if rec.Commit {
+		hdr = append(hdr, 1)
+	} else {
+		hdr = append(hdr, 0)
+	}
+	return writeFrame(w, frameRecord, hdr, rec.Data)
+}
+
+func parseRecord(payload []byte) (kv.Record, error) {
+	if len(payload) < 9 {
+		return kv.Record{}, errors.New("short record frame")
+	}
+	return kv.Record{
+		Version: binary.BigEndian.Uint64(payload),
+		Commit:  payload[8] == 1,
+		Data:    payload[9:],
+	}, nil
+}
+
+func writeErrorFrame(w io.Writer, code byte, msg string) error {
+	return writeFrame(w, frameError, []byte{code}, []byte(msg))
+}
+
+// frameErr turns the payload of an error frame into an error.
+func frameErr(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("leader: empty error frame")
+	}
+	switch payload[0] {
+	case codeBehind:
+		return fmt.Errorf("leader: %w: %s", ErrBehind, payload[1:])
+	case codeDenied:
+		return fmt.Errorf("leader: %w: %s", ErrDenied, payload[1:])
+	}
+	return fmt.Errorf("leader: %s", payload[1:])
+}
@@ -0,0 +1,187 @@
+package replication
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/internal/testcert"
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/tables"
+	"github.com/MHS-20/ElkDB/tlsconfig"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	dir := t.TempDir()
+	db := &tables.DB{Path: filepath.Join(dir, "leader.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	tx := tables.DBTX{}
+	db.Begin(&tx)
+	is.NoError(t, tx.TableNew(&tables.TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "k", "v"},
+		Types:   []uint32{tables.TypeInt64, tables.TypeInt64, tables.TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"k"}},
+	}))
+	is.NoError(t, db.Commit(&tx))
+	_, err := db.BootstrapAdmin("repl", "secret")
+	is.NoError(t, err)
+	next := int64(0)
+	insert := func(n int) {
+		tx := tables.DBTX{}
+		db.Begin(&tx)
+		for range n {
+			_, err := tx.Insert("t", *(&tables.Record{}).AddInt64("id", next).AddInt64("k", next%7).AddStr("v", make([]byte, 100)))
+			is.NoError(t, err)
+			next++
+		}
+		is.NoError(t, db.Commit(&tx))
+	}
+	count := func(db *tables.DB) int64 {
+		r := tables.DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		sc := tables.FullScan()
+		is.NoError(t, r.Scan("t", &sc))
+		n := int64(0)
+		for ; sc.Valid(); sc.Next() {
+			n++
+		}
+		return n
+	}
+	serve := func(addr string) (*Leader, string) {
+		ln, err := net.Listen("tcp", addr)
+		is.NoError(t, err)
+		l := &Leader{DB: db, RequireAuth: true}
+		go func() { _ = l.Serve(ln) }()
+		is.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return l.feed != nil
+		}, time.Second, time.Millisecond)
+		return l, ln.Addr().String()
+	}
+	insert(500)
+	leader, addr := serve("127.0.0.1:0")
+
+	// A new follower starts from a full backup, then follows.
+	f := &Follower{
+		Path: filepath.Join(dir, "follower.db"), Leader: addr, RetryDelay: 10 * time.Millisecond,
+		User: "repl", Password: "secret",
+	}
+	is.NoError(t, f.Open())
+	is.Equal(t, int64(500), count(f.DB()))
+	for range 20 {
+		insert(50)
+	}
+	is.Eventually(t, func() bool { return count(f.DB()) == next }, 5*time.Second, 5*time.Millisecond)
+	is.True(t, f.DB().Check().OK())
+	tx = tables.DBTX{}
+	f.DB().Begin(&tx)
+	_, err = tx.Insert("t", *(&tables.Record{}).AddInt64("id", -1).AddInt64("k", 0).AddStr("v", nil))
+	is.NoError(t, err)
+	is.ErrorIs(t, f.DB().Commit(&tx), kv.ErrReadOnly)
+
+	// A follower that comes back is sent what it missed as an increment.
+	f.Close()
+	insert(100)
+	is.NoError(t, f.Open())
+	is.Equal(t, next, count(f.DB()))
+
+	// A lost connection is resumed from the backlog.
+	leader.mu.Lock()
+	for conn := range leader.conns {
+		conn.Close()
+	}
+	leader.mu.Unlock()
+	insert(10) // committed while the follower is away
+	insert(10)
+	is.Eventually(t, func() bool { return count(f.DB()) == next }, 5*time.Second, 5*time.Millisecond)
+	is.NoError(t, f.Err())
+
+	// Changes the leader no longer holds stop the follower, which can still
+	// be read and resyncs when opened again.
+	leader.Close()
+	insert(10)
+	leader, _ = serve(addr)
+	defer leader.Close()
+	is.Eventually(t, func() bool { return f.Err() != nil }, 5*time.Second, 5*time.Millisecond)
+	is.ErrorIs(t, f.Err(), ErrBehind)
+	is.Equal(t, next-10, count(f.DB()))
+	f.Close()
+	is.NoError(t, f.Open())
+	defer f.Close()
+	is.Equal(t, next, count(f.DB()))
+}
+
+func TestLeaderSecurity(t *testing.T) {
+	dir := t.TempDir()
+	db := &tables.DB{Path: filepath.Join(dir, "leader.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	_, err := db.BootstrapAdmin("admin", "secret")
+	is.NoError(t, err)
+	tx := tables.DBTX{}
+	db.Begin(&tx)
+	is.NoError(t, tx.UserNew("reader", "pw", false))
+	is.NoError(t, db.Commit(&tx))
+
+	serve := func(l *Leader) (string, error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		is.NoError(t, err)
+		errc := make(chan error, 1)
+		go func() { errc <- l.Serve(ln) }()
+		select {
+		case err := <-errc:
+			return "", err
+		case <-time.After(50 * time.Millisecond):
+		}
+		t.Cleanup(func() { l.Close() })
+		return ln.Addr().String(), nil
+	}
+	follow := func(f *Follower) error {
+		f.Path = filepath.Join(t.TempDir(), "follower.db")
+		err := f.Open()
+		if err == nil {
+			f.Close()
+		}
+		return err
+	}
+
+	// A leader that cannot tell who its followers are does not start.
+	_, err = serve(&Leader{DB: db})
+	is.ErrorContains(t, err, "needs RequireAuth")
+	cert, key := testcert.Write(t, "leader")
+	srvTLS, err := tlsconfig.Server(cert, key, "")
+	is.NoError(t, err)
+	_, err = serve(&Leader{DB: db, TLSConfig: srvTLS})
+	is.ErrorContains(t, err, "needs RequireAuth")
+
+	// With RequireAuth, followers log in as an admin.
+	addr, err := serve(&Leader{DB: db, RequireAuth: true})
+	is.NoError(t, err)
+	is.NoError(t, follow(&Follower{Leader: addr, User: "admin", Password: "secret"}))
+	is.ErrorIs(t, follow(&Follower{Leader: addr, User: "admin", Password: "wrong"}), ErrDenied)
+	is.ErrorIs(t, follow(&Follower{Leader: addr}), ErrDenied)
+	err = follow(&Follower{Leader: addr, User: "reader", Password: "pw"})
+	is.ErrorIs(t, err, ErrDenied)
+	is.ErrorContains(t, err, "admin")
+
+	// With client certificates, the certificate is enough.
+	clientCert, clientKey := testcert.Write(t, "follower")
+	srvTLS, err = tlsconfig.Server(cert, key, clientCert)
+	is.NoError(t, err)
+	addr, err = serve(&Leader{DB: db, TLSConfig: srvTLS})
+	is.NoError(t, err)
+	cliTLS, err := tlsconfig.Client(cert, clientCert, clientKey)
+	is.NoError(t, err)
+	is.NoError(t, follow(&Follower{Leader: addr, TLSConfig: cliTLS}))
+	cliTLS, err = tlsconfig.Client(cert, "", "")
+	is.NoError(t, err)
+	is.Error(t, follow(&Follower{Leader: addr, TLSConfig: cliTLS}))
+	is.Error(t, follow(&Follower{Leader: addr}))
+}
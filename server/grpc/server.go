@@ -0,0 +1,471 @@
+// Package grpc serves ElkDB tables over gRPC, with the ElkDB service of
+// proto/elkdb/v1. The generated client and message types are in package
+// elkdbv1. Like server/http, it is built on the tables.DB API, so it needs
+// nothing beyond an open database:
+//
+//	Get          fetch one row by primary key (NOT_FOUND if absent)
+//	Put          insert or replace a row
+//	Delete       delete a row by primary key (NOT_FOUND if absent)
+//	Scan         stream the rows of a range, read from one snapshot
+//	CreateTable  create a table (ALREADY_EXISTS if the name is taken)
+//
+// A row is a list of columns. The value of a column must have the case of
+// its type: int64, float64, or bytes, which also holds the 16 bytes of a
+// UUID. Writes that lose an OCC conflict are retried on the server.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/kv"
+	elkdbv1 "github.com/MHS-20/ElkDB/proto/elkdb/v1"
+	table "github.com/MHS-20/ElkDB/tables"
+)
+
+// Server serves DB over gRPC. Use ListenAndServe (or Serve) and Shutdown, or
+// Register the service on an existing grpc.Server.
+type Server struct {
+	// Addr is the TCP address to listen on, e.g. ":5435".
+	Addr string
+	// DB is the database to serve; it must be open and outlive the server.
+	DB *table.DB
+	// TLSConfig, if set, makes the server accept TLS connections only; see
+	// the tlsconfig package.
+	TLSConfig *tls.Config
+	// RequireAuth makes every call authenticate against the users of DB
+	// with HTTP Basic credentials in its "authorization" metadata (see
+	// BasicAuth); creating tables then needs an admin.
+	RequireAuth bool
+
+	mu  sync.Mutex
+	srv *grpc.Server
+}
+
+// Register registers the ElkDB service on r.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	elkdbv1.RegisterElkDBServer(r, &service{s: s})
+}
+
+// ListenAndServe listens on Addr and serves until Shutdown, after which it
+// returns nil.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", s.Addr, err)
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves connections accepted from ln until Shutdown, after which it
+// returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	mode := "plain TCP"
+	if s.TLSConfig != nil {
+		mode = "TLS"
+	}
+	log.Printf("elkdb-grpc: listening on %s (%s)", ln.Addr(), mode)
+	err := s.server().Serve(ln)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) server() *grpc.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv == nil {
+		var opts []grpc.ServerOption
+		if s.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLSConfig)))
+		}
+		s.srv = grpc.NewServer(opts...)
+		s.Register(s.srv)
+	}
+	return s.srv
+}
+
+// Shutdown stops accepting calls and waits for those in flight to finish,
+// or cancels them once ctx expires. The DB is left open.
+func (s *Server) Shutdown(ctx context.Context) error {
+	srv := s.server()
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// BasicAuth returns credentials that authenticate every call as the user
+// name, for a server with RequireAuth. Pass it to grpc.WithPerRPCCredentials;
+// as it sends a password, it refuses connections without TLS unless
+// insecure is set.
+func BasicAuth(name, password string, insecure bool) credentials.PerRPCCredentials {
+	return basicAuth{
+		header:   "Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password)),
+		insecure: insecure,
+	}
+}
+
+type basicAuth struct {
+	header   string
+	insecure bool
+}
+
+func (b basicAuth) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": b.header}, nil
+}
+
+func (b basicAuth) RequireTransportSecurity() bool { return !b.insecure }
+
+// ---------------------------------------------------------------------------
+// Service
+// ---------------------------------------------------------------------------
+
+// service implements elkdbv1.ElkDBServer for a Server.
+type service struct {
+	elkdbv1.UnimplementedElkDBServer
+	s *Server
+}
+
+// user authenticates the call ctx belongs to. Without RequireAuth it
+// returns the zero User.
+func (sv *service) user(ctx context.Context) (table.User, error) {
+	if !sv.s.RequireAuth {
+		return table.User{}, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 {
+		return table.User{}, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	payload, ok := strings.CutPrefix(auth[0], "Basic ")
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	name, password, found := strings.Cut(string(raw), ":")
+	if !ok || err != nil || !found {
+		return table.User{}, status.Error(codes.Unauthenticated, "bad authorization metadata")
+	}
+	user, err := sv.s.DB.Authenticate(name, password)
+	if errors.Is(err, table.ErrAuth) {
+		return table.User{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return user, statusErr(err)
+}
+
+func (sv *service) Get(ctx context.Context, req *elkdbv1.GetRequest) (*elkdbv1.Row, error) {
+	if _, err := sv.user(ctx); err != nil {
+		return nil, err
+	}
+	tx := table.DBReader{}
+	sv.s.DB.BeginRead(&tx)
+	defer sv.s.DB.EndRead(&tx)
+	tdef, rec, err := primaryKey(&tx, req.GetTable(), req.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	ok, err := tx.Get(tdef.Name, &rec)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "row not found")
+	}
+	return row(rec), nil
+}
+
+func (sv *service) Put(ctx context.Context, req *elkdbv1.PutRequest) (*elkdbv1.PutResponse, error) {
+	user, err := sv.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var added bool
+	err = sv.s.DB.Update(func(tx *table.DBTX) error {
+		tx.User = user.Name
+		tdef := tableDef(&tx.DBReader, req.GetTable())
+		if tdef == nil {
+			return status.Errorf(codes.NotFound, "table not found: %s", req.GetTable())
+		}
+		rec, err := record(tdef, req.GetRow().GetColumns())
+		if err != nil {
+			return err
+		}
+		if added, err = tx.Upsert(tdef.Name, rec); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return &elkdbv1.PutResponse{Created: added}, nil
+}
+
+func (sv *service) Delete(ctx context.Context, req *elkdbv1.DeleteRequest) (*elkdbv1.DeleteResponse, error) {
+	user, err := sv.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var deleted bool
+	err = sv.s.DB.Update(func(tx *table.DBTX) error {
+		tx.User = user.Name
+		tdef, rec, err := primaryKey(&tx.DBReader, req.GetTable(), req.GetKey())
+		if err != nil {
+			return err
+		}
+		deleted, err = tx.Delete(tdef.Name, rec)
+		return err
+	})
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	if !deleted {
+		return nil, status.Error(codes.NotFound, "row not found")
+	}
+	return &elkdbv1.DeleteResponse{}, nil
+}
+
+var cmps = map[elkdbv1.Cmp]int{
+	elkdbv1.Cmp_CMP_GE: btree.CmpGE,
+	elkdbv1.Cmp_CMP_GT: btree.CmpGT,
+	elkdbv1.Cmp_CMP_LT: btree.CmpLT,
+	elkdbv1.Cmp_CMP_LE: btree.CmpLE,
+}
+
+func (sv *service) Scan(req *elkdbv1.ScanRequest, stream grpc.ServerStreamingServer[elkdbv1.Row]) error {
+	ctx := stream.Context()
+	if _, err := sv.user(ctx); err != nil {
+		return err
+	}
+	tx := table.DBReader{}
+	sv.s.DB.BeginRead(&tx)
+	defer sv.s.DB.EndRead(&tx)
+	tdef := tableDef(&tx, req.GetTable())
+	if tdef == nil {
+		return status.Errorf(codes.NotFound, "table not found: %s", req.GetTable())
+	}
+	sc := table.Scanner{Cmp1: btree.CmpGE, Limit: int(req.GetLimit())}
+	var err error
+	if req.GetCmp1() != elkdbv1.Cmp_CMP_UNSPECIFIED {
+		if sc.Cmp1 = cmps[req.GetCmp1()]; sc.Cmp1 == 0 {
+			return status.Errorf(codes.InvalidArgument, "bad cmp1: %v", req.GetCmp1())
+		}
+	}
+	if req.GetCmp2() != elkdbv1.Cmp_CMP_UNSPECIFIED {
+		if sc.Cmp2 = cmps[req.GetCmp2()]; sc.Cmp2 == 0 {
+			return status.Errorf(codes.InvalidArgument, "bad cmp2: %v", req.GetCmp2())
+		}
+	}
+	if sc.Key1, err = record(tdef, req.GetKey1()); err != nil {
+		return err
+	}
+	if sc.Key2, err = record(tdef, req.GetKey2()); err != nil {
+		return err
+	}
+	if err := tx.Scan(tdef.Name, &sc); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for ; sc.Valid(); sc.Next() {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		var rec table.Record
+		sc.Deref(&rec)
+		if err := stream.Send(row(rec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var colTypes = map[elkdbv1.ColumnType]uint32{
+	elkdbv1.ColumnType_COLUMN_TYPE_BYTES:   table.TypeBytes,
+	elkdbv1.ColumnType_COLUMN_TYPE_INT64:   table.TypeInt64,
+	elkdbv1.ColumnType_COLUMN_TYPE_FLOAT64: table.TypeFloat64,
+	elkdbv1.ColumnType_COLUMN_TYPE_UUID:    table.TypeUUID,
+}
+
+func (sv *service) CreateTable(ctx context.Context, req *elkdbv1.CreateTableRequest) (*elkdbv1.CreateTableResponse, error) {
+	user, err := sv.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if sv.s.RequireAuth && !user.Admin {
+		return nil, status.Error(codes.PermissionDenied, "only admins can create tables")
+	}
+	def := req.GetTable()
+	if strings.HasPrefix(def.GetName(), "@") {
+		return nil, status.Errorf(codes.InvalidArgument, "bad table name: %s", def.GetName())
+	}
+	tdef := &table.TableDef{
+		Name:  def.GetName(),
+		Cols:  def.GetCols(),
+		PKeys: int(def.GetPkeys()),
+		Quota: def.GetQuota(),
+	}
+	for _, typ := range def.GetTypes() {
+		t, ok := colTypes[typ]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "bad column type: %v", typ)
+		}
+		tdef.Types = append(tdef.Types, t)
+	}
+	for _, index := range def.GetIndexes() {
+		tdef.Indexes = append(tdef.Indexes, index.GetCols())
+	}
+	err = sv.s.DB.Update(func(tx *table.DBTX) error {
+		tx.User = user.Name
+		if tx.TableDef(tdef.Name) != nil {
+			return status.Errorf(codes.AlreadyExists, "table exists: %s", tdef.Name)
+		}
+		if err := tx.TableNew(tdef); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return &elkdbv1.CreateTableResponse{}, nil
+}
+
+// ---------------------------------------------------------------------------
+// Row encoding
+// ---------------------------------------------------------------------------
+
+// statusErr turns an error into a gRPC status: OCC conflicts that
+// exhausted their retries are ABORTED, and errors without a status
+// INTERNAL.
+func statusErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, kv.ErrConflict) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// tableDef looks up a table by name. The internal tables, whose names start
+// with "@" and which hold the users among other things, are not served.
+func tableDef(tx *table.DBReader, name string) *table.TableDef {
+	if strings.HasPrefix(name, "@") {
+		return nil
+	}
+	return tx.TableDef(name)
+}
+
+// primaryKey resolves a table and the record of a primary key, which must
+// have every primary-key column.
+func primaryKey(tx *table.DBReader, name string, key []*elkdbv1.Column) (*table.TableDef, table.Record, error) {
+	tdef := tableDef(tx, name)
+	if tdef == nil {
+		return nil, table.Record{}, status.Errorf(codes.NotFound, "table not found: %s", name)
+	}
+	rec, err := record(tdef, key)
+	if err != nil {
+		return nil, rec, err
+	}
+	if len(rec.Cols) != tdef.PKeys {
+		return nil, rec, status.Errorf(codes.InvalidArgument, "primary key of %s has %d columns, got %d", tdef.Name, tdef.PKeys, len(rec.Cols))
+	}
+	// The record of a key has its columns in table order.
+	pk := table.Record{}
+	for _, col := range tdef.Cols[:tdef.PKeys] {
+		v := rec.Get(col)
+		if v == nil {
+			return nil, rec, status.Errorf(codes.InvalidArgument, "missing primary-key column: %s", col)
+		}
+		pk.Cols = append(pk.Cols, col)
+		pk.Vals = append(pk.Vals, *v)
+	}
+	return tdef, pk, nil
+}
+
+// record converts columns of tdef to a record, in the order given.
+func record(tdef *table.TableDef, cols []*elkdbv1.Column) (table.Record, error) {
+	rec := table.Record{}
+	for _, c := range cols {
+		idx := table.ColIndex(tdef, c.GetName())
+		if idx < 0 {
+			return rec, status.Errorf(codes.InvalidArgument, "unknown column: %s", c.GetName())
+		}
+		if rec.Get(c.GetName()) != nil {
+			return rec, status.Errorf(codes.InvalidArgument, "column %s given twice", c.GetName())
+		}
+		val := table.Value{Type: tdef.Types[idx]}
+		v := c.GetValue()
+		switch {
+		case val.Type == table.TypeInt64 && v.GetKind() != nil:
+			kind, ok := v.GetKind().(*elkdbv1.Value_Int64)
+			if !ok {
+				return rec, status.Errorf(codes.InvalidArgument, "column %s: expected int64", c.GetName())
+			}
+			val.I64 = kind.Int64
+		case val.Type == table.TypeFloat64 && v.GetKind() != nil:
+			kind, ok := v.GetKind().(*elkdbv1.Value_Float64)
+			if !ok {
+				return rec, status.Errorf(codes.InvalidArgument, "column %s: expected float64", c.GetName())
+			}
+			val.F64 = kind.Float64
+		case (val.Type == table.TypeBytes || val.Type == table.TypeUUID) && v.GetKind() != nil:
+			kind, ok := v.GetKind().(*elkdbv1.Value_Bytes)
+			if !ok {
+				return rec, status.Errorf(codes.InvalidArgument, "column %s: expected bytes", c.GetName())
+			}
+			if val.Type == table.TypeUUID && len(kind.Bytes) != len(table.UUID{}) {
+				return rec, status.Errorf(codes.InvalidArgument, "column %s: a UUID has 16 bytes, got %d", c.GetName(), len(kind.Bytes))
+			}
+			val.Str = kind.Bytes
+		default:
+			return rec, status.Errorf(codes.InvalidArgument, "column %s: no value", c.GetName())
+		}
+		rec.Cols = append(rec.Cols, c.GetName())
+		rec.Vals = append(rec.Vals, val)
+	}
+	return rec, nil
+}
+
+// row converts rec to a Row with the columns in table order.
+func row(rec table.Record) *elkdbv1.Row {
+	out := &elkdbv1.Row{Columns: make([]*elkdbv1.Column, len(rec.Cols))}
+	for i, col := range rec.Cols {
+		v := rec.Vals[i]
+		val := &elkdbv1.Value{}
+		switch v.Type {
+		case table.TypeInt64:
+			val.Kind = &elkdbv1.Value_Int64{Int64: v.I64}
+		case table.TypeFloat64:
+			val.Kind = &elkdbv1.Value_Float64{Float64: v.F64}
+		default:
+			val.Kind = &elkdbv1.Value_Bytes{Bytes: v.Str}
+		}
+		out.Columns[i] = &elkdbv1.Column{Name: col, Value: val}
+	}
+	return out
+}
@@ -0,0 +1,229 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	elkdbv1 "github.com/MHS-20/ElkDB/proto/elkdb/v1"
+	table "github.com/MHS-20/ElkDB/tables"
+	is "github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *table.DB {
+	db := &table.DB{Path: filepath.Join(t.TempDir(), "grpc.db")}
+	is.NoError(t, db.Open())
+	t.Cleanup(db.Close)
+	return db
+}
+
+// serve starts s on a loopback port and returns its address.
+func serve(t *testing.T, s *Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		is.NoError(t, s.Shutdown(ctx))
+		is.NoError(t, <-done)
+	})
+	return ln.Addr().String()
+}
+
+// dial returns a client of the server at addr; opts are added to the dial
+// options.
+func dial(t *testing.T, addr string, opts ...grpc.DialOption) elkdbv1.ElkDBClient {
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(addr, opts...)
+	is.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return elkdbv1.NewElkDBClient(conn)
+}
+
+func col(name string, v any) *elkdbv1.Column {
+	val := &elkdbv1.Value{}
+	switch v := v.(type) {
+	case int64:
+		val.Kind = &elkdbv1.Value_Int64{Int64: v}
+	case float64:
+		val.Kind = &elkdbv1.Value_Float64{Float64: v}
+	case []byte:
+		val.Kind = &elkdbv1.Value_Bytes{Bytes: v}
+	case string:
+		val.Kind = &elkdbv1.Value_Bytes{Bytes: []byte(v)}
+	}
+	return &elkdbv1.Column{Name: name, Value: val}
+}
+
+func code(err error) codes.Code {
+	return status.Code(err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t, serve(t, &Server{DB: openTestDB(t)}))
+
+	_, err := c.CreateTable(ctx, &elkdbv1.CreateTableRequest{Table: &elkdbv1.TableDef{
+		Name: "users",
+		Cols: []string{"id", "name", "score", "uid"},
+		Types: []elkdbv1.ColumnType{
+			elkdbv1.ColumnType_COLUMN_TYPE_INT64,
+			elkdbv1.ColumnType_COLUMN_TYPE_BYTES,
+			elkdbv1.ColumnType_COLUMN_TYPE_FLOAT64,
+			elkdbv1.ColumnType_COLUMN_TYPE_UUID,
+		},
+		Pkeys:   1,
+		Indexes: []*elkdbv1.Index{{Cols: []string{"name"}}},
+	}})
+	is.NoError(t, err)
+	_, err = c.CreateTable(ctx, &elkdbv1.CreateTableRequest{Table: &elkdbv1.TableDef{
+		Name: "users", Cols: []string{"id"},
+		Types: []elkdbv1.ColumnType{elkdbv1.ColumnType_COLUMN_TYPE_INT64}, Pkeys: 1,
+	}})
+	is.Equal(t, codes.AlreadyExists, code(err))
+
+	uid, err := table.ParseUUID("01234567-89ab-cdef-0123-456789abcdef")
+	is.NoError(t, err)
+	put := func(id int64, name string) bool {
+		resp, err := c.Put(ctx, &elkdbv1.PutRequest{Table: "users", Row: &elkdbv1.Row{Columns: []*elkdbv1.Column{
+			col("id", id), col("name", name), col("score", 1.5), col("uid", uid[:]),
+		}}})
+		is.NoError(t, err)
+		return resp.GetCreated()
+	}
+	for i, name := range []string{"ann", "bob", "cat", "dan"} {
+		is.True(t, put(int64(i+1), name))
+	}
+	is.False(t, put(2, "bea"))
+
+	row, err := c.Get(ctx, &elkdbv1.GetRequest{Table: "users", Key: []*elkdbv1.Column{col("id", int64(2))}})
+	is.NoError(t, err)
+	is.Len(t, row.GetColumns(), 4)
+	is.Equal(t, int64(2), row.GetColumns()[0].GetValue().GetInt64())
+	is.Equal(t, []byte("bea"), row.GetColumns()[1].GetValue().GetBytes())
+	is.Equal(t, 1.5, row.GetColumns()[2].GetValue().GetFloat64())
+	is.Equal(t, uid[:], row.GetColumns()[3].GetValue().GetBytes())
+
+	scan := func(req *elkdbv1.ScanRequest) []string {
+		req.Table = "users"
+		stream, err := c.Scan(ctx, req)
+		is.NoError(t, err)
+		var names []string
+		for {
+			row, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return names
+			}
+			is.NoError(t, err)
+			names = append(names, string(row.GetColumns()[1].GetValue().GetBytes()))
+		}
+	}
+	is.Equal(t, []string{"ann", "bea", "cat", "dan"}, scan(&elkdbv1.ScanRequest{}))
+	is.Equal(t, []string{"cat", "dan"}, scan(&elkdbv1.ScanRequest{
+		Cmp1: elkdbv1.Cmp_CMP_GT, Key1: []*elkdbv1.Column{col("id", int64(2))},
+	}))
+	is.Equal(t, []string{"cat", "bea"}, scan(&elkdbv1.ScanRequest{
+		Cmp1: elkdbv1.Cmp_CMP_LT, Key1: []*elkdbv1.Column{col("name", "dan")},
+		Cmp2: elkdbv1.Cmp_CMP_GE, Key2: []*elkdbv1.Column{col("name", "bea")},
+	}))
+	is.Equal(t, []string{"ann", "bea"}, scan(&elkdbv1.ScanRequest{Limit: 2}))
+
+	_, err = c.Delete(ctx, &elkdbv1.DeleteRequest{Table: "users", Key: []*elkdbv1.Column{col("id", int64(2))}})
+	is.NoError(t, err)
+	_, err = c.Delete(ctx, &elkdbv1.DeleteRequest{Table: "users", Key: []*elkdbv1.Column{col("id", int64(2))}})
+	is.Equal(t, codes.NotFound, code(err))
+	_, err = c.Get(ctx, &elkdbv1.GetRequest{Table: "users", Key: []*elkdbv1.Column{col("id", int64(2))}})
+	is.Equal(t, codes.NotFound, code(err))
+	is.Equal(t, []string{"ann", "cat", "dan"}, scan(&elkdbv1.ScanRequest{}))
+}
+
+func TestBadRequests(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t, serve(t, &Server{DB: openTestDB(t)}))
+	_, err := c.CreateTable(ctx, &elkdbv1.CreateTableRequest{Table: &elkdbv1.TableDef{
+		Name: "kv", Cols: []string{"k", "v"},
+		Types: []elkdbv1.ColumnType{elkdbv1.ColumnType_COLUMN_TYPE_INT64, elkdbv1.ColumnType_COLUMN_TYPE_UUID},
+		Pkeys: 1,
+	}})
+	is.NoError(t, err)
+
+	for _, tc := range []struct {
+		key  []*elkdbv1.Column
+		want codes.Code
+	}{
+		{nil, codes.InvalidArgument},
+		{[]*elkdbv1.Column{col("k", "x")}, codes.InvalidArgument},
+		{[]*elkdbv1.Column{col("nope", int64(1))}, codes.InvalidArgument},
+		{[]*elkdbv1.Column{col("v", make([]byte, 16))}, codes.InvalidArgument},
+		{[]*elkdbv1.Column{{Name: "k"}}, codes.InvalidArgument},
+	} {
+		_, err := c.Get(ctx, &elkdbv1.GetRequest{Table: "kv", Key: tc.key})
+		is.Equal(t, tc.want, code(err), "%v", tc.key)
+	}
+	_, err = c.Put(ctx, &elkdbv1.PutRequest{Table: "kv", Row: &elkdbv1.Row{Columns: []*elkdbv1.Column{
+		col("k", int64(1)), col("v", []byte("short")),
+	}}})
+	is.Equal(t, codes.InvalidArgument, code(err))
+	_, err = c.Get(ctx, &elkdbv1.GetRequest{Table: "missing", Key: []*elkdbv1.Column{col("k", int64(1))}})
+	is.Equal(t, codes.NotFound, code(err))
+
+	// The internal tables are not served.
+	_, err = c.Get(ctx, &elkdbv1.GetRequest{Table: "@meta", Key: []*elkdbv1.Column{col("key", "next_prefix")}})
+	is.Equal(t, codes.NotFound, code(err))
+	_, err = c.CreateTable(ctx, &elkdbv1.CreateTableRequest{Table: &elkdbv1.TableDef{
+		Name: "@x", Cols: []string{"k"},
+		Types: []elkdbv1.ColumnType{elkdbv1.ColumnType_COLUMN_TYPE_INT64}, Pkeys: 1,
+	}})
+	is.Equal(t, codes.InvalidArgument, code(err))
+
+	stream, err := c.Scan(ctx, &elkdbv1.ScanRequest{Table: "kv", Cmp1: elkdbv1.Cmp(42)})
+	is.NoError(t, err)
+	_, err = stream.Recv()
+	is.Equal(t, codes.InvalidArgument, code(err))
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	_, err := db.BootstrapAdmin("root", "secret")
+	is.NoError(t, err)
+	is.NoError(t, db.Update(func(tx *table.DBTX) error {
+		return tx.UserNew("ann", "pw", false)
+	}))
+	addr := serve(t, &Server{DB: db, RequireAuth: true})
+	anon := dial(t, addr)
+	def := &elkdbv1.CreateTableRequest{Table: &elkdbv1.TableDef{
+		Name: "kv", Cols: []string{"k"},
+		Types: []elkdbv1.ColumnType{elkdbv1.ColumnType_COLUMN_TYPE_INT64}, Pkeys: 1,
+	}}
+
+	_, err = anon.CreateTable(ctx, def)
+	is.Equal(t, codes.Unauthenticated, code(err))
+
+	login := func(name, password string) elkdbv1.ElkDBClient {
+		return dial(t, addr, grpc.WithPerRPCCredentials(BasicAuth(name, password, true)))
+	}
+	_, err = login("root", "wrong").CreateTable(ctx, def)
+	is.Equal(t, codes.Unauthenticated, code(err))
+	ann := login("ann", "pw")
+	_, err = ann.CreateTable(ctx, def)
+	is.Equal(t, codes.PermissionDenied, code(err))
+	_, err = login("root", "secret").CreateTable(ctx, def)
+	is.NoError(t, err)
+
+	_, err = ann.Put(ctx, &elkdbv1.PutRequest{Table: "kv", Row: &elkdbv1.Row{Columns: []*elkdbv1.Column{col("k", int64(1))}}})
+	is.NoError(t, err)
+	_, err = ann.Get(ctx, &elkdbv1.GetRequest{Table: "kv", Key: []*elkdbv1.Column{col("k", int64(1))}})
+	is.NoError(t, err)
+}
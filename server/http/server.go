@@ -0,0 +1,515 @@
+// Package http serves ElkDB tables over a JSON REST API. It is built on the
+// tables.DB API, so it needs nothing beyond an open database:
+//
+//	GET    /tables/{table}/{pk...}  fetch one row by primary key
+//	PUT    /tables/{table}/{pk...}  insert or replace a row (JSON object body)
+//	DELETE /tables/{table}/{pk...}  delete a row
+//	POST   /tables/{table}/query    range query (JSON body, see Query)
+//	GET    /users                   list users (see UserRequest)
+//	PUT    /users/{name}            create or update a user
+//	DELETE /users/{name}            delete a user
+//	GET    /metrics                 Prometheus metrics (see package metrics)
+//
+// A primary key made of several columns takes one path segment per column.
+// Rows are JSON objects keyed by column name: int64 and float64 columns are
+// numbers (NaN and the infinities are the strings "NaN", "+Inf" and "-Inf"
+// on output) and bytes columns are strings. Errors are returned as
+// {"Error": "..."}.
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/metrics"
+	table "github.com/MHS-20/ElkDB/tables"
+)
+
+// DefaultLimit is the number of rows a query returns when it sets no Limit.
+const DefaultLimit = 1000
+
+// maxBody bounds the size of a request body.
+const maxBody = 1 << 20
+
+// Server serves DB over HTTP. Use ListenAndServe (or Serve) and Shutdown, or
+// mount Handler in an existing http.Server.
+type Server struct {
+	// Addr is the TCP address to listen on, e.g. ":8080".
+	Addr string
+	// DB is the database to serve; it must be open and outlive the server.
+	DB *table.DB
+	// MaxLimit caps the rows a single query may return (0 = no cap).
+	MaxLimit int
+	// TLSConfig, if set, makes the server serve HTTPS only; see the
+	// tlsconfig package.
+	TLSConfig *tls.Config
+	// RequireAuth makes every request authenticate with HTTP Basic auth
+	// against the users of DB; managing users then needs an admin.
+	RequireAuth bool
+
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+// Handler returns the REST handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tables/{table}/{pk...}", s.handleGet)
+	mux.HandleFunc("PUT /tables/{table}/{pk...}", s.handlePut)
+	mux.HandleFunc("DELETE /tables/{table}/{pk...}", s.handleDelete)
+	mux.HandleFunc("POST /tables/{table}/query", s.handleQuery)
+	mux.HandleFunc("GET /users", s.handleUsers)
+	mux.HandleFunc("PUT /users/{name}", s.handlePutUser)
+	mux.HandleFunc("DELETE /users/{name}", s.handleDeleteUser)
+	mux.Handle("GET /metrics", metrics.Handler(s.DB))
+	if s.RequireAuth {
+		return s.authenticate(mux)
+	}
+	return mux
+}
+
+// ListenAndServe listens on Addr and serves until Shutdown, after which it
+// returns nil.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", s.Addr, err)
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves connections accepted from ln until Shutdown, after which it
+// returns nil. It returns as soon as Shutdown starts: wait for Shutdown to
+// return before closing the DB.
+func (s *Server) Serve(ln net.Listener) error {
+	srv := s.server()
+	var err error
+	if s.TLSConfig != nil {
+		log.Printf("elkdb-rest: listening on %s (HTTPS)", ln.Addr())
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		log.Printf("elkdb-rest: listening on %s", ln.Addr())
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) server() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv == nil {
+		s.srv = &http.Server{Handler: s.Handler(), TLSConfig: s.TLSConfig}
+	}
+	return s.srv
+}
+
+// Shutdown stops accepting requests and waits for those in flight to finish
+// or for ctx to expire. The DB is left open.
+func (s *Server) Shutdown(ctx context.Context) error {
+	srv := s.server()
+	return srv.Shutdown(ctx)
+}
+
+// ---------------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------------
+
+// httpError is an error with the status code it is reported with.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(code int, format string, args ...any) error {
+	return &httpError{code, fmt.Sprintf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		code = he.code
+	case errors.Is(err, kv.ErrConflict):
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, struct{ Error string }{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("elkdb-rest: response write error: %v", err)
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	tx := table.DBReader{}
+	s.DB.BeginRead(&tx)
+	defer s.DB.EndRead(&tx)
+
+	tdef, rec, err := pathKey(&tx, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ok, err := tx.Get(tdef.Name, &rec)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, errorf(http.StatusNotFound, "row not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, row(rec))
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var added bool
+	err = s.write(r, func(tx *table.DBTX) error {
+		tdef, rec, err := pathKey(&tx.DBReader, r)
+		if err != nil {
+			return err
+		}
+		if err := decodeRow(tdef, body, &rec); err != nil {
+			return err
+		}
+		if added, err = tx.Upsert(tdef.Name, rec); err != nil {
+			return errorf(http.StatusBadRequest, "%v", err)
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case added:
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var deleted bool
+	err := s.write(r, func(tx *table.DBTX) error {
+		tdef, rec, err := pathKey(&tx.DBReader, r)
+		if err != nil {
+			return err
+		}
+		deleted, err = tx.Delete(tdef.Name, rec)
+		return err
+	})
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case !deleted:
+		writeError(w, errorf(http.StatusNotFound, "row not found"))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// write runs fn in a write transaction for the request r and commits it
+// with DB.Update, which re-runs it when the commit loses an OCC conflict;
+// writes are idempotent, so that is safe. The transaction's User is the
+// user r authenticated as, for audited tables.
+func (s *Server) write(r *http.Request, fn func(tx *table.DBTX) error) error {
+	user, _ := r.Context().Value(userKey{}).(table.User)
+	return s.DB.Update(func(tx *table.DBTX) error {
+		tx.User = user.Name
+		return fn(tx)
+	})
+}
+
+// Query is the body of a range query. It mirrors tables.Scanner: Key1 and
+// Key2 are objects whose columns, in order, must be a prefix of the primary
+// key or of a secondary index, and Cmp1 / Cmp2 are one of ">=", ">", "<",
+// "<=". An empty query scans the whole table by primary key. To read the
+// next page of a query, send it again with Token set to the Next of its
+// last result.
+type Query struct {
+	Cmp1  string          // defaults to ">="
+	Key1  json.RawMessage // object; column order is significant
+	Cmp2  string          // empty: every row after Key1 in the Cmp1 direction
+	Key2  json.RawMessage
+	Limit int    // 0 = DefaultLimit; capped at Server.MaxLimit
+	Token string // resume after the last page; see tables.Scanner.Page
+}
+
+// QueryResult is the response to a range query. More is set when the range
+// holds rows beyond Limit, and Next is then the token of the next page.
+type QueryResult struct {
+	Rows []json.RawMessage
+	More bool
+	Next string `json:",omitempty"`
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	q := Query{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &q); err != nil {
+			writeError(w, errorf(http.StatusBadRequest, "bad query: %v", err))
+			return
+		}
+	}
+
+	tx := table.DBReader{}
+	s.DB.BeginRead(&tx)
+	defer s.DB.EndRead(&tx)
+	tdef := tableDef(&tx, r.PathValue("table"))
+	if tdef == nil {
+		writeError(w, errorf(http.StatusNotFound, "table not found: %s", r.PathValue("table")))
+		return
+	}
+	sc, err := scanner(tdef, q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := tx.Scan(tdef.Name, &sc); err != nil {
+		writeError(w, errorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if s.MaxLimit > 0 {
+		limit = min(limit, s.MaxLimit)
+	}
+	rows, next, err := sc.Page(limit, q.Token)
+	if err != nil {
+		writeError(w, errorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+	res := QueryResult{Rows: []json.RawMessage{}, More: next != "", Next: next}
+	for _, rec := range rows {
+		res.Rows = append(res.Rows, row(rec))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+var cmps = map[string]int{">=": btree.CmpGE, ">": btree.CmpGT, "<": btree.CmpLT, "<=": btree.CmpLE}
+
+func scanner(tdef *table.TableDef, q Query) (table.Scanner, error) {
+	sc := table.Scanner{Cmp1: btree.CmpGE}
+	if q.Cmp1 != "" {
+		sc.Cmp1 = cmps[q.Cmp1]
+		if sc.Cmp1 == 0 {
+			return sc, errorf(http.StatusBadRequest, "bad Cmp1: %q", q.Cmp1)
+		}
+	}
+	if q.Cmp2 != "" {
+		sc.Cmp2 = cmps[q.Cmp2]
+		if sc.Cmp2 == 0 {
+			return sc, errorf(http.StatusBadRequest, "bad Cmp2: %q", q.Cmp2)
+		}
+	}
+	if len(q.Key1) > 0 {
+		if err := decodeRow(tdef, q.Key1, &sc.Key1); err != nil {
+			return sc, err
+		}
+	}
+	if len(q.Key2) > 0 {
+		if err := decodeRow(tdef, q.Key2, &sc.Key2); err != nil {
+			return sc, err
+		}
+	}
+	return sc, nil
+}
+
+// ---------------------------------------------------------------------------
+// Row encoding
+// ---------------------------------------------------------------------------
+
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "read body: %v", err)
+	}
+	return body, nil
+}
+
+// pathKey resolves the table and primary key named by the request path.
+// tableDef looks up a table by name. The internal tables, whose names start
+// with "@" and which hold the users among other things, are not served.
+func tableDef(tx *table.DBReader, name string) *table.TableDef {
+	if strings.HasPrefix(name, "@") {
+		return nil
+	}
+	return tx.TableDef(name)
+}
+
+func pathKey(tx *table.DBReader, r *http.Request) (*table.TableDef, table.Record, error) {
+	rec := table.Record{}
+	tdef := tableDef(tx, r.PathValue("table"))
+	if tdef == nil {
+		return nil, rec, errorf(http.StatusNotFound, "table not found: %s", r.PathValue("table"))
+	}
+	// Split the raw path so that a key may contain an escaped slash.
+	parts := strings.Split(r.URL.EscapedPath(), "/")[3:] // "", "tables", table
+	if len(parts) != tdef.PKeys {
+		return nil, rec, errorf(http.StatusBadRequest, "primary key of %s has %d columns, got %d", tdef.Name, tdef.PKeys, len(parts))
+	}
+	for i, part := range parts {
+		col := tdef.Cols[i]
+		part, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, rec, errorf(http.StatusBadRequest, "column %s: %v", col, err)
+		}
+		switch tdef.Types[i] {
+		case table.TypeInt64:
+			v, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, rec, errorf(http.StatusBadRequest, "column %s: bad int64 %q", col, part)
+			}
+			rec.AddInt64(col, v)
+		case table.TypeFloat64:
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, rec, errorf(http.StatusBadRequest, "column %s: bad float64 %q", col, part)
+			}
+			rec.AddFloat64(col, v)
+		case table.TypeUUID:
+			id, err := table.ParseUUID(part)
+			if err != nil {
+				return nil, rec, errorf(http.StatusBadRequest, "column %s: %v", col, err)
+			}
+			rec.AddUUID(col, id)
+		default:
+			rec.AddStr(col, []byte(part))
+		}
+	}
+	return tdef, rec, nil
+}
+
+// decodeRow appends the columns of the JSON object data to rec in the order
+// they appear. A column already in rec (the primary key from the path) must
+// not be given a different value.
+func decodeRow(tdef *table.TableDef, data []byte, rec *table.Record) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errorf(http.StatusBadRequest, "expected a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return errorf(http.StatusBadRequest, "bad JSON object: %v", err)
+		}
+		col := tok.(string)
+		idx := table.ColIndex(tdef, col)
+		if idx < 0 {
+			return errorf(http.StatusBadRequest, "unknown column: %s", col)
+		}
+		var raw any
+		if err := dec.Decode(&raw); err != nil {
+			return errorf(http.StatusBadRequest, "column %s: %v", col, err)
+		}
+		val := table.Value{Type: tdef.Types[idx]}
+		switch v := raw.(type) {
+		case json.Number:
+			switch val.Type {
+			case table.TypeInt64:
+				if val.I64, err = v.Int64(); err != nil {
+					return errorf(http.StatusBadRequest, "column %s: bad int64 %s", col, v)
+				}
+			case table.TypeFloat64:
+				if val.F64, err = v.Float64(); err != nil {
+					return errorf(http.StatusBadRequest, "column %s: bad float64 %s", col, v)
+				}
+			default:
+				return errorf(http.StatusBadRequest, "column %s: expected a string", col)
+			}
+		case string:
+			switch val.Type {
+			case table.TypeBytes:
+				val.Str = []byte(v)
+			case table.TypeUUID:
+				id, err := table.ParseUUID(v)
+				if err != nil {
+					return errorf(http.StatusBadRequest, "column %s: %v", col, err)
+				}
+				val.Str = id[:]
+			default:
+				return errorf(http.StatusBadRequest, "column %s: expected a number", col)
+			}
+		default:
+			return errorf(http.StatusBadRequest, "column %s: unsupported JSON value", col)
+		}
+		if old := rec.Get(col); old != nil {
+			if old.I64 != val.I64 || old.F64 != val.F64 || !bytes.Equal(old.Str, val.Str) {
+				return errorf(http.StatusBadRequest, "column %s does not match the path", col)
+			}
+			continue
+		}
+		rec.Cols = append(rec.Cols, col)
+		rec.Vals = append(rec.Vals, val)
+	}
+	return nil
+}
+
+// row encodes rec as a JSON object with the columns in table order.
+func row(rec table.Record) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, col := range rec.Cols {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(col)
+		buf.Write(name)
+		buf.WriteByte(':')
+		v := rec.Vals[i]
+		switch {
+		case v.Type == table.TypeInt64:
+			buf.WriteString(strconv.FormatInt(v.I64, 10))
+		case v.Type == table.TypeFloat64 && !math.IsNaN(v.F64) && !math.IsInf(v.F64, 0):
+			buf.WriteString(strconv.FormatFloat(v.F64, 'g', -1, 64))
+		case v.Type == table.TypeFloat64:
+			str, _ := json.Marshal(strconv.FormatFloat(v.F64, 'g', -1, 64))
+			buf.Write(str)
+		case v.Type == table.TypeUUID:
+			str, _ := json.Marshal(table.UUID(v.Str).String())
+			buf.Write(str)
+		default:
+			str, _ := json.Marshal(string(v.Str))
+			buf.Write(str)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
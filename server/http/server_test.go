@@ -0,0 +1,254 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	table "github.com/MHS-20/ElkDB/tables"
+	is "github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *table.DB {
+	db := &table.DB{Path: filepath.Join(t.TempDir(), "rest.db")}
+	is.NoError(t, db.Open())
+	t.Cleanup(db.Close)
+
+	tx := table.DBTX{}
+	db.Begin(&tx)
+	is.NoError(t, tx.TableNew(&table.TableDef{
+		Name:    "users",
+		Cols:    []string{"id", "name", "age"},
+		Types:   []uint32{table.TypeInt64, table.TypeBytes, table.TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"age"}},
+	}))
+	is.NoError(t, tx.TableNew(&table.TableDef{
+		Name:  "pairs",
+		Cols:  []string{"a", "b", "v"},
+		Types: []uint32{table.TypeBytes, table.TypeInt64, table.TypeBytes},
+		PKeys: 2,
+	}))
+	is.NoError(t, db.Commit(&tx))
+	return db
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	is.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	is.NoError(t, err)
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	is.NoError(t, err)
+	return resp.StatusCode, strings.TrimSpace(string(out))
+}
+
+func TestRowLifecycle(t *testing.T) {
+	srv := httptest.NewServer((&Server{DB: openTestDB(t)}).Handler())
+	defer srv.Close()
+
+	code, _ := do(t, srv, "PUT", "/tables/users/7", `{"name":"ann","age":30}`)
+	is.Equal(t, http.StatusCreated, code)
+	code, body := do(t, srv, "GET", "/tables/users/7", "")
+	is.Equal(t, http.StatusOK, code)
+	is.Equal(t, `{"id":7,"name":"ann","age":30}`, body)
+
+	// Replacing a row; the body may repeat the key.
+	code, _ = do(t, srv, "PUT", "/tables/users/7", `{"id":7,"name":"bob","age":31}`)
+	is.Equal(t, http.StatusNoContent, code)
+	_, body = do(t, srv, "GET", "/tables/users/7", "")
+	is.Equal(t, `{"id":7,"name":"bob","age":31}`, body)
+
+	// Composite primary keys take one segment per column.
+	code, _ = do(t, srv, "PUT", "/tables/pairs/x%2Fy/-2", `{"v":"z"}`)
+	is.Equal(t, http.StatusCreated, code)
+	_, body = do(t, srv, "GET", "/tables/pairs/x%2Fy/-2", "")
+	is.Equal(t, `{"a":"x/y","b":-2,"v":"z"}`, body)
+
+	code, _ = do(t, srv, "DELETE", "/tables/users/7", "")
+	is.Equal(t, http.StatusNoContent, code)
+	code, body = do(t, srv, "GET", "/tables/users/7", "")
+	is.Equal(t, http.StatusNotFound, code)
+	is.Equal(t, `{"Error":"row not found"}`, body)
+	code, _ = do(t, srv, "DELETE", "/tables/users/7", "")
+	is.Equal(t, http.StatusNotFound, code)
+}
+
+func TestBadRequests(t *testing.T) {
+	srv := httptest.NewServer((&Server{DB: openTestDB(t)}).Handler())
+	defer srv.Close()
+
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+		msg                string
+	}{
+		{"GET", "/tables/nope/1", "", http.StatusNotFound, "table not found"},
+		{"GET", "/tables/users/x", "", http.StatusBadRequest, "bad int64"},
+		{"GET", "/tables/pairs/x", "", http.StatusBadRequest, "has 2 columns"},
+		{"PUT", "/tables/users/1", `[1]`, http.StatusBadRequest, "expected a JSON object"},
+		{"PUT", "/tables/users/1", `{"zip":1}`, http.StatusBadRequest, "unknown column"},
+		{"PUT", "/tables/users/1", `{"name":5,"age":1}`, http.StatusBadRequest, "expected a string"},
+		{"PUT", "/tables/users/1", `{"id":2,"name":"a","age":1}`, http.StatusBadRequest, "does not match the path"},
+		{"PUT", "/tables/users/1", `{"name":"a"}`, http.StatusBadRequest, "missing column"},
+		{"POST", "/tables/users/query", `{"Cmp1":"=="}`, http.StatusBadRequest, "bad Cmp1"},
+		{"POST", "/tables/users/query", `{"Key1":{"name":"a"}}`, http.StatusBadRequest, "no index"},
+	} {
+		code, body := do(t, srv, c.method, c.path, c.body)
+		is.Equal(t, c.code, code, "%s %s", c.method, c.path)
+		is.Contains(t, body, c.msg, "%s %s", c.method, c.path)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	srv := httptest.NewServer((&Server{DB: openTestDB(t), MaxLimit: 50}).Handler())
+	defer srv.Close()
+	for i := 0; i < 100; i++ {
+		code, _ := do(t, srv, "PUT", "/tables/users/"+itoa(i), `{"name":"u","age":`+itoa(i%10)+`}`)
+		is.Equal(t, http.StatusCreated, code)
+	}
+
+	query := func(body string) QueryResult {
+		code, out := do(t, srv, "POST", "/tables/users/query", body)
+		is.Equal(t, http.StatusOK, code, out)
+		res := QueryResult{}
+		is.NoError(t, json.Unmarshal([]byte(out), &res))
+		return res
+	}
+
+	// Primary-key range.
+	res := query(`{"Cmp1":">","Key1":{"id":10},"Cmp2":"<=","Key2":{"id":13}}`)
+	is.Len(t, res.Rows, 3)
+	is.JSONEq(t, `{"id":11,"name":"u","age":1}`, string(res.Rows[0]))
+	is.False(t, res.More)
+
+	// Secondary index, descending.
+	res = query(`{"Cmp1":"<=","Key1":{"age":3},"Cmp2":">=","Key2":{"age":3}}`)
+	is.Len(t, res.Rows, 10)
+	is.JSONEq(t, `{"id":93,"name":"u","age":3}`, string(res.Rows[0]))
+
+	// Full scans are limited.
+	res = query(``)
+	is.Len(t, res.Rows, 50)
+	is.True(t, res.More)
+	res = query(`{"Limit":20}`)
+	is.Len(t, res.Rows, 20)
+	is.True(t, res.More)
+	res = query(`{"Key1":{"id":90}}`)
+	is.Len(t, res.Rows, 10)
+	is.False(t, res.More)
+	is.Empty(t, res.Next)
+
+	// Pages resume after the last row.
+	res = query(`{"Key1":{"id":30},"Limit":25}`)
+	is.True(t, res.More)
+	is.JSONEq(t, `{"id":54,"name":"u","age":4}`, string(res.Rows[24]))
+	res = query(`{"Key1":{"id":30},"Limit":25,"Token":"` + res.Next + `"}`)
+	is.Len(t, res.Rows, 25)
+	is.JSONEq(t, `{"id":55,"name":"u","age":5}`, string(res.Rows[0]))
+	code, out := do(t, srv, "POST", "/tables/users/query", `{"Key1":{"id":30},"Token":"x"}`)
+	is.Equal(t, http.StatusBadRequest, code, out)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	db := openTestDB(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(t, err)
+	s := &Server{DB: db}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	url := "http://" + ln.Addr().String() + "/tables/users/1"
+	req, err := http.NewRequest("PUT", url, strings.NewReader(`{"name":"a","age":1}`))
+	is.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	is.NoError(t, err)
+	resp.Body.Close()
+	is.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	is.NoError(t, s.Shutdown(ctx))
+	is.NoError(t, <-served)
+	_, err = http.Get(url)
+	is.Error(t, err)
+
+	// The DB stays usable after the server stops.
+	r := table.DBReader{}
+	db.BeginRead(&r)
+	defer db.EndRead(&r)
+	rec := (&table.Record{}).AddInt64("id", 1)
+	ok, err := r.Get("users", rec)
+	is.NoError(t, err)
+	is.True(t, ok)
+}
+
+func TestTLS(t *testing.T) {
+	// Borrow httptest's certificate for 127.0.0.1 and a client trusting it.
+	certs := httptest.NewUnstartedServer(nil)
+	certs.StartTLS()
+	cfg, client := certs.TLS.Clone(), certs.Client()
+	certs.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(t, err)
+	s := &Server{DB: openTestDB(t), TLSConfig: cfg}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+	defer func() {
+		is.NoError(t, s.Shutdown(context.Background()))
+		is.NoError(t, <-served)
+	}()
+
+	url := "https://" + ln.Addr().String() + "/tables/users/1"
+	req, err := http.NewRequest("PUT", url, strings.NewReader(`{"name":"a","age":1}`))
+	is.NoError(t, err)
+	resp, err := client.Do(req)
+	is.NoError(t, err)
+	resp.Body.Close()
+	is.Equal(t, http.StatusCreated, resp.StatusCode)
+	is.NotNil(t, resp.TLS)
+
+	// Plain HTTP is refused.
+	resp, err = http.Get("http://" + ln.Addr().String() + "/tables/users/1")
+	is.NoError(t, err)
+	resp.Body.Close()
+	is.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func itoa(i int) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+func TestMetrics(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.BootstrapAdmin("admin", "secret")
+	is.NoError(t, err)
+	srv := httptest.NewServer((&Server{DB: db, RequireAuth: true}).Handler())
+	defer srv.Close()
+
+	code, _ := do(t, srv, "GET", "/metrics", "")
+	is.Equal(t, http.StatusUnauthorized, code)
+
+	req, err := http.NewRequest("GET", srv.URL+"/metrics", nil)
+	is.NoError(t, err)
+	req.SetBasicAuth("admin", "secret")
+	resp, err := srv.Client().Do(req)
+	is.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	is.NoError(t, err)
+	is.Equal(t, http.StatusOK, resp.StatusCode)
+	is.Contains(t, resp.Header.Get("Content-Type"), "version=0.0.4")
+	is.Contains(t, string(body), "# TYPE elkdb_kv_flush_seconds histogram\n")
+	is.Contains(t, string(body), "elkdb_table_gets_total 0\n")
+}
@@ -0,0 +1,169 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MHS-20/ElkDB/kv"
+
+	table "github.com/MHS-20/ElkDB/tables"
+)
+
+// ---------------------------------------------------------------------------
+// Authentication and user management
+// ---------------------------------------------------------------------------
+
+type userKey struct{}
+
+// authenticate checks the Basic credentials of every request against the
+// users of the database before passing it on to next.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="elkdb", charset="UTF-8"`)
+			writeError(w, errorf(http.StatusUnauthorized, "authentication required"))
+			return
+		}
+		user, err := s.DB.Authenticate(name, password)
+		if errors.Is(err, table.ErrAuth) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="elkdb", charset="UTF-8"`)
+			writeError(w, errorf(http.StatusUnauthorized, "%v", err))
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// checkAdmin fails unless the request was made by an admin, or
+// authentication is off.
+func (s *Server) checkAdmin(r *http.Request) error {
+	if !s.RequireAuth {
+		return nil
+	}
+	if user, _ := r.Context().Value(userKey{}).(table.User); !user.Admin {
+		return errorf(http.StatusForbidden, "only admins can manage users")
+	}
+	return nil
+}
+
+// UserRequest is the body of PUT /users/{name}. Creating a user needs a
+// Password; updating one changes only the fields that are set.
+type UserRequest struct {
+	Password string
+	Admin    *bool
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkAdmin(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	tx := table.DBReader{}
+	s.DB.BeginRead(&tx)
+	defer s.DB.EndRead(&tx)
+	users, err := tx.Users()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, append([]table.User{}, users...))
+}
+
+func (s *Server) handlePutUser(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkAdmin(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req := UserRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, errorf(http.StatusBadRequest, "bad user: %v", err))
+		return
+	}
+
+	name := r.PathValue("name")
+	var created bool
+	err = s.write(r, func(tx *table.DBTX) error {
+		users, err := tx.Users()
+		if err != nil {
+			return err
+		}
+		created = !hasUser(users, name)
+		if created {
+			if req.Password == "" {
+				return errorf(http.StatusBadRequest, "a new user needs a Password")
+			}
+			err := tx.UserNew(name, req.Password, req.Admin != nil && *req.Admin)
+			return badRequest(err)
+		}
+		if req.Password != "" {
+			if err := tx.UserSetPassword(name, req.Password); err != nil {
+				return badRequest(err)
+			}
+		}
+		if req.Admin != nil {
+			return badRequest(tx.UserSetAdmin(name, *req.Admin))
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case created:
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkAdmin(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	name := r.PathValue("name")
+	err := s.write(r, func(tx *table.DBTX) error {
+		users, err := tx.Users()
+		if err != nil {
+			return err
+		}
+		if !hasUser(users, name) {
+			return errorf(http.StatusNotFound, "user not found: %s", name)
+		}
+		return badRequest(tx.UserDelete(name))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func hasUser(users []table.User, name string) bool {
+	for _, u := range users {
+		if u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// badRequest reports the errors of the user API, which are all caused by
+// the request, with status 400; OCC conflicts keep their meaning.
+func badRequest(err error) error {
+	if err == nil || errors.Is(err, kv.ErrConflict) {
+		return err
+	}
+	return errorf(http.StatusBadRequest, "%v", err)
+}
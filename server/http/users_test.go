@@ -0,0 +1,79 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.BootstrapAdmin("admin", "secret")
+	is.NoError(t, err)
+	srv := httptest.NewServer((&Server{DB: db, RequireAuth: true}).Handler())
+	defer srv.Close()
+
+	as := func(user, password, method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		is.NoError(t, err)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := srv.Client().Do(req)
+		is.NoError(t, err)
+		defer resp.Body.Close()
+		out, err := io.ReadAll(resp.Body)
+		is.NoError(t, err)
+		if resp.StatusCode == http.StatusUnauthorized {
+			is.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+		}
+		return resp.StatusCode, strings.TrimSpace(string(out))
+	}
+
+	code, _ := as("", "", "GET", "/tables/users/1", "")
+	is.Equal(t, http.StatusUnauthorized, code)
+	code, _ = as("admin", "wrong", "GET", "/tables/users/1", "")
+	is.Equal(t, http.StatusUnauthorized, code)
+	code, _ = as("admin", "secret", "PUT", "/tables/users/1", `{"name":"a","age":1}`)
+	is.Equal(t, http.StatusCreated, code)
+
+	// Admins manage users.
+	code, _ = as("admin", "secret", "PUT", "/users/bob", `{"Password":"pw"}`)
+	is.Equal(t, http.StatusCreated, code)
+	code, _ = as("admin", "secret", "PUT", "/users/eve", `{}`)
+	is.Equal(t, http.StatusBadRequest, code)
+	code, body := as("admin", "secret", "GET", "/users", "")
+	is.Equal(t, http.StatusOK, code)
+	is.JSONEq(t, `[{"Name":"admin","Admin":true},{"Name":"bob","Admin":false}]`, body)
+
+	// Other users can use the tables but not manage users.
+	code, body = as("bob", "pw", "GET", "/tables/users/1", "")
+	is.Equal(t, http.StatusOK, code)
+	is.JSONEq(t, `{"id":1,"name":"a","age":1}`, body)
+	code, _ = as("bob", "pw", "GET", "/users", "")
+	is.Equal(t, http.StatusForbidden, code)
+	code, _ = as("bob", "pw", "PUT", "/users/bob", `{"Admin":true}`)
+	is.Equal(t, http.StatusForbidden, code)
+
+	code, _ = as("admin", "secret", "PUT", "/users/bob", `{"Password":"pw2","Admin":true}`)
+	is.Equal(t, http.StatusNoContent, code)
+	code, _ = as("bob", "pw", "GET", "/users", "")
+	is.Equal(t, http.StatusUnauthorized, code)
+	code, _ = as("bob", "pw2", "DELETE", "/users/admin", "")
+	is.Equal(t, http.StatusNoContent, code)
+	code, body = as("bob", "pw2", "DELETE", "/users/bob", "")
+	is.Equal(t, http.StatusBadRequest, code)
+	is.Contains(t, body, "last admin")
+	code, _ = as("bob", "pw2", "DELETE", "/users/nobody", "")
+	is.Equal(t, http.StatusNotFound, code)
+
+	// The user table itself is not reachable.
+	code, _ = as("bob", "pw2", "GET", "/tables/@user/bob", "")
+	is.Equal(t, http.StatusNotFound, code)
+	code, _ = as("bob", "pw2", "POST", "/tables/@user/query", "")
+	is.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,128 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// RESP2 wire format
+// ---------------------------------------------------------------------------
+
+// Limits on a single request, so that a bad client cannot make the server
+// allocate without bound.
+const (
+	maxArgs     = 1 << 16
+	maxBulk     = 1 << 20
+	maxInline   = 64 << 10
+	maxLineSize = 64 << 10
+)
+
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads one request: an array of bulk strings as sent by Redis
+// clients, or an inline command (space-separated words on one line) as typed
+// into telnet. It returns nil args for an empty inline line.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r, maxInline)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: bad array length", errProtocol)
+	}
+	args := make([][]byte, 0, max(n, 0))
+	for range n {
+		line, err := readLine(r, maxLineSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", errProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, fmt.Errorf("%w: bad bulk length", errProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by "\r\n" (or a bare "\n") of at most
+// limit bytes and returns it without the terminator.
+func readLine(r *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return nil, fmt.Errorf("%w: line too long", errProtocol)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, nil
+}
+
+// writer encodes replies. Errors are sticky and reported by flush.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+// error writes an error reply; line breaks in msg are replaced by spaces.
+func (w writer) error(msg string) {
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.w.WriteString("-" + msg + "\r\n")
+}
+
+func (w writer) int(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// bulk writes b, or the null bulk string if b is nil.
+func (w writer) bulk(b []byte) {
+	if b == nil {
+		w.w.WriteString("$-1\r\n")
+		return
+	}
+	w.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+// array writes the header of an array of n elements, which the caller
+// writes next.
+func (w writer) array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,812 @@
+// Package resp serves ElkDB over the Redis protocol (RESP2), so that
+// existing Redis clients can use it as a persistent store.
+//
+// Strings live in a table of (key, val) rows and hashes in a table of
+// (key, field, val) rows, both created on first use; see Server.StringTable
+// and Server.HashTable. Every command runs in its own transaction. The
+// supported commands are
+//
+//	PING ECHO QUIT SELECT COMMAND AUTH
+//	GET SET DEL EXISTS SCAN
+//	HGET HSET HDEL HGETALL
+//
+// SET accepts NX and XX but not expiry options.
+package resp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MHS-20/ElkDB/btree"
+	table "github.com/MHS-20/ElkDB/tables"
+)
+
+// Default table names.
+const (
+	DefaultStringTable = "redis_strings"
+	DefaultHashTable   = "redis_hashes"
+)
+
+// Server accepts RESP2 connections for DB.
+type Server struct {
+	// Addr is the TCP address to listen on, e.g. ":6379".
+	Addr string
+	// DB is the database to serve; it must be open and outlive the server.
+	DB *table.DB
+	// StringTable and HashTable name the tables that hold strings and
+	// hashes (empty: DefaultStringTable, DefaultHashTable).
+	StringTable string
+	HashTable   string
+	// TLSConfig, if set, makes the server accept TLS connections only, as
+	// redis-cli --tls expects; see the tlsconfig package.
+	TLSConfig *tls.Config
+	// RequireAuth makes clients send AUTH with the name and password of a
+	// user of DB before any other command but PING and QUIT.
+	RequireAuth bool
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// ListenAndServe listens on Addr and serves until Close.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", s.Addr, err)
+	}
+	return s.Serve(ln)
+}
+
+// Serve creates the tables if needed, then serves connections accepted from
+// ln until Close, after which it returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.ensureTables(); err != nil {
+		ln.Close()
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	log.Printf("elkdb-resp: listening on %s (tls: %t)", ln.Addr(), s.TLSConfig != nil)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		if s.conns == nil {
+			s.conns = map[net.Conn]struct{}{}
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// their command in progress to finish. The DB is left open.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) stringTable() string { return orDefault(s.StringTable, DefaultStringTable) }
+func (s *Server) hashTable() string   { return orDefault(s.HashTable, DefaultHashTable) }
+
+func orDefault(name, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
+
+// ensureTables creates the string and hash tables, or checks that existing
+// ones have the expected columns.
+func (s *Server) ensureTables() error {
+	defs := []*table.TableDef{{
+		Name:  s.stringTable(),
+		Cols:  []string{"key", "val"},
+		Types: []uint32{table.TypeBytes, table.TypeBytes},
+		PKeys: 1,
+	}, {
+		Name:  s.hashTable(),
+		Cols:  []string{"key", "field", "val"},
+		Types: []uint32{table.TypeBytes, table.TypeBytes, table.TypeBytes},
+		PKeys: 2,
+	}}
+	return s.DB.Update(func(tx *table.DBTX) error {
+		for _, want := range defs {
+			got := tx.TableDef(want.Name)
+			if got == nil {
+				if err := tx.TableNew(want); err != nil {
+					return err
+				}
+				continue
+			}
+			if !slices.Equal(got.Cols, want.Cols) || !slices.Equal(got.Types, want.Types) || got.PKeys != want.PKeys {
+				return fmt.Errorf("table %s exists with different columns", want.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// read runs fn on a snapshot.
+func (s *Server) read(fn func(tx *table.DBReader) error) error {
+	tx := table.DBReader{}
+	s.DB.BeginRead(&tx)
+	defer s.DB.EndRead(&tx)
+	return fn(&tx)
+}
+
+// ---------------------------------------------------------------------------
+// Connections
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	authed := !s.RequireAuth
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.error("ERR " + err.Error())
+				w.flush()
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("elkdb-resp: [%s] read error: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.exec(w, args, &authed)
+		// Pipelined requests are answered together.
+		if quit || r.Buffered() == 0 {
+			if err := w.flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// errWrongType is the Redis error for a command applied to a key holding
+// the other kind of value.
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+type command struct {
+	arity int // number of arguments including the name; -n means at least n
+	fn    func(s *Server, w writer, args [][]byte) error
+}
+
+var commands = map[string]command{
+	"PING":    {-1, cmdPing},
+	"ECHO":    {2, cmdEcho},
+	"SELECT":  {2, cmdSelect},
+	"COMMAND": {-1, cmdCommand},
+	"GET":     {2, cmdGet},
+	"SET":     {-3, cmdSet},
+	"DEL":     {-2, cmdDel},
+	"EXISTS":  {-2, cmdExists},
+	"SCAN":    {-2, cmdScan},
+	"HGET":    {3, cmdHGet},
+	"HSET":    {-4, cmdHSet},
+	"HDEL":    {-3, cmdHDel},
+	"HGETALL": {2, cmdHGetAll},
+}
+
+// exec runs one command and writes its reply. authed is the authentication
+// state of the connection, which AUTH updates. It reports whether the
+// connection should be closed.
+func (s *Server) exec(w writer, args [][]byte, authed *bool) bool {
+	name := strings.ToUpper(string(args[0]))
+	switch {
+	case name == "QUIT":
+		w.simple("OK")
+		return true
+	case name == "AUTH":
+		s.auth(w, args, authed)
+		return false
+	case !*authed && name != "PING":
+		w.error("NOAUTH Authentication required.")
+		return false
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+	if err := cmd.fn(s, w, args); err != nil {
+		msg := err.Error()
+		if !errors.Is(err, errWrongType) && !strings.HasPrefix(msg, "ERR ") {
+			msg = "ERR " + msg
+		}
+		w.error(msg)
+	}
+	return false
+}
+
+// auth runs AUTH [username] password. Without a username, as sent by
+// clients configured with a password only, the user is "default".
+func (s *Server) auth(w writer, args [][]byte, authed *bool) {
+	if len(args) != 2 && len(args) != 3 {
+		w.error("ERR wrong number of arguments for 'auth' command")
+		return
+	}
+	if !s.RequireAuth {
+		w.error("ERR AUTH called without authentication enabled on the server")
+		return
+	}
+	name, password := "default", string(args[len(args)-1])
+	if len(args) == 3 {
+		name = string(args[1])
+	}
+	_, err := s.DB.Authenticate(name, password)
+	switch {
+	case errors.Is(err, table.ErrAuth):
+		w.error("WRONGPASS invalid username-password pair")
+	case err != nil:
+		w.error("ERR " + err.Error())
+	default:
+		*authed = true
+		w.simple("OK")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Commands
+// ---------------------------------------------------------------------------
+
+func cmdPing(s *Server, w writer, args [][]byte) error {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		return fmt.Errorf("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func cmdEcho(s *Server, w writer, args [][]byte) error {
+	w.bulk(args[1])
+	return nil
+}
+
+func cmdSelect(s *Server, w writer, args [][]byte) error {
+	if string(args[1]) != "0" {
+		return fmt.Errorf("ERR DB index is out of range")
+	}
+	w.simple("OK")
+	return nil
+}
+
+// cmdCommand answers the introspection clients send on connect with an
+// empty list.
+func cmdCommand(s *Server, w writer, args [][]byte) error {
+	w.array(0)
+	return nil
+}
+
+func cmdGet(s *Server, w writer, args [][]byte) error {
+	var val []byte
+	err := s.read(func(tx *table.DBReader) error {
+		v, ok, err := s.getString(tx, args[1])
+		if err != nil || ok {
+			val = v
+			return err
+		}
+		isHash, err := s.hashExists(tx, args[1])
+		if err == nil && isHash {
+			err = errWrongType
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.bulk(val)
+	return nil
+}
+
+func cmdSet(s *Server, w writer, args [][]byte) error {
+	nx, xx := false, false
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(string(opt)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX", "EXAT", "PXAT", "KEEPTTL":
+			return fmt.Errorf("ERR expiry is not supported")
+		default:
+			return fmt.Errorf("ERR syntax error")
+		}
+	}
+	if nx && xx {
+		return fmt.Errorf("ERR syntax error")
+	}
+
+	applied := false
+	err := s.DB.Update(func(tx *table.DBTX) error {
+		applied = false
+		if nx || xx {
+			_, isStr, err := s.getString(&tx.DBReader, args[1])
+			if err != nil {
+				return err
+			}
+			isHash, err := s.hashExists(&tx.DBReader, args[1])
+			if err != nil {
+				return err
+			}
+			if exists := isStr || isHash; (nx && exists) || (xx && !exists) {
+				return nil
+			}
+		}
+		// SET replaces a value of any kind.
+		if _, err := s.delHash(tx, args[1]); err != nil {
+			return err
+		}
+		rec := (&table.Record{}).AddStr("key", args[1]).AddStr("val", args[2])
+		if _, err := tx.Upsert(s.stringTable(), *rec); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if applied {
+		w.simple("OK")
+	} else {
+		w.bulk(nil)
+	}
+	return nil
+}
+
+func cmdDel(s *Server, w writer, args [][]byte) error {
+	n := int64(0)
+	err := s.DB.Update(func(tx *table.DBTX) error {
+		n = 0
+		for _, key := range args[1:] {
+			deleted, err := tx.Delete(s.stringTable(), *(&table.Record{}).AddStr("key", key))
+			if err != nil {
+				return err
+			}
+			fields, err := s.delHash(tx, key)
+			if err != nil {
+				return err
+			}
+			if deleted || fields > 0 {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.int(n)
+	return nil
+}
+
+func cmdExists(s *Server, w writer, args [][]byte) error {
+	n := int64(0)
+	err := s.read(func(tx *table.DBReader) error {
+		for _, key := range args[1:] {
+			_, isStr, err := s.getString(tx, key)
+			if err != nil {
+				return err
+			}
+			isHash, err := s.hashExists(tx, key)
+			if err != nil {
+				return err
+			}
+			if isStr || isHash {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.int(n)
+	return nil
+}
+
+// cmdScan implements SCAN with the cursor being the number of keys already
+// visited: string keys in order, then hash keys in order. Like in Redis,
+// COUNT bounds the keys visited per call, not the keys returned; resuming
+// costs a walk over the keys before the cursor.
+func cmdScan(s *Server, w writer, args [][]byte) error {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("ERR invalid cursor")
+	}
+	var pattern []byte
+	count := uint64(10)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return fmt.Errorf("ERR syntax error")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil || count == 0 {
+				return fmt.Errorf("ERR syntax error")
+			}
+		default:
+			return fmt.Errorf("ERR syntax error")
+		}
+	}
+
+	var keys [][]byte
+	pos := uint64(0)
+	visit := func(key []byte) bool {
+		if pos >= cursor {
+			if pattern == nil || match(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		pos++
+		return pos < cursor+count
+	}
+	done := false
+	err = s.read(func(tx *table.DBReader) error {
+		more, err := scanKeys(tx, s.stringTable(), visit)
+		if err != nil || more || pos >= cursor+count {
+			return err // the hash keys are left to the next call
+		}
+		more, err = scanKeys(tx, s.hashTable(), visit)
+		done = !more
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	next := pos
+	if done {
+		next = 0
+	}
+	w.array(2)
+	w.bulk([]byte(strconv.FormatUint(next, 10)))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk(key)
+	}
+	return nil
+}
+
+// scanKeys calls fn for each distinct key of tbl in order until fn returns
+// false. more reports whether it stopped before the end of the table with
+// keys left to visit.
+func scanKeys(tx *table.DBReader, tbl string, fn func(key []byte) bool) (more bool, err error) {
+	sc := table.Scanner{Cmp1: btree.CmpGE}
+	if err := tx.Scan(tbl, &sc); err != nil {
+		return false, err
+	}
+	var last []byte
+	stopped := false
+	for ; sc.Valid(); sc.Next() {
+		var rec table.Record
+		sc.Deref(&rec)
+		key := rec.Get("key").Str
+		if last != nil && string(key) == string(last) {
+			continue
+		}
+		if stopped {
+			return true, nil
+		}
+		last = key
+		stopped = !fn(key)
+	}
+	return false, nil
+}
+
+func cmdHGet(s *Server, w writer, args [][]byte) error {
+	var val []byte
+	err := s.read(func(tx *table.DBReader) error {
+		if err := s.checkHash(tx, args[1]); err != nil {
+			return err
+		}
+		rec := (&table.Record{}).AddStr("key", args[1]).AddStr("field", args[2])
+		ok, err := tx.Get(s.hashTable(), rec)
+		if ok {
+			val = rec.Get("val").Str
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.bulk(val)
+	return nil
+}
+
+func cmdHSet(s *Server, w writer, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return fmt.Errorf("ERR wrong number of arguments for 'hset' command")
+	}
+	n := int64(0)
+	err := s.DB.Update(func(tx *table.DBTX) error {
+		n = 0
+		if err := s.checkHash(&tx.DBReader, args[1]); err != nil {
+			return err
+		}
+		for i := 2; i < len(args); i += 2 {
+			rec := (&table.Record{}).AddStr("key", args[1]).AddStr("field", args[i]).AddStr("val", args[i+1])
+			added, err := tx.Upsert(s.hashTable(), *rec)
+			if err != nil {
+				return err
+			}
+			if added {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.int(n)
+	return nil
+}
+
+func cmdHDel(s *Server, w writer, args [][]byte) error {
+	n := int64(0)
+	err := s.DB.Update(func(tx *table.DBTX) error {
+		n = 0
+		if err := s.checkHash(&tx.DBReader, args[1]); err != nil {
+			return err
+		}
+		for _, field := range args[2:] {
+			deleted, err := tx.Delete(s.hashTable(), *(&table.Record{}).AddStr("key", args[1]).AddStr("field", field))
+			if err != nil {
+				return err
+			}
+			if deleted {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.int(n)
+	return nil
+}
+
+func cmdHGetAll(s *Server, w writer, args [][]byte) error {
+	var pairs [][]byte
+	err := s.read(func(tx *table.DBReader) error {
+		if err := s.checkHash(tx, args[1]); err != nil {
+			return err
+		}
+		return s.hashFields(tx, args[1], func(rec table.Record) {
+			pairs = append(pairs, rec.Get("field").Str, rec.Get("val").Str)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	w.array(len(pairs))
+	for _, b := range pairs {
+		w.bulk(b)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Storage helpers
+// ---------------------------------------------------------------------------
+
+func (s *Server) getString(tx *table.DBReader, key []byte) ([]byte, bool, error) {
+	rec := (&table.Record{}).AddStr("key", key)
+	ok, err := tx.Get(s.stringTable(), rec)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	val := rec.Get("val").Str
+	if val == nil {
+		val = []byte{}
+	}
+	return val, true, nil
+}
+
+// checkHash fails with errWrongType if key holds a string.
+func (s *Server) checkHash(tx *table.DBReader, key []byte) error {
+	_, isStr, err := s.getString(tx, key)
+	if err != nil {
+		return err
+	}
+	if isStr {
+		return errWrongType
+	}
+	return nil
+}
+
+// hashFields calls fn for each field of the hash at key, in field order.
+func (s *Server) hashFields(tx *table.DBReader, key []byte, fn func(rec table.Record)) error {
+	bound := *(&table.Record{}).AddStr("key", key)
+	sc := table.Scanner{Cmp1: btree.CmpGE, Key1: bound, Cmp2: btree.CmpLE, Key2: bound}
+	if err := tx.Scan(s.hashTable(), &sc); err != nil {
+		return err
+	}
+	for ; sc.Valid(); sc.Next() {
+		var rec table.Record
+		sc.Deref(&rec)
+		fn(rec)
+	}
+	return nil
+}
+
+func (s *Server) hashExists(tx *table.DBReader, key []byte) (bool, error) {
+	found := false
+	err := s.hashFields(tx, key, func(table.Record) { found = true })
+	return found, err
+}
+
+// delHash deletes every field of the hash at key and returns how many there
+// were.
+func (s *Server) delHash(tx *table.DBTX, key []byte) (int, error) {
+	var fields []table.Record
+	err := s.hashFields(&tx.DBReader, key, func(rec table.Record) {
+		fields = append(fields, table.Record{Cols: rec.Cols[:2], Vals: rec.Vals[:2]})
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, pk := range fields {
+		if _, err := tx.Delete(s.hashTable(), pk); err != nil {
+			return 0, err
+		}
+	}
+	return len(fields), nil
+}
+
+// match reports whether s matches the Redis glob pattern: * and ? match any
+// run of bytes and any single byte, [abc], [a-z] and [^a] match a byte
+// class, and \ escapes the next byte.
+func match(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				return false // unterminated class
+			}
+			class, negate := pattern[1:end], false
+			if len(class) > 0 && class[0] == '^' {
+				class, negate = class[1:], true
+			}
+			if classMatch(class, s[0]) == negate {
+				return false
+			}
+			pattern, s = pattern[end+1:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+func classMatch(class []byte, c byte) bool {
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+			if class[i] == c {
+				return true
+			}
+			continue
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := min(class[i], class[i+2]), max(class[i], class[i+2])
+			if lo <= c && c <= hi {
+				return true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			return true
+		}
+	}
+	return false
+}
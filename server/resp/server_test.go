@@ -0,0 +1,306 @@
+package resp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	table "github.com/MHS-20/ElkDB/tables"
+	is "github.com/stretchr/testify/require"
+)
+
+// respError is an error reply as decoded by client.
+type respError string
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, path string) (*table.DB, string) {
+	db := &table.DB{Path: path}
+	is.NoError(t, db.Open())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(t, err)
+	s := &Server{DB: db}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+	t.Cleanup(func() {
+		is.NoError(t, s.Close())
+		is.NoError(t, <-served)
+		db.Close()
+	})
+	return db, ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	is.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &client{t, conn, bufio.NewReader(conn)}
+}
+
+// do sends args as a RESP array and returns the decoded reply.
+func (c *client) do(args ...string) any {
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, a := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := c.conn.Write(buf)
+	is.NoError(c.t, err)
+	return c.reply()
+}
+
+func (c *client) reply() any {
+	line, err := readLine(c.r, maxLineSize)
+	is.NoError(c.t, err)
+	body := string(line[1:])
+	switch line[0] {
+	case '+':
+		return body
+	case '-':
+		return respError(body)
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		is.NoError(c.t, err)
+		return n
+	case '$':
+		n, err := strconv.Atoi(body)
+		is.NoError(c.t, err)
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, b)
+		is.NoError(c.t, err)
+		return string(b[:n])
+	case '*':
+		n, err := strconv.Atoi(body)
+		is.NoError(c.t, err)
+		out := []any{}
+		for range n {
+			out = append(out, c.reply())
+		}
+		return out
+	}
+	c.t.Fatalf("bad reply %q", line)
+	return nil
+}
+
+func TestStrings(t *testing.T) {
+	_, addr := startServer(t, filepath.Join(t.TempDir(), "resp.db"))
+	c := dial(t, addr)
+
+	is.Equal(t, "PONG", c.do("PING"))
+	is.Equal(t, "hi", c.do("ping", "hi"))
+	is.Equal(t, nil, c.do("GET", "k"))
+	is.Equal(t, "OK", c.do("SET", "k", "v1"))
+	is.Equal(t, "v1", c.do("GET", "k"))
+	is.Equal(t, "OK", c.do("SET", "empty", ""))
+	is.Equal(t, "", c.do("GET", "empty"))
+
+	is.Equal(t, nil, c.do("SET", "k", "v2", "NX"))
+	is.Equal(t, "OK", c.do("SET", "k", "v2", "XX"))
+	is.Equal(t, nil, c.do("SET", "new", "x", "XX"))
+	is.Equal(t, "v2", c.do("GET", "k"))
+	is.Equal(t, respError("ERR expiry is not supported"), c.do("SET", "k", "v", "EX", "10"))
+
+	is.Equal(t, int64(2), c.do("EXISTS", "k", "empty", "nope"))
+	is.Equal(t, int64(1), c.do("DEL", "k", "nope"))
+	is.Equal(t, nil, c.do("GET", "k"))
+
+	is.Equal(t, respError("ERR unknown command 'FLUSHALL'"), c.do("FLUSHALL"))
+	is.Equal(t, respError("ERR wrong number of arguments for 'get' command"), c.do("GET"))
+	is.Equal(t, "OK", c.do("SELECT", "0"))
+	is.Equal(t, []any{}, c.do("COMMAND", "DOCS"))
+	is.Equal(t, "OK", c.do("QUIT"))
+}
+
+func TestHashes(t *testing.T) {
+	db, addr := startServer(t, filepath.Join(t.TempDir(), "resp.db"))
+	c := dial(t, addr)
+
+	is.Equal(t, int64(2), c.do("HSET", "h", "f1", "a", "f2", "b"))
+	is.Equal(t, int64(1), c.do("HSET", "h", "f2", "B", "f3", "c"))
+	is.Equal(t, "B", c.do("HGET", "h", "f2"))
+	is.Equal(t, nil, c.do("HGET", "h", "nope"))
+	is.Equal(t, []any{"f1", "a", "f2", "B", "f3", "c"}, c.do("HGETALL", "h"))
+	is.Equal(t, []any{}, c.do("HGETALL", "nope"))
+	is.Equal(t, int64(1), c.do("HDEL", "h", "f1", "nope"))
+	is.Equal(t, respError("ERR wrong number of arguments for 'hset' command"), c.do("HSET", "h", "f", "v", "g"))
+
+	// Kinds do not mix, but SET and DEL replace or remove either.
+	wrongType := respError(errWrongType.Error())
+	is.Equal(t, wrongType, c.do("GET", "h"))
+	is.Equal(t, "OK", c.do("SET", "s", "v"))
+	is.Equal(t, wrongType, c.do("HSET", "s", "f", "v"))
+	is.Equal(t, wrongType, c.do("HGET", "s", "f"))
+	is.Equal(t, "OK", c.do("SET", "h", "now a string"))
+	is.Equal(t, "now a string", c.do("GET", "h"))
+	is.Equal(t, int64(1), c.do("HSET", "h2", "f", "v"))
+	is.Equal(t, int64(2), c.do("DEL", "h", "h2"))
+	is.Equal(t, int64(0), c.do("EXISTS", "h", "h2"))
+
+	// The data lives in ordinary tables.
+	r := table.DBReader{}
+	db.BeginRead(&r)
+	defer db.EndRead(&r)
+	rec := (&table.Record{}).AddStr("key", []byte("s"))
+	ok, err := r.Get(DefaultStringTable, rec)
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, "v", string(rec.Get("val").Str))
+}
+
+func TestScan(t *testing.T) {
+	_, addr := startServer(t, filepath.Join(t.TempDir(), "resp.db"))
+	c := dial(t, addr)
+	var want []string
+	for i := range 25 {
+		key := fmt.Sprintf("str:%02d", i)
+		is.Equal(t, "OK", c.do("SET", key, "v"))
+		want = append(want, key)
+	}
+	for i := range 5 {
+		key := fmt.Sprintf("hash:%d", i)
+		is.Equal(t, int64(2), c.do("HSET", key, "a", "1", "b", "2"))
+		want = append(want, key)
+	}
+
+	scanAll := func(args ...string) []string {
+		var got []string
+		cursor := "0"
+		for calls := 0; ; calls++ {
+			is.Less(t, calls, 100)
+			reply := c.do(append([]string{"SCAN", cursor}, args...)...).([]any)
+			for _, k := range reply[1].([]any) {
+				got = append(got, k.(string))
+			}
+			cursor = reply[0].(string)
+			if cursor == "0" {
+				return got
+			}
+		}
+	}
+	got := scanAll("COUNT", "7")
+	slices.Sort(got)
+	slices.Sort(want)
+	is.Equal(t, want, got)
+	is.Equal(t, []string{"str:10", "str:11", "str:12", "str:13", "str:14", "str:15", "str:16", "str:17", "str:18", "str:19"},
+		scanAll("MATCH", "str:1?"))
+	is.Len(t, scanAll("MATCH", "hash:*", "COUNT", "1000"), 5)
+	is.Equal(t, respError("ERR invalid cursor"), c.do("SCAN", "x"))
+	is.Equal(t, respError("ERR syntax error"), c.do("SCAN", "0", "COUNT"))
+}
+
+func TestInlineAndPipelining(t *testing.T) {
+	_, addr := startServer(t, filepath.Join(t.TempDir(), "resp.db"))
+	c := dial(t, addr)
+
+	_, err := c.conn.Write([]byte("PING\r\n\r\nSET a 1\nGET a\r\n"))
+	is.NoError(t, err)
+	is.Equal(t, "PONG", c.reply())
+	is.Equal(t, "OK", c.reply())
+	is.Equal(t, "1", c.reply())
+
+	// A protocol error is reported and closes the connection.
+	_, err = c.conn.Write([]byte("*1\r\n+PING\r\n"))
+	is.NoError(t, err)
+	is.Contains(t, c.reply(), "protocol error")
+	_, err = c.r.ReadByte()
+	is.ErrorIs(t, err, io.EOF)
+}
+
+func TestAuth(t *testing.T) {
+	db := &table.DB{Path: filepath.Join(t.TempDir(), "resp.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	_, err := db.BootstrapAdmin("admin", "secret")
+	is.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(t, err)
+	s := &Server{DB: db, RequireAuth: true}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+	defer func() {
+		is.NoError(t, s.Close())
+		is.NoError(t, <-served)
+	}()
+
+	c := dial(t, ln.Addr().String())
+	is.Equal(t, "PONG", c.do("PING"))
+	is.Equal(t, respError("NOAUTH Authentication required."), c.do("GET", "k"))
+	is.Equal(t, respError("WRONGPASS invalid username-password pair"), c.do("AUTH", "admin", "wrong"))
+	is.Equal(t, respError("WRONGPASS invalid username-password pair"), c.do("AUTH", "secret")) // user "default"
+	is.Equal(t, "OK", c.do("AUTH", "admin", "secret"))
+	is.Equal(t, "OK", c.do("SET", "k", "v"))
+	is.Equal(t, "v", c.do("GET", "k"))
+
+	// Without RequireAuth, AUTH is an error, as in Redis without a password.
+	_, addr := startServer(t, filepath.Join(t.TempDir(), "open.db"))
+	is.Contains(t, dial(t, addr).do("AUTH", "x"), "without authentication enabled")
+}
+
+func TestTLS(t *testing.T) {
+	// Borrow httptest's certificate for 127.0.0.1.
+	certs := httptest.NewUnstartedServer(nil)
+	certs.StartTLS()
+	cfg := certs.TLS.Clone()
+	pool := x509.NewCertPool()
+	pool.AddCert(certs.Certificate())
+	certs.Close()
+
+	db := &table.DB{Path: filepath.Join(t.TempDir(), "resp.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(t, err)
+	s := &Server{DB: db, TLSConfig: cfg}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+	defer func() {
+		is.NoError(t, s.Close())
+		is.NoError(t, <-served)
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: pool})
+	is.NoError(t, err)
+	defer conn.Close()
+	c := &client{t, conn, bufio.NewReader(conn)}
+	is.Equal(t, "OK", c.do("SET", "k", "v"))
+	is.Equal(t, "v", c.do("GET", "k"))
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"a*c", "abxc", true},
+		{"a*c", "abx", false},
+		{"?b", "ab", true},
+		{"?b", "b", false},
+		{"[ab]x", "bx", true},
+		{"[ab]x", "cx", false},
+		{"[^ab]x", "cx", true},
+		{"[a-c]", "b", true},
+		{"[a-c]", "d", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"[abc", "a", false},
+		{"user:*:name", "user:42:name", true},
+	} {
+		is.Equal(t, c.want, match([]byte(c.pattern), []byte(c.s)), "%q ~ %q", c.pattern, c.s)
+	}
+}
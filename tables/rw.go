@@ -17,6 +17,13 @@ type Reader interface {
 	// not exist.  Exposes the internal getTableDef lookup so the ql package
 	// can inspect schemas without reaching into unexported table internals.
 	TableDef(tableName string) *TableDef
+
+	// Count returns the number of rows of a table; see DBReader.Count.
+	Count(tableName string) (int64, error)
+
+	// ColumnStats returns the statistics of a column stored by the last
+	// Analyze of its table; ok is false if it was never analysed.
+	ColumnStats(tableName, col string) (st ColumnStats, ok bool, err error)
 }
 
 // Writer is the read-write surface of a table transaction.
@@ -43,4 +50,18 @@ type Writer interface {
 	// Delete removes a row by its primary key. Returns (true, nil) if the
 	// row was found and deleted.
 	Delete(tableName string, rec Record) (bool, error)
+
+	// Analyze collects the column statistics of a table; see DB.Analyze.
+	Analyze(tableName string) error
+
+	// ColumnDrop removes a non-key column of a table and rewrites its
+	// rows; see DB.ColumnDrop.
+	ColumnDrop(tableName, col string) error
+
+	// ColumnType changes the type of a non-key column of a table and
+	// converts its values; see DB.ColumnType.
+	ColumnType(tableName, col string, typ uint32) error
+
+	// ColumnRename renames a column of a table; see DB.ColumnRename.
+	ColumnRename(tableName, col, name string) error
 }
@@ -0,0 +1,654 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Column changes (ALTER TABLE)
+// ---------------------------------------------------------------------------
+
+// A change to the columns of a table rewrites its rows in a new layout,
+// online, like IndexAdd. A first transaction allocates fresh prefixes for
+// the rows and indexes in the new layout and records the new definition in
+// TableDef.Rewrite: from then on every write to the table also writes the
+// row in the new layout. The existing rows are then copied in primary-key
+// order, in batches of short transactions, and the last batch swaps in the
+// new definition. Readers see the old definition and rows until that
+// commit, and the new ones after it. The rows and index entries of the old
+// layout are then deleted in batches.
+//
+// Progress is checkpointed in @meta, under "alter:" and the table name: if
+// a change is interrupted, the next change to the same table finishes it
+// first. While the new layout is built, the usage of a table with a Quota
+// is accounted for it under "alter-usage:" and the table name, and replaces
+// that of the old layout in the swap.
+//
+// A change that converts values fails with ErrConvert when the copy meets a
+// value the new type cannot hold. It is then cancelled: the new definition
+// is dropped and the rows already copied are deleted. Until the swap, a
+// write of such a value fails with ErrConvert too.
+//
+// The rows of a table with Expires, Versioned or SoftDelete set have
+// entries in internal tables keyed by their encoded primary key, prefix
+// included, so the columns of such a table cannot be changed.
+
+// ErrConvert is returned (wrapped) by DB.ColumnType, and by writes while it
+// runs, for a value the new type of the column cannot hold.
+var ErrConvert = errors.New("cannot convert value")
+
+// AlterReq describes a change to a column of a table, made by
+// DB.ColumnDrop or DB.ColumnType.
+type AlterReq struct {
+	Table string
+	Col   string
+	Type  uint32 // the new type of Col; ColumnType only
+
+	BatchSize int            // rows per transaction (0 = DefaultBackfillBatch)
+	Pause     time.Duration  // sleep between batches to throttle the rewrite
+	Progress  func(rows int) // called after each committed batch with the running total
+}
+
+// The phases of a column change, the first byte of its checkpoint.
+const (
+	alterCopy  = 'c' // copying the rows; then the last primary key copied
+	alterPurge = 'p' // deleting the old layout; then the prefixes left
+)
+
+// ColumnDrop removes a non-key column from a table that may already hold
+// rows, rewriting them without it. A column that an index holds, or that
+// the condition of a partial index tests, cannot be dropped. The rewrite
+// runs in batches of short transactions; see AlterReq.
+func (db *DB) ColumnDrop(req *AlterReq) error {
+	return db.alter(req, func(tdef *TableDef) (*TableDef, error) {
+		return dropColumn(tdef, req.Col)
+	})
+}
+
+// ColumnDrop is DB.ColumnDrop in one transaction, which rewrites every row
+// of the table.
+func (tx *DBTX) ColumnDrop(table, col string) error {
+	return tx.alter(table, func(tdef *TableDef) (*TableDef, error) {
+		return dropColumn(tdef, col)
+	})
+}
+
+// dropColumn returns the definition of tdef without col.
+func dropColumn(tdef *TableDef, col string) (*TableDef, error) {
+	i := ColIndex(tdef, col)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown column: %s", col)
+	}
+	if i < tdef.PKeys {
+		return nil, fmt.Errorf("cannot drop primary-key column: %s", col)
+	}
+	for j, index := range tdef.Indexes {
+		used := slices.Contains(index, col) || slices.Contains(tdef.included(j), col)
+		if j < len(tdef.Where) {
+			used = used || slices.ContainsFunc(tdef.Where[j], func(c Cond) bool { return c.Col == col })
+		}
+		if used {
+			return nil, fmt.Errorf("column %s is used by index %v", col, index)
+		}
+		// The key of an index must leave a column out.
+		if len(index) >= len(tdef.Cols)-1 {
+			return nil, fmt.Errorf("cannot drop column %s: index %v would hold every column", col, index)
+		}
+	}
+	next := *tdef
+	next.Cols = slices.Delete(slices.Clone(tdef.Cols), i, i+1)
+	next.Types = slices.Delete(slices.Clone(tdef.Types), i, i+1)
+	if tdef.Collate != nil {
+		next.Collate = maps.Clone(tdef.Collate)
+		delete(next.Collate, col)
+	}
+	if tdef.Desc != nil {
+		next.Desc = maps.Clone(tdef.Desc)
+		delete(next.Desc, col)
+	}
+	return &next, nil
+}
+
+// ColumnType changes the type of a non-key column of a table that may
+// already hold rows, converting its values: a number to its decimal text,
+// text holding a number to that number, and an int64 to a float64, or
+// back, when the value is exact in both. The rewrite runs in batches of
+// short transactions, like ColumnDrop, and the change is cancelled, with
+// ErrConvert, if a value cannot be converted. A column tested by the
+// condition of a partial index cannot change type. Changing a column to
+// the type it has does nothing.
+func (db *DB) ColumnType(req *AlterReq) error {
+	return db.alter(req, func(tdef *TableDef) (*TableDef, error) {
+		return changeColumnType(tdef, req.Col, req.Type)
+	})
+}
+
+// ColumnType is DB.ColumnType in one transaction, which rewrites every row
+// of the table. A value that cannot be converted fails the call, and the
+// transaction should then be aborted.
+func (tx *DBTX) ColumnType(table, col string, typ uint32) error {
+	return tx.alter(table, func(tdef *TableDef) (*TableDef, error) {
+		return changeColumnType(tdef, col, typ)
+	})
+}
+
+// ColumnRename renames a column of a table, in one short transaction. Rows
+// are encoded by column position, so none is rewritten: only the
+// definition changes, with the indexes, included columns, partial-index
+// conditions, collations and statistics that name the column. A column
+// cannot be renamed while the columns of its table change.
+func (db *DB) ColumnRename(table, col, name string) error {
+	return db.Update(func(tx *DBTX) error {
+		return tx.ColumnRename(table, col, name)
+	})
+}
+
+// ColumnRename is DB.ColumnRename within the transaction.
+func (tx *DBTX) ColumnRename(table, col, name string) error {
+	tdef := getTableDefFromDisk(&tx.DBReader, table)
+	if tdef == nil {
+		return fmt.Errorf("table not found: %s", table)
+	}
+	i := ColIndex(tdef, col)
+	switch {
+	case i < 0:
+		return fmt.Errorf("unknown column: %s", col)
+	case name == "":
+		return fmt.Errorf("empty column name")
+	case ColIndex(tdef, name) >= 0:
+		return fmt.Errorf("column exists: %s", name)
+	}
+	ok, err := dbGet(&tx.DBReader, tdefMeta, alterCheckpoint(table))
+	assert(err == nil)
+	if ok {
+		return fmt.Errorf("cannot rename a column of %s while its columns change", table)
+	}
+
+	// tdef was just decoded, so it shares nothing with the cache.
+	rename := func(cols []string) {
+		if j := slices.Index(cols, col); j >= 0 {
+			cols[j] = name
+		}
+	}
+	rename(tdef.Cols)
+	for _, index := range tdef.Indexes {
+		rename(index)
+	}
+	for _, cols := range tdef.Include {
+		rename(cols)
+	}
+	for _, conds := range tdef.Where {
+		for k := range conds {
+			if conds[k].Col == col {
+				conds[k].Col = name
+			}
+		}
+	}
+	if c, ok := tdef.Collate[col]; ok {
+		delete(tdef.Collate, col)
+		tdef.Collate[name] = c
+	}
+	if d, ok := tdef.Desc[col]; ok {
+		delete(tdef.Desc, col)
+		tdef.Desc[name] = d
+	}
+	if err := putTableDef(tx, tdef); err != nil {
+		return err
+	}
+	tx.ownDefs = true
+
+	// Move the statistics of the column to its new name.
+	key := (&Record{}).AddStr("table", []byte(table)).AddStr("col", []byte(col))
+	ok, err = dbGet(&tx.DBReader, tdefStats, key)
+	assert(err == nil)
+	if !ok {
+		return nil
+	}
+	data := key.Get("data").Str
+	if _, err := dbDelete(tx, tdefStats, *(&Record{}).AddStr("table", []byte(table)).AddStr("col", []byte(col))); err != nil {
+		return err
+	}
+	rec := (&Record{}).AddStr("table", []byte(table)).AddStr("col", []byte(name)).AddStr("data", data)
+	return dbUpdate(tx, tdefStats, &DBSetReq{Record: *rec})
+}
+
+// changeColumnType returns the definition of tdef with col of type typ, or
+// nil if it has that type already.
+func changeColumnType(tdef *TableDef, col string, typ uint32) (*TableDef, error) {
+	i := ColIndex(tdef, col)
+	switch {
+	case i < 0:
+		return nil, fmt.Errorf("unknown column: %s", col)
+	case i < tdef.PKeys:
+		return nil, fmt.Errorf("cannot change the type of primary-key column: %s", col)
+	case typ != TypeBytes && typ != TypeInt64 && typ != TypeFloat64 && typ != TypeUUID:
+		return nil, fmt.Errorf("bad column type: %d", typ)
+	case tdef.Types[i] == typ:
+		return nil, nil
+	}
+	for j, conds := range tdef.Where {
+		if slices.ContainsFunc(conds, func(c Cond) bool { return c.Col == col }) {
+			return nil, fmt.Errorf("column %s is tested by partial index %v", col, tdef.Indexes[j])
+		}
+	}
+	next := *tdef
+	next.Types = slices.Clone(tdef.Types)
+	next.Types[i] = typ
+	if _, ok := tdef.Collate[col]; ok {
+		next.Collate = maps.Clone(tdef.Collate)
+		delete(next.Collate, col)
+	}
+	return &next, nil
+}
+
+// convertValue converts v to typ, if it can be without loss.
+func convertValue(v Value, typ uint32) (Value, bool) {
+	out := Value{Type: typ}
+	var err error
+	switch {
+	case v.Type == typ:
+		return v, true
+	case typ == TypeBytes && v.Type == TypeInt64:
+		out.Str = strconv.AppendInt(nil, v.I64, 10)
+	case typ == TypeBytes && v.Type == TypeFloat64:
+		out.Str = strconv.AppendFloat(nil, v.F64, 'g', -1, 64)
+	case typ == TypeInt64 && v.Type == TypeBytes:
+		out.I64, err = strconv.ParseInt(string(v.Str), 10, 64)
+	case typ == TypeInt64 && v.Type == TypeFloat64:
+		// 2^63 is the first float above the int64 range.
+		if v.F64 != math.Trunc(v.F64) || v.F64 < math.MinInt64 || v.F64 >= math.MaxInt64 {
+			return out, false
+		}
+		out.I64 = int64(v.F64)
+	case typ == TypeBytes && v.Type == TypeUUID:
+		out.Str = []byte(UUID(v.Str).String())
+	case typ == TypeUUID && v.Type == TypeBytes:
+		var id UUID
+		id, err = ParseUUID(string(v.Str))
+		out.Str = id[:]
+	case typ == TypeFloat64 && v.Type == TypeBytes:
+		out.F64, err = strconv.ParseFloat(string(v.Str), 64)
+	case typ == TypeFloat64 && v.Type == TypeInt64:
+		out.F64 = float64(v.I64)
+		if out.F64 >= math.MaxInt64 || int64(out.F64) != v.I64 {
+			return out, false
+		}
+	default:
+		return out, false
+	}
+	return out, err == nil
+}
+
+func alterCheckpoint(table string) *Record {
+	return (&Record{}).AddStr("key", []byte("alter:"+table))
+}
+
+func alterUsageKey(table string) *Record {
+	return (&Record{}).AddStr("key", []byte("alter-usage:"+table))
+}
+
+// alter makes the change to the table of req, after finishing an earlier
+// one that was interrupted.
+func (db *DB) alter(req *AlterReq, change func(*TableDef) (*TableDef, error)) error {
+	batch := req.BatchSize
+	if batch <= 0 {
+		batch = DefaultBackfillBatch
+	}
+	for {
+		started, err := db.alterStartRetry(req.Table, change)
+		if err != nil {
+			return err
+		}
+		err = db.alterRun(req, batch)
+		if errors.Is(err, ErrConvert) {
+			// Give the change up, and delete what it copied.
+			if cerr := db.alterCancelRetry(req.Table); cerr != nil {
+				return cerr
+			}
+			if cerr := db.alterRun(req, batch); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+		if err != nil || started {
+			return err
+		}
+	}
+}
+
+// alterRun runs the batches of the change of the table of req until it is
+// done.
+func (db *DB) alterRun(req *AlterReq, batch int) error {
+	total := 0
+	for {
+		n, size, done, err := db.alterBatchRetry(req.Table, batch)
+		if err != nil {
+			return err
+		}
+		db.Maintenance.Wait(n, int64(size))
+		total += n
+		if req.Progress != nil && n > 0 {
+			req.Progress(total)
+		}
+		if done {
+			return nil
+		}
+		if req.Pause > 0 {
+			time.Sleep(req.Pause)
+		}
+	}
+}
+
+// alter is DB.alter in one transaction.
+func (tx *DBTX) alter(table string, change func(*TableDef) (*TableDef, error)) error {
+	for {
+		started, err := alterStart(tx, table, change)
+		if err != nil {
+			return err
+		}
+		for done := false; !done; {
+			if _, _, done, err = alterBatch(tx, table, math.MaxInt); err != nil {
+				return err
+			}
+		}
+		if started {
+			return nil
+		}
+	}
+}
+
+func (db *DB) alterStartRetry(table string, change func(*TableDef) (*TableDef, error)) (bool, error) {
+	var started bool
+	err := db.Update(func(tx *DBTX) (err error) {
+		started, err = alterStart(tx, table, change)
+		return err
+	})
+	return started, err
+}
+
+// alterStart starts the change of table to the definition change returns
+// and reports true, or reports false if an earlier change has to be
+// finished first. A nil definition leaves the table as it is.
+func alterStart(tx *DBTX, table string, change func(*TableDef) (*TableDef, error)) (bool, error) {
+	tdef := getTableDefFromDisk(&tx.DBReader, table)
+	if tdef == nil {
+		return false, fmt.Errorf("table not found: %s", table)
+	}
+	ok, err := dbGet(&tx.DBReader, tdefMeta, alterCheckpoint(table))
+	assert(err == nil)
+	switch {
+	case ok:
+		return false, nil
+	case tdef.Expires || tdef.Versioned || tdef.SoftDelete:
+		return false, fmt.Errorf("cannot change the columns of a table with expiring, versioned or soft-deleted rows: %s", table)
+	case slices.Contains(tdef.Building, true):
+		return false, fmt.Errorf("cannot change the columns of %s while an index is built", table)
+	}
+	next, err := change(tdef)
+	if next == nil || err != nil {
+		return err == nil, err
+	}
+	next.Indexes = slices.Clone(next.Indexes)
+	if err := tableDefCheck(next); err != nil {
+		return false, err
+	}
+	prefixes, err := allocPrefixes(tx, 1+len(next.Indexes))
+	if err != nil {
+		return false, err
+	}
+	next.Prefix, next.IndexPrefixes = prefixes[0], prefixes[1:]
+	tdef.Rewrite = next
+	if err := putTableDef(tx, tdef); err != nil {
+		return false, err
+	}
+	tx.ownDefs = true
+	ckpt := alterCheckpoint(table).AddStr("val", []byte{alterCopy})
+	return true, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+}
+
+func (db *DB) alterBatchRetry(table string, batch int) (int, int, bool, error) {
+	var n, size int
+	var done bool
+	err := db.Update(func(tx *DBTX) (err error) {
+		n, size, done, err = alterBatch(tx, table, batch)
+		return err
+	})
+	return n, size, done, err
+}
+
+// alterBatch runs one batch of the change of table and advances its
+// checkpoint. While copying, it writes up to batch rows in the new layout,
+// and swaps in the new definition once the end of the table has been
+// reached; while purging, it deletes up to batch keys of the old layout. It
+// returns the number of rows or keys written or deleted and their size in
+// bytes; done is true once no change of the table is left.
+func alterBatch(tx *DBTX, table string, batch int) (int, int, bool, error) {
+	tdef := getTableDefFromDisk(&tx.DBReader, table)
+	if tdef == nil {
+		return 0, 0, false, fmt.Errorf("table not found: %s", table)
+	}
+	ckpt := alterCheckpoint(table)
+	ok, err := dbGet(&tx.DBReader, tdefMeta, ckpt)
+	assert(err == nil)
+	if !ok {
+		return 0, 0, true, nil
+	}
+	state := ckpt.Get("val").Str
+	if state[0] == alterPurge {
+		return alterPurgeBatch(tx, table, state[1:], batch)
+	}
+
+	// Copy the rows after the last primary key done.
+	sc := resumeScanner(tdef, state[1:], true)
+	if err := dbScan(&tx.DBReader, tdef, &sc); err != nil {
+		return 0, 0, false, err
+	}
+	rows := collectBatch(&sc, batch)
+	size := 0
+	for _, rec := range rows {
+		n, err := alterSet(tx, tdef, rec)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		size += n
+	}
+
+	n := len(rows)
+	if sc.Valid() {
+		state = append([]byte{alterCopy}, encodeValues(nil, rows[n-1].Vals[:tdef.PKeys])...)
+		ckpt = alterCheckpoint(table).AddStr("val", state)
+		return n, size, false, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+	}
+	return n, size, false, alterSwap(tx, tdef)
+}
+
+// alterSwap replaces the definition of tdef, whose rows have all been
+// copied, by the new one, and starts the purge of the old layout.
+func alterSwap(tx *DBTX, tdef *TableDef) error {
+	next := *tdef.Rewrite
+	if tdef.Quota > 0 {
+		rec := alterUsageKey(tdef.Name)
+		ok, err := dbGet(&tx.DBReader, tdefMeta, rec)
+		assert(err == nil)
+		val := make([]byte, 8)
+		if ok {
+			val = rec.Get("val").Str
+			if _, err := dbDelete(tx, tdefMeta, *alterUsageKey(tdef.Name)); err != nil {
+				return err
+			}
+		}
+		if err := dbUpdate(tx, tdefMeta, &DBSetReq{Record: *quotaKey(&next).AddStr("val", val)}); err != nil {
+			return err
+		}
+	}
+	// The statistics of a column that is gone, or changed, are stale.
+	for i, col := range tdef.Cols {
+		if j := ColIndex(&next, col); j < 0 || next.Types[j] != tdef.Types[i] {
+			key := (&Record{}).AddStr("table", []byte(tdef.Name)).AddStr("col", []byte(col))
+			if _, err := dbDelete(tx, tdefStats, *key); err != nil {
+				return err
+			}
+		}
+	}
+	if err := putTableDef(tx, &next); err != nil {
+		return err
+	}
+	ckpt := alterCheckpoint(tdef.Name).AddStr("val", alterPurgeState(tdef))
+	return dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+}
+
+// alterPurgeState returns the checkpoint of the purge of the rows and
+// indexes of tdef.
+func alterPurgeState(tdef *TableDef) []byte {
+	state := binary.BigEndian.AppendUint32([]byte{alterPurge}, tdef.Prefix)
+	for _, prefix := range tdef.IndexPrefixes {
+		state = binary.BigEndian.AppendUint32(state, prefix)
+	}
+	return state
+}
+
+func (db *DB) alterCancelRetry(table string) error {
+	return db.Update(func(tx *DBTX) error {
+		return alterCancel(tx, table)
+	})
+}
+
+// alterCancel drops the new definition of table, if its rows are still
+// being copied, and starts the purge of the new layout.
+func alterCancel(tx *DBTX, table string) error {
+	tdef := getTableDefFromDisk(&tx.DBReader, table)
+	if tdef == nil || tdef.Rewrite == nil {
+		return nil
+	}
+	next := tdef.Rewrite
+	tdef.Rewrite = nil
+	if err := putTableDef(tx, tdef); err != nil {
+		return err
+	}
+	if _, err := dbDelete(tx, tdefMeta, *alterUsageKey(table)); err != nil {
+		return err
+	}
+	ckpt := alterCheckpoint(table).AddStr("val", alterPurgeState(next))
+	return dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+}
+
+// alterPurgeBatch deletes up to batch keys under the first of prefixes, the
+// big-endian prefixes of the old layout of table that are left, and removes
+// the checkpoint once they are all empty.
+func alterPurgeBatch(tx *DBTX, table string, prefixes []byte, batch int) (int, int, bool, error) {
+	start := prefixes[:4]
+	var keys [][]byte
+	size := 0
+	for it := tx.kvr.Seek(start, btree.CmpGE); it.Valid() && len(keys) < batch; it.Next() {
+		key, val := it.Deref()
+		if !bytes.HasPrefix(key, start) {
+			break
+		}
+		keys = append(keys, slices.Clone(key))
+		size += len(key) + len(val)
+	}
+	for _, key := range keys {
+		assert(tx.kvw.Del(&btree.DeleteReq{Key: key}))
+	}
+	if len(keys) < batch {
+		if err := releasePrefix(tx, binary.BigEndian.Uint32(start)); err != nil {
+			return 0, 0, false, err
+		}
+		prefixes = prefixes[4:]
+	}
+	if len(prefixes) == 0 {
+		_, err := dbDelete(tx, tdefMeta, *alterCheckpoint(table))
+		return len(keys), size, true, err
+	}
+	ckpt := alterCheckpoint(table).AddStr("val", append([]byte{alterPurge}, prefixes...))
+	return len(keys), size, false, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+}
+
+// alterRow converts rec, a row of tdef, to the layout of tdef.Rewrite.
+func alterRow(tdef *TableDef, rec Record) (Record, error) {
+	next := tdef.Rewrite
+	out := Record{next.Cols, make([]Value, len(next.Cols))}
+	for i, col := range next.Cols {
+		v := *rec.Get(col)
+		var ok bool
+		if out.Vals[i], ok = convertValue(v, next.Types[i]); !ok {
+			return out, fmt.Errorf("%w: column %s: %s", ErrConvert, col, formatValue(v))
+		}
+	}
+	return out, nil
+}
+
+// alterSet writes rec, a row of tdef written or copied while its columns
+// change, in the new layout, and returns its encoded size.
+func alterSet(tx *DBTX, tdef *TableDef, rec Record) (int, error) {
+	next := tdef.Rewrite
+	out, err := alterRow(tdef, rec)
+	if err != nil {
+		return 0, err
+	}
+	row, err := encodeRow(tx.db, next, out)
+	if err != nil {
+		return 0, err
+	}
+	req := btree.InsertReq{Key: row.key, Val: row.val}
+	tx.kvw.Update(&req)
+	size := len(row.key) + len(row.val)
+	if !req.Updated {
+		return size, nil
+	}
+	delta := int64(size)
+	if req.Old != nil {
+		old := slices.Clone(row.values)
+		decodeRowValues(next, req.Old, old[next.PKeys:])
+		indexOp(tx, next, Record{next.Cols, old}, indexDel)
+		delta -= int64(len(row.key) + len(req.Old))
+	}
+	indexOp(tx, next, Record{next.Cols, row.values}, indexAdd)
+	return size, alterCharge(tx, tdef, delta)
+}
+
+// alterDel deletes the row of tdef with primary key pk from the new layout,
+// if it was copied already.
+func alterDel(tx *DBTX, tdef *TableDef, pk []Value) error {
+	next := tdef.Rewrite
+	key := encodeKeyCols(nil, next.Prefix, next, next.Cols[:next.PKeys], pk)
+	req := btree.DeleteReq{Key: key}
+	if !tx.kvw.Del(&req) {
+		return nil
+	}
+	old := make([]Value, len(next.Cols))
+	copy(old, pk)
+	for i := next.PKeys; i < len(old); i++ {
+		old[i].Type = next.Types[i]
+	}
+	decodeRowValues(next, req.Old, old[next.PKeys:])
+	indexOp(tx, next, Record{next.Cols, old}, indexDel)
+	return alterCharge(tx, tdef, -int64(len(key)+len(req.Old)))
+}
+
+// alterCharge adds delta bytes to the usage of the new layout of tdef, if
+// tdef has a quota.
+func alterCharge(tx *DBTX, tdef *TableDef, delta int64) error {
+	if tdef.Quota <= 0 || delta == 0 {
+		return nil
+	}
+	rec := alterUsageKey(tdef.Name)
+	ok, err := dbGet(&tx.DBReader, tdefMeta, rec)
+	assert(err == nil)
+	used := int64(0)
+	if ok {
+		used = int64(binary.LittleEndian.Uint64(rec.Get("val").Str))
+	}
+	val := binary.LittleEndian.AppendUint64(nil, uint64(max(used+delta, 0)))
+	return dbUpdate(tx, tdefMeta, &DBSetReq{Record: *alterUsageKey(tdef.Name).AddStr("val", val)})
+}
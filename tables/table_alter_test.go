@@ -0,0 +1,364 @@
+package tables
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+// prefixKeys counts the keys under prefix in the last commit.
+func prefixKeys(db *DB, prefix uint32) int {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	n := 0
+	for it := tx.kvr.Seek(binary.BigEndian.AppendUint32(nil, prefix), btree.CmpGE); it.Valid(); it.Next() {
+		if key, _ := it.Deref(); binary.BigEndian.Uint32(key) != prefix {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func TestColumnDrop(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "big", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}},
+		Include: [][]string{{"v"}},
+		Quota:   1 << 20,
+	})
+	row := func(id, v int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("tag", fmt.Appendf(nil, "t%d", id%3)).
+			AddStr("big", make([]byte, 100)).AddInt64("v", v)
+	}
+	for id := range int64(100) {
+		tt.add("t", row(id, id))
+	}
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	old := *r.TableDef("t")
+	tt.db.EndRead(&r)
+
+	// Columns in use, and key columns, cannot be dropped.
+	for _, col := range []string{"id", "tag", "v", "nope"} {
+		is.Error(t, tt.db.ColumnDrop(&AlterReq{Table: "t", Col: col}))
+	}
+
+	// Writers keep going between the batches.
+	batches := 0
+	err := tt.db.ColumnDrop(&AlterReq{Table: "t", Col: "big", BatchSize: 30, Progress: func(int) {
+		batches++
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		_, err := tx.Update("t", row(10, -10)) // copied already
+		is.NoError(t, err)
+		_, err = tx.Update("t", row(90, -90)) // not copied yet
+		is.NoError(t, err)
+		_, err = tx.Insert("t", row(int64(100+batches), 0))
+		is.NoError(t, err)
+		_, err = tx.Delete("t", *(&Record{}).AddInt64("id", int64(batches)))
+		is.NoError(t, err)
+		is.NoError(t, tt.db.Commit(&tx))
+	}})
+	is.NoError(t, err)
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	tdef := tx.TableDef("t")
+	is.Equal(t, []string{"id", "tag", "v"}, tdef.Cols)
+	is.Nil(t, tdef.Rewrite)
+	sc := Scanner{Cmp1: btree.CmpGE}
+	is.NoError(t, tx.Scan("t", &sc))
+	want := map[int64]int64{}
+	for id := range int64(100) {
+		want[id] = id
+	}
+	want[10], want[90] = -10, -90
+	for i := 1; i <= batches; i++ {
+		delete(want, int64(i))
+		want[int64(100+i)] = 0
+	}
+	got := map[int64]int64{}
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		is.Equal(t, tdef.Cols, rec.Cols)
+		got[rec.Get("id").I64] = rec.Get("v").I64
+	}
+	is.Equal(t, want, got)
+
+	// The index was rebuilt in the new layout, and the old one is gone.
+	report, err := tx.CheckIndexes("t")
+	is.NoError(t, err)
+	is.True(t, report.OK(), report.Problems)
+	is.EqualValues(t, len(want), report.Entries)
+	tt.db.Abort(&tx)
+	for _, prefix := range append([]uint32{old.Prefix}, old.IndexPrefixes...) {
+		is.Zero(t, prefixKeys(&tt.db, prefix))
+	}
+
+	// The usage is that of the new rows.
+	usage := int64(0)
+	r = DBReader{}
+	tt.db.BeginRead(&r)
+	sc = Scanner{Cmp1: btree.CmpGE}
+	is.NoError(t, r.Scan("t", &sc))
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		usage += int64(len(encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:1], rec.Vals[:1])) + len(encodeValues(nil, rec.Vals[1:])))
+	}
+	used, err := r.Usage("t")
+	is.NoError(t, err)
+	is.Equal(t, usage, used)
+	tt.db.EndRead(&r)
+}
+
+func TestColumnDropTx(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "a", "b"},
+		Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys: 1,
+	})
+	for id := range int64(10) {
+		tt.add("t", *(&Record{}).AddInt64("id", id).AddStr("a", []byte("x")).AddInt64("b", id))
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	is.NoError(t, tx.ColumnDrop("t", "a"))
+	_, err := tx.Insert("t", *(&Record{}).AddInt64("id", 10).AddInt64("b", 10))
+	is.NoError(t, err)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	sc := Scanner{Cmp1: btree.CmpGE}
+	is.NoError(t, r.Scan("t", &sc))
+	n := int64(0)
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		is.Equal(t, []string{"id", "b"}, rec.Cols)
+		is.Equal(t, n, rec.Get("b").I64)
+		n++
+	}
+	is.EqualValues(t, 11, n)
+}
+
+func TestColumnType(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "n", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"n"}},
+	})
+	row := func(id int64, n string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("n", []byte(n)).AddInt64("v", id)
+	}
+	for id := range int64(50) {
+		tt.add("t", row(id, fmt.Sprint(100-id)))
+	}
+	is.Error(t, tt.db.ColumnType(&AlterReq{Table: "t", Col: "id", Type: TypeBytes}))
+	is.Error(t, tt.db.ColumnType(&AlterReq{Table: "t", Col: "n", Type: 9}))
+
+	// A write the new type cannot hold fails while the rows are copied.
+	err := tt.db.ColumnType(&AlterReq{Table: "t", Col: "n", Type: TypeInt64, BatchSize: 20, Progress: func(rows int) {
+		if rows != 20 {
+			return // once, while the rows are copied
+		}
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		_, err := tx.Update("t", row(3, "x"))
+		is.ErrorIs(t, err, ErrConvert)
+		tt.db.Abort(&tx)
+	}})
+	is.NoError(t, err)
+	is.NoError(t, tt.db.ColumnType(&AlterReq{Table: "t", Col: "n", Type: TypeInt64})) // no change
+
+	// The index now orders n as numbers.
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	is.Equal(t, []uint32{TypeInt64, TypeInt64, TypeInt64}, r.TableDef("t").Types)
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE,
+		Key1: *(&Record{}).AddInt64("n", 51), Key2: *(&Record{}).AddInt64("n", 100)}
+	is.NoError(t, r.Scan("t", &sc))
+	var ids []int64
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		ids = append(ids, rec.Get("id").I64)
+	}
+	is.Len(t, ids, 50)
+	is.Equal(t, int64(49), ids[0])
+	report, err := r.CheckIndexes("t")
+	is.NoError(t, err)
+	is.True(t, report.OK(), report.Problems)
+	tdef := *r.TableDef("t")
+	tt.db.EndRead(&r)
+
+	// A value that cannot be converted cancels the change.
+	tt.add("t", *(&Record{}).AddInt64("id", 50).AddInt64("n", 1).AddInt64("v", 1<<60+1))
+	err = tt.db.ColumnType(&AlterReq{Table: "t", Col: "v", Type: TypeFloat64, BatchSize: 20})
+	is.ErrorIs(t, err, ErrConvert)
+	r = DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	now := r.TableDef("t")
+	is.Nil(t, now.Rewrite)
+	is.Equal(t, tdef.Types, now.Types)
+	is.Equal(t, tdef.Prefix, now.Prefix)
+	// Whatever had been copied is gone, from the prefixes the change took.
+	for _, prefix := range []uint32{tdef.IndexPrefixes[0] + 1, tdef.IndexPrefixes[0] + 2} {
+		is.Zero(t, prefixKeys(&tt.db, prefix))
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	for _, c := range []struct {
+		in  Value
+		typ uint32
+		out Value
+		ok  bool
+	}{
+		{Value{Type: TypeInt64, I64: -12}, TypeBytes, Value{Type: TypeBytes, Str: []byte("-12")}, true},
+		{Value{Type: TypeFloat64, F64: 1.5}, TypeBytes, Value{Type: TypeBytes, Str: []byte("1.5")}, true},
+		{Value{Type: TypeBytes, Str: []byte("42")}, TypeInt64, Value{Type: TypeInt64, I64: 42}, true},
+		{Value{Type: TypeBytes, Str: []byte("4x")}, TypeInt64, Value{Type: TypeInt64}, false},
+		{Value{Type: TypeBytes, Str: []byte("2.5")}, TypeFloat64, Value{Type: TypeFloat64, F64: 2.5}, true},
+		{Value{Type: TypeFloat64, F64: 3}, TypeInt64, Value{Type: TypeInt64, I64: 3}, true},
+		{Value{Type: TypeFloat64, F64: 3.5}, TypeInt64, Value{Type: TypeInt64}, false},
+		{Value{Type: TypeFloat64, F64: 1 << 63}, TypeInt64, Value{Type: TypeInt64}, false},
+		{Value{Type: TypeInt64, I64: 7}, TypeFloat64, Value{Type: TypeFloat64, F64: 7}, true},
+	} {
+		out, ok := convertValue(c.in, c.typ)
+		is.Equal(t, c.ok, ok, c)
+		if ok {
+			is.Equal(t, c.out, out, c)
+		}
+	}
+}
+
+func TestColumnRename(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v", "w"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}, {"w"}},
+		Include: [][]string{{"v"}},
+		Where:   [][]Cond{nil, {{Col: "v", Cmp: ">", Val: Value{Type: TypeInt64, I64: 0}}}},
+		Collate: map[string]string{"tag": CollateNoCase},
+	})
+	for id := range int64(10) {
+		tt.add("t", *(&Record{}).AddInt64("id", id).AddStr("tag", []byte("A")).AddInt64("v", id%2).AddInt64("w", id))
+	}
+	is.NoError(t, tt.db.Analyze("t"))
+
+	is.Error(t, tt.db.ColumnRename("t", "nope", "x"))
+	is.Error(t, tt.db.ColumnRename("t", "v", "tag"))
+	is.NoError(t, tt.db.ColumnRename("t", "v", "odd"))
+	is.NoError(t, tt.db.ColumnRename("t", "tag", "label"))
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	tdef := r.TableDef("t")
+	is.Equal(t, []string{"id", "label", "odd", "w"}, tdef.Cols)
+	is.Equal(t, [][]string{{"label", "id"}, {"w", "id"}}, tdef.Indexes)
+	is.Equal(t, [][]string{{"odd"}}, tdef.Include)
+	is.Equal(t, "odd", tdef.Where[1][0].Col)
+	is.Equal(t, map[string]string{"label": CollateNoCase}, tdef.Collate)
+
+	// The rows read under the new names, through either index.
+	key := *(&Record{}).AddStr("label", []byte("a"))
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key, Key2: key}
+	is.NoError(t, r.Scan("t", &sc))
+	n := 0
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		is.Equal(t, rec.Get("id").I64%2, rec.Get("odd").I64)
+		n++
+	}
+	is.Equal(t, 10, n)
+	report, err := r.CheckIndexes("t")
+	is.NoError(t, err)
+	is.True(t, report.OK(), report.Problems)
+
+	_, ok, err := r.ColumnStats("t", "odd")
+	is.NoError(t, err)
+	is.True(t, ok)
+	_, _, err = r.ColumnStats("t", "v")
+	is.Error(t, err)
+}
+
+func TestPrefixRecycle(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v", "big"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}},
+	})
+	for id := range int64(10) {
+		tt.add("t", *(&Record{}).AddInt64("id", id).AddStr("tag", []byte("x")).AddInt64("v", id).AddStr("big", []byte("y")))
+	}
+	is.NoError(t, tt.db.ColumnDrop(&AlterReq{Table: "t", Col: "big", BatchSize: 3}))
+
+	// The old layout's prefixes are released and reused, lowest first,
+	// before the counter advances.
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	is.Equal(t, uint32(102), r.TableDef("t").Prefix)
+	tt.db.EndRead(&r)
+	tdef := &TableDef{
+		Name:    "u",
+		Cols:    []string{"id", "a", "b", "c"},
+		Types:   []uint32{TypeInt64, TypeInt64, TypeInt64, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"a"}, {"b"}},
+	}
+	tt.create(tdef)
+	is.Equal(t, uint32(100), tdef.Prefix)
+	is.Equal(t, []uint32{101, 104}, tdef.IndexPrefixes)
+
+	// Running out of prefixes is an error, not a wraparound.
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	val := binary.LittleEndian.AppendUint32(nil, math.MaxUint32-1)
+	meta := (&Record{}).AddStr("key", []byte("next_prefix")).AddStr("val", val)
+	is.NoError(t, dbUpdate(&tx, tdefMeta, &DBSetReq{Record: *meta}))
+	err := tx.TableNew(&TableDef{
+		Name:    "w",
+		Cols:    []string{"id", "a", "b"},
+		Types:   []uint32{TypeInt64, TypeInt64, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"a"}},
+	})
+	is.ErrorIs(t, err, ErrPrefixesExhausted)
+	is.NoError(t, tx.TableNew(&TableDef{Name: "w", Cols: []string{"id"}, Types: []uint32{TypeInt64}, PKeys: 1}))
+	is.Equal(t, uint32(math.MaxUint32-1), tx.TableDef("w").Prefix)
+	is.NoError(t, tt.db.Commit(&tx))
+}
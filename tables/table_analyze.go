@@ -0,0 +1,320 @@
+package tables
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Column statistics (ANALYZE)
+// ---------------------------------------------------------------------------
+
+// Defaults of Analyze.
+const (
+	AnalyzeSample  = 10000 // rows kept in the sample of a table
+	AnalyzeBuckets = 32    // buckets of each histogram
+)
+
+// statsMaxBytes bounds the bytes values kept as histogram bounds, so that
+// the statistics of a column with large values still fit in a B-tree value.
+const statsMaxBytes = 64
+
+// tdefStats stores the statistics of each analysed column as JSON.
+var tdefStats = &TableDef{
+	Prefix: 7,
+	Name:   "@stats",
+	Types:  []uint32{TypeBytes, TypeBytes, TypeBytes},
+	Cols:   []string{"table", "col", "data"},
+	PKeys:  2,
+}
+
+// ColumnStats describes the values of one column of a table, as estimated
+// by Analyze from a uniform sample of its rows.
+type ColumnStats struct {
+	Rows     int64 // rows of the table when it was analysed
+	Sampled  int64 // rows in the sample
+	Distinct int64 // estimated number of distinct values
+
+	// Bounds are the bounds of an equi-depth histogram of the sample, in
+	// the order of the column (its collation, for bytes): bucket i lies
+	// between Bounds[i] and Bounds[i+1] and holds about as many sampled
+	// rows as any other. The first bound is the smallest value sampled and
+	// the last the largest. A value that fills several buckets is frequent.
+	// Bytes bounds are cut to statsMaxBytes.
+	Bounds []Value
+
+	Analyzed time.Time
+}
+
+// Analyze collects the statistics of every column of table and stores them
+// in @stats, replacing those of an earlier run. It reads the whole table
+// from one snapshot, paced by Maintenance, and keeps a uniform sample of
+// AnalyzeSample rows (reservoir sampling), from which the histograms and
+// the distinct counts are estimated. Only the last step writes, in a short
+// transaction. Expired rows are left out.
+func (db *DB) Analyze(table string) error {
+	r := DBReader{}
+	db.BeginRead(&r)
+	tdef := getTableDef(&r, table)
+	if tdef == nil {
+		db.EndRead(&r)
+		return fmt.Errorf("table not found: %s", table)
+	}
+	stats, err := analyze(&r, tdef, func(n, size int) { db.Maintenance.Wait(n, int64(size)) })
+	db.EndRead(&r)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *DBTX) error {
+		return putColumnStats(tx, tdef, stats)
+	})
+}
+
+// Analyze is DB.Analyze within the transaction, which sees the rows it
+// wrote. The scan is not paced.
+func (tx *DBTX) Analyze(table string) error {
+	tdef := getTableDef(&tx.DBReader, table)
+	if tdef == nil {
+		return fmt.Errorf("table not found: %s", table)
+	}
+	stats, err := analyze(&tx.DBReader, tdef, nil)
+	if err != nil {
+		return err
+	}
+	return putColumnStats(tx, tdef, stats)
+}
+
+// ColumnStats returns the statistics of col of table stored by the last
+// Analyze of the table. ok is false if it was never analysed.
+func (tx *DBReader) ColumnStats(table, col string) (st ColumnStats, ok bool, err error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return st, false, fmt.Errorf("table not found: %s", table)
+	}
+	if ColIndex(tdef, col) < 0 {
+		return st, false, fmt.Errorf("unknown column: %s", col)
+	}
+	rec := (&Record{}).AddStr("table", []byte(table)).AddStr("col", []byte(col))
+	ok, err = dbGet(tx, tdefStats, rec)
+	if !ok || err != nil {
+		return st, false, err
+	}
+	var data columnStatsData
+	if err := json.Unmarshal(rec.Get("data").Str, &data); err != nil {
+		return st, false, err
+	}
+	st = data.ColumnStats
+	for in := data.Bounds; len(in) > 0; {
+		v := Value{Type: tdef.Types[ColIndex(tdef, col)]}
+		in = decodeValue(in, &v)
+		st.Bounds = append(st.Bounds, v)
+	}
+	return st, true, nil
+}
+
+// Selectivity estimates the fraction of the rows that satisfy c, a
+// condition on the column st describes, from its histogram. A value that
+// spans whole buckets has the share of the rows they hold, any other value
+// the share of one distinct value. A range counts the buckets it covers,
+// interpolating within the bucket at each end for numbers and counting half
+// of it for bytes. A table that was empty when analysed gives 0.
+func (st *ColumnStats) Selectivity(tdef *TableDef, c Cond) float64 {
+	if len(st.Bounds) < 2 {
+		return 0
+	}
+	eq, lt := st.equal(tdef, c.Col, c.Val), st.less(tdef, c.Col, c.Val)
+	var f float64
+	switch c.Cmp {
+	case "==":
+		f = eq
+	case "!=":
+		f = 1 - eq
+	case "<":
+		f = lt
+	case "<=":
+		f = lt + eq
+	case ">":
+		f = 1 - lt - eq
+	case ">=":
+		f = 1 - lt
+	default:
+		panic("ColumnStats.Selectivity: bad operator " + c.Cmp)
+	}
+	return min(max(f, 0), 1)
+}
+
+// equal estimates the fraction of the rows whose col equals v.
+func (st *ColumnStats) equal(tdef *TableDef, col string, v Value) float64 {
+	b := st.Bounds
+	buckets := float64(len(b) - 1)
+	if compareValues(tdef, col, v, b[0]) < 0 || compareValues(tdef, col, v, b[len(b)-1]) > 0 {
+		return 0
+	}
+	whole := 0
+	for i := 1; i < len(b); i++ {
+		if compareValues(tdef, col, b[i-1], v) == 0 && compareValues(tdef, col, b[i], v) == 0 {
+			whole++
+		}
+	}
+	if whole > 0 {
+		return float64(whole) / buckets
+	}
+	return min(1/float64(max(st.Distinct, 1)), 1/buckets)
+}
+
+// less estimates the fraction of the rows whose col is below v.
+func (st *ColumnStats) less(tdef *TableDef, col string, v Value) float64 {
+	b := st.Bounds
+	sum := 0.0
+	for i := 1; i < len(b); i++ {
+		lo, hi := b[i-1], b[i]
+		switch {
+		case compareValues(tdef, col, hi, v) < 0:
+			sum++
+		case compareValues(tdef, col, lo, v) >= 0:
+		default:
+			// lo < v <= hi
+			part := 0.5
+			switch v.Type {
+			case TypeInt64:
+				part = (float64(v.I64) - float64(lo.I64)) / (float64(hi.I64) - float64(lo.I64))
+			case TypeFloat64:
+				part = (v.F64 - lo.F64) / (hi.F64 - lo.F64)
+			}
+			if math.IsNaN(part) || part < 0 || part > 1 {
+				part = 0.5 // infinities and NaNs
+			}
+			sum += part
+		}
+	}
+	return sum / float64(len(b)-1)
+}
+
+// columnStatsData is ColumnStats as stored in @stats. Its Bounds, in the
+// value encoding, hide those of ColumnStats from JSON, which cannot hold
+// every float.
+type columnStatsData struct {
+	ColumnStats
+	Bounds []byte
+}
+
+// analyzePace is the number of rows analyze reads between calls to pace.
+const analyzePace = 256
+
+// analyze scans tdef and returns the statistics of its columns, in column
+// order. pace, if set, is called every analyzePace rows with the rows and
+// bytes read since the last call.
+func analyze(tx *DBReader, tdef *TableDef, pace func(n, size int)) ([]ColumnStats, error) {
+	sc := Scanner{Cmp1: btree.CmpGE}
+	if err := dbScan(tx, tdef, &sc); err != nil {
+		return nil, err
+	}
+	var sample [][]Value
+	rows, n, size := int64(0), 0, 0
+	for ; sc.Valid(); sc.Next() {
+		rows++
+		i := len(sample)
+		if i == AnalyzeSample {
+			i = int(rand.Int64N(rows))
+		}
+		if i < AnalyzeSample {
+			// Values may point into the pages of the snapshot.
+			var rec Record
+			sc.Deref(&rec)
+			for j := range rec.Vals {
+				rec.Vals[j].Str = slices.Clone(rec.Vals[j].Str)
+			}
+			if i == len(sample) {
+				sample = append(sample, rec.Vals)
+			} else {
+				sample[i] = rec.Vals
+			}
+		}
+		key, val := sc.iter.Deref()
+		n, size = n+1, size+len(key)+len(val)
+		if pace != nil && n == analyzePace {
+			pace(n, size)
+			n, size = 0, 0
+		}
+	}
+
+	now := time.Now()
+	stats := make([]ColumnStats, len(tdef.Cols))
+	for c, col := range tdef.Cols {
+		vals := make([]Value, len(sample))
+		for i, row := range sample {
+			vals[i] = row[c]
+		}
+		slices.SortFunc(vals, func(a, b Value) int { return compareValues(tdef, col, a, b) })
+		stats[c] = ColumnStats{
+			Rows:     rows,
+			Sampled:  int64(len(vals)),
+			Distinct: estimateDistinct(tdef, col, vals, rows),
+			Bounds:   histogram(vals, AnalyzeBuckets),
+			Analyzed: now,
+		}
+	}
+	return stats, nil
+}
+
+// estimateDistinct estimates the distinct values of a column of rows rows
+// from the sorted sample vals, with the Duj1 estimator of Haas and Stokes:
+// it scales the distinct values of the sample by how many of them were seen
+// only once. A sample of the whole table is counted exactly.
+func estimateDistinct(tdef *TableDef, col string, vals []Value, rows int64) int64 {
+	d, once := 0, 0
+	for i := 0; i < len(vals); {
+		j := i + 1
+		for j < len(vals) && compareValues(tdef, col, vals[i], vals[j]) == 0 {
+			j++
+		}
+		d++
+		if j-i == 1 {
+			once++
+		}
+		i = j
+	}
+	n := float64(len(vals))
+	if int64(len(vals)) == rows || d == 0 {
+		return int64(d)
+	}
+	est := n * float64(d) / (n - float64(once) + float64(once)*n/float64(rows))
+	return min(max(int64(est+0.5), int64(d)), rows)
+}
+
+// histogram returns the buckets+1 bounds of an equi-depth histogram of the
+// sorted values vals, or nil for no values.
+func histogram(vals []Value, buckets int) []Value {
+	if len(vals) == 0 {
+		return nil
+	}
+	bounds := make([]Value, buckets+1)
+	for i := range bounds {
+		v := vals[i*(len(vals)-1)/buckets]
+		if v.Type == TypeBytes && len(v.Str) > statsMaxBytes {
+			v.Str = v.Str[:statsMaxBytes]
+		}
+		bounds[i] = v
+	}
+	return bounds
+}
+
+// putColumnStats stores the statistics of the columns of tdef.
+func putColumnStats(tx *DBTX, tdef *TableDef, stats []ColumnStats) error {
+	for c, col := range tdef.Cols {
+		data, err := json.Marshal(columnStatsData{stats[c], encodeValues(nil, stats[c].Bounds)})
+		assert(err == nil)
+		rec := (&Record{}).AddStr("table", []byte(tdef.Name)).AddStr("col", []byte(col)).AddStr("data", data)
+		if err := dbUpdate(tx, tdefStats, &DBSetReq{Record: *rec}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
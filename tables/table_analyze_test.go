@@ -0,0 +1,146 @@
+package tables
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestAnalyze(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "grp", "f"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeFloat64},
+		PKeys:   1,
+		Collate: map[string]string{"grp": CollateNoCase},
+	})
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	_, ok, err := tx.ColumnStats("t", "id")
+	is.NoError(t, err)
+	is.False(t, ok)
+	_, _, err = tx.ColumnStats("t", "nope")
+	is.Error(t, err)
+
+	// Within a transaction, Analyze sees its rows.
+	for i := range 100 {
+		grp := fmt.Sprintf("g%d", i%4)
+		if i%8 == 0 {
+			grp = fmt.Sprintf("G%d", i%4) // collates equal to g%d
+		}
+		f := float64(i)
+		if i == 99 {
+			f = math.NaN()
+		}
+		_, err := tx.Insert("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("grp", []byte(grp)).AddFloat64("f", f))
+		is.NoError(t, err)
+	}
+	is.NoError(t, tx.Analyze("t"))
+	st, ok, err := tx.ColumnStats("t", "grp")
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, int64(100), st.Rows)
+	is.Equal(t, int64(100), st.Sampled)
+	is.Equal(t, int64(4), st.Distinct)
+	is.Len(t, st.Bounds, AnalyzeBuckets+1)
+	st, _, err = tx.ColumnStats("t", "f")
+	is.NoError(t, err)
+	is.Equal(t, int64(100), st.Distinct)
+	is.True(t, math.IsNaN(st.Bounds[0].F64)) // NaN sorts first, and survives storage
+	is.Equal(t, 98.0, st.Bounds[AnalyzeBuckets].F64)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// More rows than the sample.
+	tt.db.Begin(&tx)
+	for i := 100; i < 3*AnalyzeSample/2; i++ {
+		_, err := tx.Insert("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("grp", fmt.Appendf(nil, "g%d", i%4)).AddFloat64("f", float64(i%1000)))
+		is.NoError(t, err)
+	}
+	is.NoError(t, tt.db.Commit(&tx))
+	is.NoError(t, tt.db.Analyze("t"))
+	is.Error(t, tt.db.Analyze("nope"))
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	st, _, err = r.ColumnStats("t", "id")
+	is.NoError(t, err)
+	is.Equal(t, int64(3*AnalyzeSample/2), st.Rows)
+	is.Equal(t, int64(AnalyzeSample), st.Sampled)
+	is.Equal(t, st.Rows, st.Distinct) // every sampled value was seen once
+	is.Less(t, st.Bounds[0].I64, int64(200))
+	is.Greater(t, st.Bounds[AnalyzeBuckets].I64, int64(3*AnalyzeSample/2-200))
+	for i := 1; i < len(st.Bounds); i++ {
+		is.Less(t, st.Bounds[i-1].I64, st.Bounds[i].I64)
+	}
+	// The quartiles of an evenly spread column are near where they belong.
+	is.InDelta(t, 3*AnalyzeSample/8, st.Bounds[AnalyzeBuckets/4].I64, 3*AnalyzeSample/80)
+	st, _, err = r.ColumnStats("t", "grp")
+	is.NoError(t, err)
+	is.Equal(t, int64(4), st.Distinct)
+	st, _, err = r.ColumnStats("t", "f")
+	is.NoError(t, err)
+	is.InEpsilon(t, 1000, st.Distinct, 0.1)
+}
+
+func TestColumnStatsSelectivity(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "grp", "name"},
+		Types: []uint32{TypeInt64, TypeInt64, TypeBytes},
+		PKeys: 1,
+	})
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	for i := range 1000 {
+		grp := int64(i) // half of the rows are in group 0
+		if i%2 == 0 {
+			grp = 0
+		}
+		_, err := tx.Insert("t", *(&Record{}).AddInt64("id", int64(i)).AddInt64("grp", grp).AddStr("name", fmt.Appendf(nil, "n%03d", i)))
+		is.NoError(t, err)
+	}
+	is.NoError(t, tt.db.Commit(&tx))
+	is.NoError(t, tt.db.Analyze("t"))
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	tdef := r.TableDef("t")
+	sel := func(col, cmp string, v Value) float64 {
+		st, ok, err := r.ColumnStats("t", col)
+		is.NoError(t, err)
+		is.True(t, ok)
+		return st.Selectivity(tdef, Cond{Col: col, Cmp: cmp, Val: v})
+	}
+	i64 := func(v int64) Value { return Value{Type: TypeInt64, I64: v} }
+	str := func(s string) Value { return Value{Type: TypeBytes, Str: []byte(s)} }
+
+	is.InDelta(t, 0.25, sel("id", "<", i64(250)), 0.02)
+	is.InDelta(t, 0.75, sel("id", ">=", i64(250)), 0.02)
+	is.InDelta(t, 0.001, sel("id", "==", i64(500)), 0.001)
+	is.Equal(t, 0.0, sel("id", "==", i64(5000)))
+	is.Equal(t, 0.0, sel("id", ">", i64(5000)))
+	is.Equal(t, 1.0, sel("id", ">=", i64(-1)))
+	is.InDelta(t, 0.5, sel("grp", "==", i64(0)), 0.05) // a frequent value
+	is.InDelta(t, 0.5, sel("grp", "!=", i64(0)), 0.05)
+	is.InDelta(t, 0.002, sel("grp", "==", i64(501)), 0.002)
+	is.InDelta(t, 0.5, sel("name", "<", str("n500")), 0.05)
+	is.Equal(t, 0.0, sel("name", "<", str("a")))
+
+	// An empty table selects nothing.
+	tt.create(&TableDef{Name: "e", Cols: []string{"id", "v"}, Types: []uint32{TypeInt64, TypeInt64}, PKeys: 1})
+	is.NoError(t, tt.db.Analyze("e"))
+	tt.db.EndRead(&r)
+	tt.db.BeginRead(&r)
+	st, ok, err := r.ColumnStats("e", "v")
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, 0.0, st.Selectivity(r.TableDef("e"), Cond{Col: "v", Cmp: "!=", Val: i64(1)}))
+}
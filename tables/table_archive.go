@@ -0,0 +1,466 @@
+package tables
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Cold tier: archived segments
+// ---------------------------------------------------------------------------
+
+// SegmentStore holds archived segments by name. Segments are written once
+// and never modified, so the store can be slow or remote (e.g. an object
+// store); DirStore keeps them as files in a local directory.
+type SegmentStore interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	Delete(name string) error
+}
+
+// DirStore is a SegmentStore backed by one file per segment in Dir.
+type DirStore struct {
+	Dir string
+}
+
+// path returns the file of segment name, refusing names that could point
+// outside Dir.
+func (s DirStore) path(name string) (string, error) {
+	if err := checkSegmentName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, name+".seg"), nil
+}
+
+func (s DirStore) Put(name string, data []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s DirStore) Get(name string) ([]byte, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s DirStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// checkSegmentName rejects segment names that are empty or hold a path
+// separator or "..", so that a name maps to one entry of a store.
+func checkSegmentName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("bad segment name: %q", name)
+	}
+	return nil
+}
+
+// segmentMeta is the @meta record describing one archived segment.
+type segmentMeta struct {
+	Lo, Hi []byte // first and last encoded primary key in the segment
+	Rows   int
+}
+
+// segmentKV is one archived row in its B-tree encoding.
+type segmentKV struct {
+	key, val []byte
+}
+
+func archiveMetaKey(table, name string) []byte {
+	return []byte("archive:" + table + "/" + name)
+}
+
+// archiveTombKey is the @meta key that hides the row with the encoded
+// primary key of table in segment name. Segments cannot be changed, so a
+// row deleted from the hot tier, or archived again into a newer segment,
+// is hidden in the segments that still hold it instead.
+func archiveTombKey(table, name string, key []byte) []byte {
+	return append([]byte("archive-del:"+table+"/"+name+"/"), key...)
+}
+
+// Archive moves the rows of table selected by sc into a compressed,
+// read-only segment called name in DB.Segments, and deletes them from the
+// B-tree (secondary index entries included). The segment is written before
+// the rows are removed, so a failure leaves the data in the hot tier, and
+// it is deleted again if the commit fails. Get still finds archived rows;
+// scans do not see them until Restore. Returns the number of rows archived.
+func (db *DB) Archive(table, name string, sc Scanner) (int, error) {
+	if db.Segments == nil {
+		return 0, fmt.Errorf("no segment store configured")
+	}
+	if err := checkSegmentName(name); err != nil {
+		return 0, err
+	}
+	tx := DBTX{}
+	db.Begin(&tx)
+	n, data, err := archiveRows(&tx, table, name, sc)
+	if err == nil && n > 0 {
+		db.Maintenance.Wait(n, int64(len(data)))
+		err = db.Segments.Put(name, data)
+	}
+	if err != nil {
+		db.Abort(&tx)
+		return 0, err
+	}
+	if err := db.Commit(&tx); err != nil {
+		if n > 0 {
+			if derr := db.Segments.Delete(name); derr != nil {
+				err = errors.Join(err, fmt.Errorf("delete segment %s: %w", name, derr))
+			}
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+func archiveRows(tx *DBTX, table, name string, sc Scanner) (int, []byte, error) {
+	tdef := getTableDef(&tx.DBReader, table)
+	if tdef == nil {
+		return 0, nil, fmt.Errorf("table not found: %s", table)
+	}
+	meta := (&Record{}).AddStr("key", archiveMetaKey(table, name))
+	exists, err := dbGet(&tx.DBReader, tdefMeta, meta)
+	assert(err == nil)
+	if exists {
+		return 0, nil, fmt.Errorf("segment exists: %s", name)
+	}
+
+	// Collect the range first to avoid mutating while iterating.
+	if err := dbScan(&tx.DBReader, tdef, &sc); err != nil {
+		return 0, nil, err
+	}
+	var rows []segmentKV
+	var pks []Record
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		rows = append(rows, segmentKV{
+			key: encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], rec.Vals[:tdef.PKeys]),
+			val: encodeValues(nil, rec.Vals[tdef.PKeys:]),
+		})
+		pks = append(pks, Record{tdef.Cols[:tdef.PKeys], rec.Vals[:tdef.PKeys]})
+	}
+	if len(rows) == 0 {
+		return 0, nil, nil
+	}
+	slices.SortFunc(rows, func(a, b segmentKV) int { return bytes.Compare(a.key, b.key) })
+
+	for _, pk := range pks {
+		if _, err := dbDelete(tx, tdef, pk); err != nil {
+			return 0, nil, err
+		}
+	}
+	info, err := json.Marshal(segmentMeta{rows[0].key, rows[len(rows)-1].key, len(rows)})
+	assert(err == nil)
+	meta.AddStr("val", info)
+	if err := dbUpdate(tx, tdefMeta, &DBSetReq{Record: *meta}); err != nil {
+		return 0, nil, err
+	}
+	return len(rows), encodeSegment(rows), nil
+}
+
+// RestoreResult reports what DB.Restore did with the rows of a segment.
+type RestoreResult struct {
+	Restored int // rows moved back into the table
+	Hidden   int // rows left out because they were deleted or archived again
+	// Conflicts holds the primary keys of the rows left out because the key
+	// was reused in the hot tier since they were archived; the newer hot
+	// rows are kept.
+	Conflicts []Record
+}
+
+// Restore moves the rows of segment name back into table and deletes the
+// segment. A row is left out when it was deleted or archived again after
+// the segment was written, or when its primary key is in use in the hot
+// tier; the result reports those rows, and the archived versions of them
+// are gone with the segment.
+func (db *DB) Restore(table, name string) (RestoreResult, error) {
+	if db.Segments == nil {
+		return RestoreResult{}, fmt.Errorf("no segment store configured")
+	}
+	var res RestoreResult
+	err := db.Update(func(tx *DBTX) (err error) {
+		res, err = restoreRows(tx, table, name)
+		return err
+	})
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	return res, db.Segments.Delete(name)
+}
+
+func restoreRows(tx *DBTX, table, name string) (RestoreResult, error) {
+	var res RestoreResult
+	tdef := getTableDef(&tx.DBReader, table)
+	if tdef == nil {
+		return res, fmt.Errorf("table not found: %s", table)
+	}
+	meta := (&Record{}).AddStr("key", archiveMetaKey(table, name))
+	ok, err := dbGet(&tx.DBReader, tdefMeta, meta)
+	assert(err == nil)
+	if !ok {
+		return res, fmt.Errorf("segment not found: %s", name)
+	}
+	rows, err := loadSegment(tx.db.Segments, name)
+	if err != nil {
+		return res, err
+	}
+	for _, kv := range rows {
+		tomb := (&Record{}).AddStr("key", archiveTombKey(table, name, kv.key))
+		hidden, err := dbDelete(tx, tdefMeta, *tomb)
+		if err != nil {
+			return res, err
+		}
+		if hidden {
+			res.Hidden++
+			continue
+		}
+		rec := decodeSegmentRow(tdef, kv)
+		req := DBSetReq{Record: rec, Mode: btree.ModeInsertOnly}
+		if err := dbUpdate(tx, tdef, &req); err != nil {
+			return res, err
+		}
+		if !req.Added {
+			res.Conflicts = append(res.Conflicts, Record{rec.Cols[:tdef.PKeys], rec.Vals[:tdef.PKeys]})
+			continue
+		}
+		res.Restored++
+	}
+	if _, err := dbDelete(tx, tdefMeta, *(&Record{}).AddStr("key", archiveMetaKey(table, name))); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// Segments lists the names of the archived segments of table.
+func (tx *DBReader) Segments(table string) ([]string, error) {
+	if getTableDef(tx, table) == nil {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	var names []string
+	err := scanSegments(tx, table, func(name string, _ segmentMeta) bool {
+		names = append(names, name)
+		return true
+	})
+	return names, err
+}
+
+// scanSegments calls fn for each segment of table recorded in @meta until
+// fn returns false.
+func scanSegments(tx *DBReader, table string, fn func(name string, meta segmentMeta) bool) error {
+	prefix := archiveMetaKey(table, "")
+	end := append(bytes.Clone(prefix[:len(prefix)-1]), '/'+1)
+	sc := Scanner{
+		Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("key", prefix),
+		Cmp2: btree.CmpLT, Key2: *(&Record{}).AddStr("key", end),
+	}
+	if err := dbScan(tx, tdefMeta, &sc); err != nil {
+		return err
+	}
+	var rec Record
+	for ; sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		var meta segmentMeta
+		if err := json.Unmarshal(rec.Get("val").Str, &meta); err != nil {
+			return err
+		}
+		if !fn(string(rec.Get("key").Str[len(prefix):]), meta) {
+			break
+		}
+	}
+	return nil
+}
+
+// archiveGet looks up the primary key in rec among the archived segments of
+// tdef, filling rec with the full row on a hit. Each candidate segment is
+// fetched from the store on every call: archived data is expected to be
+// read rarely.
+func archiveGet(tx *DBReader, tdef *TableDef, rec *Record) (bool, error) {
+	key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], rec.Vals[:tdef.PKeys])
+	_, row, found, err := archiveFind(tx, tdef, key)
+	if found {
+		*rec = decodeSegmentRow(tdef, row)
+	}
+	return found, err
+}
+
+// archiveFind returns the segment of tdef that holds the row with the
+// encoded primary key, and the row, skipping the segments where it is
+// hidden. A key is visible in one segment at most.
+func archiveFind(tx *DBReader, tdef *TableDef, key []byte) (string, segmentKV, bool, error) {
+	var seg string
+	var row segmentKV
+	var found bool
+	var ferr error
+	err := scanSegments(tx, tdef.Name, func(name string, meta segmentMeta) bool {
+		if bytes.Compare(key, meta.Lo) < 0 || bytes.Compare(key, meta.Hi) > 0 {
+			return true
+		}
+		if archiveHidden(tx, tdef.Name, name, key) {
+			return true
+		}
+		rows, err := loadSegment(tx.db.Segments, name)
+		if err != nil {
+			ferr = err
+			return false
+		}
+		i := sort.Search(len(rows), func(i int) bool { return bytes.Compare(rows[i].key, key) >= 0 })
+		if i < len(rows) && bytes.Equal(rows[i].key, key) {
+			seg, row, found = name, rows[i], true
+			return false
+		}
+		return true
+	})
+	if err == nil {
+		err = ferr
+	}
+	return seg, row, found, err
+}
+
+// archiveHidden reports whether the row with the encoded primary key is
+// hidden in segment name of table.
+func archiveHidden(tx *DBReader, table, name string, key []byte) bool {
+	ok, err := dbGet(tx, tdefMeta, (&Record{}).AddStr("key", archiveTombKey(table, name, key)))
+	assert(err == nil)
+	return ok
+}
+
+// archiveHide is called by dbDelete for the row with the encoded primary
+// key, deleted from the hot tier if hot is true. It hides the row in the
+// segments whose range covers the key, so that Get does not find an older,
+// archived version of it. If the row was not in the hot tier, it is hidden
+// in the segment that holds it, and archiveHide reports whether there was
+// one.
+func archiveHide(tx *DBTX, tdef *TableDef, key []byte, hot bool) (bool, error) {
+	if tdef.Prefix < tablePrefixMin {
+		return false, nil
+	}
+	var names []string
+	if hot {
+		err := scanSegments(&tx.DBReader, tdef.Name, func(name string, meta segmentMeta) bool {
+			if bytes.Compare(key, meta.Lo) >= 0 && bytes.Compare(key, meta.Hi) <= 0 {
+				names = append(names, name)
+			}
+			return true
+		})
+		if err != nil {
+			return false, err
+		}
+	} else if tx.db.Segments != nil {
+		name, _, found, err := archiveFind(&tx.DBReader, tdef, key)
+		if err != nil || !found {
+			return false, err
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		tomb := (&Record{}).AddStr("key", archiveTombKey(tdef.Name, name, key)).AddStr("val", nil)
+		if err := dbUpdate(tx, tdefMeta, &DBSetReq{Record: *tomb}); err != nil {
+			return false, err
+		}
+	}
+	return len(names) > 0, nil
+}
+
+func decodeSegmentRow(tdef *TableDef, kv segmentKV) Record {
+	vals := make([]Value, len(tdef.Cols))
+	for i, typ := range tdef.Types {
+		vals[i].Type = typ
+	}
+	decodeKey(tdef, tdef.Cols[:tdef.PKeys], kv.key[4:], vals[:tdef.PKeys])
+	decodeValues(kv.val, vals[tdef.PKeys:])
+	return Record{tdef.Cols, vals}
+}
+
+// ---------------------------------------------------------------------------
+// Segment encoding
+// ---------------------------------------------------------------------------
+
+// A segment is a gzip stream of rows sorted by key, each written as
+// uvarint(len(key)) key uvarint(len(val)) val.
+
+func encodeSegment(rows []segmentKV) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	var tmp [binary.MaxVarintLen64]byte
+	for _, kv := range rows {
+		for _, b := range [][]byte{kv.key, kv.val} {
+			n := binary.PutUvarint(tmp[:], uint64(len(b)))
+			zw.Write(tmp[:n])
+			zw.Write(b)
+		}
+	}
+	assert(zw.Close() == nil)
+	return buf.Bytes()
+}
+
+func loadSegment(store SegmentStore, name string) ([]segmentKV, error) {
+	if store == nil {
+		return nil, fmt.Errorf("no segment store configured")
+	}
+	data, err := store.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("load segment %s: %w", name, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("load segment %s: %w", name, err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("load segment %s: %w", name, err)
+	}
+
+	var rows []segmentKV
+	next := func() ([]byte, bool) {
+		n, sz := binary.Uvarint(raw)
+		if sz <= 0 || uint64(len(raw)-sz) < n {
+			return nil, false
+		}
+		b := raw[sz : sz+int(n)]
+		raw = raw[sz+int(n):]
+		return b, true
+	}
+	for len(raw) > 0 {
+		key, ok1 := next()
+		val, ok2 := next()
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("load segment %s: corrupt data", name)
+		}
+		rows = append(rows, segmentKV{key, val})
+	}
+	return rows, nil
+}
@@ -0,0 +1,236 @@
+package tables
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/kv"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestArchiveRestore(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	dir := t.TempDir()
+	tt.db.Segments = DirStore{Dir: dir}
+	tt.create(&TableDef{
+		Name:    "events",
+		Cols:    []string{"ts", "kind", "body"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"kind"}},
+	})
+	for i := int64(0); i < 100; i++ {
+		kind := "a"
+		if i%2 == 1 {
+			kind = "b"
+		}
+		tt.add("events", *(&Record{}).AddInt64("ts", i).AddStr("kind", []byte(kind)).AddStr("body", []byte("payload")))
+	}
+	count := func(sc Scanner) int {
+		r := DBReader{}
+		tt.db.BeginRead(&r)
+		defer tt.db.EndRead(&r)
+		is.NoError(t, r.Scan("events", &sc))
+		n := 0
+		for ; sc.Valid(); sc.Next() {
+			n++
+		}
+		return n
+	}
+	get := func(ts int64) (Record, bool) {
+		r := DBReader{}
+		tt.db.BeginRead(&r)
+		defer tt.db.EndRead(&r)
+		rec := *(&Record{}).AddInt64("ts", ts)
+		ok, err := r.Get("events", &rec)
+		is.NoError(t, err)
+		return rec, ok
+	}
+	byKind := Scanner{
+		Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("kind", []byte("b")),
+		Cmp2: btree.CmpLE, Key2: *(&Record{}).AddStr("kind", []byte("b")),
+	}
+
+	// Archive everything older than ts 60.
+	n, err := tt.db.Archive("events", "old", Scanner{
+		Cmp1: btree.CmpLT, Key1: *(&Record{}).AddInt64("ts", 60),
+	})
+	is.NoError(t, err)
+	is.Equal(t, 60, n)
+	is.Equal(t, 40, count(FullScan()))
+	is.Equal(t, 20, count(byKind))
+	path, err := DirStore{Dir: dir}.path("old")
+	is.NoError(t, err)
+	_, err = os.Stat(path)
+	is.NoError(t, err)
+
+	// Point reads still see archived rows.
+	rec, ok := get(7)
+	is.True(t, ok)
+	is.Equal(t, []byte("b"), rec.Get("kind").Str)
+	_, ok = get(1000)
+	is.False(t, ok)
+
+	_, err = tt.db.Archive("events", "old", FullScan())
+	is.ErrorContains(t, err, "segment exists")
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	names, err := r.Segments("events")
+	tt.db.EndRead(&r)
+	is.NoError(t, err)
+	is.Equal(t, []string{"old"}, names)
+
+	res, err := tt.db.Restore("events", "old")
+	is.NoError(t, err)
+	is.Equal(t, RestoreResult{Restored: 60}, res)
+	is.Equal(t, 100, count(FullScan()))
+	is.Equal(t, 50, count(byKind))
+	_, err = os.Stat(path)
+	is.True(t, os.IsNotExist(err))
+
+	_, err = tt.db.Restore("events", "old")
+	is.ErrorContains(t, err, "segment not found")
+}
+
+func TestArchiveDeleteAndConflicts(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.db.Segments = DirStore{Dir: t.TempDir()}
+	tt.create(&TableDef{
+		Name:  "kv",
+		Cols:  []string{"k", "v"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+	})
+	row := func(k int64, v string) Record {
+		return *(&Record{}).AddInt64("k", k).AddStr("v", []byte(v))
+	}
+	put := func(rec Record) {
+		is.NoError(t, tt.db.Update(func(tx *DBTX) error {
+			_, err := tx.Upsert("kv", rec)
+			return err
+		}))
+	}
+	for k := range int64(10) {
+		put(row(k, "old"))
+	}
+	get := func(k int64) string {
+		r := DBReader{}
+		tt.db.BeginRead(&r)
+		defer tt.db.EndRead(&r)
+		rec := *(&Record{}).AddInt64("k", k)
+		ok, err := r.Get("kv", &rec)
+		is.NoError(t, err)
+		if !ok {
+			return "<missing>"
+		}
+		return string(rec.Get("v").Str)
+	}
+	del := func(k int64) bool {
+		var deleted bool
+		is.NoError(t, tt.db.Update(func(tx *DBTX) (err error) {
+			deleted, err = tx.Delete("kv", *(&Record{}).AddInt64("k", k))
+			return err
+		}))
+		return deleted
+	}
+	n, err := tt.db.Archive("kv", "s1", FullScan())
+	is.NoError(t, err)
+	is.Equal(t, 10, n)
+
+	// Deleting a row that is only archived hides it.
+	is.True(t, del(1))
+	is.Equal(t, "<missing>", get(1))
+	is.False(t, del(1))
+
+	// A reused key wins over the archived row, and deleting it does not
+	// bring the archived row back.
+	put(row(2, "new"))
+	is.Equal(t, "new", get(2))
+	is.True(t, del(2))
+	is.Equal(t, "<missing>", get(2))
+
+	// Archiving a key again hides it in the older segment.
+	put(row(3, "newer"))
+	n, err = tt.db.Archive("kv", "s2", FullScan())
+	is.NoError(t, err)
+	is.Equal(t, 1, n)
+	is.Equal(t, "newer", get(3))
+
+	// Restoring keeps the hot rows of reused keys and reports them.
+	put(row(4, "hot"))
+	res, err := tt.db.Restore("kv", "s1")
+	is.NoError(t, err)
+	is.Equal(t, 6, res.Restored)
+	is.Equal(t, 3, res.Hidden) // 1, 2 and 3
+	is.Len(t, res.Conflicts, 1)
+	is.Equal(t, int64(4), res.Conflicts[0].Get("k").I64)
+	is.Equal(t, "hot", get(4))
+	is.Equal(t, "old", get(5))
+	is.Equal(t, "<missing>", get(1))
+	is.Equal(t, "<missing>", get(2))
+	is.Equal(t, "newer", get(3))
+	res, err = tt.db.Restore("kv", "s2")
+	is.NoError(t, err)
+	is.Equal(t, RestoreResult{Restored: 1}, res)
+	is.Equal(t, "newer", get(3))
+}
+
+func TestArchiveSegmentNames(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	dir := t.TempDir()
+	store := DirStore{Dir: filepath.Join(dir, "segs")}
+	tt.db.Segments = store
+	tt.create(&TableDef{Name: "kv", Cols: []string{"k"}, Types: []uint32{TypeInt64}, PKeys: 1})
+	tt.add("kv", *(&Record{}).AddInt64("k", 1))
+
+	for _, name := range []string{"", "../x", "a/b", `a\b`, ".."} {
+		_, err := tt.db.Archive("kv", name, FullScan())
+		is.ErrorContains(t, err, "bad segment name")
+		is.ErrorContains(t, store.Put(name, []byte("x")), "bad segment name")
+		_, err = store.Get(name)
+		is.Error(t, err)
+		is.Error(t, store.Delete(name))
+	}
+	entries, err := os.ReadDir(dir)
+	is.NoError(t, err)
+	is.Empty(t, entries)
+}
+
+// conflictStore makes the commit of Archive fail by committing a change to
+// the range being archived while the segment is written.
+type conflictStore struct {
+	DirStore
+	db *DB
+}
+
+func (s conflictStore) Put(name string, data []byte) error {
+	err := s.db.Update(func(tx *DBTX) error {
+		_, err := tx.Upsert("kv", *(&Record{}).AddInt64("k", 2))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.DirStore.Put(name, data)
+}
+
+func TestArchiveCommitFailure(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	dir := t.TempDir()
+	tt.db.Segments = conflictStore{DirStore{Dir: dir}, &tt.db}
+	tt.create(&TableDef{Name: "kv", Cols: []string{"k"}, Types: []uint32{TypeInt64}, PKeys: 1})
+	tt.add("kv", *(&Record{}).AddInt64("k", 1))
+
+	_, err := tt.db.Archive("kv", "s", FullScan())
+	is.ErrorIs(t, err, kv.ErrConflict)
+	entries, err := os.ReadDir(dir)
+	is.NoError(t, err)
+	is.Empty(t, entries)
+}
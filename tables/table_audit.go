@@ -0,0 +1,71 @@
+package tables
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Audit log
+// ---------------------------------------------------------------------------
+
+// Every row a transaction inserts, updates or deletes in a table created
+// with TableDef.Audit is recorded in the @audit internal table, in the same
+// transaction: who made the change (DBTX.User), when, the kind of change,
+// and the primary key of the row, as the JSON encoding of a Record. Unlike
+// @change, which is a bounded buffer of whole rows for consumers, the audit
+// log keeps every entry and is read like any other table, by scanning
+// "@audit" in sequence order or through its index on table and sequence.
+// The next sequence number is in @meta, under "audit", little-endian.
+
+// tdefAudit stores one row per change of an audited table.
+var tdefAudit = &TableDef{
+	Prefix:        11,
+	Name:          "@audit",
+	Types:         []uint32{TypeInt64, TypeInt64, TypeBytes, TypeBytes, TypeInt64, TypeBytes},
+	Cols:          []string{"seq", "time", "user", "table", "op", "key"},
+	PKeys:         1,
+	Indexes:       [][]string{{"table", "seq"}},
+	IndexPrefixes: []uint32{12},
+}
+
+func auditMetaKey() *Record {
+	return (&Record{}).AddStr("key", []byte("audit"))
+}
+
+// nextAuditSeq returns the sequence number of the next audit entry, from 1,
+// and advances it.
+func nextAuditSeq(tx *DBTX) (int64, error) {
+	rec := auditMetaKey()
+	ok, err := dbGet(&tx.DBReader, tdefMeta, rec)
+	assert(err == nil)
+	seq := uint64(1)
+	if ok {
+		seq = binary.LittleEndian.Uint64(rec.Get("val").Str)
+	}
+	val := binary.LittleEndian.AppendUint64(nil, seq+1)
+	err = dbUpdate(tx, tdefMeta, &DBSetReq{Record: *auditMetaKey().AddStr("val", val)})
+	return int64(seq), err
+}
+
+// recordAudit appends an entry for a change of the row with primary key pk
+// to the audit log, if tdef has Audit set.
+func recordAudit(tx *DBTX, tdef *TableDef, op ChangeOp, pk []Value) error {
+	if !tdef.Audit {
+		return nil
+	}
+	key, err := json.Marshal(Record{tdef.Cols[:tdef.PKeys], pk})
+	assert(err == nil)
+	seq, err := nextAuditSeq(tx)
+	if err != nil {
+		return err
+	}
+	rec := (&Record{}).AddInt64("seq", seq).
+		AddInt64("time", time.Now().UnixNano()).
+		AddStr("user", []byte(tx.User)).
+		AddStr("table", []byte(tdef.Name)).
+		AddInt64("op", int64(op)).
+		AddStr("key", key)
+	return dbUpdate(tx, tdefAudit, &DBSetReq{Record: *rec})
+}
@@ -0,0 +1,93 @@
+package tables
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "v"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+		Audit: true,
+	})
+	tt.create(&TableDef{
+		Name:  "plain",
+		Cols:  []string{"id", "v"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+	})
+	row := func(id int64, v string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("v", []byte(v))
+	}
+
+	tx := DBTX{User: "alice"}
+	tt.db.Begin(&tx)
+	for _, table := range []string{"t", "plain"} {
+		_, err := tx.Insert(table, row(1, "a"))
+		is.NoError(t, err)
+		_, err = tx.Insert(table, row(2, "a"))
+		is.NoError(t, err)
+		_, err = tx.Update(table, row(1, "b"))
+		is.NoError(t, err)
+		_, err = tx.Update(table, row(2, "a")) // unchanged: not recorded
+		is.NoError(t, err)
+	}
+	is.NoError(t, tt.db.Commit(&tx))
+
+	tx = DBTX{User: "bob"}
+	tt.db.Begin(&tx)
+	ok, err := tx.Delete("t", *(&Record{}).AddInt64("id", 2))
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// The log reads like any other table, here through its table index.
+	type entry struct {
+		seq   int64
+		user  string
+		op    ChangeOp
+		id    int64
+		table string
+	}
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	key := *(&Record{}).AddStr("table", []byte("t"))
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key, Key2: key}
+	is.NoError(t, r.Scan("@audit", &sc))
+	var got []entry
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		is.NotZero(t, rec.Get("time").I64)
+		var pk Record
+		is.NoError(t, json.Unmarshal(rec.Get("key").Str, &pk))
+		got = append(got, entry{
+			rec.Get("seq").I64, string(rec.Get("user").Str), ChangeOp(rec.Get("op").I64),
+			pk.Get("id").I64, string(rec.Get("table").Str),
+		})
+	}
+	is.Equal(t, []entry{
+		{1, "alice", ChangeInsert, 1, "t"},
+		{2, "alice", ChangeInsert, 2, "t"},
+		{3, "alice", ChangeUpdate, 1, "t"},
+		{4, "bob", ChangeDelete, 2, "t"},
+	}, got)
+
+	// Nothing else was recorded.
+	all := Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddInt64("seq", 0)}
+	is.NoError(t, r.Scan("@audit", &all))
+	n := 0
+	for ; all.Valid(); all.Next() {
+		n++
+	}
+	is.Equal(t, 4, n)
+}
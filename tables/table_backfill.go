@@ -0,0 +1,165 @@
+package tables
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Column backfill
+// ---------------------------------------------------------------------------
+
+// DefaultBackfillBatch is the number of rows rewritten per transaction when
+// BackfillReq.BatchSize is zero.
+const DefaultBackfillBatch = 256
+
+// BackfillReq describes a column backfill run by DB.Backfill.
+type BackfillReq struct {
+	Table string
+	Col   string                 // a non-key column of Table
+	Fn    func(rec Record) Value // computes Col from the full current row
+
+	BatchSize int            // rows per transaction (0 = DefaultBackfillBatch)
+	Pause     time.Duration  // sleep between batches to throttle the rewrite
+	Progress  func(rows int) // called after each committed batch with the running total
+}
+
+// BackfillColumn sets col to fn(row) for every row of table, using default
+// batching. See Backfill.
+func (db *DB) BackfillColumn(table, col string, fn func(Record) Value) error {
+	return db.Backfill(&BackfillReq{Table: table, Col: col, Fn: fn})
+}
+
+// Backfill rewrites req.Col of every row of req.Table in primary-key order.
+// Each batch is its own short transaction, so concurrent writers are never
+// blocked for longer than one batch; a batch that loses an OCC conflict is
+// retried. The primary key of the last rewritten row is checkpointed in
+// @meta together with the batch, so a backfill that is interrupted (error,
+// crash) resumes where it stopped when called again with the same table and
+// column. The checkpoint is removed once the table has been fully processed.
+func (db *DB) Backfill(req *BackfillReq) error {
+	batch := req.BatchSize
+	if batch <= 0 {
+		batch = DefaultBackfillBatch
+	}
+
+	total := 0
+	for {
+		n, size, done, err := db.backfillBatchRetry(req, batch)
+		if err != nil {
+			return err
+		}
+		db.Maintenance.Wait(n, int64(size))
+		total += n
+		if req.Progress != nil && n > 0 {
+			req.Progress(total)
+		}
+		if done {
+			return nil
+		}
+		if req.Pause > 0 {
+			time.Sleep(req.Pause)
+		}
+	}
+}
+
+func (db *DB) backfillBatchRetry(req *BackfillReq, batch int) (int, int, bool, error) {
+	var n, size int
+	var done bool
+	err := db.Update(func(tx *DBTX) (err error) {
+		n, size, done, err = backfillBatch(tx, req, batch)
+		return err
+	})
+	return n, size, done, err
+}
+
+// backfillBatch rewrites up to batch rows after the checkpoint and advances
+// it. It returns the number of rows and of encoded row bytes rewritten; done
+// is true once the end of the table has been reached.
+func backfillBatch(tx *DBTX, req *BackfillReq, batch int) (int, int, bool, error) {
+	tdef := getTableDef(&tx.DBReader, req.Table)
+	if tdef == nil {
+		return 0, 0, false, fmt.Errorf("table not found: %s", req.Table)
+	}
+	idx := ColIndex(tdef, req.Col)
+	if idx < 0 {
+		return 0, 0, false, fmt.Errorf("unknown column: %s", req.Col)
+	}
+	if idx < tdef.PKeys {
+		return 0, 0, false, fmt.Errorf("cannot backfill primary-key column: %s", req.Col)
+	}
+
+	// Resume after the checkpointed primary key, if any.
+	ckptKey := []byte("backfill:" + req.Table + "." + req.Col)
+	ckpt := (&Record{}).AddStr("key", ckptKey)
+	resume, err := dbGet(&tx.DBReader, tdefMeta, ckpt)
+	assert(err == nil)
+	var after []byte
+	if resume {
+		after = ckpt.Get("val").Str
+	}
+	sc := resumeScanner(tdef, after, false)
+	if err := dbScan(&tx.DBReader, tdef, &sc); err != nil {
+		return 0, 0, false, err
+	}
+	rows := collectBatch(&sc, batch)
+	done := !sc.Valid()
+
+	size := 0
+	for _, rec := range rows {
+		v := req.Fn(rec)
+		if v.Type != tdef.Types[idx] {
+			return 0, 0, false, fmt.Errorf("bad column type: %s", req.Col)
+		}
+		rec.Vals[idx] = v
+		if err := dbUpdate(tx, tdef, &DBSetReq{Record: rec, Mode: btree.ModeUpdateOnly}); err != nil {
+			return 0, 0, false, err
+		}
+		size += len(encodeValues(nil, rec.Vals))
+	}
+
+	if done {
+		if resume {
+			_, err := dbDelete(tx, tdefMeta, *(&Record{}).AddStr("key", ckptKey))
+			assert(err == nil)
+		}
+		return len(rows), size, true, nil
+	}
+	last := rows[len(rows)-1]
+	ckpt = (&Record{}).AddStr("key", ckptKey).
+		AddStr("val", encodeValues(nil, last.Vals[:tdef.PKeys]))
+	return len(rows), size, false, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+}
+
+// resumeScanner returns a scanner of the rows of tdef in primary-key order,
+// for a batch job that resumes after the row whose primary key last holds,
+// as encoded by encodeValues; an empty last starts at the first row. A raw
+// scanner also yields expired rows.
+func resumeScanner(tdef *TableDef, last []byte, raw bool) Scanner {
+	if len(last) == 0 {
+		return Scanner{Cmp1: btree.CmpGE, raw: raw}
+	}
+	pk := make([]Value, tdef.PKeys)
+	for i := range pk {
+		pk[i].Type = tdef.Types[i]
+	}
+	decodeValues(last, pk)
+	return Scanner{Cmp1: btree.CmpGT, Key1: Record{tdef.Cols[:tdef.PKeys], pk}, raw: raw, exact: true}
+}
+
+// collectBatch reads up to n rows from sc and leaves it on the row after
+// them. Batch jobs collect their rows first, so that they don't change the
+// tree under the scan.
+func collectBatch(sc *Scanner, n int) []Record {
+	var rows []Record
+	for ; sc.Valid() && len(rows) < n; sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		rec.Cols = slices.Clone(rec.Cols)
+		rows = append(rows, rec)
+	}
+	return rows
+}
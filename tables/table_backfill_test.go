@@ -0,0 +1,111 @@
+package tables
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/throttle"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "v", "w"},
+		Types: []uint32{TypeInt64, TypeInt64, TypeInt64},
+		PKeys: 1,
+	})
+	for i := int64(1); i <= 10; i++ {
+		tt.add("t", *(&Record{}).AddInt64("id", i).AddInt64("v", i).AddInt64("w", 0))
+	}
+
+	// Fail part-way through: the batches before the failure stay committed.
+	calls := 0
+	err := tt.db.Backfill(&BackfillReq{
+		Table: "t", Col: "w", BatchSize: 3,
+		Fn: func(rec Record) Value {
+			calls++
+			if rec.Get("id").I64 == 7 {
+				return Value{Type: TypeBytes}
+			}
+			return Value{Type: TypeInt64, I64: rec.Get("v").I64 * 2}
+		},
+	})
+	is.ErrorContains(t, err, "bad column type")
+	is.Equal(t, 7, calls)
+
+	// Resuming starts after the last committed batch.
+	calls = 0
+	var progress []int
+	err = tt.db.Backfill(&BackfillReq{
+		Table: "t", Col: "w", BatchSize: 3,
+		Fn: func(rec Record) Value {
+			calls++
+			return Value{Type: TypeInt64, I64: rec.Get("v").I64 * 2}
+		},
+		Progress: func(n int) { progress = append(progress, n) },
+	})
+	is.NoError(t, err)
+	is.Equal(t, 4, calls)
+	is.Equal(t, []int{3, 4}, progress)
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	for i := int64(1); i <= 10; i++ {
+		rec := *(&Record{}).AddInt64("id", i)
+		ok, err := r.Get("t", &rec)
+		is.NoError(t, err)
+		is.True(t, ok)
+		is.Equal(t, i*2, rec.Get("w").I64)
+	}
+	tt.db.EndRead(&r)
+
+	// With the checkpoint cleared, a new backfill covers the whole table.
+	calls = 0
+	err = tt.db.BackfillColumn("t", "w", func(rec Record) Value {
+		calls++
+		return Value{Type: TypeInt64, I64: 0}
+	})
+	is.NoError(t, err)
+	is.Equal(t, 10, calls)
+
+	is.Error(t, tt.db.BackfillColumn("t", "id", nil))
+	is.Error(t, tt.db.BackfillColumn("t", "nope", nil))
+}
+
+func TestMaintenanceThrottle(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "v"},
+		Types: []uint32{TypeInt64, TypeInt64},
+		PKeys: 1,
+	})
+	for i := int64(1); i <= 100; i++ {
+		tt.add("t", *(&Record{}).AddInt64("id", i).AddInt64("v", i))
+	}
+	tt.db.Maintenance = throttle.New(0, 400)
+
+	// Backfill is charged one operation per row: 100 rows at 400/s.
+	start := time.Now()
+	err := tt.db.Backfill(&BackfillReq{
+		Table: "t", Col: "v", BatchSize: 10,
+		Fn: func(rec Record) Value { return Value{Type: TypeInt64, I64: 0} },
+	})
+	is.NoError(t, err)
+	is.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	st := tt.db.Maintenance.Stats()
+	is.Equal(t, int64(100), st.Ops)
+	is.Positive(t, st.Bytes)
+	is.Positive(t, st.Throttled)
+
+	// Dumps are charged by the bytes they write.
+	tt.db.Maintenance = throttle.New(0, 0)
+	var buf bytes.Buffer
+	is.NoError(t, tt.db.Dump(&buf))
+	is.Equal(t, int64(buf.Len()), tt.db.Maintenance.Stats().Bytes)
+}
@@ -0,0 +1,82 @@
+package tables
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Batch insert
+// ---------------------------------------------------------------------------
+
+// ErrDuplicate is the error InsertMany reports for a row whose primary key
+// already exists, in the table or earlier in the batch.
+var ErrDuplicate = errors.New("duplicate primary key")
+
+// InsertMany inserts the rows recs into table in one transaction, so with
+// one commit and one fsync rather than one per row. Every row is checked
+// and encoded before any is written.
+//
+// A row that cannot be inserted is skipped, and its error is reported at
+// its position in the returned slice: a row that does not fit the table, a
+// duplicate primary key (ErrDuplicate), or a row over the table's quota
+// (ErrQuotaExceeded). The slice is nil when every row was inserted. The
+// error is for the batch as a whole, such as a missing table or a failed
+// commit, in which case no row was inserted.
+func (db *DB) InsertMany(table string, recs []Record) ([]error, error) {
+	var errs []error
+	err := db.Update(func(tx *DBTX) (err error) {
+		errs, err = tx.InsertMany(table, recs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// InsertMany is DB.InsertMany within the transaction. After an error for
+// the batch as a whole, some rows may have been written, and the
+// transaction should be aborted.
+func (tx *DBTX) InsertMany(table string, recs []Record) ([]error, error) {
+	tdef := getTableDef(&tx.DBReader, table)
+	if tdef == nil {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	tx.db.stats.sets.Add(uint64(len(recs)))
+	var errs []error
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(recs))
+		}
+		errs[i] = fmt.Errorf("row %d: %w", i, err)
+	}
+
+	rows := make([]encodedRow, len(recs))
+	for i, rec := range recs {
+		row, err := encodeRow(tx.db, tdef, rec)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		rows[i] = row
+	}
+	for i, row := range rows {
+		if row.key == nil {
+			continue // failed to encode
+		}
+		req := DBSetReq{Record: recs[i], Mode: btree.ModeInsertOnly}
+		err := dbWrite(tx, tdef, &req, row)
+		switch {
+		case errors.Is(err, ErrQuotaExceeded):
+			fail(i, err) // checked before anything is written
+		case err != nil:
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		case !req.Added:
+			fail(i, ErrDuplicate)
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,86 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestInsertMany(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "name", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"name"}},
+	})
+	row := func(id int64, name string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("name", []byte(name)).AddInt64("v", id*10)
+	}
+	tt.add("t", row(1, "old"))
+
+	commits := tt.db.Metrics().KV.Commits
+	errs, err := tt.db.InsertMany("t", []Record{
+		row(2, "b"),
+		row(1, "dup"), // already in the table
+		*(&Record{}).AddInt64("id", 3).AddStr("name", []byte("c")), // no v
+		row(4, "d"),
+		row(4, "dd"), // earlier in the batch
+		*(&Record{}).AddInt64("id", 5).AddInt64("name", 5).AddInt64("v", 5), // bad type
+	})
+	is.NoError(t, err)
+	is.Equal(t, commits+1, tt.db.Metrics().KV.Commits)
+	is.Len(t, errs, 6)
+	is.NoError(t, errs[0])
+	is.ErrorIs(t, errs[1], ErrDuplicate)
+	is.Error(t, errs[2])
+	is.NoError(t, errs[3])
+	is.ErrorIs(t, errs[4], ErrDuplicate)
+	is.Error(t, errs[5])
+	is.Contains(t, errs[5].Error(), "row 5")
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	n, err := r.Count("t")
+	is.NoError(t, err)
+	is.Equal(t, int64(3), n)
+	for id, name := range map[int64]string{1: "old", 2: "b", 4: "d"} {
+		rec := (&Record{}).AddStr("name", []byte(name))
+		sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: *rec, Key2: *rec}
+		is.NoError(t, r.Scan("t", &sc))
+		is.True(t, sc.Valid())
+		got := Record{}
+		sc.Deref(&got)
+		is.Equal(t, id, got.Get("id").I64)
+	}
+	tt.db.EndRead(&r)
+
+	// Every row inserted.
+	errs, err = tt.db.InsertMany("t", []Record{row(6, "f"), row(7, "g")})
+	is.NoError(t, err)
+	is.Nil(t, errs)
+
+	_, err = tt.db.InsertMany("nope", []Record{row(8, "h")})
+	is.Error(t, err)
+
+	// The rows past the quota are skipped; the others are kept.
+	tt.create(&TableDef{Name: "q", Cols: []string{"id", "v"}, Types: []uint32{TypeInt64, TypeBytes}, PKeys: 1, Quota: 100})
+	var recs []Record
+	for i := range 5 {
+		recs = append(recs, *(&Record{}).AddInt64("id", int64(i)).AddStr("v", []byte("xxxxxxxxxxxxxxxxxxxx")))
+	}
+	errs, err = tt.db.InsertMany("q", recs)
+	is.NoError(t, err)
+	is.NoError(t, errs[2])
+	is.ErrorIs(t, errs[3], ErrQuotaExceeded)
+	is.ErrorIs(t, errs[4], ErrQuotaExceeded)
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	n, err = r.Count("q")
+	is.NoError(t, err)
+	is.Equal(t, int64(3), n)
+}
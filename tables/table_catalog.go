@@ -0,0 +1,172 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Index catalog
+// ---------------------------------------------------------------------------
+
+// The @index internal table holds one row per secondary index of every user
+// table: its table, its position in TableDef.Indexes, its columns (as a
+// JSON list, ending with the primary-key columns every entry carries),
+// whether it is unique, and its key prefix. It is written with the table
+// definition, by every change that puts one in @table, so it is always in
+// step with it, and it is read like any other table. DBReader.Indexes
+// returns the indexes of a table from it, through a cache on the DB that
+// the commits changing a definition invalidate, like that of @table.
+
+// ErrUnique is returned (wrapped) by a write that would give two rows the
+// same values in the columns of a unique index (see TableDef.Unique).
+var ErrUnique = errors.New("unique constraint violated")
+
+// tdefIndex stores one row per secondary index of a user table.
+var tdefIndex = &TableDef{
+	Prefix: 13,
+	Name:   "@index",
+	Types:  []uint32{TypeBytes, TypeInt64, TypeBytes, TypeInt64, TypeInt64},
+	Cols:   []string{"table", "index", "cols", "unique", "prefix"},
+	PKeys:  2,
+}
+
+// IndexDef describes a secondary index, as the @index catalog holds it.
+type IndexDef struct {
+	Table  string
+	Index  int      // the position of the index in TableDef.Indexes
+	Cols   []string // as in TableDef.Indexes
+	Unique bool     // see TableDef.Unique
+	Prefix uint32
+}
+
+func indexDefs(tdef *TableDef) []IndexDef {
+	defs := make([]IndexDef, len(tdef.Indexes))
+	for i, cols := range tdef.Indexes {
+		defs[i] = IndexDef{tdef.Name, i, cols, tdef.unique(i), tdef.IndexPrefixes[i]}
+	}
+	return defs
+}
+
+func indexCatalogKey(table string, i int) *Record {
+	return (&Record{}).AddStr("table", []byte(table)).AddInt64("index", int64(i))
+}
+
+// putIndexDefs brings the rows of tdef in @index in line with its
+// definition.
+func putIndexDefs(tx *DBTX, tdef *TableDef) error {
+	for _, def := range indexDefs(tdef) {
+		cols, err := json.Marshal(def.Cols)
+		assert(err == nil)
+		unique := int64(0)
+		if def.Unique {
+			unique = 1
+		}
+		rec := indexCatalogKey(def.Table, def.Index).AddStr("cols", cols).
+			AddInt64("unique", unique).AddInt64("prefix", int64(def.Prefix))
+		if err := dbUpdate(tx, tdefIndex, &DBSetReq{Record: *rec}); err != nil {
+			return err
+		}
+	}
+	// Drop the rows of indexes the table no longer has.
+	for i := len(tdef.Indexes); ; i++ {
+		ok, err := dbDelete(tx, tdefIndex, *indexCatalogKey(tdef.Name, i))
+		if !ok || err != nil {
+			return err
+		}
+	}
+}
+
+// Indexes returns the secondary indexes of table, in the order of
+// TableDef.Indexes, or nil if it has none or does not exist.
+func (tx *DBReader) Indexes(table string) []IndexDef {
+	db := tx.db
+	version := tx.kvr.(interface{ Version() uint64 }).Version()
+	db.mu.Lock()
+	defs, ok := db.indexes[table]
+	ok = ok && db.defsCurrent(version) && !tx.ownDefs
+	db.mu.Unlock()
+	if ok {
+		return defs
+	}
+
+	defs = getIndexDefsFromDisk(tx, table)
+	db.mu.Lock()
+	if db.indexes == nil {
+		db.indexes = map[string][]IndexDef{}
+	}
+	if db.defsCurrent(version) && !tx.ownDefs {
+		db.indexes[table] = defs
+	}
+	db.mu.Unlock()
+	return defs
+}
+
+func getIndexDefsFromDisk(tx *DBReader, table string) []IndexDef {
+	key := *(&Record{}).AddStr("table", []byte(table))
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key, Key2: key}
+	err := dbScan(tx, tdefIndex, &sc)
+	assert(err == nil)
+	var defs []IndexDef
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		def := IndexDef{
+			Table:  table,
+			Index:  int(rec.Get("index").I64),
+			Unique: rec.Get("unique").I64 != 0,
+			Prefix: uint32(rec.Get("prefix").I64),
+		}
+		err := json.Unmarshal(rec.Get("cols").Str, &def.Cols)
+		assert(err == nil)
+		defs = append(defs, def)
+	}
+	if defs == nil {
+		// A table created before the catalog has no rows in it until its
+		// definition next changes.
+		if tdef := getTableDefFromDisk(tx, table); tdef != nil && len(tdef.Indexes) > 0 {
+			defs = indexDefs(tdef)
+		}
+	}
+	return defs
+}
+
+// checkUnique fails with ErrUnique if the write of row to tdef would give
+// it the values of another row in the columns of a unique index.
+func checkUnique(tx *DBTX, tdef *TableDef, dbreq *DBSetReq, row encodedRow) error {
+	if !slices.Contains(tdef.Unique, true) {
+		return nil
+	}
+	if dbreq.Mode != btree.ModeUpsert {
+		// Nothing is written if the row exists, or if it does not.
+		if _, exists := tx.kvr.Get(row.key); exists == (dbreq.Mode == btree.ModeInsertOnly) {
+			return nil
+		}
+	}
+	rec := Record{tdef.Cols, row.values}
+	for i, index := range tdef.Indexes {
+		if !tdef.unique(i) || !tdef.indexed(i, rec) {
+			continue
+		}
+		// The entry of any row with the same values starts with prefix,
+		// and only that of the row itself is key.
+		key, _ := indexEntry(tdef, i, rec)
+		cols := index[:uniqueCols(tdef, i)]
+		prefix := encodeKeyCols(nil, tdef.IndexPrefixes[i], tdef, cols, pickValues(rec, cols))
+		for it := tx.kvr.Seek(prefix, btree.CmpGE); it.Valid(); it.Next() {
+			k, _ := it.Deref()
+			if !bytes.HasPrefix(k, prefix) {
+				break
+			}
+			if !bytes.Equal(k, key) {
+				return fmt.Errorf("%w: %s%v", ErrUnique, tdef.Name, cols)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestIndexCatalog(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "email", "tag", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}, {"email"}},
+		Unique:  []bool{false, true},
+	})
+	tt.create(&TableDef{Name: "plain", Cols: []string{"id"}, Types: []uint32{TypeInt64}, PKeys: 1})
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	is.Equal(t, []IndexDef{
+		{"t", 0, []string{"tag", "id"}, false, 101},
+		{"t", 1, []string{"email", "id"}, true, 102},
+	}, r.Indexes("t"))
+	is.Nil(t, r.Indexes("plain"))
+	is.Nil(t, r.Indexes("nope"))
+	tt.db.EndRead(&r)
+
+	// The catalog follows changes of the definition.
+	is.NoError(t, tt.db.IndexAdd(&IndexReq{Table: "t", Index: []string{"v"}}))
+	tt.db.BeginRead(&r)
+	defs := r.Indexes("t")
+	is.Len(t, defs, 3)
+	is.Equal(t, IndexDef{"t", 2, []string{"v", "id"}, false, r.TableDef("t").IndexPrefixes[2]}, defs[2])
+	sc := Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("table", nil)}
+	is.NoError(t, r.Scan("@index", &sc))
+	n := 0
+	for ; sc.Valid(); sc.Next() {
+		n++
+	}
+	is.Equal(t, 3, n)
+	tt.db.EndRead(&r)
+
+	// A unique index needs a column besides the primary key, listed first.
+	for _, index := range [][]string{{"id"}, {"id", "email"}} {
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		err := tx.TableNew(&TableDef{
+			Name: "bad", Cols: []string{"id", "email", "v"}, Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+			PKeys: 1, Indexes: [][]string{index}, Unique: []bool{true},
+		})
+		is.Error(t, err)
+		tt.db.Abort(&tx)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "email", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}},
+		Unique:  []bool{true},
+		Where:   [][]Cond{{{Col: "v", Cmp: ">=", Val: Value{Type: TypeInt64}}}},
+	})
+	row := func(id int64, email string, v int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("email", []byte(email)).AddInt64("v", v)
+	}
+	write := func(fn func(tx *DBTX) error) error {
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		if err := fn(&tx); err != nil {
+			tt.db.Abort(&tx)
+			return err
+		}
+		return tt.db.Commit(&tx)
+	}
+	insert := func(rec Record) error {
+		return write(func(tx *DBTX) error { _, err := tx.Insert("t", rec); return err })
+	}
+
+	is.NoError(t, insert(row(1, "a", 0)))
+	is.NoError(t, insert(row(2, "ab", 0)))
+	is.ErrorIs(t, insert(row(3, "a", 0)), ErrUnique)
+	// Only the rows of a partial index must be unique.
+	is.NoError(t, insert(row(3, "a", -1)))
+	is.ErrorIs(t, write(func(tx *DBTX) error { _, err := tx.Update("t", row(3, "a", 1)); return err }), ErrUnique)
+
+	// A row may keep its own value, and an insert of an existing row is
+	// no write at all.
+	is.NoError(t, write(func(tx *DBTX) error { _, err := tx.Update("t", row(1, "a", 5)); return err }))
+	is.NoError(t, write(func(tx *DBTX) error {
+		ok, err := tx.Insert("t", row(2, "a", 0))
+		is.False(t, ok)
+		return err
+	}))
+
+	// A value is free again once its row moves away from it.
+	is.NoError(t, write(func(tx *DBTX) error {
+		if _, err := tx.Update("t", row(1, "c", 5)); err != nil {
+			return err
+		}
+		_, err := tx.Upsert("t", row(4, "a", 0))
+		return err
+	}))
+	is.ErrorIs(t, write(func(tx *DBTX) error { _, err := tx.Upsert("t", row(5, "c", 0)); return err }), ErrUnique)
+}
@@ -0,0 +1,295 @@
+package tables
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+)
+
+// ---------------------------------------------------------------------------
+// Change data capture
+// ---------------------------------------------------------------------------
+
+// With DB.ChangeLog set, every row a transaction inserts, updates or deletes
+// in a user table is recorded in the @change internal table, in the same
+// transaction, under the next change sequence number. The table is a ring
+// buffer: only the last ChangeLog changes are kept. Since the records are
+// ordinary rows they survive restarts and reach replicas, and a consumer
+// that remembers the last sequence number it handled resumes with
+// DB.Changes.
+//
+// A change is stored as the JSON encoding of a changeEntry, cut into parts
+// of changePart bytes so that a change of two large rows still fits in B-tree
+// values. The range of sequence numbers kept is in @meta, under "changes":
+// the first one kept and the next one to assign, little-endian.
+
+// ErrChangesLost is returned (wrapped) by DB.Changes, and by a ChangeStream's
+// Err, when changes a consumer asked for were already dropped from the ring
+// buffer.
+var ErrChangesLost = errors.New("changes no longer kept")
+
+// ChangeOp is the kind of a Change.
+type ChangeOp int
+
+const (
+	ChangeInsert ChangeOp = 1
+	ChangeUpdate ChangeOp = 2
+	ChangeDelete ChangeOp = 3
+)
+
+// Change is one row change of a committed transaction.
+type Change struct {
+	// Seq numbers the changes from 1: those of a transaction follow each
+	// other in the order it made them, and transactions in commit order.
+	Seq   uint64
+	Table string
+	Op    ChangeOp
+	Old   *Record // the row before the change; nil for ChangeInsert
+	New   *Record // the row after the change; nil for ChangeDelete
+}
+
+type changeEntry struct {
+	Table string
+	Op    ChangeOp
+	Old   *Record `json:",omitempty"`
+	New   *Record `json:",omitempty"`
+}
+
+// changePart is the size of the parts a change is stored in; it leaves room
+// for escaping in the B-tree value.
+const changePart = 1024
+
+// changeBatch is the number of changes a ChangeStream reads per snapshot.
+const changeBatch = 256
+
+// tdefChange stores the parts of each change, by sequence number.
+var tdefChange = &TableDef{
+	Prefix: 4,
+	Name:   "@change",
+	Types:  []uint32{TypeInt64, TypeInt64, TypeBytes},
+	Cols:   []string{"seq", "part", "data"},
+	PKeys:  2,
+}
+
+// changePos is the range of sequence numbers kept: first to next-1.
+type changePos struct {
+	first, next uint64
+}
+
+func changeMetaKey() *Record {
+	return (&Record{}).AddStr("key", []byte("changes"))
+}
+
+func getChangePos(tx *DBReader) changePos {
+	rec := changeMetaKey()
+	ok, err := dbGet(tx, tdefMeta, rec)
+	assert(err == nil)
+	if !ok {
+		return changePos{1, 1}
+	}
+	val := rec.Get("val").Str
+	return changePos{binary.LittleEndian.Uint64(val), binary.LittleEndian.Uint64(val[8:])}
+}
+
+func setChangePos(tx *DBTX, pos changePos) error {
+	val := binary.LittleEndian.AppendUint64(nil, pos.first)
+	val = binary.LittleEndian.AppendUint64(val, pos.next)
+	return dbUpdate(tx, tdefMeta, &DBSetReq{Record: *changeMetaKey().AddStr("val", val)})
+}
+
+// recordChange appends a change of tdef to the ring buffer, if DB.ChangeLog
+// is set and tdef is a user table, and drops the oldest changes past
+// ChangeLog. old and new are the rows in tdef's column order, nil when
+// absent; they are encoded before recordChange returns.
+func recordChange(tx *DBTX, tdef *TableDef, op ChangeOp, old, new []Value) error {
+	if tx.db.ChangeLog <= 0 || tdef.Prefix < tablePrefixMin {
+		return nil
+	}
+	entry := changeEntry{Table: tdef.Name, Op: op}
+	if old != nil {
+		entry.Old = &Record{tdef.Cols, old}
+	}
+	if new != nil {
+		entry.New = &Record{tdef.Cols, new}
+	}
+	data, err := json.Marshal(entry)
+	assert(err == nil)
+
+	pos := getChangePos(&tx.DBReader)
+	for part := int64(0); len(data) > 0; part++ {
+		n := min(len(data), changePart)
+		rec := (&Record{}).AddInt64("seq", int64(pos.next)).AddInt64("part", part).AddStr("data", data[:n])
+		if err := dbUpdate(tx, tdefChange, &DBSetReq{Record: *rec}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	pos.next++
+	for pos.next-pos.first > uint64(tx.db.ChangeLog) {
+		if err := dropChange(tx, pos.first); err != nil {
+			return err
+		}
+		pos.first++
+	}
+	return setChangePos(tx, pos)
+}
+
+// dropChange deletes the parts of change seq.
+func dropChange(tx *DBTX, seq uint64) error {
+	key := *(&Record{}).AddInt64("seq", int64(seq))
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key, Key2: key}
+	if err := dbScan(&tx.DBReader, tdefChange, &sc); err != nil {
+		return err
+	}
+	// Collect the parts first to avoid mutating while iterating.
+	var parts []Record
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		parts = append(parts, Record{rec.Cols[:2], rec.Vals[:2]})
+	}
+	for _, rec := range parts {
+		if _, err := dbDelete(tx, tdefChange, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readChanges returns up to limit changes after since.
+func readChanges(tx *DBReader, since uint64, limit int) ([]Change, error) {
+	pos := getChangePos(tx)
+	if since+1 < pos.first {
+		return nil, fmt.Errorf("%w: asked from %d, oldest is %d", ErrChangesLost, since+1, pos.first)
+	}
+	sc := Scanner{Cmp1: btree.CmpGT, Key1: *(&Record{}).AddInt64("seq", int64(since))}
+	if err := dbScan(tx, tdefChange, &sc); err != nil {
+		return nil, err
+	}
+	var out []Change
+	var data []byte
+	seq := uint64(0)
+	flush := func() error {
+		entry := changeEntry{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("change %d: %w", seq, err)
+		}
+		out = append(out, Change{seq, entry.Table, entry.Op, entry.Old, entry.New})
+		data = data[:0]
+		return nil
+	}
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		s := uint64(rec.Get("seq").I64)
+		if s != seq && seq != 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if len(out) == limit {
+				return out, nil
+			}
+		}
+		seq = s
+		data = append(data, rec.Get("data").Str...)
+	}
+	if seq != 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// ChangeStream delivers the changes recorded after a sequence number, in
+// order, as transactions commit. See DB.Changes.
+type ChangeStream struct {
+	// C receives the changes. It is closed by Close, or when the stream
+	// fails; Err then tells why.
+	C <-chan Change
+
+	c        chan Change
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// Changes subscribes to the changes recorded after since (0 for all those
+// kept); see DB.ChangeLog. The stream first delivers the recorded changes,
+// then those of each transaction as it commits, until Close. It fails with
+// ErrChangesLost when the consumer falls so far behind that the ring buffer
+// drops changes it has not received. Close the stream before the database.
+func (db *DB) Changes(since uint64) (*ChangeStream, error) {
+	// Subscribe first, so that no commit goes unnoticed after the first read.
+	sub := db.Events().Subscribe(1, events.Commit)
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	pos := getChangePos(&tx)
+	db.EndRead(&tx)
+	if since == 0 {
+		since = pos.first - 1
+	}
+	if since+1 < pos.first {
+		sub.Close()
+		return nil, fmt.Errorf("%w: asked from %d, oldest is %d", ErrChangesLost, since+1, pos.first)
+	}
+	c := make(chan Change)
+	s := &ChangeStream{C: c, c: c, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run(db, sub, since)
+	return s, nil
+}
+
+func (s *ChangeStream) run(db *DB, sub *events.Subscription, since uint64) {
+	defer close(s.done)
+	defer close(s.c)
+	defer sub.Close()
+	for {
+		tx := DBReader{}
+		db.BeginRead(&tx)
+		batch, err := readChanges(&tx, since, changeBatch)
+		db.EndRead(&tx)
+		if err != nil {
+			s.err = err
+			return
+		}
+		for _, ch := range batch {
+			select {
+			case s.c <- ch:
+				since = ch.Seq
+			case <-s.stop:
+				return
+			}
+		}
+		if len(batch) == changeBatch {
+			continue
+		}
+		select {
+		case <-sub.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Err returns why the stream failed once C is closed, or nil while it runs
+// or if it was stopped by Close.
+func (s *ChangeStream) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close stops the stream and closes C.
+func (s *ChangeStream) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
@@ -0,0 +1,127 @@
+package tables
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.db")
+	db := &DB{Path: path, ChangeLog: 5}
+	is.NoError(t, db.Open())
+	defer func() { db.Close() }()
+
+	update := func(fn func(tx *DBTX) error) {
+		t.Helper()
+		tx := DBTX{}
+		db.Begin(&tx)
+		is.NoError(t, fn(&tx))
+		is.NoError(t, db.Commit(&tx))
+	}
+	row := func(id int64, v []byte) Record {
+		return *(&Record{}).AddInt64("id", id).AddInt64("k", int64(len(v))).AddStr("v", v)
+	}
+	next := func(s *ChangeStream) Change {
+		t.Helper()
+		select {
+		case ch, ok := <-s.C:
+			is.True(t, ok, "stream closed: %v", s.Err())
+			return ch
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change")
+			return Change{}
+		}
+	}
+
+	update(func(tx *DBTX) error {
+		return tx.TableNew(&TableDef{
+			Name:    "t",
+			Cols:    []string{"id", "k", "v"},
+			Types:   []uint32{TypeInt64, TypeInt64, TypeBytes},
+			PKeys:   1,
+			Indexes: [][]string{{"k"}},
+		})
+	})
+	s, err := db.Changes(0)
+	is.NoError(t, err)
+	update(func(tx *DBTX) error {
+		if _, err := tx.Insert("t", row(1, []byte("a"))); err != nil {
+			return err
+		}
+		_, err := tx.Update("t", row(1, []byte("b")))
+		return err
+	})
+
+	ch := next(s)
+	is.Equal(t, uint64(1), ch.Seq)
+	is.Equal(t, "t", ch.Table)
+	is.Equal(t, ChangeInsert, ch.Op)
+	is.Nil(t, ch.Old)
+	is.Equal(t, []byte("a"), ch.New.Get("v").Str)
+	ch = next(s)
+	is.Equal(t, uint64(2), ch.Seq)
+	is.Equal(t, ChangeUpdate, ch.Op)
+	is.Equal(t, []byte("a"), ch.Old.Get("v").Str)
+	is.Equal(t, []byte("b"), ch.New.Get("v").Str)
+
+	// A change of rows larger than a B-tree value, then a delete.
+	big := bytes.Repeat([]byte("z\x00"), 900)
+	update(func(tx *DBTX) error {
+		_, err := tx.Upsert("t", row(1, big))
+		return err
+	})
+	update(func(tx *DBTX) error {
+		_, err := tx.Delete("t", *(&Record{}).AddInt64("id", 1))
+		return err
+	})
+	ch = next(s)
+	is.Equal(t, uint64(3), ch.Seq)
+	is.Equal(t, big, ch.New.Get("v").Str)
+	ch = next(s)
+	is.Equal(t, uint64(4), ch.Seq)
+	is.Equal(t, ChangeDelete, ch.Op)
+	is.Equal(t, big, ch.Old.Get("v").Str)
+	is.Nil(t, ch.New)
+	s.Close()
+	_, ok := <-s.C
+	is.False(t, ok)
+	is.NoError(t, s.Err())
+
+	// The changes survive a restart; the ring buffer keeps the last 5.
+	db.Close()
+	db = &DB{Path: path, ChangeLog: 5}
+	is.NoError(t, db.Open())
+	update(func(tx *DBTX) error {
+		for id := int64(10); id < 13; id++ {
+			if _, err := tx.Insert("t", row(id, []byte("x"))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	_, err = db.Changes(1)
+	is.ErrorIs(t, err, ErrChangesLost)
+	s, err = db.Changes(3)
+	is.NoError(t, err)
+	defer s.Close()
+	for seq := uint64(4); seq <= 7; seq++ {
+		is.Equal(t, seq, next(s).Seq)
+	}
+	s2, err := db.Changes(0)
+	is.NoError(t, err)
+	is.Equal(t, uint64(3), next(s2).Seq)
+	s2.Close()
+
+	// Internal tables are not captured.
+	update(func(tx *DBTX) error { return tx.UserNew("bob", "secret", false) })
+	r := DBReader{}
+	db.BeginRead(&r)
+	changes, err := readChanges(&r, 7, 10)
+	db.EndRead(&r)
+	is.NoError(t, err)
+	is.Empty(t, changes)
+}
@@ -0,0 +1,173 @@
+package tables
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Row codecs
+// ---------------------------------------------------------------------------
+
+// A RowCodec encodes the values of the non-key columns of a row, which are
+// the KV value of the row; keys always use the order-preserving encoding.
+// A table chooses its codec by name in TableDef.Codec. Index entries, the
+// cold tier and the internal tables keep the default codec.
+type RowCodec interface {
+	// Encode appends the encoding of vals to out.
+	Encode(out []byte, vals []Value) []byte
+	// Decode decodes in into vals, which hold only their Types. The
+	// values may alias in.
+	Decode(in []byte, vals []Value) error
+}
+
+// Row codecs every process knows.
+const (
+	CodecDefault = "default" // the key encoding; the default
+	CodecFlat    = "flat"    // numbers as their bits, bytes length-prefixed
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]RowCodec
+}{m: map[string]RowCodec{CodecFlat: flatCodec{}}}
+
+// RegisterRowCodec makes a row codec available under name to
+// TableDef.Codec. A table that names it can only be used by a process that
+// registered it, and the codec must keep decoding what it encoded before.
+// It panics if name is taken.
+func RegisterRowCodec(name string, codec RowCodec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, dup := codecs.m[name]; dup || name == CodecDefault || name == "" || codec == nil {
+		panic("tables: RegisterRowCodec called twice or with a bad codec: " + name)
+	}
+	codecs.m[name] = codec
+}
+
+// LookupRowCodec returns the row codec registered under name. ok is false
+// if there is no such codec.
+func LookupRowCodec(name string) (codec RowCodec, ok bool) {
+	if name == CodecDefault || name == "" {
+		return defaultCodec{}, true
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok = codecs.m[name]
+	return codec, ok
+}
+
+// codec returns the row codec of tdef.
+func (tdef *TableDef) codec() RowCodec {
+	codec, ok := LookupRowCodec(tdef.Codec)
+	assert(ok) // checked by tableDefCheck and dbScan
+	return codec
+}
+
+// checkCodec verifies that the codec of tdef is registered in this process.
+func checkCodec(tdef *TableDef) error {
+	if _, ok := LookupRowCodec(tdef.Codec); !ok {
+		return fmt.Errorf("unknown row codec %q of table %s", tdef.Codec, tdef.Name)
+	}
+	return nil
+}
+
+// encodeRowValues appends the encoding of vals, the non-key values of a row
+// of tdef, to out.
+func encodeRowValues(tdef *TableDef, out []byte, vals []Value) []byte {
+	if tdef.Codec == "" {
+		return encodeValues(out, vals)
+	}
+	return tdef.codec().Encode(out, vals)
+}
+
+// decodeRowValues inverts encodeRowValues.
+func decodeRowValues(tdef *TableDef, in []byte, vals []Value) {
+	if tdef.Codec == "" {
+		decodeValues(in, vals)
+		return
+	}
+	// Only the types are the codec's to read: the values may share memory
+	// with a caller's record.
+	for i := range vals {
+		vals[i] = Value{Type: vals[i].Type}
+	}
+	err := tdef.codec().Decode(in, vals)
+	assert(err == nil)
+}
+
+// defaultCodec is the order-preserving encoding of keys.
+type defaultCodec struct{}
+
+func (defaultCodec) Encode(out []byte, vals []Value) []byte {
+	return encodeValues(out, vals)
+}
+
+func (defaultCodec) Decode(in []byte, vals []Value) error {
+	decodeValues(in, vals)
+	return nil
+}
+
+// flatCodec stores int64 and float64 values as their 8 bytes, little-endian,
+// UUIDs as their 16 bytes, and bytes values as a uvarint length and the
+// bytes. Unlike the default codec, it needs no escaping, so it is cheaper
+// for values holding zero bytes, and the fixed-width columns before the
+// first bytes column sit at the same offset in every row.
+type flatCodec struct{}
+
+var errFlat = errors.New("flat codec: truncated value")
+
+func (flatCodec) Encode(out []byte, vals []Value) []byte {
+	for _, v := range vals {
+		switch v.Type {
+		case TypeInt64:
+			out = binary.LittleEndian.AppendUint64(out, uint64(v.I64))
+		case TypeFloat64:
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v.F64))
+		case TypeUUID:
+			out = append(out, v.Str...)
+		case TypeBytes:
+			out = binary.AppendUvarint(out, uint64(len(v.Str)))
+			out = append(out, v.Str...)
+		default:
+			panic("flatCodec: unknown type")
+		}
+	}
+	return out
+}
+
+func (flatCodec) Decode(in []byte, vals []Value) error {
+	for i := range vals {
+		v := &vals[i]
+		n := 8
+		switch v.Type {
+		case TypeUUID:
+			n = 16
+		case TypeBytes:
+			size, k := binary.Uvarint(in)
+			if k <= 0 || size > uint64(len(in)-k) {
+				return errFlat
+			}
+			in, n = in[k:], int(size)
+		}
+		if len(in) < n {
+			return errFlat
+		}
+		switch v.Type {
+		case TypeInt64:
+			v.I64 = int64(binary.LittleEndian.Uint64(in))
+		case TypeFloat64:
+			v.F64 = math.Float64frombits(binary.LittleEndian.Uint64(in))
+		default:
+			v.Str = in[:n:n]
+		}
+		in = in[n:]
+	}
+	if len(in) != 0 {
+		return errors.New("flat codec: trailing bytes")
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+// jsonCodec stores the values as JSON, as an application might for interop.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(out []byte, vals []Value) []byte {
+	data, err := json.Marshal(vals)
+	assert(err == nil)
+	return append(out, data...)
+}
+
+func (jsonCodec) Decode(in []byte, vals []Value) error {
+	return json.Unmarshal(in, &vals)
+}
+
+func TestFlatCodec(t *testing.T) {
+	id := NewUUIDv7()
+	vals := []Value{
+		{Type: TypeInt64, I64: -5}, {Type: TypeFloat64, F64: math.Inf(-1)}, {Type: TypeUUID, Str: id[:]},
+		{Type: TypeBytes, Str: []byte{0, 1, 0xff}}, {Type: TypeBytes, Str: []byte{}},
+	}
+	codec, ok := LookupRowCodec(CodecFlat)
+	is.True(t, ok)
+	data := codec.Encode(nil, vals)
+	is.Len(t, data, 8+8+16+1+3+1)
+	out := []Value{{Type: TypeInt64}, {Type: TypeFloat64}, {Type: TypeUUID}, {Type: TypeBytes}, {Type: TypeBytes}}
+	is.NoError(t, codec.Decode(data, out))
+	is.Equal(t, vals, out)
+	is.Error(t, codec.Decode(data[:len(data)-1], out))
+	is.Error(t, codec.Decode(append(data, 0), out))
+}
+
+func TestRowCodec(t *testing.T) {
+	RegisterRowCodec("test-json", jsonCodec{})
+	is.Panics(t, func() { RegisterRowCodec("test-json", jsonCodec{}) })
+
+	tt := newTableTester()
+	defer tt.dispose()
+	for _, codec := range []string{CodecFlat, "test-json"} {
+		tt.create(&TableDef{
+			Name:    codec,
+			Cols:    []string{"id", "name", "score", "blob"},
+			Types:   []uint32{TypeInt64, TypeBytes, TypeFloat64, TypeBytes},
+			PKeys:   1,
+			Indexes: [][]string{{"name"}},
+			Include: [][]string{{"score"}},
+			Codec:   codec,
+		})
+		row := func(id int64, name string, score float64) Record {
+			return *(&Record{}).AddInt64("id", id).AddStr("name", []byte(name)).
+				AddFloat64("score", score).AddStr("blob", []byte{0, byte(id)})
+		}
+		for i := range int64(10) {
+			tt.add(codec, row(i, string(rune('a'+i%3)), float64(i)/2))
+		}
+		tt.add(codec, row(4, "z", -1))
+		tt.del(codec, *(&Record{}).AddInt64("id", 5))
+		for i := range int64(10) {
+			rec := (&Record{}).AddInt64("id", i)
+			tt.get(codec, rec)
+		}
+
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		tdef := tx.TableDef(codec)
+		// The row is stored in the codec's encoding.
+		key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:1], []Value{{Type: TypeInt64, I64: 4}})
+		val, ok := tx.kvr.Get(key)
+		is.True(t, ok)
+		want, _ := LookupRowCodec(codec)
+		is.Equal(t, want.Encode(nil, row(4, "z", -1).Vals[1:]), val)
+		// Index entries keep the default encoding.
+		sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: *(&Record{}).AddStr("name", []byte("a")),
+			Key2: *(&Record{}).AddStr("name", []byte("a")), Cols: []string{"name", "score"}}
+		is.NoError(t, tx.Scan(codec, &sc))
+		var scores []float64
+		for rec := (Record{}); sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			scores = append(scores, rec.Get("score").F64)
+		}
+		is.Equal(t, []float64{0, 1.5, 3, 4.5}, scores)
+		tt.db.Abort(&tx)
+	}
+
+	// A dump keeps the codec of each table.
+	var dump bytes.Buffer
+	is.NoError(t, tt.db.Dump(&dump))
+	is.Contains(t, dump.String(), `"Codec":"flat"`)
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	err := tx.TableNew(&TableDef{Name: "bad", Cols: []string{"id"}, Types: []uint32{TypeInt64}, PKeys: 1, Codec: "nope"})
+	is.ErrorContains(t, err, "unknown row codec")
+}
@@ -0,0 +1,109 @@
+package tables
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Collations
+// ---------------------------------------------------------------------------
+
+// A Collation orders the values of a bytes column in keys. Keys are compared
+// as bytes, so a collation is given as a sort-key function rather than a
+// comparator: it appends to dst a key for s such that comparing the keys of
+// two values as bytes compares the values by the collation. Values with the
+// same sort key collate equal.
+type Collation func(dst, s []byte) []byte
+
+// Collations every process knows.
+const (
+	CollateBinary = "binary" // the bytes of the value; the default
+	CollateNoCase = "nocase" // ASCII letters compare case-insensitively
+)
+
+var collations = struct {
+	sync.RWMutex
+	m map[string]Collation
+}{m: map[string]Collation{CollateNoCase: nocaseKey}}
+
+// RegisterCollation makes a collation available under name to
+// TableDef.Collate. A table that names it can only be used by a process
+// that registered it, and the function must never change, since the keys
+// already stored were ordered by it. It panics if name is taken.
+func RegisterCollation(name string, key Collation) {
+	collations.Lock()
+	defer collations.Unlock()
+	if _, dup := collations.m[name]; dup || name == CollateBinary || name == "" || key == nil {
+		panic("tables: RegisterCollation called twice or with a bad collation: " + name)
+	}
+	collations.m[name] = key
+}
+
+// LookupCollation returns the collation registered under name, or nil for
+// the binary collation. ok is false if there is no such collation.
+func LookupCollation(name string) (key Collation, ok bool) {
+	if name == CollateBinary || name == "" {
+		return nil, true
+	}
+	collations.RLock()
+	defer collations.RUnlock()
+	key, ok = collations.m[name]
+	return key, ok
+}
+
+func nocaseKey(dst, s []byte) []byte {
+	for _, ch := range s {
+		if 'A' <= ch && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
+
+// checkCollations verifies that every collation of tdef names a bytes
+// column and is registered in this process.
+func checkCollations(tdef *TableDef) error {
+	for col, name := range tdef.Collate {
+		idx := ColIndex(tdef, col)
+		if idx < 0 {
+			return fmt.Errorf("unknown collated column: %s", col)
+		}
+		if tdef.Types[idx] != TypeBytes {
+			return fmt.Errorf("collated column is not bytes: %s", col)
+		}
+		if _, ok := LookupCollation(name); !ok {
+			return fmt.Errorf("unknown collation %q for column %s", name, col)
+		}
+	}
+	return nil
+}
+
+// collation returns the collation of col, or nil if it is binary.
+func (tdef *TableDef) collation(col string) Collation {
+	if len(tdef.Collate) == 0 {
+		return nil
+	}
+	key, ok := LookupCollation(tdef.Collate[col])
+	assert(ok) // checked by dbScan and checkRecord
+	return key
+}
+
+// keyValues returns vals, the values of the key columns cols, as they are
+// encoded in a key: the value of a collated column is preceded by its sort
+// key, so that keys order by the collation first and by the bytes of the
+// value second. Distinct values therefore stay distinct keys.
+func keyValues(tdef *TableDef, cols []string, vals []Value) []Value {
+	if len(tdef.Collate) == 0 {
+		return vals
+	}
+	out := make([]Value, 0, 2*len(vals))
+	for i, v := range vals {
+		if coll := tdef.collation(cols[i]); coll != nil {
+			out = append(out, Value{Type: TypeBytes, Str: coll(nil, v.Str)})
+		}
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package tables
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCollation(t *testing.T) {
+	// A collation that orders strings by length first.
+	RegisterCollation("test-length", func(dst, s []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(dst, uint32(len(s))), s...)
+	})
+	is.Panics(t, func() { RegisterCollation("test-length", nocaseKey) })
+	is.Panics(t, func() { RegisterCollation(CollateBinary, nocaseKey) })
+
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "people",
+		Cols:    []string{"name", "id", "city", "note"},
+		Types:   []uint32{TypeBytes, TypeInt64, TypeBytes, TypeBytes},
+		PKeys:   2,
+		Indexes: [][]string{{"city"}},
+		Collate: map[string]string{"name": CollateNoCase, "city": "test-length"},
+	})
+	for i, row := range [][2]string{
+		{"carol", "Rome"}, {"Alice", "Oslo"}, {"bob", "Lisbon"},
+		{"alice", "Bern"}, {"CAROL", "Kyiv"}, {"Bob", "Paris"},
+	} {
+		rec := Record{}
+		rec.AddStr("name", []byte(row[0])).AddInt64("id", int64(i)).AddStr("city", []byte(row[1])).AddStr("note", nil)
+		is.True(t, tt.add("people", rec))
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	scan := func(sc Scanner) (names []string) {
+		is.NoError(t, tx.Scan("people", &sc))
+		for rec := (Record{}); sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			names = append(names, string(rec.Get("name").Str))
+		}
+		return names
+	}
+	key := func(col, s string) Record {
+		return *(&Record{}).AddStr(col, []byte(s))
+	}
+
+	// Keys order by the collation, then by bytes.
+	is.Equal(t, []string{"Alice", "alice", "Bob", "bob", "CAROL", "carol"}, scan(FullScan()))
+	// Bounds match every value that collates equal.
+	is.Equal(t, []string{"Alice", "alice", "Bob", "bob"},
+		scan(Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key("name", "ALICE"), Key2: key("name", "BOB")}))
+	is.Equal(t, []string{"CAROL", "carol"},
+		scan(Scanner{Cmp1: btree.CmpGT, Key1: key("name", "bOb")}))
+	is.Equal(t, []string{"bob", "Bob", "alice", "Alice"},
+		scan(Scanner{Cmp1: btree.CmpLT, Key1: key("name", "Carol")}))
+	// A registered collation orders an index.
+	is.Equal(t, []string{"alice", "CAROL", "Alice", "carol", "Bob", "bob"}, scan(Scanner{Cmp1: btree.CmpGE, Key1: key("city", "")}))
+	is.Equal(t, []string{"CAROL", "Alice", "carol", "Bob"},
+		scan(Scanner{Cmp1: btree.CmpGT, Cmp2: btree.CmpLE, Key1: key("city", "Bern"), Key2: key("city", "zzzzz")}))
+
+	// Point reads and writes use the exact value.
+	rec := key("name", "alice")
+	rec.AddInt64("id", 3)
+	ok, err := tx.Get("people", &rec)
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, "Bern", string(rec.Get("city").Str))
+	rec = key("name", "ALICE")
+	rec.AddInt64("id", 3)
+	ok, err = tx.Get("people", &rec)
+	is.NoError(t, err)
+	is.False(t, ok)
+
+	// A collated column must be the last in a range key.
+	sc := Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("name", []byte("bob")).AddInt64("id", 2)}
+	is.ErrorContains(t, tx.Scan("people", &sc), "must come last")
+
+	for _, coll := range []map[string]string{
+		{"id": CollateNoCase},
+		{"name": "no-such-collation"},
+		{"missing": CollateNoCase},
+	} {
+		err := tx.TableNew(&TableDef{
+			Name: "bad", Cols: []string{"name", "id"}, Types: []uint32{TypeBytes, TypeInt64}, PKeys: 1,
+			Collate: coll,
+		})
+		is.Error(t, err)
+	}
+}
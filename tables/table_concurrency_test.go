@@ -0,0 +1,268 @@
+package tables
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/kv"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestConcurrentDB(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "conc.db"), ChangeLog: 100}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	update := func(fn func(tx *DBTX) error) error {
+		for {
+			tx := DBTX{}
+			db.Begin(&tx)
+			if err := fn(&tx); err != nil {
+				db.Abort(&tx)
+				return err
+			}
+			err := db.Commit(&tx)
+			if !errors.Is(err, kv.ErrConflict) {
+				return err
+			}
+		}
+	}
+	// The workers report their errors here, to be checked on the test
+	// goroutine: require stops a test only when called from it.
+	errs := make(chan error, 9)
+	for w := range 4 {
+		go func() {
+			errs <- func() error {
+				name := fmt.Sprintf("t%d", w)
+				err := update(func(tx *DBTX) error {
+					return tx.TableNew(&TableDef{Name: name, Cols: []string{"id", "k", "v"}, Types: []uint32{TypeInt64, TypeInt64, TypeBytes}, PKeys: 1, Indexes: [][]string{{"k"}}})
+				})
+				if err != nil {
+					return err
+				}
+				for i := range int64(200) {
+					err := update(func(tx *DBTX) error {
+						_, err := tx.Insert(name, *(&Record{}).AddInt64("id", i).AddInt64("k", i%5).AddStr("v", make([]byte, 500)))
+						return err
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}()
+		}()
+		go func() {
+			for range 50 {
+				r := DBReader{}
+				db.BeginRead(&r)
+				sc := FullScan()
+				if err := r.Scan(fmt.Sprintf("t%d", w), &sc); err == nil {
+					for ; sc.Valid(); sc.Next() {
+						var rec Record
+						sc.Deref(&rec)
+					}
+				}
+				db.EndRead(&r)
+				db.Stats()
+				db.Metrics()
+			}
+			errs <- nil
+		}()
+	}
+	go func() {
+		for range 5 {
+			if _, err := db.Compact(); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	for range cap(errs) {
+		is.NoError(t, <-errs)
+	}
+}
+
+func TestDBUpdate(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "update.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.NoError(t, db.Update(func(tx *DBTX) error {
+		if err := tx.TableNew(&TableDef{Name: "c", Cols: []string{"id", "n"}, Types: []uint32{TypeInt64, TypeInt64}, PKeys: 1}); err != nil {
+			return err
+		}
+		_, err := tx.Insert("c", *(&Record{}).AddInt64("id", 1).AddInt64("n", 0))
+		return err
+	}))
+	incr := func(tx *DBTX, by int64) error {
+		rec := (&Record{}).AddInt64("id", 1)
+		if _, err := tx.Get("c", rec); err != nil {
+			return err
+		}
+		rec.Get("n").I64 += by
+		_, err := tx.Update("c", *rec)
+		return err
+	}
+	get := func() int64 {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		rec := (&Record{}).AddInt64("id", 1)
+		ok, err := r.Get("c", rec)
+		is.True(t, ok)
+		is.NoError(t, err)
+		return rec.Get("n").I64
+	}
+
+	// A commit that loses a conflict is re-run on a new snapshot.
+	calls := 0
+	is.NoError(t, db.Update(func(tx *DBTX) error {
+		calls++
+		if calls == 1 {
+			is.NoError(t, db.Update(func(tx *DBTX) error { return incr(tx, 10) }))
+		}
+		return incr(tx, 1)
+	}))
+	is.Equal(t, 2, calls)
+	is.Equal(t, int64(11), get())
+
+	// An error of fn aborts without a retry.
+	calls = 0
+	err := db.Update(func(tx *DBTX) error {
+		calls++
+		is.NoError(t, incr(tx, 100))
+		return errors.New("no")
+	})
+	is.EqualError(t, err, "no")
+	is.Equal(t, 1, calls)
+	is.Equal(t, int64(11), get())
+
+	// A transaction that always conflicts is given up on.
+	calls = 0
+	err = db.Update(func(tx *DBTX) error {
+		calls++
+		is.NoError(t, db.Update(func(tx *DBTX) error { return incr(tx, 1) }))
+		return incr(tx, 1)
+	})
+	is.ErrorIs(t, err, kv.ErrConflict)
+	is.Equal(t, kv.MaxRetries, calls)
+	is.Equal(t, int64(11+kv.MaxRetries), get())
+}
+
+// TestCrossTableTx moves amounts between the rows of one table and records
+// each move in another, in one transaction. Readers must never see one
+// table change without the other.
+func TestCrossTableTx(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "cross.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	acct := func(id, bal int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddInt64("bal", bal).AddStr("note", nil)
+	}
+	tx := DBTX{}
+	db.Begin(&tx)
+	is.NoError(t, tx.TableNew(&TableDef{Name: "acct", Cols: []string{"id", "bal", "note"}, Types: []uint32{TypeInt64, TypeInt64, TypeBytes}, PKeys: 1}))
+	is.NoError(t, tx.TableNew(&TableDef{Name: "ledger", Cols: []string{"seq", "from", "amount"}, Types: []uint32{TypeInt64, TypeInt64, TypeInt64}, PKeys: 1}))
+	for id := range int64(4) {
+		_, err := tx.Insert("acct", acct(id, 100))
+		is.NoError(t, err)
+	}
+	_, err := tx.Insert("acct", acct(99, 0))
+	is.NoError(t, err)
+	is.NoError(t, db.Commit(&tx))
+
+	balance := func(tx *DBReader, id int64) (int64, error) {
+		rec := (&Record{}).AddInt64("id", id)
+		ok, err := tx.Get("acct", rec)
+		if err == nil && !ok {
+			err = fmt.Errorf("account %d not found", id)
+		}
+		return rec.Get("bal").I64, err
+	}
+	mustBalance := func(tx *DBReader, id int64) int64 {
+		bal, err := balance(tx, id)
+		is.NoError(t, err)
+		return bal
+	}
+	add := func(tx *DBTX, id, amount int64) error {
+		bal, err := balance(&tx.DBReader, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Update("acct", acct(id, bal+amount))
+		return err
+	}
+	transfer := func(tx *DBTX, seq, from, amount int64) error {
+		if err := add(tx, from, -amount); err != nil {
+			return err
+		}
+		if err := add(tx, 99, amount); err != nil {
+			return err
+		}
+		_, err := tx.Insert("ledger", *(&Record{}).AddInt64("seq", seq).AddInt64("from", from).AddInt64("amount", amount))
+		return err
+	}
+
+	// An aborted transaction leaves both tables as they were.
+	db.Begin(&tx)
+	is.NoError(t, transfer(&tx, 0, 0, 50))
+	db.Abort(&tx)
+
+	// Workers report their errors to the test goroutine, as in
+	// TestConcurrentDB.
+	errs := make(chan error, 4)
+	for w := range int64(4) {
+		go func() {
+			errs <- func() error {
+				for i := range int64(25) {
+					for {
+						tx := DBTX{}
+						db.Begin(&tx)
+						if err := transfer(&tx, w*100+i, w, 1); err != nil {
+							db.Abort(&tx)
+							return err
+						}
+						err := db.Commit(&tx)
+						if !errors.Is(err, kv.ErrConflict) {
+							if err != nil {
+								return err
+							}
+							break
+						}
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+	check := func() int64 {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		total := mustBalance(&r, 99)
+		for id := range int64(4) {
+			total += mustBalance(&r, id)
+		}
+		is.Equal(t, int64(400), total)
+		moved := int64(0)
+		sc := FullScan()
+		is.NoError(t, r.Scan("ledger", &sc))
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			moved += rec.Get("amount").I64
+		}
+		is.Equal(t, mustBalance(&r, 99), moved)
+		return moved
+	}
+	for range 50 {
+		check()
+	}
+	for range cap(errs) {
+		is.NoError(t, <-errs)
+	}
+	is.Equal(t, int64(100), check())
+}
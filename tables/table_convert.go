@@ -0,0 +1,114 @@
+package tables
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Verified copy between database files
+// ---------------------------------------------------------------------------
+
+// TableChecksum summarises the rows of one table independently of the file
+// that stores them: two tables holding the same rows have equal checksums,
+// whatever their prefixes, page layout or format version.
+type TableChecksum struct {
+	Table string
+	Rows  int64
+	Sum   uint64 // FNV-1a over the encoded rows in primary-key order
+}
+
+// Checksums returns the checksum of every user table in the snapshot, in
+// table name order.
+func (tx *DBReader) Checksums() ([]TableChecksum, error) {
+	names, err := tableNames(tx)
+	if err != nil {
+		return nil, err
+	}
+	sums := make([]TableChecksum, 0, len(names))
+	for _, name := range names {
+		sum, err := tableChecksum(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	return sums, nil
+}
+
+func tableChecksum(tx *DBReader, name string) (TableChecksum, error) {
+	sum := TableChecksum{Table: name}
+	sc := Scanner{Cmp1: btree.CmpGE}
+	if err := tx.Scan(name, &sc); err != nil {
+		return sum, err
+	}
+	h := fnv.New64a()
+	var rec Record
+	var buf []byte
+	for ; sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		buf = encodeValues(buf[:0], rec.Vals)
+		h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(buf))))
+		h.Write(buf)
+		sum.Rows++
+	}
+	sum.Sum = h.Sum64()
+	return sum, nil
+}
+
+// Convert copies every user table of src into dst, which is typically a new
+// file written by this build, by streaming a logical dump from one snapshot
+// of src into dst.Load. It then checks that dst holds the same tables with
+// the same row counts and checksums as that snapshot, and returns them.
+// Writers may keep using src meanwhile; their commits are not copied.
+func Convert(dst, src *DB) ([]TableChecksum, error) {
+	tx := DBReader{}
+	src.BeginRead(&tx)
+	defer src.EndRead(&tx)
+
+	pr, pw := io.Pipe()
+	dumped := make(chan error, 1)
+	go func() {
+		err := dump(&tx, pw)
+		pw.CloseWithError(err)
+		dumped <- err
+	}()
+	err := dst.Load(pr)
+	pr.CloseWithError(fmt.Errorf("load stopped"))
+	if derr := <-dumped; derr != nil && err == nil {
+		err = derr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+
+	want, err := tx.Checksums()
+	if err != nil {
+		return nil, fmt.Errorf("convert: checksum source: %w", err)
+	}
+	out := DBReader{}
+	dst.BeginRead(&out)
+	defer dst.EndRead(&out)
+	names, err := tableNames(&out)
+	if err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+	if len(names) != len(want) {
+		return nil, fmt.Errorf("convert: %d tables copied, source has %d", len(names), len(want))
+	}
+	for _, w := range want {
+		got, err := tableChecksum(&out, w.Table)
+		if err != nil {
+			return nil, fmt.Errorf("convert: checksum %s: %w", w.Table, err)
+		}
+		if got != w {
+			return nil, fmt.Errorf("convert: table %s: copied %d rows (checksum %016x), source has %d (%016x)",
+				w.Table, got.Rows, got.Sum, w.Rows, w.Sum)
+		}
+	}
+	return want, nil
+}
@@ -0,0 +1,61 @@
+package tables
+
+import (
+	"path/filepath"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestConvert(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "users",
+		Cols:    []string{"id", "name", "age"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"age"}},
+	})
+	tt.create(&TableDef{
+		Name:  "empty",
+		Cols:  []string{"k", "v"},
+		Types: []uint32{TypeBytes, TypeBytes},
+		PKeys: 1,
+	})
+	for i := int64(0); i < 2000; i++ {
+		tt.add("users", *(&Record{}).AddInt64("id", i).AddStr("name", []byte{'n', byte(i)}).AddInt64("age", i%50))
+	}
+
+	dst := DB{Path: filepath.Join(t.TempDir(), "convert.db")}
+	is.NoError(t, dst.Open())
+	defer dst.Close()
+	sums, err := Convert(&dst, &tt.db)
+	is.NoError(t, err)
+	is.Len(t, sums, 2)
+	is.Equal(t, "empty", sums[0].Table)
+	is.Zero(t, sums[0].Rows)
+	is.Equal(t, "users", sums[1].Table)
+	is.Equal(t, int64(2000), sums[1].Rows)
+
+	// The checksums of the copy match, although its prefixes differ.
+	r := DBReader{}
+	dst.BeginRead(&r)
+	got, err := r.Checksums()
+	dst.EndRead(&r)
+	is.NoError(t, err)
+	is.Equal(t, sums, got)
+
+	// Any change to a row changes the checksum.
+	tt.add("users", *(&Record{}).AddInt64("id", 7).AddStr("name", []byte("other")).AddInt64("age", 7))
+	tt.db.BeginRead(&r)
+	changed, err := r.Checksums()
+	tt.db.EndRead(&r)
+	is.NoError(t, err)
+	is.Equal(t, sums[1].Rows, changed[1].Rows)
+	is.NotEqual(t, sums[1].Sum, changed[1].Sum)
+
+	// The target must not hold the tables already.
+	_, err = Convert(&dst, &tt.db)
+	is.ErrorContains(t, err, "table exists")
+}
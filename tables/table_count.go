@@ -0,0 +1,119 @@
+package tables
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Row counts
+// ---------------------------------------------------------------------------
+
+// countKey returns the @meta key of the row counter of tdef.
+func countKey(tdef *TableDef) *Record {
+	return (&Record{}).AddStr("key", []byte("rows:"+tdef.Name))
+}
+
+// countGet returns the row counter of tdef. ok is false for a table created
+// before row counters existed, which has none.
+func countGet(tx *DBReader, tdef *TableDef) (n int64, ok bool) {
+	rec := countKey(tdef)
+	ok, err := dbGet(tx, tdefMeta, rec)
+	assert(err == nil)
+	if !ok {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(rec.Get("val").Str)), true
+}
+
+func countPut(tx *DBTX, tdef *TableDef, n int64) error {
+	val := binary.LittleEndian.AppendUint64(nil, uint64(n))
+	return dbUpdate(tx, tdefMeta, &DBSetReq{Record: *countKey(tdef).AddStr("val", val)})
+}
+
+// countAdd adds delta to the row counter of tdef, if it has one. Internal
+// tables are not counted.
+func countAdd(tx *DBTX, tdef *TableDef, delta int64) error {
+	if tdef.Prefix < tablePrefixMin {
+		return nil
+	}
+	n, ok := countGet(&tx.DBReader, tdef)
+	if !ok {
+		return nil
+	}
+	return countPut(tx, tdef, n+delta)
+}
+
+// Count returns the number of rows of table in the snapshot. The count is
+// kept in @meta by every insert and delete, so it costs one lookup. Rows
+// that have expired but not been reclaimed yet are counted. A table created
+// before row counts were kept has no counter, and its rows are counted with
+// a scan.
+func (tx *DBReader) Count(table string) (int64, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return 0, fmt.Errorf("table not found: %s", table)
+	}
+	if n, ok := countGet(tx, tdef); ok {
+		return n, nil
+	}
+	n := int64(0)
+	for it := tx.kvr.Seek(prefixKey(tdef.Prefix), btree.CmpGE); it.Valid(); it.Next() {
+		if key, _ := it.Deref(); binary.BigEndian.Uint32(key) != tdef.Prefix {
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Count runs DBReader.Count on the last commit.
+func (db *DB) Count(table string) (int64, error) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return tx.Count(table)
+}
+
+// EstimateCount estimates the number of rows that a scan of table with
+// req's range would return, without running it. It reads only the paths to
+// the two ends of the range down the B-tree (see btree.BTree.EstimateKeys),
+// so its cost grows with the height of the tree rather than the size of the
+// range. Cmp1, Cmp2, Key1, Key2 and Where choose the range and the index as
+// in Scan; the conditions of Where and Filter are not applied otherwise, and
+// expired rows are counted. The estimate is rough, except for a range that
+// lies within one leaf, which is counted exactly.
+func (tx *DBReader) EstimateCount(table string, req *Scanner) (int64, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return 0, fmt.Errorf("table not found: %s", table)
+	}
+	sc := Scanner{Cmp1: req.Cmp1, Cmp2: req.Cmp2, Key1: req.Key1, Key2: req.Key2, Where: req.Where}
+	start, err := scanInit(tx, tdef, &sc)
+	if err != nil {
+		return 0, err
+	}
+	// Turn the bounds into [start, end): the key just after an inclusive
+	// upper bound or an exclusive lower one is the bound plus a zero byte.
+	end := sc.keyEnd
+	if sc.Cmp1 < 0 {
+		start, end = end, start
+	}
+	if sc.Cmp1 == btree.CmpGT || sc.Cmp2 == btree.CmpGT {
+		start = append(start, 0)
+	}
+	if sc.Cmp1 == btree.CmpLE || sc.Cmp2 == btree.CmpLE {
+		end = append(end, 0)
+	}
+	return tx.kvr.EstimateKeys(start, end), nil
+}
+
+// EstimateCount runs DBReader.EstimateCount on the last commit.
+func (db *DB) EstimateCount(table string, req *Scanner) (int64, error) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return tx.EstimateCount(table, req)
+}
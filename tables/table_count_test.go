@@ -0,0 +1,90 @@
+package tables
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCount(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}},
+	})
+	n, err := tt.db.Count("t")
+	is.NoError(t, err)
+	is.Zero(t, n)
+
+	row := func(id int) Record {
+		return *(&Record{}).AddInt64("id", int64(id)).AddStr("tag", fmt.Appendf(nil, "t%d", id%10)).AddStr("v", fmt.Appendf(nil, "%0100d", id))
+	}
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	for i := range 5000 {
+		_, err := tx.Insert("t", row(i))
+		is.NoError(t, err)
+	}
+	_, err = tx.Upsert("t", row(7)) // not a new row
+	is.NoError(t, err)
+	for i := range 100 {
+		_, err := tx.Delete("t", *(&Record{}).AddInt64("id", int64(i*2)))
+		is.NoError(t, err)
+	}
+	_, err = tx.Delete("t", *(&Record{}).AddInt64("id", -1)) // no such row
+	is.NoError(t, err)
+	n, err = tx.Count("t")
+	is.NoError(t, err)
+	is.Equal(t, int64(4900), n)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// An aborted transaction leaves the count alone.
+	tt.db.Begin(&tx)
+	_, err = tx.Insert("t", row(9000))
+	is.NoError(t, err)
+	tt.db.Abort(&tx)
+	n, err = tt.db.Count("t")
+	is.NoError(t, err)
+	is.Equal(t, int64(4900), n)
+
+	// A table without a counter is counted with a scan.
+	tt.db.Begin(&tx)
+	_, err = dbDelete(&tx, tdefMeta, *countKey(tx.TableDef("t")))
+	is.NoError(t, err)
+	is.NoError(t, tt.db.Commit(&tx))
+	tt.add("t", row(9000))
+	n, err = tt.db.Count("t")
+	is.NoError(t, err)
+	is.Equal(t, int64(4901), n)
+	_, err = tt.db.Count("nope")
+	is.Error(t, err)
+
+	// Ranges of the primary key and of the index.
+	pk := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+	tag := func(s string) Record { return *(&Record{}).AddStr("tag", []byte(s)) }
+	for _, c := range []struct {
+		sc   Scanner
+		want int64
+	}{
+		{Scanner{Cmp1: btree.CmpGE}, 4901},
+		{Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLT, Key1: pk(1000), Key2: pk(3000)}, 2000},
+		{Scanner{Cmp1: btree.CmpLE, Cmp2: btree.CmpGT, Key1: pk(3000), Key2: pk(1000)}, 2000},
+		{Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLT, Key1: tag("t2"), Key2: tag("t5")}, 1500},
+	} {
+		est, err := tt.db.EstimateCount("t", &c.sc)
+		is.NoError(t, err)
+		is.InEpsilon(t, c.want, est, 0.15, "%+v", c.sc)
+	}
+	// A range within one leaf is counted exactly.
+	est, err := tt.db.EstimateCount("t", &Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: pk(4000), Key2: pk(4004)})
+	is.NoError(t, err)
+	is.Equal(t, int64(5), est)
+	_, err = tt.db.EstimateCount("t", &Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLT, Key1: pk(1), Key2: tag("a")})
+	is.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package tables
+
+import (
+	"fmt"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Deferred constraint checks
+// ---------------------------------------------------------------------------
+
+// Check is a constraint evaluated against a transaction's final state.
+type Check func(tx *DBTX) error
+
+// Defer registers check to run when the transaction commits. Checks run in
+// registration order against the transaction's own view, after every write
+// has been made; the first failure aborts the transaction and is returned by
+// DB.Commit. Rows may therefore be temporarily inconsistent in between, e.g.
+// while swapping two unique values or inserting a child before its parent.
+func (tx *DBTX) Defer(check Check) {
+	tx.deferred = append(tx.deferred, check)
+}
+
+func (tx *DBTX) runDeferred() error {
+	for _, check := range tx.deferred {
+		if err := check(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UniqueCheck returns a Check that fails if two rows of table share the same
+// values in cols. It reads the whole table, so it is meant for deferral over
+// modest tables rather than as a per-row check.
+func UniqueCheck(table string, cols ...string) Check {
+	return func(tx *DBTX) error {
+		tdef := getTableDef(&tx.DBReader, table)
+		if tdef == nil {
+			return fmt.Errorf("table not found: %s", table)
+		}
+		for _, c := range cols {
+			if ColIndex(tdef, c) < 0 {
+				return fmt.Errorf("unknown column: %s", c)
+			}
+		}
+
+		seen := map[string]bool{}
+		sc := Scanner{Cmp1: btree.CmpGE}
+		if err := dbScan(&tx.DBReader, tdef, &sc); err != nil {
+			return err
+		}
+		var rec Record
+		for ; sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			k := string(encodeValues(nil, pickValues(rec, cols)))
+			if seen[k] {
+				return fmt.Errorf("unique constraint violated: %s%v", table, cols)
+			}
+			seen[k] = true
+		}
+		return nil
+	}
+}
+
+// ReferenceCheck returns a Check that fails if a row of child has no row in
+// parent whose primary key equals the child's cols (a foreign key). cols
+// must match parent's primary-key columns in number and type.
+func ReferenceCheck(child string, cols []string, parent string) Check {
+	return func(tx *DBTX) error {
+		ctdef := getTableDef(&tx.DBReader, child)
+		if ctdef == nil {
+			return fmt.Errorf("table not found: %s", child)
+		}
+		ptdef := getTableDef(&tx.DBReader, parent)
+		if ptdef == nil {
+			return fmt.Errorf("table not found: %s", parent)
+		}
+		for _, c := range cols {
+			if ColIndex(ctdef, c) < 0 {
+				return fmt.Errorf("unknown column: %s", c)
+			}
+		}
+		if len(cols) != ptdef.PKeys {
+			return fmt.Errorf("reference %s%v does not match the primary key of %s", child, cols, parent)
+		}
+
+		sc := Scanner{Cmp1: btree.CmpGE}
+		if err := dbScan(&tx.DBReader, ctdef, &sc); err != nil {
+			return err
+		}
+		var rec Record
+		for ; sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			pk := Record{Cols: ptdef.Cols[:ptdef.PKeys], Vals: pickValues(rec, cols)}
+			ok, err := dbGet(&tx.DBReader, ptdef, &pk)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("foreign key violated: %s%v references missing %s row", child, cols, parent)
+			}
+		}
+		return nil
+	}
+}
+
+// pickValues returns the values of cols from rec, in the order of cols.
+func pickValues(rec Record, cols []string) []Value {
+	vals := make([]Value, len(cols))
+	for i, c := range cols {
+		vals[i] = *rec.Get(c)
+	}
+	return vals
+}
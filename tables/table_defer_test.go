@@ -0,0 +1,84 @@
+package tables
+
+import (
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestDeferredChecks(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "users",
+		Cols:  []string{"id", "email"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+	})
+	tt.create(&TableDef{
+		Name:  "orders",
+		Cols:  []string{"oid", "uid"},
+		Types: []uint32{TypeInt64, TypeInt64},
+		PKeys: 1,
+	})
+	user := func(id int64, email string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("email", []byte(email))
+	}
+	order := func(oid, uid int64) Record {
+		return *(&Record{}).AddInt64("oid", oid).AddInt64("uid", uid)
+	}
+	unique := UniqueCheck("users", "email")
+	fk := ReferenceCheck("orders", []string{"uid"}, "users")
+
+	// Child before parent succeeds once both exist at commit.
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	tx.Defer(unique)
+	tx.Defer(fk)
+	_, err := tx.Insert("orders", order(1, 10))
+	is.NoError(t, err)
+	_, err = tx.Insert("users", user(10, "a@x"))
+	is.NoError(t, err)
+	_, err = tx.Insert("users", user(11, "b@x"))
+	is.NoError(t, err)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// Swapping two unique values passes through a duplicate state.
+	tx = DBTX{}
+	tt.db.Begin(&tx)
+	tx.Defer(unique)
+	_, err = tx.Update("users", user(10, "b@x"))
+	is.NoError(t, err)
+	_, err = tx.Update("users", user(11, "a@x"))
+	is.NoError(t, err)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// A duplicate left at commit aborts the transaction.
+	tx = DBTX{}
+	tt.db.Begin(&tx)
+	tx.Defer(unique)
+	_, err = tx.Insert("users", user(12, "a@x"))
+	is.NoError(t, err)
+	is.ErrorContains(t, tt.db.Commit(&tx), "unique constraint")
+
+	// So does a dangling reference.
+	tx = DBTX{}
+	tt.db.Begin(&tx)
+	tx.Defer(fk)
+	_, err = tx.Insert("orders", order(2, 99))
+	is.NoError(t, err)
+	is.ErrorContains(t, tt.db.Commit(&tx), "foreign key")
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	rec := *(&Record{}).AddInt64("id", 12)
+	ok, err := r.Get("users", &rec)
+	is.NoError(t, err)
+	is.False(t, ok)
+	rec = *(&Record{}).AddInt64("id", 10)
+	ok, err = r.Get("users", &rec)
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, []byte("b@x"), rec.Get("email").Str)
+}
@@ -0,0 +1,587 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Document tables
+// ---------------------------------------------------------------------------
+
+// A table with TableDef.Document set is a document table: besides its
+// primary key it has a single bytes column, which holds one schemaless
+// document per row, encoded as CBOR (RFC 8949). DBTX.DocPut and
+// DBReader.DocGet convert documents to and from Go values, and DocField,
+// DocMatch and DBReader.DocFind query their fields by path. The document
+// column is checked to hold one well-formed CBOR item on every write, so
+// rows written by Insert or SQL are documents too. The fields of documents
+// are not indexed: DocFind reads the whole table.
+//
+// Documents decode to map[string]any, []any, string, []byte, int64 (or
+// uint64 above math.MaxInt64), float64, bool and nil. Maps are encoded with
+// their keys in sorted order, so equal documents have equal encodings.
+
+// ErrBadDocument is returned (wrapped) for a value that is not a document:
+// one that is not well-formed CBOR, uses a feature the decoder does not
+// support (tags, indefinite lengths, maps with non-text keys), or is a Go
+// value MarshalDoc cannot encode.
+var ErrBadDocument = errors.New("bad document")
+
+// maxDocDepth bounds the nesting of arrays and maps in a document.
+const maxDocDepth = 256
+
+// A DocCond compares the field of a document at Path (see DocField) with
+// Val, using one of the operators of Cond. A document missing the field
+// only matches "!=". Numbers compare by value, whatever their types;
+// strings, byte strings and booleans with their own kind; other values and
+// values of different kinds only compare equal or not.
+type DocCond struct {
+	Path string
+	Cmp  string
+	Val  any
+}
+
+// Document is a row of a document table, as DocFind returns it.
+type Document struct {
+	Key  Record // the primary-key columns
+	Body any
+}
+
+// checkDocTable verifies that a document table has a single non-key column,
+// of bytes.
+func checkDocTable(tdef *TableDef) error {
+	if len(tdef.Cols) != tdef.PKeys+1 || tdef.Types[tdef.PKeys] != TypeBytes {
+		return fmt.Errorf("document table must have one bytes column besides its key: %s", tdef.Name)
+	}
+	return nil
+}
+
+// checkDoc verifies that the document column of a row holds a document.
+func checkDoc(tdef *TableDef, values []Value) error {
+	if _, err := UnmarshalDoc(values[tdef.PKeys].Str); err != nil {
+		return fmt.Errorf("%w: column %s", err, tdef.Cols[tdef.PKeys])
+	}
+	return nil
+}
+
+// docTableDef returns the definition of the document table table.
+func docTableDef(tx *DBReader, table string) (*TableDef, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	if !tdef.Document {
+		return nil, fmt.Errorf("not a document table: %s", table)
+	}
+	return tdef, nil
+}
+
+// DocPut stores doc, encoded by MarshalDoc, as the document of the row
+// whose primary key is key, replacing any document it had. Returns (true,
+// nil) if the row is new.
+func (tx *DBTX) DocPut(table string, key Record, doc any) (bool, error) {
+	tdef, err := docTableDef(&tx.DBReader, table)
+	if err != nil {
+		return false, err
+	}
+	data, err := MarshalDoc(doc)
+	if err != nil {
+		return false, err
+	}
+	rec := Record{slices.Clone(key.Cols), slices.Clone(key.Vals)}
+	rec.AddStr(tdef.Cols[tdef.PKeys], data)
+	return tx.Upsert(table, rec)
+}
+
+// DocGet returns the document of the row whose primary key is key. ok is
+// false if there is no such row.
+func (tx *DBReader) DocGet(table string, key Record) (doc any, ok bool, err error) {
+	tdef, err := docTableDef(tx, table)
+	if err != nil {
+		return nil, false, err
+	}
+	rec := Record{slices.Clone(key.Cols), slices.Clone(key.Vals)}
+	if ok, err := tx.Get(table, &rec); !ok || err != nil {
+		return nil, false, err
+	}
+	doc, err = UnmarshalDoc(rec.Get(tdef.Cols[tdef.PKeys]).Str)
+	return doc, err == nil, err
+}
+
+// DocFind returns the rows of a document table, in primary-key order,
+// whose documents satisfy every condition of conds.
+func (tx *DBReader) DocFind(table string, conds ...DocCond) ([]Document, error) {
+	tdef, err := docTableDef(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	conds, err = normDocConds(conds)
+	if err != nil {
+		return nil, err
+	}
+	var docs []Document
+	var cur any // the document of the row the filter last let through
+	var bad error
+	sc := Scanner{Cmp1: btree.CmpGE}
+	sc.Filter = func(rec Record) bool {
+		doc, err := UnmarshalDoc(rec.Get(tdef.Cols[tdef.PKeys]).Str)
+		if err != nil {
+			bad = err
+			return false
+		}
+		cur = doc
+		return DocMatch(doc, conds)
+	}
+	if err := tx.Scan(table, &sc); err != nil {
+		return nil, err
+	}
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		docs = append(docs, Document{Record{rec.Cols[:tdef.PKeys], rec.Vals[:tdef.PKeys]}, cur})
+	}
+	if bad != nil {
+		return nil, bad
+	}
+	return docs, nil
+}
+
+// normDocConds returns conds with their values as a decoded document holds
+// them, so that an int compares with an int64 and a []string with an []any.
+func normDocConds(conds []DocCond) ([]DocCond, error) {
+	out := make([]DocCond, len(conds))
+	for i, c := range conds {
+		data, err := MarshalDoc(c.Val)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = c
+		out[i].Val, err = UnmarshalDoc(data)
+		assert(err == nil)
+	}
+	return out, nil
+}
+
+// DocField returns the field of doc at path: the names of map keys, or the
+// positions of array elements, separated by dots, as in "address.city" or
+// "tags.0". The empty path is doc itself. ok is false if doc has no such
+// field.
+func DocField(doc any, path string) (v any, ok bool) {
+	if path == "" {
+		return doc, true
+	}
+	for part := range strings.SplitSeq(path, ".") {
+		switch d := doc.(type) {
+		case map[string]any:
+			if doc, ok = d[part]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// DocMatch reports whether doc satisfies every condition of conds, whose
+// values must be as UnmarshalDoc returns them. It suits Scanner.Filter for
+// a range of a document table.
+func DocMatch(doc any, conds []DocCond) bool {
+	for _, c := range conds {
+		v, ok := DocField(doc, c.Path)
+		if !ok {
+			if c.Cmp != "!=" {
+				return false
+			}
+			continue
+		}
+		n, ordered := compareDocValues(v, c.Val)
+		var match bool
+		switch c.Cmp {
+		case "==":
+			match = n == 0
+		case "!=":
+			match = n != 0
+		case "<":
+			match = ordered && n < 0
+		case "<=":
+			match = ordered && n <= 0
+		case ">":
+			match = ordered && n > 0
+		case ">=":
+			match = ordered && n >= 0
+		default:
+			panic("DocMatch: bad operator " + c.Cmp)
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// compareDocValues compares two decoded values. ordered is false if they
+// are not of an ordered kind they share; n is then 0 if they are equal and
+// 1 if not.
+func compareDocValues(a, b any) (n int, ordered bool) {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case b:
+				return -1, true
+			}
+			return 1, true
+		}
+	case int64, uint64, float64:
+		if isDocNumber(b) {
+			return compareDocNumbers(a, b), true
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, false
+	}
+	return 1, false
+}
+
+func isDocNumber(v any) bool {
+	switch v.(type) {
+	case int64, uint64, float64:
+		return true
+	}
+	return false
+}
+
+// compareDocNumbers compares two numbers, exactly unless one is a float.
+func compareDocNumbers(a, b any) int {
+	ai, aint := a.(int64)
+	bi, bint := b.(int64)
+	au, auint := a.(uint64)
+	bu, buint := b.(uint64)
+	switch {
+	case aint && bint:
+		return cmpOrdered(ai, bi)
+	case auint && buint:
+		return cmpOrdered(au, bu)
+	case aint && buint:
+		if ai < 0 {
+			return -1
+		}
+		return cmpOrdered(uint64(ai), bu)
+	case auint && bint:
+		return -compareDocNumbers(b, a)
+	}
+	return cmpOrdered(docFloat(a), docFloat(b))
+}
+
+func docFloat(v any) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	}
+	return v.(float64)
+}
+
+func cmpOrdered[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ---------------------------------------------------------------------------
+// CBOR
+// ---------------------------------------------------------------------------
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// MarshalDoc encodes v as a CBOR document. v may be nil, a bool, any
+// integer or float, a string, a byte slice, or a slice, array or map with
+// string keys of such values, or a pointer or interface holding one.
+func MarshalDoc(v any) ([]byte, error) {
+	return appendCBOR(nil, reflect.ValueOf(v), 0)
+}
+
+func appendCBORHead(out []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(out, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(out, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(out, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(out, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(out, major|27), n)
+}
+
+func appendCBOR(out []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > maxDocDepth {
+		return nil, fmt.Errorf("%w: nested too deeply", ErrBadDocument)
+	}
+	if !v.IsValid() {
+		return append(out, cborSimple<<5|22), nil // null
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(out, cborSimple<<5|22), nil
+		}
+		return appendCBOR(out, v.Elem(), depth)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(out, cborSimple<<5|21), nil
+		}
+		return append(out, cborSimple<<5|20), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i < 0 {
+			return appendCBORHead(out, cborNegInt, uint64(-1-i)), nil
+		}
+		return appendCBORHead(out, cborUint, uint64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendCBORHead(out, cborUint, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(out, cborSimple<<5|27), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return append(appendCBORHead(out, cborText, uint64(v.Len())), v.String()...), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			out = appendCBORHead(out, cborBytes, uint64(v.Len()))
+			for i := range v.Len() {
+				out = append(out, byte(v.Index(i).Uint()))
+			}
+			return out, nil
+		}
+		out = appendCBORHead(out, cborArray, uint64(v.Len()))
+		for i := range v.Len() {
+			var err error
+			if out, err = appendCBOR(out, v.Index(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%w: map keys must be strings: %s", ErrBadDocument, v.Type())
+		}
+		// Sort the entries by their encoded keys, as RFC 8949 does for
+		// deterministic encoding.
+		type entry struct{ key, val []byte }
+		entries := make([]entry, 0, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			key := appendCBORHead(nil, cborText, uint64(it.Key().Len()))
+			key = append(key, it.Key().String()...)
+			val, err := appendCBOR(nil, it.Value(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{key, val})
+		}
+		slices.SortFunc(entries, func(a, b entry) int { return bytes.Compare(a.key, b.key) })
+		out = appendCBORHead(out, cborMap, uint64(len(entries)))
+		for _, e := range entries {
+			out = append(append(out, e.key...), e.val...)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%w: cannot encode %s", ErrBadDocument, v.Type())
+}
+
+// UnmarshalDoc decodes a CBOR document, which must be a single item.
+func UnmarshalDoc(data []byte) (any, error) {
+	d := cborDecoder{data: data}
+	v, err := d.value(0)
+	if err == nil && d.pos != len(data) {
+		err = fmt.Errorf("%w: trailing bytes", ErrBadDocument)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: offset %d: %s", ErrBadDocument, d.pos, fmt.Sprintf(format, args...))
+}
+
+// take returns the next n bytes.
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, d.errorf("truncated")
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head decodes the head of an item: its major type, its additional
+// information, and the argument that follows.
+func (d *cborDecoder) head() (major, info byte, n uint64, err error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		arg, err := d.take(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range arg {
+			n = n<<8 | uint64(c)
+		}
+		return major, info, n, nil
+	}
+	return 0, 0, 0, d.errorf("indefinite lengths are not supported")
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > maxDocDepth {
+		return nil, d.errorf("nested too deeply")
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, d.errorf("integer out of range")
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		b, err := d.take(n)
+		return slices.Clone(b), err
+	case cborText:
+		b, err := d.take(n)
+		return string(b), err
+	case cborArray:
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, d.errorf("truncated")
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case cborMap:
+		if n > uint64(len(d.data)-d.pos)/2 {
+			return nil, d.errorf("truncated")
+		}
+		m := make(map[string]any, n)
+		for range n {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := key.(string)
+			if !ok {
+				return nil, d.errorf("map key is not a string")
+			}
+			if _, dup := m[s]; dup {
+				return nil, d.errorf("duplicate map key %q", s)
+			}
+			if m[s], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cborTag:
+		return nil, d.errorf("tags are not supported")
+	}
+	// Simple values and floats.
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil // null, undefined
+	case 25:
+		return halfToFloat(uint16(n)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+	return nil, d.errorf("unsupported simple value %d", n)
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,152 @@
+package tables
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestDocCBOR(t *testing.T) {
+	// Examples of RFC 8949, appendix A.
+	for _, c := range []struct {
+		hex  string
+		want any
+	}{
+		{"00", int64(0)},
+		{"1903e8", int64(1000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"3903e7", int64(-1000)},
+		{"f93e00", 1.5},
+		{"f97c00", math.Inf(1)},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"8301820203820405", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"a26161016162820203", map[string]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+	} {
+		data, err := hex.DecodeString(c.hex)
+		is.NoError(t, err)
+		v, err := UnmarshalDoc(data)
+		is.NoError(t, err, c.hex)
+		is.Equal(t, c.want, v, c.hex)
+	}
+	for _, bad := range []string{"", "19", "9f01ff", "c11a514b67b0", "a10102", "a2616101616102", "0000"} {
+		data, _ := hex.DecodeString(bad)
+		_, err := UnmarshalDoc(data)
+		is.ErrorIs(t, err, ErrBadDocument, bad)
+	}
+
+	// Encoding is deterministic and round-trips.
+	doc := map[string]any{"zz": []string{"x"}, "a": int8(-1), "n": nil, "f": float32(0.5), "t": true}
+	data, err := MarshalDoc(doc)
+	is.NoError(t, err)
+	is.Equal(t, "a56161206166fb3fe0000000000000616ef66174f5627a7a816178", hex.EncodeToString(data))
+	v, err := UnmarshalDoc(data)
+	is.NoError(t, err)
+	is.Equal(t, map[string]any{"zz": []any{"x"}, "a": int64(-1), "n": nil, "f": 0.5, "t": true}, v)
+	_, err = MarshalDoc(map[int]any{1: 2})
+	is.ErrorIs(t, err, ErrBadDocument)
+	_, err = MarshalDoc(struct{}{})
+	is.ErrorIs(t, err, ErrBadDocument)
+}
+
+func TestDocField(t *testing.T) {
+	doc := map[string]any{"a": map[string]any{"b": []any{int64(1), "two"}}}
+	v, ok := DocField(doc, "a.b.1")
+	is.True(t, ok)
+	is.Equal(t, "two", v)
+	v, ok = DocField(doc, "")
+	is.True(t, ok)
+	is.Equal(t, doc, v)
+	for _, path := range []string{"x", "a.c", "a.b.2", "a.b.-1", "a.b.x", "a.b.0.c"} {
+		_, ok := DocField(doc, path)
+		is.False(t, ok, path)
+	}
+
+	is.True(t, DocMatch(int64(3), []DocCond{{"", "<", 3.5}, {"", ">", uint64(0)}, {"", "==", 3.0}}))
+	is.True(t, DocMatch(uint64(math.MaxUint64), []DocCond{{"", ">", int64(math.MaxInt64)}}))
+	is.False(t, DocMatch("3", []DocCond{{"", "<", int64(4)}}))
+	is.True(t, DocMatch("3", []DocCond{{"", "!=", int64(3)}}))
+	is.True(t, DocMatch(doc, []DocCond{{"a.b", "==", []any{int64(1), "two"}}, {"missing", "!=", nil}}))
+}
+
+func TestDocTable(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:     "people",
+		Cols:     []string{"id", "doc"},
+		Types:    []uint32{TypeInt64, TypeBytes},
+		PKeys:    1,
+		Document: true,
+	})
+	key := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	for i, doc := range []map[string]any{
+		{"name": "ann", "age": 31, "address": map[string]any{"city": "Oslo"}},
+		{"name": "bob", "age": 25.5, "tags": []string{"admin"}},
+		{"name": "cid"},
+	} {
+		added, err := tx.DocPut("people", key(int64(i+1)), doc)
+		is.NoError(t, err)
+		is.True(t, added)
+	}
+	added, err := tx.DocPut("people", key(3), map[string]any{"name": "cid", "age": 40})
+	is.NoError(t, err)
+	is.False(t, added)
+	// The document column only holds documents.
+	_, err = tx.Insert("people", *(&Record{}).AddInt64("id", 9).AddStr("doc", []byte{0xff}))
+	is.ErrorIs(t, err, ErrBadDocument)
+	_, err = tx.DocPut("people", key(9), func() {})
+	is.ErrorIs(t, err, ErrBadDocument)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	doc, ok, err := r.DocGet("people", key(1))
+	is.NoError(t, err)
+	is.True(t, ok)
+	city, _ := DocField(doc, "address.city")
+	is.Equal(t, "Oslo", city)
+	_, ok, err = r.DocGet("people", key(7))
+	is.NoError(t, err)
+	is.False(t, ok)
+
+	find := func(conds ...DocCond) []int64 {
+		docs, err := r.DocFind("people", conds...)
+		is.NoError(t, err)
+		var ids []int64
+		for _, d := range docs {
+			ids = append(ids, d.Key.Get("id").I64)
+			name, _ := DocField(d.Body, "name")
+			is.NotEmpty(t, name)
+		}
+		return ids
+	}
+	is.Equal(t, []int64{1, 2, 3}, find())
+	is.Equal(t, []int64{1, 3}, find(DocCond{"age", ">", 30}))
+	is.Equal(t, []int64{2}, find(DocCond{"age", "<", 30}, DocCond{"tags.0", "==", "admin"}))
+	is.Equal(t, []int64{1, 3}, find(DocCond{"tags", "!=", []string{"admin"}}))
+	is.Empty(t, find(DocCond{"name", "==", "dan"}))
+
+	_, err = r.DocFind("@table")
+	is.Error(t, err)
+
+	// A document table has one bytes column besides its key.
+	wtx := DBTX{}
+	tt.db.Begin(&wtx)
+	defer tt.db.Abort(&wtx)
+	err = wtx.TableNew(&TableDef{
+		Name: "bad", Cols: []string{"id", "doc", "x"}, Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys: 1, Document: true,
+	})
+	is.Error(t, err)
+}
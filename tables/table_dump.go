@@ -0,0 +1,266 @@
+package tables
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Logical dump and load
+// ---------------------------------------------------------------------------
+
+// A dump is a stream of JSON values, one per line: a dumpHeader, then for
+// each table its definition followed by its rows. Rows are JSON arrays in
+// column order, with int64 columns as numbers and bytes columns as base64
+// strings. Nothing in it depends on the on-disk format, so a dump taken by
+// one build can be loaded by another whatever their FormatVersion.
+
+const (
+	dumpMagic = "ElkDB dump"
+	// DumpVersion is the revision of the dump format written by DB.Dump.
+	DumpVersion = 1
+)
+
+// loadBatch is the number of rows inserted per transaction by DB.Load.
+const loadBatch = 1024
+
+type dumpHeader struct {
+	Magic   string
+	Version int
+}
+
+// dumpEntry is one line after the header: either a table or a row of the
+// table most recently defined.
+type dumpEntry struct {
+	Table *TableDef         `json:",omitempty"`
+	Row   []json.RawMessage `json:",omitempty"`
+}
+
+// Dump writes every user table, definition and rows, to w from a single
+// consistent snapshot. Internal tables are not dumped: prefixes, quota
+// usage and checkpoints are rebuilt or meaningless on load. Rows moved to
+// the cold tier by Archive are not included; Restore them first.
+func (db *DB) Dump(w io.Writer) error {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return dump(&tx, w)
+}
+
+func dump(tx *DBReader, w io.Writer) error {
+	enc := json.NewEncoder(tx.db.Maintenance.Writer(w))
+	if err := enc.Encode(dumpHeader{dumpMagic, DumpVersion}); err != nil {
+		return err
+	}
+
+	names, err := tableNames(tx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		tdef := *getTableDef(tx, name)
+		tdef.Prefix, tdef.IndexPrefixes, tdef.Building, tdef.Rewrite = 0, nil, nil, nil
+		if err := enc.Encode(dumpEntry{Table: &tdef}); err != nil {
+			return err
+		}
+		if err := dumpRows(tx, &tdef, enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableNames returns the names of the user tables in tx, in order.
+func tableNames(tx *DBReader) ([]string, error) {
+	var names []string
+	sc := Scanner{Cmp1: btree.CmpGE}
+	if err := dbScan(tx, tdefTable, &sc); err != nil {
+		return nil, err
+	}
+	var rec Record
+	for ; sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		names = append(names, string(rec.Get("name").Str))
+	}
+	return names, nil
+}
+
+func dumpRows(tx *DBReader, tdef *TableDef, enc *json.Encoder) error {
+	sc := Scanner{Cmp1: btree.CmpGE}
+	if err := tx.Scan(tdef.Name, &sc); err != nil {
+		return err
+	}
+	var rec Record
+	for ; sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		row := make([]json.RawMessage, len(rec.Vals))
+		for i, v := range rec.Vals {
+			var err error
+			switch v.Type {
+			case TypeInt64:
+				row[i], err = json.Marshal(v.I64)
+			case TypeFloat64:
+				row[i], err = marshalFloat(v.F64)
+			case TypeUUID:
+				row[i], err = json.Marshal(UUID(v.Str).String())
+			default:
+				row[i], err = json.Marshal(v.Str)
+			}
+			assert(err == nil)
+		}
+		if err := enc.Encode(dumpEntry{Row: row}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads a dump written by Dump and recreates its tables and rows. The
+// tables must not exist yet. Each table is created in its own transaction
+// and its rows are inserted in batches, so a failed load leaves the tables
+// and rows before the failure in place.
+func (db *DB) Load(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	var hdr dumpHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return fmt.Errorf("load: bad dump header: %w", err)
+	}
+	if hdr.Magic != dumpMagic {
+		return fmt.Errorf("load: not an ElkDB dump")
+	}
+	if hdr.Version < 1 || hdr.Version > DumpVersion {
+		return fmt.Errorf("load: unsupported dump version %d (max %d)", hdr.Version, DumpVersion)
+	}
+
+	var tdef *TableDef
+	var batch []Record
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := db.loadRows(tdef.Name, batch)
+		batch = batch[:0]
+		return err
+	}
+	for {
+		var ent dumpEntry
+		err := dec.Decode(&ent)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("load: %w", err)
+		}
+
+		switch {
+		case ent.Table != nil:
+			if err := flush(); err != nil {
+				return err
+			}
+			tdef = ent.Table
+			tdef.Prefix, tdef.IndexPrefixes, tdef.Building, tdef.Rewrite = 0, nil, nil, nil
+			if err := db.loadTable(tdef); err != nil {
+				return err
+			}
+		case tdef == nil:
+			return fmt.Errorf("load: row before any table")
+		default:
+			rec, err := decodeDumpRow(tdef, ent.Row)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, rec)
+			if len(batch) >= loadBatch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+func (db *DB) loadTable(tdef *TableDef) error {
+	tx := DBTX{}
+	db.Begin(&tx)
+	if err := tx.TableNew(tdef); err != nil {
+		db.Abort(&tx)
+		return fmt.Errorf("load: %w", err)
+	}
+	return db.Commit(&tx)
+}
+
+func (db *DB) loadRows(table string, rows []Record) error {
+	tx := DBTX{}
+	db.Begin(&tx)
+	for _, rec := range rows {
+		added, err := tx.Insert(table, rec)
+		if err == nil && !added {
+			err = ErrDuplicate
+		}
+		if err != nil {
+			db.Abort(&tx)
+			return fmt.Errorf("load %s: %w", table, err)
+		}
+	}
+	return db.Commit(&tx)
+}
+
+func decodeDumpRow(tdef *TableDef, row []json.RawMessage) (Record, error) {
+	if len(row) != len(tdef.Cols) {
+		return Record{}, fmt.Errorf("load %s: row has %d columns, want %d", tdef.Name, len(row), len(tdef.Cols))
+	}
+	rec := Record{Cols: tdef.Cols, Vals: make([]Value, len(row))}
+	for i, raw := range row {
+		v := &rec.Vals[i]
+		v.Type = tdef.Types[i]
+		var err error
+		switch v.Type {
+		case TypeInt64:
+			err = json.Unmarshal(raw, &v.I64)
+		case TypeFloat64:
+			v.F64, err = unmarshalFloat(raw)
+		case TypeUUID:
+			var s string
+			var id UUID
+			if err = json.Unmarshal(raw, &s); err == nil {
+				id, err = ParseUUID(s)
+				v.Str = id[:]
+			}
+		case TypeBytes:
+			err = json.Unmarshal(raw, &v.Str)
+		default:
+			err = fmt.Errorf("unknown column type %d", v.Type)
+		}
+		if err != nil {
+			return Record{}, fmt.Errorf("load %s.%s: %w", tdef.Name, tdef.Cols[i], err)
+		}
+	}
+	return rec, nil
+}
+
+// marshalFloat encodes f as a JSON number, or as the string "NaN", "+Inf"
+// or "-Inf", which JSON has no number for.
+func marshalFloat(f float64) (json.RawMessage, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return json.Marshal(f)
+}
+
+// unmarshalFloat inverts marshalFloat.
+func unmarshalFloat(raw json.RawMessage) (float64, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return strconv.ParseFloat(s, 64)
+	}
+	var f float64
+	err := json.Unmarshal(raw, &f)
+	return f, err
+}
@@ -0,0 +1,107 @@
+package tables
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestDumpLoad(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "users",
+		Cols:    []string{"id", "name", "age"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"name"}},
+	})
+	tt.create(&TableDef{
+		Name:  "blobs",
+		Cols:  []string{"k", "v"},
+		Types: []uint32{TypeBytes, TypeBytes},
+		PKeys: 1,
+		Quota: 1 << 20,
+	})
+	tt.create(&TableDef{
+		Name:  "floats",
+		Cols:  []string{"f", "id", "u"},
+		Types: []uint32{TypeFloat64, TypeInt64, TypeUUID},
+		PKeys: 1,
+	})
+	for i := int64(0); i < 3000; i++ {
+		tt.add("users", *(&Record{}).AddInt64("id", i).AddStr("name", []byte{'u', byte(i % 7)}).AddInt64("age", -i))
+	}
+	tt.add("blobs", *(&Record{}).AddStr("k", []byte("bin")).AddStr("v", []byte{0, 1, 0xff, '\n'}))
+	tt.add("blobs", *(&Record{}).AddStr("k", []byte("empty")).AddStr("v", nil))
+	for i, f := range []float64{math.Inf(-1), -0.1, 1e300, math.Inf(1)} {
+		tt.add("floats", *(&Record{}).AddFloat64("f", f).AddInt64("id", int64(i)).AddUUID("u", NewUUIDv7()))
+	}
+
+	var dump bytes.Buffer
+	is.NoError(t, tt.db.Dump(&dump))
+	is.True(t, strings.HasPrefix(dump.String(), `{"Magic":"ElkDB dump","Version":1}`))
+
+	path := filepath.Join(t.TempDir(), "load.db")
+	db := DB{Path: path}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	is.NoError(t, db.Load(bytes.NewReader(dump.Bytes())))
+
+	rows := func(db *DB, table string, sc Scanner) []Record {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		is.NoError(t, r.Scan(table, &sc))
+		var out []Record
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			for i := range rec.Vals {
+				if rec.Vals[i].Str != nil && len(rec.Vals[i].Str) == 0 {
+					rec.Vals[i].Str = nil
+				}
+			}
+			out = append(out, rec)
+		}
+		return out
+	}
+	all := Scanner{Cmp1: btree.CmpGE}
+	for _, table := range []string{"users", "blobs", "floats"} {
+		is.Equal(t, rows(&tt.db, table, all), rows(&db, table, all))
+	}
+
+	// Secondary indexes are rebuilt and definitions carry over.
+	byName := Scanner{
+		Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("name", []byte{'u', 3}),
+		Cmp2: btree.CmpLE, Key2: *(&Record{}).AddStr("name", []byte{'u', 3}),
+	}
+	is.Len(t, rows(&db, "users", byName), 429)
+	r := DBReader{}
+	db.BeginRead(&r)
+	is.Equal(t, int64(1<<20), r.TableDef("blobs").Quota)
+	used, err := r.Usage("blobs")
+	db.EndRead(&r)
+	is.NoError(t, err)
+	is.Positive(t, used)
+
+	// Loading into a database that already has the tables fails.
+	is.ErrorContains(t, db.Load(bytes.NewReader(dump.Bytes())), "table exists")
+}
+
+func TestLoadRejectsBadDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "load.db")
+	db := DB{Path: path}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	is.ErrorContains(t, db.Load(strings.NewReader(`{"Magic":"other","Version":1}`)), "not an ElkDB dump")
+	is.ErrorContains(t, db.Load(strings.NewReader(`{"Magic":"ElkDB dump","Version":99}`)), "unsupported dump version")
+	is.ErrorContains(t, db.Load(strings.NewReader(`{"Magic":"ElkDB dump","Version":1}
+{"Row":[1]}`)), "row before any table")
+}
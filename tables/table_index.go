@@ -0,0 +1,258 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Online index builds
+// ---------------------------------------------------------------------------
+
+// IndexReq describes an index built by DB.IndexAdd or rebuilt by
+// DB.Reindex.
+type IndexReq struct {
+	Table   string
+	Index   []string // the index columns, as in TableDef.Indexes
+	Include []string // see TableDef.Include; IndexAdd only
+	Where   []Cond   // see TableDef.Where; IndexAdd only
+
+	BatchSize int            // rows per transaction (0 = DefaultBackfillBatch)
+	Pause     time.Duration  // sleep between batches to throttle the build
+	Progress  func(rows int) // called after each committed batch with the running total
+}
+
+// The phases of an index build, the first byte of its checkpoint.
+const (
+	indexPurge = 'p' // deleting the old entries; then the last key deleted
+	indexFill  = 'f' // adding an entry per row; then the last primary key
+)
+
+// IndexAdd adds an index to a table that may already hold rows. The index
+// is added in a first transaction, marked as Building: from then on writes
+// maintain it, but scans do not use it. A backfill then adds the entries of
+// the existing rows in primary-key order, in batches of short transactions
+// like Backfill, so concurrent writers are never blocked for longer than
+// one batch. The last transaction clears Building. Progress is checkpointed
+// in @meta: if the build is interrupted, calling IndexAdd again with the
+// same index resumes it.
+func (db *DB) IndexAdd(req *IndexReq) error {
+	prefix, err := db.indexStart(req, true)
+	if err != nil {
+		return err
+	}
+	return db.indexBuild(req, prefix)
+}
+
+// IndexRebuild rebuilds an index of table that may hold wrong entries,
+// using default batching. See Reindex.
+func (db *DB) IndexRebuild(table string, index []string) error {
+	return db.Reindex(&IndexReq{Table: table, Index: index})
+}
+
+// Reindex rebuilds an existing index from the rows of its table, to repair
+// it. The index is marked Building, so scans stop using it; its entries are
+// then deleted and added again from the rows, in batches, as IndexAdd does.
+// Like IndexAdd, it resumes an interrupted build.
+func (db *DB) Reindex(req *IndexReq) error {
+	prefix, err := db.indexStart(req, false)
+	if err != nil {
+		return err
+	}
+	return db.indexBuild(req, prefix)
+}
+
+// indexStart marks the index of req as Building, adding it first if add is
+// set, and returns its prefix. An index already being built is left as it
+// is, so that its build resumes.
+func (db *DB) indexStart(req *IndexReq, add bool) (uint32, error) {
+	var prefix uint32
+	err := db.Update(func(tx *DBTX) (err error) {
+		prefix, err = indexStart(tx, req, add)
+		return err
+	})
+	return prefix, err
+}
+
+func indexStart(tx *DBTX, req *IndexReq, add bool) (uint32, error) {
+	tdef := getTableDefFromDisk(&tx.DBReader, req.Table)
+	if tdef == nil {
+		return 0, fmt.Errorf("table not found: %s", req.Table)
+	}
+	if tdef.Rewrite != nil {
+		return 0, fmt.Errorf("cannot build an index of %s while its columns change", req.Table)
+	}
+	index, err := checkIndexKeys(tdef, slices.Clone(req.Index))
+	if err != nil {
+		return 0, err
+	}
+	i := slices.IndexFunc(tdef.Indexes, func(cols []string) bool { return slices.Equal(cols, index) })
+	switch {
+	case i >= 0 && tdef.building(i):
+		return tdef.IndexPrefixes[i], nil
+	case i >= 0 && add:
+		return 0, fmt.Errorf("index exists: %v", req.Index)
+	case i < 0 && !add:
+		return 0, fmt.Errorf("no such index: %v", req.Index)
+	}
+
+	phase := []byte{indexPurge}
+	if add {
+		phase[0] = indexFill
+		i = len(tdef.Indexes)
+		tdef.Indexes = append(tdef.Indexes, index)
+		if req.Include != nil {
+			tdef.Include = append(tdef.Include, make([][]string, i-len(tdef.Include))...)
+			tdef.Include = append(tdef.Include, req.Include)
+		}
+		if req.Where != nil {
+			tdef.Where = append(tdef.Where, make([][]Cond, i-len(tdef.Where))...)
+			tdef.Where = append(tdef.Where, req.Where)
+		}
+		if err := tableDefCheck(tdef); err != nil {
+			return 0, err
+		}
+		prefixes, err := allocPrefixes(tx, 1)
+		if err != nil {
+			return 0, err
+		}
+		tdef.IndexPrefixes = append(tdef.IndexPrefixes, prefixes[0])
+	}
+	tdef.Building = append(tdef.Building, make([]bool, i+1-len(tdef.Building))...)
+	tdef.Building[i] = true
+	if err := putTableDef(tx, tdef); err != nil {
+		return 0, err
+	}
+	ckpt := (&Record{}).AddStr("key", indexCheckpoint(tdef, tdef.IndexPrefixes[i])).AddStr("val", phase)
+	return tdef.IndexPrefixes[i], dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+}
+
+// indexCheckpoint returns the @meta key of the checkpoint of the build of
+// the index with prefix.
+func indexCheckpoint(tdef *TableDef, prefix uint32) []byte {
+	return fmt.Appendf(nil, "index:%s.%d", tdef.Name, prefix)
+}
+
+// indexBuild runs the batches of the build of the index with prefix until
+// it is done.
+func (db *DB) indexBuild(req *IndexReq, prefix uint32) error {
+	batch := req.BatchSize
+	if batch <= 0 {
+		batch = DefaultBackfillBatch
+	}
+
+	total := 0
+	for {
+		n, size, done, err := db.indexBatchRetry(req.Table, prefix, batch)
+		if err != nil {
+			return err
+		}
+		db.Maintenance.Wait(n, int64(size))
+		total += n
+		if req.Progress != nil && n > 0 {
+			req.Progress(total)
+		}
+		if done {
+			return nil
+		}
+		if req.Pause > 0 {
+			time.Sleep(req.Pause)
+		}
+	}
+}
+
+func (db *DB) indexBatchRetry(table string, prefix uint32, batch int) (int, int, bool, error) {
+	var n, size int
+	var done bool
+	err := db.Update(func(tx *DBTX) (err error) {
+		n, size, done, err = indexBatch(tx, table, prefix, batch)
+		return err
+	})
+	return n, size, done, err
+}
+
+// indexBatch runs one batch of the build of the index with prefix and
+// advances its checkpoint. While purging, it deletes up to batch entries;
+// while filling, it adds the entries of up to batch rows, and clears
+// Building once the end of the table has been reached. It returns the
+// number of entries written or deleted and their size in bytes.
+func indexBatch(tx *DBTX, table string, prefix uint32, batch int) (int, int, bool, error) {
+	tdef := getTableDef(&tx.DBReader, table)
+	if tdef == nil {
+		return 0, 0, false, fmt.Errorf("table not found: %s", table)
+	}
+	i := slices.Index(tdef.IndexPrefixes, prefix)
+	if i < 0 || !tdef.building(i) {
+		return 0, 0, false, fmt.Errorf("index of %s no longer being built", table)
+	}
+	ckptKey := indexCheckpoint(tdef, prefix)
+	ckpt := (&Record{}).AddStr("key", ckptKey)
+	ok, err := dbGet(&tx.DBReader, tdefMeta, ckpt)
+	assert(err == nil && ok)
+	state := ckpt.Get("val").Str
+
+	if state[0] == indexPurge {
+		// Delete the entries after the last deleted one.
+		start, cmp := binary.BigEndian.AppendUint32(nil, prefix), btree.CmpGE
+		if len(state) > 1 {
+			start, cmp = state[1:], btree.CmpGT
+		}
+		var keys [][]byte
+		size := 0
+		for it := tx.kvr.Seek(start, cmp); it.Valid() && len(keys) < batch; it.Next() {
+			key, val := it.Deref()
+			if !bytes.HasPrefix(key, start[:4]) {
+				break
+			}
+			keys = append(keys, slices.Clone(key))
+			size += len(key) + len(val)
+		}
+		for _, key := range keys {
+			assert(tx.kvw.Del(&btree.DeleteReq{Key: key}))
+		}
+		state = []byte{indexFill}
+		if len(keys) == batch {
+			state = append([]byte{indexPurge}, keys[len(keys)-1]...)
+		}
+		ckpt = (&Record{}).AddStr("key", ckptKey).AddStr("val", state)
+		return len(keys), size, false, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+	}
+
+	// Add the entries of the rows after the last primary key done. Expired
+	// rows get one too, since deleting them deletes it.
+	sc := resumeScanner(tdef, state[1:], true)
+	if err := dbScan(&tx.DBReader, tdef, &sc); err != nil {
+		return 0, 0, false, err
+	}
+	rows := collectBatch(&sc, batch)
+	size := 0
+	for _, rec := range rows {
+		if !tdef.indexed(i, rec) {
+			continue
+		}
+		key, val := indexEntry(tdef, i, rec)
+		tx.kvw.Update(&btree.InsertReq{Key: key, Val: val, Mode: btree.ModeUpsert})
+		size += len(key) + len(val)
+	}
+
+	n := len(rows)
+	if sc.Valid() {
+		state = append([]byte{indexFill}, encodeValues(nil, rows[n-1].Vals[:tdef.PKeys])...)
+		ckpt = (&Record{}).AddStr("key", ckptKey).AddStr("val", state)
+		return n, size, false, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *ckpt})
+	}
+	_, err = dbDelete(tx, tdefMeta, *(&Record{}).AddStr("key", ckptKey))
+	assert(err == nil)
+	done := *tdef
+	done.Building = slices.Clone(tdef.Building)
+	done.Building[i] = false
+	if !slices.Contains(done.Building, true) {
+		done.Building = nil
+	}
+	return n, size, true, putTableDef(tx, &done)
+}
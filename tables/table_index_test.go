@@ -0,0 +1,205 @@
+package tables
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+// indexRows returns the ids of the rows found through the index on tag, in
+// index order, and the ids of all rows ordered by tag then id.
+func indexRows(t *testing.T, db *DB) (got, want []int64) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	sc := Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("tag", nil)}
+	is.NoError(t, tx.Scan("t", &sc))
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		got = append(got, rec.Get("id").I64)
+	}
+
+	// Every entry must point at a row, so count them too.
+	tdef := tx.TableDef("t")
+	prefix := tdef.IndexPrefixes[len(tdef.IndexPrefixes)-1]
+	entries := 0
+	for it := tx.kvr.Seek(binary.BigEndian.AppendUint32(nil, prefix), btree.CmpGE); it.Valid(); it.Next() {
+		if key, _ := it.Deref(); binary.BigEndian.Uint32(key) != prefix {
+			break
+		}
+		entries++
+	}
+	is.Equal(t, len(got), entries)
+
+	type row struct {
+		tag string
+		id  int64
+	}
+	var rows []row
+	sc = Scanner{Cmp1: btree.CmpGE}
+	is.NoError(t, tx.Scan("t", &sc))
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		rows = append(rows, row{string(rec.Get("tag").Str), rec.Get("id").I64})
+	}
+	slices.SortFunc(rows, func(a, b row) int {
+		return strings.Compare(fmt.Sprintf("%s\x00%08d", a.tag, a.id), fmt.Sprintf("%s\x00%08d", b.tag, b.id))
+	})
+	for _, r := range rows {
+		want = append(want, r.id)
+	}
+	return got, want
+}
+
+func TestIndexAdd(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "tag", "v"},
+		Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys: 1,
+	})
+	for i := range 500 {
+		tt.add("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("tag", fmt.Appendf(nil, "t%d", i%7)).AddInt64("v", 0))
+	}
+
+	// Writers keep going during the build.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tx := DBTX{}
+			tt.db.Begin(&tx)
+			id := int64(i * 37 % 600)
+			var err error
+			if i%3 == 0 {
+				_, err = tx.Delete("t", *(&Record{}).AddInt64("id", id))
+			} else {
+				_, err = tx.Upsert("t", *(&Record{}).AddInt64("id", id).AddStr("tag", fmt.Appendf(nil, "w%d", i%5)).AddInt64("v", 1))
+			}
+			if err != nil {
+				tt.db.Abort(&tx)
+				t.Error(err)
+				return
+			}
+			_ = tt.db.Commit(&tx)
+		}
+	}()
+
+	var progress []int
+	err := tt.db.IndexAdd(&IndexReq{
+		Table: "t", Index: []string{"tag"}, BatchSize: 50,
+		Progress: func(n int) { progress = append(progress, n) },
+	})
+	close(stop)
+	wg.Wait()
+	is.NoError(t, err)
+	is.NotEmpty(t, progress)
+	got, want := indexRows(t, &tt.db)
+	is.Equal(t, want, got)
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	tdef := tx.TableDef("t")
+	is.Equal(t, [][]string{{"tag", "id"}}, tdef.Indexes)
+	is.Nil(t, tdef.Building)
+	tt.db.Abort(&tx)
+	is.ErrorContains(t, tt.db.IndexAdd(&IndexReq{Table: "t", Index: []string{"tag"}}), "index exists")
+	is.ErrorContains(t, tt.db.IndexAdd(&IndexReq{Table: "t", Index: []string{"nope"}}), "unknown index column")
+}
+
+func TestIndexResume(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "t",
+		Cols:  []string{"id", "tag", "v"},
+		Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys: 1,
+	})
+	for i := range 100 {
+		tt.add("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("tag", fmt.Appendf(nil, "t%d", i%7)).AddInt64("v", 0))
+	}
+
+	// Stop after two batches.
+	req := &IndexReq{Table: "t", Index: []string{"tag"}, BatchSize: 30}
+	prefix, err := tt.db.indexStart(req, true)
+	is.NoError(t, err)
+	for range 2 {
+		_, _, done, err := tt.db.indexBatchRetry("t", prefix, 30)
+		is.NoError(t, err)
+		is.False(t, done)
+	}
+
+	// The index is maintained but not used yet.
+	tt.add("t", *(&Record{}).AddInt64("id", 200).AddStr("tag", []byte("new")).AddInt64("v", 0))
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	sc := Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("tag", nil)}
+	is.ErrorContains(t, tx.Scan("t", &sc), "no index")
+	is.Equal(t, []bool{true}, tx.TableDef("t").Building)
+	tt.db.Abort(&tx)
+
+	rows := 0
+	is.NoError(t, tt.db.IndexAdd(&IndexReq{
+		Table: "t", Index: []string{"tag"}, BatchSize: 30,
+		Progress: func(n int) { rows = n },
+	}))
+	is.Equal(t, 41, rows) // rows 60..99 and 200
+	got, want := indexRows(t, &tt.db)
+	is.Equal(t, want, got)
+}
+
+func TestIndexRebuild(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}},
+	})
+	for i := range 100 {
+		tt.add("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("tag", fmt.Appendf(nil, "t%d", i%7)).AddInt64("v", 0))
+	}
+
+	// Damage the index: drop an entry and add one that points at the wrong
+	// row.
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	tdef := tx.TableDef("t")
+	key, _ := indexEntry(tdef, 0, *(&Record{}).AddInt64("id", 5).AddStr("tag", []byte("t5")))
+	is.True(t, tx.kvw.Del(&btree.DeleteReq{Key: key}))
+	key, _ = indexEntry(tdef, 0, *(&Record{}).AddInt64("id", 3).AddStr("tag", []byte("zz")))
+	is.True(t, tx.kvw.Update(&btree.InsertReq{Key: key}))
+	is.NoError(t, tt.db.Commit(&tx))
+	got, want := indexRows(t, &tt.db)
+	is.NotEqual(t, want, got)
+
+	is.NoError(t, tt.db.Reindex(&IndexReq{Table: "t", Index: []string{"tag"}, BatchSize: 16}))
+	got, want = indexRows(t, &tt.db)
+	is.Equal(t, want, got)
+	rep, err := tt.db.CheckIndexes("t")
+	is.NoError(t, err)
+	is.True(t, rep.OK(), "%v", rep.Problems)
+	is.NoError(t, tt.db.IndexRebuild("t", []string{"tag", "id"}))
+	is.ErrorContains(t, tt.db.IndexRebuild("t", []string{"v"}), "no such index")
+	is.ErrorContains(t, tt.db.IndexRebuild("nope", []string{"v"}), "table not found")
+}
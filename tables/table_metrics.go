@@ -0,0 +1,52 @@
+package tables
+
+import (
+	"sync/atomic"
+
+	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/metrics"
+)
+
+// ---------------------------------------------------------------------------
+// Metrics
+// ---------------------------------------------------------------------------
+
+// Metrics is a snapshot of a DB's row-level counters together with those of
+// the underlying KV. Only the public Get, Scan, Set and Delete calls are
+// counted, not the index and catalog accesses they make.
+type Metrics struct {
+	KV      kv.Metrics
+	Gets    uint64 // DBReader.Get calls
+	Scans   uint64 // DBReader.Scan calls
+	Sets    uint64 // DBTX.Set calls, including Insert, Update and Upsert, and InsertMany rows
+	Deletes uint64 // DBTX.Delete calls
+}
+
+type dbStats struct {
+	gets, scans, sets, deletes atomic.Uint64
+	defHits, defMisses         atomic.Uint64 // see getTableDef
+	authHits, authMisses       atomic.Uint64 // see Authenticate
+}
+
+// Metrics returns a snapshot of the counters, for embedders that report
+// them their own way.
+func (db *DB) Metrics() Metrics {
+	return Metrics{
+		KV:      db.kv.Metrics(),
+		Gets:    db.stats.gets.Load(),
+		Scans:   db.stats.scans.Load(),
+		Sets:    db.stats.sets.Load(),
+		Deletes: db.stats.deletes.Load(),
+	}
+}
+
+// WriteMetrics makes a DB a metrics.Source, so that it can be served with
+// metrics.Handler.
+func (db *DB) WriteMetrics(w *metrics.Writer) {
+	m := db.Metrics()
+	m.KV.Write(w)
+	w.Counter("elkdb_table_gets_total", "Row lookups by primary key.", m.Gets)
+	w.Counter("elkdb_table_scans_total", "Range scans started.", m.Scans)
+	w.Counter("elkdb_table_sets_total", "Row inserts, updates and upserts.", m.Sets)
+	w.Counter("elkdb_table_deletes_total", "Row deletes.", m.Deletes)
+}
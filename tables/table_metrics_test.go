@@ -0,0 +1,55 @@
+package tables
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/metrics"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "metrics.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	tx := DBTX{}
+	db.Begin(&tx)
+	is.NoError(t, tx.TableNew(&TableDef{
+		Name:  "t",
+		Cols:  []string{"k", "v"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+	}))
+	for i := range 3 {
+		_, err := tx.Insert("t", *(&Record{}).AddInt64("k", int64(i)).AddStr("v", []byte("x")))
+		is.NoError(t, err)
+	}
+	_, err := tx.Delete("t", *(&Record{}).AddInt64("k", 0))
+	is.NoError(t, err)
+	is.NoError(t, db.Commit(&tx))
+
+	r := DBReader{}
+	db.BeginRead(&r)
+	_, err = r.Get("t", (&Record{}).AddInt64("k", 1))
+	is.NoError(t, err)
+	is.NoError(t, r.Scan("t", &Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddInt64("k", 0)}))
+	db.EndRead(&r)
+
+	m := db.Metrics()
+	is.Equal(t, uint64(1), m.Gets)
+	is.Equal(t, uint64(1), m.Scans)
+	is.Equal(t, uint64(3), m.Sets)
+	is.Equal(t, uint64(1), m.Deletes)
+	is.Equal(t, uint64(1), m.KV.Commits)
+	is.Greater(t, m.KV.Sets, m.Sets) // the catalog rows are KV writes too
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	db.WriteMetrics(w)
+	is.NoError(t, w.Flush())
+	is.Contains(t, buf.String(), "elkdb_table_sets_total 3\n")
+	is.Contains(t, buf.String(), "elkdb_kv_commits_total 1\n")
+}
@@ -1,9 +1,14 @@
 package tables
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"time"
 
 	"github.com/MHS-20/ElkDB/btree"
 )
@@ -14,12 +19,15 @@ import (
 
 // dbGet fetches one row by its primary key using a point-scan.
 // On success it fills rec with the full row; on a miss it returns (false, nil).
+// Expired rows are found too.
 func dbGet(tx *DBReader, tdef *TableDef, rec *Record) (bool, error) {
 	sc := Scanner{
-		Cmp1: btree.CmpGE,
-		Cmp2: btree.CmpLE,
-		Key1: *rec,
-		Key2: *rec,
+		Cmp1:  btree.CmpGE,
+		Cmp2:  btree.CmpLE,
+		Key1:  *rec,
+		Key2:  *rec,
+		raw:   true,
+		exact: true,
 	}
 	if err := dbScan(tx, tdef, &sc); err != nil {
 		return false, err
@@ -37,6 +45,7 @@ func dbGet(tx *DBReader, tdef *TableDef, rec *Record) (bool, error) {
 // rec must contain at least the primary-key columns on entry; on success it
 // is rewritten with the full row.
 func (tx *DBReader) Get(table string, rec *Record) (bool, error) {
+	tx.db.stats.gets.Add(1)
 	tdef := getTableDef(tx, table)
 	if tdef == nil {
 		return false, fmt.Errorf("table not found: %s", table)
@@ -47,7 +56,18 @@ func (tx *DBReader) Get(table string, rec *Record) (bool, error) {
 	}
 	rec.Cols = tdef.Cols[:tdef.PKeys]
 	rec.Vals = values[:tdef.PKeys]
-	return dbGet(tx, tdef, rec)
+	ok, err := dbGet(tx, tdef, rec)
+	if ok && tdef.Expires && expired(rowDeadline(tx, tdef, rec.Vals[:tdef.PKeys]), time.Now().UnixNano()) {
+		return false, nil
+	}
+	if ok && tdef.SoftDelete && rowDeleted(tx, tdef, rec.Vals[:tdef.PKeys]) != 0 {
+		return false, nil
+	}
+	if ok || err != nil || tx.db.Segments == nil {
+		return ok, err
+	}
+	// Fall back to the cold tier.
+	return archiveGet(tx, tdef, rec)
 }
 
 // ---------------------------------------------------------------------------
@@ -57,9 +77,11 @@ func (tx *DBReader) Get(table string, rec *Record) (bool, error) {
 // DBSetReq carries the inputs and outputs of a Set operation.
 type DBSetReq struct {
 	Record  Record
-	Mode    int // btree.ModeUpsert / ModeUpdateOnly / ModeInsertOnly
+	Mode    int  // btree.ModeUpsert / ModeUpdateOnly / ModeInsertOnly
+	WantOld bool // fill Old
 	Updated bool
 	Added   bool
+	Old     *Record // with WantOld, the row the key held before; nil if none
 }
 
 const (
@@ -69,60 +91,167 @@ const (
 
 // indexOp adds or removes a secondary index entry for rec.
 func indexOp(tx *DBTX, tdef *TableDef, rec Record, op int) {
-	key := make([]byte, 0, 256)
-	irec := make([]Value, len(tdef.Cols))
-	for i, index := range tdef.Indexes {
-		for j, c := range index {
-			irec[j] = *rec.Get(c)
+	for i := range tdef.Indexes {
+		if !tdef.indexed(i, rec) {
+			continue
 		}
-		key = encodeKey(key[:0], tdef.IndexPrefixes[i], irec[:len(index)])
-		assert(len(key) <= btree.MaxKeySize)
+		key, val := indexEntry(tdef, i, rec)
 		var done bool
 		switch op {
 		case indexAdd:
-			done = tx.kvw.Update(&btree.InsertReq{Key: key, Mode: btree.ModeUpsert})
+			done = tx.kvw.Update(&btree.InsertReq{Key: key, Val: val, Mode: btree.ModeUpsert})
 		case indexDel:
 			done = tx.kvw.Del(&btree.DeleteReq{Key: key})
 		default:
 			panic("indexOp: unknown op")
 		}
-		assert(done)
+		// An index being built may lack the entry, or have it already.
+		assert(done || tdef.building(i))
+	}
+}
+
+// indexEntry returns the key and the value of the entry of index i for rec.
+func indexEntry(tdef *TableDef, i int, rec Record) ([]byte, []byte) {
+	index := tdef.Indexes[i]
+	irec := make([]Value, len(index))
+	for j, c := range index {
+		irec[j] = *rec.Get(c)
 	}
+	key := encodeKeyCols(make([]byte, 0, 256), tdef.IndexPrefixes[i], tdef, index, irec)
+	var val []byte
+	for _, c := range tdef.included(i) {
+		val = encodeValues(val, []Value{*rec.Get(c)})
+	}
+	return key, val
+}
+
+// encodedRow is a row checked against its table, with its KV pair.
+type encodedRow struct {
+	values   []Value // in column order
+	key, val []byte
+}
+
+// encodeRow checks the complete row rec against tdef, and its KV pair and
+// those of its index entries against the size limits of db, and encodes it.
+func encodeRow(db *DB, tdef *TableDef, rec Record) (encodedRow, error) {
+	values, err := checkRecord(tdef, rec, len(tdef.Cols))
+	if err != nil {
+		return encodedRow{}, err
+	}
+
+	key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], values[:tdef.PKeys])
+	val := encodeRowValues(tdef, nil, values[tdef.PKeys:])
+
+	if err := db.kv.CheckSize(len(key), len(val)); err != nil {
+		return encodedRow{}, fmt.Errorf("row of %s: %w", tdef.Name, err)
+	}
+	full := Record{tdef.Cols, values}
+	for i := range tdef.Indexes {
+		if !tdef.indexed(i, full) {
+			continue
+		}
+		ikey, ival := indexEntry(tdef, i, full)
+		if err := db.kv.CheckSize(len(ikey), len(ival)); err != nil {
+			return encodedRow{}, fmt.Errorf("index %v of %s: %w", tdef.Indexes[i], tdef.Name, err)
+		}
+	}
+	return encodedRow{values, key, val}, nil
 }
 
 // dbUpdate writes one row to tdef, maintaining secondary indexes.
 func dbUpdate(tx *DBTX, tdef *TableDef, dbreq *DBSetReq) error {
-	values, err := checkRecord(tdef, dbreq.Record, len(tdef.Cols))
+	row, err := encodeRow(tx.db, tdef, dbreq.Record)
 	if err != nil {
 		return err
 	}
+	return dbWrite(tx, tdef, dbreq, row)
+}
 
-	key := encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
-	val := encodeValues(nil, values[tdef.PKeys:])
-
-	if len(key) > btree.MaxKeySize {
-		return fmt.Errorf("primary key too large: %d bytes (max %d)", len(key), btree.MaxKeySize)
+// dbWrite is dbUpdate for a row encodeRow has checked.
+func dbWrite(tx *DBTX, tdef *TableDef, dbreq *DBSetReq, row encodedRow) error {
+	values, key, val := row.values, row.key, row.val
+	if tdef.Expires {
+		if err := reclaimExpired(tx, tdef, values[:tdef.PKeys]); err != nil {
+			return err
+		}
+	}
+	if tdef.SoftDelete {
+		if err := reclaimDeleted(tx, tdef, values[:tdef.PKeys]); err != nil {
+			return err
+		}
+	}
+	if tdef.Document {
+		if err := checkDoc(tdef, values); err != nil {
+			return err
+		}
+	}
+	if err := checkUnique(tx, tdef, dbreq, row); err != nil {
+		return err
 	}
-	if len(val) > btree.MaxValSize {
-		return fmt.Errorf("value too large: %d bytes (max %d)", len(val), btree.MaxValSize)
+	if tdef.Quota > 0 {
+		if err := quotaCharge(tx, tdef, quotaDelta(tx, key, val, dbreq.Mode)); err != nil {
+			return err
+		}
 	}
 
 	req := btree.InsertReq{Key: key, Val: val, Mode: dbreq.Mode}
 	tx.kvw.Update(&req)
-	dbreq.Added, dbreq.Updated = req.Added, req.Updated
-	if !req.Updated || len(tdef.Indexes) == 0 {
+	dbreq.Added, dbreq.Updated, dbreq.Old = req.Added, req.Updated, nil
+
+	// The row that had the key, if any.
+	var old []Value
+	if req.Old != nil && (req.Updated || dbreq.WantOld) {
+		old = slices.Clone(values)
+		decodeRowValues(tdef, req.Old, old[tdef.PKeys:])
+	}
+	if dbreq.WantOld && old != nil {
+		dbreq.Old = &Record{tdef.Cols, old}
+	}
+	if !req.Updated {
+		return nil
+	}
+	if req.Added {
+		if err := countAdd(tx, tdef, 1); err != nil {
+			return err
+		}
+	}
+
+	op := ChangeInsert
+	if old != nil {
+		op = ChangeUpdate
+	}
+	if err := recordChange(tx, tdef, op, old, values); err != nil {
+		return err
+	}
+	if err := recordAudit(tx, tdef, op, values[:tdef.PKeys]); err != nil {
+		return err
+	}
+	if tdef.Rewrite != nil {
+		if _, err := alterSet(tx, tdef, Record{tdef.Cols, values}); err != nil {
+			return err
+		}
+	}
+	if tdef.Versioned {
+		if err := bumpVersion(tx, tdef, values[:tdef.PKeys], req.Added); err != nil {
+			return err
+		}
+	}
+	if req.Added && tdef.TTL > 0 {
+		deadline := time.Now().Add(tdef.TTL).UnixNano()
+		if err := setRowDeadline(tx, tdef, values[:tdef.PKeys], deadline); err != nil {
+			return err
+		}
+	}
+	if len(tdef.Indexes) == 0 {
 		return nil
 	}
 
 	// Update secondary indexes.
-	if req.Updated && !req.Added {
+	if old != nil {
 		// The row already existed: remove the old index entries first.
-		decodeValues(req.Old, values[tdef.PKeys:])
-		indexOp(tx, tdef, Record{tdef.Cols, values}, indexDel)
-	}
-	if req.Updated {
-		indexOp(tx, tdef, dbreq.Record, indexAdd)
+		indexOp(tx, tdef, Record{tdef.Cols, old}, indexDel)
 	}
+	indexOp(tx, tdef, dbreq.Record, indexAdd)
 	return nil
 }
 
@@ -133,24 +262,67 @@ func dbDelete(tx *DBTX, tdef *TableDef, rec Record) (bool, error) {
 		return false, err
 	}
 
-	key := encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
-	if len(key) > btree.MaxKeySize {
-		return false, fmt.Errorf("primary key too large: %d bytes (max %d)", len(key), btree.MaxKeySize)
+	key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], values[:tdef.PKeys])
+	if err := tx.db.kv.CheckSize(len(key), 0); err != nil {
+		return false, fmt.Errorf("row of %s: %w", tdef.Name, err)
 	}
 
 	req := btree.DeleteReq{Key: key}
 	deleted := tx.kvw.Del(&req)
-	if !deleted || len(tdef.Indexes) == 0 {
-		return deleted, nil
+	archived, err := archiveHide(tx, tdef, key, deleted)
+	if err != nil {
+		return false, err
+	}
+	if !deleted {
+		return archived, nil
+	}
+	err = quotaCharge(tx, tdef, -int64(len(key)+len(req.Old)))
+	assert(err == nil)
+	if err := countAdd(tx, tdef, -1); err != nil {
+		return false, err
 	}
 
 	// Recover the non-key column types so decodeValues knows how to decode.
 	for i := tdef.PKeys; i < len(tdef.Types); i++ {
 		values[i].Type = tdef.Types[i]
 	}
-	decodeValues(req.Old, values[tdef.PKeys:])
-	indexOp(tx, tdef, Record{tdef.Cols, values}, indexDel)
-	return true, nil
+	decodeRowValues(tdef, req.Old, values[tdef.PKeys:])
+	if err := recordChange(tx, tdef, ChangeDelete, values, nil); err != nil {
+		return false, err
+	}
+	if err := recordAudit(tx, tdef, ChangeDelete, values[:tdef.PKeys]); err != nil {
+		return false, err
+	}
+	if tdef.Rewrite != nil {
+		if err := alterDel(tx, tdef, values[:tdef.PKeys]); err != nil {
+			return false, err
+		}
+	}
+	if len(tdef.Indexes) > 0 {
+		indexOp(tx, tdef, Record{tdef.Cols, values}, indexDel)
+	}
+	if tdef.Versioned {
+		if err := dropVersion(tx, tdef, values[:tdef.PKeys]); err != nil {
+			return false, err
+		}
+	}
+	if tdef.SoftDelete && rowDeleted(&tx.DBReader, tdef, values[:tdef.PKeys]) != 0 {
+		if err := setRowDeleted(tx, tdef, values[:tdef.PKeys], 0); err != nil {
+			return false, err
+		}
+	}
+	if !tdef.Expires {
+		return true, nil
+	}
+	// An expired row was absent already; its deletion only reclaims it.
+	deadline := rowDeadline(&tx.DBReader, tdef, values[:tdef.PKeys])
+	if deadline == 0 {
+		return true, nil
+	}
+	if err := setRowDeadline(tx, tdef, values[:tdef.PKeys], 0); err != nil {
+		return false, err
+	}
+	return !expired(deadline, time.Now().UnixNano()), nil
 }
 
 // ---------------------------------------------------------------------------
@@ -159,6 +331,7 @@ func dbDelete(tx *DBTX, tdef *TableDef, rec Record) (bool, error) {
 
 // Set writes a row to table, using the mode specified in req.
 func (tx *DBTX) Set(table string, req *DBSetReq) error {
+	tx.db.stats.sets.Add(1)
 	tdef := getTableDef(&tx.DBReader, table)
 	if tdef == nil {
 		return fmt.Errorf("table not found: %s", table)
@@ -190,13 +363,27 @@ func (tx *DBTX) Upsert(table string, rec Record) (bool, error) {
 	return req.Added, err
 }
 
+// UpsertOld is Upsert that also returns the row it replaced, or nil if it
+// created a new one, so that an audit trail or a diff needs no Get first.
+// A replaced row is returned even if rec left it unchanged.
+func (tx *DBTX) UpsertOld(table string, rec Record) (*Record, error) {
+	req := DBSetReq{Record: rec, Mode: btree.ModeUpsert, WantOld: true}
+	err := tx.Set(table, &req)
+	return req.Old, err
+}
+
 // Delete removes a row by its primary key. Returns (true, nil) if the row was
-// found and deleted.
+// found and deleted. In a table with SoftDelete set, the row is only marked
+// deleted; see DB.Purge.
 func (tx *DBTX) Delete(table string, rec Record) (bool, error) {
+	tx.db.stats.deletes.Add(1)
 	tdef := getTableDef(&tx.DBReader, table)
 	if tdef == nil {
 		return false, fmt.Errorf("table not found: %s", table)
 	}
+	if tdef.SoftDelete {
+		return softDelete(tx, tdef, rec)
+	}
 	return dbDelete(tx, tdef, rec)
 }
 
@@ -219,32 +406,95 @@ func (tx *DBTX) TableNew(tdef *TableDef) error {
 		return fmt.Errorf("table exists: %s", tdef.Name)
 	}
 
-	// Assign a fresh prefix for the primary key tree.
+	// Assign a prefix for the primary key tree and one for each secondary
+	// index.
 	assert(tdef.Prefix == 0)
-	tdef.Prefix = tablePrefixMin
+	prefixes, err := allocPrefixes(tx, 1+len(tdef.Indexes))
+	if err != nil {
+		return err
+	}
+	tdef.Prefix, tdef.IndexPrefixes = prefixes[0], prefixes[1:]
+	if err := putTableDef(tx, tdef); err != nil {
+		return err
+	}
+	return countPut(tx, tdef, 0)
+}
+
+// ErrPrefixesExhausted is returned (wrapped) by TableNew, IndexAdd and the
+// column changes when no B-tree key prefix is left to give the new table,
+// index or layout.
+var ErrPrefixesExhausted = errors.New("key prefixes exhausted")
+
+// Key prefixes are handed out from the next_prefix counter in @meta. A
+// prefix whose keys have all been deleted, such as that of the old layout
+// of a table after a column change, is released: it is kept in @meta under
+// "free_prefix:" and its big-endian value, and reused, lowest first, before
+// the counter advances. The counter stops below math.MaxUint32, since a
+// scan of a prefix ends at the next one.
+
+func freePrefixKey(prefix uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte("free_prefix:"), prefix)
+}
+
+// allocPrefixes reserves n B-tree key prefixes, released ones first.
+func allocPrefixes(tx *DBTX, n int) ([]uint32, error) {
+	// Take the lowest released prefixes.
+	free := []byte("free_prefix:")
+	sc := Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddStr("key", free)}
+	if err := dbScan(&tx.DBReader, tdefMeta, &sc); err != nil {
+		return nil, err
+	}
+	var prefixes []uint32
+	for ; sc.Valid() && len(prefixes) < n; sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		key := rec.Get("key").Str
+		if !bytes.HasPrefix(key, free) {
+			break
+		}
+		prefixes = append(prefixes, binary.BigEndian.Uint32(key[len(free):]))
+	}
+	for _, prefix := range prefixes {
+		if _, err := dbDelete(tx, tdefMeta, *(&Record{}).AddStr("key", freePrefixKey(prefix))); err != nil {
+			return nil, err
+		}
+	}
+	need := n - len(prefixes)
+	if need == 0 {
+		return prefixes, nil
+	}
+
+	prefix := tablePrefixMin
 	meta := (&Record{}).AddStr("key", []byte("next_prefix"))
-	ok, err = dbGet(&tx.DBReader, tdefMeta, meta)
+	ok, err := dbGet(&tx.DBReader, tdefMeta, meta)
 	assert(err == nil)
 	if ok {
-		tdef.Prefix = binary.LittleEndian.Uint32(meta.Get("val").Str)
-		assert(tdef.Prefix > tablePrefixMin)
+		prefix = binary.LittleEndian.Uint32(meta.Get("val").Str)
+		assert(prefix > tablePrefixMin)
 	} else {
 		meta.AddStr("val", make([]byte, 4))
 	}
-
-	// Assign a prefix for each secondary index.
-	for i := range tdef.Indexes {
-		tdef.IndexPrefixes = append(tdef.IndexPrefixes, tdef.Prefix+1+uint32(i))
+	if uint64(prefix)+uint64(need) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d needed, %d left", ErrPrefixesExhausted, need, math.MaxUint32-prefix)
+	}
+	for i := range need {
+		prefixes = append(prefixes, prefix+uint32(i))
 	}
 
 	// Advance the next-prefix counter.
-	ntree := 1 + uint32(len(tdef.Indexes))
-	binary.LittleEndian.PutUint32(meta.Get("val").Str, tdef.Prefix+ntree)
-	if err := dbUpdate(tx, tdefMeta, &DBSetReq{Record: *meta}); err != nil {
-		return err
-	}
+	binary.LittleEndian.PutUint32(meta.Get("val").Str, prefix+uint32(need))
+	return prefixes, dbUpdate(tx, tdefMeta, &DBSetReq{Record: *meta})
+}
+
+// releasePrefix makes prefix, whose keys have all been deleted, available
+// to allocPrefixes again.
+func releasePrefix(tx *DBTX, prefix uint32) error {
+	rec := (&Record{}).AddStr("key", freePrefixKey(prefix)).AddStr("val", nil)
+	return dbUpdate(tx, tdefMeta, &DBSetReq{Record: *rec})
+}
 
-	// Persist the definition.
+// putTableDef persists tdef, new or changed, in @table.
+func putTableDef(tx *DBTX, tdef *TableDef) error {
 	if tdef.Indexes == nil {
 		tdef.Indexes = [][]string{}
 	}
@@ -253,6 +503,10 @@ func (tx *DBTX) TableNew(tdef *TableDef) error {
 	}
 	val, err := json.Marshal(tdef)
 	assert(err == nil)
-	table.AddStr("def", val)
-	return dbUpdate(tx, tdefTable, &DBSetReq{Record: *table})
+	table := (&Record{}).AddStr("name", []byte(tdef.Name)).AddStr("def", val)
+	if err := dbUpdate(tx, tdefTable, &DBSetReq{Record: *table}); err != nil {
+		return err
+	}
+	tx.schema = append(tx.schema, tdef.Name)
+	return putIndexDefs(tx, tdef)
 }
@@ -0,0 +1,139 @@
+package tables
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+)
+
+// ---------------------------------------------------------------------------
+// Partial indexes
+// ---------------------------------------------------------------------------
+
+// A Cond compares a column with a constant, as in Col Cmp Val, where Cmp is
+// one of "==", "!=", "<", "<=", ">", ">=". Bytes values compare by the
+// collation of the column. Conds make up the predicates of partial indexes
+// (TableDef.Where) and the filters that can use them (Scanner.Where).
+type Cond struct {
+	Col string
+	Cmp string
+	Val Value
+}
+
+// compareValues compares a and b, values of col, by the collation of col
+// for bytes values.
+func compareValues(tdef *TableDef, col string, a, b Value) int {
+	switch a.Type {
+	case TypeInt64:
+		return cmp.Compare(a.I64, b.I64)
+	case TypeFloat64:
+		return cmp.Compare(a.F64, b.F64)
+	case TypeUUID:
+		return bytes.Compare(a.Str, b.Str)
+	case TypeBytes:
+		x, y := a.Str, b.Str
+		if coll := tdef.collation(col); coll != nil {
+			x, y = coll(nil, x), coll(nil, y)
+		}
+		return bytes.Compare(x, y)
+	}
+	return 0
+}
+
+// Match reports whether v, a value of c.Col, satisfies c.
+func (c Cond) Match(tdef *TableDef, v Value) bool {
+	n := compareValues(tdef, c.Col, v, c.Val)
+	switch c.Cmp {
+	case "==":
+		return n == 0
+	case "!=":
+		return n != 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	}
+	panic("Cond.Match: bad operator " + c.Cmp)
+}
+
+// equal reports whether c and d are the same condition.
+func (c Cond) equal(d Cond) bool {
+	return c.Col == d.Col && c.Cmp == d.Cmp && c.Val.Type == d.Val.Type &&
+		c.Val.I64 == d.Val.I64 && c.Val.F64 == d.Val.F64 && bytes.Equal(c.Val.Str, d.Val.Str)
+}
+
+// matchAll reports whether rec satisfies every condition of conds.
+func matchAll(tdef *TableDef, conds []Cond, rec Record) bool {
+	for _, c := range conds {
+		if !c.Match(tdef, *rec.Get(c.Col)) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConds verifies that every condition of conds compares a column of
+// tdef with a value of its type.
+func checkConds(tdef *TableDef, conds []Cond) error {
+	for _, c := range conds {
+		idx := ColIndex(tdef, c.Col)
+		if idx < 0 {
+			return fmt.Errorf("unknown condition column: %s", c.Col)
+		}
+		if c.Val.Type != tdef.Types[idx] {
+			return fmt.Errorf("condition type mismatch: %s", c.Col)
+		}
+		switch c.Cmp {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("bad condition operator: %q", c.Cmp)
+		}
+		if c.Val.Type == TypeFloat64 && math.IsNaN(c.Val.F64) {
+			return fmt.Errorf("condition on %s compares with NaN", c.Col)
+		}
+	}
+	return nil
+}
+
+// condCols returns the columns conds refer to.
+func condCols(conds []Cond) []string {
+	cols := make([]string, len(conds))
+	for i, c := range conds {
+		cols[i] = c.Col
+	}
+	return cols
+}
+
+// indexed reports whether index i has an entry for rec: it is a complete
+// index, or rec satisfies its predicate.
+func (tdef *TableDef) indexed(i int, rec Record) bool {
+	return i >= len(tdef.Where) || matchAll(tdef, tdef.Where[i], rec)
+}
+
+// usable reports whether a scan that filters by where may use index i: it
+// is a complete index, or where contains every condition of its predicate.
+func (tdef *TableDef) usable(i int, where []Cond) bool {
+	if i >= len(tdef.Where) {
+		return true
+	}
+	for _, c := range tdef.Where[i] {
+		found := false
+		for _, w := range where {
+			found = found || c.equal(w)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// partial reports whether index i has a predicate.
+func (tdef *TableDef) partial(i int) bool {
+	return i < len(tdef.Where) && len(tdef.Where[i]) > 0
+}
@@ -0,0 +1,114 @@
+package tables
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Per-table space quotas
+// ---------------------------------------------------------------------------
+
+// ErrQuotaExceeded is returned (wrapped) by writes that would grow a table
+// past its TableDef.Quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaWarnPercent is the usage level, as a percentage of the quota, at which
+// DB.OnQuota is notified.
+const QuotaWarnPercent = 90
+
+// QuotaEvent reports that a committed transaction pushed a table's usage to
+// or past QuotaWarnPercent of its quota.
+type QuotaEvent struct {
+	Table string
+	Used  int64 // bytes after the commit
+	Quota int64
+}
+
+// Usage returns the number of bytes (encoded primary key plus row value)
+// accounted to table. Only tables with a non-zero Quota are accounted; the
+// usage of any other table is reported as 0.
+func (tx *DBReader) Usage(table string) (int64, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return 0, fmt.Errorf("table not found: %s", table)
+	}
+	return quotaUsage(tx, tdef), nil
+}
+
+func quotaKey(tdef *TableDef) *Record {
+	return (&Record{}).AddStr("key", []byte("usage:"+tdef.Name))
+}
+
+func quotaUsage(tx *DBReader, tdef *TableDef) int64 {
+	rec := quotaKey(tdef)
+	ok, err := dbGet(tx, tdefMeta, rec)
+	assert(err == nil)
+	if !ok {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(rec.Get("val").Str))
+}
+
+// quotaCharge adds delta bytes to tdef's usage. A positive delta that would
+// exceed the quota is rejected before anything is written; shrinking is
+// always allowed so that an over-quota table can be cleaned up.
+func quotaCharge(tx *DBTX, tdef *TableDef, delta int64) error {
+	if tdef.Quota <= 0 || delta == 0 {
+		return nil
+	}
+	used := quotaUsage(&tx.DBReader, tdef)
+	next := max(used+delta, 0)
+	if delta > 0 && next > tdef.Quota {
+		return fmt.Errorf("%w: %s (%d + %d > %d bytes)", ErrQuotaExceeded, tdef.Name, used, delta, tdef.Quota)
+	}
+
+	val := make([]byte, 8)
+	binary.LittleEndian.PutUint64(val, uint64(next))
+	rec := quotaKey(tdef).AddStr("val", val)
+	if err := dbUpdate(tx, tdefMeta, &DBSetReq{Record: *rec}); err != nil {
+		return err
+	}
+
+	warn := tdef.Quota * QuotaWarnPercent / 100
+	if delta > 0 && next >= warn {
+		tx.quotaEvents = append(tx.quotaEvents, QuotaEvent{tdef.Name, next, tdef.Quota})
+	}
+	return nil
+}
+
+// quotaDelta returns how many bytes writing key/val with mode would add to
+// the table, looking up the existing row if there is one.
+func quotaDelta(tx *DBTX, key, val []byte, mode int) int64 {
+	old, exists := tx.kvr.Get(key)
+	switch {
+	case exists && mode == btree.ModeInsertOnly:
+		return 0
+	case !exists && mode == btree.ModeUpdateOnly:
+		return 0
+	case exists:
+		return int64(len(val) - len(old))
+	default:
+		return int64(len(key) + len(val))
+	}
+}
+
+// fireQuotaEvents delivers the events collected by a committed transaction,
+// keeping only the last one per table.
+func (db *DB) fireQuotaEvents(events []QuotaEvent) {
+	if db.OnQuota == nil {
+		return
+	}
+	last := map[string]int{}
+	for i, ev := range events {
+		last[ev.Table] = i
+	}
+	for i, ev := range events {
+		if last[ev.Table] == i {
+			db.OnQuota(ev)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package tables
 
 import (
+	"encoding/base64"
 	"fmt"
 	"reflect"
+	"slices"
+	"time"
 
 	"github.com/MHS-20/ElkDB/btree"
 )
@@ -24,17 +27,59 @@ type Scanner struct {
 	Key1 Record
 	Key2 Record // required when Cmp2 != 0
 
+	// Filter, if set, is applied during iteration: rows in the range for
+	// which it returns false are skipped, so Valid / Deref only ever see
+	// matching rows. It receives the row as Deref returns it and must not
+	// retain it.
+	Filter func(rec Record) bool
+
+	// Where, if set, skips the rows that do not satisfy all of its
+	// conditions, before Filter. It also lets the scan use a partial index
+	// whose predicate (TableDef.Where) it contains; no other scan does.
+	Where []Cond
+
+	// Cols, if set, are the only columns Deref returns, in this order. An
+	// index scan whose entries hold them all (the index columns plus
+	// TableDef.Include) then never reads the primary rows.
+	Cols []string
+
+	// Offset rows of the range are skipped by Scan before the first one it
+	// returns, and Limit, if positive, bounds the rows returned: Valid
+	// reports false after them. A skipped row is neither decoded, unless
+	// Where, Filter or expiry must see it, nor fetched from the table in an
+	// index scan.
+	Offset int
+	Limit  int
+
+	// Deleted makes a scan of a table with SoftDelete set return the rows
+	// that were deleted but not yet purged, too.
+	Deleted bool
+
 	// Fields filled by dbScan; not touched by the caller.
-	tx      *DBReader
-	tdef    *TableDef
-	indexNo int          // -1: primary key; >= 0: secondary index
-	iter    *btree.BIter // underlying B-tree iterator
-	keyEnd  []byte       // encoded Key2 (the stopping sentinel)
+	tx       *DBReader
+	tdef     *TableDef
+	indexNo  int          // -1: primary key; >= 0: secondary index
+	iter     *btree.BIter // underlying B-tree iterator
+	keyStart []byte       // encoded Key1
+	keyEnd   []byte       // encoded Key2 (the stopping sentinel)
+	cur      Record       // row decoded for Filter; reused by Deref
+	hasCur   bool
+	returned int   // rows Next moved past, for Limit
+	now      int64 // time rows expire at, for a table with Expires; else 0
+	tomb     bool  // skip soft-deleted rows
+	raw      bool  // set by dbGet: expired and soft-deleted rows are not skipped
+	covered  bool  // the index entries hold every column of Cols
+	exact    bool  // set by dbGet and Backfill: collated values match exactly
 }
 
 // Valid reports whether the scanner is positioned on a row that lies within
 // the requested range.
 func (sc *Scanner) Valid() bool {
+	return (sc.Limit <= 0 || sc.returned < sc.Limit) && sc.inRange()
+}
+
+// inRange is Valid without Limit.
+func (sc *Scanner) inRange() bool {
 	if !sc.iter.Valid() {
 		return false
 	}
@@ -46,6 +91,16 @@ func (sc *Scanner) Valid() bool {
 // Must only be called when Valid() returns true.
 func (sc *Scanner) Next() {
 	assert(sc.Valid())
+	sc.returned++
+	if sc.Limit > 0 && sc.returned >= sc.Limit {
+		return // no need to look for another row
+	}
+	sc.step()
+	sc.skip()
+}
+
+func (sc *Scanner) step() {
+	sc.hasCur = false
 	if sc.Cmp1 > 0 {
 		sc.iter.Next()
 	} else {
@@ -53,11 +108,105 @@ func (sc *Scanner) Next() {
 	}
 }
 
+// skip advances past rows rejected by Where or Filter, and past expired
+// and soft-deleted rows.
+func (sc *Scanner) skip() {
+	if sc.Filter == nil && sc.Where == nil && sc.now == 0 && !sc.tomb {
+		return
+	}
+	for sc.inRange() {
+		sc.deref(&sc.cur)
+		live := sc.now == 0 || !expired(rowDeadline(sc.tx, sc.tdef, primaryKey(sc.tdef, sc.cur)), sc.now)
+		live = live && (!sc.tomb || rowDeleted(sc.tx, sc.tdef, primaryKey(sc.tdef, sc.cur)) == 0)
+		live = live && matchAll(sc.tdef, sc.Where, sc.cur)
+		sc.project(&sc.cur)
+		if live && (sc.Filter == nil || sc.Filter(sc.cur)) {
+			sc.hasCur = true
+			return
+		}
+		sc.step()
+	}
+}
+
 // Deref fills rec with the row at the current scanner position.
 // Must only be called when Valid() returns true.
 func (sc *Scanner) Deref(rec *Record) {
 	assert(sc.Valid())
+	if sc.hasCur {
+		rec.Cols = sc.cur.Cols
+		rec.Vals = append(rec.Vals[:0], sc.cur.Vals...)
+		return
+	}
+	sc.deref(rec)
+	sc.project(rec)
+}
 
+// Page returns up to limit rows of the scan and a token to resume it with.
+// With an empty token the page starts at the current position; otherwise it
+// starts right after the row the token designates, which must come from a
+// page of a scan of the same range. The token encodes the key of the last
+// row returned: the primary key, or the index entry of an index scan, which
+// ends with the primary key. So a page resumes where the last one stopped
+// even across other transactions' writes, with no row returned twice or
+// skipped, except a row whose key changed in between. The token is empty
+// after the last page. It is opaque and safe in a URL.
+//
+// Page must be called on a scanner initialised by Scan, and its rows are
+// valid as long as the rows Deref returns.
+func (sc *Scanner) Page(limit int, token string) ([]Record, string, error) {
+	if limit < 1 {
+		return nil, "", fmt.Errorf("bad page limit: %d", limit)
+	}
+	if token != "" {
+		key, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || !btree.CmpOK(key, sc.Cmp1, sc.keyStart) || !btree.CmpOK(key, sc.Cmp2, sc.keyEnd) {
+			return nil, "", fmt.Errorf("bad page token")
+		}
+		cmp := btree.CmpGT
+		if sc.Cmp1 < 0 {
+			cmp = btree.CmpLT
+		}
+		sc.iter = sc.tx.kvr.Seek(key, cmp)
+		sc.hasCur = false
+		sc.skip()
+	}
+	var rows []Record
+	var last []byte
+	for ; sc.Valid() && len(rows) < limit; sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		rows = append(rows, rec)
+		last, _ = sc.iter.Deref()
+	}
+	if !sc.Valid() {
+		return rows, "", nil
+	}
+	return rows, base64.RawURLEncoding.EncodeToString(last), nil
+}
+
+// project narrows rec to Cols, if set.
+func (sc *Scanner) project(rec *Record) {
+	if sc.Cols == nil {
+		return
+	}
+	vals := make([]Value, len(sc.Cols))
+	for i, c := range sc.Cols {
+		vals[i] = *rec.Get(c)
+	}
+	rec.Cols, rec.Vals = sc.Cols, vals
+}
+
+// primaryKey returns the primary-key values of rec, which holds at least
+// the primary-key columns in any order.
+func primaryKey(tdef *TableDef, rec Record) []Value {
+	pk := make([]Value, tdef.PKeys)
+	for i, c := range tdef.Cols[:tdef.PKeys] {
+		pk[i] = *rec.Get(c)
+	}
+	return pk
+}
+
+func (sc *Scanner) deref(rec *Record) {
 	tdef := sc.tdef
 	rec.Cols = tdef.Cols
 	rec.Vals = rec.Vals[:0]
@@ -68,19 +217,28 @@ func (sc *Scanner) Deref(rec *Record) {
 		for _, typ := range tdef.Types {
 			rec.Vals = append(rec.Vals, Value{Type: typ})
 		}
-		decodeValues(key[4:], rec.Vals[:tdef.PKeys])
-		decodeValues(val, rec.Vals[tdef.PKeys:])
+		decodeKey(tdef, tdef.Cols[:tdef.PKeys], key[4:], rec.Vals[:tdef.PKeys])
+		decodeRowValues(tdef, val, rec.Vals[tdef.PKeys:])
 	} else {
 		// Secondary-index scan: decode the index key to get the primary key,
-		// then fetch the full row from the primary tree.
-		assert(len(val) == 0)
-
+		// then fetch the full row from the primary tree, unless the entry
+		// holds every column in Cols.
 		index := tdef.Indexes[sc.indexNo]
 		ival := make([]Value, len(index))
 		for i, c := range index {
 			ival[i].Type = tdef.Types[ColIndex(tdef, c)]
 		}
-		decodeValues(key[4:], ival)
+		decodeKey(tdef, index, key[4:], ival)
+		if sc.covered {
+			include := tdef.included(sc.indexNo)
+			for _, c := range include {
+				ival = append(ival, Value{Type: tdef.Types[ColIndex(tdef, c)]})
+			}
+			decodeValues(val, ival[len(index):])
+			rec.Cols = append(slices.Clip(index), include...)
+			rec.Vals = ival
+			return
+		}
 		icol := Record{index, ival}
 
 		// Reconstruct the primary key from the decoded index entry.
@@ -120,8 +278,9 @@ func isPrefix(long []string, short []string) bool {
 }
 
 // findIndex selects the best index (or primary key) for the given key columns.
-// Returns -1 for the primary key, >= 0 for a secondary index.
-func findIndex(tdef *TableDef, keys []string) (int, error) {
+// Returns -1 for the primary key, >= 0 for a secondary index. A partial
+// index usable with where beats a complete one, since it is smaller.
+func findIndex(tdef *TableDef, keys []string, where []Cond) (int, error) {
 	pk := tdef.Cols[:tdef.PKeys]
 	if isPrefix(pk, keys) {
 		// Primary key (also covers full-table scans with no key columns).
@@ -130,10 +289,11 @@ func findIndex(tdef *TableDef, keys []string) (int, error) {
 
 	winner := -2
 	for i, index := range tdef.Indexes {
-		if !isPrefix(index, keys) {
+		if !isPrefix(index, keys) || !tdef.usable(i, where) || tdef.building(i) {
 			continue
 		}
-		if winner == -2 || len(index) < len(tdef.Indexes[winner]) {
+		if winner == -2 || tdef.partial(i) && !tdef.partial(winner) ||
+			tdef.partial(i) == tdef.partial(winner) && len(index) < len(tdef.Indexes[winner]) {
 			winner = i
 		}
 	}
@@ -152,22 +312,49 @@ func findIndex(tdef *TableDef, keys []string) (int, error) {
 // depending on cmp so that prefix range queries work correctly:
 //
 //   - CmpLT and CmpGE → nothing appended (empty byte string is the minimum)
-//   - CmpGT and CmpLE → 0xff… bytes appended (the maximum sentinel)
+//   - CmpGT and CmpLE → 0xff… bytes appended (the maximum sentinel; two of
+//     them for a descending string, see encodeDesc)
+//
+// Unless exact is set, a value of a collated column stands for every value
+// that collates equal to it: only its sort key is encoded, followed by the
+// sentinel, and it must be the last value given.
 func encodeKeyPartial(
 	out []byte, prefix uint32, values []Value,
-	tdef *TableDef, keys []string, cmp int,
+	tdef *TableDef, keys []string, cmp int, exact bool,
 ) []byte {
-	out = encodeKey(out, prefix, values)
-
 	max := cmp == btree.CmpGT || cmp == btree.CmpLE
+	if !exact {
+		for i, v := range values {
+			if coll := tdef.collation(keys[i]); coll != nil {
+				out = encodeKeyCols(out, prefix, tdef, keys[:i], values[:i])
+				out = encodeCol(out, tdef, keys[i], Value{Type: TypeBytes, Str: coll(nil, v.Str)})
+				if max {
+					out = append(out, 0xff)
+					if tdef.Desc[keys[i]] {
+						out = append(out, 0xff)
+					}
+				}
+				return out
+			}
+		}
+	}
+	out = encodeKeyCols(out, prefix, tdef, keys[:len(values)], values)
+
 loop:
 	for i := len(values); max && i < len(keys); i++ {
 		switch tdef.Types[ColIndex(tdef, keys[i])] {
 		case TypeBytes:
 			out = append(out, 0xff)
+			if tdef.Desc[keys[i]] {
+				out = append(out, 0xff)
+			}
 			break loop // 0xff terminates any string encoding
-		case TypeInt64:
+		case TypeInt64, TypeFloat64:
 			out = append(out, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		case TypeUUID:
+			for range len(UUID{}) {
+				out = append(out, 0xff)
+			}
 		default:
 			panic("encodeKeyPartial: unknown type")
 		}
@@ -182,43 +369,96 @@ loop:
 // dbScan initialises sc for the given table and positions the iterator.
 // After dbScan returns, callers use sc.Valid / sc.Next / sc.Deref.
 func dbScan(tx *DBReader, tdef *TableDef, req *Scanner) error {
+	keyStart, err := scanInit(tx, tdef, req)
+	if err != nil {
+		return err
+	}
+	req.keyStart = keyStart
+	req.iter = tx.kvr.Seek(keyStart, req.Cmp1)
+	req.hasCur = false
+	req.returned = 0
+	req.skip()
+	for i := 0; i < req.Offset && req.inRange(); i++ {
+		req.step()
+		req.skip()
+	}
+	return nil
+}
+
+// scanInit validates req, chooses the index and sets the fields of req that
+// do not depend on the iterator. It returns the encoded Key1 to seek to.
+func scanInit(tx *DBReader, tdef *TableDef, req *Scanner) ([]byte, error) {
 	// Validate the cmp combination.
 	switch {
 	case req.Cmp1 > 0 && req.Cmp2 < 0: // forward range:  Cmp1=GE/GT, Cmp2=LE/LT
 	case req.Cmp2 > 0 && req.Cmp1 < 0: // backward range: Cmp1=LE/LT, Cmp2=GE/GT
 	case req.Cmp1 != 0 && req.Cmp2 == 0 && len(req.Key2.Cols) == 0: // prefix scan
 	default:
-		return fmt.Errorf("bad range: invalid Cmp1/Cmp2 combination")
+		return nil, fmt.Errorf("bad range: invalid Cmp1/Cmp2 combination")
 	}
 	if req.Cmp2 != 0 && !reflect.DeepEqual(req.Key1.Cols, req.Key2.Cols) {
-		return fmt.Errorf("bad range key: Key1 and Key2 must have the same columns")
+		return nil, fmt.Errorf("bad range key: Key1 and Key2 must have the same columns")
+	}
+	if err := checkCollations(tdef); err != nil {
+		return nil, err
+	}
+	if err := checkCodec(tdef); err != nil {
+		return nil, err
 	}
 	if err := checkRecordTypes(tdef, req.Key1); err != nil {
-		return err
+		return nil, err
 	}
 	if req.Cmp2 != 0 {
 		if err := checkRecordTypes(tdef, req.Key2); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	// Choose the index.
-	indexNo, err := findIndex(tdef, req.Key1.Cols)
+	if err := checkConds(tdef, req.Where); err != nil {
+		return nil, err
+	}
+	indexNo, err := findIndex(tdef, req.Key1.Cols, req.Where)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	index, prefix := tdef.Cols[:tdef.PKeys], tdef.Prefix
 	if indexNo >= 0 {
 		index, prefix = tdef.Indexes[indexNo], tdef.IndexPrefixes[indexNo]
 	}
+	if !req.exact {
+		for _, c := range req.Key1.Cols[:max(len(req.Key1.Cols)-1, 0)] {
+			if tdef.collation(c) != nil {
+				return nil, fmt.Errorf("bad range key: collated column %s must come last", c)
+			}
+		}
+	}
+
+	req.covered = false
+	for _, c := range req.Cols {
+		if ColIndex(tdef, c) < 0 {
+			return nil, fmt.Errorf("unknown column: %s", c)
+		}
+	}
+	if req.Cols != nil && indexNo >= 0 {
+		req.covered = true
+		for _, c := range append(condCols(req.Where), req.Cols...) {
+			if !slices.Contains(index, c) && !slices.Contains(tdef.included(indexNo), c) {
+				req.covered = false
+			}
+		}
+	}
 
 	req.tx = tx
 	req.tdef = tdef
 	req.indexNo = indexNo
+	req.now = 0
+	if tdef.Expires && !req.raw {
+		req.now = time.Now().UnixNano()
+	}
+	req.tomb = tdef.SoftDelete && !req.Deleted && !req.raw
 
-	// Seek to Key1.
-	keyStart := encodeKeyPartial(nil, prefix, req.Key1.Vals, tdef, index, req.Cmp1)
-	req.iter = tx.kvr.Seek(keyStart, req.Cmp1)
+	keyStart := encodeKeyPartial(nil, prefix, req.Key1.Vals, tdef, index, req.Cmp1, req.exact)
 
 	// Compute the stopping key (Key2 / prefix sentinel).
 	if req.Cmp2 == 0 {
@@ -234,9 +474,9 @@ func dbScan(tx *DBReader, tdef *TableDef, req *Scanner) error {
 			panic("unreachable")
 		}
 	} else {
-		req.keyEnd = encodeKeyPartial(nil, prefix, req.Key2.Vals, tdef, index, req.Cmp2)
+		req.keyEnd = encodeKeyPartial(nil, prefix, req.Key2.Vals, tdef, index, req.Cmp2, req.exact)
 	}
-	return nil
+	return keyStart, nil
 }
 
 // ---------------------------------------------------------------------------
@@ -247,9 +487,53 @@ func dbScan(tx *DBReader, tdef *TableDef, req *Scanner) error {
 // iterator at the first matching row.  After Scan returns, use
 // req.Valid / req.Next / req.Deref to iterate.
 func (tx *DBReader) Scan(table string, req *Scanner) error {
+	tx.db.stats.scans.Add(1)
 	tdef := getTableDef(tx, table)
 	if tdef == nil {
 		return fmt.Errorf("table not found: %s", table)
 	}
 	return dbScan(tx, tdef, req)
 }
+
+// First fills rec with the first row of table in primary-key order. It
+// reads one path down the B-tree rather than scanning, so it suits
+// watermark queries such as the oldest entry of a log table, and the start
+// of pagination. Expired rows are skipped. It reports false if the table is
+// empty. To go on from there, Scan with Cmp1 set to btree.CmpGE and no Key1.
+func (tx *DBReader) First(table string, rec *Record) (bool, error) {
+	return tx.edge(table, btree.CmpGE, rec)
+}
+
+// Last is like First for the last row. To go on backwards, Scan
+// with Cmp1 set to btree.CmpLE and no Key1.
+func (tx *DBReader) Last(table string, rec *Record) (bool, error) {
+	return tx.edge(table, btree.CmpLE, rec)
+}
+
+func (tx *DBReader) edge(table string, cmp int, rec *Record) (bool, error) {
+	sc := Scanner{Cmp1: cmp}
+	if err := tx.Scan(table, &sc); err != nil {
+		return false, err
+	}
+	if !sc.Valid() {
+		return false, nil
+	}
+	sc.Deref(rec)
+	return true, nil
+}
+
+// First runs DBReader.First on the last commit.
+func (db *DB) First(table string, rec *Record) (bool, error) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return tx.First(table, rec)
+}
+
+// Last runs DBReader.Last on the last commit.
+func (db *DB) Last(table string, rec *Record) (bool, error) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return tx.Last(table, rec)
+}
@@ -0,0 +1,93 @@
+package tables
+
+import (
+	"fmt"
+	"math"
+)
+
+// ---------------------------------------------------------------------------
+// Named sequences
+// ---------------------------------------------------------------------------
+
+// A sequence hands out increasing int64 values, from 1, for ids that are not
+// a table's own. DB.SequenceNext serves them from a range reserved in
+// memory, so only one call in SequenceBatch writes: it reserves the next
+// range by advancing the row of the sequence in the @seq internal table,
+// in a transaction of its own. Values are unique and increase across
+// restarts, but the rest of a range reserved before the DB is closed is
+// skipped, so a sequence has gaps.
+
+// DefaultSequenceBatch is the number of values DB.SequenceNext reserves at a
+// time when DB.SequenceBatch is 0.
+const DefaultSequenceBatch = 100
+
+// tdefSeq stores one row per sequence: the first value not yet reserved.
+var tdefSeq = &TableDef{
+	Prefix: 14,
+	Name:   "@seq",
+	Types:  []uint32{TypeBytes, TypeInt64},
+	Cols:   []string{"name", "next"},
+	PKeys:  1,
+}
+
+// seqRange is the part of a sequence reserved by this DB: the values from
+// next up to end.
+type seqRange struct {
+	next, end int64
+}
+
+// SequenceNext returns the next value of the sequence name, creating it at
+// 1 if it does not exist. It is safe for concurrent use.
+func (db *DB) SequenceNext(name string) (int64, error) {
+	if name == "" || len(name) > 256 {
+		return 0, fmt.Errorf("bad sequence name: %q", name)
+	}
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	r := db.seqs[name]
+	if r == nil || r.next == r.end {
+		batch := int64(db.SequenceBatch)
+		if batch <= 0 {
+			batch = DefaultSequenceBatch
+		}
+		next, err := db.seqReserve(name, batch)
+		if err != nil {
+			return 0, err
+		}
+		if db.seqs == nil {
+			db.seqs = map[string]*seqRange{}
+		}
+		r = &seqRange{next, next + batch}
+		db.seqs[name] = r
+	}
+	r.next++
+	return r.next - 1, nil
+}
+
+// seqReserve reserves the next n values of the sequence name and returns
+// the first.
+func (db *DB) seqReserve(name string, n int64) (int64, error) {
+	var next int64
+	err := db.Update(func(tx *DBTX) error {
+		rec := (&Record{}).AddStr("name", []byte(name))
+		ok, err := dbGet(&tx.DBReader, tdefSeq, rec)
+		if err != nil {
+			return err
+		}
+		next = 1
+		if ok {
+			next = rec.Get("next").I64
+		} else {
+			rec.AddInt64("next", 0)
+		}
+		if next > math.MaxInt64-n {
+			return fmt.Errorf("sequence exhausted: %s", name)
+		}
+		rec.Get("next").I64 = next + n
+		return dbUpdate(tx, tdefSeq, &DBSetReq{Record: *rec})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
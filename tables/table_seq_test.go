@@ -0,0 +1,67 @@
+package tables
+
+import (
+	"sync"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestSequenceNext(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.db.SequenceBatch = 3
+	seqRow := func() int64 {
+		r := DBReader{}
+		tt.db.BeginRead(&r)
+		defer tt.db.EndRead(&r)
+		rec := (&Record{}).AddStr("name", []byte("s"))
+		ok, err := r.Get("@seq", rec)
+		is.NoError(t, err)
+		is.True(t, ok)
+		return rec.Get("next").I64
+	}
+
+	for want := int64(1); want <= 4; want++ {
+		v, err := tt.db.SequenceNext("s")
+		is.NoError(t, err)
+		is.Equal(t, want, v)
+	}
+	// Two ranges of 3 were reserved.
+	is.Equal(t, int64(7), seqRow())
+	v, err := tt.db.SequenceNext("other")
+	is.NoError(t, err)
+	is.Equal(t, int64(1), v)
+	_, err = tt.db.SequenceNext("")
+	is.Error(t, err)
+
+	// After a restart the sequence goes on from the next range.
+	tt.db.Close()
+	tt.db = DB{Path: "r.db", SequenceBatch: 3}
+	is.NoError(t, tt.db.Open())
+	v, err = tt.db.SequenceNext("s")
+	is.NoError(t, err)
+	is.Equal(t, int64(7), v)
+
+	// Concurrent callers get distinct values.
+	var mu sync.Mutex
+	seen := map[int64]bool{}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				v, err := tt.db.SequenceNext("s")
+				is.NoError(t, err)
+				mu.Lock()
+				is.False(t, seen[v])
+				seen[v] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	is.Len(t, seen, 100)
+	is.Equal(t, int64(109), seqRow())
+}
@@ -0,0 +1,241 @@
+package tables
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Soft deletes
+// ---------------------------------------------------------------------------
+
+// Delete on a table created with TableDef.SoftDelete leaves the row in
+// place and marks it with a tombstone: the time it was deleted, kept in the
+// @tomb internal table, keyed by table and encoded primary key, with a
+// secondary index ordered by table and time for DB.Purge to walk. A
+// tombstoned row reads as absent, unless a scan asks for it with
+// Scanner.Deleted, and DBTX.Undelete brings it back. It is removed for good
+// by DB.Purge, or by the next write to its key.
+
+// tdefTomb stores the time each soft-deleted row was deleted, in Unix
+// nanoseconds.
+var tdefTomb = &TableDef{
+	Prefix:        9,
+	Name:          "@tomb",
+	Types:         []uint32{TypeBytes, TypeBytes, TypeInt64},
+	Cols:          []string{"table", "key", "deleted"},
+	PKeys:         2,
+	Indexes:       [][]string{{"table", "deleted", "key"}},
+	IndexPrefixes: []uint32{10},
+}
+
+func tombKey(tdef *TableDef, pk []Value) *Record {
+	key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], pk)
+	return (&Record{}).AddStr("table", []byte(tdef.Name)).AddStr("key", key)
+}
+
+// rowDeleted returns the time the row with primary key pk was soft-deleted,
+// or 0 if it was not.
+func rowDeleted(tx *DBReader, tdef *TableDef, pk []Value) int64 {
+	rec := tombKey(tdef, pk)
+	ok, err := dbGet(tx, tdefTomb, rec)
+	assert(err == nil)
+	if !ok {
+		return 0
+	}
+	return rec.Get("deleted").I64
+}
+
+// setRowDeleted gives a row a tombstone at the time at, or removes it if at
+// is 0.
+func setRowDeleted(tx *DBTX, tdef *TableDef, pk []Value, at int64) error {
+	rec := tombKey(tdef, pk)
+	if at == 0 {
+		_, err := dbDelete(tx, tdefTomb, *rec)
+		return err
+	}
+	return dbUpdate(tx, tdefTomb, &DBSetReq{Record: *rec.AddInt64("deleted", at)})
+}
+
+// reclaimDeleted removes the row with primary key pk for good if it is
+// soft-deleted, before a write to it.
+func reclaimDeleted(tx *DBTX, tdef *TableDef, pk []Value) error {
+	if rowDeleted(&tx.DBReader, tdef, pk) == 0 {
+		return nil
+	}
+	_, err := dbDelete(tx, tdef, Record{tdef.Cols[:tdef.PKeys], pk})
+	return err
+}
+
+// liveRow reports whether the row of tdef with primary key pk exists, has
+// not expired and is not soft-deleted.
+func liveRow(tx *DBReader, tdef *TableDef, pk []Value) (bool, error) {
+	row := Record{tdef.Cols[:tdef.PKeys], slices.Clone(pk)}
+	if ok, err := dbGet(tx, tdef, &row); !ok || err != nil {
+		return false, err
+	}
+	if tdef.Expires && expired(rowDeadline(tx, tdef, pk), time.Now().UnixNano()) {
+		return false, nil
+	}
+	return rowDeleted(tx, tdef, pk) == 0, nil
+}
+
+// softDelete gives the row rec of tdef, a table with SoftDelete set, a
+// tombstone. Returns false if the row does not exist or is deleted already.
+func softDelete(tx *DBTX, tdef *TableDef, rec Record) (bool, error) {
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return false, err
+	}
+	pk := values[:tdef.PKeys]
+	if ok, err := liveRow(&tx.DBReader, tdef, pk); !ok || err != nil {
+		return false, err
+	}
+	if err := recordAudit(tx, tdef, ChangeDelete, pk); err != nil {
+		return false, err
+	}
+	return true, setRowDeleted(tx, tdef, pk, time.Now().UnixNano())
+}
+
+// softDeleteDef returns the definition of table, a table with SoftDelete
+// set.
+func softDeleteDef(tx *DBReader, table string) (*TableDef, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	if !tdef.SoftDelete {
+		return nil, fmt.Errorf("table does not soft-delete rows: %s", table)
+	}
+	return tdef, nil
+}
+
+// Undelete removes the tombstone of a soft-deleted row of table, so that
+// it reads as present again. rec holds the primary key. Returns false if
+// the row is not soft-deleted, or was purged.
+func (tx *DBTX) Undelete(table string, rec Record) (bool, error) {
+	tdef, err := softDeleteDef(&tx.DBReader, table)
+	if err != nil {
+		return false, err
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return false, err
+	}
+	pk := values[:tdef.PKeys]
+	if rowDeleted(&tx.DBReader, tdef, pk) == 0 {
+		return false, nil
+	}
+	if err := recordAudit(tx, tdef, ChangeInsert, pk); err != nil {
+		return false, err
+	}
+	return true, setRowDeleted(tx, tdef, pk, 0)
+}
+
+// DeletedAt returns the time a row of table, a table with SoftDelete set,
+// was soft-deleted. rec holds the primary key. Returns false if the row is
+// not soft-deleted.
+func (tx *DBReader) DeletedAt(table string, rec Record) (time.Time, bool, error) {
+	tdef, err := softDeleteDef(tx, table)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	at := rowDeleted(tx, tdef, values[:tdef.PKeys])
+	if at == 0 {
+		return time.Time{}, false, nil
+	}
+	return time.Unix(0, at), true, nil
+}
+
+// PurgeReq configures DB.Purge.
+type PurgeReq struct {
+	Before    time.Time     // purge the rows soft-deleted before this time
+	BatchSize int           // rows removed per transaction (0 = DefaultSweepBatch)
+	Pause     time.Duration // sleep between batches to throttle the deletes
+}
+
+// Purge removes for good the rows of table, a table with SoftDelete set,
+// that were soft-deleted before req.Before, walking the tombstone index in
+// batches like Sweep: each batch is its own short transaction, retried on
+// an OCC conflict, and paced by req.Pause and DB.Maintenance. Returns the
+// number of rows removed.
+func (db *DB) Purge(table string, req *PurgeReq) (int, error) {
+	r := DBReader{}
+	db.BeginRead(&r)
+	_, err := softDeleteDef(&r, table)
+	db.EndRead(&r)
+	if err != nil {
+		return 0, err
+	}
+	batch := req.BatchSize
+	if batch <= 0 {
+		batch = DefaultSweepBatch
+	}
+	total := 0
+	for {
+		n, size, done, err := db.purgeBatchRetry(table, req.Before.UnixNano(), batch)
+		if err != nil {
+			return total, err
+		}
+		db.Maintenance.Wait(n, int64(size))
+		total += n
+		if done {
+			return total, nil
+		}
+		if req.Pause > 0 {
+			time.Sleep(req.Pause)
+		}
+	}
+}
+
+func (db *DB) purgeBatchRetry(table string, before int64, batch int) (int, int, bool, error) {
+	var n, size int
+	var done bool
+	err := db.Update(func(tx *DBTX) (err error) {
+		n, size, done, err = purgeBatch(tx, table, before, batch)
+		return err
+	})
+	return n, size, done, err
+}
+
+// purgeBatch removes up to batch rows of table soft-deleted before the time
+// before. It returns the number of rows and of encoded row bytes removed;
+// done is true once no such row is left.
+func purgeBatch(tx *DBTX, table string, before int64, batch int) (int, int, bool, error) {
+	tdef, err := softDeleteDef(&tx.DBReader, table)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	lo := *(&Record{}).AddStr("table", []byte(table)).AddInt64("deleted", 0)
+	hi := *(&Record{}).AddStr("table", []byte(table)).AddInt64("deleted", before)
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLT, Key1: lo, Key2: hi}
+	if err := dbScan(&tx.DBReader, tdefTomb, &sc); err != nil {
+		return 0, 0, false, err
+	}
+
+	// Collect the batch first to avoid mutating while iterating.
+	var keys [][]byte
+	for ; sc.Valid() && len(keys) < batch; sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		keys = append(keys, slices.Clone(rec.Get("key").Str))
+	}
+	done := !sc.Valid()
+
+	size := 0
+	for _, key := range keys {
+		freed, err := deleteByKey(tx, tdef, key)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		size += freed
+	}
+	return len(keys), size, done, nil
+}
@@ -0,0 +1,132 @@
+package tables
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestSoftDelete(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:       "t",
+		Cols:       []string{"id", "grp", "v"},
+		Types:      []uint32{TypeInt64, TypeInt64, TypeBytes},
+		PKeys:      1,
+		Indexes:    [][]string{{"grp"}},
+		SoftDelete: true,
+	})
+	row := func(id int64, v string) Record {
+		return *(&Record{}).AddInt64("id", id).AddInt64("grp", id%2).AddStr("v", []byte(v))
+	}
+	pk := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+	for id := range int64(6) {
+		tt.add("t", row(id, "a"))
+	}
+	scan := func(tx *DBReader, sc Scanner) []int64 {
+		is.NoError(t, tx.Scan("t", &sc))
+		var ids []int64
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids
+	}
+	odd := *(&Record{}).AddInt64("grp", 1)
+	byGrp := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: odd, Key2: odd}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	for _, id := range []int64{1, 2} {
+		ok, err := tx.Delete("t", pk(id))
+		is.NoError(t, err)
+		is.True(t, ok)
+	}
+	ok, err := tx.Delete("t", pk(1)) // deleted already
+	is.NoError(t, err)
+	is.False(t, ok)
+	ok, err = tx.Delete("t", pk(9))
+	is.NoError(t, err)
+	is.False(t, ok)
+
+	// The rows read as absent, unless asked for.
+	got := pk(1)
+	ok, err = tx.Get("t", &got)
+	is.NoError(t, err)
+	is.False(t, ok)
+	is.Equal(t, []int64{0, 3, 4, 5}, scan(&tx.DBReader, FullScan()))
+	is.Equal(t, []int64{3, 5}, scan(&tx.DBReader, byGrp))
+	all := FullScan()
+	all.Deleted = true
+	is.Equal(t, []int64{0, 1, 2, 3, 4, 5}, scan(&tx.DBReader, all))
+	at, ok, err := tx.DeletedAt("t", pk(1))
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.WithinDuration(t, time.Now(), at, time.Minute)
+	_, ok, err = tx.DeletedAt("t", pk(0))
+	is.NoError(t, err)
+	is.False(t, ok)
+
+	// Undelete brings a row back, and a write replaces a deleted row.
+	ok, err = tx.Undelete("t", pk(1))
+	is.NoError(t, err)
+	is.True(t, ok)
+	ok, err = tx.Undelete("t", pk(1))
+	is.NoError(t, err)
+	is.False(t, ok)
+	ok, err = tx.Update("t", row(2, "b")) // no row to update
+	is.NoError(t, err)
+	is.False(t, ok)
+	ok, err = tx.Insert("t", row(2, "c"))
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, []int64{0, 1, 2, 3, 4, 5}, scan(&tx.DBReader, FullScan()))
+	got = pk(2)
+	ok, err = tx.Get("t", &got)
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, "c", string(got.Get("v").Str))
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// Purge removes the rows deleted before the cutoff only.
+	tt.db.Begin(&tx)
+	for _, id := range []int64{3, 4, 5} {
+		_, err := tx.Delete("t", pk(id))
+		is.NoError(t, err)
+	}
+	is.NoError(t, tt.db.Commit(&tx))
+	cutoff := time.Now()
+	tt.db.Begin(&tx)
+	_, err = tx.Delete("t", pk(0))
+	is.NoError(t, err)
+	is.NoError(t, tt.db.Commit(&tx))
+
+	n, err := tt.db.Purge("t", &PurgeReq{Before: cutoff, BatchSize: 2})
+	is.NoError(t, err)
+	is.Equal(t, 3, n)
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	all = FullScan()
+	all.Deleted = true
+	is.Equal(t, []int64{0, 1, 2}, scan(&r, all))
+	is.Equal(t, []int64{1, 2}, scan(&r, FullScan()))
+	count, err := r.Count("t")
+	is.NoError(t, err)
+	is.Equal(t, int64(3), count) // the soft-deleted row 0 is counted
+	tt.db.EndRead(&r)
+	check := tt.db.Check()
+	is.True(t, check.OK(), "%v", check.Problems)
+	report, err := tt.db.CheckIndexes("t")
+	is.NoError(t, err)
+	is.True(t, report.OK())
+
+	_, err = tt.db.Purge("nope", &PurgeReq{Before: cutoff})
+	is.Error(t, err)
+	tt.create(&TableDef{Name: "plain", Cols: []string{"id", "v"}, Types: []uint32{TypeInt64, TypeBytes}, PKeys: 1})
+	_, err = tt.db.Purge("plain", &PurgeReq{Before: cutoff})
+	is.Error(t, err)
+}
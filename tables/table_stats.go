@@ -0,0 +1,168 @@
+package tables
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/kv"
+)
+
+// ---------------------------------------------------------------------------
+// Database statistics
+// ---------------------------------------------------------------------------
+
+// statsSamples is the number of leaves Stats reads per key range. Ranges of
+// up to that many leaves are counted exactly.
+const statsSamples = 16
+
+// Stats describes the database file, its tables and its in-memory caches.
+type Stats struct {
+	KV     kv.Stats
+	Tables []TableStats // user tables, in name order
+
+	TableDefs CacheStats // cache of table definitions
+	Auth      CacheStats // cache of verified passwords (see Authenticate)
+}
+
+// TableStats is the approximate size of one table, estimated by sampling
+// the B-tree leaves that hold it.
+type TableStats struct {
+	Name  string
+	Rows  int64 // rows
+	Bytes int64 // encoded key and value bytes of the rows and index entries
+	Exact bool  // every leaf was read, so Rows and Bytes are exact
+}
+
+// CacheStats counts the lookups in a cache.
+type CacheStats struct {
+	Hits, Misses uint64
+}
+
+// HitRate returns the share of lookups served from the cache, or 0 if there
+// were none.
+func (c CacheStats) HitRate() float64 {
+	if c.Hits+c.Misses == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(c.Hits+c.Misses)
+}
+
+// Stats collects the current statistics. Table sizes are estimated from a
+// few leaves per table and index, so the cost grows with the number of
+// tables and the height of the tree rather than with the amount of data.
+func (db *DB) Stats() (Stats, error) {
+	st := Stats{
+		KV:        db.kv.Stats(),
+		TableDefs: CacheStats{db.stats.defHits.Load(), db.stats.defMisses.Load()},
+		Auth:      CacheStats{db.stats.authHits.Load(), db.stats.authMisses.Load()},
+	}
+
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	names, err := tableNames(&tx)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, name := range names {
+		tdef := getTableDef(&tx, name)
+		if tdef == nil {
+			continue
+		}
+		ts := TableStats{Name: name, Exact: true}
+		for i, prefix := range append([]uint32{tdef.Prefix}, tdef.IndexPrefixes...) {
+			est := tx.kvtx.EstimateRange(prefixKey(prefix), prefixKey(prefix+1), statsSamples)
+			if i == 0 {
+				ts.Rows = est.Keys
+			}
+			ts.Bytes += est.Bytes
+			ts.Exact = ts.Exact && est.Exact
+		}
+		st.Tables = append(st.Tables, ts)
+	}
+	return st, nil
+}
+
+// prefixKey returns the smallest key with the given table or index prefix.
+func prefixKey(prefix uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, prefix)
+}
+
+// Check verifies the integrity of the underlying store; see kv.KV.Check.
+func (db *DB) Check() btree.CheckReport {
+	return db.kv.Check()
+}
+
+// FreeStats reports the free pages of the file; see KV.FreeStats.
+func (db *DB) FreeStats() btree.FreeStats {
+	return db.kv.FreeStats()
+}
+
+// TreeStats reports the shape of the tree of the file; see KV.TreeStats.
+func (db *DB) TreeStats() btree.TreeStats {
+	return db.kv.TreeStats()
+}
+
+// Compact rewrites the underlying store into a file without free pages; see
+// kv.KV.Compact. Maintenance paces the copy.
+func (db *DB) Compact() (kv.CompactReport, error) {
+	return db.kv.Compact()
+}
+
+// Backup writes a consistent copy of the underlying store to w, to be
+// restored with kv.RestoreFrom; see kv.KV.Backup. Maintenance paces it.
+func (db *DB) Backup(w io.Writer) (kv.BackupReport, error) {
+	return db.kv.Backup(w)
+}
+
+// BackupSince writes an incremental backup of the pages written after
+// version gen, to be applied with kv.ApplyBackup; see kv.KV.BackupSince.
+func (db *DB) BackupSince(gen uint64, w io.Writer) (kv.BackupReport, error) {
+	return db.kv.BackupSince(gen, w)
+}
+
+// SnapshotTo writes the committed state of the underlying store to a new
+// database file at path without blocking writers; see kv.KV.SnapshotTo.
+func (db *DB) SnapshotTo(path string) error {
+	return db.kv.SnapshotTo(path)
+}
+
+// Feed opens a feed of the changes committed from now on, for replication;
+// see kv.KV.Feed.
+func (db *DB) Feed(queue int) *kv.Feed {
+	return db.kv.Feed(queue)
+}
+
+// Apply lays a record of a leader's Feed over this ReadOnly database; see
+// kv.KV.Apply.
+func (db *DB) Apply(data []byte) error {
+	defer db.defsChanging()()
+	return db.kv.Apply(data)
+}
+
+// ApplyLogged applies an entry of the replicated log to a database in
+// logged mode; see kv.KV.ApplyLogged.
+func (db *DB) ApplyLogged(index uint64, entry []byte) error {
+	defer db.defsChanging()()
+	return db.kv.ApplyLogged(index, entry)
+}
+
+// SkipLogged records an entry of the replicated log that holds no changes;
+// see kv.KV.SkipLogged.
+func (db *DB) SkipLogged(index uint64) {
+	db.kv.SkipLogged(index)
+}
+
+// InstallLogged replaces the contents of the database with a snapshot of
+// the replicated log's state; see kv.KV.InstallLogged.
+func (db *DB) InstallLogged(src *kv.KV, applied, lastWrite uint64) error {
+	defer db.defsChanging()()
+	return db.kv.InstallLogged(src, applied, lastWrite)
+}
+
+// LogState returns the position of the database in the replicated log;
+// see kv.KV.LogState.
+func (db *DB) LogState() (applied, lastWrite uint64) {
+	return db.kv.LogState()
+}
@@ -0,0 +1,56 @@
+package tables
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "stats.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	tx := DBTX{}
+	db.Begin(&tx)
+	for _, tdef := range []*TableDef{
+		{Name: "big", Cols: []string{"k", "n", "v"}, Types: []uint32{TypeInt64, TypeInt64, TypeBytes}, PKeys: 1, Indexes: [][]string{{"n"}}},
+		{Name: "small", Cols: []string{"k", "v"}, Types: []uint32{TypeInt64, TypeBytes}, PKeys: 1},
+		{Name: "empty", Cols: []string{"k"}, Types: []uint32{TypeInt64}, PKeys: 1},
+	} {
+		is.NoError(t, tx.TableNew(tdef))
+	}
+	val := bytes.Repeat([]byte("x"), 200)
+	for i := range 5000 {
+		_, err := tx.Insert("big", *(&Record{}).AddInt64("k", int64(i)).AddInt64("n", int64(i%7)).AddStr("v", val))
+		is.NoError(t, err)
+	}
+	for i := range 10 {
+		_, err := tx.Insert("small", *(&Record{}).AddInt64("k", int64(i)).AddStr("v", []byte("x")))
+		is.NoError(t, err)
+	}
+	is.NoError(t, db.Commit(&tx))
+
+	st, err := db.Stats()
+	is.NoError(t, err)
+	is.Equal(t, 3, st.KV.TreeHeight)
+	is.Equal(t, []string{"big", "empty", "small"}, []string{st.Tables[0].Name, st.Tables[1].Name, st.Tables[2].Name})
+
+	big := st.Tables[0]
+	is.False(t, big.Exact)
+	is.InEpsilon(t, 5000, big.Rows, 0.2)
+	// Rows and their index entries on n.
+	is.Greater(t, big.Bytes, int64(5000*200))
+	is.Less(t, big.Bytes, int64(5000*300))
+	is.Equal(t, TableStats{Name: "empty", Exact: true}, st.Tables[1])
+	small := st.Tables[2]
+	is.True(t, small.Exact)
+	is.Equal(t, int64(10), small.Rows)
+
+	// The table definitions were cached by the inserts.
+	is.Positive(t, st.TableDefs.Hits)
+	is.Positive(t, st.TableDefs.HitRate())
+	is.Zero(t, st.Auth.HitRate())
+}
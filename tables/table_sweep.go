@@ -0,0 +1,177 @@
+package tables
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Expiry sweeper
+// ---------------------------------------------------------------------------
+
+// Defaults of the SweepReq settings left at 0.
+const (
+	DefaultSweepBatch    = 256
+	DefaultSweepInterval = time.Minute
+)
+
+// SweepReq configures DB.Sweep and the sweeper started by DB.StartSweeper.
+type SweepReq struct {
+	BatchSize int           // rows deleted per transaction (0 = DefaultSweepBatch)
+	Pause     time.Duration // sleep between batches to throttle the deletes
+	// Interval is the time between two passes of the sweeper (0 =
+	// DefaultSweepInterval).
+	Interval time.Duration
+	// OnError, if set, receives the error of a failed pass; the sweeper
+	// tries again at the next one.
+	OnError func(err error)
+}
+
+// Sweep deletes the rows whose deadline has passed (see DBTX.ExpireAt),
+// walking the deadline index in batches. Each batch is its own short
+// transaction, so concurrent writers are never blocked for longer than one
+// batch; a batch that loses an OCC conflict is retried. Batches are paced by
+// req.Pause and DB.Maintenance. Returns the number of rows deleted.
+func (db *DB) Sweep(req *SweepReq) (int, error) {
+	batch := req.BatchSize
+	if batch <= 0 {
+		batch = DefaultSweepBatch
+	}
+	now := time.Now().UnixNano()
+	total := 0
+	for {
+		n, size, done, err := db.sweepBatchRetry(now, batch)
+		if err != nil {
+			return total, err
+		}
+		db.Maintenance.Wait(n, int64(size))
+		total += n
+		if done {
+			return total, nil
+		}
+		if req.Pause > 0 {
+			time.Sleep(req.Pause)
+		}
+	}
+}
+
+func (db *DB) sweepBatchRetry(now int64, batch int) (int, int, bool, error) {
+	var n, size int
+	var done bool
+	err := db.Update(func(tx *DBTX) (err error) {
+		n, size, done, err = sweepBatch(tx, now, batch)
+		return err
+	})
+	return n, size, done, err
+}
+
+// sweepBatch deletes up to batch rows whose deadline is at or before now.
+// It returns the number of rows and of encoded row bytes deleted; done is
+// true once no expired row is left.
+func sweepBatch(tx *DBTX, now int64, batch int) (int, int, bool, error) {
+	lo, hi := *(&Record{}).AddInt64("deadline", 0), *(&Record{}).AddInt64("deadline", now)
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: lo, Key2: hi}
+	if err := dbScan(&tx.DBReader, tdefTTL, &sc); err != nil {
+		return 0, 0, false, err
+	}
+
+	// Collect the batch first to avoid mutating while iterating.
+	var entries []Record
+	for ; sc.Valid() && len(entries) < batch; sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		entries = append(entries, rec)
+	}
+	done := !sc.Valid()
+
+	size := 0
+	for _, entry := range entries {
+		tdef := getTableDef(&tx.DBReader, string(entry.Get("table").Str))
+		if tdef == nil {
+			// The table is gone; only its deadline is left.
+			if _, err := dbDelete(tx, tdefTTL, Record{entry.Cols[:2], entry.Vals[:2]}); err != nil {
+				return 0, 0, false, err
+			}
+			continue
+		}
+		freed, err := deleteByKey(tx, tdef, entry.Get("key").Str)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		size += freed
+	}
+	return len(entries), size, done, nil
+}
+
+// deleteByKey deletes the row of tdef whose encoded primary key is key, and
+// returns the bytes of row data freed: the key and the encoded values, or 0
+// when no row is left under key.
+func deleteByKey(tx *DBTX, tdef *TableDef, key []byte) (int, error) {
+	pk := make([]Value, tdef.PKeys)
+	for i := range pk {
+		pk[i].Type = tdef.Types[i]
+	}
+	decodeKey(tdef, tdef.Cols[:tdef.PKeys], key[4:], pk)
+	freed := 0
+	row := Record{tdef.Cols[:tdef.PKeys], slices.Clone(pk)}
+	if ok, err := dbGet(&tx.DBReader, tdef, &row); ok && err == nil {
+		freed = len(key) + len(encodeRowValues(tdef, nil, row.Vals[tdef.PKeys:]))
+	}
+	_, err := dbDelete(tx, tdef, Record{tdef.Cols[:tdef.PKeys], pk})
+	return freed, err
+}
+
+// Sweeper deletes expired rows in the background; see DB.StartSweeper.
+type Sweeper struct {
+	stop chan struct{}
+	done chan struct{}
+
+	mu   sync.Mutex
+	rows int
+}
+
+// StartSweeper runs Sweep every req.Interval until Stop.
+func (db *DB) StartSweeper(req SweepReq) *Sweeper {
+	interval := req.Interval
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	s := &Sweeper{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-tick.C:
+			}
+			n, err := db.Sweep(&req)
+			s.mu.Lock()
+			s.rows += n
+			s.mu.Unlock()
+			if err != nil && req.OnError != nil {
+				req.OnError(err)
+			}
+		}
+	}()
+	return s
+}
+
+// Rows returns the number of rows the sweeper deleted so far.
+func (s *Sweeper) Rows() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows
+}
+
+// Stop stops the sweeper, waiting for a pass under way to finish. Stop it
+// before closing the database.
+func (s *Sweeper) Stop() {
+	close(s.stop)
+	<-s.done
+}
@@ -0,0 +1,110 @@
+package tables
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestSweep(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "sweep.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	update := func(fn func(tx *DBTX) error) {
+		t.Helper()
+		tx := DBTX{}
+		db.Begin(&tx)
+		is.NoError(t, fn(&tx))
+		is.NoError(t, db.Commit(&tx))
+	}
+	pk := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+	// stored counts the rows in the B-tree, expired or not.
+	stored := func(tdef *TableDef) int {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		sc := Scanner{Cmp1: btree.CmpGE, raw: true}
+		is.NoError(t, dbScan(&r, tdef, &sc))
+		n := 0
+		for ; sc.Valid(); sc.Next() {
+			n++
+		}
+		return n
+	}
+
+	update(func(tx *DBTX) error {
+		return tx.TableNew(&TableDef{
+			Name:    "t",
+			Cols:    []string{"id", "k", "v"},
+			Types:   []uint32{TypeInt64, TypeInt64, TypeBytes},
+			PKeys:   1,
+			Indexes: [][]string{{"k"}},
+			Expires: true,
+		})
+	})
+	update(func(tx *DBTX) error {
+		for id := range int64(100) {
+			row := pk(id)
+			if _, err := tx.Insert("t", *row.AddInt64("k", id%3).AddStr("v", []byte("v"))); err != nil {
+				return err
+			}
+			at := time.Now().Add(-time.Second)
+			if id%2 == 1 {
+				at = time.Now().Add(time.Hour)
+			}
+			if _, err := tx.ExpireAt("t", pk(id), at); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tdef := func() *TableDef {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		return r.TableDef("t")
+	}()
+	is.Equal(t, 100, stored(tdef))
+
+	// Expired rows are deleted in batches, index entries and deadlines
+	// included; the others stay.
+	n, err := db.Sweep(&SweepReq{BatchSize: 7})
+	is.NoError(t, err)
+	is.Equal(t, 50, n)
+	is.Equal(t, 50, stored(tdef))
+	is.Equal(t, 50, stored(tdefTTL))
+	r := DBReader{}
+	db.BeginRead(&r)
+	for k := range int64(3) {
+		key := *(&Record{}).AddInt64("k", k)
+		sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key, Key2: key}
+		is.NoError(t, r.Scan("t", &sc))
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			is.Equal(t, int64(1), rec.Get("id").I64%2)
+		}
+	}
+	db.EndRead(&r)
+	n, err = db.Sweep(&SweepReq{})
+	is.NoError(t, err)
+	is.Zero(t, n)
+
+	// The background sweeper does the same on its own.
+	update(func(tx *DBTX) error {
+		for id := int64(1); id < 20; id += 2 {
+			if _, err := tx.ExpireAt("t", pk(id), time.Now().Add(-time.Second)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s := db.StartSweeper(SweepReq{Interval: 10 * time.Millisecond, OnError: func(err error) { t.Error(err) }})
+	is.Eventually(t, func() bool { return s.Rows() == 10 }, 5*time.Second, 10*time.Millisecond)
+	s.Stop()
+	is.Equal(t, 40, stored(tdef))
+}
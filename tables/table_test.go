@@ -1,13 +1,21 @@
 package tables
 
 import (
+	"encoding/binary"
+	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MHS-20/ElkDB/btree"
+	"github.com/MHS-20/ElkDB/events"
+	"github.com/MHS-20/ElkDB/kv"
 	is "github.com/stretchr/testify/require"
 )
 
@@ -210,6 +218,107 @@ func TestTableBasic(t *testing.T) {
 	tt.dispose()
 }
 
+func TestUpsertOld(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "name", "n"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"name"}},
+	})
+	row := func(id int64, name string, n int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("name", []byte(name)).AddInt64("n", n)
+	}
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	old, err := tx.UpsertOld("t", row(1, "a", 10))
+	is.NoError(t, err)
+	is.Nil(t, old)
+	old, err = tx.UpsertOld("t", row(1, "b", 20))
+	is.NoError(t, err)
+	is.Equal(t, row(1, "a", 10).Vals, old.Vals)
+	is.Equal(t, []string{"id", "name", "n"}, old.Cols)
+	old, err = tx.UpsertOld("t", row(1, "b", 20)) // unchanged
+	is.NoError(t, err)
+	is.Equal(t, row(1, "b", 20).Vals, old.Vals)
+
+	// Any mode can ask for the row.
+	req := DBSetReq{Record: row(1, "c", 30), Mode: btree.ModeInsertOnly, WantOld: true}
+	is.NoError(t, tx.Set("t", &req))
+	is.False(t, req.Added)
+	is.Equal(t, row(1, "b", 20).Vals, req.Old.Vals)
+	req = DBSetReq{Record: row(2, "c", 30), Mode: btree.ModeUpdateOnly, WantOld: true}
+	is.NoError(t, tx.Set("t", &req))
+	is.False(t, req.Updated)
+	is.Nil(t, req.Old)
+	req = DBSetReq{Record: row(1, "c", 30), Mode: btree.ModeUpsert}
+	is.NoError(t, tx.Set("t", &req))
+	is.Nil(t, req.Old) // not asked for
+	is.NoError(t, tt.db.Commit(&tx))
+}
+
+func TestLongPrimaryKey(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "pages",
+		Cols:    []string{"url", "hits", "title"},
+		Types:   []uint32{TypeBytes, TypeInt64, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"hits"}, {"title"}},
+		Codec:   CodecFlat,
+	})
+
+	// URLs past the key limit, sharing all of it, scan in order, and so do
+	// the index entries holding them.
+	base := "https://example.com/" + strings.Repeat("a/", btree.MaxKeySize)
+	for _, i := range []int64{3, 1, 2} {
+		rec := Record{}
+		rec.AddStr("url", fmt.Appendf(nil, "%s%d", base, i)).AddInt64("hits", i).AddStr("title", []byte("t"))
+		is.True(t, tt.add("pages", rec))
+	}
+	rec := Record{}
+	rec.AddStr("url", []byte(base+"2"))
+	is.True(t, tt.get("pages", &rec))
+	is.Equal(t, int64(2), rec.Get("hits").I64)
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	sc := Scanner{Cmp1: btree.CmpGE}
+	sc.Key1.AddStr("url", []byte(base))
+	is.NoError(t, tx.Scan("pages", &sc))
+	var hits []int64
+	for rec := (Record{}); sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		hits = append(hits, rec.Get("hits").I64)
+	}
+	is.Equal(t, []int64{1, 2, 3}, hits)
+	sc = Scanner{Cmp1: btree.CmpLE, Cmp2: btree.CmpGE}
+	sc.Key1.AddInt64("hits", 2)
+	sc.Key2.AddInt64("hits", 1)
+	is.NoError(t, tx.Scan("pages", &sc))
+	var urls []string
+	for rec := (Record{}); sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		urls = append(urls, string(rec.Get("url").Str))
+	}
+	is.Equal(t, []string{base + "2", base + "1"}, urls)
+
+	// A key past the room of the KV pair fails the write, be it the row's
+	// or an index entry's, whose escaped zero bytes take twice the room.
+	huge := Record{}
+	huge.AddStr("url", make([]byte, btree.MaxKeySize+btree.MaxValSize)).AddInt64("hits", 0).AddStr("title", nil)
+	_, err := tx.Insert("pages", huge)
+	is.ErrorContains(t, err, "key too large")
+	huge = Record{}
+	huge.AddStr("url", []byte("u")).AddInt64("hits", 0).AddStr("title", make([]byte, btree.MaxValSize-100))
+	_, err = tx.Insert("pages", huge)
+	is.ErrorContains(t, err, "index [title url] of pages: key too large")
+}
+
 func TestStringEscape(t *testing.T) {
 	in := [][]byte{
 		{},
@@ -233,6 +342,69 @@ func TestStringEscape(t *testing.T) {
 	}
 }
 
+func TestRecordBuilder(t *testing.T) {
+	when := time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	id := NewUUIDv7()
+	rec := (&Record{}).AddBytes("b", []byte("x")).AddInt64("i", -1).AddFloat64("f", 1.5).
+		AddBool("ok", true).AddTime("at", when).AddUUID("u", id).AddNull("n")
+
+	b, ok := rec.GetBytes("b")
+	is.True(t, ok)
+	is.Equal(t, []byte("x"), b)
+	i, ok := rec.GetInt64("i")
+	is.True(t, ok)
+	is.Equal(t, int64(-1), i)
+	f, ok := rec.GetFloat64("f")
+	is.True(t, ok)
+	is.Equal(t, 1.5, f)
+	flag, ok := rec.GetBool("ok")
+	is.True(t, ok)
+	is.True(t, flag)
+	at, ok := rec.GetTime("at")
+	is.True(t, ok)
+	is.True(t, when.Equal(at))
+	u, ok := rec.GetUUID("u")
+	is.True(t, ok)
+	is.Equal(t, id, u)
+	is.True(t, rec.IsNull("n"))
+	is.False(t, rec.IsNull("i"))
+
+	// Wrong types and missing columns are not found.
+	_, ok = rec.GetInt64("b")
+	is.False(t, ok)
+	_, ok = rec.GetBytes("nope")
+	is.False(t, ok)
+	_, ok = rec.GetTime("f")
+	is.False(t, ok)
+
+	// Set replaces a value in place, or appends the column.
+	rec.Set("i", Value{Type: TypeInt64, I64: 7}).Set("z", Value{Type: TypeBytes, Str: []byte("z")})
+	is.Equal(t, []string{"b", "i", "f", "ok", "at", "u", "n", "z"}, rec.Cols)
+	i, _ = rec.GetInt64("i")
+	is.Equal(t, int64(7), i)
+
+	// A null is a missing column to a table.
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{Name: "t", Cols: []string{"id", "at", "ok"}, Types: []uint32{TypeInt64, TypeInt64, TypeInt64}, PKeys: 1})
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	_, err := tx.Insert("t", *(&Record{}).AddInt64("id", 1).AddTime("at", when).AddNull("ok"))
+	is.ErrorContains(t, err, "missing column: ok")
+	_, err = tx.Insert("t", *(&Record{}).AddInt64("id", 1).AddTime("at", when).AddBool("ok", false))
+	is.NoError(t, err)
+	got := (&Record{}).AddInt64("id", 1)
+	ok, err = tx.Get("t", got)
+	is.NoError(t, err)
+	is.True(t, ok)
+	at, _ = got.GetTime("at")
+	is.True(t, when.Equal(at))
+	flag, ok = got.GetBool("ok")
+	is.True(t, ok)
+	is.False(t, flag)
+}
+
 func TestTableEncoding(t *testing.T) {
 	input := []int{-1, 0, +1, math.MinInt64, math.MaxInt64}
 	sort.Ints(input)
@@ -249,6 +421,273 @@ func TestTableEncoding(t *testing.T) {
 	is.True(t, sort.StringsAreSorted(encoded))
 }
 
+func TestFloatEncoding(t *testing.T) {
+	input := []float64{
+		math.Inf(-1), -math.MaxFloat64, -1e10, -1.5, -1, -math.SmallestNonzeroFloat64,
+		0, math.SmallestNonzeroFloat64, 0.5, 1, 1.5, 1e10, math.MaxFloat64, math.Inf(1),
+	}
+	encoded := []string{}
+	for _, f := range input {
+		v := Value{Type: TypeFloat64, F64: f}
+		b := encodeValues(nil, []Value{v})
+		out := []Value{{Type: TypeFloat64}}
+		decodeValues(b, out)
+		is.Equal(t, f, out[0].F64)
+		encoded = append(encoded, string(b))
+	}
+	is.True(t, sort.StringsAreSorted(encoded))
+
+	// -0 is the same key as +0, and every NaN the same key after +Inf.
+	enc := func(f float64) string {
+		return string(encodeValues(nil, []Value{{Type: TypeFloat64, F64: f}}))
+	}
+	is.Equal(t, enc(0), enc(math.Copysign(0, -1)))
+	is.Equal(t, enc(math.NaN()), enc(math.Float64frombits(0xfff8000000000001)))
+	is.Less(t, enc(math.Inf(1)), enc(math.NaN()))
+}
+
+func TestFloatKeyScan(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "readings",
+		Cols:    []string{"temp", "id", "site", "note"},
+		Types:   []uint32{TypeFloat64, TypeInt64, TypeFloat64, TypeBytes},
+		PKeys:   2,
+		Indexes: [][]string{{"site"}},
+	})
+	temps := []float64{-40.5, -3.25, -0.5, 0, 0.125, 2.5, 19.75, 100}
+	for i, temp := range temps {
+		rec := Record{}
+		rec.AddFloat64("temp", temp).AddInt64("id", int64(i)).AddFloat64("site", -temp).AddStr("note", nil)
+		is.True(t, tt.add("readings", rec))
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	scan := func(col string, cmp1, cmp2 int, lo, hi float64) []float64 {
+		sc := Scanner{Cmp1: cmp1, Cmp2: cmp2}
+		sc.Key1.AddFloat64(col, lo)
+		sc.Key2.AddFloat64(col, hi)
+		is.NoError(t, tx.Scan("readings", &sc))
+		got := []float64{}
+		for rec := (Record{}); sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			got = append(got, rec.Get("temp").F64)
+		}
+		return got
+	}
+	// Primary key prefix ranges, across the sign change.
+	is.Equal(t, []float64{-3.25, -0.5, 0, 0.125}, scan("temp", btree.CmpGE, btree.CmpLE, -3.25, 0.125))
+	is.Equal(t, []float64{-0.5, 0}, scan("temp", btree.CmpGT, btree.CmpLT, -3.25, 0.125))
+	is.Equal(t, []float64{2.5, 0.125, 0, -0.5}, scan("temp", btree.CmpLE, btree.CmpGE, 2.5, -1))
+	// The index on site = -temp runs the other way.
+	is.Equal(t, []float64{2.5, 0.125, 0, -0.5, -3.25}, scan("site", btree.CmpGE, btree.CmpLE, -2.5, 3.25))
+}
+
+func TestDescEncoding(t *testing.T) {
+	tdef := &TableDef{
+		Cols:  []string{"s", "i"},
+		Types: []uint32{TypeBytes, TypeInt64},
+		Desc:  map[string]bool{"s": true, "i": true},
+	}
+	input := []string{"", "\x00", "\x00\x00", "\x01", "a", "ab", "b", "\xfe", "\xff", "\xff\x00"}
+	encoded := []string{}
+	for i, s := range input {
+		vals := []Value{{Type: TypeBytes, Str: []byte(s)}, {Type: TypeInt64, I64: int64(i)}}
+		key := encodeKeyCols(nil, 1, tdef, tdef.Cols, vals)
+		out := []Value{{Type: TypeBytes}, {Type: TypeInt64}}
+		decodeKey(tdef, tdef.Cols, key[4:], out)
+		is.Equal(t, s, string(out[0].Str))
+		is.Equal(t, int64(i), out[1].I64)
+		// The maximum sentinel of a missing column is above every key.
+		is.Less(t, string(key), string(encodeKeyPartial(nil, 1, nil, tdef, tdef.Cols, btree.CmpLE, false)))
+		encoded = append(encoded, string(key))
+	}
+	slices.Reverse(encoded)
+	is.True(t, sort.StringsAreSorted(encoded))
+}
+
+func TestDescKeyScan(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "events",
+		Cols:    []string{"ts", "id", "kind", "msg"},
+		Types:   []uint32{TypeInt64, TypeInt64, TypeBytes, TypeBytes},
+		PKeys:   2,
+		Indexes: [][]string{{"kind", "ts"}},
+		Desc:    map[string]bool{"ts": true, "kind": true},
+		Collate: map[string]string{"kind": CollateNoCase},
+	})
+	for i := range 20 {
+		rec := Record{}
+		rec.AddInt64("ts", int64(i%10)).AddInt64("id", int64(i))
+		rec.AddStr("kind", []byte([]string{"a", "B", ""}[i%3])).AddStr("msg", nil)
+		is.True(t, tt.add("events", rec))
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	scan := func(sc Scanner) (out []int64) {
+		is.NoError(t, tx.Scan("events", &sc))
+		for rec := (Record{}); sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			out = append(out, rec.Get("ts").I64*100+rec.Get("id").I64)
+		}
+		return out
+	}
+	ts := func(v int64) Record { return *(&Record{}).AddInt64("ts", v) }
+	kind := func(s string) Record { return *(&Record{}).AddStr("kind", []byte(s)) }
+
+	// Newest first, with the ascending id breaking ties.
+	is.Equal(t, []int64{909, 919, 808, 818, 707}, scan(FullScan())[:5])
+	// Bounds follow the key order: from ts 2 on is ts 2 and below.
+	is.Equal(t, []int64{202, 212, 101, 111, 0, 10}, scan(Scanner{Cmp1: btree.CmpGE, Key1: ts(2)}))
+	is.Equal(t, []int64{404, 414, 303, 313}, scan(Scanner{Cmp1: btree.CmpGT, Cmp2: btree.CmpLE, Key1: ts(5), Key2: ts(3)}))
+	is.Equal(t, []int64{313, 303, 414, 404}, scan(Scanner{Cmp1: btree.CmpLE, Cmp2: btree.CmpGT, Key1: ts(3), Key2: ts(5)}))
+	// A descending, collated index: kinds from b down, newest first within
+	// each.
+	is.Equal(t, []int64{919, 707, 616, 404, 313, 101, 10}, scan(Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: kind("b"), Key2: kind("B")}))
+	is.Equal(t, []int64{909, 818, 606, 515, 303, 212, 0}, scan(Scanner{Cmp1: btree.CmpGT, Key1: kind("b")})[:7])
+}
+
+func TestCoveringIndex(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "people",
+		Cols:    []string{"id", "city", "age", "name", "bio"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64, TypeBytes, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"city", "age"}, {"name"}},
+		Include: [][]string{{"name"}},
+	})
+	for i := range 10 {
+		rec := Record{}
+		rec.AddInt64("id", int64(i)).AddStr("city", []byte{'a' + byte(i%3)}).AddInt64("age", int64(20+i))
+		rec.AddStr("name", fmt.Appendf(nil, "n%d", i)).AddStr("bio", []byte("long text"))
+		is.True(t, tt.add("people", rec))
+	}
+	// Changing an included column rewrites the index entry.
+	rec := Record{}
+	rec.AddInt64("id", 4).AddStr("city", []byte("b")).AddInt64("age", 24).AddStr("name", []byte("renamed")).AddStr("bio", nil)
+	is.False(t, tt.add("people", rec))
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	city := *(&Record{}).AddStr("city", []byte("b"))
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: city, Key2: city, Cols: []string{"name", "id", "age"}}
+	is.NoError(t, tx.Scan("people", &sc))
+	is.True(t, sc.covered)
+	var got []Record
+	for ; sc.Valid(); sc.Next() {
+		var rec Record
+		sc.Deref(&rec)
+		got = append(got, rec)
+	}
+	is.Equal(t, []Record{
+		*(&Record{}).AddStr("name", []byte("n1")).AddInt64("id", 1).AddInt64("age", 21),
+		*(&Record{}).AddStr("name", []byte("renamed")).AddInt64("id", 4).AddInt64("age", 24),
+		*(&Record{}).AddStr("name", []byte("n7")).AddInt64("id", 7).AddInt64("age", 27),
+	}, got)
+
+	// Columns the index lacks come from the primary rows, and Filter sees
+	// the projected row.
+	sc = Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: city, Key2: city, Cols: []string{"bio"}}
+	sc.Filter = func(rec Record) bool { return len(rec.Cols) == 1 && string(rec.Vals[0].Str) == "long text" }
+	is.NoError(t, tx.Scan("people", &sc))
+	is.False(t, sc.covered)
+	n := 0
+	for ; sc.Valid(); sc.Next() {
+		n++
+	}
+	is.Equal(t, 2, n)
+
+	sc = Scanner{Cmp1: btree.CmpGE, Cols: []string{"nope"}}
+	is.Error(t, tx.Scan("people", &sc))
+	for _, include := range [][][]string{{{"age"}}, {{"id"}}, {{"bio", "bio"}}, {{"nope"}}, {nil, nil, nil}} {
+		err := tx.TableNew(&TableDef{
+			Name: "bad", Cols: []string{"id", "city", "age", "bio"}, Types: []uint32{TypeInt64, TypeBytes, TypeInt64, TypeBytes},
+			PKeys: 1, Indexes: [][]string{{"city", "age"}}, Include: include,
+		})
+		is.Error(t, err)
+	}
+}
+
+func TestPartialIndex(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	deleted := Cond{Col: "deleted", Cmp: "==", Val: Value{Type: TypeInt64}}
+	tt.create(&TableDef{
+		Name:    "docs",
+		Cols:    []string{"id", "owner", "deleted", "body"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"owner"}},
+		Where:   [][]Cond{{deleted}},
+	})
+	for i := range 10 {
+		rec := Record{}
+		rec.AddInt64("id", int64(i)).AddStr("owner", []byte{'a' + byte(i%2)}).AddInt64("deleted", int64(i/5)).AddStr("body", nil)
+		is.True(t, tt.add("docs", rec))
+	}
+	// Moving a row out of the predicate removes its entry; moving one in
+	// adds it.
+	rec := Record{}
+	rec.AddInt64("id", 2).AddStr("owner", []byte("a")).AddInt64("deleted", 1).AddStr("body", nil)
+	is.False(t, tt.add("docs", rec))
+	rec = Record{}
+	rec.AddInt64("id", 9).AddStr("owner", []byte("b")).AddInt64("deleted", 0).AddStr("body", nil)
+	is.False(t, tt.add("docs", rec))
+	is.True(t, tt.del("docs", *(&Record{}).AddInt64("id", 0)))
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	entries := 0
+	tdef := tx.TableDef("docs")
+	for it := tx.kvr.Seek(binary.BigEndian.AppendUint32(nil, tdef.IndexPrefixes[0]), btree.CmpGE); it.Valid(); it.Next() {
+		if key, _ := it.Deref(); binary.BigEndian.Uint32(key) == tdef.IndexPrefixes[0] {
+			entries++
+		}
+	}
+	is.Equal(t, 4, entries) // 1, 3, 4, 9
+
+	owner := *(&Record{}).AddStr("owner", []byte("b"))
+	ids := func(sc Scanner) (ids []int64) {
+		is.NoError(t, tx.Scan("docs", &sc))
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids
+	}
+	is.Equal(t, []int64{1, 3, 9}, ids(Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: owner, Key2: owner, Where: []Cond{deleted}}))
+	// Without the predicate, no index serves the scan.
+	sc := Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: owner, Key2: owner}
+	is.ErrorContains(t, tx.Scan("docs", &sc), "no index")
+	// Where filters any scan.
+	is.Equal(t, []int64{2, 5, 6, 7, 8}, ids(Scanner{Cmp1: btree.CmpGE, Where: []Cond{{Col: "deleted", Cmp: "!=", Val: Value{Type: TypeInt64}}}}))
+
+	for _, where := range [][][]Cond{
+		{{{Col: "nope", Cmp: "==", Val: Value{Type: TypeInt64}}}},
+		{{{Col: "deleted", Cmp: "==", Val: Value{Type: TypeBytes}}}},
+		{{{Col: "deleted", Cmp: "=~", Val: Value{Type: TypeInt64}}}},
+		{{deleted}, {deleted}},
+	} {
+		err := tx.TableNew(&TableDef{
+			Name: "bad", Cols: []string{"id", "owner", "deleted"}, Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+			PKeys: 1, Indexes: [][]string{{"owner"}}, Where: where,
+		})
+		is.Error(t, err)
+	}
+}
+
 func TestTableScan(t *testing.T) {
 	tt := newTableTester()
 	tdef := &TableDef{
@@ -455,3 +894,389 @@ func TestTableIndex(t *testing.T) {
 
 	tt.dispose()
 }
+
+func TestScannerFilter(t *testing.T) {
+	tt := newTableTester()
+	tdef := &TableDef{
+		Name:    "tbl_test",
+		Cols:    []string{"k", "v", "s"},
+		Types:   []uint32{TypeInt64, TypeInt64, TypeBytes},
+		PKeys:   1,
+		Indexes: [][]string{{"v"}},
+	}
+	tt.create(tdef)
+	for i := int64(0); i < 20; i++ {
+		rec := Record{}
+		rec.AddInt64("k", i).AddInt64("v", i%5).AddStr("s", []byte("x"))
+		tt.add("tbl_test", rec)
+	}
+
+	collect := func(sc Scanner) []int64 {
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		defer tt.db.Abort(&tx)
+		is.Nil(t, tx.Scan("tbl_test", &sc))
+		var out []int64
+		var rec Record
+		for ; sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			out = append(out, rec.Get("k").I64)
+		}
+		return out
+	}
+	odd := func(rec Record) bool { return rec.Get("v").I64%2 == 1 }
+
+	// primary key range, both directions
+	lo := *(&Record{}).AddInt64("k", 3)
+	hi := *(&Record{}).AddInt64("k", 12)
+	got := collect(Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: lo, Key2: hi, Filter: odd})
+	is.Equal(t, []int64{3, 6, 8, 11}, got)
+	got = collect(Scanner{Cmp1: btree.CmpLE, Cmp2: btree.CmpGE, Key1: hi, Key2: lo, Filter: odd})
+	is.Equal(t, []int64{11, 8, 6, 3}, got)
+
+	// secondary index: the filter sees the full row
+	v := *(&Record{}).AddInt64("v", 3)
+	got = collect(Scanner{
+		Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: v, Key2: v,
+		Filter: func(rec Record) bool { return rec.Get("k").I64 > 10 },
+	})
+	is.Equal(t, []int64{13, 18}, got)
+
+	// nothing matches
+	got = collect(Scanner{Cmp1: btree.CmpGE, Filter: func(Record) bool { return false }})
+	is.Empty(t, got)
+
+	tt.dispose()
+}
+
+func TestScannerLimitOffset(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}},
+	})
+	for i := range 30 {
+		tt.add("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("tag", fmt.Appendf(nil, "t%d", i%2)).AddInt64("v", int64(i)))
+	}
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	collect := func(sc Scanner) []int64 {
+		t.Helper()
+		is.NoError(t, r.Scan("t", &sc))
+		var ids []int64
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids
+	}
+
+	is.Equal(t, []int64{10, 11, 12}, collect(Scanner{Cmp1: btree.CmpGE, Offset: 10, Limit: 3}))
+	is.Equal(t, []int64{27, 26}, collect(Scanner{Cmp1: btree.CmpLE, Offset: 2, Limit: 2}))
+	is.Equal(t, []int64{28, 29}, collect(Scanner{Cmp1: btree.CmpGE, Offset: 28, Limit: 5}))
+	is.Empty(t, collect(Scanner{Cmp1: btree.CmpGE, Offset: 30}))
+	is.Len(t, collect(Scanner{Cmp1: btree.CmpGE, Limit: 0}), 30)
+
+	// Through an index, and with a filter, which sees the skipped rows.
+	tag := *(&Record{}).AddStr("tag", []byte("t1"))
+	is.Equal(t, []int64{9, 11}, collect(Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: tag, Key2: tag, Offset: 4, Limit: 2}))
+	seen := 0
+	filter := func(rec Record) bool { seen++; return rec.Get("v").I64%3 == 0 }
+	is.Equal(t, []int64{9, 12}, collect(Scanner{Cmp1: btree.CmpGE, Filter: filter, Offset: 3, Limit: 2}))
+	is.Equal(t, 13, seen) // rows 0 to 12
+}
+
+func TestFirstLast(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	for _, name := range []string{"a", "b", "c"} {
+		tt.create(&TableDef{
+			Name:    name,
+			Cols:    []string{"ts", "v", "n"},
+			Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+			PKeys:   1,
+			Indexes: [][]string{{"v"}},
+			Desc:    map[string]bool{"ts": name == "c"},
+		})
+	}
+	var rec Record
+	ok, err := tt.db.First("b", &rec)
+	is.NoError(t, err)
+	is.False(t, ok)
+	ok, err = tt.db.Last("b", &rec)
+	is.NoError(t, err)
+	is.False(t, ok)
+	_, err = tt.db.First("nope", &rec)
+	is.Error(t, err)
+
+	for i := range 50 {
+		for _, name := range []string{"a", "b", "c"} {
+			tt.add(name, *(&Record{}).AddInt64("ts", int64(i*7%50)).AddStr("v", []byte(name)).AddInt64("n", 0))
+		}
+	}
+	edges := func(table string) (first, last int64) {
+		var rec Record
+		ok, err := tt.db.First(table, &rec)
+		is.NoError(t, err)
+		is.True(t, ok)
+		first = rec.Get("ts").I64
+		ok, err = tt.db.Last(table, &rec)
+		is.NoError(t, err)
+		is.True(t, ok)
+		return first, rec.Get("ts").I64
+	}
+	first, last := edges("b")
+	is.Equal(t, []int64{0, 49}, []int64{first, last})
+	first, last = edges("c") // newest first
+	is.Equal(t, []int64{49, 0}, []int64{first, last})
+
+	tt.del("b", *(&Record{}).AddInt64("ts", 0))
+	tt.del("b", *(&Record{}).AddInt64("ts", 49))
+	first, last = edges("b")
+	is.Equal(t, []int64{1, 48}, []int64{first, last})
+}
+
+func TestScannerPage(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}},
+	})
+	row := func(id int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("tag", fmt.Appendf(nil, "t%d", id%3)).AddInt64("v", 0)
+	}
+	pk := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+	for i := range 50 {
+		tt.add("t", row(int64(i*2)))
+	}
+
+	// page reads one page of sc from the last commit.
+	page := func(sc Scanner, token string) ([]int64, string) {
+		t.Helper()
+		r := DBReader{}
+		tt.db.BeginRead(&r)
+		defer tt.db.EndRead(&r)
+		is.NoError(t, r.Scan("t", &sc))
+		rows, next, err := sc.Page(20, token)
+		is.NoError(t, err)
+		var ids []int64
+		for _, rec := range rows {
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids, next
+	}
+
+	// Rows written between pages behind the cursor are not seen, those
+	// ahead of it are, and none is seen twice.
+	ids, token := page(Scanner{Cmp1: btree.CmpGE}, "")
+	is.Len(t, ids, 20)
+	is.Equal(t, int64(38), ids[19])
+	tt.add("t", row(1))   // behind
+	tt.add("t", row(39))  // ahead
+	tt.del("t", pk(40))   // ahead
+	tt.del("t", pk(38))   // the last row returned
+	tt.add("t", row(101)) // at the end
+	var all []int64
+	all = append(all, ids...)
+	for token != "" {
+		ids, token = page(Scanner{Cmp1: btree.CmpGE}, token)
+		all = append(all, ids...)
+	}
+	is.Len(t, all, 51)
+	is.True(t, slices.IsSorted(all))
+	is.Equal(t, []int64{38, 39, 42}, all[19:22])
+	is.Equal(t, int64(101), all[50])
+
+	// Backwards over a range of an index.
+	sc := Scanner{Cmp1: btree.CmpLE, Cmp2: btree.CmpGE, Key1: *(&Record{}).AddStr("tag", []byte("t1")), Key2: *(&Record{}).AddStr("tag", []byte("t0"))}
+	all, token = nil, ""
+	for {
+		ids, token = page(sc, token)
+		all = append(all, ids...)
+		if token == "" {
+			break
+		}
+	}
+	var want []int64
+	for _, tag := range []int64{1, 0} {
+		for id := int64(101); id >= 0; id-- {
+			if id%3 == tag && (id%2 == 0 && id < 100 && id != 38 && id != 40 || id == 1 || id == 39 || id == 101) {
+				want = append(want, id)
+			}
+		}
+	}
+	is.Equal(t, want, all)
+
+	// Tokens only resume the range they came from.
+	r := DBReader{}
+	tt.db.BeginRead(&r)
+	defer tt.db.EndRead(&r)
+	sc = Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: pk(0), Key2: pk(20)}
+	is.NoError(t, r.Scan("t", &sc))
+	_, next, err := sc.Page(5, "")
+	is.NoError(t, err)
+	for _, bad := range []string{"!", "AAAA"} {
+		_, _, err = sc.Page(5, bad)
+		is.Error(t, err, bad)
+	}
+	other := Scanner{Cmp1: btree.CmpGE, Key1: pk(30)}
+	is.NoError(t, r.Scan("t", &other))
+	_, _, err = other.Page(5, next)
+	is.Error(t, err)
+	_, _, err = sc.Page(0, "")
+	is.Error(t, err)
+}
+
+func TestTableQuota(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	// Each row below is 12 key bytes (prefix + int64) + 21 value bytes.
+	tt.create(&TableDef{
+		Name:  "tenant",
+		Cols:  []string{"id", "v"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+		Quota: 100,
+	})
+	var events []QuotaEvent
+	tt.db.OnQuota = func(ev QuotaEvent) { events = append(events, ev) }
+
+	payload := []byte("xxxxxxxxxxxxxxxxxxxx")
+	put := func(id int64, v []byte) error {
+		tx := DBTX{}
+		tt.db.Begin(&tx)
+		_, err := tx.Upsert("tenant", *(&Record{}).AddInt64("id", id).AddStr("v", v))
+		if err != nil {
+			tt.db.Abort(&tx)
+			return err
+		}
+		return tt.db.Commit(&tx)
+	}
+	usage := func() int64 {
+		tx := DBReader{}
+		tt.db.BeginRead(&tx)
+		defer tt.db.EndRead(&tx)
+		n, err := tx.Usage("tenant")
+		is.NoError(t, err)
+		return n
+	}
+
+	is.NoError(t, put(1, payload))
+	is.NoError(t, put(2, payload))
+	is.Equal(t, int64(66), usage())
+	is.Empty(t, events)
+
+	is.NoError(t, put(3, payload))
+	is.Equal(t, int64(99), usage())
+	is.Equal(t, []QuotaEvent{{"tenant", 99, 100}}, events)
+
+	err := put(4, payload)
+	is.ErrorIs(t, err, ErrQuotaExceeded)
+	is.Equal(t, int64(99), usage())
+
+	// Overwriting with a smaller value frees space.
+	is.NoError(t, put(3, nil))
+	is.Equal(t, int64(79), usage())
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	deleted, err := tx.Delete("tenant", *(&Record{}).AddInt64("id", 1))
+	is.NoError(t, err)
+	is.True(t, deleted)
+	is.NoError(t, tt.db.Commit(&tx))
+	is.Equal(t, int64(46), usage())
+	is.NoError(t, put(4, payload))
+}
+
+// TestKeyFormatGolden pins the order-preserving row encoding: a big-endian
+// table prefix, int64 biased by 1<<63 in big-endian, float64 bits with the
+// sign bit set (positive) or all bits flipped (negative), and strings escaped
+// and null-terminated. Changing it requires a new kv format version.
+func TestKeyFormatGolden(t *testing.T) {
+	key := encodeKey(nil, 100, []Value{
+		{Type: TypeInt64, I64: -1},
+		{Type: TypeBytes, Str: []byte("a\x00\x01")},
+		{Type: TypeBytes, Str: []byte("\xff")},
+		{Type: TypeFloat64, F64: 1.5},
+		{Type: TypeFloat64, F64: -1.5},
+	})
+	is.Equal(t, "00000064"+ // prefix
+		"7fffffffffffffff"+ // -1
+		"610101010200"+ // "a\x00\x01"
+		"feff00"+ // "\xff"
+		"bff8000000000000"+ // 1.5
+		"4007ffffffffffff", // -1.5
+		fmt.Sprintf("%x", key))
+}
+
+func TestSchemaEvents(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	sub := tt.db.Events().Subscribe(16, events.SchemaChanged)
+	defer sub.Close()
+
+	tdef := func() *TableDef {
+		return &TableDef{
+			Name:  "tbl_test",
+			Cols:  []string{"k", "v"},
+			Types: []uint32{TypeInt64, TypeBytes},
+			PKeys: 1,
+		}
+	}
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	is.NoError(t, tx.TableNew(tdef()))
+	tt.db.Abort(&tx)
+	is.Empty(t, sub.C) // nothing until commit
+
+	tt.create(tdef())
+	ev := <-sub.C
+	is.Equal(t, events.SchemaChanged, ev.Kind)
+	is.Equal(t, "tbl_test", ev.Table)
+	tt.add("tbl_test", *(&Record{}).AddInt64("k", 1).AddStr("v", []byte("x")))
+	is.Empty(t, sub.C)
+}
+
+func TestBeginReadAt(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "versions.db"), KeepVersions: 2}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	set := func(v string) {
+		tx := DBTX{}
+		db.Begin(&tx)
+		_, err := tx.Upsert("t", *(&Record{}).AddInt64("k", 1).AddStr("v", []byte(v)))
+		is.NoError(t, err)
+		is.NoError(t, db.Commit(&tx))
+	}
+	tx := DBTX{}
+	db.Begin(&tx)
+	is.NoError(t, tx.TableNew(&TableDef{Name: "t", Cols: []string{"k", "v"}, Types: []uint32{TypeInt64, TypeBytes}, PKeys: 1}))
+	is.NoError(t, db.Commit(&tx))
+
+	set("a")
+	r := DBReader{}
+	db.BeginRead(&r)
+	version := r.Version()
+	db.EndRead(&r)
+	set("b")
+	is.NoError(t, db.BeginReadAt(&r, version))
+	rec := *(&Record{}).AddInt64("k", 1)
+	ok, err := r.Get("t", &rec)
+	is.True(t, ok)
+	is.NoError(t, err)
+	is.Equal(t, []byte("a"), rec.Get("v").Str)
+	db.EndRead(&r)
+
+	set("c")
+	is.ErrorIs(t, db.BeginReadAt(&r, version), kv.ErrVersionGone)
+}
@@ -0,0 +1,134 @@
+package tables
+
+import (
+	"fmt"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Row expiry
+// ---------------------------------------------------------------------------
+
+// The rows of a table created with TableDef.Expires may be given a deadline
+// (DBTX.ExpireAt, or TableDef.TTL on insert). Deadlines live in the @ttl
+// internal table, keyed by table and encoded primary key, with a secondary
+// index ordered by deadline for the sweeper to walk. A row past its
+// deadline reads as absent: Get misses it and scans skip it. It is deleted
+// lazily, by the next write to it, or by the sweeper.
+
+// tdefTTL stores the deadline of every expiring row, in Unix nanoseconds.
+var tdefTTL = &TableDef{
+	Prefix:        5,
+	Name:          "@ttl",
+	Types:         []uint32{TypeBytes, TypeBytes, TypeInt64},
+	Cols:          []string{"table", "key", "deadline"},
+	PKeys:         2,
+	Indexes:       [][]string{{"deadline", "table", "key"}},
+	IndexPrefixes: []uint32{6},
+}
+
+func ttlKey(tdef *TableDef, pk []Value) *Record {
+	key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], pk)
+	return (&Record{}).AddStr("table", []byte(tdef.Name)).AddStr("key", key)
+}
+
+// rowDeadline returns the deadline of the row with primary key pk, or 0 if
+// it has none.
+func rowDeadline(tx *DBReader, tdef *TableDef, pk []Value) int64 {
+	rec := ttlKey(tdef, pk)
+	ok, err := dbGet(tx, tdefTTL, rec)
+	assert(err == nil)
+	if !ok {
+		return 0
+	}
+	return rec.Get("deadline").I64
+}
+
+// expired reports whether a deadline has passed at now.
+func expired(deadline, now int64) bool {
+	return deadline != 0 && deadline <= now
+}
+
+// setRowDeadline sets the deadline of a row, or clears it if deadline is 0.
+func setRowDeadline(tx *DBTX, tdef *TableDef, pk []Value, deadline int64) error {
+	rec := ttlKey(tdef, pk)
+	if deadline == 0 {
+		_, err := dbDelete(tx, tdefTTL, *rec)
+		return err
+	}
+	return dbUpdate(tx, tdefTTL, &DBSetReq{Record: *rec.AddInt64("deadline", deadline)})
+}
+
+// reclaimExpired deletes the row with primary key pk if it is past its
+// deadline, before a write to it.
+func reclaimExpired(tx *DBTX, tdef *TableDef, pk []Value) error {
+	if !expired(rowDeadline(&tx.DBReader, tdef, pk), time.Now().UnixNano()) {
+		return nil
+	}
+	_, err := dbDelete(tx, tdef, Record{tdef.Cols[:tdef.PKeys], pk})
+	return err
+}
+
+// ExpireAt gives a row of table, a table with Expires set, the deadline at,
+// after which it reads as absent and is deleted; a zero at clears it. rec
+// holds the primary key. Returns false if the row does not exist.
+func (tx *DBTX) ExpireAt(table string, rec Record, at time.Time) (bool, error) {
+	tdef := getTableDef(&tx.DBReader, table)
+	if tdef == nil {
+		return false, fmt.Errorf("table not found: %s", table)
+	}
+	if !tdef.Expires {
+		return false, fmt.Errorf("table does not expire rows: %s", table)
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return false, err
+	}
+	pk := values[:tdef.PKeys]
+	if err := reclaimExpired(tx, tdef, pk); err != nil {
+		return false, err
+	}
+	row := Record{tdef.Cols[:tdef.PKeys], pk}
+	if ok, err := dbGet(&tx.DBReader, tdef, &row); !ok || err != nil {
+		return false, err
+	}
+	deadline := int64(0)
+	if !at.IsZero() {
+		deadline = at.UnixNano()
+	}
+	return true, setRowDeadline(tx, tdef, pk, deadline)
+}
+
+// Expire gives a row of table a deadline ttl from now; see ExpireAt.
+func (tx *DBTX) Expire(table string, rec Record, ttl time.Duration) (bool, error) {
+	return tx.ExpireAt(table, rec, time.Now().Add(ttl))
+}
+
+// Deadline returns the deadline of a row of table, the zero time if it has
+// none. rec holds the primary key. Returns false if the row does not exist
+// or has expired.
+func (tx *DBReader) Deadline(table string, rec Record) (time.Time, bool, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return time.Time{}, false, fmt.Errorf("table not found: %s", table)
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	row := Record{tdef.Cols[:tdef.PKeys], values[:tdef.PKeys]}
+	if ok, err := dbGet(tx, tdef, &row); !ok || err != nil {
+		return time.Time{}, false, err
+	}
+	if !tdef.Expires {
+		return time.Time{}, true, nil
+	}
+	deadline := rowDeadline(tx, tdef, values[:tdef.PKeys])
+	switch {
+	case deadline == 0:
+		return time.Time{}, true, nil
+	case expired(deadline, time.Now().UnixNano()):
+		return time.Time{}, false, nil
+	}
+	return time.Unix(0, deadline), true, nil
+}
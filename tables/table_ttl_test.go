@@ -0,0 +1,170 @@
+package tables
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestExpiry(t *testing.T) {
+	db := &DB{Path: filepath.Join(t.TempDir(), "ttl.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	update := func(fn func(tx *DBTX) error) error {
+		tx := DBTX{}
+		db.Begin(&tx)
+		if err := fn(&tx); err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+	row := func(id, k int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddInt64("k", k).AddStr("v", []byte("v"))
+	}
+	pk := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+	scan := func(sc Scanner) []int64 {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		is.NoError(t, r.Scan("t", &sc))
+		var ids []int64
+		for ; sc.Valid(); sc.Next() {
+			var rec Record
+			sc.Deref(&rec)
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids
+	}
+	byK := func(k int64) Scanner {
+		key := *(&Record{}).AddInt64("k", k)
+		return Scanner{Cmp1: btree.CmpGE, Cmp2: btree.CmpLE, Key1: key, Key2: key}
+	}
+	get := func(id int64) bool {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		rec := pk(id)
+		ok, err := r.Get("t", &rec)
+		is.NoError(t, err)
+		return ok
+	}
+	deadline := func(table string, id int64) (time.Time, bool) {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		at, ok, err := r.Deadline(table, pk(id))
+		is.NoError(t, err)
+		return at, ok
+	}
+
+	is.NoError(t, update(func(tx *DBTX) error {
+		def := func(name string, ttl time.Duration) *TableDef {
+			return &TableDef{
+				Name:    name,
+				Cols:    []string{"id", "k", "v"},
+				Types:   []uint32{TypeInt64, TypeInt64, TypeBytes},
+				PKeys:   1,
+				Indexes: [][]string{{"k"}},
+				Expires: true,
+				TTL:     ttl,
+			}
+		}
+		if err := tx.TableNew(def("t", 0)); err != nil {
+			return err
+		}
+		if err := tx.TableNew(def("cache", time.Hour)); err != nil {
+			return err
+		}
+		return tx.TableNew(&TableDef{Name: "plain", Cols: []string{"id", "v"}, Types: []uint32{TypeInt64, TypeBytes}, PKeys: 1})
+	}))
+	is.NoError(t, update(func(tx *DBTX) error {
+		for id := range int64(4) {
+			if _, err := tx.Insert("t", row(id, 7)); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Insert("cache", row(1, 1))
+		return err
+	}))
+
+	// A row past its deadline is gone for Get, scans and index scans.
+	is.NoError(t, update(func(tx *DBTX) error {
+		ok, err := tx.ExpireAt("t", pk(1), time.Now().Add(-time.Second))
+		is.True(t, ok)
+		if err != nil {
+			return err
+		}
+		ok, err = tx.Expire("t", pk(2), time.Hour)
+		is.True(t, ok)
+		if err != nil {
+			return err
+		}
+		ok, err = tx.Expire("t", pk(9), time.Hour)
+		is.False(t, ok)
+		return err
+	}))
+	is.False(t, get(1))
+	is.True(t, get(2))
+	is.Equal(t, []int64{0, 2, 3}, scan(FullScan()))
+	is.Equal(t, []int64{0, 2, 3}, scan(byK(7)))
+	at, ok := deadline("t", 2)
+	is.True(t, ok)
+	is.WithinDuration(t, time.Now().Add(time.Hour), at, time.Minute)
+	_, ok = deadline("t", 1)
+	is.False(t, ok)
+	at, ok = deadline("t", 0)
+	is.True(t, ok && at.IsZero())
+	at, ok = deadline("cache", 1)
+	is.True(t, ok)
+	is.WithinDuration(t, time.Now().Add(time.Hour), at, time.Minute)
+
+	// The next write reclaims it: an insert finds the key free, and a
+	// delete reports it absent.
+	is.NoError(t, update(func(tx *DBTX) error {
+		added, err := tx.Insert("t", row(1, 8))
+		is.True(t, added)
+		return err
+	}))
+	is.Equal(t, []int64{0, 2, 3}, scan(byK(7)))
+	is.Equal(t, []int64{1}, scan(byK(8)))
+	at, ok = deadline("t", 1)
+	is.True(t, ok && at.IsZero())
+	is.NoError(t, update(func(tx *DBTX) error {
+		if _, err := tx.ExpireAt("t", pk(3), time.Now().Add(-time.Second)); err != nil {
+			return err
+		}
+		deleted, err := tx.Delete("t", pk(3))
+		is.False(t, deleted)
+		return err
+	}))
+	is.Equal(t, []int64{0, 1, 2}, scan(FullScan()))
+
+	// Clearing a deadline keeps the row.
+	is.NoError(t, update(func(tx *DBTX) error {
+		_, err := tx.ExpireAt("t", pk(2), time.Time{})
+		return err
+	}))
+	at, ok = deadline("t", 2)
+	is.True(t, ok && at.IsZero())
+
+	is.ErrorContains(t, update(func(tx *DBTX) error {
+		_, err := tx.Expire("plain", pk(1), time.Hour)
+		return err
+	}), "does not expire")
+
+	r := DBReader{}
+	db.BeginRead(&r)
+	sc := Scanner{Cmp1: btree.CmpGE}
+	is.NoError(t, dbScan(&r, tdefTTL, &sc))
+	n := 0
+	for ; sc.Valid(); sc.Next() {
+		n++
+	}
+	db.EndRead(&r)
+	is.Equal(t, 1, n) // only the cache row has a deadline left
+}
@@ -0,0 +1,265 @@
+package tables
+
+import (
+	"fmt"
+	"iter"
+	"reflect"
+	"unsafe"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Typed table API
+// ---------------------------------------------------------------------------
+
+// Codec converts between a Go value and a table Record.
+// Encode must produce every column of the table; Decode receives a full row
+// as returned by Get or Scan.
+type Codec[T any] struct {
+	Encode func(v T) Record
+	Decode func(rec Record) (T, error)
+}
+
+// Table is a typed view of a single table. Each call runs in its own
+// transaction on the underlying DB, and writes that lose an OCC conflict are
+// retried through DB.Update, so a Table is a convenience for simple CRUD;
+// use DB.Update directly when several operations must be atomic.
+type Table[T any] struct {
+	db    *DB
+	name  string
+	codec Codec[T]
+}
+
+// NewTable returns a typed view of the named table using codec for row
+// conversion. The table must already exist.
+func NewTable[T any](db *DB, name string, codec Codec[T]) (*Table[T], error) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	tdef := getTableDef(&tx, name)
+	db.EndRead(&tx)
+	if tdef == nil {
+		return nil, fmt.Errorf("table not found: %s", name)
+	}
+	return &Table[T]{db: db, name: name, codec: codec}, nil
+}
+
+// Insert adds v as a new row. Returns (false, nil) if the primary key
+// already exists.
+func (t *Table[T]) Insert(v T) (bool, error) {
+	var added bool
+	err := t.db.Update(func(tx *DBTX) (err error) {
+		added, err = tx.Insert(t.name, t.codec.Encode(v))
+		return err
+	})
+	return added, err
+}
+
+// Upsert inserts v or replaces the row with the same primary key.
+func (t *Table[T]) Upsert(v T) (bool, error) {
+	var added bool
+	err := t.db.Update(func(tx *DBTX) (err error) {
+		added, err = tx.Upsert(t.name, t.codec.Encode(v))
+		return err
+	})
+	return added, err
+}
+
+// Get fetches the row whose primary key matches pk. pk must contain the
+// primary-key columns.
+func (t *Table[T]) Get(pk Record) (T, bool, error) {
+	var zero T
+	tx := DBReader{}
+	t.db.BeginRead(&tx)
+	defer t.db.EndRead(&tx)
+
+	ok, err := tx.Get(t.name, &pk)
+	if err != nil || !ok {
+		return zero, false, err
+	}
+	v, err := t.codec.Decode(pk)
+	if err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+// Delete removes the row whose primary key matches pk.
+func (t *Table[T]) Delete(pk Record) (bool, error) {
+	var deleted bool
+	err := t.db.Update(func(tx *DBTX) (err error) {
+		deleted, err = tx.Delete(t.name, pk)
+		return err
+	})
+	return deleted, err
+}
+
+// Scan returns an iterator over the rows selected by sc. Ranging over it
+// opens a read snapshot, kept until the loop finishes or breaks, and
+// yields the rows with a nil error. An invalid range, or a row that fails
+// to decode, is yielded as an error instead, after which the iteration
+// stops.
+//
+// Unlike the iter.Seq[T] first planned for it, Scan yields an error with
+// each row: an iter.Seq has nowhere to report a failed scan, which would
+// then look like the end of the range.
+func (t *Table[T]) Scan(sc Scanner) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		tx := DBReader{}
+		t.db.BeginRead(&tx)
+		defer t.db.EndRead(&tx)
+
+		req := sc
+		if err := tx.Scan(t.name, &req); err != nil {
+			yield(zero, err)
+			return
+		}
+		for ; req.Valid(); req.Next() {
+			var rec Record
+			req.Deref(&rec)
+			v, err := t.codec.Decode(rec)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Struct codec
+// ---------------------------------------------------------------------------
+
+// StructCodec derives a Codec for the struct type T. Exported fields are
+// mapped to columns by their `elk:"name"` tag, or by field name when the tag
+// is absent; a tag of "-" skips the field. Fields must be int64 (or another
+// signed integer kind), float64 / float32, UUID, or []byte / string.
+//
+// The struct layout is analysed once here, with reflection; per-row
+// conversion then reads and writes the fields at their offsets, without
+// reflection.
+func StructCodec[T any]() (Codec[T], error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return Codec[T]{}, fmt.Errorf("StructCodec: %s is not a struct", typ)
+	}
+
+	var fields []structField
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		col := f.Name
+		if tag, ok := f.Tag.Lookup("elk"); ok {
+			if tag == "-" {
+				continue
+			}
+			col = tag
+		}
+		kind := f.Type.Kind()
+		switch {
+		case kind >= reflect.Int && kind <= reflect.Int64:
+		case kind == reflect.Float32 || kind == reflect.Float64:
+		case kind == reflect.String:
+		case kind == reflect.Slice && f.Type.Elem().Kind() == reflect.Uint8:
+		case f.Type == reflect.TypeFor[UUID]():
+		default:
+			return Codec[T]{}, fmt.Errorf("StructCodec: unsupported field type %s for %s", f.Type, f.Name)
+		}
+		fields = append(fields, structField{col: col, offset: f.Offset, kind: kind})
+	}
+
+	encode := func(v T) Record {
+		base := unsafe.Pointer(&v)
+		rec := Record{}
+		for _, f := range fields {
+			f.encode(unsafe.Add(base, f.offset), &rec)
+		}
+		return rec
+	}
+	decode := func(rec Record) (T, error) {
+		var out T
+		base := unsafe.Pointer(&out)
+		for _, f := range fields {
+			v := rec.Get(f.col)
+			if v == nil {
+				return out, fmt.Errorf("StructCodec: missing column: %s", f.col)
+			}
+			f.decode(unsafe.Add(base, f.offset), v)
+		}
+		return out, nil
+	}
+	return Codec[T]{Encode: encode, Decode: decode}, nil
+}
+
+// structField is a field of a struct mapped by StructCodec. Its kind was
+// checked when the codec was built, which makes the pointer conversions
+// below safe; a named type has the memory layout of its kind.
+type structField struct {
+	col    string
+	offset uintptr
+	kind   reflect.Kind
+}
+
+// encode adds the field at p to rec.
+func (f structField) encode(p unsafe.Pointer, rec *Record) {
+	switch f.kind {
+	case reflect.Int:
+		rec.AddInt64(f.col, int64(*(*int)(p)))
+	case reflect.Int8:
+		rec.AddInt64(f.col, int64(*(*int8)(p)))
+	case reflect.Int16:
+		rec.AddInt64(f.col, int64(*(*int16)(p)))
+	case reflect.Int32:
+		rec.AddInt64(f.col, int64(*(*int32)(p)))
+	case reflect.Int64:
+		rec.AddInt64(f.col, *(*int64)(p))
+	case reflect.Float32:
+		rec.AddFloat64(f.col, float64(*(*float32)(p)))
+	case reflect.Float64:
+		rec.AddFloat64(f.col, *(*float64)(p))
+	case reflect.String:
+		rec.AddStr(f.col, []byte(*(*string)(p)))
+	case reflect.Slice:
+		rec.AddStr(f.col, *(*[]byte)(p))
+	case reflect.Array:
+		rec.AddUUID(f.col, *(*UUID)(p))
+	}
+}
+
+// decode sets the field at p from v.
+func (f structField) decode(p unsafe.Pointer, v *Value) {
+	switch f.kind {
+	case reflect.Int:
+		*(*int)(p) = int(v.I64)
+	case reflect.Int8:
+		*(*int8)(p) = int8(v.I64)
+	case reflect.Int16:
+		*(*int16)(p) = int16(v.I64)
+	case reflect.Int32:
+		*(*int32)(p) = int32(v.I64)
+	case reflect.Int64:
+		*(*int64)(p) = v.I64
+	case reflect.Float32:
+		*(*float32)(p) = float32(v.F64)
+	case reflect.Float64:
+		*(*float64)(p) = v.F64
+	case reflect.String:
+		*(*string)(p) = string(v.Str)
+	case reflect.Slice:
+		*(*[]byte)(p) = append([]byte(nil), v.Str...)
+	case reflect.Array:
+		*(*UUID)(p) = UUID(v.Str)
+	}
+}
+
+// FullScan is a convenience Scanner covering every row of a table in
+// ascending primary-key order.
+func FullScan() Scanner {
+	return Scanner{Cmp1: btree.CmpGE}
+}
@@ -0,0 +1,190 @@
+package tables
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+type typedUser struct {
+	ID   int64  `elk:"id"`
+	Name string `elk:"name"`
+	Age  int64  `elk:"age"`
+	Note string `elk:"-"`
+}
+
+func TestTypedTable(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "users",
+		Cols:  []string{"id", "name", "age"},
+		Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys: 1,
+	})
+
+	codec, err := StructCodec[typedUser]()
+	is.NoError(t, err)
+	users, err := NewTable(&tt.db, "users", codec)
+	is.NoError(t, err)
+
+	_, err = NewTable(&tt.db, "missing", codec)
+	is.Error(t, err)
+
+	for i := int64(1); i <= 5; i++ {
+		added, err := users.Insert(typedUser{ID: i, Name: fmt.Sprintf("u%d", i), Age: 20 + i})
+		is.NoError(t, err)
+		is.True(t, added)
+	}
+	added, err := users.Insert(typedUser{ID: 1, Name: "dup"})
+	is.NoError(t, err)
+	is.False(t, added)
+
+	got, ok, err := users.Get(*(&Record{}).AddInt64("id", 3))
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, typedUser{ID: 3, Name: "u3", Age: 23}, got)
+
+	_, ok, err = users.Get(*(&Record{}).AddInt64("id", 42))
+	is.NoError(t, err)
+	is.False(t, ok)
+
+	seq := users.Scan(Scanner{
+		Cmp1: btree.CmpGE, Cmp2: btree.CmpLE,
+		Key1: *(&Record{}).AddInt64("id", 2),
+		Key2: *(&Record{}).AddInt64("id", 4),
+	})
+	ids := []int64{}
+	for u, err := range seq {
+		is.NoError(t, err)
+		ids = append(ids, u.ID)
+	}
+	is.Equal(t, []int64{2, 3, 4}, ids)
+
+	n := 0
+	for _, err := range users.Scan(FullScan()) {
+		is.NoError(t, err)
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	is.Equal(t, 2, n)
+
+	// A bad range and a row that fails to decode are yielded as errors.
+	n = 0
+	for _, err := range users.Scan(Scanner{Cmp1: btree.CmpGE, Key1: *(&Record{}).AddInt64("age", 1)}) {
+		is.Error(t, err)
+		n++
+	}
+	is.Equal(t, 1, n)
+	broken, err := NewTable(&tt.db, "users", Codec[typedUser]{
+		Encode: codec.Encode,
+		Decode: func(rec Record) (typedUser, error) {
+			if rec.Get("id").I64 == 2 {
+				return typedUser{}, fmt.Errorf("bad row")
+			}
+			return codec.Decode(rec)
+		},
+	})
+	is.NoError(t, err)
+	ids = ids[:0]
+	var scanErr error
+	for u, err := range broken.Scan(FullScan()) {
+		if err != nil {
+			scanErr = err
+			continue
+		}
+		ids = append(ids, u.ID)
+	}
+	is.EqualError(t, scanErr, "bad row")
+	is.Equal(t, []int64{1}, ids)
+
+	deleted, err := users.Delete(*(&Record{}).AddInt64("id", 3))
+	is.NoError(t, err)
+	is.True(t, deleted)
+	_, ok, _ = users.Get(*(&Record{}).AddInt64("id", 3))
+	is.False(t, ok)
+
+	_, err = StructCodec[int]()
+	is.Error(t, err)
+}
+
+type typedAll struct {
+	I    int      `elk:"i"`
+	I8   int8     `elk:"i8"`
+	I16  int16    `elk:"i16"`
+	I32  int32    `elk:"i32"`
+	I64  typedID  `elk:"i64"`
+	F32  float32  `elk:"f32"`
+	F64  float64  `elk:"f64"`
+	S    string   `elk:"s"`
+	B    []byte   `elk:"b"`
+	U    UUID     `elk:"u"`
+	Skip chan int `elk:"-"`
+	priv int
+}
+
+type typedID int64
+
+func TestStructCodecKinds(t *testing.T) {
+	codec, err := StructCodec[typedAll]()
+	is.NoError(t, err)
+	in := typedAll{
+		I: -1, I8: -8, I16: 16, I32: -32, I64: 64, F32: 1.5, F64: -2.25,
+		S: "str", B: []byte{0, 1, 2}, U: NewUUIDv7(), priv: 7,
+	}
+	rec := codec.Encode(in)
+	is.Equal(t, []string{"i", "i8", "i16", "i32", "i64", "f32", "f64", "s", "b", "u"}, rec.Cols)
+	is.Equal(t, int64(-8), rec.Get("i8").I64)
+	is.Equal(t, 1.5, rec.Get("f32").F64)
+	is.Equal(t, TypeUUID, rec.Get("u").Type)
+
+	out, err := codec.Decode(rec)
+	is.NoError(t, err)
+	in.priv = 0
+	is.Equal(t, in, out)
+
+	// Decoded bytes do not alias the record.
+	rec.Get("b").Str[0] = 9
+	is.Equal(t, []byte{0, 1, 2}, out.B)
+
+	_, err = codec.Decode(*(&Record{}).AddInt64("i", 1))
+	is.ErrorContains(t, err, "missing column: i8")
+	_, err = StructCodec[struct{ M map[string]int }]()
+	is.ErrorContains(t, err, "unsupported field type")
+}
+
+func TestTypedTableConcurrentWrites(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "users",
+		Cols:  []string{"id", "name", "age"},
+		Types: []uint32{TypeInt64, TypeBytes, TypeInt64},
+		PKeys: 1,
+	})
+	codec, err := StructCodec[typedUser]()
+	is.NoError(t, err)
+	users, err := NewTable(&tt.db, "users", codec)
+	is.NoError(t, err)
+
+	// Writers of one row conflict with each other; each write is retried
+	// instead of failing with kv.ErrConflict.
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := range int64(writers) {
+		go func() {
+			_, err := users.Upsert(typedUser{ID: 1, Name: "w", Age: i})
+			errs <- err
+		}()
+	}
+	for range writers {
+		is.NoError(t, <-errs)
+	}
+	_, ok, err := users.Get(*(&Record{}).AddInt64("id", 1))
+	is.NoError(t, err)
+	is.True(t, ok)
+}
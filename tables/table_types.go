@@ -6,12 +6,19 @@ package tables
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/MHS-20/ElkDB/events"
 	"github.com/MHS-20/ElkDB/kv"
+	"github.com/MHS-20/ElkDB/throttle"
 )
 
 // ---------------------------------------------------------------------------
@@ -19,17 +26,81 @@ import (
 // ---------------------------------------------------------------------------
 
 // DB is the top-level relational database handle.
-// Open it with DB.Open, then create transactions with Begin / BeginRead.
+// Open it with DB.Open, then create transactions with Begin / BeginRead. A
+// DB is safe for concurrent use by multiple goroutines; a transaction is not.
 type DB struct {
 	Path string
+	// OnQuota, if set, is called after a commit that leaves a table with a
+	// Quota at or above QuotaWarnPercent of it.
+	OnQuota func(QuotaEvent)
+	// Segments holds the cold tier written by Archive; nil disables it.
+	Segments SegmentStore
+	// Maintenance, if set, paces background jobs (Backfill, Archive, Dump,
+	// Convert, Sweep and Compact) so that they do not starve foreground I/O.
+	Maintenance *throttle.Limiter
+	// Logger is handed to the underlying KV (see kv.KV.Logger); nil
+	// discards its messages.
+	Logger *slog.Logger
+	// WALArchive is handed to the underlying KV (see kv.KV.WALArchive);
+	// empty disables WAL archiving.
+	WALArchive string
+	// ReadOnly opens the underlying KV as a replication follower (see
+	// kv.KV.ReadOnly): commits fail and changes come through Apply.
+	ReadOnly bool
+	// Propose, if set, opens the underlying KV in logged mode (see
+	// kv.KV.Propose): commits are proposed to a replicated log and take
+	// effect through ApplyLogged.
+	Propose func(entry []byte) error
+	// ChangeLog, if positive, records the row changes of user tables in the
+	// @change internal table, keeping the last ChangeLog of them for
+	// DB.Changes. Changes made while it is 0 are not recorded.
+	ChangeLog int
+	// KeepVersions is handed to the underlying KV (see kv.KV.KeepVersions):
+	// the last KeepVersions versions stay readable through BeginReadAt.
+	KeepVersions int
+	// WriteStall and CommitTimeout are handed to the underlying KV (see
+	// kv.KV.WriteStall): commits that wait on a busy commit lock or a hung
+	// WAL write fail with kv.ErrTimeout.
+	WriteStall    time.Duration
+	CommitTimeout time.Duration
+	// MaxKeySize and MaxValSize are handed to the underlying KV (see
+	// kv.KV.MaxKeySize): the size limits of the KV pairs of a file Open
+	// creates, which bound primary keys and encoded rows.
+	MaxKeySize int
+	MaxValSize int
+	// SequenceBatch is the number of values SequenceNext reserves per write
+	// of @seq; 0 means DefaultSequenceBatch.
+	SequenceBatch int
 	// internals
-	kv     kv.KV
-	mu     sync.Mutex
-	tables map[string]*TableDef // cache of table definitions loaded from disk
+	kv      kv.KV
+	mu      sync.Mutex
+	tables  map[string]*TableDef  // cache of table definitions loaded from disk
+	indexes map[string][]IndexDef // cache of the @index catalog; see DBReader.Indexes
+	defs    struct {
+		changing int    // commits in progress that may change definitions
+		from     uint64 // the version the cached definitions were read at, or later
+	}
+	stats dbStats
+
+	authMu    sync.Mutex
+	authCache map[string][sha256.Size]byte // verified passwords; see Authenticate
+
+	seqMu sync.Mutex
+	seqs  map[string]*seqRange // reserved values; see SequenceNext
 }
 
 func (db *DB) Open() error {
 	db.kv.Path = db.Path
+	db.kv.Logger = db.Logger
+	db.kv.Maintenance = db.Maintenance
+	db.kv.WALArchive = db.WALArchive
+	db.kv.ReadOnly = db.ReadOnly
+	db.kv.Propose = db.Propose
+	db.kv.KeepVersions = db.KeepVersions
+	db.kv.WriteStall = db.WriteStall
+	db.kv.CommitTimeout = db.CommitTimeout
+	db.kv.MaxKeySize = db.MaxKeySize
+	db.kv.MaxValSize = db.MaxValSize
 	return db.kv.Open()
 }
 
@@ -37,6 +108,12 @@ func (db *DB) Close() {
 	db.kv.Close()
 }
 
+// Events returns the bus engine events are published on: those of the
+// underlying KV plus SchemaChanged for table definitions.
+func (db *DB) Events() *events.Bus {
+	return db.kv.Events()
+}
+
 // ---------------------------------------------------------------------------
 // Transaction types
 // ---------------------------------------------------------------------------
@@ -47,6 +124,8 @@ type DBReader struct {
 	db   *DB
 	kvr  kv.Reader    // snapshot; either a *kv.KVReader or the read face of a *kv.KVTX
 	kvtx *kv.KVReader // non-nil only for stand-alone read transactions (BeginRead)
+
+	ownDefs bool // the transaction changed the columns of a table: bypass the cache
 }
 
 // BeginRead opens a read-only transaction.
@@ -58,6 +137,25 @@ func (db *DB) BeginRead(tx *DBReader) {
 	tx.kvr = r
 }
 
+// BeginReadAt opens a read-only transaction on an earlier version kept by
+// KeepVersions; see kv.KV.BeginReadAt.
+func (db *DB) BeginReadAt(tx *DBReader, version uint64) error {
+	r := &kv.KVReader{}
+	if err := db.kv.BeginReadAt(r, version); err != nil {
+		return err
+	}
+	tx.db = db
+	tx.kvtx = r
+	tx.kvr = r
+	return nil
+}
+
+// Version returns the version a transaction opened by BeginRead or
+// BeginReadAt reads, for a later BeginReadAt.
+func (tx *DBReader) Version() uint64 {
+	return tx.kvtx.Version()
+}
+
 // EndRead closes a read-only transaction.
 func (db *DB) EndRead(tx *DBReader) {
 	db.kv.EndRead(tx.kvtx)
@@ -69,6 +167,14 @@ type DBTX struct {
 	db       *DB
 	kvw      kv.Writer // the underlying kv write transaction
 	DBReader           // embedded for the Reader methods; kvr is wired to kvw
+
+	deferred    []Check      // run by Commit before the kv commit
+	quotaEvents []QuotaEvent // delivered to DB.OnQuota after commit
+	schema      []string     // tables whose definition changed; published after commit
+
+	// User is recorded as the author of the changes to tables with
+	// TableDef.Audit set; the HTTP server sets it to the authenticated user.
+	User string
 }
 
 // Begin opens a read-write transaction.
@@ -85,8 +191,24 @@ func (db *DB) Begin(tx *DBTX) {
 }
 
 // Commit persists the transaction.
+// Deferred checks registered with DBTX.Defer run first; if one fails the
+// transaction is aborted and its error returned.
 func (db *DB) Commit(tx *DBTX) error {
-	return db.kv.Commit(tx.kvw.(*kv.KVTX))
+	if err := tx.runDeferred(); err != nil {
+		db.Abort(tx)
+		return err
+	}
+	if len(tx.schema) > 0 {
+		defer db.defsChanging()()
+	}
+	if err := db.kv.Commit(tx.kvw.(*kv.KVTX)); err != nil {
+		return err
+	}
+	db.fireQuotaEvents(tx.quotaEvents)
+	for _, name := range tx.schema {
+		db.kv.Events().Publish(events.Event{Kind: events.SchemaChanged, Table: name})
+	}
+	return nil
 }
 
 // Abort rolls back the transaction.
@@ -94,6 +216,29 @@ func (db *DB) Abort(tx *DBTX) {
 	db.kv.Abort(tx.kvw.(*kv.KVTX))
 }
 
+// Update runs fn in a read-write transaction and commits it. If fn returns
+// an error the transaction is aborted and the error returned. A commit that
+// loses a serialisation conflict (kv.ErrConflict) with a concurrent writer
+// is retried with a new transaction, calling fn again, up to kv.MaxRetries
+// times in all (see kv.Retry); fn must therefore not keep anything from an
+// attempt that failed.
+func (db *DB) Update(fn func(tx *DBTX) error) error {
+	var fnErr error
+	err := kv.Retry(func() error {
+		tx := DBTX{}
+		db.Begin(&tx)
+		if fnErr = fn(&tx); fnErr != nil {
+			db.Abort(&tx)
+			return nil
+		}
+		return db.Commit(&tx)
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
 // ---------------------------------------------------------------------------
 // Schema types
 // ---------------------------------------------------------------------------
@@ -106,9 +251,57 @@ type TableDef struct {
 	Cols    []string   // column names
 	PKeys   int        // the first PKeys columns form the primary key
 	Indexes [][]string // each entry is an ordered list of column names
+	// Include[i] lists non-key columns whose values the entries of
+	// Indexes[i] also hold, so that a scan of the index wanting no other
+	// columns (see Scanner.Cols) never reads the primary rows.
+	Include [][]string `json:",omitempty"`
+	// Where[i], if not empty, makes Indexes[i] a partial index: only the
+	// rows that satisfy every condition have an entry. A scan uses it only
+	// when its Scanner.Where contains all of them.
+	Where [][]Cond `json:",omitempty"`
+	// Unique[i] makes Indexes[i] a unique index: no two rows may hold the
+	// same values in its columns other than the primary-key ones, which
+	// must all follow them.
+	Unique []bool `json:",omitempty"`
+	Quota  int64  `json:",omitempty"` // max bytes of row data (keys + values); 0 = unlimited
+	// Expires lets rows be given a deadline (see DBTX.ExpireAt), and TTL,
+	// which implies it, gives one to every row inserted, TTL after.
+	Expires bool          `json:",omitempty"`
+	TTL     time.Duration `json:",omitempty"`
+	// Versioned gives every row a version, advanced by each write that
+	// changes it, for DBTX.UpdateIfVersion.
+	Versioned bool `json:",omitempty"`
+	// SoftDelete makes Delete mark rows deleted rather than remove them;
+	// see DB.Purge.
+	SoftDelete bool `json:",omitempty"`
+	// Audit records who changed which row, and when, in the @audit table.
+	Audit bool `json:",omitempty"`
+	// Collate maps bytes columns to the collation (CollateBinary,
+	// CollateNoCase or one given to RegisterCollation) that orders them in
+	// the primary key and indexes. Rows stay distinct when their values
+	// collate equal, but a scan bound on a collated column matches every
+	// value that collates equal to it.
+	Collate map[string]string `json:",omitempty"`
+	// Desc lists the columns that sort in descending order in the primary
+	// key and indexes. Scans follow the key order, so a scan from a value
+	// with CmpGE walks towards smaller values of a descending column.
+	Desc map[string]bool `json:",omitempty"`
+	// Codec names the RowCodec (CodecFlat or one given to RegisterRowCodec)
+	// that encodes the non-key values of the rows; empty for CodecDefault.
+	Codec string `json:",omitempty"`
+	// Document makes a document table: its only column besides the primary
+	// key holds a CBOR document (see DBTX.DocPut and DBReader.DocFind).
+	Document bool `json:",omitempty"`
 	// auto-assigned by TableNew
 	Prefix        uint32   // B-tree key prefix for the primary key
 	IndexPrefixes []uint32 // B-tree key prefixes for each secondary index
+	// Building[i] is set while IndexAdd or Reindex builds Indexes[i]:
+	// writes maintain the index, but no scan uses it.
+	Building []bool `json:",omitempty"`
+	// Rewrite is the definition the table changes to while a column change
+	// (DB.ColumnDrop, DB.ColumnType) copies its rows: writes maintain the
+	// new layout too.
+	Rewrite *TableDef `json:",omitempty"`
 }
 
 // Column type constants.
@@ -116,12 +309,15 @@ const (
 	TypeUnknown = uint32(0)
 	TypeBytes   = uint32(1)
 	TypeInt64   = uint32(2)
+	TypeFloat64 = uint32(3)
+	TypeUUID    = uint32(4) // 16 bytes in Value.Str; see UUID
 )
 
 // Value is a single typed column value.
 type Value struct {
 	Type uint32
 	I64  int64
+	F64  float64
 	Str  []byte
 }
 
@@ -147,6 +343,57 @@ func (rec *Record) AddInt64(col string, val int64) *Record {
 	return rec
 }
 
+// AddFloat64 appends a float64-typed column to the record and returns the
+// record for chaining.
+func (rec *Record) AddFloat64(col string, val float64) *Record {
+	rec.Cols = append(rec.Cols, col)
+	rec.Vals = append(rec.Vals, Value{Type: TypeFloat64, F64: val})
+	return rec
+}
+
+// AddBytes is AddStr: it appends a bytes-typed column to the record and
+// returns the record for chaining.
+func (rec *Record) AddBytes(col string, val []byte) *Record {
+	return rec.AddStr(col, val)
+}
+
+// AddBool appends an int64-typed column holding 1 for true and 0 for false,
+// the way the engine stores flags, and returns the record for chaining.
+func (rec *Record) AddBool(col string, val bool) *Record {
+	n := int64(0)
+	if val {
+		n = 1
+	}
+	return rec.AddInt64(col, n)
+}
+
+// AddTime appends an int64-typed column holding t as Unix nanoseconds, the
+// way the engine stores times, and returns the record for chaining. Times
+// outside the years 1678 to 2262 do not fit.
+func (rec *Record) AddTime(col string, t time.Time) *Record {
+	return rec.AddInt64(col, t.UnixNano())
+}
+
+// AddNull appends a column with no value (TypeUnknown) and returns the
+// record for chaining. Tables have no NULLs: a write treats the column as
+// missing.
+func (rec *Record) AddNull(col string) *Record {
+	return rec.Set(col, Value{})
+}
+
+// Set gives the named column the value v, replacing its value if the record
+// has the column and appending it otherwise, and returns the record for
+// chaining.
+func (rec *Record) Set(col string, v Value) *Record {
+	if old := rec.Get(col); old != nil {
+		*old = v
+		return rec
+	}
+	rec.Cols = append(rec.Cols, col)
+	rec.Vals = append(rec.Vals, v)
+	return rec
+}
+
 // Get returns a pointer to the value for the named column, or nil if not
 // present.
 func (rec *Record) Get(col string) *Value {
@@ -158,6 +405,62 @@ func (rec *Record) Get(col string) *Value {
 	return nil
 }
 
+// getTyped returns the value of the named column if it has type typ.
+func (rec *Record) getTyped(col string, typ uint32) (*Value, bool) {
+	v := rec.Get(col)
+	return v, v != nil && v.Type == typ
+}
+
+// GetBytes returns the value of a bytes-typed column; ok is false if the
+// record has no such column or it has another type. The slice is not a
+// copy.
+func (rec *Record) GetBytes(col string) (val []byte, ok bool) {
+	if v, ok := rec.getTyped(col, TypeBytes); ok {
+		return v.Str, true
+	}
+	return nil, false
+}
+
+// GetInt64 returns the value of an int64-typed column; ok is false if the
+// record has no such column or it has another type.
+func (rec *Record) GetInt64(col string) (val int64, ok bool) {
+	if v, ok := rec.getTyped(col, TypeInt64); ok {
+		return v.I64, true
+	}
+	return 0, false
+}
+
+// GetFloat64 returns the value of a float64-typed column; ok is false if
+// the record has no such column or it has another type.
+func (rec *Record) GetFloat64(col string) (val float64, ok bool) {
+	if v, ok := rec.getTyped(col, TypeFloat64); ok {
+		return v.F64, true
+	}
+	return 0, false
+}
+
+// GetBool returns the value of a column set by AddBool: true for any
+// non-zero int64.
+func (rec *Record) GetBool(col string) (val bool, ok bool) {
+	n, ok := rec.GetInt64(col)
+	return n != 0, ok
+}
+
+// GetTime returns the value of a column set by AddTime.
+func (rec *Record) GetTime(col string) (val time.Time, ok bool) {
+	if n, ok := rec.GetInt64(col); ok {
+		return time.Unix(0, n), true
+	}
+	return time.Time{}, false
+}
+
+// IsNull reports whether the record has the named column with no value, as
+// AddNull adds it.
+func (rec *Record) IsNull(col string) bool {
+	_, ok := rec.getTyped(col, TypeUnknown)
+	return ok
+}
+
 // ColIndex returns the position of col in tdef.Cols, or -1 if not found.
 // Exported so the ql package can use it without reaching into table internals.
 func ColIndex(tdef *TableDef, col string) int {
@@ -192,8 +495,17 @@ var tdefTable = &TableDef{
 }
 
 var internalTables = map[string]*TableDef{
-	"@meta":  tdefMeta,
-	"@table": tdefTable,
+	"@meta":    tdefMeta,
+	"@table":   tdefTable,
+	"@user":    tdefUser,
+	"@change":  tdefChange,
+	"@ttl":     tdefTTL,
+	"@stats":   tdefStats,
+	"@version": tdefVersion,
+	"@tomb":    tdefTomb,
+	"@audit":   tdefAudit,
+	"@index":   tdefIndex,
+	"@seq":     tdefSeq,
 }
 
 // ---------------------------------------------------------------------------
@@ -206,9 +518,29 @@ func tableDefCheck(tdef *TableDef) error {
 	bad := tdef.Name == "" || len(tdef.Cols) == 0
 	bad = bad || len(tdef.Cols) != len(tdef.Types)
 	bad = bad || (1 > tdef.PKeys || tdef.PKeys > len(tdef.Cols))
+	bad = bad || tdef.TTL < 0
 	if bad {
 		return fmt.Errorf("bad table definition: %s", tdef.Name)
 	}
+	if tdef.TTL > 0 {
+		tdef.Expires = true
+	}
+	if err := checkCollations(tdef); err != nil {
+		return err
+	}
+	if err := checkCodec(tdef); err != nil {
+		return err
+	}
+	if tdef.Document {
+		if err := checkDocTable(tdef); err != nil {
+			return err
+		}
+	}
+	for col := range tdef.Desc {
+		if ColIndex(tdef, col) < 0 {
+			return fmt.Errorf("unknown descending column: %s", col)
+		}
+	}
 	for i, index := range tdef.Indexes {
 		index, err := checkIndexKeys(tdef, index)
 		if err != nil {
@@ -216,6 +548,68 @@ func tableDefCheck(tdef *TableDef) error {
 		}
 		tdef.Indexes[i] = index
 	}
+	if len(tdef.Include) > len(tdef.Indexes) {
+		return fmt.Errorf("bad table definition: %s: more Include entries than indexes", tdef.Name)
+	}
+	if len(tdef.Where) > len(tdef.Indexes) {
+		return fmt.Errorf("bad table definition: %s: more Where entries than indexes", tdef.Name)
+	}
+	if len(tdef.Unique) > len(tdef.Indexes) {
+		return fmt.Errorf("bad table definition: %s: more Unique entries than indexes", tdef.Name)
+	}
+	for i, index := range tdef.Indexes {
+		if !tdef.unique(i) {
+			continue
+		}
+		n := uniqueCols(tdef, i)
+		if n == 0 || n+tdef.PKeys != len(index) {
+			return fmt.Errorf("unique index must list its other columns before the primary key: %v", index)
+		}
+	}
+	if len(tdef.Building) > len(tdef.Indexes) {
+		return fmt.Errorf("bad table definition: %s: more Building entries than indexes", tdef.Name)
+	}
+	for _, conds := range tdef.Where {
+		if err := checkConds(tdef, conds); err != nil {
+			return err
+		}
+	}
+	for i, cols := range tdef.Include {
+		for j, c := range cols {
+			if ColIndex(tdef, c) < 0 {
+				return fmt.Errorf("unknown included column: %s", c)
+			}
+			if slices.Contains(tdef.Indexes[i], c) || slices.Contains(cols[:j], c) {
+				return fmt.Errorf("included column already in index: %s", c)
+			}
+		}
+	}
+	return nil
+}
+
+// building reports whether index i is being built.
+func (tdef *TableDef) building(i int) bool {
+	return i < len(tdef.Building) && tdef.Building[i]
+}
+
+// unique reports whether index i is unique.
+func (tdef *TableDef) unique(i int) bool {
+	return i < len(tdef.Unique) && tdef.Unique[i]
+}
+
+// uniqueCols returns the number of columns of index i before its first
+// primary-key column: for a unique index, those that must be unique.
+func uniqueCols(tdef *TableDef, i int) int {
+	return slices.IndexFunc(tdef.Indexes[i], func(c string) bool {
+		return slices.Contains(tdef.Cols[:tdef.PKeys], c)
+	})
+}
+
+// included returns the columns the entries of index i hold besides its key.
+func (tdef *TableDef) included(i int) []string {
+	if i < len(tdef.Include) {
+		return tdef.Include[i]
+	}
 	return nil
 }
 
@@ -251,12 +645,15 @@ func reorderRecord(tdef *TableDef, rec Record) ([]Value, error) {
 	out := make([]Value, len(tdef.Cols))
 	for i, c := range tdef.Cols {
 		v := rec.Get(c)
-		if v == nil {
-			continue // column absent; caller decides if that's an error
+		if v == nil || v.Type == TypeUnknown {
+			continue // column absent or null; caller decides if that's an error
 		}
 		if v.Type != tdef.Types[i] {
 			return nil, fmt.Errorf("bad column type: %s", c)
 		}
+		if v.Type == TypeUUID && len(v.Str) != len(UUID{}) {
+			return nil, fmt.Errorf("bad uuid: %s: %d bytes", c, len(v.Str))
+		}
 		out[i] = *v
 	}
 	return out, nil
@@ -279,6 +676,12 @@ func valuesComplete(tdef *TableDef, vals []Value, n int) error {
 // present. n == tdef.PKeys means "primary key only"; n == len(tdef.Cols) means
 // "all columns".
 func checkRecord(tdef *TableDef, rec Record, n int) ([]Value, error) {
+	if err := checkCollations(tdef); err != nil {
+		return nil, err
+	}
+	if err := checkCodec(tdef); err != nil {
+		return nil, err
+	}
 	vals, err := reorderRecord(tdef, rec)
 	if err != nil {
 		return nil, err
@@ -380,6 +783,13 @@ func encodeValues(out []byte, vals []Value) []byte {
 			// for signed integers.
 			binary.BigEndian.PutUint64(buf[:], uint64(v.I64)+(1<<63))
 			out = append(out, buf[:]...)
+		case TypeFloat64:
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], encodeFloat(v.F64))
+			out = append(out, buf[:]...)
+		case TypeUUID:
+			assert(len(v.Str) == len(UUID{}))
+			out = append(out, v.Str...)
 		case TypeBytes:
 			out = append(out, escapeString(v.Str)...)
 			out = append(out, 0) // null terminator
@@ -390,6 +800,33 @@ func encodeValues(out []byte, vals []Value) []byte {
 	return out
 }
 
+// encodeFloat maps f to an unsigned integer with the same order. The IEEE
+// 754 bits of a positive float already sort as unsigned integers, so setting
+// the sign bit puts them above all negatives; a negative float has all its
+// bits flipped, which reverses their order and clears the sign bit. -0 is
+// stored as +0, since the two compare equal, and every NaN as the one
+// canonical NaN, which sorts after +Inf.
+func encodeFloat(f float64) uint64 {
+	if f == 0 {
+		f = 0
+	} else if math.IsNaN(f) {
+		f = math.NaN()
+	}
+	u := math.Float64bits(f)
+	if u>>63 != 0 {
+		return ^u
+	}
+	return u | 1<<63
+}
+
+// decodeFloat inverts encodeFloat.
+func decodeFloat(u uint64) float64 {
+	if u>>63 != 0 {
+		return math.Float64frombits(u &^ (1 << 63))
+	}
+	return math.Float64frombits(^u)
+}
+
 // encodeKey prepends a 4-byte big-endian prefix to the encoded values.
 // Used for both primary keys and index keys.
 func encodeKey(out []byte, prefix uint32, vals []Value) []byte {
@@ -403,19 +840,113 @@ func encodeKey(out []byte, prefix uint32, vals []Value) []byte {
 // out[i].Type must be pre-set to the expected type before calling.
 func decodeValues(in []byte, out []Value) {
 	for i := range out {
-		switch out[i].Type {
-		case TypeInt64:
-			u := binary.BigEndian.Uint64(in[:8])
-			out[i].I64 = int64(u - (1 << 63))
-			in = in[8:]
-		case TypeBytes:
-			idx := bytes.IndexByte(in, 0)
-			assert(idx >= 0)
-			out[i].Str = unescapeString(in[:idx:idx])
-			in = in[idx+1:]
-		default:
-			panic("decodeValues: unknown type")
+		in = decodeValue(in, &out[i])
+	}
+	assert(len(in) == 0)
+}
+
+// decodeValue decodes the value at the start of in into v, whose Type must
+// be set, and returns the rest of in.
+func decodeValue(in []byte, v *Value) []byte {
+	switch v.Type {
+	case TypeInt64:
+		u := binary.BigEndian.Uint64(in[:8])
+		v.I64 = int64(u - (1 << 63))
+		return in[8:]
+	case TypeFloat64:
+		v.F64 = decodeFloat(binary.BigEndian.Uint64(in[:8]))
+		return in[8:]
+	case TypeUUID:
+		v.Str = in[:16:16]
+		return in[16:]
+	case TypeBytes:
+		idx := bytes.IndexByte(in, 0)
+		assert(idx >= 0)
+		v.Str = unescapeString(in[:idx:idx])
+		return in[idx+1:]
+	default:
+		panic("decodeValues: unknown type")
+	}
+}
+
+// encodeDesc appends the encoding of v for a descending key column: the
+// ascending one with every byte complemented, which reverses the order. A
+// string's terminator thus becomes 0xff, and a 0x00 follows it so that
+// 0xff 0xff stays above every encoded value.
+func encodeDesc(out []byte, v Value) []byte {
+	start := len(out)
+	out = encodeValues(out, []Value{v})
+	for i := start; i < len(out); i++ {
+		out[i] = ^out[i]
+	}
+	if v.Type == TypeBytes {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// decodeDesc is decodeValue for a value encoded by encodeDesc.
+func decodeDesc(in []byte, v *Value) []byte {
+	n := 8
+	if v.Type == TypeUUID {
+		n = 16
+	}
+	if v.Type == TypeBytes {
+		n = bytes.IndexByte(in, 0xff) + 1
+		assert(n > 0 && len(in) > n && in[n] == 0)
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = ^in[i]
+	}
+	rest := decodeValue(buf, v)
+	assert(len(rest) == 0)
+	if v.Type == TypeBytes {
+		n++
+	}
+	return in[n:]
+}
+
+// encodeKeyCols encodes vals, the values of the key columns cols of tdef,
+// as a key with the given prefix, following the collations and sort
+// directions of the columns.
+func encodeKeyCols(out []byte, prefix uint32, tdef *TableDef, cols []string, vals []Value) []byte {
+	if len(tdef.Desc) == 0 {
+		return encodeKey(out, prefix, keyValues(tdef, cols, vals))
+	}
+	out = encodeKey(out, prefix, nil)
+	for i := range vals {
+		for _, v := range keyValues(tdef, cols[i:i+1], vals[i:i+1]) {
+			out = encodeCol(out, tdef, cols[i], v)
+		}
+	}
+	return out
+}
+
+// encodeCol appends one encoded value of the key column col.
+func encodeCol(out []byte, tdef *TableDef, col string, v Value) []byte {
+	if tdef.Desc[col] {
+		return encodeDesc(out, v)
+	}
+	return encodeValues(out, []Value{v})
+}
+
+// decodeKey is decodeValues for the key columns cols encoded by
+// encodeKeyCols.
+func decodeKey(tdef *TableDef, cols []string, in []byte, out []Value) {
+	if len(tdef.Collate) == 0 && len(tdef.Desc) == 0 {
+		decodeValues(in, out)
+		return
+	}
+	for i := range out {
+		decode := decodeValue
+		if tdef.Desc[cols[i]] {
+			decode = decodeDesc
 		}
+		if tdef.collation(cols[i]) != nil {
+			in = decode(in, &Value{Type: TypeBytes}) // the sort key
+		}
+		in = decode(in, &out[i])
 	}
 	assert(len(in) == 0)
 }
@@ -439,17 +970,22 @@ func getTableDef(tx *DBReader, name string) *TableDef {
 	}
 
 	db := tx.db
+	version := tx.kvr.(interface{ Version() uint64 }).Version()
 	db.mu.Lock()
 	tdef, ok := db.tables[name]
+	ok = ok && db.defsCurrent(version) && !tx.ownDefs
 	db.mu.Unlock()
 
-	if !ok {
+	if ok {
+		db.stats.defHits.Add(1)
+	} else {
+		db.stats.defMisses.Add(1)
 		tdef = getTableDefFromDisk(tx, name)
 		db.mu.Lock()
 		if db.tables == nil {
 			db.tables = map[string]*TableDef{}
 		}
-		if tdef != nil {
+		if tdef != nil && db.defsCurrent(version) && !tx.ownDefs {
 			db.tables[name] = tdef
 		}
 		db.mu.Unlock()
@@ -457,6 +993,31 @@ func getTableDef(tx *DBReader, name string) *TableDef {
 	return tdef
 }
 
+// defsCurrent reports whether the definitions read at version are the
+// latest ones, which the cache holds. It runs under mu.
+func (db *DB) defsCurrent(version uint64) bool {
+	return db.defs.changing == 0 && version >= db.defs.from
+}
+
+// defsChanging is called before a commit that may change table
+// definitions, and the function it returns after it. In between, and for
+// the transactions that began before, the cache is bypassed, so that
+// nobody caches a definition the commit replaces.
+func (db *DB) defsChanging() func() {
+	db.mu.Lock()
+	db.defs.changing++
+	db.mu.Unlock()
+	return func() {
+		_, current := db.kv.Versions()
+		db.mu.Lock()
+		db.defs.changing--
+		db.defs.from = current
+		clear(db.tables)
+		clear(db.indexes)
+		db.mu.Unlock()
+	}
+}
+
 func getTableDefFromDisk(tx *DBReader, name string) *TableDef {
 	rec := (&Record{}).AddStr("name", []byte(name))
 	ok, err := dbGet(tx, tdefTable, rec)
@@ -0,0 +1,275 @@
+package tables
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Users of the network servers
+// ---------------------------------------------------------------------------
+
+// The accounts the servers authenticate clients against live in the @user
+// internal table, so they are part of the data file and its transactions.
+// Only a salted PBKDF2 hash of each password is stored. Admins may manage
+// users; every user has full access to the tables.
+
+// ErrAuth is returned by Authenticate for an unknown user or a wrong
+// password; the two are not told apart.
+var ErrAuth = errors.New("invalid user name or password")
+
+// passwordIterations is the PBKDF2-SHA256 work factor of new hashes. Stored
+// hashes record their own count, so raising it only affects new passwords.
+var passwordIterations = 600_000
+
+// User is an account as listed by Users.
+type User struct {
+	Name  string
+	Admin bool
+}
+
+// tdefUser stores one row per user: its name, password hash (see
+// hashPassword) and admin flag (0 or 1).
+var tdefUser = &TableDef{
+	Prefix: 3,
+	Name:   "@user",
+	Types:  []uint32{TypeBytes, TypeBytes, TypeInt64},
+	Cols:   []string{"name", "hash", "admin"},
+	PKeys:  1,
+}
+
+func userKey(name string) *Record {
+	return (&Record{}).AddStr("name", []byte(name))
+}
+
+func userRecord(name string, hash []byte, admin bool) Record {
+	a := int64(0)
+	if admin {
+		a = 1
+	}
+	return *userKey(name).AddStr("hash", hash).AddInt64("admin", a)
+}
+
+func getUser(tx *DBReader, name string) (*Record, bool, error) {
+	rec := userKey(name)
+	ok, err := dbGet(tx, tdefUser, rec)
+	return rec, ok, err
+}
+
+// UserNew creates a user.
+func (tx *DBTX) UserNew(name, password string, admin bool) error {
+	if name == "" || len(name) > 256 {
+		return fmt.Errorf("bad user name: %q", name)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	req := DBSetReq{Record: userRecord(name, hash, admin), Mode: btree.ModeInsertOnly}
+	if err := dbUpdate(tx, tdefUser, &req); err != nil {
+		return err
+	}
+	if !req.Added {
+		return fmt.Errorf("user exists: %s", name)
+	}
+	return nil
+}
+
+// UserSetPassword replaces the password of a user.
+func (tx *DBTX) UserSetPassword(name, password string) error {
+	rec, ok, err := getUser(&tx.DBReader, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user not found: %s", name)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return dbUpdate(tx, tdefUser, &DBSetReq{Record: userRecord(name, hash, rec.Get("admin").I64 != 0)})
+}
+
+// UserSetAdmin grants or revokes the admin flag of a user. Revoking it
+// from the last admin is refused.
+func (tx *DBTX) UserSetAdmin(name string, admin bool) error {
+	rec, ok, err := getUser(&tx.DBReader, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user not found: %s", name)
+	}
+	if !admin && rec.Get("admin").I64 != 0 {
+		if err := tx.checkOtherAdmin(name); err != nil {
+			return err
+		}
+	}
+	return dbUpdate(tx, tdefUser, &DBSetReq{Record: userRecord(name, rec.Get("hash").Str, admin)})
+}
+
+// UserDelete removes a user. Removing the last admin is refused.
+func (tx *DBTX) UserDelete(name string) error {
+	rec, ok, err := getUser(&tx.DBReader, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user not found: %s", name)
+	}
+	if rec.Get("admin").I64 != 0 {
+		if err := tx.checkOtherAdmin(name); err != nil {
+			return err
+		}
+	}
+	_, err = dbDelete(tx, tdefUser, *userKey(name))
+	return err
+}
+
+func (tx *DBTX) checkOtherAdmin(name string) error {
+	users, err := tx.Users()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.Admin && u.Name != name {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot remove the last admin: %s", name)
+}
+
+// Users lists the users in name order.
+func (tx *DBReader) Users() ([]User, error) {
+	sc := Scanner{Cmp1: btree.CmpGE}
+	if err := dbScan(tx, tdefUser, &sc); err != nil {
+		return nil, err
+	}
+	var users []User
+	var rec Record
+	for ; sc.Valid(); sc.Next() {
+		sc.Deref(&rec)
+		users = append(users, User{string(rec.Get("name").Str), rec.Get("admin").I64 != 0})
+	}
+	return users, nil
+}
+
+// Authenticate checks a user's password. It returns ErrAuth if the user
+// does not exist or the password is wrong.
+//
+// Hashing is deliberately slow, so a successful check is remembered (as a
+// digest of the password and the stored hash) until the user's hash
+// changes; clients that authenticate every request, like HTTP Basic auth,
+// pay the cost once.
+func (db *DB) Authenticate(name, password string) (User, error) {
+	r := DBReader{}
+	db.BeginRead(&r)
+	rec, ok, err := getUser(&r, name)
+	db.EndRead(&r)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		// Spend the same time as for a known user.
+		checkPassword(dummyHash, password)
+		return User{}, ErrAuth
+	}
+	user := User{name, rec.Get("admin").I64 != 0}
+	hash := rec.Get("hash").Str
+
+	digest := sha256.Sum256(append(append(bytes.Clone(hash), 0), password...))
+	db.authMu.Lock()
+	cached, hit := db.authCache[name]
+	db.authMu.Unlock()
+	if hit && subtle.ConstantTimeCompare(cached[:], digest[:]) == 1 {
+		db.stats.authHits.Add(1)
+		return user, nil
+	}
+	db.stats.authMisses.Add(1)
+	if !checkPassword(hash, password) {
+		return User{}, ErrAuth
+	}
+	db.authMu.Lock()
+	if db.authCache == nil {
+		db.authCache = map[string][sha256.Size]byte{}
+	}
+	db.authCache[name] = digest
+	db.authMu.Unlock()
+	return user, nil
+}
+
+// BootstrapAdmin creates an admin user if the database has no users yet,
+// so that a new server can be logged into. An empty password is replaced
+// by a random one. It returns the password it set, or "" if there were
+// users already.
+func (db *DB) BootstrapAdmin(name, password string) (string, error) {
+	if password == "" {
+		b := make([]byte, 18)
+		rand.Read(b)
+		password = base64.RawURLEncoding.EncodeToString(b)
+	}
+	tx := DBTX{}
+	db.Begin(&tx)
+	users, err := tx.Users()
+	if err == nil && len(users) == 0 {
+		err = tx.UserNew(name, password, true)
+	}
+	if err != nil || len(users) > 0 {
+		db.Abort(&tx)
+		return "", err
+	}
+	if err := db.Commit(&tx); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// ---------------------------------------------------------------------------
+// Password hashing
+// ---------------------------------------------------------------------------
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>", with the
+// salt and key in unpadded base64.
+func hashPassword(password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("empty password")
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Appendf(nil, "pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// dummyHash is checked against when the user does not exist.
+var dummyHash = []byte("pbkdf2-sha256$600000$AAAAAAAAAAAAAAAAAAAAAA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+
+func checkPassword(hash []byte, password string) bool {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err != nil || err1 != nil || err2 != nil || iter <= 0 || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
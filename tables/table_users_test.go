@@ -0,0 +1,115 @@
+package tables
+
+import (
+	"path/filepath"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestUsers(t *testing.T) {
+	defer func(n int) { passwordIterations = n }(passwordIterations)
+	passwordIterations = 1000
+
+	db := &DB{Path: filepath.Join(t.TempDir(), "users.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+
+	update := func(fn func(tx *DBTX) error) error {
+		tx := DBTX{}
+		db.Begin(&tx)
+		if err := fn(&tx); err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+	users := func() []User {
+		r := DBReader{}
+		db.BeginRead(&r)
+		defer db.EndRead(&r)
+		users, err := r.Users()
+		is.NoError(t, err)
+		return users
+	}
+
+	password, err := db.BootstrapAdmin("admin", "secret")
+	is.NoError(t, err)
+	is.Equal(t, "secret", password)
+	password, err = db.BootstrapAdmin("other", "")
+	is.NoError(t, err)
+	is.Empty(t, password)
+
+	u, err := db.Authenticate("admin", "secret")
+	is.NoError(t, err)
+	is.Equal(t, User{"admin", true}, u)
+	u, err = db.Authenticate("admin", "secret") // from the cache
+	is.NoError(t, err)
+	is.True(t, u.Admin)
+	_, err = db.Authenticate("admin", "wrong")
+	is.ErrorIs(t, err, ErrAuth)
+	_, err = db.Authenticate("nobody", "secret")
+	is.ErrorIs(t, err, ErrAuth)
+
+	is.NoError(t, update(func(tx *DBTX) error { return tx.UserNew("bob", "pw", false) }))
+	is.ErrorContains(t, update(func(tx *DBTX) error { return tx.UserNew("bob", "pw", false) }), "user exists")
+	is.ErrorContains(t, update(func(tx *DBTX) error { return tx.UserNew("eve", "", false) }), "empty password")
+	is.Equal(t, []User{{"admin", true}, {"bob", false}}, users())
+
+	// A new password replaces the cached one.
+	_, err = db.Authenticate("bob", "pw")
+	is.NoError(t, err)
+	is.NoError(t, update(func(tx *DBTX) error { return tx.UserSetPassword("bob", "pw2") }))
+	_, err = db.Authenticate("bob", "pw")
+	is.ErrorIs(t, err, ErrAuth)
+	_, err = db.Authenticate("bob", "pw2")
+	is.NoError(t, err)
+
+	// There is always an admin left.
+	is.ErrorContains(t, update(func(tx *DBTX) error { return tx.UserDelete("admin") }), "last admin")
+	is.ErrorContains(t, update(func(tx *DBTX) error { return tx.UserSetAdmin("admin", false) }), "last admin")
+	is.NoError(t, update(func(tx *DBTX) error { return tx.UserSetAdmin("bob", true) }))
+	is.NoError(t, update(func(tx *DBTX) error { return tx.UserDelete("admin") }))
+	is.Equal(t, []User{{"bob", true}}, users())
+	_, err = db.Authenticate("admin", "secret")
+	is.ErrorIs(t, err, ErrAuth)
+	is.ErrorContains(t, update(func(tx *DBTX) error { return tx.UserDelete("admin") }), "user not found")
+
+	// Users are not tables: they are left out of Dump.
+	r := DBReader{}
+	db.BeginRead(&r)
+	names, err := tableNames(&r)
+	db.EndRead(&r)
+	is.NoError(t, err)
+	is.Empty(t, names)
+}
+
+func TestBootstrapRandomPassword(t *testing.T) {
+	defer func(n int) { passwordIterations = n }(passwordIterations)
+	passwordIterations = 1000
+
+	db := &DB{Path: filepath.Join(t.TempDir(), "users.db")}
+	is.NoError(t, db.Open())
+	defer db.Close()
+	password, err := db.BootstrapAdmin("admin", "")
+	is.NoError(t, err)
+	is.Len(t, password, 24)
+	u, err := db.Authenticate("admin", password)
+	is.NoError(t, err)
+	is.True(t, u.Admin)
+}
+
+func TestPasswordHash(t *testing.T) {
+	defer func(n int) { passwordIterations = n }(passwordIterations)
+	passwordIterations = 1000
+
+	h1, err := hashPassword("pw")
+	is.NoError(t, err)
+	h2, err := hashPassword("pw")
+	is.NoError(t, err)
+	is.NotEqual(t, h1, h2) // salted
+	is.True(t, checkPassword(h1, "pw"))
+	is.False(t, checkPassword(h1, "pW"))
+	is.False(t, checkPassword([]byte("md5$x"), "pw"))
+	is.False(t, checkPassword(dummyHash, ""))
+}
@@ -0,0 +1,102 @@
+package tables
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestUUIDv7(t *testing.T) {
+	var ids []UUID
+	for range 1000 {
+		ids = append(ids, NewUUIDv7())
+	}
+	for i, id := range ids {
+		is.Equal(t, byte(0x70), id[6]&0xf0, "version")
+		is.Equal(t, byte(0x80), id[8]&0xc0, "variant")
+		if i > 0 {
+			is.Equal(t, 1, bytes.Compare(id[:], ids[i-1][:]))
+		}
+		parsed, err := ParseUUID(id.String())
+		is.NoError(t, err)
+		is.Equal(t, id, parsed)
+	}
+
+	id, err := ParseUUID("0190b2a4-5c1e-7a3b-8f12-3456789abcde")
+	is.NoError(t, err)
+	is.Equal(t, "0190b2a4-5c1e-7a3b-8f12-3456789abcde", id.String())
+	for _, s := range []string{"", "0190b2a45c1e7a3b8f123456789abcde", "0190b2a4-5c1e-7a3b-8f12-3456789abcdg"} {
+		_, err := ParseUUID(s)
+		is.Error(t, err)
+	}
+}
+
+func TestUUIDColumn(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "events",
+		Cols:    []string{"id", "ref", "v"},
+		Types:   []uint32{TypeUUID, TypeUUID, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"ref"}},
+		Desc:    map[string]bool{"ref": true},
+	})
+	var ids []UUID
+	for i := range int64(20) {
+		id := NewUUIDv7()
+		ids = append(ids, id)
+		is.True(t, tt.add("events", *(&Record{}).AddUUID("id", id).AddUUID("ref", id).AddInt64("v", i)))
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+	_, err := tx.Insert("events", Record{[]string{"id", "ref", "v"}, []Value{
+		{Type: TypeUUID, Str: []byte("short")}, {Type: TypeUUID, Str: ids[0][:]}, {Type: TypeInt64},
+	}})
+	is.ErrorContains(t, err, "bad uuid")
+
+	scan := func(sc Scanner) (got []int64) {
+		is.NoError(t, tx.Scan("events", &sc))
+		for rec := (Record{}); sc.Valid(); sc.Next() {
+			sc.Deref(&rec)
+			is.Equal(t, rec.Get("id").Str, rec.Get("ref").Str)
+			got = append(got, rec.Get("v").I64)
+		}
+		return got
+	}
+	// Rows come in the order their UUIDs were made; the index runs the
+	// other way.
+	is.Equal(t, []int64{5, 6, 7}, scan(Scanner{
+		Cmp1: btree.CmpGE, Cmp2: btree.CmpLE,
+		Key1: *(&Record{}).AddUUID("id", ids[5]), Key2: *(&Record{}).AddUUID("id", ids[7]),
+	}))
+	is.Equal(t, []int64{7, 6, 5}, scan(Scanner{
+		Cmp1: btree.CmpGE, Cmp2: btree.CmpLE,
+		Key1: *(&Record{}).AddUUID("ref", ids[7]), Key2: *(&Record{}).AddUUID("ref", ids[5]),
+	}))
+	is.Equal(t, []int64{18, 19}, scan(Scanner{Cmp1: btree.CmpGT, Key1: *(&Record{}).AddUUID("id", ids[17])}))
+
+	rec := (&Record{}).AddUUID("id", ids[3])
+	ok, err := tx.Get("events", rec)
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, int64(3), rec.Get("v").I64)
+}
+
+func TestConvertUUID(t *testing.T) {
+	id := NewUUIDv7()
+	v, ok := convertValue(Value{Type: TypeUUID, Str: id[:]}, TypeBytes)
+	is.True(t, ok)
+	is.Equal(t, id.String(), string(v.Str))
+	v, ok = convertValue(v, TypeUUID)
+	is.True(t, ok)
+	is.Equal(t, id[:], v.Str)
+	_, ok = convertValue(Value{Type: TypeBytes, Str: []byte("x")}, TypeUUID)
+	is.False(t, ok)
+	_, ok = convertValue(Value{Type: TypeInt64}, TypeUUID)
+	is.False(t, ok)
+}
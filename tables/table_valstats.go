@@ -0,0 +1,71 @@
+package tables
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Value-size statistics
+// ---------------------------------------------------------------------------
+
+// ValueSizeBuckets is the number of buckets in ValueStats.Sizes: enough
+// power-of-two classes to hold btree.MaxValSize.
+const ValueSizeBuckets = 13
+
+// ValueStats describes the distribution of encoded row-value sizes (the
+// non-key columns as stored in the B-tree leaf) of a table.
+//
+// Every value is stored inline in its leaf: there are no overflow pages,
+// so there is neither an overflow to report nor a threshold to configure.
+// Instead the caller picks a candidate threshold and Large counts the rows
+// above it. That is the share of a table that would
+// move out of the leaves if values above that size were stored separately,
+// and lets metadata-like and blob-like tables be told apart.
+type ValueStats struct {
+	Rows      int64
+	KeyBytes  int64 // total encoded primary-key bytes
+	ValBytes  int64 // total encoded value bytes
+	MaxVal    int   // largest value, in bytes
+	Threshold int   // the threshold Large was counted against
+	Large     int64 // rows whose value is larger than Threshold
+	LargeSize int64 // total bytes of those values
+
+	// Sizes[i] counts values whose size in bytes has bit length i, i.e.
+	// Sizes[0] counts empty values and Sizes[i] sizes in [2^(i-1), 2^i).
+	Sizes [ValueSizeBuckets]int64
+}
+
+// ValueStats scans table and returns the distribution of its value sizes,
+// counting values larger than threshold bytes as Large. A threshold <= 0
+// defaults to a quarter of btree.MaxValSize.
+func (tx *DBReader) ValueStats(table string, threshold int) (ValueStats, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return ValueStats{}, fmt.Errorf("table not found: %s", table)
+	}
+	if threshold <= 0 {
+		threshold = btree.MaxValSize / 4
+	}
+
+	st := ValueStats{Threshold: threshold}
+	sc := Scanner{Cmp1: btree.CmpGE}
+	if err := dbScan(tx, tdef, &sc); err != nil {
+		return ValueStats{}, err
+	}
+	for ; sc.Valid(); sc.Next() {
+		key, val := sc.iter.Deref()
+		st.Rows++
+		st.KeyBytes += int64(len(key))
+		st.ValBytes += int64(len(val))
+		st.MaxVal = max(st.MaxVal, len(val))
+		st.Sizes[bits.Len(uint(len(val)))]++
+		if len(val) > threshold {
+			st.Large++
+			st.LargeSize += int64(len(val))
+		}
+	}
+	return st, nil
+}
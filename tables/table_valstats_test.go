@@ -0,0 +1,52 @@
+package tables
+
+import (
+	"strings"
+	"testing"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestValueStats(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:  "blobs",
+		Cols:  []string{"id", "data"},
+		Types: []uint32{TypeInt64, TypeBytes},
+		PKeys: 1,
+	})
+	// Bytes values are encoded with a trailing terminator: n+1 bytes.
+	sizes := []int{0, 10, 10, 100, 1000, 2000}
+	for i, n := range sizes {
+		rec := (&Record{}).AddInt64("id", int64(i)).AddStr("data", []byte(strings.Repeat("x", n)))
+		tt.add("blobs", *rec)
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	defer tt.db.Abort(&tx)
+
+	st, err := tx.ValueStats("blobs", 500)
+	is.NoError(t, err)
+	is.Equal(t, int64(len(sizes)), st.Rows)
+	is.Equal(t, int64(len(sizes)*12), st.KeyBytes) // 4-byte prefix + int64
+	is.Equal(t, int64(1+11+11+101+1001+2001), st.ValBytes)
+	is.Equal(t, 2001, st.MaxVal)
+	is.Equal(t, 500, st.Threshold)
+	is.Equal(t, int64(2), st.Large)
+	is.Equal(t, int64(1001+2001), st.LargeSize)
+	is.Equal(t, int64(1), st.Sizes[1])  // 1 byte
+	is.Equal(t, int64(2), st.Sizes[4])  // 11 bytes
+	is.Equal(t, int64(1), st.Sizes[7])  // 101 bytes
+	is.Equal(t, int64(1), st.Sizes[10]) // 1001 bytes
+	is.Equal(t, int64(1), st.Sizes[11]) // 2001 bytes
+
+	st, err = tx.ValueStats("blobs", 0)
+	is.NoError(t, err)
+	is.Equal(t, 750, st.Threshold)
+	is.Equal(t, int64(2), st.Large)
+
+	_, err = tx.ValueStats("nope", 0)
+	is.Error(t, err)
+}
@@ -0,0 +1,211 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Index consistency check
+// ---------------------------------------------------------------------------
+
+// maxIndexProblems bounds IndexReport.Problems, as maxCheckProblems does
+// for btree.CheckReport.
+const maxIndexProblems = 100
+
+// Kinds of IndexProblem.
+const (
+	IndexOrphan   = "orphan"   // an entry whose row does not exist
+	IndexMissing  = "missing"  // a row without its entry
+	IndexMismatch = "mismatch" // an entry that does not match its row
+	IndexGarbled  = "garbled"  // an entry that cannot be decoded
+)
+
+// IndexProblem is one disagreement between an index and its table found by
+// CheckIndexes.
+type IndexProblem struct {
+	Table string
+	Index []string // the index columns
+	Kind  string   // IndexOrphan, IndexMissing, IndexMismatch or IndexGarbled
+	Key   Record   // the primary key of the row; empty for IndexGarbled
+}
+
+func (p IndexProblem) String() string {
+	var pk []string
+	for i, c := range p.Key.Cols {
+		pk = append(pk, c+"="+formatValue(p.Key.Vals[i]))
+	}
+	s := fmt.Sprintf("%s (%s): %s entry", p.Table, strings.Join(p.Index, ","), p.Kind)
+	if len(pk) > 0 {
+		s += " " + strings.Join(pk, ",")
+	}
+	return s
+}
+
+// formatValue formats v for a problem report.
+func formatValue(v Value) string {
+	switch v.Type {
+	case TypeInt64:
+		return fmt.Sprint(v.I64)
+	case TypeFloat64:
+		return fmt.Sprint(v.F64)
+	case TypeUUID:
+		return UUID(v.Str).String()
+	}
+	return fmt.Sprintf("%q", v.Str)
+}
+
+// IndexReport is the result of CheckIndexes.
+type IndexReport struct {
+	Tables  int   // tables checked
+	Indexes int   // indexes checked
+	Rows    int64 // rows of the tables checked
+	Entries int64 // entries of the indexes checked
+
+	Problems     []IndexProblem // the first problems found
+	MoreProblems int            // problems found beyond those in Problems
+}
+
+// OK reports whether no problem was found.
+func (r IndexReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *IndexReport) problem(p IndexProblem) {
+	if len(r.Problems) == maxIndexProblems {
+		r.MoreProblems++
+		return
+	}
+	r.Problems = append(r.Problems, p)
+}
+
+// CheckIndexes cross-checks the secondary indexes of the named tables, or
+// of every user table, against their rows in the snapshot. It walks every
+// entry of each index and looks up its row, which must exist and produce
+// the same key and included values, then walks the rows and looks up the
+// entry each one should have. Indexes being built are skipped. An error
+// means the check could not run; problems are in the report.
+func (tx *DBReader) CheckIndexes(tables ...string) (IndexReport, error) {
+	var rep IndexReport
+	if len(tables) == 0 {
+		names, err := tableNames(tx)
+		if err != nil {
+			return rep, err
+		}
+		tables = names
+	}
+	for _, name := range tables {
+		tdef := getTableDef(tx, name)
+		if tdef == nil {
+			return rep, fmt.Errorf("table not found: %s", name)
+		}
+		if err := checkTableIndexes(tx, tdef, &rep); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// CheckIndexes runs DBReader.CheckIndexes on the last commit.
+func (db *DB) CheckIndexes(tables ...string) (IndexReport, error) {
+	tx := DBReader{}
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return tx.CheckIndexes(tables...)
+}
+
+func checkTableIndexes(tx *DBReader, tdef *TableDef, rep *IndexReport) error {
+	rep.Tables++
+	var check []int
+	for i := range tdef.Indexes {
+		if !tdef.building(i) {
+			check = append(check, i)
+		}
+	}
+	rep.Indexes += len(check)
+
+	// From the entries to the rows.
+	for _, i := range check {
+		prefix := binary.BigEndian.AppendUint32(nil, tdef.IndexPrefixes[i])
+		for it := tx.kvr.Seek(prefix, btree.CmpGE); it.Valid(); it.Next() {
+			key, val := it.Deref()
+			if !bytes.HasPrefix(key, prefix) {
+				break
+			}
+			rep.Entries++
+			problem := IndexProblem{Table: tdef.Name, Index: tdef.Indexes[i]}
+			row, ok := entryRow(tdef, i, key)
+			if !ok {
+				problem.Kind = IndexGarbled
+				rep.problem(problem)
+				continue
+			}
+			problem.Key = Record{tdef.Cols[:tdef.PKeys], slices.Clone(row.Vals)}
+			found, err := dbGet(tx, tdef, &row)
+			if err != nil {
+				return err
+			}
+			if !found {
+				problem.Kind = IndexOrphan
+				rep.problem(problem)
+				continue
+			}
+			want, wantVal := indexEntry(tdef, i, row)
+			if !tdef.indexed(i, row) || !bytes.Equal(key, want) || !bytes.Equal(val, wantVal) {
+				problem.Kind = IndexMismatch
+				rep.problem(problem)
+			}
+		}
+	}
+
+	// From the rows to the entries. Expired rows keep theirs.
+	sc := Scanner{Cmp1: btree.CmpGE, raw: true}
+	if err := dbScan(tx, tdef, &sc); err != nil {
+		return err
+	}
+	for ; sc.Valid(); sc.Next() {
+		var row Record
+		sc.Deref(&row)
+		rep.Rows++
+		for _, i := range check {
+			if !tdef.indexed(i, row) {
+				continue
+			}
+			key, _ := indexEntry(tdef, i, row)
+			if _, ok := tx.kvr.Get(key); !ok {
+				rep.problem(IndexProblem{
+					Table: tdef.Name, Index: tdef.Indexes[i], Kind: IndexMissing,
+					Key: Record{tdef.Cols[:tdef.PKeys], row.Vals[:tdef.PKeys]},
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// entryRow decodes the key of an entry of index i into a record holding the
+// primary key of its row. ok is false if the key cannot be decoded.
+func entryRow(tdef *TableDef, i int, key []byte) (row Record, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	index := tdef.Indexes[i]
+	ival := make([]Value, len(index))
+	for j, c := range index {
+		ival[j].Type = tdef.Types[ColIndex(tdef, c)]
+	}
+	decodeKey(tdef, index, key[4:], ival)
+	icol := Record{index, ival}
+	for _, c := range tdef.Cols[:tdef.PKeys] {
+		row.Cols = append(row.Cols, c)
+		row.Vals = append(row.Vals, *icol.Get(c))
+	}
+	return row, true
+}
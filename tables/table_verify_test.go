@@ -0,0 +1,78 @@
+package tables
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/btree"
+	is "github.com/stretchr/testify/require"
+)
+
+func TestCheckIndexes(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "tag", "v", "w"},
+		Types:   []uint32{TypeInt64, TypeBytes, TypeInt64, TypeInt64},
+		PKeys:   1,
+		Indexes: [][]string{{"tag"}, {"v"}},
+		Include: [][]string{{"w"}},
+		Where:   [][]Cond{nil, {{Col: "v", Cmp: ">", Val: Value{Type: TypeInt64, I64: 2}}}},
+	})
+	tt.create(&TableDef{
+		Name: "plain", Cols: []string{"id", "v"}, Types: []uint32{TypeInt64, TypeInt64}, PKeys: 1,
+	})
+	for i := range 20 {
+		tt.add("t", *(&Record{}).AddInt64("id", int64(i)).AddStr("tag", fmt.Appendf(nil, "t%d", i%3)).AddInt64("v", int64(i%5)).AddInt64("w", 0))
+	}
+
+	rep, err := tt.db.CheckIndexes()
+	is.NoError(t, err)
+	is.True(t, rep.OK())
+	is.Equal(t, IndexReport{Tables: 2, Indexes: 2, Rows: 20, Entries: 20 + 8}, rep)
+
+	// Damage the indexes behind the table layer's back.
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	tdef := tx.TableDef("t")
+	row := func(id int64, tag string, v, w int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("tag", []byte(tag)).AddInt64("v", v).AddInt64("w", w)
+	}
+	key, _ := indexEntry(tdef, 0, row(4, "t1", 4, 0))
+	is.True(t, tx.kvw.Del(&btree.DeleteReq{Key: key})) // missing
+	key, val := indexEntry(tdef, 0, row(99, "t0", 0, 0))
+	is.True(t, tx.kvw.Update(&btree.InsertReq{Key: key, Val: val})) // orphan
+	key, _ = indexEntry(tdef, 0, row(3, "zz", 0, 0))
+	is.True(t, tx.kvw.Update(&btree.InsertReq{Key: key})) // mismatch: wrong key
+	key, val = indexEntry(tdef, 0, row(6, "t0", 1, 7))
+	tx.kvw.Update(&btree.InsertReq{Key: key, Val: val, Mode: btree.ModeUpsert}) // mismatch: included value
+	key, _ = indexEntry(tdef, 1, row(5, "t2", 0, 0))
+	is.True(t, tx.kvw.Update(&btree.InsertReq{Key: key}))                                         // mismatch: outside the predicate
+	is.True(t, tx.kvw.Update(&btree.InsertReq{Key: append(prefixKey(tdef.IndexPrefixes[1]), 1)})) // garbled
+	is.NoError(t, tt.db.Commit(&tx))
+
+	rep, err = tt.db.CheckIndexes("t")
+	is.NoError(t, err)
+	is.False(t, rep.OK())
+	var got []string
+	for _, p := range rep.Problems {
+		got = append(got, p.String())
+	}
+	is.Equal(t, []string{
+		"t (tag,id): mismatch entry id=6",
+		"t (tag,id): orphan entry id=99",
+		"t (tag,id): mismatch entry id=3",
+		"t (v,id): garbled entry",
+		"t (v,id): mismatch entry id=5",
+		"t (tag,id): missing entry id=4",
+	}, got)
+
+	_, err = tt.db.CheckIndexes("nope")
+	is.Error(t, err)
+	is.NoError(t, tt.db.IndexRebuild("t", []string{"tag"}))
+	is.NoError(t, tt.db.IndexRebuild("t", []string{"v"}))
+	rep, err = tt.db.CheckIndexes()
+	is.NoError(t, err)
+	is.True(t, rep.OK(), "%v", rep.Problems)
+}
@@ -0,0 +1,128 @@
+package tables
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/MHS-20/ElkDB/btree"
+)
+
+// ---------------------------------------------------------------------------
+// Row versions
+// ---------------------------------------------------------------------------
+
+// The rows of a table created with TableDef.Versioned carry a version: 1
+// when inserted, one more after each write that changes them. Versions live
+// in the @version internal table, keyed by table and encoded primary key,
+// so the rows themselves and their encoding are unchanged. An application
+// reads a row with its version (DBReader.RowVersion), and writes it back
+// with DBTX.UpdateIfVersion, which fails if another writer changed the row
+// in between. This protects against lost updates across transactions, such
+// as a form that is read, edited by a user, and saved.
+
+// ErrVersionMismatch is returned (wrapped) by UpdateIfVersion when the row
+// is not at the version the caller expects.
+var ErrVersionMismatch = errors.New("row version mismatch")
+
+// tdefVersion stores the version of every row of a versioned table.
+var tdefVersion = &TableDef{
+	Prefix: 8,
+	Name:   "@version",
+	Types:  []uint32{TypeBytes, TypeBytes, TypeInt64},
+	Cols:   []string{"table", "key", "version"},
+	PKeys:  2,
+}
+
+func versionKey(tdef *TableDef, pk []Value) *Record {
+	key := encodeKeyCols(nil, tdef.Prefix, tdef, tdef.Cols[:tdef.PKeys], pk)
+	return (&Record{}).AddStr("table", []byte(tdef.Name)).AddStr("key", key)
+}
+
+// rowVersion returns the version of the row with primary key pk, or 0 if it
+// has none.
+func rowVersion(tx *DBReader, tdef *TableDef, pk []Value) int64 {
+	rec := versionKey(tdef, pk)
+	ok, err := dbGet(tx, tdefVersion, rec)
+	assert(err == nil)
+	if !ok {
+		return 0
+	}
+	return rec.Get("version").I64
+}
+
+// bumpVersion advances the version of a row that was just written: to 1 if
+// it was added, else by one.
+func bumpVersion(tx *DBTX, tdef *TableDef, pk []Value, added bool) error {
+	version := int64(1)
+	if !added {
+		version = rowVersion(&tx.DBReader, tdef, pk) + 1
+	}
+	rec := versionKey(tdef, pk).AddInt64("version", version)
+	return dbUpdate(tx, tdefVersion, &DBSetReq{Record: *rec})
+}
+
+// dropVersion removes the version of a deleted row.
+func dropVersion(tx *DBTX, tdef *TableDef, pk []Value) error {
+	_, err := dbDelete(tx, tdefVersion, *versionKey(tdef, pk))
+	return err
+}
+
+// versionedDef returns the definition of table, a table with Versioned set.
+func versionedDef(tx *DBReader, table string) (*TableDef, error) {
+	tdef := getTableDef(tx, table)
+	if tdef == nil {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	if !tdef.Versioned {
+		return nil, fmt.Errorf("table is not versioned: %s", table)
+	}
+	return tdef, nil
+}
+
+// RowVersion returns the version of a row of table, a table with Versioned
+// set. rec holds the primary key. Returns false if the row does not exist,
+// has expired or was soft-deleted.
+func (tx *DBReader) RowVersion(table string, rec Record) (int64, bool, error) {
+	tdef, err := versionedDef(tx, table)
+	if err != nil {
+		return 0, false, err
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return 0, false, err
+	}
+	pk := values[:tdef.PKeys]
+	if ok, err := liveRow(tx, tdef, pk); !ok || err != nil {
+		return 0, false, err
+	}
+	return rowVersion(tx, tdef, pk), true, nil
+}
+
+// UpdateIfVersion is Update for a row of a versioned table that must still
+// be at version expected: if another write changed the row since the caller
+// read that version, it fails with ErrVersionMismatch and writes nothing.
+// It returns the version of the row after the write, which is unchanged if
+// rec holds the values the row had. Returns 0 and no error if the row does
+// not exist.
+func (tx *DBTX) UpdateIfVersion(table string, rec Record, expected int64) (int64, error) {
+	tdef, err := versionedDef(&tx.DBReader, table)
+	if err != nil {
+		return 0, err
+	}
+	values, err := checkRecord(tdef, rec, len(tdef.Cols))
+	if err != nil {
+		return 0, err
+	}
+	pk := values[:tdef.PKeys]
+	version, ok, err := tx.RowVersion(table, Record{tdef.Cols[:tdef.PKeys], pk})
+	if !ok || err != nil {
+		return 0, err
+	}
+	if version != expected {
+		return 0, fmt.Errorf("%w: %s is at version %d, not %d", ErrVersionMismatch, table, version, expected)
+	}
+	if err := tx.Set(table, &DBSetReq{Record: rec, Mode: btree.ModeUpdateOnly}); err != nil {
+		return 0, err
+	}
+	return rowVersion(&tx.DBReader, tdef, pk), nil
+}
@@ -0,0 +1,87 @@
+package tables
+
+import (
+	"testing"
+	"time"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestRowVersion(t *testing.T) {
+	tt := newTableTester()
+	defer tt.dispose()
+	tt.create(&TableDef{
+		Name:      "t",
+		Cols:      []string{"id", "v"},
+		Types:     []uint32{TypeInt64, TypeBytes},
+		PKeys:     1,
+		Versioned: true,
+		Expires:   true,
+	})
+	tt.create(&TableDef{Name: "plain", Cols: []string{"id", "v"}, Types: []uint32{TypeInt64, TypeBytes}, PKeys: 1})
+	row := func(id int64, v string) Record { return *(&Record{}).AddInt64("id", id).AddStr("v", []byte(v)) }
+	pk := func(id int64) Record { return *(&Record{}).AddInt64("id", id) }
+	version := func(tx *DBReader, id int64) int64 {
+		v, ok, err := tx.RowVersion("t", pk(id))
+		is.NoError(t, err)
+		if !ok {
+			return 0
+		}
+		return v
+	}
+
+	tx := DBTX{}
+	tt.db.Begin(&tx)
+	_, err := tx.Insert("t", row(1, "a"))
+	is.NoError(t, err)
+	is.Equal(t, int64(1), version(&tx.DBReader, 1))
+	_, err = tx.Upsert("t", row(1, "b"))
+	is.NoError(t, err)
+	is.Equal(t, int64(2), version(&tx.DBReader, 1))
+	_, err = tx.Upsert("t", row(1, "b")) // unchanged
+	is.NoError(t, err)
+	is.Equal(t, int64(2), version(&tx.DBReader, 1))
+	is.NoError(t, tt.db.Commit(&tx))
+
+	// Two writers read version 2; the second to save loses.
+	tt.db.Begin(&tx)
+	v, err := tx.UpdateIfVersion("t", row(1, "c"), 2)
+	is.NoError(t, err)
+	is.Equal(t, int64(3), v)
+	is.NoError(t, tt.db.Commit(&tx))
+	tt.db.Begin(&tx)
+	_, err = tx.UpdateIfVersion("t", row(1, "d"), 2)
+	is.ErrorIs(t, err, ErrVersionMismatch)
+	got := pk(1)
+	ok, err := tx.Get("t", &got)
+	is.NoError(t, err)
+	is.True(t, ok)
+	is.Equal(t, "c", string(got.Get("v").Str))
+	v, err = tx.UpdateIfVersion("t", row(9, "x"), 1) // no such row
+	is.NoError(t, err)
+	is.Zero(t, v)
+
+	// A deleted row starts again at 1.
+	_, err = tx.Delete("t", pk(1))
+	is.NoError(t, err)
+	is.Zero(t, version(&tx.DBReader, 1))
+	_, err = tx.Insert("t", row(1, "e"))
+	is.NoError(t, err)
+	is.Equal(t, int64(1), version(&tx.DBReader, 1))
+
+	// So does an expired one, written again.
+	_, err = tx.ExpireAt("t", pk(1), time.Unix(1, 0))
+	is.NoError(t, err)
+	is.Zero(t, version(&tx.DBReader, 1))
+	_, err = tx.Upsert("t", row(1, "f"))
+	is.NoError(t, err)
+	is.Equal(t, int64(1), version(&tx.DBReader, 1))
+
+	_, _, err = tx.RowVersion("plain", pk(1))
+	is.Error(t, err)
+	_, err = tx.UpdateIfVersion("plain", row(1, "a"), 1)
+	is.Error(t, err)
+	_, err = tx.UpdateIfVersion("t", pk(1), 1) // incomplete row
+	is.Error(t, err)
+	is.NoError(t, tt.db.Commit(&tx))
+}
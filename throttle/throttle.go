@@ -0,0 +1,121 @@
+// Package throttle paces background work (backfills, archiving, dumps...)
+// to a byte rate and an operation rate, so that maintenance can run next to
+// a production workload without starving it of I/O.
+//
+// A Limiter is shared by every job it should pace: their costs add up
+// against the same budget. Limits can be changed while jobs are running.
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter paces work to at most BytesPerSec and OpsPerSec on average. A zero
+// limit is unlimited, and so is a nil *Limiter: every method is safe to call
+// on nil, which lets callers hold an optional limiter without checking it.
+type Limiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	opsPerSec   int64
+	next        time.Time // when the work reserved so far is paid for
+	stats       Stats
+}
+
+// Stats is the state and running totals of a Limiter.
+type Stats struct {
+	BytesPerSec int64         // current limits (0 = unlimited)
+	OpsPerSec   int64         //
+	Bytes       int64         // work charged so far
+	Ops         int64         //
+	Throttled   time.Duration // total time callers were made to wait
+	Waiting     int           // callers waiting right now
+}
+
+// New returns a Limiter with the given limits.
+func New(bytesPerSec, opsPerSec int64) *Limiter {
+	l := &Limiter{}
+	l.SetLimits(bytesPerSec, opsPerSec)
+	return l
+}
+
+// SetLimits changes the limits. Callers already waiting finish their wait;
+// work charged after the change is paced by the new limits only.
+func (l *Limiter) SetLimits(bytesPerSec, opsPerSec int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.bytesPerSec, l.opsPerSec = max(bytesPerSec, 0), max(opsPerSec, 0)
+	l.next = time.Time{}
+	l.mu.Unlock()
+}
+
+// Wait charges ops operations moving bytes bytes and sleeps as long as
+// needed to keep the average rates within the limits.
+func (l *Limiter) Wait(ops int, bytes int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.stats.Ops += int64(ops)
+	l.stats.Bytes += bytes
+	var cost time.Duration
+	if l.bytesPerSec > 0 {
+		cost = time.Duration(bytes) * time.Second / time.Duration(l.bytesPerSec)
+	}
+	if l.opsPerSec > 0 {
+		cost = max(cost, time.Duration(ops)*time.Second/time.Duration(l.opsPerSec))
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(cost)
+	d := l.next.Sub(now)
+	if d <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	l.stats.Throttled += d
+	l.stats.Waiting++
+	l.mu.Unlock()
+
+	time.Sleep(d)
+
+	l.mu.Lock()
+	l.stats.Waiting--
+	l.mu.Unlock()
+}
+
+// Stats returns the current limits and totals.
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.stats
+	st.BytesPerSec, st.OpsPerSec = l.bytesPerSec, l.opsPerSec
+	return st
+}
+
+// Writer returns w paced by l: each Write is charged as one operation of
+// len(p) bytes. With a nil l it returns w itself.
+func (l *Limiter) Writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return writer{l, w}
+}
+
+type writer struct {
+	l *Limiter
+	w io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	w.l.Wait(1, int64(len(p)))
+	return w.w.Write(p)
+}
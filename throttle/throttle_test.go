@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	is "github.com/stretchr/testify/require"
+)
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	l.Wait(1000, 1<<30)
+	l.SetLimits(1, 1)
+	is.Equal(t, Stats{}, l.Stats())
+	var buf bytes.Buffer
+	is.Equal(t, &buf, l.Writer(&buf))
+}
+
+func TestUnlimited(t *testing.T) {
+	l := &Limiter{}
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		l.Wait(1, 1<<20)
+	}
+	is.Less(t, time.Since(start), time.Second)
+	st := l.Stats()
+	is.Equal(t, int64(1000), st.Ops)
+	is.Equal(t, int64(1000<<20), st.Bytes)
+	is.Zero(t, st.Throttled)
+}
+
+func TestBytesLimit(t *testing.T) {
+	l := New(10_000, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Wait(1, 400) // 40ms each
+	}
+	is.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	st := l.Stats()
+	is.Equal(t, int64(10_000), st.BytesPerSec)
+	is.Equal(t, int64(2000), st.Bytes)
+	is.Greater(t, st.Throttled, time.Duration(0))
+}
+
+func TestOpsLimitShared(t *testing.T) {
+	// Concurrent callers share one budget.
+	l := New(0, 200)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				l.Wait(1, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	is.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond) // 40 ops at 200/s
+	is.Equal(t, int64(40), l.Stats().Ops)
+}
+
+func TestWaitingAndWriter(t *testing.T) {
+	l := New(1000, 0)
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := l.Writer(&buf).Write(make([]byte, 200)) // 200ms
+		is.NoError(t, err)
+	}()
+	is.Eventually(t, func() bool { return l.Stats().Waiting == 1 }, time.Second, time.Millisecond)
+	<-done
+	is.Equal(t, 200, buf.Len())
+	st := l.Stats()
+	is.Zero(t, st.Waiting)
+	is.Equal(t, int64(1), st.Ops)
+
+	// Lifting the limits stops further throttling.
+	l.SetLimits(0, 0)
+	start := time.Now()
+	l.Wait(1, 1<<30)
+	is.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,101 @@
+// Package tlsconfig builds the TLS configurations of the ElkDB servers
+// (ElkWire, REST, RESP), their clients and the nodes of a cluster from PEM
+// files, so that every binary takes the same -tls-* flags and applies the
+// same defaults.
+//
+// A server needs a certificate and its key. Giving it a client CA as well
+// turns on client-certificate authentication: only clients presenting a
+// certificate signed by that CA can connect. The nodes of a cluster always
+// authenticate each other that way (see Peer).
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// MinVersion is the oldest protocol version servers and clients accept.
+const MinVersion = tls.VersionTLS12
+
+// Server returns the configuration of a server presenting the certificate
+// in certFile with the key in keyFile. If clientCAFile is not empty, clients
+// must present a certificate signed by one of the CAs it contains.
+func Server(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls: a server needs both a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   MinVersion,
+	}
+	if clientCAFile != "" {
+		pool, err := loadPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// Client returns the configuration of a client that verifies the server
+// against the CAs in caFile, or against the system roots if caFile is empty.
+// If certFile and keyFile are set, the client presents that certificate to
+// servers that require one.
+func Client(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: MinVersion}
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("tls: a client certificate needs both a certificate and a key")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Peer returns the configuration of a cluster node, which both accepts
+// connections from the other nodes and makes connections to them. It
+// presents the certificate in certFile with the key in keyFile either way,
+// and only talks to nodes whose certificate is signed by a CA in caFile.
+func Peer(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, errors.New("tls: a cluster node needs the CA of the other nodes")
+	}
+	cfg, err := Server(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootCAs = cfg.ClientCAs
+	return cfg, nil
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", file)
+	}
+	return pool, nil
+}
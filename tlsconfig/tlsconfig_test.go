@@ -0,0 +1,109 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/MHS-20/ElkDB/internal/testcert"
+	is "github.com/stretchr/testify/require"
+)
+
+// handshake accepts one connection with server and dials it with client,
+// echoing a byte to complete the handshake on both sides.
+func handshake(t *testing.T, server, client *tls.Config) error {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	is.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{1}); err != nil {
+		return err
+	}
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+func TestHandshake(t *testing.T) {
+	cert, key := testcert.Write(t, "server")
+	clientCert, clientKey := testcert.Write(t, "client")
+	other, _ := testcert.Write(t, "other")
+
+	srv, err := Server(cert, key, "")
+	is.NoError(t, err)
+	is.Equal(t, tls.NoClientCert, srv.ClientAuth)
+	cli, err := Client(cert, "", "")
+	is.NoError(t, err)
+	is.NoError(t, handshake(t, srv, cli))
+
+	// The server is verified against the CA file.
+	cli, err = Client(other, "", "")
+	is.NoError(t, err)
+	is.Error(t, handshake(t, srv, cli))
+
+	// With a client CA, only clients with a certificate it signed get in.
+	srv, err = Server(cert, key, clientCert)
+	is.NoError(t, err)
+	cli, err = Client(cert, clientCert, clientKey)
+	is.NoError(t, err)
+	is.NoError(t, handshake(t, srv, cli))
+	cli, err = Client(cert, "", "")
+	is.NoError(t, err)
+	is.Error(t, handshake(t, srv, cli))
+	otherCert, otherKey := testcert.Write(t, "stranger")
+	cli, err = Client(cert, otherCert, otherKey)
+	is.NoError(t, err)
+	is.Error(t, handshake(t, srv, cli))
+}
+
+func TestPeer(t *testing.T) {
+	cert, key := testcert.Write(t, "node")
+	other, otherKey := testcert.Write(t, "other")
+
+	// A node both accepts and dials the others, with the same config.
+	cfg, err := Peer(cert, key, cert)
+	is.NoError(t, err)
+	is.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	is.NoError(t, handshake(t, cfg, cfg))
+
+	// A node with a certificate from another CA is refused either way.
+	stranger, err := Peer(other, otherKey, cert)
+	is.NoError(t, err)
+	is.Error(t, handshake(t, cfg, stranger))
+	is.Error(t, handshake(t, stranger, cfg))
+
+	_, err = Peer(cert, key, "")
+	is.ErrorContains(t, err, "needs the CA")
+}
+
+func TestConfigErrors(t *testing.T) {
+	cert, key := testcert.Write(t, "server")
+	_, err := Server(cert, "", "")
+	is.Error(t, err)
+	_, err = Server(cert, key, filepath.Join(t.TempDir(), "missing.crt"))
+	is.Error(t, err)
+	_, err = Server(cert, key, key) // a key is not a CA certificate
+	is.ErrorContains(t, err, "no certificates found")
+	_, err = Client("", cert, "")
+	is.Error(t, err)
+	_, err = Client(cert, key, cert)
+	is.Error(t, err)
+
+	cfg, err := Client("", "", "")
+	is.NoError(t, err)
+	is.Nil(t, cfg.RootCAs) // the system roots
+	is.Equal(t, uint16(MinVersion), cfg.MinVersion)
+}